@@ -0,0 +1,690 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// StatusResponse is a response body template for the admin status route,
+// reporting this node's view of raft state (useful for monitoring how far
+// behind the commit index the database is on each node)
+type StatusResponse struct {
+	Id           string `json:"id"`
+	State        string `json:"state"`
+	Witness      bool   `json:"witness"`
+	Term         int64  `json:"term"`
+	Leader       string `json:"leader"`
+	LastLogIndex int64  `json:"lastLogIndex"`
+	CommitIndex  int64  `json:"commitIndex"`
+	LastApplied  int64  `json:"lastApplied"`
+	CaughtUp     bool   `json:"caughtUp"`
+	// Whether this node is quarantined (see /admin/reseed), and why
+	Quarantined      bool   `json:"quarantined"`
+	QuarantineReason string `json:"quarantineReason,omitempty"`
+	// Whether this node has disabled lease reads because a peer's clock is
+	// skewed (see clockOffsetMillis)
+	LeaseReadsDisabled bool `json:"leaseReadsDisabled,omitempty"`
+	// How far this node is behind, and whether it is shedding low-priority
+	// requests because of it
+	Backlog node.Backlog `json:"backlog"`
+	Version string       `json:"version"`
+	// Number of keys in the database (including expired keys that have not
+	// been removed yet)
+	Keys int `json:"keys"`
+	// Total size of the files in the data directory
+	DiskBytes int64 `json:"diskBytes"`
+	// Reachability of each other member, and replication progress (only
+	// reported by the leader)
+	Peers []PeerResponse `json:"peers,omitempty"`
+}
+
+// PeerResponse reports this node's view of another member of the cluster. On
+// the leader, it includes how many entries behind the leader's log it is
+type PeerResponse struct {
+	Id        string `json:"id"`
+	Available bool   `json:"available"`
+	// Whether the member is being bootstrapped, so does not count toward the
+	// majority
+	Learner bool `json:"learner,omitempty"`
+	// Round-trip time of the last successful ping, in milliseconds
+	RTTMillis  float64 `json:"rttMillis"`
+	MatchIndex *int64  `json:"matchIndex,omitempty"`
+	Lag        *int64  `json:"lag,omitempty"`
+	// Until when this node has isolated the member (absent if it has not)
+	IsolatedUntil *time.Time `json:"isolatedUntil,omitempty"`
+	// Estimated offset of the member's clock from this node's, in
+	// milliseconds (positive if the member's is ahead; only reported by the
+	// leader)
+	ClockOffsetMillis *float64 `json:"clockOffsetMillis,omitempty"`
+	// Progress and version reported by the member in its last reply to this
+	// node (absent until it has replied)
+	CommitIndex *int64 `json:"commitIndex,omitempty"`
+	LastApplied *int64 `json:"lastApplied,omitempty"`
+	Version     string `json:"version,omitempty"`
+}
+
+// Handler for the admin status endpoint
+// @Summary Return raft status of this node
+// @ID admin-status
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} StatusResponse
+// @Router /admin/status [get]
+func (ctl *Controller) handleStatus(c *gin.Context) {
+	n := ctl.Node
+	reason := n.QuarantineReason()
+	state, term := n.CurrentRole()
+	status := StatusResponse{
+		Id:                 n.RaftNode.Id,
+		State:              string(state),
+		Witness:            n.IsWitness(),
+		Term:               term,
+		Leader:             n.RedirectLeader(),
+		LastLogIndex:       n.LastLogIndex(),
+		CommitIndex:        n.Committed(),
+		LastApplied:        n.Applied(),
+		CaughtUp:           n.CaughtUp(),
+		Quarantined:        reason != "",
+		QuarantineReason:   reason,
+		LeaseReadsDisabled: !n.LeaseReadsAllowed(),
+		Backlog:            n.Backlog(),
+		Version:            LeifDBVersion,
+		Keys:               n.Store.Len()}
+	if size, err := n.DiskUsage(); err != nil {
+		logger.Warn().Err(err).Msg("Error measuring data directory")
+	} else {
+		status.DiskBytes = size
+	}
+	status.Peers = ctl.peerResponses()
+	c.JSON(http.StatusOK, status)
+}
+
+// peerResponses reports this node's view of each other member, sorted by ID
+func (ctl *Controller) peerResponses() []PeerResponse {
+	n := ctl.Node
+	lastLogIndex := n.LastLogIndex()
+	leader := n.IsLeader()
+	peers := []PeerResponse{}
+	for _, peer := range n.Peers() {
+		response := PeerResponse{
+			Id:            peer.Id,
+			Available:     peer.Available,
+			Learner:       peer.Learner,
+			RTTMillis:     peer.RTT.Seconds() * 1000,
+			CommitIndex:   peer.CommitIndex,
+			LastApplied:   peer.LastApplied,
+			Version:       peer.Version,
+			IsolatedUntil: peer.IsolatedUntil}
+		if peer.ClockOffset != nil {
+			offset := peer.ClockOffset.Seconds() * 1000
+			response.ClockOffsetMillis = &offset
+		}
+		if leader {
+			matchIndex := peer.MatchIndex
+			lag := lastLogIndex - peer.MatchIndex
+			response.MatchIndex, response.Lag = &matchIndex, &lag
+		}
+		peers = append(peers, response)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Id < peers[j].Id })
+	return peers
+}
+
+// MembersResponse lists the other members of the cluster, by ID
+type MembersResponse struct {
+	Members []PeerResponse `json:"members"`
+	// ID of the last member listed, to pass as `after` for the next page
+	// (absent on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// Handler for listing members
+// @Summary Return this node's view of each other member of the cluster
+// @Description Lists what /admin/status reports under peers, a page at a
+// @Description time: members are sorted by ID.
+// @ID admin-members
+// @Accept */*
+// @Produce application/json
+// @Param limit query int false "Most members to return (default all)"
+// @Param after query string false "Return the members after this ID (next of the previous page)"
+// @Param fields query string false "Comma-separated fields to return of each member (default all)"
+// @Success 200 {object} MembersResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/members [get]
+func (ctl *Controller) handleMembers(c *gin.Context) {
+	q, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	peers := ctl.peerResponses()
+	start, end, next := q.page(len(peers), func(i int) string { return peers[i].Id })
+	respondList(c, q, "members", MembersResponse{Members: peers[start:end], Next: next})
+}
+
+// Handler for the admin disk endpoint
+// @Summary Return the files in this node's data directory, and free space
+// @Description Lists each file in the data directory with its size and
+// @Description component (log, snapshots, or other), totals for each
+// @Description component, and the free and total size of the volume holding
+// @Description the directory (where it can be measured).
+// @ID admin-disk
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} mgmt.DiskUsage
+// @Failure 500 {object} ErrorResponse "Error message"
+// @Router /admin/disk [get]
+func (ctl *Controller) handleDisk(c *gin.Context) {
+	usage, err := ctl.snapshots.DiskUsage()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// Handler for reading the log configuration
+// @Summary Return the log level of each subsystem, and its sampling rate
+// @ID admin-log
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} logging.Config
+// @Router /admin/log [get]
+func (ctl *Controller) handleLogConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.Current())
+}
+
+// Handler for changing the log configuration
+// @Summary Change the default log level, or the level and sampling of subsystems
+// @Description Subsystems not in the request are left as they are. A subsystem
+// @Description with an empty level uses the default level. The change lasts
+// @Description until the node restarts.
+// @ID admin-log-set
+// @Accept application/json
+// @Produce application/json
+// @Param config body logging.Config true "Log configuration"
+// @Success 200 {object} logging.Config
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/log [put]
+func (ctl *Controller) handleSetLogConfig(c *gin.Context) {
+	var config logging.Config
+	if err := c.ShouldBindJSON(&config); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := logging.Configure(config); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	logger.Info().Interface("config", config).Msg("Log configuration changed")
+	c.JSON(http.StatusOK, logging.Current())
+}
+
+// Handler for reading the proposal batching limits
+// @Summary Return how writes proposed at about the same time are batched
+// @Description Sets and deletes are appended to the log in batches of up to
+// @Description maxEntries writes and maxBytes bytes, the first waiting up to
+// @Description maxDelayMicros for others to join. The sizes of the batches
+// @Description appended are reported by the leifdb_proposal_batch_size metric.
+// @ID admin-batching
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} node.ProposalBatching
+// @Router /admin/batching [get]
+func (ctl *Controller) handleBatching(c *gin.Context) {
+	c.JSON(http.StatusOK, ctl.Node.ProposalBatching())
+}
+
+// Handler for changing the proposal batching limits
+// @Summary Change how writes proposed at about the same time are batched
+// @Description The new limits apply to batches opened from now on. A
+// @Description maxEntries of 1 or less disables batching, and a maxBytes of 0
+// @Description means unlimited. The change lasts until the node restarts.
+// @ID admin-batching-set
+// @Accept application/json
+// @Produce application/json
+// @Param batching body node.ProposalBatching true "Batching limits"
+// @Success 200 {object} node.ProposalBatching
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/batching [put]
+func (ctl *Controller) handleSetBatching(c *gin.Context) {
+	var batching node.ProposalBatching
+	if err := c.ShouldBindJSON(&batching); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := ctl.Node.SetProposalBatching(batching); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.Node.ProposalBatching())
+}
+
+// EventsResponse is a response body template for the admin events route
+type EventsResponse struct {
+	Events []node.Event `json:"events"`
+}
+
+// Handler for the admin events endpoint
+// @Summary Return recent cluster events observed by this node, oldest first
+// @ID admin-events
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} EventsResponse
+// @Router /admin/events [get]
+func (ctl *Controller) handleEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, EventsResponse{Events: ctl.events.recent()})
+}
+
+// EntriesResponse is a response body template for the admin entries route
+type EntriesResponse struct {
+	Entries []node.EntryInfo `json:"entries"`
+	// Index of the last entry appended at or before `to`, if it is still in
+	// the log (the point to restore to for the state as of that time)
+	IndexAtEnd *int64 `json:"indexAtEnd,omitempty"`
+}
+
+// parseTimeParam parses an RFC 3339 time from the named query parameter, or
+// returns def if it is not set
+func parseTimeParam(c *gin.Context, name string, def time.Time) (time.Time, error) {
+	s := c.Query(name)
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Handler for listing log entries by time
+// @Summary Return the log entries appended between two times, oldest first
+// @Description Only entries that have not been compacted into a snapshot are
+// @Description listed, without their values. Times are RFC 3339.
+// @ID admin-entries
+// @Accept */*
+// @Produce application/json
+// @Param from query string false "Start of the range, inclusive (default: the oldest entry)"
+// @Param to query string false "End of the range, exclusive (default: now)"
+// @Param limit query int false "Maximum number of entries (default 100, 0 for no limit)"
+// @Success 200 {object} EntriesResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/entries [get]
+func (ctl *Controller) handleEntries(c *gin.Context) {
+	from, err := parseTimeParam(c, "from", time.Time{})
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	to, err := parseTimeParam(c, "to", time.Now())
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+
+	response := EntriesResponse{Entries: ctl.Node.EntriesBetween(from, to, limit)}
+	if index, ok := ctl.Node.IndexAt(to.Add(-time.Nanosecond)); ok {
+		response.IndexAtEnd = &index
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SampleResponse is a response body template for the key sampling route
+type SampleResponse struct {
+	Keys    []string       `json:"keys"`
+	Samples []db.KeySample `json:"samples,omitempty"`
+}
+
+// StatsResponse is a response body template for the keyspace stats route
+type StatsResponse struct {
+	Prefixes []db.PrefixStats `json:"prefixes"`
+}
+
+// Handler for sampling random keys
+// @Summary Return keys chosen at random, optionally with their sizes and expiry times
+// @ID admin-sample
+// @Accept */*
+// @Produce application/json
+// @Param n query int false "Number of keys (default 10)"
+// @Param details query bool false "Include sizes and expiry times"
+// @Success 200 {object} SampleResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /admin/keys/sample [get]
+func (ctl *Controller) handleSample(c *gin.Context) {
+	n, err := strconv.Atoi(c.DefaultQuery("n", "10"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	details, err := strconv.ParseBool(c.DefaultQuery("details", "false"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	samples := ctl.Node.Store.Sample(n, rng)
+	response := SampleResponse{Keys: make([]string, 0, len(samples))}
+	for _, sample := range samples {
+		response.Keys = append(response.Keys, sample.Key)
+	}
+	if details {
+		response.Samples = samples
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for keyspace statistics
+// @Summary Return the number of keys and bytes for each key prefix
+// @ID admin-stats
+// @Accept */*
+// @Produce application/json
+// @Param delimiter query string false "Delimiter ending a prefix (default :)"
+// @Success 200 {object} StatsResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /admin/keys/stats [get]
+func (ctl *Controller) handleStats(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+	delimiter := c.DefaultQuery("delimiter", ":")
+	c.JSON(http.StatusOK, StatsResponse{Prefixes: ctl.Node.Store.Stats(delimiter)})
+}
+
+// Handler for the drain status endpoint
+// @Summary Return the progress of draining this node for maintenance
+// @ID admin-drain-status
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} node.DrainStatus
+// @Router /admin/drain [get]
+func (ctl *Controller) handleDrainStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, ctl.Node.DrainStatus())
+}
+
+// Handler for draining a node
+// @Summary Stop accepting writes on this node, optionally transferring leadership
+// @ID admin-drain
+// @Accept */*
+// @Produce application/json
+// @Param transfer query bool false "Hand leadership to another node (default false)"
+// @Success 200 {object} node.DrainStatus
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 500 {object} ErrorResponse "Error message"
+// @Router /admin/drain [post]
+func (ctl *Controller) handleDrain(c *gin.Context) {
+	transfer, err := strconv.ParseBool(c.DefaultQuery("transfer", "false"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := ctl.Node.Drain(transfer); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.Node.DrainStatus())
+}
+
+// Handler for resuming after a drain
+// @Summary Accept writes on this node again after draining
+// @ID admin-resume
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} node.DrainStatus
+// @Router /admin/drain [delete]
+func (ctl *Controller) handleResume(c *gin.Context) {
+	ctl.Node.Resume()
+	c.JSON(http.StatusOK, ctl.Node.DrainStatus())
+}
+
+// DemoteResponse reports a leader stepping down
+type DemoteResponse struct {
+	Id string `json:"id"`
+	// Term in which the node was leader
+	Term int64 `json:"term"`
+	// Time until which the node does not stand for election
+	HoldoffUntil time.Time `json:"holdoffUntil"`
+}
+
+// Handler for demoting the leader
+// @Summary Make this node step down as leader, without choosing a successor
+// @Description The followers elect a new leader once their election timers
+// @Description expire, as they would after a failure. The demoted node votes in
+// @Description that election, but does not stand for election until the
+// @Description holdoff has passed.
+// @ID admin-demote
+// @Accept */*
+// @Produce application/json
+// @Param holdoff query string false "Time before the node may stand for election again, e.g. 5s (default 2s)"
+// @Success 200 {object} DemoteResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 409 {object} ErrorResponse "This node is not the leader"
+// @Router /admin/demote [post]
+func (ctl *Controller) handleDemote(c *gin.Context) {
+	holdoff := node.DefaultDemoteHoldoff
+	if s := c.Query("holdoff"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			invalidRequest(c, fmt.Errorf("Invalid holdoff %q", s))
+			return
+		}
+		holdoff = d
+	}
+	_, term := ctl.Node.CurrentRole()
+	if err := ctl.Node.Demote(holdoff); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, DemoteResponse{
+		Id:           ctl.Node.RaftNode.Id,
+		Term:         term,
+		HoldoffUntil: time.Now().Add(holdoff)})
+}
+
+// TransferResponse reports leadership being handed to another member
+type TransferResponse struct {
+	Id string `json:"id"`
+	// Term in which the node was leader
+	Term int64 `json:"term"`
+	// Member asked to take over (empty if any up-to-date follower was)
+	Target string `json:"target,omitempty"`
+}
+
+// Handler for transferring leadership
+// @Summary Hand leadership from this node to a chosen member
+// @Description The leader brings its followers up to date, then asks the target
+// @Description (or, without one, any follower with every log entry) to stand
+// @Description for election at once, rather than waiting for an election
+// @Description timeout. Returns once the target has accepted; it is the leader
+// @Description once it has won the election, in the next term.
+// @ID admin-transfer
+// @Accept */*
+// @Produce application/json
+// @Param target query string false "Raft address of the member to take over"
+// @Success 200 {object} TransferResponse
+// @Failure 404 {object} ErrorResponse "The target is not a voting member"
+// @Failure 409 {object} ErrorResponse "This node is not the leader, or the target is behind or refused"
+// @Router /admin/transfer [post]
+func (ctl *Controller) handleTransfer(c *gin.Context) {
+	target := c.Query("target")
+	_, term := ctl.Node.CurrentRole()
+	if err := ctl.Node.TransferLeadership(target); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, TransferResponse{
+		Id:     ctl.Node.RaftNode.Id,
+		Term:   term,
+		Target: target})
+}
+
+// DefaultIsolation is how long a peer is isolated if no duration is given
+const DefaultIsolation = 10 * time.Minute
+
+// IsolationResponse reports a member isolated from this node
+type IsolationResponse struct {
+	Id string `json:"id"`
+	// Time at which the isolation lapses
+	Until time.Time `json:"until"`
+}
+
+// Handler for isolating a member
+// @Summary Cut a member off from this node for a while, without removing it
+// @Description This node stops sending the member append requests and asking
+// @Description for its vote, and refuses its vote requests, until the duration
+// @Description has passed. Isolation is local to this node and not kept across
+// @Description restarts: isolate the member on each node to keep it out of
+// @Description elections, and on the leader to stop sending it entries.
+// @ID admin-isolate
+// @Accept */*
+// @Produce application/json
+// @Param address path string true "Raft address of the member"
+// @Param duration query string false "How long to isolate the member, e.g. 30m (default 10m)"
+// @Success 200 {object} IsolationResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "The address is not a member"
+// @Failure 409 {object} ErrorResponse "The address is this node's own"
+// @Router /admin/members/{address}/isolation [put]
+func (ctl *Controller) handleIsolate(c *gin.Context) {
+	duration := DefaultIsolation
+	if s := c.Query("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			invalidRequest(c, fmt.Errorf("Invalid duration %q", s))
+			return
+		}
+		duration = d
+	}
+	address := c.Param("address")
+	until, err := ctl.Node.Isolate(address, duration)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, IsolationResponse{Id: address, Until: until})
+}
+
+// Handler for ending the isolation of a member
+// @Summary Stop isolating a member from this node before the isolation lapses
+// @ID admin-unisolate
+// @Accept */*
+// @Produce application/json
+// @Param address path string true "Raft address of the member"
+// @Success 204 "No Content"
+// @Failure 404 {object} ErrorResponse "The address is not a member"
+// @Router /admin/members/{address}/isolation [delete]
+func (ctl *Controller) handleUnisolate(c *gin.Context) {
+	if err := ctl.Node.Unisolate(c.Param("address")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// readOnlyScope parses the scope query parameter of the read-only routes
+func readOnlyScope(c *gin.Context) (node.ReadOnlyScope, error) {
+	switch scope := node.ReadOnlyScope(c.DefaultQuery("scope", string(node.ReadOnlyNode))); scope {
+	case node.ReadOnlyNode, node.ReadOnlyCluster:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("Invalid scope %q, must be node or cluster", scope)
+	}
+}
+
+// Handler for the read-only status endpoint
+// @Summary Return whether writes are disabled on this node or the cluster
+// @ID admin-readonly-status
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} node.ReadOnlyStatus
+// @Router /admin/readonly [get]
+func (ctl *Controller) handleReadOnlyStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, ctl.Node.ReadOnly())
+}
+
+// Handler for entering read-only mode
+// @Summary Disable writes on this node or the whole cluster, while serving reads
+// @Description With scope=node, writes are disabled on this node (which only
+// @Description matters while it is the leader) until re-enabled or it restarts.
+// @Description With scope=cluster, an entry disabling writes is committed to the
+// @Description log, so that it applies to every member, and survives restarts
+// @Description and changes of leader. Rejected writes get a ReadOnly error.
+// @ID admin-readonly
+// @Accept */*
+// @Produce application/json
+// @Param scope query string false "node (default) or cluster"
+// @Param reason query string false "Reason reported to rejected writes (default maintenance)"
+// @Success 200 {object} node.ReadOnlyStatus
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/readonly [put]
+func (ctl *Controller) handleReadOnly(c *gin.Context) {
+	ctl.setReadOnly(c, true)
+}
+
+// Handler for leaving read-only mode
+// @Summary Enable writes on this node or the whole cluster again
+// @ID admin-readwrite
+// @Accept */*
+// @Produce application/json
+// @Param scope query string false "node (default) or cluster"
+// @Success 200 {object} node.ReadOnlyStatus
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/readonly [delete]
+func (ctl *Controller) handleReadWrite(c *gin.Context) {
+	ctl.setReadOnly(c, false)
+}
+
+// setReadOnly disables (or, if readOnly is false, enables) writes in the
+// requested scope
+func (ctl *Controller) setReadOnly(c *gin.Context, readOnly bool) {
+	scope, err := readOnlyScope(c)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if scope == node.ReadOnlyNode {
+		if readOnly {
+			ctl.Node.SetReadOnly(c.Query("reason"))
+		} else {
+			ctl.Node.ClearReadOnly()
+		}
+		c.JSON(http.StatusOK, ctl.Node.ReadOnly())
+		return
+	}
+
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+	if readOnly {
+		err = ctl.Node.SetClusterReadOnly(c.Request.Context(), c.Query("reason"))
+	} else {
+		err = ctl.Node.ClearClusterReadOnly(c.Request.Context())
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.Node.ReadOnly())
+}
@@ -0,0 +1,510 @@
+// +build unit
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+func TestStatusRoute(t *testing.T) {
+	router, n := setupServer(t)
+	n.Log = &raft.LogStore{
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_SET, Key: "a", Value: "1"},
+			{Term: 1, Action: raft.LogRecord_SET, Key: "b", Value: "2"}}}
+	n.CommitIndex = 1
+	n.LastApplied = 0
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/status", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in GET:", w.Code)
+	}
+
+	raw, _ := ioutil.ReadAll(w.Body)
+	var data StatusResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Error(err.Error())
+	}
+	if data.LastLogIndex != 1 || data.CommitIndex != 1 || data.LastApplied != 0 {
+		t.Errorf("Incorrect indexes in response: %+v", data)
+	}
+	if data.State != "Leader" {
+		t.Errorf("Expected state Leader, got %s", data.State)
+	}
+	if data.Version != LeifDBVersion || data.Keys != 0 {
+		t.Errorf("Incorrect version or key count in response: %+v", data)
+	}
+}
+
+func TestKeyspaceRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "user:1", "alice")
+	n.Set(context.Background(), "user:2", "bob")
+	n.Set(context.Background(), "session:abc", "xyz")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/keys/sample?n=2&details=true", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in sample:", w.Code)
+	}
+	raw, _ := ioutil.ReadAll(w.Body)
+	var sample SampleResponse
+	if err := json.Unmarshal(raw, &sample); err != nil {
+		t.Error(err.Error())
+	}
+	if len(sample.Keys) != 2 || len(sample.Samples) != 2 {
+		t.Errorf("Expected 2 keys with details, got %+v", sample)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/keys/stats", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in stats:", w.Code)
+	}
+	raw, _ = ioutil.ReadAll(w.Body)
+	var stats StatsResponse
+	if err := json.Unmarshal(raw, &stats); err != nil {
+		t.Error(err.Error())
+	}
+	if len(stats.Prefixes) != 2 || stats.Prefixes[0].Prefix != "user:" || stats.Prefixes[0].Keys != 2 {
+		t.Errorf("Incorrect response: %+v", stats)
+	}
+}
+
+func TestEntriesRoute(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "a", "1")
+	time.Sleep(5 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	n.Set(context.Background(), "b", "2")
+	n.Set(context.Background(), "c", "3")
+
+	get := func(path string) EntriesResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Non-200 status in %s: %d", path, w.Code)
+		}
+		var resp EntriesResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Error(err.Error())
+		}
+		return resp
+	}
+
+	all := get("/admin/entries")
+	if len(all.Entries) != 3 || all.Entries[0].Key != "a" || all.Entries[0].Time.IsZero() {
+		t.Errorf("Expected all 3 entries with times, got %+v", all.Entries)
+	}
+	stamp := url.QueryEscape(between.Format(time.RFC3339Nano))
+	after := get("/admin/entries?from=" + stamp + "&limit=1")
+	if len(after.Entries) != 1 || after.Entries[0].Key != "b" {
+		t.Errorf("Expected the first entry after the time, got %+v", after.Entries)
+	}
+	before := get("/admin/entries?to=" + stamp)
+	if len(before.Entries) != 1 || before.IndexAtEnd == nil || *before.IndexAtEnd != 0 {
+		t.Errorf("Expected only entry 0 before the time, got %+v", before)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/entries?from=yesterday", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid time, got %d", w.Code)
+	}
+}
+
+func TestHotKeysRoute(t *testing.T) {
+	router, n := setupServer(t)
+	for i := 0; i < 8; i++ {
+		n.Set(context.Background(), "user:1", "alice")
+	}
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 40; i++ {
+		do("/db/user:1")
+	}
+	// one in hotKeysSampleEvery reads is counted, so read each other key in a
+	// run long enough for some of its reads to be counted
+	for i := 0; i < 8; i++ {
+		do("/db/user:2")
+	}
+	for i := 0; i < 8; i++ {
+		do("/db/config")
+	}
+
+	var report HotKeysResponse
+	w := do("/admin/hotkeys?sort=reads")
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal("Error parsing hot keys:", err)
+	}
+	if len(report.Keys) != 3 || report.Keys[0].Key != "user:1" ||
+		report.Keys[0].Reads <= report.Keys[1].Reads || report.Keys[0].Writes == 0 {
+		t.Errorf("Expected user:1 to be the hottest key, got %+v", report)
+	}
+
+	w = do("/admin/hotkeys?by=prefix&limit=1")
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal("Error parsing hot keys:", err)
+	}
+	if report.By != "prefix" || len(report.Keys) != 1 || report.Keys[0].Key != "user:" {
+		t.Errorf("Expected user: to be the hottest prefix, got %+v", report)
+	}
+
+	for _, query := range []string{"by=value", "sort=size", "limit=-1"} {
+		if w := do("/admin/hotkeys?" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected bad request for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestDrainRoutes(t *testing.T) {
+	router, n := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/drain", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in drain:", w.Code)
+	}
+	raw, _ := ioutil.ReadAll(w.Body)
+	var status node.DrainStatus
+	if err := json.Unmarshal(raw, &status); err != nil {
+		t.Error(err.Error())
+	}
+	if !status.Draining || !status.Drained {
+		t.Errorf("Expected idle single node to be drained, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/db/stuff", strings.NewReader(`{"value": "x"}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected retryable 503 while draining, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/admin/drain", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || n.Draining() {
+		t.Errorf("Expected resume to stop draining, got %d", w.Code)
+	}
+	if err := n.Set(context.Background(), "stuff", "x"); err != nil {
+		t.Errorf("Expected write after resume to succeed, got %v", err)
+	}
+}
+
+func TestDemoteRoute(t *testing.T) {
+	router, n := setupServer(t)
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("/admin/demote?holdoff=soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid holdoff, got %d", w.Code)
+	}
+
+	term := n.Term
+	w := do("/admin/demote?holdoff=1m")
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status in demote:", w.Code)
+	}
+	var resp DemoteResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp.Term != term || resp.HoldoffUntil.Before(time.Now().Add(50*time.Second)) {
+		t.Errorf("Expected demotion in term %d held off for a minute, got %+v", term, resp)
+	}
+	if n.State != node.Follower || !n.AllowVote {
+		t.Errorf("Expected demoted leader to be a follower that votes, got %s", n.State)
+	}
+	// a cluster of one would otherwise re-elect itself at once
+	if n.DoElection() {
+		t.Error("Expected demoted node not to stand for election during holdoff")
+	}
+
+	w = do("/admin/demote")
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if w.Code != http.StatusConflict || errResp.Code != ErrorConflict {
+		t.Errorf("Expected conflict demoting a follower, got %d %+v", w.Code, errResp)
+	}
+}
+
+func TestNamespaceRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "user:1", "alice")
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PUT", "/admin/namespaces/user", `{"access": "readonly", "maxKeys": 10}`)
+	var ns NamespaceResponse
+	json.Unmarshal(w.Body.Bytes(), &ns)
+	if w.Code != http.StatusOK || ns.Name != "user" || ns.Keys != 1 ||
+		ns.Policy.Access != node.AccessReadOnly || ns.Policy.MaxKeys != 10 {
+		t.Errorf("Expected user namespace policy, got %d %+v", w.Code, ns)
+	}
+	if w := do("PUT", "/admin/namespaces/user", `{"access": "sometimes"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid access, got %d", w.Code)
+	}
+
+	var errResp ErrorResponse
+	w = do("PUT", "/db/user:2", `{"value": "bob"}`)
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if w.Code != http.StatusForbidden || errResp.Code != ErrorForbidden {
+		t.Errorf("Expected write to read-only namespace to be forbidden, got %d %+v", w.Code, errResp)
+	}
+	systemWrite := `{"operations": [{"op": "set", "key": "_leifdb/namespace/user", "value": "{}"}]}`
+	if w := do("POST", "/db/_batch", systemWrite); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for write of a system key, got %d", w.Code)
+	}
+	if w := do("GET", "/db/user:1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected read of read-only namespace to succeed, got %d", w.Code)
+	}
+
+	do("PUT", "/admin/namespaces/user", `{"access": "none"}`)
+	if w := do("GET", "/db/user:1", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected read to be forbidden, got %d", w.Code)
+	}
+	if w := do("GET", "/export", ""); strings.Contains(w.Body.String(), "user:1") ||
+		strings.Contains(w.Body.String(), "_leifdb/") {
+		t.Errorf("Expected export to leave out system keys and denied namespaces, got %s", w.Body.String())
+	}
+
+	var list NamespacesResponse
+	json.Unmarshal(do("GET", "/admin/namespaces", "").Body.Bytes(), &list)
+	if len(list.Namespaces) != 1 || list.Namespaces[0].Policy.Access != node.AccessNone {
+		t.Errorf("Expected one namespace policy, got %+v", list)
+	}
+
+	if w := do("DELETE", "/admin/namespaces/user", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected policy to be deleted, got %d", w.Code)
+	}
+	if w := do("GET", "/admin/namespaces/user", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected no policy after delete, got %d", w.Code)
+	}
+	if w := do("GET", "/db/user:1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected read after deleting the policy to succeed, got %d", w.Code)
+	}
+}
+
+func TestReadOnlyRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "stuff", "x")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/admin/readonly?scope=cluster&reason=migration", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status entering read-only mode:", w.Code)
+	}
+	var status node.ReadOnlyStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if !status.Cluster || status.ClusterReason != "migration" || status.Node {
+		t.Errorf("Expected cluster to be read-only for migration, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/db/stuff", strings.NewReader(`{"value": "y"}`))
+	router.ServeHTTP(w, req)
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusServiceUnavailable || resp.Code != ErrorReadOnly {
+		t.Errorf("Expected ReadOnly error for write, got %d %+v", w.Code, resp)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/db/stuff", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected read to be served in read-only mode, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/admin/readonly?scope=cluster", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || n.ReadOnly().Cluster {
+		t.Errorf("Expected cluster to accept writes again, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/admin/readonly", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !n.ReadOnly().Node {
+		t.Errorf("Expected node to be read-only by default, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/admin/readonly?scope=galaxy", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid scope, got %d", w.Code)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	l := newEventLog(2)
+	if len(l.recent()) != 0 {
+		t.Error("Expected empty event log")
+	}
+	for term := int64(1); term <= 3; term++ {
+		l.add(node.Event{Type: node.EventLeaderChange, Term: term})
+	}
+	events := l.recent()
+	if len(events) != 2 || events[0].Term != 2 || events[1].Term != 3 {
+		t.Errorf("Expected the two most recent events, oldest first, got %v", events)
+	}
+}
+
+func TestDashboardRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/ui", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected HTML page, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/events", nil)
+	router.ServeHTTP(w, req)
+	raw, _ := ioutil.ReadAll(w.Body)
+	var events EventsResponse
+	if err := json.Unmarshal(raw, &events); err != nil || events.Events == nil {
+		t.Errorf("Expected list of events, got %s (%v)", raw, err)
+	}
+}
+
+func TestLogConfigRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+	defer logging.Configure(logging.Config{
+		Subsystems: map[string]logging.SubsystemConfig{logging.Watch: {}}})
+
+	w := httptest.NewRecorder()
+	body := `{"subsystems": {"watch": {"level": "debug", "sample": 10}}}`
+	req, _ := http.NewRequest("PUT", "/admin/log", strings.NewReader(body))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 changing log configuration, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/log", nil)
+	router.ServeHTTP(w, req)
+	var config logging.Config
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal("Error parsing log configuration:", err)
+	}
+	if watch := config.Subsystems[logging.Watch]; watch.Level != "debug" || watch.Sample != 10 {
+		t.Errorf("Expected watch at debug, sampled 1 in 10, got %+v", config)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/admin/log", strings.NewReader(`{"level": "loud"}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid level, got %d", w.Code)
+	}
+}
+
+func TestBatchingRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+
+	w := httptest.NewRecorder()
+	body := `{"maxDelayMicros": 500, "maxEntries": 32, "maxBytes": 65536}`
+	req, _ := http.NewRequest("PUT", "/admin/batching", strings.NewReader(body))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 changing batching, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/batching", nil)
+	router.ServeHTTP(w, req)
+	var batching node.ProposalBatching
+	if err := json.Unmarshal(w.Body.Bytes(), &batching); err != nil {
+		t.Fatal("Error parsing batching:", err)
+	}
+	expected := node.ProposalBatching{MaxDelayMicros: 500, MaxEntries: 32, MaxBytes: 65536}
+	if batching != expected {
+		t.Errorf("Expected %+v, got %+v", expected, batching)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/admin/batching", strings.NewReader(`{"maxEntries": -1}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for negative limits, got %d", w.Code)
+	}
+}
+
+func TestListRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	for _, name := range []string{"c", "a", "b"} {
+		if _, err := n.AcquireLock(context.Background(), name, "host-a", time.Minute); err != nil {
+			t.Fatal("Error taking lock:", err)
+		}
+	}
+
+	var page LocksResponse
+	json.Unmarshal(get("/admin/locks?limit=2").Body.Bytes(), &page)
+	if len(page.Locks) != 2 || page.Locks[0].Name != "a" || page.Locks[1].Name != "b" || page.Next != "b" {
+		t.Fatalf("Expected locks a and b, and to continue after b, got %+v", page)
+	}
+	next := page.Next
+	page = LocksResponse{}
+	json.Unmarshal(get("/admin/locks?limit=2&after="+next).Body.Bytes(), &page)
+	if len(page.Locks) != 1 || page.Locks[0].Name != "c" || page.Next != "" {
+		t.Errorf("Expected only lock c on the last page, got %+v", page)
+	}
+
+	var selected map[string][]map[string]interface{}
+	json.Unmarshal(get("/admin/locks?limit=1&fields=name,owner").Body.Bytes(), &selected)
+	if locks := selected["locks"]; len(locks) != 1 || len(locks[0]) != 2 ||
+		locks[0]["name"] != "a" || locks[0]["owner"] != "host-a" {
+		t.Errorf("Expected only the name and owner of lock a, got %+v", selected)
+	}
+
+	if w := get("/admin/members"); w.Code != http.StatusOK {
+		t.Errorf("Expected members to be listed, got %d", w.Code)
+	}
+	if w := get("/admin/members?limit=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// BatchRequest is a request body template for batches of writes
+type BatchRequest struct {
+	Operations []db.BatchOp `json:"operations"`
+	// Apply the operations that can be, rather than all or nothing
+	BestEffort bool `json:"bestEffort"`
+}
+
+// BatchResponse is a response body template for batches of writes
+type BatchResponse struct {
+	Status string `json:"status"`
+	// Whether each operation was applied (omitted if the write concern is
+	// "leader", since the batch has not been applied yet)
+	Applied []bool `json:"applied,omitempty"`
+}
+
+// Handler for batches of writes
+// @Summary Apply a list of set, delete, and compare-and-swap operations as a single write
+// @ID db-batch
+// @Accept application/json
+// @Produce application/json
+// @Param body body BatchRequest true "Operations"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} BatchResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 409 {object} ErrorResponse "A compare-and-swap failed, so nothing was applied"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
+// @Router /db/_batch [post]
+func (ctl *Controller) handleBatch(c *gin.Context) {
+	var body BatchRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := db.ValidateBatch(body.Operations); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	applied, err := ctl.Node.Batch(ctx, body.Operations, !body.BestEffort)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, BatchResponse{Status: "Ok", Applied: applied})
+}
+
+// TxnRequest is a request body template for transactions
+type TxnRequest struct {
+	If   []db.Compare `json:"if"`
+	Then []db.BatchOp `json:"then"`
+	Else []db.BatchOp `json:"else"`
+}
+
+// TxnResponse is a response body template for transactions
+type TxnResponse struct {
+	Status string `json:"status"`
+	// Whether every condition held, so the "then" operations were applied
+	// rather than the "else" operations (omitted if the write concern is
+	// "leader", since the transaction has not been applied yet)
+	Succeeded *bool `json:"succeeded,omitempty"`
+}
+
+// Handler for transactions
+// @Summary Apply one of two lists of set and delete operations, depending on whether a list of conditions holds
+// @ID db-txn
+// @Accept application/json
+// @Produce application/json
+// @Param body body TxnRequest true "Conditions and operations"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} TxnResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
+// @Router /db/_txn [post]
+func (ctl *Controller) handleTxn(c *gin.Context) {
+	var body TxnRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := db.ValidateTxn(body.Then, body.Else); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	succeeded, err := ctl.Node.Txn(ctx, body.If, body.Then, body.Else)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, TxnResponse{Status: "Ok", Succeeded: succeeded})
+}
@@ -0,0 +1,413 @@
+// Package client is a Go client for the HTTP interface of a LeifDB cluster.
+// Writes are sent to the leader, and reads are spread across the members of
+// the cluster that are healthy and not too far behind the leader
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/retry"
+)
+
+// Defaults for Config fields that are left unset
+const (
+	DefaultTimeout         = 5 * time.Second
+	DefaultMaxLag          = 100
+	DefaultRefreshInterval = 5 * time.Second
+	DefaultWatchWait       = 30 * time.Second
+)
+
+var (
+	// ErrNoEndpoints indicates that a client was configured without any
+	// cluster members to connect to
+	ErrNoEndpoints = errors.New("At least one endpoint is required")
+
+	// ErrNoReaders indicates that no member of the cluster is currently
+	// healthy and up to date enough to serve reads
+	ErrNoReaders = errors.New("No cluster member is available for reads")
+)
+
+// Config holds the settings for a Client
+type Config struct {
+	// Client addresses ("host:port") of the cluster members
+	Endpoints []string
+	// Time limit for each HTTP request
+	Timeout time.Duration
+	// Members whose applied index is more than MaxLag entries behind the
+	// leader's commit index are not used for reads (see Client.Refresh)
+	MaxLag int64
+	// How often the health and lag of members is checked
+	RefreshInterval time.Duration
+	// How long each request made by a watch waits for changes
+	WatchWait time.Duration
+	// Priority of the client's requests: "system", "normal" (the default), or
+	// "batch" for bulk work that should not hold up interactive traffic
+	Priority string
+	// How reads that fail with a retryable error are retried, each time on
+	// another member. If MaxAttempts is 0, a read is tried once on each member,
+	// with no wait in between. Errors that can't succeed on another member are
+	// never retried, whatever Retry.Retryable reports
+	Retry retry.Policy
+}
+
+// Error is an error response from a cluster member (see ErrorResponse in the
+// server for the meaning of each field)
+type Error struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Leader    string `json:"leader"`
+	// Log index and term of a write that timed out, which may or may not
+	// have been committed
+	Index *int64 `json:"index"`
+	Term  *int64 `json:"term"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// A Client makes requests to a LeifDB cluster, and is safe for concurrent use
+type Client struct {
+	config    Config
+	http      *http.Client
+	watchHTTP *http.Client
+	lock      sync.Mutex
+	leader    string
+	readers   []string
+	refreshed time.Time
+	next      int
+}
+
+// New creates a Client for the cluster whose members are listed in config
+func New(config Config) (*Client, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxLag == 0 {
+		config.MaxLag = DefaultMaxLag
+	}
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = DefaultRefreshInterval
+	}
+	if config.WatchWait == 0 {
+		config.WatchWait = DefaultWatchWait
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = len(config.Endpoints)
+	}
+	return &Client{
+		config:    config,
+		http:      &http.Client{Timeout: config.Timeout},
+		watchHTTP: &http.Client{Timeout: config.Timeout + config.WatchWait}}, nil
+}
+
+// memberStatus is the subset of a member's admin status used to choose which
+// members serve reads
+type memberStatus struct {
+	State       string `json:"state"`
+	Witness     bool   `json:"witness"`
+	CommitIndex int64  `json:"commitIndex"`
+	LastApplied int64  `json:"lastApplied"`
+	CaughtUp    bool   `json:"caughtUp"`
+}
+
+// Refresh checks the health and lag of every member now, rather than waiting
+// for the next periodic check. Members that are unreachable, are witnesses,
+// have not caught up since starting, or lag the leader by more than MaxLag
+// entries are not used for reads
+func (c *Client) Refresh(ctx context.Context) error {
+	statuses := make([]*memberStatus, len(c.config.Endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range c.config.Endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			var s memberStatus
+			if err := c.do(ctx, "GET", endpoint, "/admin/status", nil, &s); err == nil {
+				statuses[i] = &s
+			}
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	// lag is measured against the leader's commit index, or if the leader is
+	// unreachable, the highest commit index known to any member
+	leader := ""
+	commit := int64(-1)
+	for i, s := range statuses {
+		if s == nil {
+			continue
+		}
+		if s.State == "Leader" {
+			leader = c.config.Endpoints[i]
+		}
+		if s.CommitIndex > commit {
+			commit = s.CommitIndex
+		}
+	}
+	readers := []string{}
+	for i, s := range statuses {
+		if s == nil || s.Witness || !s.CaughtUp {
+			continue
+		}
+		if commit-s.LastApplied > c.config.MaxLag {
+			continue
+		}
+		readers = append(readers, c.config.Endpoints[i])
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if leader != "" {
+		c.leader = leader
+	}
+	c.readers = readers
+	c.refreshed = time.Now()
+	if len(readers) == 0 {
+		return ErrNoReaders
+	}
+	return nil
+}
+
+// Readers returns the members currently used for reads
+func (c *Client) Readers() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.readers...)
+}
+
+// reader returns the next member to read from, in turn, first refreshing the
+// list of members if it is out of date
+func (c *Client) reader(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	stale := time.Since(c.refreshed) > c.config.RefreshInterval
+	c.lock.Unlock()
+	if stale {
+		c.Refresh(ctx)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.readers) == 0 {
+		return "", ErrNoReaders
+	}
+	c.next = (c.next + 1) % len(c.readers)
+	return c.readers[c.next], nil
+}
+
+// exclude stops reads from a member until the next refresh
+func (c *Client) exclude(endpoint string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, reader := range c.readers {
+		if reader == endpoint {
+			c.readers = append(c.readers[:i:i], c.readers[i+1:]...)
+			return
+		}
+	}
+}
+
+// writer returns the member to send writes to: the leader if it is known,
+// otherwise the first member (which redirects to the leader)
+func (c *Client) writer() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.leader != "" {
+		return c.leader
+	}
+	return c.config.Endpoints[0]
+}
+
+// retryable reports whether a request that failed with err may succeed on
+// another member
+func retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// readRetry returns the policy for reads: the configured policy, limited to
+// errors that may not happen on another member
+func (c *Client) readRetry() retry.Policy {
+	policy := c.config.Retry
+	policy.Retryable = func(err error) bool {
+		if err == ErrNoReaders || !retryable(err) {
+			return false
+		}
+		return c.config.Retry.ShouldRetry(err)
+	}
+	return policy
+}
+
+// Get returns the value of key (empty if it does not exist), read from each
+// healthy member in turn. The value may be stale, by up to MaxLag entries as
+// of the last check of the member. If a member fails to serve the read, it is
+// not used again until the next check, and the read is retried on another as
+// set by Config.Retry
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := c.readRetry().Do(ctx, func(int) error {
+		endpoint, err := c.reader(ctx)
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Value string `json:"value"`
+		}
+		err = c.do(ctx, "GET", endpoint, "/db/"+url.PathEscape(key), nil, &resp)
+		if err != nil {
+			if retryable(err) {
+				c.exclude(endpoint)
+			}
+			return err
+		}
+		value = resp.Value
+		return nil
+	})
+	return value, err
+}
+
+// Set writes a value for key, and returns once the write is committed
+func (c *Client) Set(ctx context.Context, key string, value string) error {
+	body := struct {
+		Value string `json:"value"`
+	}{Value: value}
+	return c.do(ctx, "PUT", c.writer(), "/db/"+url.PathEscape(key), body, nil)
+}
+
+// Delete removes key, and returns once the delete is committed
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, "DELETE", c.writer(), "/db/"+url.PathEscape(key), nil, nil)
+}
+
+// Fates of a log entry reported by EntryStatus
+const (
+	EntryCommitted = "committed"
+	EntryPending   = "pending"
+	EntryLost      = "lost"
+	EntryUnknown   = "unknown"
+)
+
+// EntryStatus asks the leader what became of the log entry at index in term,
+// such as that of a write that failed with an Error carrying an Index and Term.
+// The leader first waits up to wait for the entry to be applied, so a write
+// that is still pending can be waited out. A committed write need not be
+// retried, and a lost one can be retried safely
+func (c *Client) EntryStatus(ctx context.Context, index int64, term int64, wait time.Duration) (string, error) {
+	var resp struct {
+		Status string `json:"status"`
+	}
+	path := fmt.Sprintf("/entries/%d?term=%d&wait=%s", index, term, wait)
+	if err := c.do(ctx, "GET", c.writer(), path, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Status, nil
+}
+
+// do makes a request to a member, encoding body (if not nil) as JSON and
+// decoding a JSON response into out (if not nil). Redirects to the leader are
+// followed, and remembered for later writes
+func (c *Client) do(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	path string,
+	body interface{},
+	out interface{}) error {
+	return c.send(ctx, c.http, method, endpoint, path, body, out)
+}
+
+// requestTimeout returns how long a request may take: the time limit of the
+// HTTP client, or the time left before ctx's deadline if that is sooner (0 if
+// there is neither)
+func requestTimeout(ctx context.Context, limit time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); limit == 0 || left < limit {
+			return left
+		}
+	}
+	return limit
+}
+
+// send makes a request as for do, using a specific HTTP client
+func (c *Client) send(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	endpoint string,
+	path string,
+	body interface{},
+	out interface{}) error {
+
+	target := endpoint + path
+	if !strings.Contains(endpoint, "://") {
+		target = "http://" + target
+	}
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if c.config.Priority != "" {
+		req.Header.Set("X-Leifdb-Priority", c.config.Priority)
+	}
+	if timeout := requestTimeout(ctx, client.Timeout); timeout > 0 {
+		req.Header.Set("X-Leifdb-Timeout", timeout.String())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{Status: resp.StatusCode}
+		if json.Unmarshal(raw, e) != nil || e.Code == "" {
+			e.Message = strings.TrimSpace(string(raw))
+		}
+		return e
+	}
+	if method != "GET" && resp.Request.URL.Host != req.URL.Host {
+		// redirected to the leader
+		c.lock.Lock()
+		c.leader = resp.Request.URL.Host
+		c.lock.Unlock()
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btmorr/leifdb/retry"
+)
+
+// fakeCluster serves the admin status and database routes used by the client
+// for a set of nodes, all holding the same data
+type fakeCluster struct {
+	sync.Mutex
+	servers []*httptest.Server
+	leader  int
+	commit  int64
+	applied []int64
+	down    []bool
+	reads   []int
+	data    map[string]string
+	// changes served to watches, and the revision before which they have
+	// been discarded
+	changes   []Change
+	compacted int64
+	// X-Leifdb-Timeout header of the last write
+	timeout string
+	// post handles POST requests to the leader, returning the status and body
+	// of the response
+	post func(path string, body map[string]interface{}) (int, interface{})
+}
+
+func newFakeCluster(t *testing.T, size int) *fakeCluster {
+	c := &fakeCluster{
+		commit:    100,
+		applied:   make([]int64, size),
+		down:      make([]bool, size),
+		reads:     make([]int, size),
+		data:      map[string]string{},
+		compacted: -1}
+	for i := 0; i < size; i++ {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.serve(i, w, r)
+		}))
+		t.Cleanup(server.Close)
+		c.servers = append(c.servers, server)
+		c.applied[i] = c.commit
+	}
+	return c
+}
+
+func (c *fakeCluster) addr(i int) string {
+	return strings.TrimPrefix(c.servers[i].URL, "http://")
+}
+
+func (c *fakeCluster) endpoints() []string {
+	endpoints := []string{}
+	for i := range c.servers {
+		endpoints = append(endpoints, c.addr(i))
+	}
+	return endpoints
+}
+
+func (c *fakeCluster) serve(i int, w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	if c.down[i] {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Error{Code: "Unavailable", Retryable: true})
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/db/")
+	switch {
+	case r.URL.Path == "/admin/status":
+		s := memberStatus{
+			State:       "Follower",
+			CommitIndex: c.commit,
+			LastApplied: c.applied[i],
+			CaughtUp:    true}
+		if i == c.leader {
+			s.State = "Leader"
+		}
+		json.NewEncoder(w).Encode(s)
+	case r.URL.Path == "/watch":
+		c.watch(i, w, r)
+	case r.Method == "GET":
+		c.reads[i]++
+		json.NewEncoder(w).Encode(map[string]string{"value": c.data[key]})
+	case i != c.leader:
+		w.Header().Set("Location", c.servers[c.leader].URL+r.URL.Path)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	case r.Method == "POST":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		status, resp := c.post(r.URL.Path, body)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == "PUT":
+		c.timeout = r.Header.Get("X-Leifdb-Timeout")
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		c.data[key] = body["value"]
+	case r.Method == "DELETE":
+		delete(c.data, key)
+	}
+}
+
+// watch serves the changes after the requested revision, up to the member's
+// applied index and at most two revisions at a time
+func (c *fakeCluster) watch(i int, w http.ResponseWriter, r *http.Request) {
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	if after < c.compacted {
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(Error{Code: "Compacted"})
+		return
+	}
+	resp := struct {
+		Changes  []Change `json:"changes"`
+		Revision int64    `json:"revision"`
+	}{Changes: []Change{}, Revision: after}
+	for _, change := range c.changes {
+		if change.Revision > after && change.Revision <= after+2 && change.Revision <= c.applied[i] {
+			resp.Changes = append(resp.Changes, change)
+			resp.Revision = change.Revision
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestReadBalancing(t *testing.T) {
+	c := newFakeCluster(t, 3)
+	c.applied[2] = c.commit - 50
+	client, _ := New(Config{Endpoints: c.endpoints(), MaxLag: 10})
+	ctx := context.Background()
+
+	if err := client.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := client.Get(ctx, "key"); err != nil || v != "value" {
+			t.Fatalf("Expected to read value, got %q (%v)", v, err)
+		}
+	}
+	// the lagging member is excluded, and reads are spread over the others
+	if c.reads[0] != 5 || c.reads[1] != 5 || c.reads[2] != 0 {
+		t.Errorf("Expected reads split between the up to date members, got %v", c.reads)
+	}
+
+	// a member that fails is skipped
+	c.down[1] = true
+	for i := 0; i < 4; i++ {
+		if _, err := client.Get(ctx, "key"); err != nil {
+			t.Fatalf("Expected read to fail over, got %v", err)
+		}
+	}
+	if readers := client.Readers(); len(readers) != 1 || readers[0] != c.addr(0) {
+		t.Errorf("Expected only the first member to serve reads, got %v", readers)
+	}
+
+	c.down[0] = true
+	if err := client.Refresh(ctx); err != ErrNoReaders {
+		t.Errorf("Expected %v with no healthy members, got %v", ErrNoReaders, err)
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	c := newFakeCluster(t, 2)
+	ctx := context.Background()
+
+	// the first read goes to the second member, which fails after the check of
+	// members, and is not retried
+	once, _ := New(Config{Endpoints: c.endpoints(), Retry: retry.Once})
+	once.Refresh(ctx)
+	c.down[1] = true
+	var e *Error
+	if _, err := once.Get(ctx, "key"); !errors.As(err, &e) || e.Code != "Unavailable" {
+		t.Errorf("Expected the failed read not to be retried, got %v", err)
+	}
+	if _, err := once.Get(ctx, "key"); err != nil {
+		t.Errorf("Expected the failed member to be skipped, got %v", err)
+	}
+
+	// by default, a read is tried on each member
+	c.down[1] = false
+	client, _ := New(Config{Endpoints: c.endpoints()})
+	client.Refresh(ctx)
+	c.down[1] = true
+	if _, err := client.Get(ctx, "key"); err != nil {
+		t.Errorf("Expected the read to be retried on the other member, got %v", err)
+	}
+}
+
+func TestWriteRedirect(t *testing.T) {
+	c := newFakeCluster(t, 3)
+	c.leader = 1
+	client, _ := New(Config{Endpoints: c.endpoints()})
+
+	if err := client.Set(context.Background(), "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	if c.data["key"] != "value" {
+		t.Error("Expected write to reach the leader")
+	}
+	if client.writer() != c.addr(1) {
+		t.Errorf("Expected leader to be remembered, got %s", client.writer())
+	}
+
+	c.down[1] = true
+	err := client.Delete(context.Background(), "key")
+	if e, ok := err.(*Error); !ok || e.Code != "Unavailable" || !e.Retryable {
+		t.Errorf("Expected a typed error from an unavailable leader, got %v", err)
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	c := newFakeCluster(t, 1)
+	client, _ := New(Config{Endpoints: c.endpoints(), Timeout: 2 * time.Second})
+
+	client.Set(context.Background(), "key", "value")
+	if c.timeout != "2s" {
+		t.Errorf("Expected the client's time limit to be sent, got %q", c.timeout)
+	}
+
+	// a sooner deadline on the context is sent instead
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	client.Set(ctx, "key", "value")
+	timeout, err := time.ParseDuration(c.timeout)
+	if err != nil || timeout <= 0 || timeout > 500*time.Millisecond {
+		t.Errorf("Expected the time left before the deadline to be sent, got %q", c.timeout)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := newFakeCluster(t, 2)
+	c.applied[0], c.applied[1] = 3, 3
+	c.changes = []Change{
+		{Revision: 0, Type: ChangePut, Key: "a", Value: "1"},
+		{Revision: 2, Type: ChangePut, Key: "a", Value: "2"},
+		{Revision: 2, Type: ChangePut, Key: "b", Value: "3"},
+		{Revision: 3, Type: ChangeDelete, Key: "a"}}
+	client, _ := New(Config{Endpoints: c.endpoints()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := client.Watch(ctx, "", -1)
+	received := []Change{}
+	for change := range w.C {
+		received = append(received, change)
+		if len(received) == 1 {
+			// the watch resumes on the other member
+			c.Lock()
+			c.down[1] = true
+			c.Unlock()
+		}
+		if len(received) == len(c.changes) {
+			cancel()
+		}
+	}
+	if fmt.Sprint(received) != fmt.Sprint(c.changes) {
+		t.Errorf("Expected each change once, in order, got %v", received)
+	}
+	if w.Err() != context.Canceled {
+		t.Errorf("Expected watch to end when cancelled, got %v", w.Err())
+	}
+
+	// a watch for changes that are no longer kept fails
+	c.Lock()
+	c.down[1] = false
+	c.compacted = 1
+	c.Unlock()
+	w = client.Watch(context.Background(), "", 0)
+	for range w.C {
+		t.Error("Expected no changes")
+	}
+	if e, ok := w.Err().(*Error); !ok || e.Code != "Compacted" {
+		t.Errorf("Expected Compacted error, got %v", w.Err())
+	}
+}
+
+func TestBatchBuilder(t *testing.T) {
+	c := newFakeCluster(t, 1)
+	var sent map[string]interface{}
+	c.post = func(path string, body map[string]interface{}) (int, interface{}) {
+		sent = body
+		if body["bestEffort"] == true {
+			return http.StatusOK, map[string]interface{}{"applied": []bool{true, false, true}}
+		}
+		return http.StatusConflict, Error{Code: "Conflict"}
+	}
+	client, _ := New(Config{Endpoints: c.endpoints()})
+
+	batch := client.Batch().Set("a", "1").CompareAndSwap("b", "0", "1").Delete("c")
+	results, err := batch.Commit(context.Background())
+	if e, ok := err.(*Error); !ok || e.Code != "Conflict" {
+		t.Errorf("Expected a Conflict error, got %v", err)
+	}
+	if len(results) != 3 || results[0].Applied {
+		t.Errorf("Expected nothing applied, got %v", results)
+	}
+	ops := fmt.Sprint(sent["operations"])
+	if ops != "[map[key:a op:set value:1] map[expected:0 key:b op:cas value:1] map[key:c op:delete]]" {
+		t.Errorf("Unexpected operations sent: %s", ops)
+	}
+
+	results, err = batch.BestEffort().Commit(context.Background())
+	if err != nil {
+		t.Fatalf("Error in batch: %v", err)
+	}
+	if !results[0].Applied || results[1].Applied || results[1].Op.Key != "b" || !results[2].Applied {
+		t.Errorf("Unexpected results: %v", results)
+	}
+}
+
+func TestTxnBuilder(t *testing.T) {
+	c := newFakeCluster(t, 1)
+	var sent map[string]interface{}
+	c.post = func(path string, body map[string]interface{}) (int, interface{}) {
+		sent = body
+		return http.StatusOK, map[string]bool{"succeeded": false}
+	}
+	client, _ := New(Config{Endpoints: c.endpoints()})
+
+	result, err := client.Txn().
+		If(Equals("a", "1"), Missing("lock")).
+		Then(SetOp("lock", "me")).
+		Else(DeleteOp("a"), SetOp("b", "2")).
+		Commit(context.Background())
+	if err != nil {
+		t.Fatalf("Error in transaction: %v", err)
+	}
+	if fmt.Sprint(sent["if"]) != "[map[key:a value:1] map[key:lock]]" {
+		t.Errorf("Unexpected conditions sent: %v", sent["if"])
+	}
+	if result.Succeeded || len(result.Results) != 2 || result.Results[0].Op != DeleteOp("a") {
+		t.Errorf("Expected the else operations applied, got %+v", result)
+	}
+}
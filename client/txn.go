@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Kinds of operation in a batch or transaction
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpCAS    = "cas"
+)
+
+// An Op is one operation in a batch or transaction. A cas operation sets the
+// key to Value only if its current value is Expected, or if Expected is nil and
+// the key does not exist (transactions may only contain set and delete
+// operations)
+type Op struct {
+	Op       string  `json:"op"`
+	Key      string  `json:"key"`
+	Value    string  `json:"value,omitempty"`
+	Expected *string `json:"expected,omitempty"`
+}
+
+// SetOp returns an operation that sets key to value
+func SetOp(key string, value string) Op {
+	return Op{Op: OpSet, Key: key, Value: value}
+}
+
+// DeleteOp returns an operation that removes key
+func DeleteOp(key string) Op {
+	return Op{Op: OpDelete, Key: key}
+}
+
+// CASOp returns an operation that sets key to value if its current value is
+// expected
+func CASOp(key string, expected string, value string) Op {
+	return Op{Op: OpCAS, Key: key, Value: value, Expected: &expected}
+}
+
+// CreateOp returns an operation that sets key to value if it does not exist
+func CreateOp(key string, value string) Op {
+	return Op{Op: OpCAS, Key: key, Value: value}
+}
+
+// OpResult is the outcome of one operation of a batch or transaction
+type OpResult struct {
+	Op      Op
+	Applied bool
+}
+
+// A BatchBuilder accumulates the operations of a batch, which is sent as a
+// single write by Commit
+type BatchBuilder struct {
+	client     *Client
+	ops        []Op
+	bestEffort bool
+}
+
+// Batch starts building a batch of operations. Operations are applied in
+// order, and each sees the effects of the ones before it
+func (c *Client) Batch() *BatchBuilder {
+	return &BatchBuilder{client: c}
+}
+
+// Add adds operations to the batch
+func (b *BatchBuilder) Add(ops ...Op) *BatchBuilder {
+	b.ops = append(b.ops, ops...)
+	return b
+}
+
+// Set adds an operation that sets key to value
+func (b *BatchBuilder) Set(key string, value string) *BatchBuilder {
+	return b.Add(SetOp(key, value))
+}
+
+// Delete adds an operation that removes key
+func (b *BatchBuilder) Delete(key string) *BatchBuilder {
+	return b.Add(DeleteOp(key))
+}
+
+// CompareAndSwap adds an operation that sets key to value if its current value
+// is expected
+func (b *BatchBuilder) CompareAndSwap(key string, expected string, value string) *BatchBuilder {
+	return b.Add(CASOp(key, expected, value))
+}
+
+// Create adds an operation that sets key to value if it does not exist
+func (b *BatchBuilder) Create(key string, value string) *BatchBuilder {
+	return b.Add(CreateOp(key, value))
+}
+
+// BestEffort makes the batch skip compare-and-swaps that fail and apply the
+// rest of the operations, rather than applying nothing if any fails
+func (b *BatchBuilder) BestEffort() *BatchBuilder {
+	b.bestEffort = true
+	return b
+}
+
+// Commit sends the batch, and returns whether each operation was applied. If
+// an all-or-nothing batch is not applied because a compare-and-swap failed,
+// the results are returned along with a Conflict error
+func (b *BatchBuilder) Commit(ctx context.Context) ([]OpResult, error) {
+	body := struct {
+		Operations []Op `json:"operations"`
+		BestEffort bool `json:"bestEffort"`
+	}{Operations: b.ops, BestEffort: b.bestEffort}
+	var resp struct {
+		Applied []bool `json:"applied"`
+	}
+	err := b.client.do(ctx, "POST", b.client.writer(), "/db/_batch", body, &resp)
+	var e *Error
+	if err != nil && !(errors.As(err, &e) && e.Status == http.StatusConflict) {
+		return nil, err
+	}
+	results := make([]OpResult, len(b.ops))
+	for i, op := range b.ops {
+		results[i].Op = op
+		results[i].Applied = i < len(resp.Applied) && resp.Applied[i]
+	}
+	return results, err
+}
+
+// A Compare is a condition of a transaction: the key has the value Value, or
+// if Value is nil, the key does not exist
+type Compare struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+}
+
+// Equals returns a condition that holds if key has the value value
+func Equals(key string, value string) Compare {
+	return Compare{Key: key, Value: &value}
+}
+
+// Missing returns a condition that holds if key does not exist
+func Missing(key string) Compare {
+	return Compare{Key: key}
+}
+
+// A TxnBuilder accumulates the conditions and operations of a transaction,
+// which is sent as a single write by Commit
+type TxnBuilder struct {
+	client    *Client
+	compares  []Compare
+	then      []Op
+	otherwise []Op
+}
+
+// TxnResult is the outcome of a transaction
+type TxnResult struct {
+	// Whether every condition held, so the Then operations were applied
+	// rather than the Else operations
+	Succeeded bool
+	// The operations that were applied
+	Results []OpResult
+}
+
+// Txn starts building a transaction, which checks its conditions and applies
+// either its Then or its Else operations as a single write, so no other write
+// can change the keys in between
+func (c *Client) Txn() *TxnBuilder {
+	return &TxnBuilder{client: c}
+}
+
+// If adds conditions, all of which must hold for the Then operations to be
+// applied
+func (t *TxnBuilder) If(compares ...Compare) *TxnBuilder {
+	t.compares = append(t.compares, compares...)
+	return t
+}
+
+// Then adds operations to apply if every condition holds
+func (t *TxnBuilder) Then(ops ...Op) *TxnBuilder {
+	t.then = append(t.then, ops...)
+	return t
+}
+
+// Else adds operations to apply if any condition does not hold
+func (t *TxnBuilder) Else(ops ...Op) *TxnBuilder {
+	t.otherwise = append(t.otherwise, ops...)
+	return t
+}
+
+// Commit sends the transaction, and returns which operations were applied
+func (t *TxnBuilder) Commit(ctx context.Context) (*TxnResult, error) {
+	body := struct {
+		If   []Compare `json:"if"`
+		Then []Op      `json:"then"`
+		Else []Op      `json:"else"`
+	}{If: t.compares, Then: t.then, Else: t.otherwise}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := t.client.do(ctx, "POST", t.client.writer(), "/db/_txn", body, &resp); err != nil {
+		return nil, err
+	}
+	ops := t.otherwise
+	if resp.Succeeded {
+		ops = t.then
+	}
+	result := &TxnResult{Succeeded: resp.Succeeded, Results: make([]OpResult, len(ops))}
+	for i, op := range ops {
+		result.Results[i] = OpResult{Op: op, Applied: true}
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// watchRetryDelay is how long a watch waits before trying another member,
+// after a request fails
+const watchRetryDelay = 100 * time.Millisecond
+
+// Change types
+const (
+	ChangePut    = "put"
+	ChangeDelete = "delete"
+)
+
+// A Change is a write to a key, received from a watch. Revision is the index
+// of the log entry that made the change, which is the same on every member, so
+// changes with the same revision were made together (e.g. by one batch). Time
+// is when the leader appended the change (nil if it was not recorded)
+type Change struct {
+	Revision int64      `json:"revision"`
+	Type     string     `json:"type"`
+	Key      string     `json:"key"`
+	Value    string     `json:"value"`
+	Time     *time.Time `json:"time"`
+}
+
+// A Watcher delivers the changes to keys with a prefix, in order. Each change
+// is delivered exactly once, even if the watch moves to another member
+type Watcher struct {
+	// C receives each change, and is closed when the watch ends
+	C    <-chan Change
+	err  error
+	done chan struct{}
+}
+
+// Err returns the reason the watch ended, once C is closed: the error from the
+// context, or an error that can't be recovered from by trying another member
+// (such as a Compacted error, if changes after the last one seen are no longer
+// kept by the cluster)
+func (w *Watcher) Err() error {
+	<-w.done
+	return w.err
+}
+
+// Watch starts watching changes to keys starting with prefix (all keys if it
+// is empty) that were made after revision after (-1 for every change the
+// cluster still keeps). Changes are read from the healthy members in turn, and
+// if a member fails, the watch resumes from the last change seen on another.
+// The watch ends when ctx is done. Changes must be received from C promptly, as
+// the watch does not read more until they are
+func (c *Client) Watch(ctx context.Context, prefix string, after int64) *Watcher {
+	changes := make(chan Change)
+	w := &Watcher{C: changes, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		defer close(changes)
+		w.err = c.watch(ctx, prefix, after, changes)
+	}()
+	return w
+}
+
+// watch sends changes after revision after to out, until ctx is done or an
+// error that can't be retried occurs
+func (c *Client) watch(ctx context.Context, prefix string, after int64, out chan<- Change) error {
+	for {
+		endpoint, err := c.reader(ctx)
+		if err == nil {
+			var resp struct {
+				Changes  []Change `json:"changes"`
+				Revision int64    `json:"revision"`
+			}
+			path := fmt.Sprintf("/watch?prefix=%s&after=%d&wait=%s",
+				url.QueryEscape(prefix), after, c.config.WatchWait)
+			err = c.send(ctx, c.watchHTTP, "GET", endpoint, path, nil, &resp)
+			if err == nil {
+				next := after
+				for _, change := range resp.Changes {
+					if change.Revision <= after {
+						// already delivered
+						continue
+					}
+					select {
+					case out <- change:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					if change.Revision > next {
+						next = change.Revision
+					}
+				}
+				// the response also covers entries that made no changes to
+				// watched keys
+				if resp.Revision > next {
+					next = resp.Revision
+				}
+				after = next
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !retryable(err) {
+				return err
+			}
+			c.exclude(endpoint)
+		}
+
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err == ErrNoReaders {
+			c.Refresh(ctx)
+		}
+	}
+}
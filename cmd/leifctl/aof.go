@@ -0,0 +1,415 @@
+package main
+
+// A reader for Redis append-only files, for `leifctl import`. The commands in
+// the file are replayed against an in-memory copy of the database being
+// imported, and the keys left at the end are imported, so that keys
+// overwritten or deleted later in the file are never written to the cluster.
+// Only the commands that write strings, lists, sets, sorted sets, and hashes
+// (in the forms Redis writes them to the file) are replayed; others are
+// counted and reported. A file that starts with an RDB preamble (as written
+// with aof-use-rdb-preamble) is read as the dump followed by commands.
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAOFArgBytes bounds the size of a command argument, which bounds the size
+// of a value that can be imported
+const maxAOFArgBytes = maxLineBytes
+
+// aofArity is the least number of arguments of each command that is replayed
+var aofArity = map[string]int{
+	"SELECT": 1, "SET": 2, "SETNX": 2, "SETEX": 3, "PSETEX": 3, "GETSET": 2,
+	"APPEND": 2, "INCR": 1, "DECR": 1, "INCRBY": 2, "DECRBY": 2,
+	"DEL": 1, "UNLINK": 1, "EXPIRE": 2, "PEXPIRE": 2, "EXPIREAT": 2,
+	"PEXPIREAT": 2, "PERSIST": 1, "RENAME": 2, "RENAMENX": 2, "SADD": 2,
+	"SREM": 2, "ZADD": 3, "ZINCRBY": 3, "ZREM": 2, "HSET": 3, "HMSET": 3,
+	"HSETNX": 3, "HDEL": 2, "RPUSH": 2, "LPUSH": 2, "RPOP": 1, "LPOP": 1}
+
+// setExpiryUnits are the units of the expiry options of SET
+var setExpiryUnits = map[string]time.Duration{
+	"EX": time.Second, "PX": time.Millisecond, "EXAT": time.Second, "PXAT": time.Millisecond}
+
+var (
+	// errCorruptAOF indicates an append-only file that is not made of RESP
+	// arrays of bulk strings
+	errCorruptAOF = errors.New("Corrupt append-only file")
+)
+
+// An aofReplay is the state of a database as of the commands replayed so far
+type aofReplay struct {
+	db       int
+	selected int
+	keys     map[string]*redisKey
+	// number of each command that was not replayed
+	skipped map[string]int
+}
+
+// replayAOF reads the parts of an append-only file in order (such as the base
+// and incremental files of a Redis 7 multi-part file), and imports the keys
+// they leave in the selected database
+func (im *importer) replayAOF(parts []*bufio.Reader) error {
+	replay := &aofReplay{selected: im.db, keys: map[string]*redisKey{}, skipped: map[string]int{}}
+	for i, r := range parts {
+		if err := im.replayPart(replay, r); err != nil {
+			return fmt.Errorf("File %d: %w", i+1, err)
+		}
+	}
+
+	names := make([]string, 0, len(replay.keys))
+	for name := range replay.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := im.emit(im.db, replay.keys[name]); err != nil {
+			return err
+		}
+	}
+	if len(replay.skipped) > 0 {
+		commands := make([]string, 0, len(replay.skipped))
+		for command, n := range replay.skipped {
+			commands = append(commands, fmt.Sprintf("%s (%d)", command, n))
+		}
+		sort.Strings(commands)
+		fmt.Fprintf(im.log, "Commands not replayed: %s\n", strings.Join(commands, ", "))
+	}
+	return nil
+}
+
+// replayPart replays one part of an append-only file, which may start with an
+// RDB preamble
+func (im *importer) replayPart(replay *aofReplay, r *bufio.Reader) error {
+	if preamble, err := r.Peek(5); err == nil && string(preamble) == "REDIS" {
+		unsupported, err := readRDB(r, func(db int, key *redisKey) error {
+			if db == im.db {
+				replay.keys[key.name] = key
+			}
+			return nil
+		})
+		im.unsupported += unsupported
+		if err != nil {
+			return err
+		}
+	}
+	for command := 1; ; command++ {
+		args, err := readRESPCommand(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Command %d: %w", command, err)
+		}
+		if err := replay.apply(args); err != nil {
+			return fmt.Errorf("Command %d (%s): %w", command, args[0], err)
+		}
+	}
+}
+
+// readRESPCommand reads a command written as a RESP array of bulk strings, or
+// returns io.EOF at the end of the file
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 1 {
+		return nil, errCorruptAOF
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r, '$')
+		if err == io.EOF {
+			return nil, errCorruptAOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(line)
+		if err != nil || size < 0 || size > maxAOFArgBytes {
+			return nil, errCorruptAOF
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil || string(arg[size:]) != "\r\n" {
+			return nil, errCorruptAOF
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+// readRESPLine reads a line starting with prefix, and returns the rest of it
+func readRESPLine(r *bufio.Reader, prefix byte) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", io.EOF
+	}
+	if err != nil || len(line) < 3 || line[0] != prefix || !strings.HasSuffix(line, "\r\n") {
+		return "", errCorruptAOF
+	}
+	return line[1 : len(line)-2], nil
+}
+
+// key returns the key called name, creating it as kind if it does not exist.
+// A key of another kind is replaced, as Redis would not have written a
+// command for the wrong kind of key
+func (a *aofReplay) key(name string, kind string) *redisKey {
+	key, ok := a.keys[name]
+	if !ok || key.kind != kind {
+		key = &redisKey{name: name, kind: kind}
+		switch kind {
+		case redisSet:
+			key.set = map[string]bool{}
+		case redisZSet:
+			key.zset = map[string]float64{}
+		case redisHash:
+			key.hash = map[string]string{}
+		}
+		a.keys[name] = key
+	}
+	return key
+}
+
+// removeIfEmpty deletes a collection left with no members, as Redis does
+func (a *aofReplay) removeIfEmpty(key *redisKey) {
+	if len(key.list)+len(key.set)+len(key.zset)+len(key.hash) == 0 {
+		delete(a.keys, key.name)
+	}
+}
+
+// setString sets a string key, keeping its expiry time if keepTTL is true
+func (a *aofReplay) setString(name string, value string, expiresAt int64, keepTTL bool) {
+	if old, ok := a.keys[name]; ok && keepTTL {
+		expiresAt = old.expiresAt
+	}
+	a.keys[name] = &redisKey{name: name, kind: redisString, value: value, expiresAt: expiresAt}
+}
+
+// expiry returns the expiry time (in Unix nanoseconds) given by a number of
+// units after now, or at a Unix time in units if absolute is true
+func expiry(arg string, unit time.Duration, absolute bool) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid expiry %q", arg)
+	}
+	if absolute {
+		return n * int64(unit), nil
+	}
+	return time.Now().Add(time.Duration(n) * unit).UnixNano(), nil
+}
+
+// apply replays a command
+func (a *aofReplay) apply(args []string) error {
+	command := strings.ToUpper(args[0])
+	args = args[1:]
+	if min, ok := aofArity[command]; ok && len(args) < min {
+		return errCorruptAOF
+	}
+
+	switch command {
+	case "SELECT":
+		db, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errCorruptAOF
+		}
+		a.db = db
+		return nil
+	case "MULTI", "EXEC":
+		return nil
+	case "FLUSHALL":
+		a.keys = map[string]*redisKey{}
+		return nil
+	}
+	if a.db != a.selected {
+		return nil
+	}
+
+	switch command {
+	case "FLUSHDB":
+		a.keys = map[string]*redisKey{}
+	case "SET":
+		var expiresAt int64
+		keepTTL := false
+		for i := 2; i < len(args); i++ {
+			option := strings.ToUpper(args[i])
+			if unit, ok := setExpiryUnits[option]; ok {
+				if i+1 >= len(args) {
+					return errCorruptAOF
+				}
+				var err error
+				expiresAt, err = expiry(args[i+1], unit, strings.HasSuffix(option, "AT"))
+				if err != nil {
+					return err
+				}
+				i++
+			}
+			keepTTL = keepTTL || option == "KEEPTTL"
+		}
+		a.setString(args[0], args[1], expiresAt, keepTTL)
+	case "SETNX", "GETSET":
+		a.setString(args[0], args[1], 0, false)
+	case "SETEX", "PSETEX":
+		unit := time.Second
+		if command == "PSETEX" {
+			unit = time.Millisecond
+		}
+		expiresAt, err := expiry(args[1], unit, false)
+		if err != nil {
+			return err
+		}
+		a.setString(args[0], args[2], expiresAt, false)
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return errCorruptAOF
+		}
+		for i := 0; i < len(args); i += 2 {
+			a.setString(args[i], args[i+1], 0, false)
+		}
+	case "APPEND":
+		key := a.key(args[0], redisString)
+		key.value += args[1]
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		by := int64(1)
+		if len(args) > 1 {
+			var err error
+			if by, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return errCorruptAOF
+			}
+		}
+		if strings.HasPrefix(command, "DECR") {
+			by = -by
+		}
+		key := a.key(args[0], redisString)
+		n, _ := strconv.ParseInt(key.value, 10, 64)
+		key.value = strconv.FormatInt(n+by, 10)
+	case "DEL", "UNLINK":
+		for _, name := range args {
+			delete(a.keys, name)
+		}
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		unit := time.Second
+		if strings.HasPrefix(command, "P") {
+			unit = time.Millisecond
+		}
+		expiresAt, err := expiry(args[1], unit, strings.HasSuffix(command, "AT"))
+		if err != nil {
+			return err
+		}
+		if key, ok := a.keys[args[0]]; ok {
+			key.expiresAt = expiresAt
+		}
+	case "PERSIST":
+		if key, ok := a.keys[args[0]]; ok {
+			key.expiresAt = 0
+		}
+	case "RENAME", "RENAMENX":
+		if key, ok := a.keys[args[0]]; ok {
+			delete(a.keys, args[0])
+			key.name = args[1]
+			a.keys[args[1]] = key
+		}
+	case "SADD":
+		key := a.key(args[0], redisSet)
+		for _, member := range args[1:] {
+			key.set[member] = true
+		}
+	case "SREM":
+		key := a.key(args[0], redisSet)
+		for _, member := range args[1:] {
+			delete(key.set, member)
+		}
+		a.removeIfEmpty(key)
+	case "ZADD":
+		key := a.key(args[0], redisZSet)
+		incr := false
+		i := 1
+		for ; i < len(args); i++ {
+			option := strings.ToUpper(args[i])
+			if option != "NX" && option != "XX" && option != "GT" && option != "LT" &&
+				option != "CH" && option != "INCR" {
+				break
+			}
+			incr = incr || option == "INCR"
+		}
+		if (len(args)-i)%2 != 0 {
+			return errCorruptAOF
+		}
+		for ; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errCorruptAOF
+			}
+			if incr {
+				score += key.zset[args[i+1]]
+			}
+			key.zset[args[i+1]] = score
+		}
+		a.removeIfEmpty(key)
+	case "ZINCRBY":
+		by, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errCorruptAOF
+		}
+		key := a.key(args[0], redisZSet)
+		key.zset[args[2]] += by
+	case "ZREM":
+		key := a.key(args[0], redisZSet)
+		for _, member := range args[1:] {
+			delete(key.zset, member)
+		}
+		a.removeIfEmpty(key)
+	case "HSET", "HMSET", "HSETNX":
+		if len(args)%2 != 1 {
+			return errCorruptAOF
+		}
+		key := a.key(args[0], redisHash)
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := key.hash[args[i]]; ok && command == "HSETNX" {
+				continue
+			}
+			key.hash[args[i]] = args[i+1]
+		}
+	case "HDEL":
+		key := a.key(args[0], redisHash)
+		for _, field := range args[1:] {
+			delete(key.hash, field)
+		}
+		a.removeIfEmpty(key)
+	case "RPUSH":
+		key := a.key(args[0], redisList)
+		key.list = append(key.list, args[1:]...)
+	case "LPUSH":
+		key := a.key(args[0], redisList)
+		for _, item := range args[1:] {
+			key.list = append([]string{item}, key.list...)
+		}
+	case "RPOP", "LPOP":
+		count := 1
+		if len(args) > 1 {
+			var err error
+			if count, err = strconv.Atoi(args[1]); err != nil || count < 0 {
+				return errCorruptAOF
+			}
+		}
+		key := a.key(args[0], redisList)
+		if count > len(key.list) {
+			count = len(key.list)
+		}
+		if command == "RPOP" {
+			key.list = key.list[:len(key.list)-count]
+		} else {
+			key.list = key.list[count:]
+		}
+		a.removeIfEmpty(key)
+	default:
+		a.skipped[command]++
+	}
+	return nil
+}
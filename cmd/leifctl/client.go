@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// status is the subset of a node's admin status used by leifctl (see
+// `StatusResponse` in the server)
+type status struct {
+	Id           string `json:"id"`
+	State        string `json:"state"`
+	Witness      bool   `json:"witness"`
+	Term         int64  `json:"term"`
+	Leader       string `json:"leader"`
+	LastLogIndex int64  `json:"lastLogIndex"`
+	CommitIndex  int64  `json:"commitIndex"`
+	LastApplied  int64  `json:"lastApplied"`
+	CaughtUp     bool   `json:"caughtUp"`
+	Version      string `json:"version"`
+	Keys         int    `json:"keys"`
+	DiskBytes    int64  `json:"diskBytes"`
+	Peers        []peer `json:"peers"`
+}
+
+// peer is a node's view of another member (see `PeerResponse` in the server).
+// MatchIndex and Lag are only reported by the leader
+type peer struct {
+	Id         string  `json:"id"`
+	Available  bool    `json:"available"`
+	Learner    bool    `json:"learner"`
+	RTTMillis  float64 `json:"rttMillis"`
+	MatchIndex *int64  `json:"matchIndex"`
+	Lag        *int64  `json:"lag"`
+}
+
+// drainStatus is the progress of draining a node (see `node.DrainStatus`)
+type drainStatus struct {
+	Draining          bool     `json:"draining"`
+	Leader            bool     `json:"leader"`
+	PendingWriteBytes int64    `json:"pendingWriteBytes"`
+	Unreplicated      []string `json:"unreplicated"`
+	Drained           bool     `json:"drained"`
+}
+
+// A client makes requests to the HTTP interface of cluster nodes, each
+// identified by its client address ("host:port")
+type client struct {
+	http *http.Client
+	// priority of requests, sent in the X-Leifdb-Priority header if set
+	priority string
+}
+
+func newClient(timeout time.Duration) *client {
+	return &client{http: &http.Client{Timeout: timeout}}
+}
+
+// raw makes a request to a node and returns the response body, or an error for
+// any response other than a 2xx
+func (c *client) raw(method string, endpoint string, path string) ([]byte, error) {
+	return c.request(method, endpoint, path, nil)
+}
+
+// request makes a request to a node with a body (if not nil), and returns the
+// response body, or an error for any response other than a 2xx
+func (c *client) request(method string, endpoint string, path string, body []byte) ([]byte, error) {
+	url := endpoint + path
+	if !strings.Contains(endpoint, "://") {
+		url = "http://" + url
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.priority != "" {
+		req.Header.Set("X-Leifdb-Priority", c.priority)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, responseError(method, url, resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
+
+// A requestError is an unsuccessful response from a node
+type requestError struct {
+	method    string
+	url       string
+	status    int
+	detail    string
+	retryable bool
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.url, e.status, e.detail)
+}
+
+// responseError returns the error for an unsuccessful response
+func responseError(method string, url string, status int, body []byte) error {
+	// error responses have a JSON body with a code and message (see
+	// `ErrorResponse` in the server)
+	var e struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		Retryable bool   `json:"retryable"`
+	}
+	detail := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &e) == nil && e.Code != "" {
+		detail = fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return &requestError{
+		method:    method,
+		url:       url,
+		status:    status,
+		detail:    detail,
+		retryable: e.Retryable}
+}
+
+// download fetches a response body from a node into w, calling progress with
+// the number of bytes written so far and the total (or -1 if not known) as
+// the download goes on, and returns the response headers
+func (c *client) download(
+	endpoint string,
+	path string,
+	w io.Writer,
+	progress func(done int64, total int64)) (http.Header, error) {
+
+	url := endpoint + path
+	if !strings.Contains(endpoint, "://") {
+		url = "http://" + url
+	}
+	// the body may be large, so the download is not subject to the timeout
+	resp, err := (&http.Client{}).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError("GET", url, resp.StatusCode, body)
+	}
+	done := int64(0)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+			done += int64(n)
+			progress(done, resp.ContentLength)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.ContentLength >= 0 && done != resp.ContentLength {
+		return nil, fmt.Errorf("Download ended after %d of %d bytes", done, resp.ContentLength)
+	}
+	return resp.Header, nil
+}
+
+// do makes a request to a node and decodes a JSON response into out (if out
+// is not nil)
+func (c *client) do(method string, endpoint string, path string, out interface{}) error {
+	body, err := c.raw(method, endpoint, path)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *client) status(endpoint string) (*status, error) {
+	var s status
+	if err := c.do("GET", endpoint, "/admin/status", &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *client) drain(endpoint string, transfer bool) (*drainStatus, error) {
+	var s drainStatus
+	path := fmt.Sprintf("/admin/drain?transfer=%t", transfer)
+	if err := c.do("POST", endpoint, path, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *client) resume(endpoint string) error {
+	return c.do("DELETE", endpoint, "/admin/drain", nil)
+}
+
+// demoteResult reports a leader stepping down (see `DemoteResponse` in the
+// server)
+type demoteResult struct {
+	Id           string    `json:"id"`
+	Term         int64     `json:"term"`
+	HoldoffUntil time.Time `json:"holdoffUntil"`
+}
+
+// demote makes the leader at endpoint step down, keeping it from standing for
+// election for holdoff (or the server's default, if 0)
+func (c *client) demote(endpoint string, holdoff time.Duration) (*demoteResult, error) {
+	var r demoteResult
+	path := "/admin/demote"
+	if holdoff > 0 {
+		path += "?holdoff=" + holdoff.String()
+	}
+	if err := c.do("POST", endpoint, path, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// snapshotInfo and snapshotStatus describe the snapshots a node keeps on disk
+// (see `mgmt.SnapshotStatus`)
+type snapshotInfo struct {
+	File        string    `json:"file"`
+	LastApplied int64     `json:"lastApplied"`
+	LastTerm    int64     `json:"lastTerm"`
+	Bytes       int64     `json:"bytes"`
+	Time        time.Time `json:"time"`
+}
+
+type snapshotStatus struct {
+	InProgress bool          `json:"inProgress"`
+	Count      int           `json:"count"`
+	Latest     *snapshotInfo `json:"latest"`
+	LastError  string        `json:"lastError"`
+}
+
+func (c *client) snapshotStatus(endpoint string) (*snapshotStatus, error) {
+	var s snapshotStatus
+	if err := c.do("GET", endpoint, "/admin/snapshot/status", &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// metrics fetches the metrics of a node, by name (metrics with labels are
+// skipped)
+func (c *client) metrics(endpoint string) (map[string]float64, error) {
+	body, err := c.raw("GET", endpoint, "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]float64{}
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") ||
+			strings.Contains(fields[0], "{") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, nil
+}
+
+// locks lists the locks held, as of the last entry applied by the node at
+// endpoint
+func (c *client) locks(endpoint string) ([]lock, error) {
+	var r struct {
+		Locks []lock `json:"locks"`
+	}
+	if err := c.do("GET", endpoint, "/admin/locks", &r); err != nil {
+		return nil, err
+	}
+	return r.Locks, nil
+}
+
+// trashed is the value of a deleted key kept in the trash (see
+// `node.TrashedValue`)
+type trashed struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// trashed returns the value of key in the trash, as of the last entry applied
+// by the node at endpoint
+func (c *client) trashed(endpoint string, key string) (*trashed, error) {
+	var t trashed
+	if err := c.do("GET", endpoint, "/trash/"+url.PathEscape(key), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// restore moves the value of key back out of the trash
+func (c *client) restore(endpoint string, key string) error {
+	return c.do("POST", endpoint, "/trash/"+url.PathEscape(key)+"/restore", nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// demote makes the leader step down without naming a successor, and waits for
+// the cluster to elect another
+func demote(args []string) error {
+	flags := flag.NewFlagSet("demote", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl demote [flags] <endpoint>")
+		flags.PrintDefaults()
+	}
+	holdoff := flags.Duration("holdoff", 0,
+		"Time before the demoted node may stand for election again (0 for the "+
+			"server's default, twice the default election timeout)")
+	wait := flags.Duration("wait", 10*time.Second,
+		"Time to wait for a new leader to be elected (0 to return at once)")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the client address of the leader to demote")
+	}
+
+	c := newClient(*timeout)
+	return demoteLeader(c, flags.Arg(0), *holdoff, *wait, 100*time.Millisecond, os.Stdout)
+}
+
+// demoteLeader demotes the leader at endpoint, then polls it every poll until
+// it reports another leader, for up to wait
+func demoteLeader(
+	c *client,
+	endpoint string,
+	holdoff time.Duration,
+	wait time.Duration,
+	poll time.Duration,
+	out io.Writer) error {
+
+	result, err := c.demote(endpoint, holdoff)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s stepped down as leader in term %d, not standing for election until %s\n",
+		result.Id, result.Term, result.HoldoffUntil.Format(time.RFC3339))
+	if wait <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		s, err := c.status(endpoint)
+		if err == nil && s.State != "Leader" && s.Leader != "" && s.Term > result.Term {
+			fmt.Fprintf(out, "%s elected leader in term %d\n", s.Leader, s.Term)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%v waiting for a new leader", errTimeout)
+		}
+		time.Sleep(poll)
+	}
+}
@@ -0,0 +1,33 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemote(t *testing.T) {
+	c := newFakeCluster(t, 3)
+	c.term = 4
+	client := newClient(time.Second)
+	var out bytes.Buffer
+	err := demoteLeader(client, c.addr(0), 5*time.Second, time.Second, time.Millisecond, &out)
+	if err != nil {
+		t.Fatal("Error demoting leader:", err)
+	}
+	if expected := []string{"demote " + c.addr(0) + " 5s"}; !reflect.DeepEqual(c.events, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, c.events)
+	}
+	if !strings.Contains(out.String(), c.addr(1)+" elected leader in term 5") {
+		t.Errorf("Expected the new leader to be reported, got:\n%s", out.String())
+	}
+
+	// the node demoted is no longer the leader
+	if err := demoteLeader(client, c.addr(0), 0, 0, time.Millisecond, &out); err == nil {
+		t.Error("Expected an error demoting a follower")
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/btmorr/leifdb/retry"
+)
+
+// maxLineBytes is the longest line accepted by load, which bounds the size of
+// a value that can be loaded
+const maxLineBytes = 64 << 20
+
+// record is one line of a dump (see `ExportRecord` in the server)
+type record struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+func dump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of the cluster member to read from")
+	prefix := flags.String("prefix", "", "Only dump keys with this prefix")
+	match := flags.String("match", "", "Only dump keys matching this regular expression")
+	contains := flags.String("contains", "", "Only dump keys whose value contains this string")
+	where := flags.String("where", "",
+		"Only dump keys whose JSON value satisfies this query, such as '$.status == \"active\"'")
+	format := flags.String("format", "ndjson",
+		"Output format: ndjson, csv (key,value,expiresAt), or protobuf (length-delimited ExportRecord messages)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("prefix", *prefix)
+	query.Set("format", *format)
+	for name, value := range map[string]string{"match": *match, "contains": *contains, "where": *where} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return dumpKeys(newClient(5*time.Second), *endpoint, query, os.Stdout, os.Stderr)
+}
+
+// A recordCounter counts the records of an export written through it
+type recordCounter interface {
+	io.Writer
+	records() int
+}
+
+// newRecordCounter returns a recordCounter for an export in format, writing to
+// w
+func newRecordCounter(format string, w io.Writer) recordCounter {
+	switch format {
+	case "csv":
+		return &csvCounter{w: w}
+	case "protobuf":
+		return &delimitedCounter{w: w}
+	}
+	return &lineCounter{w: w}
+}
+
+// lineCounter counts the lines written through it
+type lineCounter struct {
+	w     io.Writer
+	lines int
+}
+
+func (l *lineCounter) Write(p []byte) (int, error) {
+	l.lines += bytes.Count(p, []byte("\n"))
+	return l.w.Write(p)
+}
+
+func (l *lineCounter) records() int {
+	return l.lines
+}
+
+// csvCounter counts the rows after the header of CSV written through it. A
+// quoted field may hold line breaks, so only those outside quotes end a row
+// (an escaped quote, "", toggles twice)
+type csvCounter struct {
+	w      io.Writer
+	quoted bool
+	rows   int
+}
+
+func (c *csvCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch {
+		case b == '"':
+			c.quoted = !c.quoted
+		case b == '\n' && !c.quoted:
+			c.rows++
+		}
+	}
+	return c.w.Write(p)
+}
+
+func (c *csvCounter) records() int {
+	if c.rows == 0 {
+		return 0
+	}
+	return c.rows - 1
+}
+
+// delimitedCounter counts the messages written through it, each preceded by
+// its length as a varint
+type delimitedCounter struct {
+	w        io.Writer
+	messages int
+	// length of the message being read, and the bits of it read so far
+	length uint64
+	shift  uint
+	// bytes of the current message still to come
+	remaining uint64
+}
+
+func (d *delimitedCounter) Write(p []byte) (int, error) {
+	for rest := p; len(rest) > 0; {
+		if d.remaining > 0 {
+			skip := d.remaining
+			if skip > uint64(len(rest)) {
+				skip = uint64(len(rest))
+			}
+			d.remaining -= skip
+			rest = rest[skip:]
+			continue
+		}
+		b := rest[0]
+		rest = rest[1:]
+		d.length |= uint64(b&0x7f) << d.shift
+		d.shift += 7
+		if b < 0x80 {
+			d.messages++
+			d.remaining, d.length, d.shift = d.length, 0, 0
+		}
+	}
+	return d.w.Write(p)
+}
+
+func (d *delimitedCounter) records() int {
+	return d.messages
+}
+
+// dumpKeys writes every key selected by query (the parameters of an export,
+// including its format), and its value, to out, and reports how many there
+// were to log
+func dumpKeys(c *client, endpoint string, query url.Values, out io.Writer, log io.Writer) error {
+	counter := newRecordCounter(query.Get("format"), out)
+	path := "/export?" + query.Encode()
+	if _, err := c.download(endpoint, path, counter, func(int64, int64) {}); err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "Dumped %d keys\n", counter.records())
+	return nil
+}
+
+// A loader writes records to a cluster in batches, at a limited rate
+type loader struct {
+	client    *client
+	endpoint  string
+	batchSize int
+	// keys per second (0 for no limit)
+	rate float64
+	// how writes rejected because the cluster is busy are retried
+	policy retry.Policy
+	log    io.Writer
+	// records waiting to be written, and the number of keys written since
+	// start
+	batch  []record
+	loaded int
+	start  time.Time
+}
+
+func load(args []string) error {
+	flags := flag.NewFlagSet("load", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (writes are redirected to the leader)")
+	batchSize := flags.Int("batch", 100, "Number of keys written in each request")
+	rate := flags.Float64("rate", 1000,
+		"Maximum keys written per second, to leave room for other writes (0 for no limit)")
+	retries := flags.Int("retries", 10,
+		"Number of times to retry a batch rejected because the cluster is busy")
+	priority := flags.String("priority", "batch",
+		"Priority of the writes (system, normal, or batch), which by default "+
+			"wait for interactive writes and use at most half of the pending write budget")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	c := newClient(30 * time.Second)
+	c.priority = *priority
+	l := &loader{
+		client:    c,
+		endpoint:  *endpoint,
+		batchSize: *batchSize,
+		rate:      *rate,
+		policy:    writeRetry(*retries),
+		log:       os.Stderr,
+	}
+	return l.run(os.Stdin)
+}
+
+// run reads records, one JSON object per line, from in and writes them. Keys
+// whose expiry time has passed are skipped, and the rest are given the same
+// expiry time they had when dumped
+func (l *loader) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	skipped := 0
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("Line %d: %v", line, err)
+		}
+		if r.ExpiresAt != 0 && time.Unix(0, r.ExpiresAt).Before(time.Now()) {
+			skipped++
+			continue
+		}
+		if err := l.add(r); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := l.flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(l.log, "\rLoaded %d keys (skipped %d expired)\n", l.loaded, skipped)
+	return nil
+}
+
+// add queues a record to be written, writing the queued records once there
+// is a full batch of them
+func (l *loader) add(r record) error {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.batch = append(l.batch, r)
+	if len(l.batch) >= l.batchSize {
+		return l.flush()
+	}
+	return nil
+}
+
+// flush writes the queued records
+func (l *loader) flush() error {
+	if len(l.batch) == 0 {
+		return nil
+	}
+	if err := l.write(l.batch); err != nil {
+		return err
+	}
+	l.done(len(l.batch))
+	l.batch = l.batch[:0]
+	return nil
+}
+
+// done counts keys written, reports progress, and waits until the average
+// rate is within the limit
+func (l *loader) done(keys int) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.loaded += keys
+	fmt.Fprintf(l.log, "\rLoaded %d keys", l.loaded)
+	if l.rate > 0 {
+		due := l.start.Add(time.Duration(float64(l.loaded) / l.rate * float64(time.Second)))
+		time.Sleep(time.Until(due))
+	}
+}
+
+// write sets the keys in a batch, then restores their expiry times
+func (l *loader) write(batch []record) error {
+	ops := make([]map[string]string, 0, len(batch))
+	for _, r := range batch {
+		ops = append(ops, map[string]string{"op": "set", "key": r.Key, "value": r.Value})
+	}
+	body, err := json.Marshal(map[string]interface{}{"operations": ops})
+	if err != nil {
+		return err
+	}
+	if err := l.retry("POST", "/db/_batch", body); err != nil {
+		return err
+	}
+	for _, r := range batch {
+		if err := l.expire(r.Key, r.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire sets the expiry time (in Unix nanoseconds) of key, if it has one
+func (l *loader) expire(key string, expiresAt int64) error {
+	if expiresAt == 0 {
+		return nil
+	}
+	ttl := time.Until(time.Unix(0, expiresAt))
+	if ttl <= 0 {
+		ttl = time.Nanosecond
+	}
+	return l.retry("PUT", fmt.Sprintf("/ttl/%s?ttl=%s", url.PathEscape(key), ttl), nil)
+}
+
+// writeRetry returns the policy for writes: up to retries more attempts while
+// the cluster responds that it is busy or unavailable, with a backoff from
+// 100ms up to 5s
+func writeRetry(retries int) retry.Policy {
+	return retry.Policy{
+		MaxAttempts: retries + 1,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+		Retryable: func(err error) bool {
+			e, ok := err.(*requestError)
+			return ok && e.retryable
+		},
+	}
+}
+
+// retry makes a request, retrying as set by the loader's policy
+func (l *loader) retry(method string, path string, body []byte) error {
+	return l.policy.Do(context.Background(), func(int) error {
+		_, err := l.client.request(method, l.endpoint, path, body)
+		return err
+	})
+}
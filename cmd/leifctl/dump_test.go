@@ -0,0 +1,134 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/export" || query.Get("prefix") != "app/" || query.Get("contains") != "x" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		fmt.Fprintln(w, `{"key":"app/a","value":"1"}`)
+		fmt.Fprintln(w, `{"key":"app/b","value":"2"}`)
+	}))
+	defer server.Close()
+
+	var out, log bytes.Buffer
+	if err := dumpKeys(newClient(time.Second), server.URL, url.Values{"prefix": {"app/"}, "contains": {"x"}}, &out, &log); err != nil {
+		t.Fatalf("Error dumping keys: %v", err)
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("Expected 2 lines, got:\n%s", out.String())
+	}
+	if log.String() != "Dumped 2 keys\n" {
+		t.Errorf("Unexpected output: %q", log.String())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	batches := [][]map[string]string{}
+	ttls := []string{}
+	busy := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/db/_batch":
+			if busy > 0 {
+				busy--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `{"code":"Busy","error":"busy","retryable":true}`)
+				return
+			}
+			var body struct {
+				Operations []map[string]string `json:"operations"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Error decoding batch: %v", err)
+			}
+			batches = append(batches, body.Operations)
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/ttl/"):
+			ttls = append(ttls, r.URL.Path)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	future := time.Now().Add(time.Hour).UnixNano()
+	past := time.Now().Add(-time.Hour).UnixNano()
+	in := fmt.Sprintf(`{"key":"a","value":"1"}
+{"key":"b","value":"2","expiresAt":%d}
+
+{"key":"c","value":"3","expiresAt":%d}
+{"key":"d","value":"4"}
+`, future, past)
+
+	var log bytes.Buffer
+	l := &loader{
+		client:    newClient(time.Second),
+		endpoint:  server.URL,
+		batchSize: 2,
+		rate:      40,
+		policy:    writeRetry(1),
+		log:       &log,
+	}
+	start := time.Now()
+	if err := l.run(strings.NewReader(in)); err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	// 3 keys at 40 per second
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Errorf("Expected load to be rate limited, took %v", elapsed)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Expected batches of 2 and 1 keys, got %v", batches)
+	}
+	if batches[1][0]["key"] != "d" || batches[1][0]["op"] != "set" {
+		t.Errorf("Unexpected operation %v", batches[1][0])
+	}
+	if len(ttls) != 1 || ttls[0] != "/ttl/b" {
+		t.Errorf("Expected TTL restored for b only, got %v", ttls)
+	}
+	if !strings.HasSuffix(log.String(), "Loaded 3 keys (skipped 1 expired)\n") {
+		t.Errorf("Unexpected output: %q", log.String())
+	}
+}
+
+func TestRecordCounters(t *testing.T) {
+	testCases := []struct {
+		format  string
+		chunks  []string
+		records int
+	}{
+		{"ndjson", []string{`{"key":"a"}` + "\n" + `{"key"`, `:"b"}` + "\n"}, 2},
+		{"csv", []string{"key,value,expiresAt\na,1,\n", `b,"two` + "\n" + `lines",` + "\n", `c,"say ""hi""",` + "\n"}, 3},
+		{"csv", []string{}, 0},
+		// lengths 2 and 200 (a two-byte varint, split across writes)
+		{"protobuf", []string{"\x02ab\xc8", "\x01" + strings.Repeat("x", 150), strings.Repeat("x", 50)}, 2}}
+
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		counter := newRecordCounter(tc.format, &out)
+		for _, chunk := range tc.chunks {
+			counter.Write([]byte(chunk))
+		}
+		if n := counter.records(); n != tc.records {
+			t.Errorf("[%s] Expected %d records, got %d", tc.format, tc.records, n)
+		}
+		if out.String() != strings.Join(tc.chunks, "") {
+			t.Errorf("[%s] Expected output to be passed through", tc.format)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+// Migration from etcd reads keys through the JSON gateway that etcd serves on
+// its client port (/v3/kv/range, /v3/watch), in place of the etcd clientv3
+// package the migration was first specified with: the gateway needs nothing
+// beyond net/http, and carries the same data, but etcd must be run with the
+// gateway enabled (the default, unless --enable-grpc-gateway=false). Keys are
+// first copied at a single revision, then (with -follow) changes after that
+// revision are applied as they are made, until the command is interrupted at
+// cutover. Like etcdctl, it connects over TLS when given a CA certificate or
+// a client certificate (-cacert, -cert, -key), and authenticates as a user
+// (-user) by getting a token from /v3/auth/authenticate, which is sent with
+// each request and renewed when etcd rejects it
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// migrate runs the subcommands of `leifctl migrate`
+var migrate = subcommands("migrate", map[string]command{
+	"etcd": migrateEtcd,
+})
+
+// errCompacted is returned when etcd no longer has the revisions following the
+// copy, so changes since then can't be followed
+var errCompacted = errors.New("etcd has compacted revisions needed to follow changes; copy again")
+
+// An etcdKV is a key and its value, as returned by the gateway (byte fields are
+// base64, which encoding/json decodes, and 64-bit integers are strings)
+type etcdKV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+	Lease       int64  `json:"lease,string"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader `json:"header"`
+	Kvs    []etcdKV   `json:"kvs"`
+	More   bool       `json:"more"`
+}
+
+// An etcdEvent is a change to a key. The type is omitted for puts
+type etcdEvent struct {
+	Type string `json:"type"`
+	Kv   etcdKV `json:"kv"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Header          etcdHeader  `json:"header"`
+		Events          []etcdEvent `json:"events"`
+		CompactRevision int64       `json:"compact_revision,string"`
+		Canceled        bool        `json:"canceled"`
+		CancelReason    string      `json:"cancel_reason"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// An etcdSource reads the keys under a prefix from an etcd cluster
+type etcdSource struct {
+	endpoint string
+	http     *http.Client
+	// secure is set when connecting over TLS
+	secure bool
+	// user and password authenticate requests, if user is set, with token
+	// holding the token from the last authentication
+	user     string
+	password string
+	token    string
+	prefix   []byte
+	// leases holds the expiry time of each lease seen (0 for no expiry)
+	leases map[int64]int64
+}
+
+// prefixEnd returns the end of the range of keys starting with prefix ("\x00"
+// for all keys, as etcd expects)
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// post sends a request to the gateway and decodes the response into out
+func (s *etcdSource) post(ctx context.Context, path string, in interface{}, out interface{}) error {
+	resp, err := s.open(ctx, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// open sends a request to the gateway, and returns the response if
+// successful. If the user's token is rejected, as it is once it expires, the
+// user is authenticated again and the request retried
+func (s *etcdSource) open(ctx context.Context, path string, in interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" && s.token == "" {
+		if err := s.authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := s.send(ctx, path, body)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && s.user != "" {
+		resp.Body.Close()
+		if err := s.authenticate(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = s.send(ctx, path, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd %s: %d %s", path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// send posts a request body to the gateway, with the user's token if set
+func (s *etcdSource) send(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	url := s.endpoint + path
+	if !strings.Contains(s.endpoint, "://") {
+		scheme := "http://"
+		if s.secure {
+			scheme = "https://"
+		}
+		url = scheme + url
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	return s.http.Do(req.WithContext(ctx))
+}
+
+// authenticate gets a token for the user, to be sent with later requests
+func (s *etcdSource) authenticate(ctx context.Context) error {
+	s.token = ""
+	body, err := json.Marshal(map[string]string{"name": s.user, "password": s.password})
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(ctx, "/v3/auth/authenticate", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("etcd authentication as %s: %d %s",
+			s.user, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return err
+	}
+	if auth.Token == "" {
+		return fmt.Errorf("etcd authentication as %s: no token returned (is auth enabled?)", s.user)
+	}
+	s.token = auth.Token
+	return nil
+}
+
+// etcdTLS returns the TLS configuration for connecting to etcd with the given
+// CA certificate and client certificate and key (any may be empty), or nil if
+// none are given
+func etcdTLS(cacert, cert, key string) (*tls.Config, error) {
+	if cacert == "" && cert == "" && key == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if cacert != "" {
+		pem, err := ioutil.ReadFile(cacert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cacert)
+		}
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("-cert and -key must be given together")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// rangePage returns up to limit keys from start, at revision (the latest
+// revision if 0)
+func (s *etcdSource) rangePage(
+	ctx context.Context, start []byte, revision int64, limit int,
+) (*etcdRangeResponse, error) {
+	req := map[string]interface{}{
+		"key":       start,
+		"range_end": prefixEnd(s.prefix),
+		"limit":     limit,
+		"revision":  revision,
+	}
+	var resp etcdRangeResponse
+	if err := s.post(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// expiresAt returns the expiry time of a lease in Unix nanoseconds, 0 if it
+// does not expire, or -1 if it has already expired
+func (s *etcdSource) expiresAt(ctx context.Context, lease int64) (int64, error) {
+	if lease == 0 {
+		return 0, nil
+	}
+	if at, ok := s.leases[lease]; ok {
+		return at, nil
+	}
+	var resp struct {
+		TTL int64 `json:"TTL,string"`
+	}
+	if err := s.post(ctx, "/v3/lease/timetolive", map[string]int64{"ID": lease}, &resp); err != nil {
+		return 0, err
+	}
+	at := int64(-1)
+	if resp.TTL > 0 {
+		at = time.Now().Add(time.Duration(resp.TTL) * time.Second).UnixNano()
+	}
+	s.leases[lease] = at
+	return at, nil
+}
+
+// watch calls handle with each batch of changes after revision, until the
+// stream ends or ctx is cancelled
+func (s *etcdSource) watch(
+	ctx context.Context, revision int64, handle func(resp *etcdWatchResponse) error,
+) error {
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            s.prefix,
+			"range_end":      prefixEnd(s.prefix),
+			"start_revision": revision + 1,
+		},
+	}
+	resp, err := s.open(ctx, "/v3/watch", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var w etcdWatchResponse
+		if err := dec.Decode(&w); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if w.Error != nil {
+			return fmt.Errorf("etcd watch: %s", w.Error.Message)
+		}
+		if w.Result.CompactRevision != 0 {
+			return errCompacted
+		}
+		if w.Result.Canceled {
+			return fmt.Errorf("etcd watch cancelled: %s", w.Result.CancelReason)
+		}
+		if err := handle(&w); err != nil {
+			return err
+		}
+	}
+}
+
+// An etcdMigration copies keys from an etcd cluster to a LeifDB cluster,
+// replacing the source prefix of each key with the target prefix
+type etcdMigration struct {
+	*loader
+	source *etcdSource
+	target string
+	// keys not copied because their value is not valid UTF-8, or their lease
+	// has expired
+	binary  int
+	expired int
+	// revision is the last etcd revision copied
+	revision int64
+}
+
+func migrateEtcd(args []string) error {
+	flags := flag.NewFlagSet("migrate etcd", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl migrate etcd [flags]")
+		fmt.Fprintln(flags.Output(), "\nKeys are read through the JSON gateway on the etcd client port "+
+			"(not the gRPC client), so etcd must serve the gateway (--enable-grpc-gateway, on by default)")
+		flags.PrintDefaults()
+	}
+	etcd := flags.String("etcd", "localhost:2379", "Client address of any etcd cluster member")
+	cacert := flags.String("cacert", "", "CA certificate to verify etcd with, connecting over TLS")
+	cert := flags.String("cert", "", "Client certificate to present to etcd, connecting over TLS")
+	key := flags.String("key", "", "Key of the client certificate")
+	user := flags.String("user", "", "etcd user to authenticate as, as name:password")
+	prefix := flags.String("prefix", "", "Prefix of the etcd keys to copy (all keys if empty)")
+	target := flags.String("target-prefix", "",
+		"Prefix that replaces -prefix in the copied keys (by default, keys are copied unchanged)")
+	follow := flags.Bool("follow", false,
+		"After copying, keep applying changes made in etcd until interrupted (at cutover)")
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (writes are redirected to the leader)")
+	batchSize := flags.Int("batch", 100, "Number of keys read and written in each request")
+	rate := flags.Float64("rate", 1000,
+		"Maximum keys written per second while copying (0 for no limit)")
+	retries := flags.Int("retries", 10,
+		"Number of times to retry a batch rejected because the cluster is busy")
+	priority := flags.String("priority", "batch",
+		"Priority of the writes (system, normal, or batch)")
+	timeout := flags.Duration("timeout", 30*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	remap := false
+	flags.Visit(func(f *flag.Flag) { remap = remap || f.Name == "target-prefix" })
+	if !remap {
+		*target = *prefix
+	}
+	tlsConfig, err := etcdTLS(*cacert, *cert, *key)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	name, password := *user, ""
+	if i := strings.Index(*user, ":"); i >= 0 {
+		name, password = (*user)[:i], (*user)[i+1:]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c := newClient(*timeout)
+	c.priority = *priority
+	m := &etcdMigration{
+		loader: &loader{
+			client:    c,
+			endpoint:  *endpoint,
+			batchSize: *batchSize,
+			rate:      *rate,
+			policy:    writeRetry(*retries),
+			log:       os.Stderr,
+		},
+		// watches stay open until cancelled, so only ctx limits requests
+		source: &etcdSource{
+			endpoint: *etcd,
+			http:     &http.Client{Transport: transport},
+			secure:   tlsConfig != nil,
+			user:     name,
+			password: password,
+			prefix:   []byte(*prefix),
+			leases:   map[int64]int64{},
+		},
+		target: *target,
+	}
+	if err := m.copy(ctx); err != nil {
+		return err
+	}
+	if !*follow {
+		return nil
+	}
+	return m.follow(ctx)
+}
+
+// key returns the LeifDB key for an etcd key
+func (m *etcdMigration) key(etcdKey []byte) string {
+	return m.target + string(etcdKey[len(m.source.prefix):])
+}
+
+// copy copies every key under the prefix, as of the revision of the first
+// read
+func (m *etcdMigration) copy(ctx context.Context) error {
+	start := m.source.prefix
+	if len(start) == 0 {
+		start = []byte{0}
+	}
+	for {
+		page, err := m.source.rangePage(ctx, start, m.revision, m.batchSize)
+		if err != nil {
+			return err
+		}
+		if m.revision == 0 {
+			m.revision = page.Header.Revision
+		}
+		for _, kv := range page.Kvs {
+			if !utf8.Valid(kv.Value) {
+				m.binary++
+				continue
+			}
+			at, err := m.source.expiresAt(ctx, kv.Lease)
+			if err != nil {
+				return err
+			}
+			if at < 0 {
+				m.expired++
+				continue
+			}
+			if err := m.add(record{Key: m.key(kv.Key), Value: string(kv.Value), ExpiresAt: at}); err != nil {
+				return err
+			}
+		}
+		if !page.More || len(page.Kvs) == 0 {
+			break
+		}
+		// continue from just after the last key read
+		start = append(page.Kvs[len(page.Kvs)-1].Key, 0)
+	}
+	if err := m.flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.log, "\rCopied %d keys at revision %d (skipped %d binary, %d expired)\n",
+		m.loaded, m.revision, m.binary, m.expired)
+	return nil
+}
+
+// follow applies changes made after the copy, resuming the watch from the last
+// revision applied whenever the stream ends, until ctx is cancelled
+func (m *etcdMigration) follow(ctx context.Context) error {
+	fmt.Fprintln(m.log, "Following changes (interrupt at cutover)")
+	for {
+		err := m.source.watch(ctx, m.revision, m.apply)
+		if ctx.Err() != nil {
+			fmt.Fprintf(m.log, "\nSynced through revision %d\n", m.revision)
+			return nil
+		}
+		if err == errCompacted {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(m.log, "\nWatch failed (%v), resuming from revision %d\n", err, m.revision)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// apply writes one batch of changes from a watch, in the order they were made
+func (m *etcdMigration) apply(w *etcdWatchResponse) error {
+	ops := make([]map[string]string, 0, len(w.Result.Events))
+	expiring := []record{}
+	for _, event := range w.Result.Events {
+		key := m.key(event.Kv.Key)
+		if event.Type == "DELETE" {
+			ops = append(ops, map[string]string{"op": "delete", "key": key})
+			continue
+		}
+		if !utf8.Valid(event.Kv.Value) {
+			m.binary++
+			continue
+		}
+		ops = append(ops, map[string]string{"op": "set", "key": key, "value": string(event.Kv.Value)})
+		at, err := m.source.expiresAt(context.Background(), event.Kv.Lease)
+		if err != nil {
+			return err
+		}
+		if at > 0 {
+			expiring = append(expiring, record{Key: key, ExpiresAt: at})
+		}
+	}
+	if len(ops) > 0 {
+		body, err := json.Marshal(map[string]interface{}{"operations": ops})
+		if err != nil {
+			return err
+		}
+		if err := m.retry("POST", "/db/_batch", body); err != nil {
+			return err
+		}
+	}
+	for _, r := range expiring {
+		if err := m.expire(r.Key, r.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if len(w.Result.Events) > 0 {
+		m.revision = w.Result.Events[len(w.Result.Events)-1].Kv.ModRevision
+		fmt.Fprintf(m.log, "\rSynced through revision %d", m.revision)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	cases := []struct {
+		prefix   string
+		expected string
+	}{
+		{"app/", "app0"},
+		{"a\xff", "b"},
+		{"\xff\xff", "\x00"},
+		{"", "\x00"},
+	}
+	for _, tc := range cases {
+		if end := string(prefixEnd([]byte(tc.prefix))); end != tc.expected {
+			t.Errorf("Expected end of %q to be %q, got %q", tc.prefix, tc.expected, end)
+		}
+	}
+}
+
+func TestMigrateEtcd(t *testing.T) {
+	kv := func(key, value string, revision int, lease int) map[string]interface{} {
+		return map[string]interface{}{
+			"key":          []byte(key),
+			"value":        []byte(value),
+			"mod_revision": fmt.Sprint(revision),
+			"lease":        fmt.Sprint(lease),
+		}
+	}
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			// two pages, at the revision of the first
+			if req["key"] == "YXBwLw==" { // "app/"
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"header": map[string]string{"revision": "7"},
+					"kvs":    []interface{}{kv("app/a", "1", 3, 0), kv("app/b", "\xff", 4, 0)},
+					"more":   true,
+				})
+				return
+			}
+			if req["revision"] != 7.0 {
+				t.Errorf("Expected later pages at revision 7, got %v", req["revision"])
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"header": map[string]string{"revision": "9"},
+				"kvs":    []interface{}{kv("app/c", "3", 5, 42), kv("app/d", "4", 6, 43)},
+			})
+		case "/v3/lease/timetolive":
+			ttl := map[float64]string{42: "60", 43: "-1"}[req["ID"].(float64)]
+			fmt.Fprintf(w, `{"TTL": %q}`, ttl)
+		case "/v3/watch":
+			create := req["create_request"].(map[string]interface{})
+			if create["start_revision"] != 8.0 {
+				t.Errorf("Expected to watch from revision 8, got %v", create["start_revision"])
+			}
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+			enc.Encode(map[string]interface{}{"result": map[string]interface{}{
+				"events": []interface{}{
+					map[string]interface{}{"kv": kv("app/e", "5", 8, 0)},
+					map[string]interface{}{"type": "DELETE", "kv": kv("app/a", "", 9, 0)},
+				},
+			}})
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer etcd.Close()
+
+	var mu sync.Mutex
+	requests := []string{}
+	synced := make(chan struct{})
+	leif := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, body))
+		mu.Unlock()
+		if bytes.Contains(body, []byte(`"delete"`)) {
+			close(synced)
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer leif.Close()
+
+	var log bytes.Buffer
+	m := &etcdMigration{
+		loader: &loader{client: newClient(time.Second), endpoint: leif.URL, batchSize: 2, log: &log},
+		source: &etcdSource{
+			endpoint: etcd.URL,
+			http:     &http.Client{},
+			prefix:   []byte("app/"),
+			leases:   map[int64]int64{},
+		},
+		target: "svc/",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.copy(ctx); err != nil {
+		t.Fatal("Error copying:", err)
+	}
+	if !strings.Contains(log.String(), "Copied 2 keys at revision 7 (skipped 1 binary, 1 expired)") {
+		t.Errorf("Unexpected output: %q", log.String())
+	}
+
+	done := make(chan error)
+	go func() { done <- m.follow(ctx) }()
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for changes to be applied")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("Error following changes:", err)
+	}
+	if m.revision != 9 {
+		t.Errorf("Expected to be synced through revision 9, got %d", m.revision)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	joined := strings.Join(requests, "\n")
+	for _, request := range []string{
+		`POST /db/_batch {"operations":[{"key":"svc/a","op":"set","value":"1"},{"key":"svc/c","op":"set","value":"3"}]}`,
+		"PUT /ttl/svc/c",
+		`POST /db/_batch {"operations":[{"key":"svc/e","op":"set","value":"5"},{"key":"svc/a","op":"delete"}]}`,
+	} {
+		if !strings.Contains(joined, request) {
+			t.Errorf("Expected request %s, got:\n%s", request, joined)
+		}
+	}
+}
+
+func TestEtcdAuth(t *testing.T) {
+	issued := 0
+	authorized := []string{}
+	etcd := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/auth/authenticate" {
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["name"] != "root" || req["password"] != "se:cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			issued++
+			fmt.Fprintf(w, `{"token": "token%d"}`, issued)
+			return
+		}
+		// the first token expires after one request
+		token := r.Header.Get("Authorization")
+		if token == "" || (token == "token1" && len(authorized) > 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "etcdserver: invalid auth token"}`)
+			return
+		}
+		authorized = append(authorized, token)
+		fmt.Fprint(w, `{"header": {"revision": "3"}}`)
+	}))
+	defer etcd.Close()
+
+	// verify the server with its certificate, as a CA certificate file
+	cacert := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: etcd.Certificate().Raw}
+	if err := ioutil.WriteFile(cacert, pem.EncodeToMemory(block), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := etcdTLS(cacert, "", "")
+	if err != nil {
+		t.Fatal("Error loading CA certificate:", err)
+	}
+	if _, err := etcdTLS("", cacert, ""); err == nil {
+		t.Error("Expected an error for a certificate without a key")
+	}
+
+	source := &etcdSource{
+		endpoint: strings.TrimPrefix(etcd.URL, "https://"),
+		http:     &http.Client{Transport: &http.Transport{TLSClientConfig: config}},
+		secure:   true,
+		user:     "root",
+		password: "se:cret",
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := source.rangePage(context.Background(), []byte("a"), 0, 10); err != nil {
+			t.Fatal("Error reading range:", err)
+		}
+	}
+	if fmt.Sprint(authorized) != "[token1 token2]" {
+		t.Errorf("Expected the expired token to be renewed, got requests with %v", authorized)
+	}
+
+	source.password = "wrong"
+	source.token = ""
+	if _, err := source.rangePage(context.Background(), []byte("a"), 0, 10); err == nil ||
+		!strings.Contains(err.Error(), "authentication as root") {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of Redis key
+const (
+	redisString = "string"
+	redisList   = "list"
+	redisSet    = "set"
+	redisZSet   = "zset"
+	redisHash   = "hash"
+)
+
+// A redisKey is the contents of a key read from a Redis dump or append-only
+// file. Only the field for its kind is set
+type redisKey struct {
+	name  string
+	kind  string
+	value string
+	list  []string
+	set   map[string]bool
+	zset  map[string]float64
+	hash  map[string]string
+	// expiry time in Unix nanoseconds (0 if the key does not expire)
+	expiresAt int64
+}
+
+func newRedisSet(name string, members []string) *redisKey {
+	key := &redisKey{name: name, kind: redisSet, set: map[string]bool{}}
+	for _, member := range members {
+		key.set[member] = true
+	}
+	return key
+}
+
+// newRedisZSet builds a sorted set from alternating members and scores
+func newRedisZSet(name string, items []string) (*redisKey, error) {
+	if len(items)%2 != 0 {
+		return nil, errCorruptRDB
+	}
+	key := &redisKey{name: name, kind: redisZSet, zset: map[string]float64{}}
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			return nil, errCorruptRDB
+		}
+		key.zset[items[i]] = score
+	}
+	return key, nil
+}
+
+// newRedisHash builds a hash from alternating fields and values
+func newRedisHash(name string, items []string) (*redisKey, error) {
+	if len(items)%2 != 0 {
+		return nil, errCorruptRDB
+	}
+	key := &redisKey{name: name, kind: redisHash, hash: map[string]string{}}
+	for i := 0; i < len(items); i += 2 {
+		key.hash[items[i]] = items[i+1]
+	}
+	return key, nil
+}
+
+// scoredMember is a member of a sorted set, as sent to /zset (see
+// `ScoredMember` in the server)
+type scoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// An importer writes the keys of one Redis database to a cluster
+type importer struct {
+	*loader
+	db int
+	// keys not imported because they had expired, were in another database,
+	// or were of a type that can't be imported
+	expired     int
+	otherDB     int
+	unsupported int
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl import [flags] <file>...")
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "rdb",
+		"Format of the files: rdb (Redis dumps) or aof (the parts of a Redis append-only "+
+			"file, in order, each with or without an RDB preamble)")
+	db := flags.Int("db", 0, "Number of the Redis database to import (others are skipped)")
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (writes are redirected to the leader)")
+	batchSize := flags.Int("batch", 100, "Number of keys (or set members) written in each request")
+	rate := flags.Float64("rate", 1000,
+		"Maximum keys written per second, to leave room for other writes (0 for no limit)")
+	retries := flags.Int("retries", 10,
+		"Number of times to retry a batch rejected because the cluster is busy")
+	priority := flags.String("priority", "batch",
+		"Priority of the writes (system, normal, or batch)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("Expected the files to import")
+	}
+	if *format != "rdb" && *format != "aof" {
+		return fmt.Errorf("Unknown format %q, expected rdb or aof", *format)
+	}
+	files := make([]*bufio.Reader, 0, flags.NArg())
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, bufio.NewReader(f))
+	}
+
+	c := newClient(30 * time.Second)
+	c.priority = *priority
+	im := &importer{
+		loader: &loader{
+			client:    c,
+			endpoint:  *endpoint,
+			batchSize: *batchSize,
+			rate:      *rate,
+			policy:    writeRetry(*retries),
+			log:       os.Stderr,
+		},
+		db: *db,
+	}
+	return im.run(files, *format)
+}
+
+// run reads files in format, and imports their keys
+func (im *importer) run(files []*bufio.Reader, format string) error {
+	if format == "aof" {
+		if err := im.replayAOF(files); err != nil {
+			return err
+		}
+	} else {
+		for i, r := range files {
+			unsupported, err := readRDB(r, im.emit)
+			im.unsupported += unsupported
+			if err != nil {
+				return fmt.Errorf("File %d: %w", i+1, err)
+			}
+		}
+	}
+	if err := im.flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(im.log, "\rImported %d keys (skipped %d expired, %d in other databases, %d streams)\n",
+		im.loaded, im.expired, im.otherDB, im.unsupported)
+	return nil
+}
+
+// emit imports a key from database db. Strings are set as they are, and lists
+// and hashes are set as JSON arrays and objects (which can be indexed, see
+// /index); sets and sorted sets are added with their members
+func (im *importer) emit(db int, key *redisKey) error {
+	if db != im.db {
+		im.otherDB++
+		return nil
+	}
+	if key.expiresAt != 0 && time.Unix(0, key.expiresAt).Before(time.Now()) {
+		im.expired++
+		return nil
+	}
+	r := record{Key: key.name, Value: key.value, ExpiresAt: key.expiresAt}
+	switch key.kind {
+	case redisString:
+		return im.add(r)
+	case redisList, redisHash:
+		var encoded []byte
+		var err error
+		if key.kind == redisList {
+			encoded, err = json.Marshal(key.list)
+		} else {
+			encoded, err = json.Marshal(key.hash)
+		}
+		if err != nil {
+			return err
+		}
+		r.Value = string(encoded)
+		return im.add(r)
+	}
+
+	// members are added in requests of up to a batch each
+	members := []interface{}{}
+	if key.kind == redisSet {
+		for member := range key.set {
+			members = append(members, member)
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].(string) < members[j].(string) })
+	} else {
+		for member, score := range key.zset {
+			// LeifDB only takes finite scores, so infinities become the
+			// largest finite scores, which sort the same way
+			if math.IsNaN(score) {
+				continue
+			}
+			if math.IsInf(score, 0) {
+				score = math.Copysign(math.MaxFloat64, score)
+			}
+			members = append(members, scoredMember{Member: member, Score: score})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].(scoredMember).Member < members[j].(scoredMember).Member
+		})
+	}
+	path := "/" + key.kind + "/" + url.PathEscape(key.name)
+	for start := 0; start < len(members); start += im.batchSize {
+		end := start + im.batchSize
+		if end > len(members) {
+			end = len(members)
+		}
+		body, err := json.Marshal(map[string]interface{}{"members": members[start:end]})
+		if err != nil {
+			return err
+		}
+		if err := im.retry("PUT", path, body); err != nil {
+			return err
+		}
+	}
+	if err := im.expire(key.name, key.expiresAt); err != nil {
+		return err
+	}
+	im.done(1)
+	return nil
+}
@@ -0,0 +1,111 @@
+// +build unit
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resp encodes commands as they are written to an append-only file
+func resp(commands ...[]string) string {
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	return b.String()
+}
+
+func TestImportAOF(t *testing.T) {
+	requests := []string{}
+	values := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Leifdb-Priority") != "batch" {
+			t.Errorf("Expected batch priority, got %q", r.Header.Get("X-Leifdb-Priority"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/db/_batch" {
+			var batch struct {
+				Operations []map[string]string `json:"operations"`
+			}
+			json.Unmarshal(body, &batch)
+			for _, op := range batch.Operations {
+				values[op["key"]] = op["value"]
+			}
+		}
+		requests = append(requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, body))
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	future := fmt.Sprint(time.Now().Add(time.Hour).UnixNano() / 1e6)
+	aof := resp(
+		[]string{"SELECT", "0"},
+		[]string{"SET", "a", "1"},
+		[]string{"SET", "b", "2", "PXAT", future},
+		[]string{"INCRBY", "a", "41"},
+		[]string{"SET", "gone", "x"},
+		[]string{"DEL", "gone"},
+		[]string{"SET", "stale", "x", "PXAT", "1000"},
+		[]string{"SADD", "tags", "red", "blue"},
+		[]string{"ZADD", "board", "1.5", "alice", "2", "bob"},
+		[]string{"ZREM", "board", "bob"},
+		[]string{"HSET", "user", "name", "carol"},
+		[]string{"RPUSH", "queue", "x", "y"},
+		[]string{"LPUSH", "queue", "w"},
+		[]string{"PUBLISH", "channel", "hi"},
+		[]string{"SELECT", "1"},
+		[]string{"SET", "elsewhere", "x"})
+
+	c := newClient(time.Second)
+	c.priority = "batch"
+	var log bytes.Buffer
+	im := &importer{loader: &loader{client: c, endpoint: server.URL, batchSize: 10, log: &log}}
+	if err := im.run([]*bufio.Reader{bufio.NewReader(strings.NewReader(aof))}, "aof"); err != nil {
+		t.Fatalf("Error importing: %v", err)
+	}
+
+	expected := map[string]string{
+		"a":     "42",
+		"b":     "2",
+		"user":  `{"name":"carol"}`,
+		"queue": `["w","x","y"]`}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, values[key])
+		}
+	}
+	if _, ok := values["gone"]; ok {
+		t.Error("Expected deleted key not to be imported")
+	}
+	sort.Strings(requests)
+	joined := strings.Join(requests, "\n")
+	for _, request := range []string{
+		`PUT /set/tags {"members":["blue","red"]}`,
+		`PUT /zset/board {"members":[{"member":"alice","score":1.5}]}`,
+		"PUT /ttl/b"} {
+		if !strings.Contains(joined, request) {
+			t.Errorf("Expected request %s, got:\n%s", request, joined)
+		}
+	}
+	if !strings.Contains(log.String(), "Imported 6 keys (skipped 1 expired, 0 in other databases, 0 streams)") ||
+		!strings.Contains(log.String(), "Commands not replayed: PUBLISH (1)") {
+		t.Errorf("Unexpected output: %q", log.String())
+	}
+
+	if err := im.run([]*bufio.Reader{bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n"))}, "aof"); err == nil {
+		t.Error("Expected an error importing a truncated file")
+	}
+}
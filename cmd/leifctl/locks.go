@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// lock is an advisory lock and its holder (see `node.Lock`)
+type lock struct {
+	Name       string `json:"name"`
+	Owner      string `json:"owner"`
+	AcquiredAt int64  `json:"acquiredAt"`
+	LeaseMs    int64  `json:"leaseMs"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// locksCommand prints the locks held, as seen by the node at the endpoint, as
+// a table or as JSON
+func locksCommand(args []string) error {
+	flags := flag.NewFlagSet("locks", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member")
+	output := flags.String("o", "table", "Output format: table or json")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q, expected table or json", *output)
+	}
+
+	locks, err := newClient(*timeout).locks(*endpoint)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(locks)
+	}
+	renderLocks(os.Stdout, locks, time.Now())
+	return nil
+}
+
+// renderLocks writes a table with a row for each lock, with how long it has
+// been held and how long is left of its lease as of now
+func renderLocks(w io.Writer, locks []lock, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tOWNER\tACQUIRED\tHELD\tLEASE\tEXPIRES IN")
+	for _, l := range locks {
+		acquired := time.Unix(0, l.AcquiredAt)
+		left := time.Unix(0, l.ExpiresAt).Sub(now)
+		if left < 0 {
+			left = 0
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			l.Name, l.Owner, acquired.UTC().Format(time.RFC3339),
+			now.Sub(acquired).Round(time.Second),
+			(time.Duration(l.LeaseMs) * time.Millisecond).String(),
+			left.Round(time.Second))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,43 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	acquired := now.Add(-90 * time.Second).UnixNano()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/locks" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"locks": [{"name": "nightly", "owner": "host-a", "acquiredAt": %d, "leaseMs": 60000, "expiresAt": %d}]}`,
+			acquired, now.Add(30*time.Second).UnixNano())
+	}))
+	defer server.Close()
+
+	locks, err := newClient(time.Second).locks(server.URL)
+	if err != nil {
+		t.Fatal("Error listing locks:", err)
+	}
+	if len(locks) != 1 || locks[0].Name != "nightly" || locks[0].Owner != "host-a" {
+		t.Fatalf("Unexpected locks %+v", locks)
+	}
+
+	var out bytes.Buffer
+	renderLocks(&out, locks, now)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := "nightly host-a 2020-07-01T11:58:30Z 1m30s 1m0s 30s"
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != expected {
+		t.Errorf("Expected row %q, got:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,86 @@
+// leifctl is a command-line tool for operating a LeifDB cluster, using the
+// HTTP interface of each node
+//
+// Usage:
+//
+//	leifctl <command> [flags]
+//
+// Run `leifctl <command> -h` for the flags accepted by each command
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A command runs a leifctl subcommand with the arguments following its name
+type command func(args []string) error
+
+var commands = map[string]command{
+	"backup":          backup,
+	"demote":          demote,
+	"dump":            dump,
+	"import":          importCommand,
+	"load":            load,
+	"locks":           locksCommand,
+	"member":          member,
+	"migrate":         migrate,
+	"restore-key":     restoreKeyCommand,
+	"snapshot":        snapshot,
+	"status":          statusCommand,
+	"rolling-restart": rollingRestart,
+	"top":             top,
+}
+
+// subcommands returns a command that runs one of cmds, named by its first
+// argument
+func subcommands(name string, cmds map[string]command) command {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return func(args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("Expected a %s command: %s", name, strings.Join(names, ", "))
+		}
+		cmd, ok := cmds[args[0]]
+		if !ok {
+			return fmt.Errorf("Unknown %s command %q, expected one of: %s",
+				name, args[0], strings.Join(names, ", "))
+		}
+		return cmd(args[1:])
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: leifctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
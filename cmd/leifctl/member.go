@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// member runs the subcommands of `leifctl member`
+var member = subcommands("member", map[string]command{
+	"list":    memberList,
+	"add":     memberAdd,
+	"remove":  memberRemove,
+	"set":     memberSet,
+	"promote": memberPromote,
+})
+
+// errAborted indicates that the operator did not confirm a change
+var errAborted = errors.New("Aborted")
+
+// confirmChange asks the operator to confirm a change to the voters of the
+// cluster, unless force is set, and returns errAborted unless they answer yes
+func confirmChange(force bool, in io.Reader, out io.Writer, question string) error {
+	if force {
+		return nil
+	}
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errAborted
+}
+
+// memberList prints the members of the cluster as seen by the leader (or by
+// the node at the endpoint, if it does not know the leader)
+func memberList(args []string) error {
+	flags := flag.NewFlagSet("member list", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c := newClient(*timeout)
+	s, err := c.status(*endpoint)
+	if err != nil {
+		return err
+	}
+	if s.State != "Leader" && s.Leader != "" {
+		// replication progress is only known to the leader
+		if leader, err := c.status(s.Leader); err == nil {
+			s = leader
+		}
+	}
+	renderMembers(os.Stdout, s)
+	return nil
+}
+
+// renderMembers writes a table with a row for the node that reported s, and
+// one for each of its peers
+func renderMembers(w io.Writer, s *status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tROLE\tAVAILABLE\tRTT\tMATCH\tLAG")
+	role := s.State
+	if s.Witness {
+		role += " (witness)"
+	}
+	match, lag := "-", "-"
+	if s.State == "Leader" {
+		match, lag = fmt.Sprint(s.LastLogIndex), "0"
+	}
+	fmt.Fprintf(tw, "%s\t%s\tyes\t-\t%s\t%s\n", s.Id, role, match, lag)
+
+	peers := append([]peer{}, s.Peers...)
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Id < peers[j].Id })
+	for _, p := range peers {
+		available, rtt, match, lag := "no", "-", "-", "-"
+		if p.Available {
+			available = "yes"
+		}
+		if p.RTTMillis > 0 {
+			rtt = fmt.Sprintf("%.1fms", p.RTTMillis)
+		}
+		if p.MatchIndex != nil {
+			match = fmt.Sprint(*p.MatchIndex)
+		}
+		if p.Lag != nil {
+			lag = fmt.Sprint(*p.Lag)
+		}
+		role := "-"
+		if p.Learner {
+			role = "(learner)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Id, role, available, rtt, match, lag)
+	}
+	tw.Flush()
+}
+
+// bootstrapStatus is the progress of bootstrapping a new member (see
+// `node.BootstrapStatus`)
+type bootstrapStatus struct {
+	Member        string `json:"member"`
+	Phase         string `json:"phase"`
+	SnapshotIndex int64  `json:"snapshotIndex"`
+	SnapshotBytes int64  `json:"snapshotBytes"`
+	SentBytes     int64  `json:"sentBytes"`
+	MatchIndex    int64  `json:"matchIndex"`
+	CommitIndex   int64  `json:"commitIndex"`
+	Error         string `json:"error"`
+}
+
+// memberAdd adds a member to the cluster through the leader, which bootstraps
+// it from a snapshot, and waits for it to be promoted to a voter (or, with
+// -learner, to catch up). Adding a voter is confirmed first, unless -force is
+// given
+func memberAdd(args []string) error {
+	flags := flag.NewFlagSet("member add", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl member add [flags] <raft address>")
+		flags.PrintDefaults()
+	}
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (the request is redirected to the leader)")
+	wait := flags.Duration("wait", 10*time.Minute,
+		"Time to wait for the member to be promoted (0 to return at once)")
+	learner := flags.Bool("learner", false,
+		"Leave the member a learner once it has caught up, until promoted with member promote")
+	force := flags.Bool("force", false, "Add the member as a voter without asking for confirmation")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the raft address of the new member")
+	}
+	if !*learner {
+		question := fmt.Sprintf("Add %s as a voter, counting toward the quorum once it has caught up?", flags.Arg(0))
+		if err := confirmChange(*force, os.Stdin, os.Stdout, question); err != nil {
+			return err
+		}
+	}
+
+	c := newClient(*timeout)
+	return addMember(c, *endpoint, flags.Arg(0), *learner, *wait, time.Second, os.Stdout)
+}
+
+// addMember adds the member at address through the node at endpoint, then
+// polls the leader's bootstrap progress every poll until the member is
+// promoted (or has caught up, if it is added as a learner) or fails, for up to
+// wait
+func addMember(
+	c *client,
+	endpoint string,
+	address string,
+	learner bool,
+	wait time.Duration,
+	poll time.Duration,
+	out io.Writer) error {
+
+	leader := endpoint
+	if s, err := c.status(endpoint); err == nil && s.State != "Leader" && s.Leader != "" {
+		leader = s.Leader
+	}
+	body, _ := json.Marshal(map[string]interface{}{"address": address, "learner": learner})
+	if _, err := c.request("POST", leader, "/admin/members", body); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Added %s, sending it a snapshot\n", address)
+	if wait <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(wait)
+	phase := ""
+	for {
+		var r struct {
+			Members []bootstrapStatus `json:"members"`
+		}
+		if err := c.do("GET", leader, "/admin/members/bootstrap", &r); err != nil {
+			return err
+		}
+		for _, b := range r.Members {
+			if b.Member != address {
+				continue
+			}
+			switch b.Phase {
+			case "promoted":
+				fmt.Fprintf(out, "%s caught up at index %d, promoted to voter\n", address, b.MatchIndex)
+				return nil
+			case "caught-up":
+				if learner {
+					fmt.Fprintf(out, "%s caught up at index %d, left a learner\n", address, b.MatchIndex)
+					return nil
+				}
+			case "failed":
+				return fmt.Errorf("Failed to bootstrap %s: %s", address, b.Error)
+			case "catching-up":
+				if phase != b.Phase {
+					fmt.Fprintf(out, "Sent snapshot as of index %d (%d bytes), catching up\n",
+						b.SnapshotIndex, b.SnapshotBytes)
+				}
+			}
+			phase = b.Phase
+		}
+		if time.Now().After(deadline) {
+			if learner {
+				return fmt.Errorf("%v waiting for %s to catch up", errTimeout, address)
+			}
+			return fmt.Errorf("%v waiting for %s to be promoted", errTimeout, address)
+		}
+		time.Sleep(poll)
+	}
+}
+
+// memberRemove removes a member from the cluster through the leader, once
+// confirmed (or with -force), and waits for the membership without it to be
+// committed
+func memberRemove(args []string) error {
+	flags := flag.NewFlagSet("member remove", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl member remove [flags] <raft address>")
+		flags.PrintDefaults()
+	}
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (the request is redirected to the leader)")
+	force := flags.Bool("force", false, "Remove the member without asking for confirmation")
+	timeout := flags.Duration("timeout", 30*time.Second, "Time to wait for the removal to be committed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the raft address of the member to remove")
+	}
+
+	if err := confirmChange(*force, os.Stdin, os.Stdout, fmt.Sprintf("Remove %s from the voters of the cluster?", flags.Arg(0))); err != nil {
+		return err
+	}
+
+	c := newClient(*timeout)
+	return removeMember(c, *endpoint, flags.Arg(0), os.Stdout)
+}
+
+// removeMember removes the member at address through the node at endpoint,
+// and prints the remaining members
+func removeMember(c *client, endpoint string, address string, out io.Writer) error {
+	leader := endpoint
+	if s, err := c.status(endpoint); err == nil && s.State != "Leader" && s.Leader != "" {
+		leader = s.Leader
+	}
+	body, err := c.request("DELETE", leader, "/admin/members/"+url.PathEscape(address), nil)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Members []string `json:"members"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Removed %s, members are now %s\n", address, strings.Join(r.Members, ", "))
+	return nil
+}
+
+// memberPromote promotes a learner to a voter through the leader, once
+// confirmed (or with -force), and waits for the membership including it to be
+// committed
+func memberPromote(args []string) error {
+	flags := flag.NewFlagSet("member promote", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl member promote [flags] <raft address>")
+		flags.PrintDefaults()
+	}
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (the request is redirected to the leader)")
+	force := flags.Bool("force", false, "Promote the learner without asking for confirmation")
+	timeout := flags.Duration("timeout", 30*time.Second, "Time to wait for the promotion to be committed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the raft address of the learner to promote")
+	}
+
+	if err := confirmChange(*force, os.Stdin, os.Stdout, fmt.Sprintf("Promote %s to a voter, counting toward the quorum?", flags.Arg(0))); err != nil {
+		return err
+	}
+
+	c := newClient(*timeout)
+	return promoteMember(c, *endpoint, flags.Arg(0), os.Stdout)
+}
+
+// promoteMember promotes the learner at address through the node at endpoint,
+// and prints the new members
+func promoteMember(c *client, endpoint string, address string, out io.Writer) error {
+	leader := endpoint
+	if s, err := c.status(endpoint); err == nil && s.State != "Leader" && s.Leader != "" {
+		leader = s.Leader
+	}
+	body, err := c.request("POST", leader, "/admin/members/"+url.PathEscape(address)+"/promote", nil)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Members []string `json:"members"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Promoted %s, members are now %s\n", address, strings.Join(r.Members, ", "))
+	return nil
+}
+
+// memberSet changes the members of the cluster to a new set through the leader,
+// which may add and remove several members at once, once confirmed (or with
+// -force), and waits for the new membership to be committed
+func memberSet(args []string) error {
+	flags := flag.NewFlagSet("member set", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl member set [flags] <raft address>...")
+		flags.PrintDefaults()
+	}
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (the request is redirected to the leader)")
+	force := flags.Bool("force", false, "Change the members without asking for confirmation")
+	timeout := flags.Duration("timeout", 10*time.Minute,
+		"Time to wait for new members to be bootstrapped and the change to be committed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("Expected the raft address of every member after the change")
+	}
+
+	if err := confirmChange(*force, os.Stdin, os.Stdout, fmt.Sprintf("Change the voters of the cluster to %s, removing any others?", strings.Join(flags.Args(), ", "))); err != nil {
+		return err
+	}
+
+	c := newClient(*timeout)
+	return setMembers(c, *endpoint, flags.Args(), os.Stdout)
+}
+
+// setMembers changes the members to those at addresses through the node at
+// endpoint, and prints the new members
+func setMembers(c *client, endpoint string, addresses []string, out io.Writer) error {
+	leader := endpoint
+	if s, err := c.status(endpoint); err == nil && s.State != "Leader" && s.Leader != "" {
+		leader = s.Leader
+	}
+	body, _ := json.Marshal(map[string][]string{"members": addresses})
+	body, err := c.request("PUT", leader, "/admin/members", body)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Members []string `json:"members"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Members are now %s\n", strings.Join(r.Members, ", "))
+	return nil
+}
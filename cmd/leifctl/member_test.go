@@ -0,0 +1,250 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMembers(t *testing.T) {
+	match, lag := int64(40), int64(2)
+	s := &status{
+		Id:           "a:16990",
+		State:        "Leader",
+		LastLogIndex: 42,
+		Peers: []peer{
+			{Id: "c:16990"},
+			{Id: "d:16990", Learner: true},
+			{Id: "b:16990", Available: true, RTTMillis: 1.25, MatchIndex: &match, Lag: &lag},
+		}}
+
+	var out bytes.Buffer
+	renderMembers(&out, s)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		"ID ROLE AVAILABLE RTT MATCH LAG",
+		"a:16990 Leader yes - 42 0",
+		"b:16990 - yes 1.2ms 40 2",
+		"c:16990 - no - - -",
+		"d:16990 (learner) no - - -",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected), out.String())
+	}
+	for i, line := range lines {
+		if fields := strings.Join(strings.Fields(line), " "); fields != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], fields)
+		}
+	}
+}
+
+func TestAddMember(t *testing.T) {
+	polls := 0
+	var added string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /admin/status":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "POST /admin/members":
+			body, _ := ioutil.ReadAll(r.Body)
+			added = string(body)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"member":"d:16990","phase":"snapshot"}`)
+		case "GET /admin/members/bootstrap":
+			polls++
+			phase := "catching-up"
+			if polls > 2 {
+				phase = "promoted"
+			}
+			fmt.Fprintf(w, `{"members":[{"member":"d:16990","phase":%q,"snapshotIndex":7,"matchIndex":9}]}`, phase)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := addMember(newClient(time.Second), server.URL, "d:16990", false, time.Second, time.Millisecond, &out)
+	if err != nil {
+		t.Fatal("Error adding member:", err)
+	}
+	if added != `{"address":"d:16990","learner":false}` {
+		t.Errorf("Unexpected request body %s", added)
+	}
+	if !strings.Contains(out.String(), "catching up") || !strings.Contains(out.String(), "promoted to voter") {
+		t.Errorf("Expected progress to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestAddLearner(t *testing.T) {
+	var added string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /admin/status":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "POST /admin/members":
+			body, _ := ioutil.ReadAll(r.Body)
+			added = string(body)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"member":"d:16990","phase":"snapshot"}`)
+		case "GET /admin/members/bootstrap":
+			fmt.Fprint(w, `{"members":[{"member":"d:16990","phase":"caught-up","matchIndex":9}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := addMember(newClient(time.Second), server.URL, "d:16990", true, time.Second, time.Millisecond, &out)
+	if err != nil {
+		t.Fatal("Error adding learner:", err)
+	}
+	if added != `{"address":"d:16990","learner":true}` {
+		t.Errorf("Unexpected request body %s", added)
+	}
+	if !strings.Contains(out.String(), "caught up at index 9, left a learner") {
+		t.Errorf("Expected the learner to be reported caught up, got:\n%s", out.String())
+	}
+}
+
+func TestPromoteMember(t *testing.T) {
+	var promoted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "POST":
+			promoted = r.URL.Path
+			fmt.Fprint(w, `{"members":["a:16990","b:16990","d:16990"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := promoteMember(newClient(time.Second), server.URL, "d:16990", &out); err != nil {
+		t.Fatal("Error promoting member:", err)
+	}
+	if promoted != "/admin/members/d:16990/promote" {
+		t.Errorf("Unexpected request path %s", promoted)
+	}
+	if !strings.Contains(out.String(), "members are now a:16990, b:16990, d:16990") {
+		t.Errorf("Expected the new members to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	var removed string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "DELETE":
+			removed = r.URL.Path
+			fmt.Fprint(w, `{"members":["a:16990","b:16990"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := removeMember(newClient(time.Second), server.URL, "c:16990", &out); err != nil {
+		t.Fatal("Error removing member:", err)
+	}
+	if removed != "/admin/members/c:16990" {
+		t.Errorf("Unexpected request path %s", removed)
+	}
+	if !strings.Contains(out.String(), "members are now a:16990, b:16990") {
+		t.Errorf("Expected the remaining members to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestSetMembers(t *testing.T) {
+	var requested struct {
+		Members []string `json:"members"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "PUT":
+			json.NewDecoder(r.Body).Decode(&requested)
+			fmt.Fprint(w, `{"members":["a:16990","d:16990","e:16990"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	members := []string{"a:16990", "d:16990", "e:16990"}
+	if err := setMembers(newClient(time.Second), server.URL, members, &out); err != nil {
+		t.Fatal("Error setting members:", err)
+	}
+	if strings.Join(requested.Members, ",") != "a:16990,d:16990,e:16990" {
+		t.Errorf("Unexpected request %+v", requested)
+	}
+	if !strings.Contains(out.String(), "Members are now a:16990, d:16990, e:16990") {
+		t.Errorf("Expected the new members to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestConfirmChange(t *testing.T) {
+	for _, tc := range []struct {
+		force    bool
+		answer   string
+		expected error
+	}{
+		{false, "y\n", nil},
+		{false, "YES\n", nil},
+		{false, "yes", nil},
+		{false, "n\n", errAborted},
+		{false, "\n", errAborted},
+		{false, "", errAborted},
+		{true, "", nil},
+	} {
+		var out bytes.Buffer
+		err := confirmChange(tc.force, strings.NewReader(tc.answer), &out, "Remove c:16990?")
+		if err != tc.expected {
+			t.Errorf("Expected %v answering %q (force %t), got %v", tc.expected, tc.answer, tc.force, err)
+		}
+		if prompted := out.String() == "Remove c:16990? [y/N]: "; prompted == tc.force {
+			t.Errorf("Expected to be asked only without force, got %q (force %t)", out.String(), tc.force)
+		}
+	}
+}
+
+func TestRemoveMemberForce(t *testing.T) {
+	removed := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"state":"Leader"}`)
+		case "DELETE":
+			removed = r.URL.Path
+			fmt.Fprint(w, `{"members":["a:16990","b:16990"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// with -force, the change is made without reading an answer
+	if err := memberRemove([]string{"-force", "-endpoint", server.URL, "c:16990"}); err != nil {
+		t.Fatal("Error removing member:", err)
+	}
+	if removed != "/admin/members/c:16990" {
+		t.Errorf("Expected the member to be removed, got request path %q", removed)
+	}
+}
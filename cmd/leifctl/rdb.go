@@ -0,0 +1,849 @@
+package main
+
+// A reader for Redis RDB files (versions 1 through 12), for `leifctl import`.
+// Strings, lists, sets, sorted sets, and hashes are decoded in all of their
+// encodings, including the compact ones (ziplists, listpacks, intsets, and
+// zipmaps) that Redis uses for small values. Streams are skipped, since there
+// is nothing in LeifDB to import them as, and module types can't be decoded
+// without the module, so they stop the import.
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// RDB opcodes
+const (
+	rdbOpSlotInfo   = 0xf4
+	rdbOpFunction2  = 0xf5
+	rdbOpFunction   = 0xf6
+	rdbOpModuleAux  = 0xf7
+	rdbOpIdle       = 0xf8
+	rdbOpFreq       = 0xf9
+	rdbOpAux        = 0xfa
+	rdbOpResizeDB   = 0xfb
+	rdbOpExpireMs   = 0xfc
+	rdbOpExpire     = 0xfd
+	rdbOpSelectDB   = 0xfe
+	rdbOpEOF        = 0xff
+	rdbMaxVersion   = 12
+	rdbHeaderLength = 9
+)
+
+// RDB value types
+const (
+	rdbString         = 0
+	rdbList           = 1
+	rdbSet            = 2
+	rdbZSet           = 3
+	rdbHash           = 4
+	rdbZSet2          = 5
+	rdbHashZipmap     = 9
+	rdbListZiplist    = 10
+	rdbSetIntset      = 11
+	rdbZSetZiplist    = 12
+	rdbHashZiplist    = 13
+	rdbListQuicklist  = 14
+	rdbStream         = 15
+	rdbHashListpack   = 16
+	rdbZSetListpack   = 17
+	rdbListQuicklist2 = 18
+	rdbStream2        = 19
+	rdbSetListpack    = 20
+	rdbStream3        = 21
+)
+
+var (
+	// errNotRDB indicates a file that does not start with an RDB header
+	errNotRDB = errors.New("Not a Redis RDB file")
+
+	// errCorruptRDB indicates an RDB file (or an encoded value in one) that
+	// ends early or is malformed
+	errCorruptRDB = errors.New("Corrupt RDB file")
+)
+
+// An rdbReader decodes an RDB file
+type rdbReader struct {
+	r       *bufio.Reader
+	version int
+	// number of keys of a type with nothing to import them as
+	unsupported int
+}
+
+// readRDB decodes the RDB file in r, calling emit with the number of the
+// database and the contents of each key. It stops after the end of the file
+// marker and its checksum, leaving any data that follows in r
+func readRDB(r *bufio.Reader, emit func(db int, key *redisKey) error) (int, error) {
+	header := make([]byte, rdbHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:5]) != "REDIS" {
+		return 0, errNotRDB
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbMaxVersion {
+		return 0, fmt.Errorf("Unsupported RDB version %q", header[5:])
+	}
+	d := &rdbReader{r: r, version: version}
+
+	db := 0
+	var expiresAt int64
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return d.unsupported, err
+		}
+		switch op {
+		case rdbOpEOF:
+			if version >= 5 {
+				// CRC64 checksum, which is not verified
+				if _, err := d.bytes(8); err != nil {
+					return d.unsupported, err
+				}
+			}
+			return d.unsupported, nil
+		case rdbOpSelectDB:
+			n, err := d.length()
+			if err != nil {
+				return d.unsupported, err
+			}
+			db = int(n)
+		case rdbOpResizeDB:
+			if _, err := d.lengths(2); err != nil {
+				return d.unsupported, err
+			}
+		case rdbOpSlotInfo:
+			if _, err := d.lengths(3); err != nil {
+				return d.unsupported, err
+			}
+		case rdbOpAux:
+			if _, err := d.string(); err != nil {
+				return d.unsupported, err
+			}
+			if _, err := d.string(); err != nil {
+				return d.unsupported, err
+			}
+		case rdbOpFunction2:
+			if _, err := d.string(); err != nil {
+				return d.unsupported, err
+			}
+		case rdbOpFunction, rdbOpModuleAux:
+			return d.unsupported, errors.New("RDB files with modules or pre-release functions can't be imported")
+		case rdbOpExpire:
+			b, err := d.bytes(4)
+			if err != nil {
+				return d.unsupported, err
+			}
+			expiresAt = int64(binary.LittleEndian.Uint32(b)) * 1e9
+		case rdbOpExpireMs:
+			b, err := d.bytes(8)
+			if err != nil {
+				return d.unsupported, err
+			}
+			expiresAt = int64(binary.LittleEndian.Uint64(b)) * 1e6
+		case rdbOpIdle:
+			if _, err := d.length(); err != nil {
+				return d.unsupported, err
+			}
+		case rdbOpFreq:
+			if _, err := d.byte(); err != nil {
+				return d.unsupported, err
+			}
+		default:
+			name, err := d.string()
+			if err != nil {
+				return d.unsupported, err
+			}
+			key, err := d.value(op, name)
+			if err != nil {
+				return d.unsupported, fmt.Errorf("Key %q: %w", name, err)
+			}
+			if key != nil {
+				key.expiresAt = expiresAt
+				if err := emit(db, key); err != nil {
+					return d.unsupported, err
+				}
+			}
+			expiresAt = 0
+		}
+	}
+}
+
+func (d *rdbReader) byte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, errCorruptRDB
+	}
+	return b, nil
+}
+
+func (d *rdbReader) bytes(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, errCorruptRDB
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, errCorruptRDB
+	}
+	return b, nil
+}
+
+// lengthOrEncoding reads a length, or the kind of a specially encoded string
+// (with encoded true)
+func (d *rdbReader) lengthOrEncoding() (n uint64, encoded bool, err error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.byte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			raw, err := d.bytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(raw)), false, nil
+		case 0x81:
+			raw, err := d.bytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(raw), false, nil
+		}
+		return 0, false, errCorruptRDB
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+func (d *rdbReader) length() (uint64, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err == nil && encoded {
+		err = errCorruptRDB
+	}
+	return n, err
+}
+
+func (d *rdbReader) lengths(count int) ([]uint64, error) {
+	ns := make([]uint64, count)
+	for i := range ns {
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
+
+// string reads a string, which may be stored as an integer or compressed
+func (d *rdbReader) string() (string, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		b, err := d.bytes(n)
+		return string(b), err
+	}
+	switch n {
+	case 0, 1, 2:
+		size := uint64(1) << n
+		b, err := d.bytes(size)
+		if err != nil {
+			return "", err
+		}
+		var v int64
+		switch size {
+		case 1:
+			v = int64(int8(b[0]))
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(b)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return strconv.FormatInt(v, 10), nil
+	case 3:
+		compressed, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		size, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		b, err := d.bytes(compressed)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(b, size)
+		return string(out), err
+	}
+	return "", errCorruptRDB
+}
+
+// strings reads a length, then that many strings
+func (d *rdbReader) strings() ([]string, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// value reads a value of type kind, and returns it as the contents of the key
+// name, or nil if it is of a type that can't be imported
+func (d *rdbReader) value(kind byte, name string) (*redisKey, error) {
+	switch kind {
+	case rdbString:
+		value, err := d.string()
+		return &redisKey{name: name, kind: redisString, value: value}, err
+	case rdbList, rdbSet:
+		items, err := d.strings()
+		if err != nil {
+			return nil, err
+		}
+		if kind == rdbList {
+			return &redisKey{name: name, kind: redisList, list: items}, nil
+		}
+		return newRedisSet(name, items), nil
+	case rdbZSet, rdbZSet2:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		key := &redisKey{name: name, kind: redisZSet, zset: map[string]float64{}}
+		for i := uint64(0); i < n; i++ {
+			member, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if kind == rdbZSet2 {
+				b, err := d.bytes(8)
+				if err != nil {
+					return nil, err
+				}
+				score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			} else if score, err = d.score(); err != nil {
+				return nil, err
+			}
+			key.zset[member] = score
+		}
+		return key, nil
+	case rdbHash:
+		items, err := d.pairs()
+		if err != nil {
+			return nil, err
+		}
+		return newRedisHash(name, items)
+	case rdbHashZipmap:
+		blob, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		items, err := decodeZipmap([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return newRedisHash(name, items)
+	case rdbListZiplist, rdbSetIntset, rdbZSetZiplist, rdbHashZiplist,
+		rdbHashListpack, rdbZSetListpack, rdbSetListpack:
+		blob, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		var items []string
+		switch kind {
+		case rdbSetIntset:
+			items, err = decodeIntset([]byte(blob))
+		case rdbListZiplist, rdbZSetZiplist, rdbHashZiplist:
+			items, err = decodeZiplist([]byte(blob))
+		default:
+			items, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case rdbListZiplist:
+			return &redisKey{name: name, kind: redisList, list: items}, nil
+		case rdbSetIntset, rdbSetListpack:
+			return newRedisSet(name, items), nil
+		case rdbZSetZiplist, rdbZSetListpack:
+			return newRedisZSet(name, items)
+		}
+		return newRedisHash(name, items)
+	case rdbListQuicklist, rdbListQuicklist2:
+		nodes, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		key := &redisKey{name: name, kind: redisList}
+		for i := uint64(0); i < nodes; i++ {
+			// quicklist 2 nodes hold either a listpack or a single element
+			packed := true
+			if kind == rdbListQuicklist2 {
+				container, err := d.length()
+				if err != nil {
+					return nil, err
+				}
+				packed = container == 2
+			}
+			blob, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if !packed {
+				key.list = append(key.list, blob)
+				continue
+			}
+			var items []string
+			if kind == rdbListQuicklist {
+				items, err = decodeZiplist([]byte(blob))
+			} else {
+				items, err = decodeListpack([]byte(blob))
+			}
+			if err != nil {
+				return nil, err
+			}
+			key.list = append(key.list, items...)
+		}
+		return key, nil
+	case rdbStream, rdbStream2, rdbStream3:
+		d.unsupported++
+		return nil, d.skipStream(kind)
+	}
+	return nil, fmt.Errorf("Unsupported value type %d (such as a module type)", kind)
+}
+
+// pairs reads a length, then that many pairs of strings
+func (d *rdbReader) pairs() ([]string, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, 2*n)
+	for i := uint64(0); i < 2*n; i++ {
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// score reads a sorted set score stored as a string (in version 1 zsets)
+func (d *rdbReader) score() (float64, error) {
+	n, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.bytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, errCorruptRDB
+	}
+	return score, nil
+}
+
+// skipStream reads past a stream of type kind
+func (d *rdbReader) skipStream(kind byte) error {
+	nodes, err := d.length()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < 2*nodes; i++ {
+		if _, err := d.string(); err != nil {
+			return err
+		}
+	}
+	// length and last ID, then (in later versions) the first ID, the largest
+	// deleted ID, and the number of entries ever added
+	fields := 3
+	if kind >= rdbStream2 {
+		fields += 5
+	}
+	if _, err := d.lengths(fields); err != nil {
+		return err
+	}
+	groups, err := d.length()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err := d.string(); err != nil {
+			return err
+		}
+		fields := 2
+		if kind >= rdbStream2 {
+			fields++
+		}
+		if _, err := d.lengths(fields); err != nil {
+			return err
+		}
+		// pending entries: ID, delivery time, and delivery count
+		pending, err := d.length()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			if _, err := d.bytes(16 + 8); err != nil {
+				return err
+			}
+			if _, err := d.length(); err != nil {
+				return err
+			}
+		}
+		consumers, err := d.length()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := d.string(); err != nil {
+				return err
+			}
+			// seen time, and (in later versions) active time
+			times := uint64(8)
+			if kind >= rdbStream3 {
+				times += 8
+			}
+			if _, err := d.bytes(times); err != nil {
+				return err
+			}
+			owned, err := d.length()
+			if err != nil {
+				return err
+			}
+			if _, err := d.bytes(16 * owned); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lzfDecompress expands LZF-compressed data to its original size
+func lzfDecompress(in []byte, size uint64) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// a run of ctrl+1 literal bytes
+			end := i + ctrl + 1
+			if end > len(in) {
+				return nil, errCorruptRDB
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+		// a back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errCorruptRDB
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorruptRDB
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorruptRDB
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, errCorruptRDB
+	}
+	return out, nil
+}
+
+// decodeIntset returns the members of an intset
+func decodeIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errCorruptRDB
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(b) < 8+width*n {
+		return nil, errCorruptRDB
+	}
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		raw := b[8+width*i:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(raw)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(raw)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(raw))
+		}
+		out = append(out, strconv.FormatInt(v, 10))
+	}
+	return out, nil
+}
+
+// decodeZiplist returns the entries of a ziplist
+func decodeZiplist(b []byte) ([]string, error) {
+	// total bytes, offset of the tail, and number of entries
+	const headerLength = 10
+	if len(b) < headerLength+1 {
+		return nil, errCorruptRDB
+	}
+	out := []string{}
+	for i := headerLength; ; {
+		if i >= len(b) {
+			return nil, errCorruptRDB
+		}
+		if b[i] == 0xff {
+			return out, nil
+		}
+		// length of the previous entry
+		if b[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errCorruptRDB
+		}
+		enc := b[i]
+		var size, skip int
+		isString := true
+		switch enc >> 6 {
+		case 0:
+			size, skip = int(enc&0x3f), 1
+		case 1:
+			if i+1 >= len(b) {
+				return nil, errCorruptRDB
+			}
+			size, skip = int(enc&0x3f)<<8|int(b[i+1]), 2
+		case 2:
+			if i+4 >= len(b) {
+				return nil, errCorruptRDB
+			}
+			size, skip = int(binary.BigEndian.Uint32(b[i+1:])), 5
+		default:
+			isString = false
+			skip = 1
+			switch enc {
+			case 0xc0:
+				size = 2
+			case 0xd0:
+				size = 4
+			case 0xe0:
+				size = 8
+			case 0xf0:
+				size = 3
+			case 0xfe:
+				size = 1
+			default:
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, errCorruptRDB
+				}
+				// a small integer in the encoding itself
+				out = append(out, strconv.Itoa(int(enc&0x0f)-1))
+				i++
+				continue
+			}
+		}
+		i += skip
+		if size < 0 || i+size > len(b) {
+			return nil, errCorruptRDB
+		}
+		raw := b[i : i+size]
+		i += size
+		if isString {
+			out = append(out, string(raw))
+			continue
+		}
+		out = append(out, strconv.FormatInt(littleEndianInt(raw), 10))
+	}
+}
+
+// littleEndianInt decodes a signed little-endian integer of 1 to 8 bytes
+func littleEndianInt(raw []byte) int64 {
+	var v uint64
+	for j := len(raw) - 1; j >= 0; j-- {
+		v = v<<8 | uint64(raw[j])
+	}
+	shift := uint(64 - 8*len(raw))
+	return int64(v<<shift) >> shift
+}
+
+// decodeListpack returns the entries of a listpack
+func decodeListpack(b []byte) ([]string, error) {
+	// total bytes and number of entries
+	const headerLength = 6
+	if len(b) < headerLength+1 {
+		return nil, errCorruptRDB
+	}
+	out := []string{}
+	for i := headerLength; ; {
+		if i >= len(b) {
+			return nil, errCorruptRDB
+		}
+		enc := b[i]
+		if enc == 0xff {
+			return out, nil
+		}
+		// length of the encoding and data, which is repeated after the entry
+		var entry int
+		var value string
+		need := func(n int) bool { return i+n <= len(b) }
+		switch {
+		case enc < 0x80:
+			entry, value = 1, strconv.Itoa(int(enc))
+		case enc < 0xc0:
+			size := int(enc & 0x3f)
+			if !need(1 + size) {
+				return nil, errCorruptRDB
+			}
+			entry, value = 1+size, string(b[i+1:i+1+size])
+		case enc < 0xe0:
+			if !need(2) {
+				return nil, errCorruptRDB
+			}
+			v := int(enc&0x1f)<<8 | int(b[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entry, value = 2, strconv.Itoa(v)
+		case enc < 0xf0:
+			if !need(2) {
+				return nil, errCorruptRDB
+			}
+			size := int(enc&0x0f)<<8 | int(b[i+1])
+			if !need(2 + size) {
+				return nil, errCorruptRDB
+			}
+			entry, value = 2+size, string(b[i+2:i+2+size])
+		case enc == 0xf0:
+			if !need(5) {
+				return nil, errCorruptRDB
+			}
+			size := int(binary.LittleEndian.Uint32(b[i+1:]))
+			if size < 0 || !need(5+size) {
+				return nil, errCorruptRDB
+			}
+			entry, value = 5+size, string(b[i+5:i+5+size])
+		case enc >= 0xf1 && enc <= 0xf4:
+			size := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+			if !need(1 + size) {
+				return nil, errCorruptRDB
+			}
+			entry = 1 + size
+			value = strconv.FormatInt(littleEndianInt(b[i+1:i+1+size]), 10)
+		default:
+			return nil, errCorruptRDB
+		}
+		out = append(out, value)
+		i += entry + listpackBacklenSize(entry)
+	}
+}
+
+// listpackBacklenSize returns the size of the field after a listpack entry
+// that holds its length
+func listpackBacklenSize(entry int) int {
+	switch {
+	case entry < 1<<7:
+		return 1
+	case entry < 1<<14:
+		return 2
+	case entry < 1<<21:
+		return 3
+	case entry < 1<<28:
+		return 4
+	}
+	return 5
+}
+
+// decodeZipmap returns the keys and values of a zipmap, alternating
+func decodeZipmap(b []byte) ([]string, error) {
+	out := []string{}
+	i := 1 // number of entries (if less than 254)
+	length := func() (int, error) {
+		if i >= len(b) {
+			return 0, errCorruptRDB
+		}
+		switch b[i] {
+		case 254:
+			if i+5 > len(b) {
+				return 0, errCorruptRDB
+			}
+			n := int(binary.LittleEndian.Uint32(b[i+1:]))
+			i += 5
+			return n, nil
+		case 255:
+			return -1, nil
+		}
+		n := int(b[i])
+		i++
+		return n, nil
+	}
+	for {
+		n, err := length()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return out, nil
+		}
+		if i+n > len(b) {
+			return nil, errCorruptRDB
+		}
+		field := string(b[i : i+n])
+		i += n
+		if n, err = length(); err != nil || n < 0 {
+			return nil, errCorruptRDB
+		}
+		// unused bytes after the value
+		if i >= len(b) {
+			return nil, errCorruptRDB
+		}
+		free := int(b[i])
+		i++
+		if i+n+free > len(b) {
+			return nil, errCorruptRDB
+		}
+		out = append(out, field, string(b[i:i+n]))
+		i += n + free
+	}
+}
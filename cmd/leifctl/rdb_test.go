@@ -0,0 +1,211 @@
+// +build unit
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// rdbBuilder writes an RDB file for tests
+type rdbBuilder struct {
+	bytes.Buffer
+}
+
+func (b *rdbBuilder) length(n int) {
+	switch {
+	case n < 1<<6:
+		b.WriteByte(byte(n))
+	case n < 1<<14:
+		b.WriteByte(byte(0x40 | n>>8))
+		b.WriteByte(byte(n))
+	default:
+		b.WriteByte(0x80)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func (b *rdbBuilder) string(s string) {
+	b.length(len(s))
+	b.WriteString(s)
+}
+
+// listpack encodes entries as a listpack of strings and 7-bit integers
+func listpack(entries ...string) string {
+	var lp bytes.Buffer
+	lp.Write(make([]byte, 6))
+	for _, entry := range entries {
+		if len(entry) == 1 && entry[0] >= '0' && entry[0] <= '9' {
+			lp.WriteByte(entry[0] - '0')
+			lp.WriteByte(1)
+			continue
+		}
+		lp.WriteByte(0x80 | byte(len(entry)))
+		lp.WriteString(entry)
+		lp.WriteByte(byte(1 + len(entry)))
+	}
+	lp.WriteByte(0xff)
+	return lp.String()
+}
+
+func TestReadRDB(t *testing.T) {
+	b := &rdbBuilder{}
+	b.WriteString("REDIS0011")
+	b.WriteByte(rdbOpAux)
+	b.string("redis-ver")
+	b.string("7.2.0")
+	b.WriteByte(rdbOpSelectDB)
+	b.length(0)
+	b.WriteByte(rdbOpResizeDB)
+	b.length(9)
+	b.length(1)
+
+	b.WriteByte(rdbString)
+	b.string("greeting")
+	b.string("hello")
+
+	// an integer-encoded string, with an expiry time
+	b.WriteByte(rdbOpExpireMs)
+	binary.Write(b, binary.LittleEndian, uint64(4102444800000))
+	b.WriteByte(rdbString)
+	b.string("count")
+	b.WriteByte(0xc1)
+	binary.Write(b, binary.LittleEndian, int16(-300))
+
+	// an LZF-compressed string: a literal "abc", then 6 bytes from 3 back
+	b.WriteByte(rdbString)
+	b.string("repeated")
+	b.WriteByte(0xc3)
+	b.length(6)
+	b.length(9)
+	b.Write([]byte{2, 'a', 'b', 'c', 0x80, 2})
+
+	b.WriteByte(rdbSetIntset)
+	b.string("ids")
+	var intset bytes.Buffer
+	binary.Write(&intset, binary.LittleEndian, []uint32{2, 2})
+	binary.Write(&intset, binary.LittleEndian, []int16{-1, 7})
+	b.string(intset.String())
+
+	b.WriteByte(rdbZSet2)
+	b.string("scores")
+	b.length(1)
+	b.string("alice")
+	binary.Write(b, binary.LittleEndian, math.Float64bits(2.5))
+
+	b.WriteByte(rdbZSetListpack)
+	b.string("ranks")
+	b.string(listpack("bob", "3"))
+
+	b.WriteByte(rdbHashListpack)
+	b.string("user")
+	b.string(listpack("name", "carol", "age", "5"))
+
+	b.WriteByte(rdbListQuicklist2)
+	b.string("queue")
+	b.length(2)
+	b.length(2)
+	b.string(listpack("a", "b"))
+	b.length(1)
+	b.string("c")
+
+	// a key in another database
+	b.WriteByte(rdbOpSelectDB)
+	b.length(1)
+	b.WriteByte(rdbSet)
+	b.string("other")
+	b.length(1)
+	b.string("x")
+
+	b.WriteByte(rdbOpEOF)
+	b.Write(make([]byte, 8))
+	b.WriteString("*1\r\n")
+
+	keys := map[string]*redisKey{}
+	dbs := map[string]int{}
+	r := bufio.NewReader(bytes.NewReader(b.Bytes()))
+	unsupported, err := readRDB(r, func(db int, key *redisKey) error {
+		keys[key.name] = key
+		dbs[key.name] = db
+		return nil
+	})
+	if err != nil || unsupported != 0 {
+		t.Fatalf("Error reading RDB: %v (%d unsupported)", err, unsupported)
+	}
+	if rest, _ := r.ReadString(0); rest != "*1\r\n" {
+		t.Errorf("Expected reading to stop after the checksum, %q left", rest)
+	}
+
+	if k := keys["greeting"]; k == nil || k.value != "hello" || k.expiresAt != 0 {
+		t.Errorf("Unexpected string: %+v", k)
+	}
+	if k := keys["count"]; k == nil || k.value != "-300" || k.expiresAt != 4102444800000*1e6 {
+		t.Errorf("Unexpected integer string: %+v", k)
+	}
+	if k := keys["repeated"]; k == nil || k.value != "abcabcabc" {
+		t.Errorf("Unexpected compressed string: %+v", k)
+	}
+	if k := keys["ids"]; k == nil || !reflect.DeepEqual(k.set, map[string]bool{"-1": true, "7": true}) {
+		t.Errorf("Unexpected intset: %+v", k)
+	}
+	if k := keys["scores"]; k == nil || k.zset["alice"] != 2.5 {
+		t.Errorf("Unexpected sorted set: %+v", k)
+	}
+	if k := keys["ranks"]; k == nil || k.zset["bob"] != 3 {
+		t.Errorf("Unexpected listpack sorted set: %+v", k)
+	}
+	if k := keys["user"]; k == nil || !reflect.DeepEqual(k.hash, map[string]string{"name": "carol", "age": "5"}) {
+		t.Errorf("Unexpected hash: %+v", k)
+	}
+	if k := keys["queue"]; k == nil || strings.Join(k.list, ",") != "a,b,c" {
+		t.Errorf("Unexpected list: %+v", k)
+	}
+	if dbs["other"] != 1 || dbs["greeting"] != 0 {
+		t.Errorf("Unexpected databases: %v", dbs)
+	}
+
+	if _, err := readRDB(bufio.NewReader(strings.NewReader("RESP")), nil); err != errNotRDB {
+		t.Errorf("Expected errNotRDB, got %v", err)
+	}
+	truncated := bufio.NewReader(bytes.NewReader(b.Bytes()[:40]))
+	if _, err := readRDB(truncated, func(int, *redisKey) error { return nil }); err == nil {
+		t.Error("Expected an error reading a truncated file")
+	}
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	var zl bytes.Buffer
+	zl.Write(make([]byte, 10))
+	// "ab", a 4-bit immediate 5, an int16 -2, and an int24 70000
+	zl.Write([]byte{0, 0x02, 'a', 'b'})
+	zl.Write([]byte{4, 0xf6})
+	zl.Write([]byte{2, 0xc0, 0xfe, 0xff})
+	zl.Write([]byte{4, 0xf0, 0x70, 0x11, 0x01})
+	zl.WriteByte(0xff)
+
+	items, err := decodeZiplist(zl.Bytes())
+	if err != nil {
+		t.Fatal("Error decoding ziplist:", err)
+	}
+	if strings.Join(items, ",") != "ab,5,-2,70000" {
+		t.Errorf("Unexpected entries: %v", items)
+	}
+}
+
+func TestDecodeZipmap(t *testing.T) {
+	zm := []byte{1, 4, 'n', 'a', 'm', 'e', 3, 1, 'b', 'o', 'b', 0, 0xff}
+	items, err := decodeZipmap(zm)
+	if err != nil {
+		t.Fatal("Error decoding zipmap:", err)
+	}
+	sort.Strings(items)
+	if strings.Join(items, ",") != "bob,name" {
+		t.Errorf("Unexpected entries: %v", items)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// errTimeout indicates that a step of a command did not complete in time
+var errTimeout = errors.New("Timed out")
+
+// A restarter restarts the nodes of a cluster one at a time, moving leadership
+// away from each node before it is restarted and waiting for it to catch up
+// afterward, so that the cluster can serve requests throughout
+type restarter struct {
+	client  *client
+	restart func(endpoint string) error
+	maxLag  int64
+	timeout time.Duration
+	poll    time.Duration
+	out     io.Writer
+}
+
+func rollingRestart(args []string) error {
+	flags := flag.NewFlagSet("rolling-restart", flag.ContinueOnError)
+	endpoints := flags.String("endpoints", "localhost:8080",
+		"Comma-separated client addresses of the cluster members")
+	restartCmd := flags.String("restart-cmd", "",
+		"Shell command that restarts a node, with {endpoint} replaced by its "+
+			"address (if empty, waits for each node to be restarted by hand)")
+	maxLag := flags.Int64("max-lag", 10,
+		"Number of entries a restarted node may trail the leader's commit index by "+
+			"before moving on to the next node")
+	timeout := flags.Duration("timeout", 5*time.Minute, "Time to wait for each step")
+	poll := flags.Duration("poll", time.Second, "Interval between status checks")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	r := &restarter{
+		client:  newClient(5 * time.Second),
+		restart: promptRestart(os.Stdin, os.Stdout),
+		maxLag:  *maxLag,
+		timeout: *timeout,
+		poll:    *poll,
+		out:     os.Stdout,
+	}
+	if *restartCmd != "" {
+		r.restart = shellRestart(*restartCmd)
+	}
+	return r.run(splitList(*endpoints))
+}
+
+// shellRestart returns a restart function that runs a shell command
+func shellRestart(command string) func(string) error {
+	return func(endpoint string) error {
+		cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, "{endpoint}", endpoint))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
+
+// promptRestart returns a restart function that waits for the operator to
+// restart the node by hand
+func promptRestart(in io.Reader, out io.Writer) func(string) error {
+	reader := bufio.NewReader(in)
+	return func(endpoint string) error {
+		fmt.Fprintf(out, "Restart %s, then press Enter: ", endpoint)
+		_, err := reader.ReadString('\n')
+		return err
+	}
+}
+
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// run restarts each node, followed by the leader
+func (r *restarter) run(endpoints []string) error {
+	order := []string{}
+	leader := ""
+	for _, endpoint := range endpoints {
+		s, err := r.client.status(endpoint)
+		if err != nil {
+			return fmt.Errorf("%s is not healthy, not starting: %v", endpoint, err)
+		}
+		if s.State == "Leader" {
+			leader = endpoint
+		} else {
+			order = append(order, endpoint)
+		}
+	}
+	if leader != "" {
+		order = append(order, leader)
+	}
+
+	for i, endpoint := range order {
+		fmt.Fprintf(r.out, "[%d/%d] %s\n", i+1, len(order), endpoint)
+		if err := r.restartNode(endpoint); err != nil {
+			return fmt.Errorf("%s: %v", endpoint, err)
+		}
+	}
+	fmt.Fprintln(r.out, "All nodes restarted")
+	return nil
+}
+
+// restartNode drains a node (moving leadership away if it is the leader),
+// restarts it, and waits for it to catch up
+func (r *restarter) restartNode(endpoint string) error {
+	s, err := r.client.status(endpoint)
+	if err != nil {
+		return err
+	}
+	isLeader := s.State == "Leader"
+	if _, err := r.client.drain(endpoint, isLeader); err != nil {
+		return err
+	}
+	if isLeader {
+		fmt.Fprintln(r.out, "  transferring leadership")
+		err := r.waitFor(func() (bool, error) {
+			s, err := r.client.status(endpoint)
+			return err == nil && s.State != "Leader", err
+		})
+		if err != nil {
+			r.client.resume(endpoint)
+			return fmt.Errorf("leadership was not transferred: %v", err)
+		}
+	}
+
+	fmt.Fprintln(r.out, "  restarting")
+	if err := r.restart(endpoint); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(r.out, "  waiting to catch up")
+	err = r.waitFor(func() (bool, error) {
+		return r.caughtUp(endpoint)
+	})
+	if err != nil {
+		return fmt.Errorf("did not catch up: %v", err)
+	}
+	// the drain does not survive a restart, but if the node was not actually
+	// restarted it would otherwise remain drained
+	return r.client.resume(endpoint)
+}
+
+// caughtUp reports whether a node is serving reads and has applied entries to
+// within maxLag of the leader's commit index
+func (r *restarter) caughtUp(endpoint string) (bool, error) {
+	s, err := r.client.status(endpoint)
+	if err != nil {
+		return false, err
+	}
+	if !s.CaughtUp {
+		return false, nil
+	}
+	if s.State == "Leader" {
+		return true, nil
+	}
+	if s.Leader == "" {
+		return false, errors.New("no leader known")
+	}
+	leader, err := r.client.status(s.Leader)
+	if err != nil {
+		return false, err
+	}
+	return leader.CommitIndex-s.LastApplied <= r.maxLag, nil
+}
+
+// waitFor polls cond until it returns true, or the timeout passes (in which
+// case the last error returned by cond, if any, is included in the error)
+func (r *restarter) waitFor(cond func() (bool, error)) error {
+	deadline := time.Now().Add(r.timeout)
+	var last error
+	for {
+		done, err := cond()
+		if done {
+			return nil
+		}
+		if err != nil {
+			last = err
+		}
+		if time.Now().After(deadline) {
+			if last != nil {
+				return fmt.Errorf("%v (%v)", errTimeout, last)
+			}
+			return errTimeout
+		}
+		time.Sleep(r.poll)
+	}
+}
@@ -0,0 +1,156 @@
+// +build unit
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCluster serves the admin routes used by leifctl for a set of nodes
+type fakeCluster struct {
+	sync.Mutex
+	servers  []*httptest.Server
+	leader   int
+	term     int64
+	commit   int64
+	applied  []int64
+	draining []bool
+	events   []string
+}
+
+func newFakeCluster(t *testing.T, size int) *fakeCluster {
+	c := &fakeCluster{applied: make([]int64, size), draining: make([]bool, size)}
+	for i := 0; i < size; i++ {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.serve(i, w, r)
+		}))
+		t.Cleanup(server.Close)
+		c.servers = append(c.servers, server)
+	}
+	c.commit = 100
+	for i := range c.applied {
+		c.applied[i] = c.commit
+	}
+	return c
+}
+
+func (c *fakeCluster) addr(i int) string {
+	return strings.TrimPrefix(c.servers[i].URL, "http://")
+}
+
+func (c *fakeCluster) serve(i int, w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/admin/status":
+		s := status{
+			State:       "Follower",
+			Leader:      c.addr(c.leader),
+			Term:        c.term,
+			CommitIndex: c.commit,
+			LastApplied: c.applied[i],
+			CaughtUp:    true}
+		if i == c.leader {
+			s.State = "Leader"
+		}
+		json.NewEncoder(w).Encode(s)
+	case r.Method == "POST" && r.URL.Path == "/admin/drain":
+		c.draining[i] = true
+		c.events = append(c.events, "drain "+c.addr(i))
+		if r.URL.Query().Get("transfer") == "true" && i == c.leader {
+			c.leader = (i + 1) % len(c.servers)
+		}
+		json.NewEncoder(w).Encode(drainStatus{Draining: true})
+	case r.Method == "DELETE" && r.URL.Path == "/admin/drain":
+		c.draining[i] = false
+		c.events = append(c.events, "resume "+c.addr(i))
+	case r.Method == "POST" && r.URL.Path == "/admin/demote":
+		if i != c.leader {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		c.events = append(c.events, "demote "+c.addr(i)+" "+r.URL.Query().Get("holdoff"))
+		json.NewEncoder(w).Encode(demoteResult{Id: c.addr(i), Term: c.term})
+		c.leader = (i + 1) % len(c.servers)
+		c.term++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	c := newFakeCluster(t, 3)
+	c.leader = 0
+
+	restarted := []string{}
+	r := &restarter{
+		client: newClient(time.Second),
+		restart: func(endpoint string) error {
+			c.Lock()
+			defer c.Unlock()
+			restarted = append(restarted, endpoint)
+			for i := range c.servers {
+				if c.addr(i) == endpoint {
+					// a restarted node starts behind, and catches up over time
+					c.applied[i] = 0
+					go func(i int) {
+						time.Sleep(10 * time.Millisecond)
+						c.Lock()
+						c.applied[i] = c.commit
+						c.Unlock()
+					}(i)
+				}
+			}
+			return nil
+		},
+		maxLag:  5,
+		timeout: time.Second,
+		poll:    time.Millisecond,
+		out:     ioutil.Discard,
+	}
+	endpoints := []string{c.addr(0), c.addr(1), c.addr(2)}
+	if err := r.run(endpoints); err != nil {
+		t.Fatalf("Error in rolling restart: %v", err)
+	}
+
+	// the leader is restarted last, after handing off leadership
+	expected := []string{c.addr(1), c.addr(2), c.addr(0)}
+	if !reflect.DeepEqual(restarted, expected) {
+		t.Errorf("Expected restart order %v, got %v", expected, restarted)
+	}
+	if c.leader == 0 {
+		t.Error("Expected leadership to be transferred away from the first leader")
+	}
+	for i, draining := range c.draining {
+		if draining {
+			t.Errorf("Expected node %d to be resumed after restart", i)
+		}
+	}
+}
+
+func TestRollingRestartUnhealthy(t *testing.T) {
+	c := newFakeCluster(t, 2)
+	r := &restarter{
+		client:  newClient(time.Second),
+		restart: func(string) error { return nil },
+		timeout: time.Second,
+		poll:    time.Millisecond,
+		out:     ioutil.Discard,
+	}
+	c.servers[1].Close()
+	if err := r.run([]string{c.addr(0), c.addr(1)}); err == nil {
+		t.Error("Expected rolling restart to refuse to start with a node down")
+	}
+	if len(c.events) != 0 {
+		t.Errorf("Expected no nodes to be drained, got %v", c.events)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// restoreKeyCommand restores the value of a deleted key from the trash
+func restoreKeyCommand(args []string) error {
+	flags := flag.NewFlagSet("restore-key", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: leifctl restore-key [flags] <key>")
+		flags.PrintDefaults()
+	}
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of any cluster member (writes are redirected to the leader)")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the key to restore")
+	}
+	return restoreKey(newClient(*timeout), *endpoint, flags.Arg(0), os.Stdout)
+}
+
+// restoreKey restores key from the trash, reporting the value restored and how
+// long it had left in the trash. A key that has been written since it was
+// deleted is left alone
+func restoreKey(c *client, endpoint string, key string, out io.Writer) error {
+	t, err := c.trashed(endpoint, key)
+	if err != nil {
+		return err
+	}
+	if err := c.restore(endpoint, key); err != nil {
+		return err
+	}
+	left := time.Until(time.Unix(0, t.ExpiresAt)).Round(time.Second)
+	fmt.Fprintf(out, "Restored %s (%d bytes, %s before it would have expired from the trash)\n",
+		key, len(t.Value), left)
+	return nil
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreKey(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UnixNano()
+	trash := map[string]string{"user:1": `{"name": "a"}`}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/trash/"), "/restore")
+		value, ok := trash[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": "NotFound", "message": "Key has no value in the trash"}`)
+			return
+		}
+		if r.Method == "POST" {
+			delete(trash, key)
+			fmt.Fprint(w, `{"status": "Ok"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"key": %q, "value": %q, "expiresAt": %d}`, key, value, expires)
+	}))
+	defer server.Close()
+
+	c := newClient(time.Second)
+	var out bytes.Buffer
+	if err := restoreKey(c, server.URL, "user:1", &out); err != nil {
+		t.Fatal("Error restoring key:", err)
+	}
+	if !strings.HasPrefix(out.String(), "Restored user:1 (13 bytes, 1h0m0s") {
+		t.Errorf("Expected the restore to be reported, got %q", out.String())
+	}
+	if len(trash) != 0 {
+		t.Errorf("Expected the key to be restored, trash holds %v", trash)
+	}
+	if err := restoreKey(c, server.URL, "user:1", &out); err == nil ||
+		!strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a 404 restoring a key not in the trash, got %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/mgmt"
+)
+
+// snapshot runs the subcommands of `leifctl snapshot`
+var snapshot = subcommands("snapshot", map[string]command{
+	"restore": snapshotRestore,
+	"save":    snapshotSave,
+	"status":  snapshotStatusCommand,
+	"verify":  snapshotVerify,
+})
+
+// backup runs the subcommands of `leifctl backup`
+var backup = subcommands("backup", map[string]command{
+	"trigger": backupTrigger,
+})
+
+// snapshotSave downloads a snapshot of the database from a node to a file
+func snapshotSave(args []string) error {
+	flags := flag.NewFlagSet("snapshot save", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of the cluster member to take the snapshot from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: leifctl snapshot save [flags] <file>")
+	}
+	return saveSnapshot(newClient(5*time.Second), *endpoint, flags.Arg(0), os.Stdout)
+}
+
+// saveSnapshot downloads a snapshot to a temporary file, which is renamed to
+// filename once the download is complete and verified against the hashes sent
+// with it, reporting progress to out. The hashes are saved alongside, in a
+// manifest named with mgmt.BackupManifestSuffix
+func saveSnapshot(c *client, endpoint string, filename string, out io.Writer) error {
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer os.Remove(tmp + mgmt.BackupManifestSuffix)
+
+	reported := int64(-1)
+	header, err := c.download(endpoint, "/admin/snapshot", f, func(done int64, total int64) {
+		// report each percent (or each megabyte, if the size is not known)
+		step := done >> 20
+		if total > 0 {
+			step = done * 100 / total
+		}
+		if step != reported {
+			reported = step
+			fmt.Fprintf(out, "\rDownloaded %s", progress(done, total))
+		}
+	})
+	if reported >= 0 {
+		fmt.Fprintln(out)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	verified, err := writeBackupManifest(tmp, header)
+	if err != nil {
+		return err
+	}
+	if verified {
+		if err := os.Rename(tmp+mgmt.BackupManifestSuffix, filename+mgmt.BackupManifestSuffix); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved snapshot as of entry %s (term %s) to %s\n",
+		header.Get("X-Leifdb-Last-Applied"), header.Get("X-Leifdb-Last-Term"), filename)
+	if !verified {
+		fmt.Fprintln(out, "The node sent no checksum, so the snapshot can't be verified")
+	}
+	return nil
+}
+
+// backupManifest is the manifest saved with a snapshot, in the format of the
+// manifests in a data directory
+type backupManifest struct {
+	LastApplied int64  `json:"lastApplied"`
+	LastTerm    int64  `json:"lastTerm"`
+	Checksum    uint32 `json:"checksum,omitempty"`
+	Sha256      string `json:"sha256,omitempty"`
+	AppliedHash uint64 `json:"appliedHash,omitempty"`
+}
+
+// writeBackupManifest writes the manifest for a snapshot downloaded with
+// header, and verifies the snapshot against it. It returns false, without
+// writing a manifest, if the node sent no checksum (as before checksums were
+// added)
+func writeBackupManifest(filename string, header http.Header) (bool, error) {
+	if header.Get("X-Leifdb-Checksum") == "" {
+		return false, nil
+	}
+	manifest := backupManifest{Sha256: header.Get("X-Leifdb-Sha256")}
+	var checksum uint64
+	var err error
+	for _, field := range []struct {
+		header string
+		parse  func(string) error
+	}{
+		{"X-Leifdb-Last-Applied", func(s string) (err error) {
+			manifest.LastApplied, err = strconv.ParseInt(s, 10, 64)
+			return
+		}},
+		{"X-Leifdb-Last-Term", func(s string) (err error) {
+			manifest.LastTerm, err = strconv.ParseInt(s, 10, 64)
+			return
+		}},
+		{"X-Leifdb-Checksum", func(s string) (err error) {
+			checksum, err = strconv.ParseUint(s, 10, 32)
+			return
+		}},
+		{"X-Leifdb-Applied-Hash", func(s string) (err error) {
+			manifest.AppliedHash, err = strconv.ParseUint(s, 10, 64)
+			return
+		}},
+	} {
+		if err = field.parse(header.Get(field.header)); err != nil {
+			return false, fmt.Errorf("Invalid %s header: %v", field.header, err)
+		}
+	}
+	manifest.Checksum = uint32(checksum)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(filename+mgmt.BackupManifestSuffix, data, 0644); err != nil {
+		return false, err
+	}
+	if _, err := mgmt.VerifySnapshot(filename); err != nil {
+		return false, fmt.Errorf("Downloaded snapshot failed verification: %w", err)
+	}
+	return true, nil
+}
+
+// snapshotVerify checks a saved snapshot against its manifest
+func snapshotVerify(args []string) error {
+	flags := flag.NewFlagSet("snapshot verify", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: leifctl snapshot verify <file>")
+	}
+	info, err := mgmt.VerifySnapshot(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s is intact: ", flags.Arg(0))
+	renderSnapshotInfo(os.Stdout, info)
+	return nil
+}
+
+// snapshotRestore verifies a saved snapshot, then installs it in the data
+// directory of a stopped node
+func snapshotRestore(args []string) error {
+	flags := flag.NewFlagSet("snapshot restore", flag.ContinueOnError)
+	dataDir := flags.String("data-dir", "",
+		"Data directory of the node to restore (which must be stopped, and hold no state)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *dataDir == "" {
+		return fmt.Errorf("Usage: leifctl snapshot restore -data-dir <dir> <file>")
+	}
+	info, err := mgmt.RestoreSnapshot(flags.Arg(0), *dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Restored %s to %s: ", flags.Arg(0), *dataDir)
+	renderSnapshotInfo(os.Stdout, info)
+	return nil
+}
+
+// renderSnapshotInfo describes a verified snapshot
+func renderSnapshotInfo(w io.Writer, info *mgmt.SnapshotInfo) {
+	fmt.Fprintf(w, "entry %d, term %d, %s", info.LastApplied, info.LastTerm, formatBytes(info.Bytes))
+	if info.Sha256 != "" {
+		fmt.Fprintf(w, ", sha256 %s", info.Sha256)
+	}
+	fmt.Fprintln(w)
+}
+
+// progress describes how much of a download is done
+func progress(done int64, total int64) string {
+	if total <= 0 {
+		return formatBytes(done)
+	}
+	return fmt.Sprintf("%s of %s (%d%%)", formatBytes(done), formatBytes(total), done*100/total)
+}
+
+// formatBytes returns a size in bytes in the largest unit that keeps it at
+// least 1
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// snapshotStatusCommand prints the state of the snapshots a node keeps on disk
+func snapshotStatusCommand(args []string) error {
+	flags := flag.NewFlagSet("snapshot status", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of the cluster member")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	s, err := newClient(5 * time.Second).snapshotStatus(*endpoint)
+	if err != nil {
+		return err
+	}
+	renderSnapshotStatus(os.Stdout, s)
+	return nil
+}
+
+func renderSnapshotStatus(w io.Writer, s *snapshotStatus) {
+	fmt.Fprintf(w, "Snapshots on disk: %d\n", s.Count)
+	if s.Latest != nil {
+		fmt.Fprintf(w, "Latest: %s (entry %d, term %d, %s, taken %s)\n",
+			s.Latest.File, s.Latest.LastApplied, s.Latest.LastTerm,
+			formatBytes(s.Latest.Bytes), s.Latest.Time.Format(time.RFC3339))
+	}
+	if s.InProgress {
+		fmt.Fprintln(w, "A snapshot is in progress")
+	}
+	if s.LastError != "" {
+		fmt.Fprintf(w, "Last attempt failed: %s\n", s.LastError)
+	}
+}
+
+// backupTrigger asks a node to take a snapshot to disk now, and waits for it
+func backupTrigger(args []string) error {
+	flags := flag.NewFlagSet("backup trigger", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "localhost:8080",
+		"Client address of the cluster member")
+	wait := flags.Bool("wait", true, "Wait for the snapshot to be taken")
+	timeout := flags.Duration("timeout", 5*time.Minute, "Time to wait for the snapshot")
+	poll := flags.Duration("poll", time.Second, "Interval between status checks")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return triggerBackup(newClient(5*time.Second), *endpoint, *wait, *timeout, *poll, os.Stdout)
+}
+
+func triggerBackup(
+	c *client,
+	endpoint string,
+	wait bool,
+	timeout time.Duration,
+	poll time.Duration,
+	out io.Writer) error {
+
+	if err := c.do("POST", endpoint, "/admin/snapshot", nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Snapshot requested on %s\n", endpoint)
+	if !wait {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err := c.snapshotStatus(endpoint)
+		if err != nil {
+			return err
+		}
+		if !s.InProgress {
+			fmt.Fprintln(out)
+			renderSnapshotStatus(out, s)
+			if s.LastError != "" {
+				return fmt.Errorf("Snapshot failed: %s", s.LastError)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintln(out)
+			return errTimeout
+		}
+		fmt.Fprint(out, ".")
+		time.Sleep(poll)
+	}
+}
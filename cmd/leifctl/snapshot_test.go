@@ -0,0 +1,133 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/mgmt"
+)
+
+func TestSaveSnapshot(t *testing.T) {
+	data := bytes.Repeat([]byte("snapshot"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Leifdb-Last-Applied", "41")
+		w.Header().Set("X-Leifdb-Last-Term", "3")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "leifctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "backup")
+
+	var out bytes.Buffer
+	if err := saveSnapshot(newClient(time.Second), server.URL, filename, &out); err != nil {
+		t.Fatalf("Error saving snapshot: %v", err)
+	}
+	saved, _ := ioutil.ReadFile(filename)
+	if !bytes.Equal(saved, data) {
+		t.Errorf("Expected %d bytes saved, got %d", len(data), len(saved))
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected temporary file to be removed")
+	}
+	if !strings.Contains(out.String(), "7.8 KiB of 7.8 KiB (100%)") ||
+		!strings.Contains(out.String(), "as of entry 41 (term 3)") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestSaveAndVerifySnapshot(t *testing.T) {
+	store := db.NewDatabase()
+	store.Set("ice", "cream")
+	data, err := db.BuildSnapshot(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	sha := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Leifdb-Last-Applied", "41")
+		w.Header().Set("X-Leifdb-Last-Term", "3")
+		w.Header().Set("X-Leifdb-Checksum", strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 10))
+		w.Header().Set("X-Leifdb-Sha256", sha)
+		w.Header().Set("X-Leifdb-Applied-Hash", "0")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "leifctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "backup")
+
+	var out bytes.Buffer
+	if err := saveSnapshot(newClient(time.Second), server.URL, filename, &out); err != nil {
+		t.Fatalf("Error saving snapshot: %v", err)
+	}
+	info, err := mgmt.VerifySnapshot(filename)
+	if err != nil || info.LastApplied != 41 || info.LastTerm != 3 || info.Sha256 != sha {
+		t.Errorf("Expected saved snapshot to verify, got %+v (%v)", info, err)
+	}
+
+	// a snapshot that doesn't match the hash sent with it is not saved
+	sha = strings.Repeat("0", 64)
+	os.Remove(filename)
+	err = saveSnapshot(newClient(time.Second), server.URL, filename, &out)
+	if !errors.Is(err, mgmt.ErrSnapshotCorrupt) {
+		t.Errorf("Expected ErrSnapshotCorrupt, got %v", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error("Expected corrupt snapshot not to be saved")
+	}
+}
+
+func TestTriggerBackup(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(snapshotStatus{InProgress: true})
+			return
+		}
+		polls++
+		s := snapshotStatus{InProgress: polls < 3}
+		if !s.InProgress {
+			s.Count = 1
+			s.Latest = &snapshotInfo{File: "ldbsnapshot000001", LastApplied: 41, Bytes: 2048}
+		}
+		json.NewEncoder(w).Encode(s)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := triggerBackup(newClient(time.Second), server.URL, true, time.Second, time.Millisecond, &out)
+	if err != nil {
+		t.Fatalf("Error triggering backup: %v", err)
+	}
+	if polls != 3 || !strings.Contains(out.String(), "Latest: ldbsnapshot000001 (entry 41, term 0, 2.0 KiB") {
+		t.Errorf("Expected to wait for the snapshot, got %d polls and output:\n%s", polls, out.String())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// endpointStatus is the status of one node, as printed by `leifctl status`
+type endpointStatus struct {
+	Endpoint    string `json:"endpoint"`
+	Id          string `json:"id,omitempty"`
+	Role        string `json:"role,omitempty"`
+	Leader      string `json:"leader,omitempty"`
+	Term        int64  `json:"term"`
+	CommitIndex int64  `json:"commitIndex"`
+	LastApplied int64  `json:"lastApplied"`
+	Keys        int    `json:"keys"`
+	DiskBytes   int64  `json:"diskBytes"`
+	Version     string `json:"version,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// statusCommand prints the status of each node, as a table or as JSON
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	endpoints := flags.String("endpoints", "localhost:8080",
+		"Comma-separated client addresses of the cluster members")
+	output := flags.String("o", "table", "Output format: table or json")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time to wait for each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("Unknown output format %q, expected table or json", *output)
+	}
+
+	statuses := endpointStatuses(newClient(*timeout), splitList(*endpoints))
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(statuses); err != nil {
+			return err
+		}
+	} else {
+		renderStatus(os.Stdout, statuses)
+	}
+
+	// fail if any node is unreachable, for scripts
+	failed := 0
+	for _, s := range statuses {
+		if s.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d endpoints failed", failed, len(statuses))
+	}
+	return nil
+}
+
+// endpointStatuses asks each node for its status
+func endpointStatuses(c *client, endpoints []string) []endpointStatus {
+	statuses := make([]endpointStatus, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		result := endpointStatus{Endpoint: endpoint}
+		s, err := c.status(endpoint)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Id = s.Id
+			result.Role = s.State
+			if s.Witness {
+				result.Role = "Witness"
+			}
+			result.Leader = s.Leader
+			result.Term = s.Term
+			result.CommitIndex = s.CommitIndex
+			result.LastApplied = s.LastApplied
+			result.Keys = s.Keys
+			result.DiskBytes = s.DiskBytes
+			result.Version = s.Version
+		}
+		statuses = append(statuses, result)
+	}
+	return statuses
+}
+
+func renderStatus(w io.Writer, statuses []endpointStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tID\tROLE\tTERM\tCOMMIT\tAPPLIED\tKEYS\tDISK\tVERSION")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\tunreachable: %s\n", s.Endpoint, s.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			s.Endpoint, s.Id, s.Role, s.Term, s.CommitIndex, s.LastApplied,
+			s.Keys, formatBytes(s.DiskBytes), s.Version)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,56 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(status{
+			Id:          "localhost:16990",
+			State:       "Leader",
+			Term:        3,
+			CommitIndex: 41,
+			LastApplied: 40,
+			Keys:        12,
+			DiskBytes:   2048,
+			Version:     "v1.2.3"})
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	statuses := endpointStatuses(newClient(time.Second), []string{server.URL, down.URL})
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %+v", statuses)
+	}
+	if s := statuses[0]; s.Role != "Leader" || s.CommitIndex != 41 || s.Keys != 12 || s.Error != "" {
+		t.Errorf("Unexpected status %+v", s)
+	}
+	if statuses[1].Error == "" {
+		t.Error("Expected an error for the unreachable endpoint")
+	}
+
+	var out bytes.Buffer
+	renderStatus(&out, statuses)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got:\n%s", out.String())
+	}
+	for _, field := range []string{"Leader", "41", "40", "12", "2.0 KiB", "v1.2.3"} {
+		if !strings.Contains(lines[1], field) {
+			t.Errorf("Expected %q in row: %s", field, lines[1])
+		}
+	}
+	if !strings.Contains(lines[2], "unreachable") {
+		t.Errorf("Expected unreachable row, got: %s", lines[2])
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// A sample is the state of a node at one point in time
+type sample struct {
+	at       time.Time
+	status   *status
+	requests float64
+	errors   float64
+	err      error
+}
+
+func top(args []string) error {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	endpoints := flags.String("endpoints", "localhost:8080",
+		"Comma-separated client addresses of the cluster members")
+	interval := flags.Duration("interval", 2*time.Second, "Time between refreshes")
+	once := flags.Bool("once", false,
+		"Print the status of each node once (after one interval, to measure rates) and exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c := newClient(*interval)
+	list := splitList(*endpoints)
+	sampleAll := func() map[string]*sample {
+		samples := map[string]*sample{}
+		for _, endpoint := range list {
+			samples[endpoint] = takeSample(c, endpoint)
+		}
+		return samples
+	}
+
+	prev := map[string]*sample{}
+	if *once {
+		// take two samples, so that rates can be shown
+		prev = sampleAll()
+		time.Sleep(*interval)
+		renderTop(os.Stdout, list, prev, sampleAll())
+		return nil
+	}
+	for {
+		cur := sampleAll()
+		// clear the screen and move the cursor to the top
+		fmt.Fprint(os.Stdout, "\033[H\033[2J")
+		fmt.Fprintf(os.Stdout, "%s (refreshing every %s, ctrl-c to exit)\n\n",
+			time.Now().Format("15:04:05"), *interval)
+		renderTop(os.Stdout, list, prev, cur)
+		prev = cur
+		time.Sleep(*interval)
+	}
+}
+
+// takeSample fetches the status and request counters of a node
+func takeSample(c *client, endpoint string) *sample {
+	s := &sample{at: time.Now()}
+	if s.status, s.err = c.status(endpoint); s.err != nil {
+		return s
+	}
+	values, err := c.metrics(endpoint)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	s.requests = values["leifdb_http_requests_total"]
+	s.errors = values["leifdb_http_errors_total"]
+	return s
+}
+
+// rate returns the per-second rate of change of a counter between samples, or
+// false if there is no earlier sample to compare with
+func rate(prev *sample, cur *sample, counter func(*sample) float64) (float64, bool) {
+	if prev == nil || prev.err != nil || cur.err != nil {
+		return 0, false
+	}
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	delta := counter(cur) - counter(prev)
+	if elapsed <= 0 || delta < 0 {
+		// a counter that went down means the node restarted
+		return 0, false
+	}
+	return delta / elapsed, true
+}
+
+// renderTop writes a table with a row for each node. Lag is the number of
+// entries between the leader's commit index and the entries applied by the
+// node, and rates are computed from the previous samples
+func renderTop(w io.Writer, endpoints []string, prev map[string]*sample, cur map[string]*sample) {
+	leaderCommit := int64(-1)
+	for _, s := range cur {
+		if s.err == nil && s.status.State == "Leader" {
+			leaderCommit = s.status.CommitIndex
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tROLE\tTERM\tCOMMIT\tAPPLIED\tLAG\tREQ/S\tERR/S")
+	for _, endpoint := range endpoints {
+		s := cur[endpoint]
+		if s.err != nil {
+			fmt.Fprintf(tw, "%s\tunreachable\t\t\t\t\t\t\n", endpoint)
+			continue
+		}
+		role := s.status.State
+		if s.status.Witness {
+			role += " (witness)"
+		}
+		lag := "-"
+		if leaderCommit >= 0 {
+			lag = fmt.Sprint(leaderCommit - s.status.LastApplied)
+		}
+		qps, errRate := "-", "-"
+		requests := func(s *sample) float64 { return s.requests }
+		errors := func(s *sample) float64 { return s.errors }
+		if r, ok := rate(prev[endpoint], s, requests); ok {
+			qps = fmt.Sprintf("%.1f", r)
+		}
+		if r, ok := rate(prev[endpoint], s, errors); ok {
+			errRate = fmt.Sprintf("%.1f", r)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			endpoint, role, s.status.Term, s.status.CommitIndex,
+			s.status.LastApplied, lag, qps, errRate)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,45 @@
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTop(t *testing.T) {
+	start := time.Now()
+	prev := map[string]*sample{
+		"a:8080": {at: start, status: &status{}, requests: 100, errors: 2},
+	}
+	cur := map[string]*sample{
+		"a:8080": {
+			at:       start.Add(2 * time.Second),
+			status:   &status{State: "Leader", Term: 3, CommitIndex: 50, LastApplied: 50},
+			requests: 120,
+			errors:   4},
+		"b:8080": {
+			at:     start.Add(2 * time.Second),
+			status: &status{State: "Follower", Term: 3, CommitIndex: 48, LastApplied: 45}},
+		"c:8080": {err: errors.New("connection refused")},
+	}
+
+	var out bytes.Buffer
+	renderTop(&out, []string{"a:8080", "b:8080", "c:8080"}, prev, cur)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected header and 3 rows, got:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "a:8080 Leader 3 50 50 0 10.0 1.0" {
+		t.Errorf("Unexpected row for leader: %s", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != "b:8080 Follower 3 48 45 5 - -" {
+		t.Errorf("Unexpected row for follower: %s", lines[2])
+	}
+	if !strings.Contains(lines[3], "unreachable") {
+		t.Errorf("Expected unreachable node to be reported, got: %s", lines[3])
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for the admin dashboard
+// @Summary Return a dashboard page showing cluster state, recent events, and metrics
+// @ID admin-ui
+// @Accept */*
+// @Produce text/html
+// @Success 200 {string} string "HTML page"
+// @Router /admin/ui [get]
+func (ctl *Controller) handleDashboard(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(dashboardHTML))
+}
+
+// dashboardHTML is a single page that polls the admin API and metrics route of
+// the node serving it. It has no external dependencies, so it works on hosts
+// without internet access
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LeifDB</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.down { color: #b00; }
+.metric { display: inline-block; margin: 0 1.5em 1em 0; }
+.metric svg { display: block; background: #f6f6f6; }
+#error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>LeifDB <span id="node"></span></h1>
+<div id="error"></div>
+<h2>Node</h2>
+<table id="status"></table>
+<h2>Peers</h2>
+<div id="peers"></div>
+<h2>Recent events</h2>
+<table id="events"></table>
+<h2>Metrics</h2>
+<div id="metrics"></div>
+<script>
+var series = {};
+var historyLength = 60;
+
+function text(value) {
+  return document.createTextNode(String(value));
+}
+
+function row(table, cells, header) {
+  var tr = document.createElement("tr");
+  cells.forEach(function (cell) {
+    var td = document.createElement(header ? "th" : "td");
+    if (cell instanceof Node) {
+      td.appendChild(cell);
+    } else {
+      td.appendChild(text(cell));
+    }
+    tr.appendChild(td);
+  });
+  table.appendChild(tr);
+  return tr;
+}
+
+function clear(el) {
+  while (el.firstChild) {
+    el.removeChild(el.firstChild);
+  }
+}
+
+function renderStatus(s) {
+  document.getElementById("node").textContent = s.id;
+  var table = document.getElementById("status");
+  clear(table);
+  row(table, ["Role", s.state + (s.witness ? " (witness)" : "")]);
+  row(table, ["Term", s.term]);
+  var leader = text(s.leader || "unknown");
+  if (s.leader && s.state !== "Leader") {
+    leader = document.createElement("a");
+    leader.href = "http://" + s.leader + "/admin/ui";
+    leader.textContent = s.leader;
+  }
+  row(table, ["Leader", leader]);
+  row(table, ["Last log index", s.lastLogIndex]);
+  row(table, ["Commit index", s.commitIndex]);
+  row(table, ["Last applied", s.lastApplied]);
+  row(table, ["Caught up", s.caughtUp]);
+
+  var peers = document.getElementById("peers");
+  clear(peers);
+  var ptable = document.createElement("table");
+  row(ptable, ["Peer", "Available", "RTT (ms)", "Match index", "Lag"], true);
+  (s.peers || []).forEach(function (p) {
+    // replication progress is only reported by the leader
+    var progress = function (v) { return v === undefined ? "-" : v; };
+    var tr = row(ptable, [p.id, p.available, p.rttMillis.toFixed(2),
+      progress(p.matchIndex), progress(p.lag)]);
+    if (!p.available) {
+      tr.className = "down";
+    }
+  });
+  peers.appendChild(ptable);
+}
+
+function renderEvents(events) {
+  var table = document.getElementById("events");
+  clear(table);
+  row(table, ["Time", "Event", "Term", "Node"], true);
+  events.slice().reverse().forEach(function (e) {
+    row(table, [new Date(e.time).toLocaleTimeString(), e.type, e.term, e.node]);
+  });
+}
+
+function parseMetrics(body) {
+  var values = {};
+  body.split("\n").forEach(function (line) {
+    // histogram buckets are too many to chart, but their sums and counts are
+    if (line === "" || line.charAt(0) === "#" || line.indexOf("_bucket{") >= 0) {
+      return;
+    }
+    var parts = line.trim().split(/\s+/);
+    values[parts[0]] = parseFloat(parts[1]);
+  });
+  return values;
+}
+
+function sparkline(values) {
+  var width = 180, height = 40;
+  var min = Math.min.apply(null, values), max = Math.max.apply(null, values);
+  var range = max - min || 1;
+  var points = values.map(function (v, i) {
+    var x = (i / (historyLength - 1)) * width;
+    var y = height - 2 - ((v - min) / range) * (height - 4);
+    return x.toFixed(1) + "," + y.toFixed(1);
+  });
+  var ns = "http://www.w3.org/2000/svg";
+  var svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("width", width);
+  svg.setAttribute("height", height);
+  var line = document.createElementNS(ns, "polyline");
+  line.setAttribute("points", points.join(" "));
+  line.setAttribute("fill", "none");
+  line.setAttribute("stroke", "#36c");
+  svg.appendChild(line);
+  return svg;
+}
+
+function renderMetrics(values) {
+  Object.keys(values).forEach(function (name) {
+    var h = series[name] || (series[name] = []);
+    h.push(values[name]);
+    if (h.length > historyLength) {
+      h.shift();
+    }
+  });
+  var el = document.getElementById("metrics");
+  clear(el);
+  Object.keys(series).sort().forEach(function (name) {
+    var h = series[name];
+    var div = document.createElement("div");
+    div.className = "metric";
+    div.appendChild(text(name + ": " + h[h.length - 1]));
+    div.appendChild(sparkline(h));
+    el.appendChild(div);
+  });
+}
+
+function get(path, parse) {
+  return fetch(path).then(function (resp) {
+    if (!resp.ok) {
+      throw new Error(path + ": " + resp.status);
+    }
+    return parse(resp);
+  });
+}
+
+function refresh() {
+  Promise.all([
+    get("/admin/status", function (r) { return r.json(); }),
+    get("/admin/events", function (r) { return r.json(); }),
+    get("/metrics", function (r) { return r.text(); })
+  ]).then(function (results) {
+    document.getElementById("error").textContent = "";
+    renderStatus(results[0]);
+    renderEvents(results[1].events);
+    var values = parseMetrics(results[2]);
+    values["commit index"] = results[0].commitIndex;
+    renderMetrics(values);
+  }).catch(function (err) {
+    document.getElementById("error").textContent = "Error: " + err.message;
+  });
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -28,58 +28,44 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/db/{key}": {
+        "/admin/batching": {
             "get": {
+                "description": "Sets and deletes are appended to the log in batches of up to\nmaxEntries writes and maxBytes bytes, the first waiting up to\nmaxDelayMicros for others to join. The sizes of the batches\nappended are reported by the leifdb_proposal_batch_size metric.",
                 "consumes": [
                     "*/*"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Return value from database by key",
-                "operationId": "db-read",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Key",
-                        "name": "key",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
+                "summary": "Return how writes proposed at about the same time are batched",
+                "operationId": "admin-batching",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.ReadResponse"
+                            "$ref": "#/definitions/node.ProposalBatching"
                         }
                     }
                 }
             },
             "put": {
+                "description": "The new limits apply to batches opened from now on. A\nmaxEntries of 1 or less disables batching, and a maxBytes of 0\nmeans unlimited. The change lasts until the node restarts.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Write value to database by key",
-                "operationId": "db-write",
+                "summary": "Change how writes proposed at about the same time are batched",
+                "operationId": "admin-batching-set",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Key",
-                        "name": "key",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Value",
-                        "name": "body",
+                        "description": "Batching limits",
+                        "name": "batching",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.WriteRequest"
+                            "$ref": "#/definitions/node.ProposalBatching"
                         }
                     }
                 ],
@@ -87,130 +73,4675 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.WriteResponse"
+                            "$ref": "#/definitions/node.ProposalBatching"
                         }
                     },
-                    "307": {
-                        "description": "Temporary Redirect",
+                    "400": {
+                        "description": "Error message",
                         "schema": {
-                            "type": "string"
-                        },
-                        "headers": {
-                            "Location": {
-                                "type": "string",
-                                "description": "Redirect address of the current leader"
-                            }
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/compact": {
+            "post": {
+                "description": "Takes a snapshot regardless of the size of the log, then\ndiscards the log entries it covers, keeping the most recent\nretain of them (by default, the number configured for automatic\ncompaction). Returns once the compaction has been requested.\nPoll /admin/snapshot/status for lastCompaction to find when it\nis done.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Take a snapshot and compact the log now, e.g. before maintenance",
+                "operationId": "admin-compact",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of entries covered by the snapshot to keep in the log",
+                        "name": "retain",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/mgmt.SnapshotStatus"
                         }
                     },
                     "400": {
                         "description": "Error message",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/admin/demote": {
+            "post": {
+                "description": "The followers elect a new leader once their election timers\nexpire, as they would after a failure. The demoted node votes in\nthat election, but does not stand for election until the\nholdoff has passed.",
                 "consumes": [
                     "*/*"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Delete item from database by key",
-                "operationId": "db-delete",
+                "summary": "Make this node step down as leader, without choosing a successor",
+                "operationId": "admin-demote",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Key",
-                        "name": "key",
-                        "in": "path",
-                        "required": true
+                        "description": "Time before the node may stand for election again, e.g. 5s (default 2s)",
+                        "name": "holdoff",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.DeleteResponse"
+                            "$ref": "#/definitions/main.DemoteResponse"
                         }
                     },
-                    "307": {
-                        "description": "Temporary Redirect",
+                    "400": {
+                        "description": "Error message",
                         "schema": {
-                            "type": "string"
-                        },
-                        "headers": {
-                            "Location": {
-                                "type": "string",
-                                "description": "Redirect address of current leader"
-                            }
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "This node is not the leader",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/health": {
+        "/admin/disk": {
             "get": {
+                "description": "Lists each file in the data directory with its size and\ncomponent (log, snapshots, or other), totals for each\ncomponent, and the free and total size of the volume holding\nthe directory (where it can be measured).",
                 "consumes": [
                     "*/*"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Return server health status",
-                "operationId": "http-health",
+                "summary": "Return the files in this node's data directory, and free space",
+                "operationId": "admin-disk",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.HealthResponse"
+                            "$ref": "#/definitions/mgmt.DiskUsage"
+                        }
+                    },
+                    "500": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.DeleteResponse": {
-            "type": "object",
-            "properties": {
-                "status": {
-                    "type": "string"
+        },
+        "/admin/drain": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the progress of draining this node for maintenance",
+                "operationId": "admin-drain-status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.DrainStatus"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Stop accepting writes on this node, optionally transferring leadership",
+                "operationId": "admin-drain",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Hand leadership to another node (default false)",
+                        "name": "transfer",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.DrainStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Accept writes on this node again after draining",
+                "operationId": "admin-resume",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.DrainStatus"
+                        }
+                    }
                 }
             }
         },
-        "main.HealthResponse": {
-            "type": "object",
-            "properties": {
-                "status": {
-                    "type": "string"
-                },
-                "version": {
-                    "type": "string"
+        "/admin/entries": {
+            "get": {
+                "description": "Only entries that have not been compacted into a snapshot are\nlisted, without their values. Times are RFC 3339.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the log entries appended between two times, oldest first",
+                "operationId": "admin-entries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Start of the range, inclusive (default: the oldest entry)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the range, exclusive (default: now)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of entries (default 100, 0 for no limit)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.EntriesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "main.ReadResponse": {
-            "type": "object",
-            "properties": {
-                "value": {
-                    "type": "string"
+        "/admin/events": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return recent cluster events observed by this node, oldest first",
+                "operationId": "admin-events",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.EventsResponse"
+                        }
+                    }
                 }
             }
         },
-        "main.WriteRequest": {
-            "type": "object",
-            "properties": {
-                "value": {
-                    "type": "string"
+        "/admin/hotkeys": {
+            "get": {
+                "description": "Reads are those served by this node, and writes those applied\nby it (the same on every member). A sample of reads and writes\nis counted, over the last one to two minutes, and only the most\nactive keys are tracked, so the rates are estimates.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the keys (or key prefixes) read and written most on this node",
+                "operationId": "admin-hot-keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "key (default) or prefix (a key up to its first ':' or '/')",
+                        "name": "by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "reads, writes, bytes, or total (default)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of keys to return (default 20)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HotKeysResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "main.WriteResponse": {
-            "type": "object",
-            "properties": {
-                "status": {
+        "/admin/keys/sample": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return keys chosen at random, optionally with their sizes and expiry times",
+                "operationId": "admin-sample",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of keys (default 10)",
+                        "name": "n",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include sizes and expiry times",
+                        "name": "details",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SampleResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/stats": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the number of keys and bytes for each key prefix",
+                "operationId": "admin-stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Delimiter ending a prefix (default :)",
+                        "name": "delimiter",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.StatsResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/locks": {
+            "get": {
+                "description": "Locks are as of the last entry applied by this node, sorted by\nname.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return every lock held, with its owner, acquisition time, and lease",
+                "operationId": "admin-locks",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most locks to return (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return the locks after this name (next of the previous page)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated fields to return of each lock (default all)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LocksResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/log": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the log level of each subsystem, and its sampling rate",
+                "operationId": "admin-log",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/logging.Config"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Subsystems not in the request are left as they are. A subsystem\nwith an empty level uses the default level. The change lasts\nuntil the node restarts.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change the default log level, or the level and sampling of subsystems",
+                "operationId": "admin-log-set",
+                "parameters": [
+                    {
+                        "description": "Log configuration",
+                        "name": "config",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/logging.Config"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/logging.Config"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/members": {
+            "get": {
+                "description": "Lists what /admin/status reports under peers, a page at a\ntime: members are sorted by ID.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return this node's view of each other member of the cluster",
+                "operationId": "admin-members",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most members to return (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return the members after this ID (next of the previous page)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated fields to return of each member (default all)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.MembersResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Adds and removes any number of members at once, safely: the\nmembers being added are bootstrapped (see POST /admin/members),\nthen a joint membership of the old and new members is committed\nthrough the log, in which commits and elections need a majority\nof each, and then the new membership. Returns once the new\nmembership is committed. The leader must be one of the new\nmembers, and only one change can be in progress at a time.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change the members of the cluster to a new set",
+                "operationId": "admin-change-members",
+                "parameters": [
+                    {
+                        "description": "New members",
+                        "name": "members",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ChangeMembersRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ChangeMembersResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The leader is not a new member, or a change is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Adds the node at the address as a learner, which is sent\nentries but does not count toward the majority. The leader\nsends it a snapshot of the database, then the entries after the\nsnapshot, and promotes it to a voter once it has caught up (see\n/admin/members/bootstrap). The membership including it is then\ncommitted through the log, so every member learns of it. With\nlearner set, the member is left a learner once it has caught\nup, until it is promoted. Returns as soon as the member is\nadded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add a member to the cluster and bootstrap it from a snapshot",
+                "operationId": "admin-add-member",
+                "parameters": [
+                    {
+                        "description": "New member",
+                        "name": "member",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.AddMemberRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/node.BootstrapStatus"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Already a member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/members/bootstrap": {
+            "get": {
+                "description": "Reports each member added with POST or PUT /admin/members while\nthis node was the leader: the phase it is in (snapshot,\ncatching-up, caught-up, promoted, or failed), how much of the\nsnapshot has been sent, and how far it has caught up. Members\nare sorted by address.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the progress of bootstrapping members added to the cluster",
+                "operationId": "admin-bootstraps",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most members to return (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return the members after this address (next of the previous page)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated fields to return of each member (default all)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BootstrapsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/members/{address}": {
+            "delete": {
+                "description": "Commits the membership without the member through the log,\nand returns once it is committed. Every member then stops\nsending requests to it and refuses its vote requests, so it\nshould be shut down. A member still being bootstrapped is\nremoved at once. The leader can't remove itself: transfer\nleadership first.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove a member from the cluster",
+                "operationId": "admin-remove-member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Raft address of the member",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RemoveMemberResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not a member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The member is the leader",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/members/{address}/isolation": {
+            "put": {
+                "description": "This node stops sending the member append requests and asking\nfor its vote, and refuses its vote requests, until the duration\nhas passed. Isolation is local to this node and not kept across\nrestarts: isolate the member on each node to keep it out of\nelections, and on the leader to stop sending it entries.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Cut a member off from this node for a while, without removing it",
+                "operationId": "admin-isolate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Raft address of the member",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "How long to isolate the member, e.g. 30m (default 10m)",
+                        "name": "duration",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IsolationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "The address is not a member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The address is this node's own",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Stop isolating a member from this node before the isolation lapses",
+                "operationId": "admin-unisolate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Raft address of the member",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "The address is not a member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/members/{address}/promote": {
+            "post": {
+                "description": "Commits the membership including the learner (added with\nlearner set, see POST /admin/members) through the log, and\nreturns once it is committed. The learner must have caught up\nwith the leader (phase caught-up in /admin/members/bootstrap).",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Promote a learner to a voting member",
+                "operationId": "admin-promote-member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Raft address of the learner",
+                        "name": "address",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.PromoteMemberResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not a member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Not a learner, or not caught up",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/namespaces": {
+            "get": {
+                "description": "Policies are as of the last entry applied by this node, sorted\nby namespace.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the namespaces that have a policy, with their policies",
+                "operationId": "admin-namespaces",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most namespaces to return (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return the namespaces after this name (next of the previous page)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated fields to return of each namespace (default all)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.NamespacesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/namespaces/{name}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the policy of a namespace, and its number of keys",
+                "operationId": "admin-namespace",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Namespace (the part of its keys before the first ':')",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.NamespaceResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Namespace has no policy",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "The policy is committed to the log, so it applies on every\nmember, and survives restarts and changes of leader. It applies\nto writes made after it is set, and is not applied to keys\nalready in the namespace.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Set the access, limits, and default time to live of a namespace",
+                "operationId": "admin-namespace-set",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Namespace (the part of its keys before the first ':')",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Policy",
+                        "name": "policy",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/node.NamespacePolicy"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.NamespaceResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove the policy of a namespace, lifting its restrictions",
+                "operationId": "admin-namespace-delete",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Namespace (the part of its keys before the first ':')",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DeleteResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Namespace has no policy",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/readonly": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return whether writes are disabled on this node or the cluster",
+                "operationId": "admin-readonly-status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.ReadOnlyStatus"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "With scope=node, writes are disabled on this node (which only\nmatters while it is the leader) until re-enabled or it restarts.\nWith scope=cluster, an entry disabling writes is committed to the\nlog, so that it applies to every member, and survives restarts\nand changes of leader. Rejected writes get a ReadOnly error.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Disable writes on this node or the whole cluster, while serving reads",
+                "operationId": "admin-readonly",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "node (default) or cluster",
+                        "name": "scope",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Reason reported to rejected writes (default maintenance)",
+                        "name": "reason",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.ReadOnlyStatus"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Enable writes on this node or the whole cluster again",
+                "operationId": "admin-readwrite",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "node (default) or cluster",
+                        "name": "scope",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.ReadOnlyStatus"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/reseed": {
+            "post": {
+                "description": "Downloads a snapshot from the leader, verifies its checksum,\nand installs it in place of the local database, log, and\nsnapshots. The leader then sends every entry after the snapshot,\nand the node serves reads once it has caught up. Lifts a\nquarantine. Returns once the snapshot is installed.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Replace this node's state with a snapshot of the leader's database",
+                "operationId": "admin-reseed",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/mgmt.SnapshotStatus"
+                        }
+                    },
+                    "409": {
+                        "description": "This node is the leader",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "No leader is known",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/snapshot": {
+            "get": {
+                "description": "The snapshot is in the same format as the snapshot files in the\ndata directory. It is built for the request, not read from disk,\nand is sent no faster than the node's snapshot transfer limit.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "summary": "Return a snapshot of the database as of the last applied entry",
+                "operationId": "admin-snapshot",
+                "responses": {
+                    "200": {
+                        "description": "Snapshot",
+                        "schema": {
+                            "type": "string"
+                        },
+                        "headers": {
+                            "X-Leifdb-Applied-Hash": {
+                                "type": "integer",
+                                "description": "Applied hash of this node as of that entry (0 if not known)"
+                            },
+                            "X-Leifdb-Checksum": {
+                                "type": "integer",
+                                "description": "CRC-32 (IEEE) of the snapshot"
+                            },
+                            "X-Leifdb-Last-Applied": {
+                                "type": "integer",
+                                "description": "Index of the last log entry reflected in the snapshot"
+                            },
+                            "X-Leifdb-Last-Term": {
+                                "type": "integer",
+                                "description": "Term of that entry"
+                            },
+                            "X-Leifdb-Sha256": {
+                                "type": "string",
+                                "description": "SHA-256 of the snapshot, hex encoded"
+                            }
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Returns once the snapshot has been requested. Poll\n/admin/snapshot/status to find when it has been taken.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Take a snapshot to disk now, rather than waiting for the log to grow",
+                "operationId": "admin-snapshot-trigger",
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/mgmt.SnapshotStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/snapshot/status": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the state of the snapshots this node keeps on disk",
+                "operationId": "admin-snapshot-status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/mgmt.SnapshotStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/status": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return raft status of this node",
+                "operationId": "admin-status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.StatusResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/transfer": {
+            "post": {
+                "description": "The leader brings its followers up to date, then asks the target\n(or, without one, any follower with every log entry) to stand\nfor election at once, rather than waiting for an election\ntimeout. Returns once the target has accepted; it is the leader\nonce it has won the election, in the next term.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Hand leadership from this node to a chosen member",
+                "operationId": "admin-transfer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Raft address of the member to take over",
+                        "name": "target",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransferResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "The target is not a voting member",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "This node is not the leader, or the target is behind or refused",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/trash": {
+            "get": {
+                "description": "Values are as of the last entry applied by this node, sorted by\nkey.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return every value in the trash, with its key and when it expires",
+                "operationId": "admin-trash",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Most values to return (default all)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Return the values after this key (next of the previous page)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated fields to return of each value (default all)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TrashResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/ui": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "summary": "Return a dashboard page showing cluster state, recent events, and metrics",
+                "operationId": "admin-ui",
+                "responses": {
+                    "200": {
+                        "description": "HTML page",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/barrier": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the leader's commit index after confirming leadership",
+                "operationId": "read-barrier",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BarrierResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/db/_batch": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Apply a list of set, delete, and compare-and-swap operations as a single write",
+                "operationId": "db-batch",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "A compare-and-swap failed, so nothing was applied",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/db/_copy": {
+            "post": {
+                "description": "Reading the key and writing the other are one step, so no\nwrite can come between them. The key written takes the expiry\ntime of the key read (or none). The outcome is only known once\nthe entry is applied, so the response waits for that even with\nthe leader write concern.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Copy the value of a key, and its expiry time, to another key",
+                "operationId": "db-copy",
+                "parameters": [
+                    {
+                        "description": "Keys",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MoveRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: majority (default) or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WriteResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "The key to read has no value",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The key to write has a value, and replace is false",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/db/_rename": {
+            "post": {
+                "description": "Reading the key and writing the other are one step, so no\nwrite can come between them. The key written takes the expiry\ntime of the key read (or none). The outcome is only known once\nthe entry is applied, so the response waits for that even with\nthe leader write concern.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Move the value of a key, and its expiry time, to another key",
+                "operationId": "db-rename",
+                "parameters": [
+                    {
+                        "description": "Keys",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MoveRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: majority (default) or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WriteResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "The key to read has no value",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The key to write has a value, and replace is false",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/db/_txn": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Apply one of two lists of set and delete operations, depending on whether a list of conditions holds",
+                "operationId": "db-txn",
+                "parameters": [
+                    {
+                        "description": "Conditions and operations",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TxnRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TxnResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/db/{key}": {
+            "get": {
+                "description": "With max_staleness (such as 500ms), the read is only served if\nthis node's data is known to be current as of that long ago\n(see Node.Staleness), and the response includes its age.\nOtherwise, it is redirected to the leader. With offset or\nlength, only that range of bytes of the value is returned\n(empty past the end of the value), along with the size of the\nwhole value. With linearizable, the read is served by the\nleader once it has confirmed its leadership, within its lease\n(see Node.AwaitLinearizable) or by a round of append requests,\nand reflects every write acknowledged before it.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return value from database by key",
+                "operationId": "db-read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bound on the age of the data read, such as 500ms",
+                        "name": "max_staleness",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Serve the read on the leader, reflecting every acknowledged write (default false)",
+                        "name": "linearizable",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Byte of the value to start reading at (default 0)",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Most bytes of the value to read (default the rest of it)",
+                        "name": "length",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReadResponse"
+                        },
+                        "headers": {
+                            "X-Leifdb-Index": {
+                                "type": "integer",
+                                "description": "Index of the last log entry applied before the read"
+                            }
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness, or too stale)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Write value to database by key",
+                "operationId": "db-write",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Value",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.WriteRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WriteResponse"
+                        },
+                        "headers": {
+                            "X-Leifdb-Index": {
+                                "type": "integer",
+                                "description": "Index of the log entry for the write"
+                            },
+                            "X-Leifdb-Term": {
+                                "type": "integer",
+                                "description": "Term of the log entry for the write"
+                            }
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete item from database by key",
+                "operationId": "db-delete",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DeleteResponse"
+                        },
+                        "headers": {
+                            "X-Leifdb-Index": {
+                                "type": "integer",
+                                "description": "Index of the log entry for the write"
+                            },
+                            "X-Leifdb-Term": {
+                                "type": "integer",
+                                "description": "Term of the log entry for the write"
+                            }
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid write concern",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "head": {
+                "description": "Responds with the headers of a read: 404 if the key has no\nvalue, or the size in bytes of the value in X-Leifdb-Value-Size.",
+                "consumes": [
+                    "*/*"
+                ],
+                "summary": "Return the size of a value, without the value",
+                "operationId": "db-head",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Key has a value",
+                        "headers": {
+                            "X-Leifdb-Index": {
+                                "type": "integer",
+                                "description": "Index of the last log entry applied before the read"
+                            },
+                            "X-Leifdb-Value-Size": {
+                                "type": "integer",
+                                "description": "Size of the value in bytes"
+                            }
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Key has no value",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/entries/{index}": {
+            "get": {
+                "description": "Optionally waits for the node to apply the index first. The\nleader has the most up-to-date view: on a follower, an entry\nthat is committed may still be reported as pending",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return whether the log entry at an index in a term was committed",
+                "operationId": "entry-status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Log index",
+                        "name": "index",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Term of the entry",
+                        "name": "term",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "How long to wait for the index to be applied, e.g. 5s (default 0)",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.EntryResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "description": "Keys are exported in order, as of when the request started.\nSets and sorted sets are not included, nor are keys in\nnamespaces whose policy denies reads. The filters are applied\non the server as keys are visited, so only matching keys are sent.\n\nThe format is chosen by the format parameter, or else by the\nAccept header: NDJSON (one ExportRecord per line, the default),\nCSV (a key,value,expiresAt header, then one row per key), or\nprotobuf (ExportRecord messages from api/raft.proto, each preceded\nby its length as a varint).",
+                "produces": [
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/x-protobuf"
+                ],
+                "summary": "Stream every key with a prefix, and its value, as newline-delimited JSON, CSV, or protobuf",
+                "operationId": "export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Format: ndjson (default), csv, or protobuf",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only export keys with this prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only export keys matching this regular expression",
+                        "name": "match",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only export keys whose value contains this string",
+                        "name": "contains",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only export keys whose JSON value satisfies a query, such as $.status == \\",
+                        "name": "where",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "One record per line",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExportRecord"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter or format",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/find": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return keys whose JSON value matches a query on an indexed path",
+                "operationId": "index-find",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Query, such as $.status == \\",
+                        "name": "where",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.FindResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return server health status",
+                "operationId": "http-health",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/index": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the paths of all secondary indexes",
+                "operationId": "index-list",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IndexListResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create a secondary index on a path within JSON values",
+                "operationId": "index-create",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Path, such as $.status",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IndexResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Drop the secondary index on a path",
+                "operationId": "index-drop",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Path, such as $.status",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IndexResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid write concern",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/lock/{name}": {
+            "get": {
+                "description": "The lock is as of the last entry applied by this node.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the owner, acquisition time, and lease of a lock",
+                "operationId": "lock-read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.Lock"
+                        }
+                    },
+                    "404": {
+                        "description": "Lock is not held",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Tries once, without waiting: if another owner holds the lock,\nthe response is a 409 naming the holder. Whether the lock is\nfree is decided when the entry is applied, so of two owners\nracing for it, only one gets it. A lock that is not renewed\nbefore its lease runs out is free to be taken again.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Take a lock if it is free, or renew it if the owner already holds it",
+                "operationId": "lock-acquire",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Owner and lease",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.LockRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.Lock"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The lock is held by another owner (see holder)",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many pending writes, or draining",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Retry-After": {
+                                "type": "string",
+                                "description": "Seconds to wait before retrying"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Release a lock held by an owner",
+                "operationId": "lock-release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Lock name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Owner releasing the lock",
+                        "name": "owner",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DeleteResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Lock is not held by the owner",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/set/{key}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the members of a set",
+                "operationId": "set-members",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SMembersResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add members to a set",
+                "operationId": "set-add",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Members",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SAddRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SetResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove members from a set",
+                "operationId": "set-remove",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Members to remove",
+                        "name": "member",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SetResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid write concern",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/set/{key}/{member}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return whether a value is a member of a set",
+                "operationId": "set-is-member",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Member",
+                        "name": "member",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SIsMemberResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/topology": {
+            "get": {
+                "description": "Returns the topology last published by a leader (with\nLEIFDB_PUBLISH_TOPOLOGY set) to the _leifdb/topology system key,\nas of the last entry applied by this node: the leader's Raft and\nclient addresses, its term, and every member it knew of.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the membership of the cluster and its leader",
+                "operationId": "http-topology",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.Topology"
+                        }
+                    },
+                    "404": {
+                        "description": "No topology has been published",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/trash/{key}": {
+            "get": {
+                "description": "Deleted values are only kept if the node is configured with a\nrestore window (LEIFDB_TRASH_TTL). The value is as of the last\nentry applied by this node.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the value of a deleted key kept in the trash, and when it expires",
+                "operationId": "trash-read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/node.TrashedValue"
+                        }
+                    },
+                    "404": {
+                        "description": "Key has no value in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/trash/{key}/restore": {
+            "post": {
+                "description": "Fails if the key has been written since it was deleted. The\noutcome is only known once the entry is applied, so the\nresponse waits for that even with the leader write concern.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Restore the value of a deleted key from the trash",
+                "operationId": "trash-restore",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: majority (default) or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WriteResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Key has no value in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Key has been written since it was deleted",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/ttl/{key}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return the remaining time before a key expires",
+                "operationId": "ttl-read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TTLResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Set a key to expire after a duration, without changing its value",
+                "operationId": "ttl-touch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Time to live, such as 30s or 15m",
+                        "name": "ttl",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExistsResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove the expiry time of a key, so that it no longer expires",
+                "operationId": "ttl-persist",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ExistsResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid write concern",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/watch": {
+            "get": {
+                "description": "Returns changes to keys with the prefix made after revision\n` + "`" + `after` + "`" + `, or if there are none yet, waits for one. Changes to\nsets, sorted sets, and TTLs are not included.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Wait for changes to keys, starting after a revision",
+                "operationId": "db-watch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only return changes to keys with this prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only return changes made after this revision (default -1, all that are kept)",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "How long to wait for a change, e.g. 10s (default 30s)",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WatchResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "410": {
+                        "description": "Changes after the revision are no longer kept",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/zset/{key}": {
+            "get": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Return members of a sorted set with scores in a range",
+                "operationId": "zset-range",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Minimum score, inclusive (default -inf)",
+                        "name": "min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Maximum score, inclusive (default +inf)",
+                        "name": "max",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of members to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ZRangeResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader (when this node is a witness)"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add members to a sorted set, or update their scores",
+                "operationId": "zset-add",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Members and scores",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ZAddRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ZSetResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Error message",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove members from a sorted set",
+                "operationId": "zset-remove",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Members to remove",
+                        "name": "member",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Write concern: leader, majority (default), or apply",
+                        "name": "concern",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ZSetResponse"
+                        }
+                    },
+                    "307": {
+                        "description": "Temporary Redirect",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "Redirect address of the current leader"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid write concern",
+                        "schema": {
+                            "$ref": "#/definitions/main.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "database.BatchOp": {
+            "type": "object",
+            "properties": {
+                "expected": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "database.Compare": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "database.KeySample": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "description": "ExpiresAt is the expiry time of the key in Unix nanoseconds, or 0 if the\nkey does not expire",
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "size": {
+                    "description": "Size is the number of bytes in the key and value",
+                    "type": "integer"
+                }
+            }
+        },
+        "database.PrefixStats": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "expiring": {
+                    "type": "integer"
+                },
+                "keys": {
+                    "type": "integer"
+                },
+                "prefix": {
+                    "type": "string"
+                }
+            }
+        },
+        "database.ScoredMember": {
+            "type": "object",
+            "properties": {
+                "member": {
+                    "type": "string"
+                },
+                "score": {
+                    "type": "number"
+                }
+            }
+        },
+        "logging.Config": {
+            "type": "object",
+            "properties": {
+                "level": {
+                    "description": "Level of subsystems that do not have their own",
+                    "type": "string"
+                },
+                "subsystems": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/logging.SubsystemConfig"
+                    }
+                }
+            }
+        },
+        "logging.SubsystemConfig": {
+            "type": "object",
+            "properties": {
+                "level": {
+                    "description": "Level of this subsystem (the default level if empty)",
+                    "type": "string"
+                },
+                "sample": {
+                    "description": "Only one in every Sample trace and debug messages is logged (0 or 1 to\nlog them all)",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.AddMemberRequest": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "description": "Raft address (\"host:port\") of the new member",
+                    "type": "string"
+                },
+                "learner": {
+                    "description": "Whether to leave the member a learner once it has caught up, until it\nis promoted (see /admin/members/{address}/promote)",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.BarrierResponse": {
+            "type": "object",
+            "properties": {
+                "commitIndex": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "bestEffort": {
+                    "description": "Apply the operations that can be, rather than all or nothing",
+                    "type": "boolean"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.BatchOp"
+                    }
+                }
+            }
+        },
+        "main.BatchResponse": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "description": "Whether each operation was applied (omitted if the write concern is\n\"leader\", since the batch has not been applied yet)",
+                    "type": "array",
+                    "items": {
+                        "type": "boolean"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.BootstrapsResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.BootstrapStatus"
+                    }
+                },
+                "next": {
+                    "description": "Address of the last member listed, to pass as ` + "`" + `after` + "`" + ` for the next\npage (absent on the last page)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.Change": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "revision": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ChangeMembersRequest": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "description": "Raft address of every voting member after the change",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.ChangeMembersResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "description": "Raft address of every voting member, once the change is committed",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.DeleteResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DemoteResponse": {
+            "type": "object",
+            "properties": {
+                "holdoffUntil": {
+                    "description": "Time until which the node does not stand for election",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "term": {
+                    "description": "Term in which the node was leader",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.EntriesResponse": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.EntryInfo"
+                    }
+                },
+                "indexAtEnd": {
+                    "description": "Index of the last entry appended at or before ` + "`" + `to` + "`" + `, if it is still in\nthe log (the point to restore to for the state as of that time)",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.EntryResponse": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "description": "Whether this node has applied the entry at the index (which is only the\nentry in question if it is committed)",
+                    "type": "boolean"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "committed, pending, lost, or unknown (see node.EntryStatus)",
+                    "type": "string"
+                },
+                "term": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "expectedVersion": {
+                    "description": "Version the request expected the data to have, for Conflict errors",
+                    "type": "integer"
+                },
+                "holder": {
+                    "description": "The lock that another owner holds, for Conflict errors taking a lock",
+                    "type": "object",
+                    "$ref": "#/definitions/node.Lock"
+                },
+                "index": {
+                    "description": "Log index of a write that may or may not have been committed, for\nTimeout and DeadlineExceeded errors",
+                    "type": "integer"
+                },
+                "leader": {
+                    "description": "Client address of the current leader, for NotLeader errors (if known)",
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "retryable": {
+                    "description": "Whether the same request may succeed if retried later",
+                    "type": "boolean"
+                },
+                "term": {
+                    "description": "Term of the log entry at Index",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.EventsResponse": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.Event"
+                    }
+                }
+            }
+        },
+        "main.ExistsResponse": {
+            "type": "object",
+            "properties": {
+                "exists": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.ExportRecord": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FindResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HotKey": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string"
+                },
+                "overcount": {
+                    "description": "How much of Reads and Writes combined may belong to other keys, which\nwere displaced from the counts by this one (0 if the rates are exact,\nup to sampling)",
+                    "type": "number"
+                },
+                "reads": {
+                    "type": "number"
+                },
+                "writeBytes": {
+                    "type": "number"
+                },
+                "writes": {
+                    "type": "number"
+                }
+            }
+        },
+        "main.HotKeysResponse": {
+            "type": "object",
+            "properties": {
+                "by": {
+                    "description": "\"key\" or \"prefix\"",
+                    "type": "string"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HotKey"
+                    }
+                },
+                "sampleEvery": {
+                    "description": "One in this many reads and writes is counted (the rates are scaled to\naccount for it)",
+                    "type": "integer"
+                },
+                "seconds": {
+                    "description": "Time the rates are measured over, in seconds",
+                    "type": "number"
+                },
+                "sort": {
+                    "description": "What keys are ranked by: \"reads\", \"writes\", \"bytes\", or \"total\"",
+                    "type": "string"
+                }
+            }
+        },
+        "main.IndexListResponse": {
+            "type": "object",
+            "properties": {
+                "paths": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.IndexResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.IsolationResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "until": {
+                    "description": "Time at which the isolation lapses",
+                    "type": "string"
+                }
+            }
+        },
+        "main.LockRequest": {
+            "type": "object",
+            "properties": {
+                "leaseMs": {
+                    "description": "Length of the lease, in milliseconds",
+                    "type": "integer"
+                },
+                "owner": {
+                    "description": "Who is taking the lock (any string the clients sharing it agree on)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.LocksResponse": {
+            "type": "object",
+            "properties": {
+                "locks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.Lock"
+                    }
+                },
+                "next": {
+                    "description": "Name of the last lock listed, to pass as ` + "`" + `after` + "`" + ` for the next page\n(absent on the last page)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.MembersResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.PeerResponse"
+                    }
+                },
+                "next": {
+                    "description": "ID of the last member listed, to pass as ` + "`" + `after` + "`" + ` for the next page\n(absent on the last page)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.MoveRequest": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "description": "Key to read",
+                    "type": "string"
+                },
+                "replace": {
+                    "description": "Overwrite the key written if it has a value",
+                    "type": "boolean"
+                },
+                "to": {
+                    "description": "Key to write",
+                    "type": "string"
+                }
+            }
+        },
+        "main.NamespaceResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "policy": {
+                    "type": "object",
+                    "$ref": "#/definitions/node.NamespacePolicy"
+                }
+            }
+        },
+        "main.NamespacesResponse": {
+            "type": "object",
+            "properties": {
+                "namespaces": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.NamespaceResponse"
+                    }
+                },
+                "next": {
+                    "description": "Name of the last namespace listed, to pass as ` + "`" + `after` + "`" + ` for the next\npage (absent on the last page)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.PeerResponse": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "boolean"
+                },
+                "clockOffsetMillis": {
+                    "description": "Estimated offset of the member's clock from this node's, in\nmilliseconds (positive if the member's is ahead; only reported by the\nleader)",
+                    "type": "number"
+                },
+                "commitIndex": {
+                    "description": "Progress and version reported by the member in its last reply to this\nnode (absent until it has replied)",
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "isolatedUntil": {
+                    "description": "Until when this node has isolated the member (absent if it has not)",
+                    "type": "string"
+                },
+                "lag": {
+                    "type": "integer"
+                },
+                "lastApplied": {
+                    "type": "integer"
+                },
+                "learner": {
+                    "description": "Whether the member is being bootstrapped, so does not count toward the\nmajority",
+                    "type": "boolean"
+                },
+                "matchIndex": {
+                    "type": "integer"
+                },
+                "rttMillis": {
+                    "description": "Round-trip time of the last successful ping, in milliseconds",
+                    "type": "number"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.PromoteMemberResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "description": "Raft address of every voting member, once the learner is promoted",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.ReadResponse": {
+            "type": "object",
+            "properties": {
+                "ageMillis": {
+                    "description": "How old the data read may be, for reads with max_staleness",
+                    "type": "number"
+                },
+                "size": {
+                    "description": "Size in bytes of the whole value, for reads of a byte range",
+                    "type": "integer"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RemoveMemberResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "description": "Raft address of every voting member, once the member is removed",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.SAddRequest": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.SIsMemberResponse": {
+            "type": "object",
+            "properties": {
+                "isMember": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.SMembersResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.SampleResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "samples": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.KeySample"
+                    }
+                }
+            }
+        },
+        "main.SetResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.StatsResponse": {
+            "type": "object",
+            "properties": {
+                "prefixes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.PrefixStats"
+                    }
+                }
+            }
+        },
+        "main.StatusResponse": {
+            "type": "object",
+            "properties": {
+                "backlog": {
+                    "description": "How far this node is behind, and whether it is shedding low-priority\nrequests because of it",
+                    "type": "object",
+                    "$ref": "#/definitions/node.Backlog"
+                },
+                "caughtUp": {
+                    "type": "boolean"
+                },
+                "commitIndex": {
+                    "type": "integer"
+                },
+                "diskBytes": {
+                    "description": "Total size of the files in the data directory",
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "keys": {
+                    "description": "Number of keys in the database (including expired keys that have not\nbeen removed yet)",
+                    "type": "integer"
+                },
+                "lastApplied": {
+                    "type": "integer"
+                },
+                "lastLogIndex": {
+                    "type": "integer"
+                },
+                "leader": {
+                    "type": "string"
+                },
+                "leaseReadsDisabled": {
+                    "description": "Whether this node has disabled lease reads because a peer's clock is\nskewed (see clockOffsetMillis)",
+                    "type": "boolean"
+                },
+                "peers": {
+                    "description": "Reachability of each other member, and replication progress (only\nreported by the leader)",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.PeerResponse"
+                    }
+                },
+                "quarantineReason": {
+                    "type": "string"
+                },
+                "quarantined": {
+                    "description": "Whether this node is quarantined (see /admin/reseed), and why",
+                    "type": "boolean"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "term": {
+                    "type": "integer"
+                },
+                "version": {
+                    "type": "string"
+                },
+                "witness": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.TTLResponse": {
+            "type": "object",
+            "properties": {
+                "exists": {
+                    "type": "boolean"
+                },
+                "expires": {
+                    "type": "boolean"
+                },
+                "ttlMs": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.TransferResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "target": {
+                    "description": "Member asked to take over (empty if any up-to-date follower was)",
+                    "type": "string"
+                },
+                "term": {
+                    "description": "Term in which the node was leader",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.TrashResponse": {
+            "type": "object",
+            "properties": {
+                "next": {
+                    "description": "Key of the last value listed, to pass as ` + "`" + `after` + "`" + ` for the next page\n(absent on the last page)",
+                    "type": "string"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.TrashedValue"
+                    }
+                }
+            }
+        },
+        "main.TxnRequest": {
+            "type": "object",
+            "properties": {
+                "else": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.BatchOp"
+                    }
+                },
+                "if": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.Compare"
+                    }
+                },
+                "then": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.BatchOp"
+                    }
+                }
+            }
+        },
+        "main.TxnResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                },
+                "succeeded": {
+                    "description": "Whether every condition held, so the \"then\" operations were applied\nrather than the \"else\" operations (omitted if the write concern is\n\"leader\", since the transaction has not been applied yet)",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.WatchResponse": {
+            "type": "object",
+            "properties": {
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Change"
+                    }
+                },
+                "revision": {
+                    "description": "Changes up to this revision have been returned, so the next request\nshould be for changes after it",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.WriteRequest": {
+            "type": "object",
+            "properties": {
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WriteResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ZAddRequest": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.ScoredMember"
+                    }
+                }
+            }
+        },
+        "main.ZRangeResponse": {
+            "type": "object",
+            "properties": {
+                "members": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/database.ScoredMember"
+                    }
+                }
+            }
+        },
+        "main.ZSetResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "mgmt.CompactionInfo": {
+            "type": "object",
+            "properties": {
+                "baseIndex": {
+                    "description": "Index of the first entry kept in the log",
+                    "type": "integer"
+                },
+                "entries": {
+                    "type": "integer"
+                },
+                "reclaimedBytes": {
+                    "type": "integer"
+                },
+                "seconds": {
+                    "type": "number"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "mgmt.DiskComponent": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "mgmt.DiskFile": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "component": {
+                    "type": "string"
+                },
+                "modified": {
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Path relative to the data directory",
+                    "type": "string"
+                }
+            }
+        },
+        "mgmt.DiskUsage": {
+            "type": "object",
+            "properties": {
+                "components": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mgmt.DiskComponent"
+                    }
+                },
+                "dataDir": {
+                    "type": "string"
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mgmt.DiskFile"
+                    }
+                },
+                "totalBytes": {
+                    "type": "integer"
+                },
+                "volumeBytes": {
+                    "type": "integer"
+                },
+                "volumeFreeBytes": {
+                    "description": "Bytes available, and the total size, of the volume (omitted where free\nspace can't be measured)",
+                    "type": "integer"
+                }
+            }
+        },
+        "mgmt.SnapshotInfo": {
+            "type": "object",
+            "properties": {
+                "appliedHash": {
+                    "type": "integer"
+                },
+                "bytes": {
+                    "type": "integer"
+                },
+                "checksum": {
+                    "description": "CRC-32 (IEEE) and SHA-256 (hex) of the snapshot, and the applied hash of\nthe node it was taken from as of LastApplied (empty if not known)",
+                    "type": "integer"
+                },
+                "file": {
+                    "type": "string"
+                },
+                "lastApplied": {
+                    "type": "integer"
+                },
+                "lastTerm": {
+                    "type": "integer"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "mgmt.SnapshotStatus": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of snapshots kept on disk",
+                    "type": "integer"
+                },
+                "inProgress": {
+                    "description": "Whether a snapshot has been requested and not yet finished",
+                    "type": "boolean"
+                },
+                "lastCompaction": {
+                    "description": "The most recent compaction of the log that discarded entries, if any",
+                    "type": "object",
+                    "$ref": "#/definitions/mgmt.CompactionInfo"
+                },
+                "lastError": {
+                    "description": "Error from the most recent attempt to take a snapshot, if it failed",
+                    "type": "string"
+                },
+                "latest": {
+                    "description": "The most recent snapshot, if any",
+                    "type": "object",
+                    "$ref": "#/definitions/mgmt.SnapshotInfo"
+                }
+            }
+        },
+        "node.Backlog": {
+            "type": "object",
+            "properties": {
+                "applyLag": {
+                    "description": "Entries committed by the leader that this node has not applied",
+                    "type": "integer"
+                },
+                "queuedWrites": {
+                    "description": "Writes waiting behind the one in progress",
+                    "type": "integer"
+                },
+                "shedding": {
+                    "description": "Whether low-priority requests are being shed",
+                    "type": "boolean"
+                }
+            }
+        },
+        "node.BootstrapStatus": {
+            "type": "object",
+            "properties": {
+                "commitIndex": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "matchIndex": {
+                    "description": "Index of the last entry known to be replicated on the member, and the\nleader's commit index it must reach to be promoted",
+                    "type": "integer"
+                },
+                "member": {
+                    "type": "string"
+                },
+                "phase": {
+                    "description": "One of \"snapshot\" (being sent a snapshot), \"catching-up\" (being sent the\nentries after it), \"caught-up\" (a learner waiting to be promoted, with\nPromote or with the other members of a joint change), \"promoted\", or\n\"failed\" (the member has been removed)",
+                    "type": "string"
+                },
+                "promoted": {
+                    "type": "string"
+                },
+                "sentBytes": {
+                    "type": "integer"
+                },
+                "snapshotBytes": {
+                    "type": "integer"
+                },
+                "snapshotIndex": {
+                    "description": "Last log index covered by the snapshot, its size, and how much of it has\nbeen sent",
+                    "type": "integer"
+                },
+                "started": {
+                    "type": "string"
+                }
+            }
+        },
+        "node.DrainStatus": {
+            "type": "object",
+            "properties": {
+                "drained": {
+                    "description": "Whether the node is draining and has no writes in progress or\nunreplicated entries",
+                    "type": "boolean"
+                },
+                "draining": {
+                    "type": "boolean"
+                },
+                "leader": {
+                    "description": "Whether the node is still the leader",
+                    "type": "boolean"
+                },
+                "pendingWriteBytes": {
+                    "description": "Bytes of writes that have not yet finished",
+                    "type": "integer"
+                },
+                "unreplicated": {
+                    "description": "Peers that have not yet acknowledged every entry in the log (only known\nwhile the node is the leader)",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "node.EntryInfo": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "requestId": {
+                    "type": "string"
+                },
+                "term": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "node.Event": {
+            "type": "object",
+            "properties": {
+                "node": {
+                    "type": "string"
+                },
+                "term": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "node.Lock": {
+            "type": "object",
+            "properties": {
+                "acquiredAt": {
+                    "description": "When the owner took the lock (renewals leave it alone), in Unix\nnanoseconds",
+                    "type": "integer"
+                },
+                "expiresAt": {
+                    "description": "When the lease runs out, in Unix nanoseconds",
+                    "type": "integer"
+                },
+                "leaseMs": {
+                    "description": "Length of the lease taken or last renewed, in milliseconds",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner": {
+                    "description": "Who holds the lock, as given by the client that took it",
+                    "type": "string"
+                }
+            }
+        },
+        "node.NamespacePolicy": {
+            "type": "object",
+            "properties": {
+                "access": {
+                    "description": "What clients may do with the keys (AccessReadWrite if empty)",
+                    "type": "string"
+                },
+                "defaultTtlMs": {
+                    "description": "Time to live of keys set in the namespace, in milliseconds, restarted\nby each write (a batch, transaction, or compare-and-set leaves the\nexpiry of the keys it sets alone)",
+                    "type": "integer"
+                },
+                "maxKeys": {
+                    "description": "Number of keys the namespace may hold",
+                    "type": "integer"
+                },
+                "maxValueBytes": {
+                    "description": "Size of each value, in bytes",
+                    "type": "integer"
+                }
+            }
+        },
+        "node.ProposalBatching": {
+            "type": "object",
+            "properties": {
+                "maxBytes": {
+                    "description": "Most bytes of keys and values in a batch (0 means unlimited). A write\nlarger than this still makes a batch of its own",
+                    "type": "integer"
+                },
+                "maxDelayMicros": {
+                    "description": "Longest the first write of a batch waits for others to join, in\nmicroseconds",
+                    "type": "integer"
+                },
+                "maxEntries": {
+                    "description": "Most writes in a batch (1 or less disables batching)",
+                    "type": "integer"
+                }
+            }
+        },
+        "node.ReadOnlyStatus": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "description": "Whether writes are disabled on the whole cluster (as of the last entry\napplied by this node)",
+                    "type": "boolean"
+                },
+                "clusterReason": {
+                    "type": "string"
+                },
+                "node": {
+                    "description": "Whether writes are disabled on this node alone",
+                    "type": "boolean"
+                },
+                "nodeReason": {
+                    "type": "string"
+                }
+            }
+        },
+        "node.Topology": {
+            "type": "object",
+            "properties": {
+                "leader": {
+                    "description": "Raft address of the leader",
+                    "type": "string"
+                },
+                "leaderClientAddr": {
+                    "description": "Client (HTTP) address of the leader",
+                    "type": "string"
+                },
+                "members": {
+                    "description": "Every member, including the leader, sorted by id",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/node.TopologyMember"
+                    }
+                },
+                "term": {
+                    "description": "Term in which the leader published the topology",
+                    "type": "integer"
+                }
+            }
+        },
+        "node.TopologyMember": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "Raft address of the member",
+                    "type": "string"
+                },
+                "learner": {
+                    "description": "Whether the member is being bootstrapped (see AddMember)",
+                    "type": "boolean"
+                }
+            }
+        },
+        "node.TrashedValue": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "description": "When the value is removed from the trash for good, in Unix nanoseconds",
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "value": {
                     "type": "string"
                 }
             }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/mgmt"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// An ErrorCode identifies the kind of error in an error response. Codes are
+// stable, so clients can branch on them rather than on error messages (which
+// may change)
+type ErrorCode string
+
+// Error codes:
+// NotLeader: the request must be made to the leader, and no leader is known
+// (when one is, the response is a redirect instead)
+// Timeout: the request did not complete in time, and may or may not have taken
+// effect (for a write that reached the leader's log, the response includes the
+// index and term of its entry, to check later whether it was committed)
+// DeadlineExceeded: the client's deadline (see the X-Leifdb-Timeout header)
+// passed before the request completed. As for Timeout, it may or may not have
+// taken effect, and a write that reached the leader's log includes its index
+// and term
+// QuotaExceeded: the request was rejected because a limit was reached, such as
+// the budget for pending writes or a namespace's limit on keys
+// Forbidden: the policy of the key's namespace does not allow the request
+// Conflict: the request conflicts with the current state of the data
+// Unavailable: the node can't serve the request right now, e.g. it is draining
+// or has not caught up with the leader since starting
+// ReadOnly: writes are disabled on the node or the cluster for maintenance
+// (reads are still served)
+// InvalidRequest: the request is malformed, and should not be retried as-is
+// NotFound: the thing the request refers to does not exist
+// Compacted: the request refers to history that is no longer kept
+// Internal: any other error
+const (
+	ErrorNotLeader      ErrorCode = "NotLeader"
+	ErrorTimeout        ErrorCode = "Timeout"
+	ErrorDeadline       ErrorCode = "DeadlineExceeded"
+	ErrorQuotaExceeded  ErrorCode = "QuotaExceeded"
+	ErrorForbidden      ErrorCode = "Forbidden"
+	ErrorConflict       ErrorCode = "Conflict"
+	ErrorUnavailable    ErrorCode = "Unavailable"
+	ErrorReadOnly       ErrorCode = "ReadOnly"
+	ErrorInvalidRequest ErrorCode = "InvalidRequest"
+	ErrorNotFound       ErrorCode = "NotFound"
+	ErrorCompacted      ErrorCode = "Compacted"
+	ErrorInternal       ErrorCode = "Internal"
+)
+
+// ErrorResponse is the body of every error response from the HTTP interface
+type ErrorResponse struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Whether the same request may succeed if retried later
+	Retryable bool `json:"retryable"`
+	// Client address of the current leader, for NotLeader errors (if known)
+	Leader string `json:"leader,omitempty"`
+	// Version the request expected the data to have, for Conflict errors
+	ExpectedVersion *int64 `json:"expectedVersion,omitempty"`
+	// The lock that another owner holds, for Conflict errors taking a lock
+	Holder *node.Lock `json:"holder,omitempty"`
+	// Log index of a write that may or may not have been committed, for
+	// Timeout and DeadlineExceeded errors
+	Index *int64 `json:"index,omitempty"`
+	// Term of the log entry at Index
+	Term *int64 `json:"term,omitempty"`
+}
+
+// classify returns the HTTP status and response body for an error
+func classify(err error) (int, ErrorResponse) {
+	response := ErrorResponse{Message: err.Error()}
+	status := http.StatusInternalServerError
+	var uncertain *node.CommitUncertainError
+	if errors.As(err, &uncertain) {
+		response.Code, response.Retryable = ErrorTimeout, true
+		if errors.Is(uncertain.Err, context.DeadlineExceeded) {
+			response.Code = ErrorDeadline
+		}
+		response.Index, response.Term = &uncertain.Index, &uncertain.Term
+		return http.StatusGatewayTimeout, response
+	}
+	var held *node.LockHeldError
+	if errors.As(err, &held) {
+		response.Code, response.Holder = ErrorConflict, &held.Lock
+		return http.StatusConflict, response
+	}
+	if errors.Is(err, node.ErrReadOnly) {
+		response.Code = ErrorReadOnly
+		return http.StatusServiceUnavailable, response
+	}
+	if errors.Is(err, node.ErrNamespaceDenied) {
+		response.Code = ErrorForbidden
+		return http.StatusForbidden, response
+	}
+	if errors.Is(err, node.ErrNamespaceQuota) {
+		response.Code = ErrorQuotaExceeded
+		return http.StatusForbidden, response
+	}
+	if errors.Is(err, node.ErrInvalidPolicy) {
+		response.Code = ErrorInvalidRequest
+		return http.StatusBadRequest, response
+	}
+	switch err {
+	case node.ErrNotLeaderRecv:
+		status, response.Code, response.Retryable =
+			http.StatusServiceUnavailable, ErrorNotLeader, true
+	case node.ErrProposalBudget:
+		status, response.Code, response.Retryable =
+			http.StatusServiceUnavailable, ErrorQuotaExceeded, true
+	case node.ErrDraining, node.ErrWitnessRead, node.ErrNotCaughtUp,
+		node.ErrQuarantined, node.ErrTooStale, node.ErrBacklogged, mgmt.ErrNoLeader:
+		status, response.Code, response.Retryable =
+			http.StatusServiceUnavailable, ErrorUnavailable, true
+	case node.ErrAppendFailed, node.ErrCommitFailed:
+		status, response.Code, response.Retryable =
+			http.StatusGatewayTimeout, ErrorTimeout, true
+	case context.DeadlineExceeded:
+		status, response.Code, response.Retryable =
+			http.StatusGatewayTimeout, ErrorDeadline, true
+	case db.ErrInvalidPath, db.ErrInvalidQuery, db.ErrInvalidScore,
+		node.ErrInvalidWriteConcern, db.ErrInvalidBatch, db.ErrInvalidTxn,
+		node.ErrSystemKey, node.ErrInvalidNamespace, node.ErrInvalidPriority,
+		node.ErrInvalidLock, node.ErrInvalidBatching, node.ErrInvalidRename,
+		node.ErrNoMembers, node.ErrInvalidIsolation:
+		status, response.Code = http.StatusBadRequest, ErrorInvalidRequest
+	case node.ErrBatchConflict, node.ErrReseedLeader, node.ErrDemoteNotLeader,
+		node.ErrMemberExists, node.ErrKeyExists, node.ErrRemoveLeader,
+		node.ErrChangeInProgress, node.ErrTransferNotLeader, node.ErrNoTransferTarget,
+		node.ErrIsolateSelf, node.ErrNotLearner, node.ErrLearnerBehind:
+		status, response.Code = http.StatusConflict, ErrorConflict
+	case db.ErrNoIndex, ErrNoNamespacePolicy, ErrNoTopology, ErrNoLock,
+		node.ErrNotInTrash, node.ErrNoSuchKey, node.ErrPeerRemoved:
+		status, response.Code = http.StatusNotFound, ErrorNotFound
+	case ErrWatchCompacted:
+		status, response.Code = http.StatusGone, ErrorCompacted
+	default:
+		response.Code = ErrorInternal
+	}
+	return status, response
+}
+
+// respondError sends the error response for err. Retryable errors from a node
+// that is temporarily unable to serve the request include a Retry-After header
+func respondError(c *gin.Context, err error) {
+	status, response := classify(err)
+	if response.Retryable && status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(status, response)
+}
+
+// redirectToLeader sends a redirect to the same request on the current leader,
+// with a NotLeader error body (for clients that do not follow redirects)
+func redirectToLeader(c *gin.Context, leader string, path string) {
+	c.Header("Location", fmt.Sprintf("http://%s%s", leader, path))
+	c.JSON(http.StatusTemporaryRedirect, ErrorResponse{
+		Code:      ErrorNotLeader,
+		Message:   "Request must be made to the leader",
+		Retryable: true,
+		Leader:    leader})
+}
+
+// invalidRequest sends the error response for a malformed request
+func invalidRequest(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Code:    ErrorInvalidRequest,
+		Message: err.Error()})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+// recentEvents is the number of cluster events kept for the admin API
+const recentEvents = 100
+
+// An eventLog keeps the most recent cluster events observed by a node
+type eventLog struct {
+	sync.Mutex
+	events []node.Event
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]node.Event, size)}
+}
+
+// add records an event, replacing the oldest one if the log is full (it can be
+// registered with `Node.AddEventListener`)
+func (l *eventLog) add(event node.Event) {
+	l.Lock()
+	defer l.Unlock()
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent returns the events in the log, oldest first
+func (l *eventLog) recent() []node.Event {
+	l.Lock()
+	defer l.Unlock()
+	if !l.full {
+		return append([]node.Event{}, l.events[:l.next]...)
+	}
+	return append(append([]node.Event{}, l.events[l.next:]...), l.events[:l.next]...)
+}
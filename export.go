@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// exportFlushEvery is the number of records written between flushes of an
+// export response
+const exportFlushEvery = 1000
+
+// Formats of an export, and their content types
+const (
+	// one JSON object per line (the default)
+	exportNDJSON     = "ndjson"
+	exportNDJSONType = "application/x-ndjson"
+	// a header row (key,value,expiresAt), then one row per key
+	exportCSV     = "csv"
+	exportCSVType = "text/csv"
+	// raft.ExportRecord messages, each preceded by its length as a varint
+	exportProtobuf     = "protobuf"
+	exportProtobufType = "application/x-protobuf"
+)
+
+var (
+	// ErrInvalidExportFormat indicates a request for an unknown export format
+	ErrInvalidExportFormat = errors.New("Export format must be ndjson, csv, or protobuf")
+)
+
+// ExportRecord is one line of an export: a key, its value, and its expiry time
+// (in Unix nanoseconds) if it has one
+type ExportRecord struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+// An exportEncoder writes the records of an export in one format
+type exportEncoder interface {
+	encode(record ExportRecord) error
+	// flush writes any buffered records to the response
+	flush() error
+}
+
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) encode(record ExportRecord) error {
+	return e.encoder.Encode(record)
+}
+
+func (e *ndjsonEncoder) flush() error {
+	return nil
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) encode(record ExportRecord) error {
+	expiresAt := ""
+	if record.ExpiresAt != 0 {
+		expiresAt = strconv.FormatInt(record.ExpiresAt, 10)
+	}
+	return e.writer.Write([]string{record.Key, record.Value, expiresAt})
+}
+
+func (e *csvEncoder) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type protobufEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *protobufEncoder) encode(record ExportRecord) error {
+	message, err := proto.Marshal(&raft.ExportRecord{
+		Key:       record.Key,
+		Value:     record.Value,
+		ExpiresAt: record.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	e.buf = protowire.AppendVarint(e.buf[:0], uint64(len(message)))
+	e.buf = append(e.buf, message...)
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+func (e *protobufEncoder) flush() error {
+	return nil
+}
+
+// exportFormat returns the format requested by the "format" query parameter,
+// or else the first of the formats accepted by the Accept header (NDJSON if it
+// accepts none of them)
+func exportFormat(c *gin.Context) (string, error) {
+	switch format := c.Query("format"); format {
+	case exportNDJSON, exportCSV, exportProtobuf:
+		return format, nil
+	case "":
+	default:
+		return "", ErrInvalidExportFormat
+	}
+	switch c.NegotiateFormat(exportNDJSONType, exportCSVType, exportProtobufType) {
+	case exportCSVType:
+		return exportCSV, nil
+	case exportProtobufType:
+		return exportProtobuf, nil
+	}
+	return exportNDJSON, nil
+}
+
+// newExportEncoder starts an export response in format
+func newExportEncoder(c *gin.Context, format string) (exportEncoder, error) {
+	switch format {
+	case exportCSV:
+		c.Header("Content-Type", exportCSVType)
+		c.Status(http.StatusOK)
+		e := &csvEncoder{writer: csv.NewWriter(c.Writer)}
+		return e, e.writer.Write([]string{"key", "value", "expiresAt"})
+	case exportProtobuf:
+		c.Header("Content-Type", exportProtobufType)
+		c.Status(http.StatusOK)
+		return &protobufEncoder{w: c.Writer}, nil
+	}
+	c.Header("Content-Type", exportNDJSONType)
+	c.Status(http.StatusOK)
+	return &ndjsonEncoder{encoder: json.NewEncoder(c.Writer)}, nil
+}
+
+// Handler for exporting keys
+// @Summary Stream every key with a prefix, and its value, as newline-delimited JSON, CSV, or protobuf
+// @Description Keys are exported in order, as of when the request started.
+// @Description Sets and sorted sets are not included, nor are keys in
+// @Description namespaces whose policy denies reads. The filters are applied
+// @Description on the server as keys are visited, so only matching keys are sent.
+// @Description
+// @Description The format is chosen by the format parameter, or else by the
+// @Description Accept header: NDJSON (one ExportRecord per line, the default),
+// @Description CSV (a key,value,expiresAt header, then one row per key), or
+// @Description protobuf (ExportRecord messages from api/raft.proto, each preceded
+// @Description by its length as a varint).
+// @ID export
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Produce application/x-protobuf
+// @Param format query string false "Format: ndjson (default), csv, or protobuf"
+// @Param prefix query string false "Only export keys with this prefix"
+// @Param match query string false "Only export keys matching this regular expression"
+// @Param contains query string false "Only export keys whose value contains this string"
+// @Param where query string false "Only export keys whose JSON value satisfies a query, such as $.status == \"active\""
+// @Success 200 {object} ExportRecord "One record per line"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 400 {object} ErrorResponse "Invalid filter or format"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /export [get]
+func (ctl *Controller) handleExport(c *gin.Context) {
+	filter, err := db.NewFilter(c.Query("match"), c.Query("contains"), c.Query("where"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	format, err := exportFormat(c)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+	prefix := c.Query("prefix")
+	store := ctl.Node.Store
+	cursor := store.PrefixCursor(prefix)
+
+	encoder, err := newExportEncoder(c, format)
+	if err != nil {
+		// the client went away
+		return
+	}
+	count := 0
+	// whether each namespace seen denies reads
+	denied := map[string]bool{}
+	for key, value, ok := cursor.Next(); ok; key, value, ok = cursor.Next() {
+		if node.IsSystemKey(key) {
+			// policies are part of the cluster's state, not its data
+			continue
+		}
+		name := node.Namespace(key)
+		if _, ok := denied[name]; !ok {
+			denied[name] = ctl.Node.CheckRead(key) != nil
+		}
+		if denied[name] {
+			continue
+		}
+		if !store.Exists(key) {
+			// expired
+			continue
+		}
+		if !filter.Match(key, value) {
+			continue
+		}
+		record := ExportRecord{Key: key, Value: value}
+		record.ExpiresAt, _ = store.Expiry(key)
+		if err := encoder.encode(record); err != nil {
+			// the client went away
+			return
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			if encoder.flush() != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+	encoder.flush()
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+)
+
+// To find the keys responsible for spikes in load, each node counts the reads
+// it serves and the writes it applies for a sample of them, by key and by key
+// prefix (a key up to its first ':' or '/'). Only the most active keys and
+// prefixes are kept, using the space-saving algorithm: when a table is full,
+// the least active entry is replaced, and the newcomer takes over its counts
+// (which are reported as the possible overcount). Counts are kept for the
+// current window and the one before it, so that rates are measured over at
+// least one whole window
+const (
+	// hotKeysSampleEvery is how many reads or writes there are for each one
+	// counted
+	hotKeysSampleEvery = 4
+	// hotKeysCapacity is the number of keys (and of prefixes) counted
+	hotKeysCapacity = 1000
+	// hotKeysWindow is how long counts are kept for
+	hotKeysWindow = time.Minute
+	// defaultHotKeysLimit is the number of keys reported if no limit is given
+	defaultHotKeysLimit = 20
+)
+
+// keyActivity counts the reads and writes of a key or prefix
+type keyActivity struct {
+	reads      uint64
+	writes     uint64
+	writeBytes uint64
+	// how much of reads and writes may have belonged to displaced entries
+	overcount uint64
+}
+
+func (a *keyActivity) total() uint64 {
+	return a.reads + a.writes
+}
+
+func (a *keyActivity) merge(b *keyActivity) {
+	a.reads += b.reads
+	a.writes += b.writes
+	a.writeBytes += b.writeBytes
+	a.overcount += b.overcount
+}
+
+// activityTable counts the activity of up to hotKeysCapacity keys or prefixes
+type activityTable map[string]*keyActivity
+
+// get returns the activity of key, displacing the least active entry if the
+// table is full
+func (t activityTable) get(key string) *keyActivity {
+	if a, ok := t[key]; ok {
+		return a
+	}
+	a := &keyActivity{}
+	if len(t) >= hotKeysCapacity {
+		var leastKey string
+		var least *keyActivity
+		for k, v := range t {
+			if least == nil || v.total() < least.total() {
+				leastKey, least = k, v
+			}
+		}
+		delete(t, leastKey)
+		*a = *least
+		a.overcount = least.total()
+	}
+	t[key] = a
+	return a
+}
+
+// keyPrefix returns key up to and including its first ':' or '/' (or all of
+// key if it has neither)
+func keyPrefix(key string) string {
+	if i := strings.IndexAny(key, ":/"); i >= 0 {
+		return key[:i+1]
+	}
+	return key
+}
+
+// hotKeys counts reads and writes by key and prefix
+type hotKeys struct {
+	// number of reads and writes seen, for sampling (atomic)
+	seen uint64
+
+	lock           sync.Mutex
+	start          time.Time
+	keys, prefixes activityTable
+	// counts from the previous window, if it ended less than a window ago
+	prevStart              time.Time
+	prevKeys, prevPrefixes activityTable
+}
+
+func newHotKeys() *hotKeys {
+	return &hotKeys{
+		start:    time.Now(),
+		keys:     activityTable{},
+		prefixes: activityTable{}}
+}
+
+// sample reports whether the next read or write is counted
+func (h *hotKeys) sample() bool {
+	return atomic.AddUint64(&h.seen, 1)%hotKeysSampleEvery == 0
+}
+
+// read counts a read of key
+func (h *hotKeys) read(key string) {
+	if !h.sample() {
+		return
+	}
+	h.record(key, func(a *keyActivity) { a.reads++ })
+}
+
+// record applies count to the activity of key and its prefix
+func (h *hotKeys) record(key string, count func(*keyActivity)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.rotate(time.Now())
+	count(h.keys.get(key))
+	count(h.prefixes.get(keyPrefix(key)))
+}
+
+// rotate starts a new window if the current one has ended
+func (h *hotKeys) rotate(now time.Time) {
+	elapsed := now.Sub(h.start)
+	if elapsed < hotKeysWindow {
+		return
+	}
+	h.prevStart, h.prevKeys, h.prevPrefixes = h.start, h.keys, h.prefixes
+	if elapsed >= 2*hotKeysWindow {
+		// nothing was counted in the last whole window
+		h.prevStart, h.prevKeys, h.prevPrefixes = time.Time{}, nil, nil
+	}
+	h.start, h.keys, h.prefixes = now, activityTable{}, activityTable{}
+}
+
+// hook returns an ApplyHook that counts the writes made by each entry, with
+// the size of the entry (or of each operation of a batch or transaction) as
+// the bytes written
+func (h *hotKeys) hook() node.ApplyHook {
+	return func(index int64, entry *raft.LogRecord, result error) {
+		switch entry.Action {
+		case raft.LogRecord_CUSTOM, raft.LogRecord_CREATE_INDEX, raft.LogRecord_DROP_INDEX,
+			raft.LogRecord_EXPIRE, raft.LogRecord_READ_ONLY, raft.LogRecord_MEMBERS,
+			raft.LogRecord_NOOP:
+			// not a client write to a key
+			return
+		}
+		if !h.sample() {
+			return
+		}
+		write := func(key string, bytes int) {
+			h.record(key, func(a *keyActivity) {
+				a.writes++
+				a.writeBytes += uint64(bytes)
+			})
+		}
+		switch entry.Action {
+		case raft.LogRecord_BATCH, raft.LogRecord_TXN:
+			for _, ops := range [][]*raft.LogRecord{entry.Ops, entry.ElseOps} {
+				for _, op := range ops {
+					write(op.Key, proto.Size(op))
+				}
+			}
+		default:
+			write(entry.Key, proto.Size(entry))
+		}
+	}
+}
+
+// A HotKey reports the rates of reads served and writes applied by a node for
+// a key or prefix, in operations (or bytes) per second
+type HotKey struct {
+	Key        string  `json:"key"`
+	Reads      float64 `json:"reads"`
+	Writes     float64 `json:"writes"`
+	WriteBytes float64 `json:"writeBytes"`
+	// How much of Reads and Writes combined may belong to other keys, which
+	// were displaced from the counts by this one (0 if the rates are exact,
+	// up to sampling)
+	Overcount float64 `json:"overcount"`
+}
+
+// HotKeysResponse reports the most active keys or prefixes on a node
+type HotKeysResponse struct {
+	// "key" or "prefix"
+	By string `json:"by"`
+	// What keys are ranked by: "reads", "writes", "bytes", or "total"
+	Sort string `json:"sort"`
+	// Time the rates are measured over, in seconds
+	Seconds float64 `json:"seconds"`
+	// One in this many reads and writes is counted (the rates are scaled to
+	// account for it)
+	SampleEvery int      `json:"sampleEvery"`
+	Keys        []HotKey `json:"keys"`
+}
+
+// report returns the most active keys (or prefixes) ranked by sortBy, with
+// their rates
+func (h *hotKeys) report(byPrefix bool, sortBy string, limit int) HotKeysResponse {
+	h.lock.Lock()
+	now := time.Now()
+	h.rotate(now)
+	tables := []activityTable{h.keys, h.prevKeys}
+	if byPrefix {
+		tables = []activityTable{h.prefixes, h.prevPrefixes}
+	}
+	since := h.start
+	if !h.prevStart.IsZero() {
+		since = h.prevStart
+	}
+	merged := map[string]*keyActivity{}
+	for _, table := range tables {
+		for key, a := range table {
+			if m, ok := merged[key]; ok {
+				m.merge(a)
+			} else {
+				copied := *a
+				merged[key] = &copied
+			}
+		}
+	}
+	h.lock.Unlock()
+
+	seconds := now.Sub(since).Seconds()
+	scale := float64(hotKeysSampleEvery)
+	if seconds > 0 {
+		scale /= seconds
+	}
+	keys := make([]HotKey, 0, len(merged))
+	for key, a := range merged {
+		keys = append(keys, HotKey{
+			Key:        key,
+			Reads:      float64(a.reads) * scale,
+			Writes:     float64(a.writes) * scale,
+			WriteBytes: float64(a.writeBytes) * scale,
+			Overcount:  float64(a.overcount) * scale})
+	}
+	rank := map[string]func(k HotKey) float64{
+		"reads":  func(k HotKey) float64 { return k.Reads },
+		"writes": func(k HotKey) float64 { return k.Writes },
+		"bytes":  func(k HotKey) float64 { return k.WriteBytes },
+		"total":  func(k HotKey) float64 { return k.Reads + k.Writes },
+	}[sortBy]
+	sort.Slice(keys, func(i, j int) bool {
+		if rank(keys[i]) != rank(keys[j]) {
+			return rank(keys[i]) > rank(keys[j])
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	by := "key"
+	if byPrefix {
+		by = "prefix"
+	}
+	return HotKeysResponse{
+		By:          by,
+		Sort:        sortBy,
+		Seconds:     seconds,
+		SampleEvery: hotKeysSampleEvery,
+		Keys:        keys}
+}
+
+// Handler for the hot keys report
+// @Summary Return the keys (or key prefixes) read and written most on this node
+// @Description Reads are those served by this node, and writes those applied
+// @Description by it (the same on every member). A sample of reads and writes
+// @Description is counted, over the last one to two minutes, and only the most
+// @Description active keys are tracked, so the rates are estimates.
+// @ID admin-hot-keys
+// @Accept */*
+// @Produce application/json
+// @Param by query string false "key (default) or prefix (a key up to its first ':' or '/')"
+// @Param sort query string false "reads, writes, bytes, or total (default)"
+// @Param limit query int false "Number of keys to return (default 20)"
+// @Success 200 {object} HotKeysResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/hotkeys [get]
+func (ctl *Controller) handleHotKeys(c *gin.Context) {
+	by := c.DefaultQuery("by", "key")
+	if by != "key" && by != "prefix" {
+		invalidRequest(c, fmt.Errorf("Invalid by %q (expected key or prefix)", by))
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "total")
+	switch sortBy {
+	case "reads", "writes", "bytes", "total":
+	default:
+		invalidRequest(c, fmt.Errorf("Invalid sort %q (expected reads, writes, bytes, or total)", sortBy))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHotKeysLimit)))
+	if err != nil || limit < 0 {
+		invalidRequest(c, fmt.Errorf("Invalid limit %q", c.Query("limit")))
+		return
+	}
+	c.JSON(http.StatusOK, ctl.hotKeys.report(by == "prefix", sortBy, limit))
+}
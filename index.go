@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// IndexListResponse is a response body template for the index list route
+type IndexListResponse struct {
+	Paths []string `json:"paths"`
+}
+
+// IndexResponse is a response body template for creating or dropping indexes
+type IndexResponse struct {
+	Status string `json:"status"`
+}
+
+// FindResponse is a response body template for the find route
+type FindResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// Handler for listing secondary indexes
+// @Summary Return the paths of all secondary indexes
+// @ID index-list
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} IndexListResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /index [get]
+func (ctl *Controller) handleListIndexes(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+	c.JSON(http.StatusOK, IndexListResponse{Paths: ctl.Node.Store.Indexes()})
+}
+
+// Handler for creating secondary indexes
+// @Summary Create a secondary index on a path within JSON values
+// @ID index-create
+// @Accept */*
+// @Produce application/json
+// @Param path query string true "Path, such as $.status"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} IndexResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /index [put]
+func (ctl *Controller) handleCreateIndex(c *gin.Context) {
+	path := c.Query("path")
+	if err := db.ValidatePath(path); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.CreateIndex(ctx, path); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, IndexResponse{Status: "Ok"})
+}
+
+// Handler for dropping secondary indexes
+// @Summary Drop the secondary index on a path
+// @ID index-drop
+// @Accept */*
+// @Produce application/json
+// @Param path query string true "Path, such as $.status"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} IndexResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Invalid write concern"
+// @Router /index [delete]
+func (ctl *Controller) handleDropIndex(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.DropIndex(ctx, c.Query("path")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, IndexResponse{Status: "Ok"})
+}
+
+// Handler for queries on secondary indexes
+// @Summary Return keys whose JSON value matches a query on an indexed path
+// @ID index-find
+// @Accept */*
+// @Produce application/json
+// @Param where query string true "Query, such as $.status == \"active\""
+// @Success 200 {object} FindResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /find [get]
+func (ctl *Controller) handleFind(c *gin.Context) {
+	path, value, err := db.ParseQuery(c.Query("where"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+
+	keys, err := ctl.Node.Store.Find(path, value)
+	if err == db.ErrNoIndex {
+		respondError(c, err)
+		return
+	}
+	// leave out keys in namespaces whose policy denies reads
+	allowed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if ctl.Node.CheckRead(key) == nil {
+			allowed = append(allowed, key)
+		}
+	}
+	c.JSON(http.StatusOK, FindResponse{Keys: allowed})
+}
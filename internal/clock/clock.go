@@ -0,0 +1,140 @@
+package clock
+
+// The timers that drive elections and appends (see mgmt.StateManager) take
+// their time from a Clock, so that tests can use a Fake, which only moves when
+// the test advances it, and calls the functions scheduled with it in the test's
+// own goroutine. Tests can then check the effect of each tick as soon as the
+// call advancing the clock returns, instead of sleeping and hoping the timers
+// have fired.
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and calls functions periodically
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Every calls f every d (which must be positive), until the returned
+	// function is called. Calls are not made concurrently with each other,
+	// and a call that is due while the previous one is still running is
+	// skipped
+	Every(d time.Duration, f func()) (stop func())
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Every(d time.Duration, f func()) func() {
+	ticker := time.NewTicker(d)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// A Fake is a Clock whose time only changes when it is advanced. The zero
+// value is not usable: see NewFake
+type Fake struct {
+	lock   sync.Mutex
+	now    time.Time
+	nextId int
+	timers map[int]*fakeTimer
+}
+
+type fakeTimer struct {
+	id     int
+	next   time.Time
+	period time.Duration
+	f      func()
+}
+
+// NewFake returns a Fake clock set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, timers: make(map[int]*fakeTimer)}
+}
+
+// Now returns the fake time
+func (c *Fake) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Every schedules f to be called every d as the clock is advanced
+func (c *Fake) Every(d time.Duration, f func()) func() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	id := c.nextId
+	c.nextId++
+	c.timers[id] = &fakeTimer{id: id, next: c.now.Add(d), period: d, f: f}
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.timers, id)
+	}
+}
+
+// Advance moves the clock forward by d, making each call that falls due in
+// that time (in order, in the calling goroutine) with the clock set to the
+// time the call is due. The scheduled functions may themselves schedule or
+// stop calls
+func (c *Fake) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.due(end)
+		if t == nil {
+			break
+		}
+		c.now = t.next
+		t.next = t.next.Add(t.period)
+		c.lock.Unlock()
+		t.f()
+		c.lock.Lock()
+	}
+	c.now = end
+	c.lock.Unlock()
+}
+
+// due returns the timer with the earliest call due by end (the first
+// scheduled, of calls due at the same time), or nil if there is none
+func (c *Fake) due(end time.Time) *fakeTimer {
+	timers := make([]*fakeTimer, 0, len(c.timers))
+	for _, t := range c.timers {
+		if !t.next.After(end) {
+			timers = append(timers, t)
+		}
+	}
+	if len(timers) == 0 {
+		return nil
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		if timers[i].next.Equal(timers[j].next) {
+			return timers[i].id < timers[j].id
+		}
+		return timers[i].next.Before(timers[j].next)
+	})
+	return timers[0]
+}
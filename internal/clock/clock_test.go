@@ -0,0 +1,58 @@
+// +build unit
+
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFake(start)
+	var calls []string
+	stopA := c.Every(2*time.Second, func() {
+		calls = append(calls, "a@"+c.Now().Sub(start).String())
+	})
+	c.Every(3*time.Second, func() {
+		calls = append(calls, "b@"+c.Now().Sub(start).String())
+	})
+
+	c.Advance(time.Second)
+	if len(calls) != 0 {
+		t.Errorf("Expected no calls before they are due, got %v", calls)
+	}
+	c.Advance(5 * time.Second)
+	expected := []string{"a@2s", "b@3s", "a@4s", "a@6s", "b@6s"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+	if got := c.Now().Sub(start); got != 6*time.Second {
+		t.Errorf("Expected clock advanced by 6s, got %s", got)
+	}
+
+	stopA()
+	calls = nil
+	c.Advance(3 * time.Second)
+	if !reflect.DeepEqual(calls, []string{"b@9s"}) {
+		t.Errorf("Expected only b after stopping a, got %v", calls)
+	}
+}
+
+func TestReal(t *testing.T) {
+	calls := make(chan struct{}, 1)
+	stop := Real.Every(time.Millisecond, func() {
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+	})
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Error("Expected a call within a second")
+	}
+	stop()
+	stop()
+}
@@ -12,7 +12,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/btmorr/leifdb/internal/node"
 	"github.com/btmorr/leifdb/internal/util"
+	"github.com/btmorr/leifdb/retry"
 	"github.com/rs/zerolog"
 )
 
@@ -71,13 +73,49 @@ type ServerConfig struct {
 	Host              string
 	DataDir           string
 	SnapshotThreshold int64
+	SnapshotEntries   int64
 	RetainNSnapshots  int
+	RetainLogEntries  int64
 	RaftPort          string
 	RaftAddr          string
 	ClientPort        string
 	ClientAddr        string
 	Mode              ClusterMode
 	NodeIds           []string
+	Witness           bool
+	MaxInflightPeer   int
+	MaxInflight       int
+	WebhookURLs       []string
+	CatchUpLag        int64
+	ValueChunkSize    int
+	MaxProposalBytes  int64
+	HedgeDelay        time.Duration
+	HedgeMaxPercent   int
+	ReadIndexWindow   time.Duration
+	LeaderLease       time.Duration
+	MaxClockSkew      time.Duration
+	AutoPromoteAfter  time.Duration
+	AutoPromoteLag    int64
+	AutoDemoteFlaps   int
+	AutoDemoteWindow  time.Duration
+	ApplyBatchSize    int
+	TrashTTL          time.Duration
+	BatchDelay        time.Duration
+	BatchEntries      int
+	BatchBytes        int64
+	PublishTopology   bool
+	TopologyFile      string
+	CatchUpRate       int64
+	SnapshotRate      int64
+	SnapshotWriteRate int64
+	ClusterId         string
+	ForceRecover      bool
+	LogSync           node.LogSyncMode
+	LogPreallocate    int64
+	ShedApplyLag      int64
+	ShedQueuedWrites  int64
+	AppendRetry       retry.Policy
+	ReconnectRetry    retry.Policy
 }
 
 type ClusterConfig struct {
@@ -229,6 +267,12 @@ func BuildServerConfig() *ServerConfig {
 	verifyInt(snapshotThreshold)
 	snapshotBytes, _ := strconv.ParseInt(snapshotThreshold, 10, 64)
 
+	// by default, do not snapshot based on the number of entries
+	snapshotEntries := getEnvDefault(
+		"LEIFDB_SNAPSHOT_ENTRIES", func() string { return "0" })
+	verifyInt(snapshotEntries)
+	snapshotEntryCount, _ := strconv.ParseInt(snapshotEntries, 10, 64)
+
 	retain := getEnvDefault(
 		"LEIFDB_RETAIN_N_SNAPSHOTS", func() string { return "1" })
 	verifyInt(retain)
@@ -237,17 +281,284 @@ func BuildServerConfig() *ServerConfig {
 		panic(ErrInvalidNSnapshots)
 	}
 
+	// number of entries already covered by a snapshot to keep in the log
+	retainEntries := getEnvDefault(
+		"LEIFDB_RETAIN_LOG_ENTRIES", func() string { return "1000" })
+	verifyInt(retainEntries)
+	retainLogEntries, _ := strconv.ParseInt(retainEntries, 10, 64)
+
+	// witness nodes vote and acknowledge appends, but store no data
+	witness := getEnvDefault(
+		"LEIFDB_WITNESS", func() string { return "false" })
+	isWitness, err := strconv.ParseBool(witness)
+	if err != nil {
+		panic(err)
+	}
+
+	// limits on outstanding append requests, per peer and overall
+	inflightPeer := getEnvDefault(
+		"LEIFDB_MAX_INFLIGHT_APPENDS_PER_PEER", func() string { return "4" })
+	verifyInt(inflightPeer)
+	maxInflightPeer, _ := strconv.Atoi(inflightPeer)
+
+	inflight := getEnvDefault(
+		"LEIFDB_MAX_INFLIGHT_APPENDS", func() string { return "256" })
+	verifyInt(inflight)
+	maxInflight, _ := strconv.Atoi(inflight)
+
+	// cluster events are posted to each of these URLs (none by default)
+	webhookURLs := []string{}
+	if urls := os.Getenv("LEIFDB_WEBHOOK_URLS"); urls != "" {
+		webhookURLs = strings.Split(urls, ",")
+	}
+
+	// how many entries behind the leader a restarted node may be when it starts
+	// serving reads
+	lag := getEnvDefault(
+		"LEIFDB_CATCHUP_MAX_LAG", func() string { return "0" })
+	verifyInt(lag)
+	catchUpLag, _ := strconv.ParseInt(lag, 10, 64)
+
+	// values larger than this many bytes are replicated in chunks (0 disables)
+	chunkSize := getEnvDefault(
+		"LEIFDB_VALUE_CHUNK_SIZE", func() string { return "1048576" })
+	verifyInt(chunkSize)
+	valueChunkSize, _ := strconv.Atoi(chunkSize)
+
+	// writes are rejected while pending writes on the leader exceed this many
+	// bytes (0 means unlimited)
+	proposalBudget := getEnvDefault(
+		"LEIFDB_MAX_PENDING_WRITE_BYTES", func() string { return "67108864" })
+	verifyInt(proposalBudget)
+	maxProposalBytes, _ := strconv.ParseInt(proposalBudget, 10, 64)
+
+	// vote and read-index requests are sent again if no reply arrives within
+	// this delay (0 disables hedging), for up to this percentage of requests
+	delay := getEnvDefault(
+		"LEIFDB_HEDGE_DELAY", func() string { return "0s" })
+	hedgeDelay, err := time.ParseDuration(delay)
+	if err != nil {
+		panic(err)
+	}
+	hedgePercent := getEnvDefault(
+		"LEIFDB_HEDGE_MAX_PERCENT", func() string { return "10" })
+	verifyInt(hedgePercent)
+	hedgeMaxPercent, _ := strconv.Atoi(hedgePercent)
+
+	// read barriers share the leadership confirmed by a round of append
+	// requests started within this window (0 gives each barrier its own round)
+	window := getEnvDefault(
+		"LEIFDB_READ_INDEX_WINDOW", func() string { return "0s" })
+	readIndexWindow, err := time.ParseDuration(window)
+	if err != nil {
+		panic(err)
+	}
+
+	// the leader serves linearizable reads without a round of append
+	// requests within this lease (0 disables it)
+	lease := getEnvDefault(
+		"LEIFDB_LEADER_LEASE", func() string { return "0s" })
+	leaderLease, err := time.ParseDuration(lease)
+	if err != nil {
+		panic(err)
+	}
+
+	// lease reads are disabled while a peer's clock is off by more than this
+	// (0 never disables them)
+	skew := getEnvDefault(
+		"LEIFDB_MAX_CLOCK_SKEW", func() string { return node.DefaultMaxClockSkew.String() })
+	maxClockSkew, err := time.ParseDuration(skew)
+	if err != nil {
+		panic(err)
+	}
+
+	// the leader promotes learners whose match index stays within the lag for
+	// this long (0 leaves them to be promoted by hand)
+	promoteAfter := getEnvDefault(
+		"LEIFDB_AUTO_PROMOTE_AFTER", func() string { return "0s" })
+	autoPromoteAfter, err := time.ParseDuration(promoteAfter)
+	if err != nil {
+		panic(err)
+	}
+	promoteLag := getEnvDefault(
+		"LEIFDB_AUTO_PROMOTE_LAG", func() string { return "0" })
+	verifyInt(promoteLag)
+	autoPromoteLag, _ := strconv.ParseInt(promoteLag, 10, 64)
+
+	// the leader demotes voters whose availability changes this many times
+	// within the window to learners (0 never demotes them)
+	demoteFlaps := getEnvDefault(
+		"LEIFDB_AUTO_DEMOTE_FLAPS", func() string { return "0" })
+	verifyInt(demoteFlaps)
+	autoDemoteFlaps, _ := strconv.Atoi(demoteFlaps)
+	demoteWindow := getEnvDefault(
+		"LEIFDB_AUTO_DEMOTE_WINDOW", func() string { return node.DefaultAutoDemoteWindow.String() })
+	autoDemoteWindow, err := time.ParseDuration(demoteWindow)
+	if err != nil {
+		panic(err)
+	}
+
+	// committed writes applied to the database together (1 applies each on
+	// its own)
+	batch := getEnvDefault(
+		"LEIFDB_APPLY_BATCH_SIZE",
+		func() string { return strconv.Itoa(node.DefaultApplyBatchSize) })
+	verifyInt(batch)
+	applyBatchSize, _ := strconv.Atoi(batch)
+
+	// deleted values are kept in the trash, to be restored, for this long (0
+	// deletes them outright)
+	trash := getEnvDefault(
+		"LEIFDB_TRASH_TTL", func() string { return "0s" })
+	trashTTL, err := time.ParseDuration(trash)
+	if err != nil {
+		panic(err)
+	}
+
+	// sets and deletes proposed together are appended to the log as one batch
+	// of up to this many entries and bytes, waiting up to the delay for
+	// others to join (1 entry disables batching, 0 bytes means unlimited)
+	proposalDelay := getEnvDefault(
+		"LEIFDB_PROPOSAL_BATCH_DELAY", func() string { return "0s" })
+	batchDelay, err := time.ParseDuration(proposalDelay)
+	if err != nil {
+		panic(err)
+	}
+	proposalEntries := getEnvDefault(
+		"LEIFDB_PROPOSAL_BATCH_ENTRIES", func() string { return "1" })
+	verifyInt(proposalEntries)
+	batchEntries, _ := strconv.Atoi(proposalEntries)
+	proposalBytes := getEnvDefault(
+		"LEIFDB_PROPOSAL_BATCH_BYTES", func() string { return "0" })
+	verifyInt(proposalBytes)
+	batchBytes, _ := strconv.ParseInt(proposalBytes, 10, 64)
+
+	// limits in bytes per second on catch-up replication to each peer, on
+	// snapshot downloads, and on writing snapshots to disk (0 means unlimited)
+	catchUp := getEnvDefault(
+		"LEIFDB_CATCHUP_BYTES_PER_SECOND", func() string { return "0" })
+	verifyInt(catchUp)
+	catchUpRate, _ := strconv.ParseInt(catchUp, 10, 64)
+	snapshotTransfer := getEnvDefault(
+		"LEIFDB_SNAPSHOT_BYTES_PER_SECOND", func() string { return "0" })
+	verifyInt(snapshotTransfer)
+	snapshotRate, _ := strconv.ParseInt(snapshotTransfer, 10, 64)
+	snapshotWrite := getEnvDefault(
+		"LEIFDB_SNAPSHOT_WRITE_BYTES_PER_SECOND", func() string { return "0" })
+	verifyInt(snapshotWrite)
+	snapshotWriteRate, _ := strconv.ParseInt(snapshotWrite, 10, 64)
+
+	// the data directory is checked against this id at startup, if set
+	clusterId := os.Getenv("LEIFDB_CLUSTER_ID")
+
+	// the leader publishes the membership to a system key, which each node
+	// writes to the topology file (if set) as it is applied
+	publish := getEnvDefault(
+		"LEIFDB_PUBLISH_TOPOLOGY", func() string { return "false" })
+	publishTopology, err := strconv.ParseBool(publish)
+	if err != nil {
+		panic(err)
+	}
+	topologyFile := os.Getenv("LEIFDB_TOPOLOGY_FILE")
+
+	// start even if the persisted state is inconsistent (also --force-recover)
+	recoverEnv := getEnvDefault(
+		"LEIFDB_FORCE_RECOVER", func() string { return "false" })
+	forceRecover, err := strconv.ParseBool(recoverEnv)
+	if err != nil {
+		panic(err)
+	}
+
+	// how writes to the log file are made durable (none, fsync, dsync, or
+	// direct), and the steps in which the log file is preallocated (0
+	// disables)
+	logSync, err := node.ParseLogSyncMode(getEnvDefault(
+		"LEIFDB_LOG_SYNC", func() string { return string(node.DefaultLogSync) }))
+	if err != nil {
+		panic(err)
+	}
+	preallocate := getEnvDefault(
+		"LEIFDB_LOG_PREALLOCATE_BYTES", func() string { return "0" })
+	verifyInt(preallocate)
+	logPreallocate, _ := strconv.ParseInt(preallocate, 10, 64)
+
+	// the apply lag (in entries) and number of queued writes past which
+	// low-priority reads and scans are shed (0 disables each)
+	shedLagEnv := getEnvDefault(
+		"LEIFDB_SHED_APPLY_LAG", func() string { return strconv.Itoa(node.DefaultShedApplyLag) })
+	verifyInt(shedLagEnv)
+	shedApplyLag, _ := strconv.ParseInt(shedLagEnv, 10, 64)
+	shedQueuedEnv := getEnvDefault(
+		"LEIFDB_SHED_QUEUED_WRITES", func() string { return strconv.Itoa(node.DefaultShedQueuedWrites) })
+	verifyInt(shedQueuedEnv)
+	shedQueuedWrites, _ := strconv.ParseInt(shedQueuedEnv, 10, 64)
+
+	// how rounds of append requests and reconnects to peers are retried, as
+	// settings replacing those of the defaults (see retry.Parse)
+	appendRetry, err := retry.Parse(
+		os.Getenv("LEIFDB_APPEND_RETRY"), node.DefaultAppendRetry)
+	if err != nil {
+		panic(err)
+	}
+	reconnectRetry, err := retry.Parse(
+		os.Getenv("LEIFDB_RECONNECT_RETRY"), node.DefaultReconnectRetry)
+	if err != nil {
+		panic(err)
+	}
+
 	return &ServerConfig{
 		Host:              host,
 		DataDir:           dataDir,
 		SnapshotThreshold: snapshotBytes,
+		SnapshotEntries:   snapshotEntryCount,
 		RetainNSnapshots:  retainNSnapshots,
+		RetainLogEntries:  retainLogEntries,
 		RaftPort:          raftPort,
 		RaftAddr:          raftAddr,
 		ClientPort:        clientPort,
 		ClientAddr:        clientAddr,
 		Mode:              ccfg.Mode,
-		NodeIds:           ccfg.NodeIds}
+		NodeIds:           ccfg.NodeIds,
+		Witness:           isWitness,
+		MaxInflightPeer:   maxInflightPeer,
+		MaxInflight:       maxInflight,
+		WebhookURLs:       webhookURLs,
+		CatchUpLag:        catchUpLag,
+		ValueChunkSize:    valueChunkSize,
+		MaxProposalBytes:  maxProposalBytes,
+		HedgeDelay:        hedgeDelay,
+		HedgeMaxPercent:   hedgeMaxPercent,
+		ReadIndexWindow:   readIndexWindow,
+		LeaderLease:       leaderLease,
+		MaxClockSkew:      maxClockSkew,
+		AutoPromoteAfter:  autoPromoteAfter,
+		AutoPromoteLag:    autoPromoteLag,
+		AutoDemoteFlaps:   autoDemoteFlaps,
+		AutoDemoteWindow:  autoDemoteWindow,
+		ApplyBatchSize:    applyBatchSize,
+		TrashTTL:          trashTTL,
+		BatchDelay:        batchDelay,
+		BatchEntries:      batchEntries,
+		BatchBytes:        batchBytes,
+		PublishTopology:   publishTopology,
+		TopologyFile:      topologyFile,
+		CatchUpRate:       catchUpRate,
+		SnapshotRate:      snapshotRate,
+		SnapshotWriteRate: snapshotWriteRate,
+		ClusterId:         clusterId,
+		ForceRecover:      forceRecover,
+		LogSync:           logSync,
+		LogPreallocate:    logPreallocate,
+		ShedApplyLag:      shedApplyLag,
+		ShedQueuedWrites:  shedQueuedWrites,
+		AppendRetry:       appendRetry,
+		ReconnectRetry:    reconnectRetry}
+}
+
+// GetLogConfigFile fetches the path of the log configuration file set at the
+// env var: LEIFDB_LOG_CONFIG (empty if there is none)
+func GetLogConfigFile() string {
+	return os.Getenv("LEIFDB_LOG_CONFIG")
 }
 
 // GetLogLevel fetches the log level set at the env var: LEIF_LOG_LEVEL
@@ -0,0 +1,113 @@
+package database
+
+// A batch is a list of set, delete, and compare-and-swap operations applied in
+// order as a single update. Batches are applied either all-or-nothing (if any
+// compare-and-swap fails, none of the operations are applied) or best-effort
+// (failed compare-and-swaps are skipped, and the rest are applied)
+import (
+	"errors"
+)
+
+// ErrInvalidBatch indicates that a batch is empty or has an operation that is
+// not one of the defined kinds
+var ErrInvalidBatch = errors.New("Batch operations must be set, delete, or cas")
+
+// Kinds of batch operation
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpCAS    = "cas"
+)
+
+// A BatchOp is one operation in a batch. A cas operation sets the key to Value
+// only if its current value is Expected, or if Expected is nil and the key
+// does not exist
+type BatchOp struct {
+	Op       string  `json:"op"`
+	Key      string  `json:"key"`
+	Value    string  `json:"value,omitempty"`
+	Expected *string `json:"expected,omitempty"`
+}
+
+// ValidateBatch returns ErrInvalidBatch if ops is empty or contains an
+// operation of an unknown kind
+func ValidateBatch(ops []BatchOp) error {
+	if len(ops) == 0 {
+		return ErrInvalidBatch
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case OpSet, OpDelete, OpCAS:
+		default:
+			return ErrInvalidBatch
+		}
+	}
+	return nil
+}
+
+// valueAt returns the value of key as of now (Unix nanoseconds), or nil if it
+// does not exist or has expired
+func (d *Database) valueAt(key string, now int64) *string {
+	r, _ := d.getValue(key)
+	if r == nil || d.expired(key, now) {
+		return nil
+	}
+	value := d.resolve(r)
+	return &value
+}
+
+// sameValue reports whether two optional values are both absent or equal
+func sameValue(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ApplyBatch applies ops in order, and returns whether each was applied. Keys
+// are treated as expired based on now (Unix nanoseconds, chosen by the leader
+// so that every replica makes the same decision). Each cas operation sees the
+// effects of the operations before it. If atomic, nothing is applied unless
+// every cas operation succeeds
+func (d *Database) ApplyBatch(ops []BatchOp, atomic bool, now int64) []bool {
+	applied := make([]bool, len(ops))
+	if atomic {
+		// check every cas against the values that earlier operations in the
+		// batch would leave, before changing anything
+		pending := map[string]*string{}
+		for _, op := range ops {
+			current, ok := pending[op.Key]
+			if !ok {
+				current = d.valueAt(op.Key, now)
+			}
+			switch op.Op {
+			case OpCAS:
+				if !sameValue(current, op.Expected) {
+					return applied
+				}
+				fallthrough
+			case OpSet:
+				value := op.Value
+				pending[op.Key] = &value
+			case OpDelete:
+				pending[op.Key] = nil
+			}
+		}
+	}
+	for i, op := range ops {
+		switch op.Op {
+		case OpSet:
+			d.Set(op.Key, op.Value)
+			applied[i] = true
+		case OpDelete:
+			d.Delete(op.Key)
+			applied[i] = true
+		case OpCAS:
+			if sameValue(d.valueAt(op.Key, now), op.Expected) {
+				d.Set(op.Key, op.Value)
+				applied[i] = true
+			}
+		}
+	}
+	return applied
+}
@@ -0,0 +1,58 @@
+// +build unit
+
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyBatch(t *testing.T) {
+	d := NewDatabase()
+	d.Set("a", "1")
+	now := time.Now().UnixNano()
+	one, two := "1", "2"
+
+	// in atomic mode, a failed cas means nothing is applied
+	ops := []BatchOp{
+		{Op: OpSet, Key: "b", Value: "x"},
+		{Op: OpCAS, Key: "a", Expected: &two, Value: "3"},
+	}
+	if applied := d.ApplyBatch(ops, true, now); !reflect.DeepEqual(applied, []bool{false, false}) {
+		t.Errorf("Expected nothing applied, got %v\n", applied)
+	}
+	if d.Exists("b") {
+		t.Error("Expected set in failed batch not to be applied")
+	}
+
+	// ...while best-effort mode skips just the failed cas
+	if applied := d.ApplyBatch(ops, false, now); !reflect.DeepEqual(applied, []bool{true, false}) {
+		t.Errorf("Expected only the set applied, got %v\n", applied)
+	}
+	if d.Get("b") != "x" || d.Get("a") != "1" {
+		t.Errorf("Unexpected values after best-effort batch: a=%q b=%q\n", d.Get("a"), d.Get("b"))
+	}
+
+	// a cas sees the effects of earlier operations, and a nil expected value
+	// means the key must not exist
+	ops = []BatchOp{
+		{Op: OpCAS, Key: "a", Expected: &one, Value: "2"},
+		{Op: OpCAS, Key: "a", Expected: &two, Value: "3"},
+		{Op: OpDelete, Key: "b"},
+		{Op: OpCAS, Key: "b", Value: "y"},
+	}
+	if applied := d.ApplyBatch(ops, true, now); !reflect.DeepEqual(applied, []bool{true, true, true, true}) {
+		t.Errorf("Expected every operation applied, got %v\n", applied)
+	}
+	if d.Get("a") != "3" || d.Get("b") != "y" {
+		t.Errorf("Unexpected values after batch: a=%q b=%q\n", d.Get("a"), d.Get("b"))
+	}
+
+	if err := ValidateBatch([]BatchOp{{Op: "incr", Key: "a"}}); err != ErrInvalidBatch {
+		t.Errorf("Expected %v for unknown op, got %v\n", ErrInvalidBatch, err)
+	}
+	if err := ValidateBatch(nil); err != ErrInvalidBatch {
+		t.Errorf("Expected %v for empty batch, got %v\n", ErrInvalidBatch, err)
+	}
+}
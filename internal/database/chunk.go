@@ -0,0 +1,98 @@
+package database
+
+// Values larger than the configured chunk size (see `node.NodeConfig`) are
+// written as a series of chunks, each replicated in its own log entry and
+// stored under a key derived from the value's key, followed by an entry that
+// commits the chunks as the key's value. Chunks are kept apart from other keys,
+// and the value is reassembled from them on read--since the database is
+// immutable, a read always sees a complete and consistent set of chunks
+import (
+	"fmt"
+	"strings"
+)
+
+// A chunkedValue is stored in place of a value that was written in chunks
+type chunkedValue struct {
+	keys []string
+}
+
+// ChunkKey derives the key for one chunk of a value. The write id must be
+// unique for each write of a chunked value to the key
+func ChunkKey(key string, writeId string, index int) string {
+	return fmt.Sprintf("%s\x00%s\x00%06d", key, writeId, index)
+}
+
+// PutChunk stores one chunk of a value, which is not visible until the value
+// is committed with `SetChunked`
+func (d *Database) PutChunk(chunkKey string, data []byte) {
+	d.chunks, _, _ = d.chunks.Insert([]byte(chunkKey), string(data))
+}
+
+// SetChunked assigns the value made up of the chunks stored under chunkKeys (in
+// order) to key, clearing any expiry time. Any other chunks stored for key
+// (belonging to an earlier value, or to a write that was never committed) are
+// removed
+func (d *Database) SetChunked(key string, chunkKeys []string) {
+	value := &chunkedValue{keys: chunkKeys}
+	d.replace(key, value)
+	d.dropChunks(key, chunkKeys)
+}
+
+// resolve returns the value for an entry in the underlying tree, reassembling
+// it if it was written in chunks
+func (d *Database) resolve(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case *chunkedValue:
+		var b strings.Builder
+		for _, chunkKey := range value.keys {
+			if chunk, ok := d.chunks.Get([]byte(chunkKey)); ok {
+				b.WriteString(chunk.(string))
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// dropChunks removes the chunks stored for key, except for those in keep
+func (d *Database) dropChunks(key string, keep []string) {
+	keepSet := make(map[string]bool, len(keep))
+	for _, chunkKey := range keep {
+		keepSet[chunkKey] = true
+	}
+	drop := [][]byte{}
+	d.chunks.Root().WalkPrefix([]byte(key+"\x00"), func(k []byte, _ interface{}) bool {
+		if !keepSet[string(k)] {
+			drop = append(drop, k)
+		}
+		return false
+	})
+	if len(drop) == 0 {
+		return
+	}
+	txn := d.chunks.Txn()
+	for _, k := range drop {
+		txn.Delete(k)
+	}
+	d.chunks = txn.Commit()
+}
+
+// chunkData returns the chunks of all chunked values, for snapshots
+func (d *Database) chunkData() (map[string][]string, map[string][]byte) {
+	chunked := map[string][]string{}
+	chunks := map[string][]byte{}
+	d.underlying.Root().Walk(func(k []byte, v interface{}) bool {
+		if value, ok := v.(*chunkedValue); ok {
+			chunked[string(k)] = value.keys
+			for _, chunkKey := range value.keys {
+				if chunk, ok := d.chunks.Get([]byte(chunkKey)); ok {
+					chunks[chunkKey] = []byte(chunk.(string))
+				}
+			}
+		}
+		return false
+	})
+	return chunked, chunks
+}
@@ -0,0 +1,64 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+)
+
+func TestChunkedValues(t *testing.T) {
+	d := NewDatabase()
+	d.CreateIndex("$.name")
+
+	// chunks split in the middle of a multi-byte character
+	value := `{"name": "café"}`
+	keys := []string{ChunkKey("doc", "1", 0), ChunkKey("doc", "1", 1)}
+	d.PutChunk(keys[0], []byte(value[:14]))
+	if d.Exists("doc") || d.Get("doc") != "" {
+		t.Error("Expected uncommitted chunks to be invisible")
+	}
+	d.PutChunk(keys[1], []byte(value[14:]))
+	d.SetChunked("doc", keys)
+
+	if v := d.Get("doc"); v != value {
+		t.Errorf("Expected %q, got %q", value, v)
+	}
+	if found, _ := d.Find("$.name", `"café"`); len(found) != 1 {
+		t.Errorf("Expected chunked value to be indexed, got %v", found)
+	}
+	cursor := d.Cursor("")
+	if k, v, ok := cursor.Next(); !ok || k != "doc" || v != value {
+		t.Errorf("Expected cursor to return reassembled value, got %q %q", k, v)
+	}
+
+	// chunks survive snapshots
+	snapshot, _ := BuildSnapshot(d)
+	restored, err := InstallSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v", err)
+	}
+	if v := restored.Get("doc"); v != value {
+		t.Errorf("Expected restored value %q, got %q", value, v)
+	}
+
+	// a clone keeps the value it saw after the key is overwritten
+	clone := Clone(d)
+	d.PutChunk(ChunkKey("orphan", "2", 0), []byte("x"))
+	d.Set("doc", `{"name": "tea"}`)
+	if v := clone.Get("doc"); v != value {
+		t.Errorf("Expected clone to keep chunked value, got %q", v)
+	}
+	if d.chunks.Len() != 1 {
+		t.Errorf("Expected chunks of overwritten value to be removed, %d remain", d.chunks.Len())
+	}
+	if found, _ := d.Find("$.name", `"café"`); len(found) != 0 {
+		t.Errorf("Expected index entry of overwritten value to be removed, got %v", found)
+	}
+
+	// committing a value removes chunks left by an uncommitted write
+	d.SetChunked("orphan", []string{})
+	d.Delete("orphan")
+	if d.chunks.Len() != 0 {
+		t.Errorf("Expected no chunks to remain, %d remain", d.chunks.Len())
+	}
+}
@@ -1,7 +1,11 @@
 package database
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
+	"math"
+	"time"
 
 	iradix "github.com/hashicorp/go-immutable-radix"
 )
@@ -9,31 +13,82 @@ import (
 // A Database is a key-value store
 type Database struct {
 	underlying *iradix.Tree
+	indexes    map[string]*iradix.Tree
+	sortedSets *iradix.Tree
+	sets       *iradix.Tree
+	expiries   *iradix.Tree
+	chunks     *iradix.Tree
+	readOnly   string
+	// transactions on underlying and expiries while a write batch is open
+	// (see writebatch.go)
+	values   *iradix.Txn
+	expiring *iradix.Txn
 }
 
-// Get retrieves the value for a key (empty string if key does not exist)
+// Get retrieves the value for a key (empty string if key does not exist or has
+// expired)
 func (d *Database) Get(key string) string {
-	r, _ := d.underlying.Get([]byte(key))
-	if r == nil {
+	r, _ := d.getValue(key)
+	if r == nil || d.expired(key, time.Now().UnixNano()) {
 		return ""
 	}
-	return r.(string)
+	return d.resolve(r)
 }
 
-// Set assigns a value to a key
+// Len returns the number of keys in the database, including expired keys
+// that have not been removed yet
+func (d *Database) Len() int {
+	return d.underlying.Len()
+}
+
+// CountPrefix returns the number of keys that start with prefix, including
+// expired keys that have not been removed yet
+func (d *Database) CountPrefix(prefix string) int {
+	count := 0
+	d.underlying.Root().WalkPrefix([]byte(prefix), func(_ []byte, _ interface{}) bool {
+		count++
+		return false
+	})
+	return count
+}
+
+// Set assigns a value to a key, clearing any expiry time
 func (d *Database) Set(key string, value string) {
-	d.underlying, _, _ = d.underlying.Insert([]byte(key), value)
+	d.replace(key, value)
+	d.dropChunks(key, nil)
 }
 
-// Delete removes a key and value from the store
+// replace stores a value (a string or a chunked value) for a key, clearing any
+// expiry time and updating indexes
+func (d *Database) replace(key string, value interface{}) {
+	d.Persist(key)
+	var old *string
+	if prev := d.insertValue(key, value); prev != nil {
+		prevValue := d.resolve(prev)
+		old = &prevValue
+	}
+	newValue := d.resolve(value)
+	d.updateIndexes(key, old, &newValue)
+}
+
+// Delete removes a key and value (and any expiry time) from the store
 func (d *Database) Delete(key string) {
-	d.underlying, _, _ = d.underlying.Delete([]byte(key))
+	d.Persist(key)
+	if prev := d.deleteValue(key); prev != nil {
+		prevValue := d.resolve(prev)
+		d.updateIndexes(key, &prevValue, nil)
+	}
+	d.dropChunks(key, nil)
 }
 
 // NewDatabase returns an initialized Database
 func NewDatabase() *Database {
 	return &Database{
 		underlying: iradix.New(),
+		sortedSets: iradix.New(),
+		sets:       iradix.New(),
+		expiries:   iradix.New(),
+		chunks:     iradix.New(),
 	}
 }
 
@@ -41,36 +96,244 @@ func NewDatabase() *Database {
 func Clone(db *Database) *Database {
 	return &Database{
 		underlying: db.underlying,
+		indexes:    db.indexes,
+		sortedSets: db.sortedSets,
+		sets:       db.sets,
+		expiries:   db.expiries,
+		chunks:     db.chunks,
+		readOnly:   db.readOnly,
+	}
+}
+
+// SetReadOnly records that writes to the cluster are disabled, for the reason
+// given, or that they are enabled if reason is empty. The database itself
+// does not enforce it
+func (d *Database) SetReadOnly(reason string) {
+	d.readOnly = reason
+}
+
+// ReadOnly returns the reason writes to the cluster are disabled, or an empty
+// string if they are enabled
+func (d *Database) ReadOnly() string {
+	return d.readOnly
+}
+
+// A Cursor iterates over the keys and values in a database in key order. It
+// reads from the state of the database when the cursor was created, so writes
+// made while iterating are not observed
+type Cursor struct {
+	iter  *iradix.Iterator
+	start []byte
+	db    *Database
+}
+
+// Cursor returns a cursor positioned at the first key greater than or equal to
+// start (an empty start iterates over all keys). To resume iteration after the
+// last key seen, pass `NextKey(key)` as start
+func (d *Database) Cursor(start string) *Cursor {
+	// note: `Iterator.SeekLowerBound` in the version of iradix in use panics on
+	// some inputs (see TestSeekLowerBound), so keys before start are skipped in
+	// `Next` instead
+	return &Cursor{
+		iter:  d.underlying.Root().Iterator(),
+		start: []byte(start),
+		db:    Clone(d)}
+}
+
+// PrefixCursor returns a cursor over the keys that start with prefix, which
+// starts at the first of them rather than skipping the keys before it
+func (d *Database) PrefixCursor(prefix string) *Cursor {
+	iter := d.underlying.Root().Iterator()
+	iter.SeekPrefix([]byte(prefix))
+	return &Cursor{
+		iter: iter,
+		db:   Clone(d)}
+}
+
+// Next returns the next key and value, or false when there are no more keys
+func (c *Cursor) Next() (string, string, bool) {
+	key, value, ok := c.next()
+	if !ok {
+		return "", "", false
+	}
+	return key, c.db.resolve(value), true
+}
+
+// next returns the next key and its stored value (a string or a chunked
+// value), or false when there are no more keys
+func (c *Cursor) next() (string, interface{}, bool) {
+	for {
+		key, value, ok := c.iter.Next()
+		if !ok {
+			return "", nil, false
+		}
+		if c.start != nil && bytes.Compare(key, c.start) < 0 {
+			continue
+		}
+		c.start = nil
+		return string(key), value, true
 	}
 }
 
+// NextKey returns the smallest possible key that sorts after key
+func NextKey(key string) string {
+	return key + "\x00"
+}
+
 type pair struct {
 	K string
 	V string
 }
 
-// BuildSnapshot serializes the database state into a JSON array of objects
-// with keys K and V and the key and value for each entry as respective values
+// snapshot is the serialized form of a database
+type snapshot struct {
+	Pairs      []pair                    `json:"pairs"`
+	Indexes    []string                  `json:"indexes"`
+	SortedSets map[string][]ScoredMember `json:"sortedSets,omitempty"`
+	Sets       map[string][]string       `json:"sets,omitempty"`
+	Expiries   map[string]int64          `json:"expiries,omitempty"`
+	Chunked    map[string][]string       `json:"chunked,omitempty"`
+	Chunks     map[string][]byte         `json:"chunks,omitempty"`
+	ReadOnly   string                    `json:"readOnly,omitempty"`
+}
+
+// snapshotBatch is the number of pairs WriteSnapshot encodes before writing
+// them out
+const snapshotBatch = 1024
+
+// pairsField opens the snapshot object and its array of pairs
+var pairsField = []byte(`{"pairs":[`)
+
+// BuildSnapshot serializes the database state into a JSON object, with the list
+// of indexed paths, the members of each set and sorted set, the expiry time of
+// each key that has one, and an array of objects with keys K and V and the key
+// and value for each entry as respective values. Values written in chunks are
+// kept as chunks, so that no single value in the snapshot is larger than the
+// chunk size
 func BuildSnapshot(db *Database) ([]byte, error) {
-	accumulator := []pair{}
-	db.underlying.Root().Walk(func(key []byte, value interface{}) bool {
-		accumulator = append(accumulator, pair{K: string(key), V: value.(string)})
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, db); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteSnapshot writes the snapshot built by BuildSnapshot to w, without
+// holding all of it in memory: the pairs, which make up most of a snapshot,
+// are read with a cursor and written snapshotBatch at a time. The cursor reads
+// from the state of the database when WriteSnapshot is called, so db may go
+// on being written to (through the Node, from a Clone) while the snapshot is
+// written, e.g. slowly, to a rate-limited writer
+func WriteSnapshot(w io.Writer, db *Database) error {
+	db = Clone(db)
+	if _, err := w.Write(pairsField); err != nil {
+		return err
+	}
+	cursor := db.Cursor("")
+	var batch bytes.Buffer
+	count := 0
+	for {
+		key, value, ok := cursor.next()
+		if !ok {
+			break
+		}
+		v, ok := value.(string)
+		if !ok {
+			// chunked values are written with the chunks, below
+			continue
+		}
+		if count > 0 {
+			batch.WriteByte(',')
+		}
+		encoded, err := json.Marshal(pair{K: key, V: v})
+		if err != nil {
+			return err
+		}
+		batch.Write(encoded)
+		count++
+		if count%snapshotBatch == 0 {
+			if _, err := w.Write(batch.Bytes()); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	batch.WriteByte(']')
+	if _, err := w.Write(batch.Bytes()); err != nil {
+		return err
+	}
+
+	chunked, chunks := db.chunkData()
+	sortedSets := map[string][]ScoredMember{}
+	db.sortedSets.Root().Walk(func(key []byte, _ interface{}) bool {
+		sortedSets[string(key)] = db.ZRangeByScore(
+			string(key), math.Inf(-1), math.Inf(1), 0)
+		return false
+	})
+	sets := map[string][]string{}
+	db.sets.Root().Walk(func(key []byte, _ interface{}) bool {
+		sets[string(key)] = db.SMembers(string(key))
 		return false
 	})
-	return json.Marshal(accumulator)
+	expiries := map[string]int64{}
+	db.expiries.Root().Walk(func(key []byte, at interface{}) bool {
+		expiries[string(key)] = at.(int64)
+		return false
+	})
+	rest, err := json.Marshal(snapshot{
+		Pairs:      []pair{},
+		Indexes:    db.Indexes(),
+		SortedSets: sortedSets,
+		Sets:       sets,
+		Expiries:   expiries,
+		Chunked:    chunked,
+		Chunks:     chunks,
+		ReadOnly:   db.readOnly})
+	if err != nil {
+		return err
+	}
+	// the rest of the object, after the (empty) pairs just marshalled
+	_, err = w.Write(rest[len(pairsField)+1:])
+	return err
 }
 
 // InstallSnapshot deserializes a JSON string (following the schema created by
-// BuildSnapshot) and returns a populated Database
+// BuildSnapshot, or the bare array of pairs used by earlier versions) and
+// returns a populated Database
 func InstallSnapshot(data []byte) (*Database, error) {
-	var pairs []pair
+	var s snapshot
 	db := NewDatabase()
 
-	if err := json.Unmarshal(data, &pairs); err != nil {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(data, &s.Pairs); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &s); err != nil {
 		return nil, err
 	}
-	for _, p := range pairs {
+	for _, p := range s.Pairs {
 		db.Set(p.K, p.V)
 	}
+	for chunkKey, data := range s.Chunks {
+		db.PutChunk(chunkKey, data)
+	}
+	for key, chunkKeys := range s.Chunked {
+		db.SetChunked(key, chunkKeys)
+	}
+	for key, members := range s.SortedSets {
+		db.ZAdd(key, members)
+	}
+	for key, members := range s.Sets {
+		db.SAdd(key, members)
+	}
+	for key, at := range s.Expiries {
+		db.Touch(key, at)
+	}
+	for _, path := range s.Indexes {
+		if err := db.CreateIndex(path); err != nil {
+			return nil, err
+		}
+	}
+	db.readOnly = s.ReadOnly
 	return db, nil
 }
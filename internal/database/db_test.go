@@ -3,7 +3,13 @@
 package database
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
 )
 
 func TestDatabase(t *testing.T) {
@@ -80,3 +86,175 @@ func TestSnapshotRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+// writeHook is a writer that calls hook before each write
+type writeHook struct {
+	buf  bytes.Buffer
+	hook func()
+}
+
+func (w *writeHook) Write(p []byte) (int, error) {
+	w.hook()
+	return w.buf.Write(p)
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	d := NewDatabase()
+	// enough pairs for several batches
+	for i := 0; i < snapshotBatch*2+10; i++ {
+		d.Set(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%d", i))
+	}
+	d.PutChunk(ChunkKey("big", "w1", 0), []byte("chunk one "))
+	d.PutChunk(ChunkKey("big", "w1", 1), []byte("chunk two"))
+	d.SetChunked("big", []string{ChunkKey("big", "w1", 0), ChunkKey("big", "w1", 1)})
+	d.SAdd("set", []string{"a", "b"})
+	d.Touch("key00001", time.Now().Add(time.Hour).UnixNano())
+	expected, err := BuildSnapshot(Clone(d))
+	if err != nil {
+		t.Fatalf("Error in BuildSnapshot: %v\n", err)
+	}
+
+	// writes made while the snapshot is being written are not included
+	writes := 0
+	w := &writeHook{hook: func() {
+		writes++
+		d.Set(fmt.Sprintf("during%d", writes), "write")
+		d.Delete(fmt.Sprintf("key%05d", writes))
+		d.Set("big", "small")
+	}}
+	if err := WriteSnapshot(w, d); err != nil {
+		t.Fatalf("Error in WriteSnapshot: %v\n", err)
+	}
+	if writes < 3 {
+		t.Errorf("Expected the pairs to be written in batches, got %d writes\n", writes)
+	}
+	if !bytes.Equal(w.buf.Bytes(), expected) {
+		t.Errorf("Snapshot written during writes differs from one taken before them\n")
+	}
+
+	// the snapshot is encoded as if the whole object were marshalled at once
+	var s snapshot
+	if err := json.Unmarshal(w.buf.Bytes(), &s); err != nil {
+		t.Fatalf("Error decoding snapshot: %v\n", err)
+	}
+	if len(s.Pairs) != snapshotBatch*2+10 || len(s.Chunked) != 1 || len(s.Sets) != 1 || len(s.Expiries) != 1 {
+		t.Errorf("Unexpected snapshot contents: %d pairs, %d chunked, %d sets, %d expiries\n",
+			len(s.Pairs), len(s.Chunked), len(s.Sets), len(s.Expiries))
+	}
+	remarshalled, _ := json.Marshal(s)
+	if !bytes.Equal(remarshalled, w.buf.Bytes()) {
+		t.Errorf("Expected snapshot to match its marshalled form\n")
+	}
+
+	restored, err := InstallSnapshot(w.buf.Bytes())
+	if err != nil {
+		t.Fatalf("Error in InstallSnapshot: %v\n", err)
+	}
+	if v := restored.Get("big"); v != "chunk one chunk two" {
+		t.Errorf("Expected chunked value to be restored, got %q\n", v)
+	}
+	if v := restored.Get("key00001"); v != "value1" {
+		t.Errorf("Expected key00001=value1, got %q\n", v)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	d := NewDatabase()
+	keys := []string{"a", "ab", "abc", "b", "ba", "c", "cab", "d"}
+	// insert out of order
+	for _, i := range []int{5, 0, 7, 2, 4, 1, 6, 3} {
+		d.Set(keys[i], "v"+keys[i])
+	}
+
+	collect := func(c *Cursor) []string {
+		got := []string{}
+		for k, v, ok := c.Next(); ok; k, v, ok = c.Next() {
+			if v != "v"+k {
+				t.Errorf("Expected value v%s for key %s, got %s\n", k, k, v)
+			}
+			got = append(got, k)
+		}
+		return got
+	}
+	check := func(name string, got []string, expected []string) {
+		if len(got) != len(expected) {
+			t.Errorf("[%s] Expected %v, got %v\n", name, expected, got)
+			return
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("[%s] Expected %v, got %v\n", name, expected, got)
+				return
+			}
+		}
+	}
+
+	check("all keys", collect(d.Cursor("")), keys)
+	check("from existing key", collect(d.Cursor("b")), keys[3:])
+	check("from missing key", collect(d.Cursor("bb")), keys[5:])
+	check("from prefix of keys", collect(d.Cursor("ca")), keys[6:])
+	check("resume after key", collect(d.Cursor(NextKey("ab"))), keys[2:])
+	check("past the end", collect(d.Cursor("e")), []string{})
+
+	// the cursor does not observe writes made after it was created
+	c := d.Cursor("")
+	d.Set("aa", "vaa")
+	d.Delete("d")
+	check("consistent", collect(c), keys)
+}
+
+func TestPrefixCursor(t *testing.T) {
+	d := NewDatabase()
+	for _, key := range []string{"a", "ab", "abc", "b", "ba", "c"} {
+		d.Set(key, "v")
+	}
+	for prefix, expected := range map[string][]string{
+		"":   {"a", "ab", "abc", "b", "ba", "c"},
+		"a":  {"a", "ab", "abc"},
+		"ab": {"ab", "abc"},
+		"b":  {"b", "ba"},
+		"bb": {},
+		"d":  {},
+	} {
+		got := []string{}
+		c := d.PrefixCursor(prefix)
+		for k, _, ok := c.Next(); ok; k, _, ok = c.Next() {
+			got = append(got, k)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("Expected keys %v with prefix %q, got %v", expected, prefix, got)
+		}
+	}
+}
+
+// TestSeekLowerBound records why Cursor skips keys before its start instead
+// of seeking to it: in the version of iradix in use, SeekLowerBound panics on
+// some trees. If this fails, the dependency has been fixed, and Cursor can
+// seek
+func TestSeekLowerBound(t *testing.T) {
+	tree := iradix.New()
+	for _, key := range []string{"a", "b", "ba", "bc"} {
+		tree, _, _ = tree.Insert([]byte(key), key)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SeekLowerBound to panic")
+		}
+	}()
+	iter := tree.Root().Iterator()
+	iter.SeekLowerBound([]byte(""))
+	for _, _, ok := iter.Next(); ok; _, _, ok = iter.Next() {
+	}
+}
+
+func TestCountPrefix(t *testing.T) {
+	d := NewDatabase()
+	for _, key := range []string{"user:1", "user:2", "users", "order:1"} {
+		d.Set(key, "x")
+	}
+	for prefix, expected := range map[string]int{"user:": 2, "user": 3, "order:": 1, "": 4, "none:": 0} {
+		if count := d.CountPrefix(prefix); count != expected {
+			t.Errorf("Expected %d keys with prefix %q, got %d", expected, prefix, count)
+		}
+	}
+}
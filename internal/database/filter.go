@@ -0,0 +1,75 @@
+package database
+
+// Filters select keys as a scan visits them, so that a selective query ships
+// only the matching keys to the client rather than every key in the range. A
+// filter is evaluated against each key and value in turn, without an index, so
+// it saves network and client time but not the time to visit the range
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// maxKeyPatternLength bounds the size of a key pattern, which is compiled for
+// every scan that uses it
+const maxKeyPatternLength = 256
+
+var (
+	// ErrInvalidKeyPattern indicates a key filter that is not a valid regular
+	// expression
+	ErrInvalidKeyPattern = errors.New("Key pattern must be a valid regular expression of at most 256 characters")
+)
+
+// A Filter selects keys by any combination of a regular expression on the
+// key, a substring of the value, and a JSON path whose value equals a scalar.
+// The zero Filter matches every key
+type Filter struct {
+	keyPattern *regexp.Regexp
+	contains   string
+	path       string
+	value      string
+}
+
+// NewFilter builds a Filter from its conditions, each of which is ignored if
+// empty: a regular expression that keys must match, a substring that values
+// must contain, and a query (as for ParseQuery) that JSON values must satisfy
+func NewFilter(keyPattern string, contains string, where string) (*Filter, error) {
+	f := &Filter{contains: contains}
+	if keyPattern != "" {
+		if len(keyPattern) > maxKeyPatternLength {
+			return nil, ErrInvalidKeyPattern
+		}
+		pattern, err := regexp.Compile(keyPattern)
+		if err != nil {
+			return nil, ErrInvalidKeyPattern
+		}
+		f.keyPattern = pattern
+	}
+	if where != "" {
+		path, value, err := ParseQuery(where)
+		if err != nil {
+			return nil, err
+		}
+		f.path, f.value = path, value
+	}
+	return f, nil
+}
+
+// Match reports whether key and its value satisfy every condition of f. The
+// cheaper conditions are checked first, so a JSON value is only decoded if the
+// key and substring match
+func (f *Filter) Match(key string, value string) bool {
+	if f.keyPattern != nil && !f.keyPattern.MatchString(key) {
+		return false
+	}
+	if f.contains != "" && !strings.Contains(value, f.contains) {
+		return false
+	}
+	if f.path != "" {
+		found, ok := extract(f.path, value)
+		if !ok || found != f.value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pattern  string
+		contains string
+		where    string
+		key      string
+		value    string
+		match    bool
+	}{
+		{"empty", "", "", "", "k", "v", true},
+		{"key pattern", `^user:\d+$`, "", "", "user:42", "v", true},
+		{"key pattern mismatch", `^user:\d+$`, "", "", "user:bob", "v", false},
+		{"contains", "", "ali", "", "k", "alice", true},
+		{"contains mismatch", "", "bob", "", "k", "alice", false},
+		{"where", "", "", `$.status == "active"`, "k", `{"status": "active"}`, true},
+		{"where number", "", "", `$.a.b == 1`, "k", `{"a": {"b": 1.0}}`, true},
+		{"where mismatch", "", "", `$.status == "active"`, "k", `{"status": "done"}`, false},
+		{"where not JSON", "", "", `$.status == "active"`, "k", "active", false},
+		{"all", "^user:", "active", `$.status == "active"`, "user:1", `{"status": "active"}`, true},
+		{"all but key", "^user:", "active", `$.status == "active"`, "order:1", `{"status": "active"}`, false}}
+
+	for _, tc := range testCases {
+		f, err := NewFilter(tc.pattern, tc.contains, tc.where)
+		if err != nil {
+			t.Errorf("[%s] Error building filter: %v", tc.name, err)
+			continue
+		}
+		if match := f.Match(tc.key, tc.value); match != tc.match {
+			t.Errorf("[%s] Expected match %v for %s=%s, got %v", tc.name, tc.match, tc.key, tc.value, match)
+		}
+	}
+
+	if _, err := NewFilter("user:(", "", ""); err != ErrInvalidKeyPattern {
+		t.Errorf("Expected ErrInvalidKeyPattern, got %v", err)
+	}
+	if _, err := NewFilter("", "", "$.status = 1"); err != ErrInvalidQuery {
+		t.Errorf("Expected ErrInvalidQuery, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+// Secondary indexes map the value found at a path inside JSON documents to the
+// keys holding those documents. Indexes are updated along with every Set and
+// Delete, so a Find never observes an index that disagrees with the data
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+var (
+	// ErrInvalidPath indicates that an index path is not of the form
+	// `$.field.subfield`
+	ErrInvalidPath = errors.New("Index path must be of the form $.field.subfield")
+
+	// ErrNoIndex indicates that a query was made on a path with no index
+	ErrNoIndex = errors.New("No index exists for path")
+
+	// ErrInvalidQuery indicates that a query is not of the form
+	// `$.field == <JSON scalar>`
+	ErrInvalidQuery = errors.New(
+		"Query must be of the form $.field == <string, number, boolean, or null>")
+)
+
+var pathPattern = regexp.MustCompile(`^\$(\.[A-Za-z0-9_-]+)+$`)
+
+// ValidatePath returns an error if path is not a valid index path
+func ValidatePath(path string) error {
+	if !pathPattern.MatchString(path) {
+		return ErrInvalidPath
+	}
+	return nil
+}
+
+// canonicalScalar returns a canonical JSON encoding of a scalar value (so that
+// e.g. `1` and `1.0` are indexed the same way), or false if it is not a scalar
+func canonicalScalar(v interface{}) (string, bool) {
+	switch v.(type) {
+	case string, float64, bool, nil:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+	return "", false
+}
+
+// extract returns the canonical encoding of the scalar at path in a JSON
+// document, or false if the value is not a JSON object with a scalar at path
+func extract(path string, value string) (string, bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return "", false
+	}
+	for _, field := range strings.Split(path, ".")[1:] {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if doc, ok = obj[field]; !ok {
+			return "", false
+		}
+	}
+	return canonicalScalar(doc)
+}
+
+// ParseQuery splits a query of the form `$.field == "value"` into the path and
+// the canonical encoding of the value
+func ParseQuery(query string) (string, string, error) {
+	parts := strings.SplitN(query, "==", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidQuery
+	}
+	path := strings.TrimSpace(parts[0])
+	if err := ValidatePath(path); err != nil {
+		return "", "", err
+	}
+	var literal interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(parts[1])), &literal); err != nil {
+		return "", "", ErrInvalidQuery
+	}
+	value, ok := canonicalScalar(literal)
+	if !ok {
+		return "", "", ErrInvalidQuery
+	}
+	return path, value, nil
+}
+
+// indexKey builds the key of an index entry--JSON encoding escapes control
+// characters, so the separator cannot appear in an indexed value
+func indexKey(indexed string, key string) []byte {
+	return []byte(indexed + "\x00" + key)
+}
+
+// updateIndexes replaces the index entries for a key, given its old value
+// (if it had one) and its new value (if it was not deleted)
+func (d *Database) updateIndexes(key string, old *string, new *string) {
+	if len(d.indexes) == 0 {
+		return
+	}
+	// copy on write, since clones share the map
+	indexes := make(map[string]*iradix.Tree, len(d.indexes))
+	for path, index := range d.indexes {
+		if old != nil {
+			if indexed, ok := extract(path, *old); ok {
+				index, _, _ = index.Delete(indexKey(indexed, key))
+			}
+		}
+		if new != nil {
+			if indexed, ok := extract(path, *new); ok {
+				index, _, _ = index.Insert(indexKey(indexed, key), nil)
+			}
+		}
+		indexes[path] = index
+	}
+	d.indexes = indexes
+}
+
+// CreateIndex builds an index on path over all existing values, and keeps it
+// updated on later writes. Creating an index that already exists has no effect
+func (d *Database) CreateIndex(path string) error {
+	if err := ValidatePath(path); err != nil {
+		return err
+	}
+	if _, ok := d.indexes[path]; ok {
+		return nil
+	}
+	txn := iradix.New().Txn()
+	cursor := d.Cursor("")
+	for key, value, ok := cursor.Next(); ok; key, value, ok = cursor.Next() {
+		if indexed, ok := extract(path, value); ok {
+			txn.Insert(indexKey(indexed, key), nil)
+		}
+	}
+
+	indexes := make(map[string]*iradix.Tree, len(d.indexes)+1)
+	for p, index := range d.indexes {
+		indexes[p] = index
+	}
+	indexes[path] = txn.Commit()
+	d.indexes = indexes
+	return nil
+}
+
+// DropIndex removes the index on path, if there is one
+func (d *Database) DropIndex(path string) {
+	if _, ok := d.indexes[path]; !ok {
+		return
+	}
+	indexes := make(map[string]*iradix.Tree, len(d.indexes))
+	for p, index := range d.indexes {
+		if p != path {
+			indexes[p] = index
+		}
+	}
+	d.indexes = indexes
+}
+
+// Indexes returns the sorted list of indexed paths
+func (d *Database) Indexes() []string {
+	paths := make([]string, 0, len(d.indexes))
+	for path := range d.indexes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Find returns the sorted list of keys whose value has the specified value (in
+// canonical JSON encoding, see `ParseQuery`) at an indexed path
+func (d *Database) Find(path string, value string) ([]string, error) {
+	index, ok := d.indexes[path]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+	prefix := []byte(value + "\x00")
+	keys := []string{}
+	index.Root().WalkPrefix(prefix, func(k []byte, _ interface{}) bool {
+		keys = append(keys, string(k[len(prefix):]))
+		return false
+	})
+	return keys, nil
+}
@@ -0,0 +1,111 @@
+// +build unit
+
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	testCases := []struct {
+		query string
+		path  string
+		value string
+		err   error
+	}{
+		{`$.status == "active"`, "$.status", `"active"`, nil},
+		{`$.a.b==1.0`, "$.a.b", `1`, nil},
+		{`$.done == true`, "$.done", `true`, nil},
+		{`$.gone == null`, "$.gone", `null`, nil},
+		{`status == "active"`, "", "", ErrInvalidPath},
+		{`$.status = "active"`, "", "", ErrInvalidQuery},
+		{`$.status == active`, "", "", ErrInvalidQuery},
+		{`$.status == {"a": 1}`, "", "", ErrInvalidQuery}}
+
+	for _, tc := range testCases {
+		path, value, err := ParseQuery(tc.query)
+		if err != tc.err || path != tc.path || value != tc.value {
+			t.Errorf(
+				"[%s] Expected (%s, %s, %v), got (%s, %s, %v)\n",
+				tc.query, tc.path, tc.value, tc.err, path, value, err)
+		}
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	d := NewDatabase()
+	d.Set("u1", `{"status": "active", "profile": {"age": 30}}`)
+	d.Set("u2", `{"status": "inactive"}`)
+	d.Set("u3", "not json")
+
+	if _, err := d.Find("$.status", `"active"`); err != ErrNoIndex {
+		t.Errorf("Expected ErrNoIndex before creating index, got %v\n", err)
+	}
+
+	// index is built over existing values
+	if err := d.CreateIndex("$.status"); err != nil {
+		t.Fatalf("Error creating index: %v\n", err)
+	}
+	d.CreateIndex("$.profile.age")
+	check := func(name string, path string, value string, expected []string) {
+		keys, err := d.Find(path, value)
+		if err != nil {
+			t.Errorf("[%s] Error in Find: %v\n", name, err)
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("[%s] Expected %v, got %v\n", name, expected, keys)
+		}
+	}
+	check("existing", "$.status", `"active"`, []string{"u1"})
+	check("nested", "$.profile.age", `30`, []string{"u1"})
+
+	// and maintained through writes and deletes
+	d.Set("u4", `{"status": "active"}`)
+	d.Set("u1", `{"status": "inactive"}`)
+	check("after writes", "$.status", `"active"`, []string{"u4"})
+	check("after writes", "$.status", `"inactive"`, []string{"u1", "u2"})
+	check("after writes", "$.profile.age", `30`, []string{})
+	d.Delete("u2")
+	check("after delete", "$.status", `"inactive"`, []string{"u1"})
+
+	// clones are unaffected by later writes
+	clone := Clone(d)
+	d.Set("u5", `{"status": "active"}`)
+	keys, _ := clone.Find("$.status", `"active"`)
+	if !reflect.DeepEqual(keys, []string{"u4"}) {
+		t.Errorf("Expected clone index to be unchanged, got %v\n", keys)
+	}
+
+	// indexes survive snapshots
+	snapshot, _ := BuildSnapshot(d)
+	restored, err := InstallSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v\n", err)
+	}
+	if !reflect.DeepEqual(restored.Indexes(), []string{"$.profile.age", "$.status"}) {
+		t.Errorf("Expected indexes to be restored, got %v\n", restored.Indexes())
+	}
+	keys, _ = restored.Find("$.status", `"active"`)
+	if !reflect.DeepEqual(keys, []string{"u4", "u5"}) {
+		t.Errorf("Expected restored index to be populated, got %v\n", keys)
+	}
+
+	d.DropIndex("$.status")
+	if _, err := d.Find("$.status", `"active"`); err != ErrNoIndex {
+		t.Errorf("Expected ErrNoIndex after dropping index, got %v\n", err)
+	}
+	if err := d.CreateIndex("status"); err != ErrInvalidPath {
+		t.Errorf("Expected ErrInvalidPath, got %v\n", err)
+	}
+}
+
+func TestInstallLegacySnapshot(t *testing.T) {
+	d, err := InstallSnapshot([]byte(`[{"K": "1", "V": "one"}]`))
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v\n", err)
+	}
+	if v := d.Get("1"); v != "one" {
+		t.Errorf("Expected 1=one, got %s\n", v)
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+// An advisory lock is a key whose value records the lock's owner, when it was
+// acquired, and when its lease runs out. The key is given the lease's expiry
+// time, so an abandoned lock expires like any other key. Locks are taken and
+// released by comparing the current holder when the entry is applied, so two
+// owners racing for the same lock can't both get it. Nothing stops a client
+// from touching the resource a lock guards without taking it: the lock only
+// means something to clients that agree to use it
+import (
+	"encoding/json"
+)
+
+// A Lock is the value of a lock key
+type Lock struct {
+	// Who holds the lock, as given by the client that took it
+	Owner string `json:"owner"`
+	// When the owner took the lock (renewals leave it alone), in Unix
+	// nanoseconds
+	AcquiredAt int64 `json:"acquiredAt"`
+	// Length of the lease taken or last renewed, in milliseconds
+	LeaseMs int64 `json:"leaseMs"`
+	// When the lease runs out, in Unix nanoseconds
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// LockAt returns the lock held on key as of now (Unix nanoseconds), or false if
+// it is not held (or its value is not a lock)
+func (d *Database) LockAt(key string, now int64) (Lock, bool) {
+	var lock Lock
+	value := d.valueAt(key, now)
+	if value == nil || json.Unmarshal([]byte(*value), &lock) != nil || lock.Owner == "" {
+		return Lock{}, false
+	}
+	return lock, true
+}
+
+// TryLock gives the lock on key to lock.Owner, if it is not held as of
+// lock.AcquiredAt (or its owner already holds it, in which case the lease is
+// renewed, keeping the original acquisition time). Returns the lock as it is
+// afterwards, and whether the owner holds it
+func (d *Database) TryLock(key string, lock Lock) (Lock, bool) {
+	current, held := d.LockAt(key, lock.AcquiredAt)
+	if held && current.Owner != lock.Owner {
+		return current, false
+	}
+	if held {
+		lock.AcquiredAt = current.AcquiredAt
+	}
+	value, _ := json.Marshal(lock)
+	d.Set(key, string(value))
+	d.Touch(key, lock.ExpiresAt)
+	return lock, true
+}
+
+// Unlock releases the lock on key if owner holds it as of now (Unix
+// nanoseconds), and returns whether it did
+func (d *Database) Unlock(key string, owner string, now int64) bool {
+	current, held := d.LockAt(key, now)
+	if !held || current.Owner != owner {
+		return false
+	}
+	d.Delete(key)
+	return true
+}
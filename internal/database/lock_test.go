@@ -0,0 +1,56 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	d := NewDatabase()
+	now := time.Now().UnixNano()
+	lease := int64(time.Minute)
+	lock := func(owner string, at int64) Lock {
+		return Lock{Owner: owner, AcquiredAt: at, LeaseMs: 60000, ExpiresAt: at + lease}
+	}
+
+	if _, held := d.LockAt("job", now); held {
+		t.Error("Expected a missing lock not to be held")
+	}
+	if got, ok := d.TryLock("job", lock("a", now)); !ok || got.Owner != "a" {
+		t.Fatalf("Expected a to take a free lock, got %+v (%v)", got, ok)
+	}
+	if at, ok := d.Expiry("job"); !ok || at != now+lease {
+		t.Errorf("Expected the key to expire with the lease at %d, got %d (%v)", now+lease, at, ok)
+	}
+
+	// another owner can't take it while the lease lasts, and is told who has it
+	if got, ok := d.TryLock("job", lock("b", now+1)); ok || got.Owner != "a" {
+		t.Errorf("Expected b to be refused a held lock, got %+v (%v)", got, ok)
+	}
+	if d.Unlock("job", "b", now+1) {
+		t.Error("Expected b not to release a lock held by a")
+	}
+
+	// the owner renews it, keeping its acquisition time
+	renewed, ok := d.TryLock("job", lock("a", now+2))
+	if !ok || renewed.AcquiredAt != now || renewed.ExpiresAt != now+2+lease {
+		t.Errorf("Expected a renewal keeping the acquisition time, got %+v (%v)", renewed, ok)
+	}
+
+	// once the lease runs out, anyone may take it
+	later := now + 2*lease + 1
+	if got, ok := d.TryLock("job", lock("b", later)); !ok || got.Owner != "b" || got.AcquiredAt != later {
+		t.Errorf("Expected b to take an expired lock, got %+v (%v)", got, ok)
+	}
+	if !d.Unlock("job", "b", later) || d.Exists("job") {
+		t.Error("Expected b to release its lock")
+	}
+
+	// a key that isn't a lock is never held
+	d.Set("plain", "value")
+	if _, held := d.LockAt("plain", now); held {
+		t.Error("Expected a key that isn't a lock not to be held")
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+// Reading part of a large value, or only its size, should not cost as much as
+// reading all of it. For a value written in chunks, only the chunks holding
+// the range are copied, and the size is the sum of the sizes of the chunks
+import (
+	"strings"
+	"time"
+)
+
+// Size returns the size in bytes of the value of key, and whether it has a
+// value that has not expired
+func (d *Database) Size(key string) (int, bool) {
+	r, ok := d.getValue(key)
+	if !ok || d.expired(key, time.Now().UnixNano()) {
+		return 0, false
+	}
+	switch value := r.(type) {
+	case string:
+		return len(value), true
+	case *chunkedValue:
+		size := 0
+		for _, chunkKey := range value.keys {
+			if chunk, ok := d.chunks.Get([]byte(chunkKey)); ok {
+				size += len(chunk.(string))
+			}
+		}
+		return size, true
+	}
+	return 0, false
+}
+
+// GetRange returns up to length bytes of the value of key, starting at offset
+// (through the end of the value if length is negative), along with the size
+// of the whole value and whether the key has a value that has not expired. A
+// range past the end of the value is empty
+func (d *Database) GetRange(key string, offset int, length int) (string, int, bool) {
+	r, ok := d.getValue(key)
+	if !ok || d.expired(key, time.Now().UnixNano()) {
+		return "", 0, false
+	}
+	switch value := r.(type) {
+	case string:
+		start, end := clampRange(offset, length, len(value))
+		return value[start:end], len(value), true
+	case *chunkedValue:
+		size, _ := d.Size(key)
+		start, end := clampRange(offset, length, size)
+		var b strings.Builder
+		pos := 0
+		for _, chunkKey := range value.keys {
+			if pos >= end {
+				break
+			}
+			chunk, ok := d.chunks.Get([]byte(chunkKey))
+			if !ok {
+				continue
+			}
+			data := chunk.(string)
+			if pos+len(data) > start {
+				from, to := start-pos, end-pos
+				if from < 0 {
+					from = 0
+				}
+				if to > len(data) {
+					to = len(data)
+				}
+				b.WriteString(data[from:to])
+			}
+			pos += len(data)
+		}
+		return b.String(), size, true
+	}
+	return "", 0, false
+}
+
+// clampRange returns the bounds within a value of size bytes of the range of
+// length bytes (or the rest of the value, if negative) starting at offset
+func clampRange(offset int, length int, size int) (int, int) {
+	start := offset
+	if start > size {
+		start = size
+	}
+	end := size
+	if length >= 0 && length < size-start {
+		end = start + length
+	}
+	return start, end
+}
@@ -0,0 +1,52 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetRange(t *testing.T) {
+	d := NewDatabase()
+	d.Set("plain", "0123456789")
+	keys := []string{ChunkKey("chunked", "1", 0), ChunkKey("chunked", "1", 1), ChunkKey("chunked", "1", 2)}
+	d.PutChunk(keys[0], []byte("0123"))
+	d.PutChunk(keys[1], []byte("4567"))
+	d.PutChunk(keys[2], []byte("89"))
+	d.SetChunked("chunked", keys)
+
+	cases := []struct {
+		offset   int
+		length   int
+		expected string
+	}{
+		{0, -1, "0123456789"},
+		{3, 4, "3456"},
+		{5, -1, "56789"},
+		{8, 10, "89"},
+		{4, 0, ""},
+		{10, 1, ""},
+		{20, -1, ""},
+	}
+	for _, key := range []string{"plain", "chunked"} {
+		if size, ok := d.Size(key); !ok || size != 10 {
+			t.Errorf("Expected %s to have 10 bytes, got %d (%v)", key, size, ok)
+		}
+		for _, c := range cases {
+			value, size, ok := d.GetRange(key, c.offset, c.length)
+			if !ok || size != 10 || value != c.expected {
+				t.Errorf("Expected %q for %s [%d, +%d), got %q of %d (%v)",
+					c.expected, key, c.offset, c.length, value, size, ok)
+			}
+		}
+	}
+
+	if _, ok := d.Size("missing"); ok {
+		t.Error("Expected no size for a missing key")
+	}
+	d.Touch("plain", time.Now().UnixNano())
+	if _, _, ok := d.GetRange("plain", 0, -1); ok {
+		t.Error("Expected nothing read from an expired key")
+	}
+}
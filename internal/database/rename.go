@@ -0,0 +1,40 @@
+package database
+
+// Renames and copies read a key and write another as one step, so no write
+// can come between them--which a client reading and then writing can't ensure.
+// The key written takes the value of the key read, and its expiry time (or no
+// expiry time, if that key does not expire), replacing any it had. Only string
+// values are moved; a chunked value is written to its new key as one string
+
+// Rename moves the value of from, and its expiry time, to to, unless to has a
+// value as of now (Unix nanoseconds) and replace is false. Returns whether
+// from had a value, and whether it was moved
+func (d *Database) Rename(from string, to string, replace bool, now int64) (bool, bool) {
+	found, copied := d.Copy(from, to, replace, now)
+	if copied && from != to {
+		d.Delete(from)
+	}
+	return found, copied
+}
+
+// Copy copies the value of from, and its expiry time, to to, unless to has a
+// value as of now (Unix nanoseconds) and replace is false. Returns whether
+// from had a value, and whether it was copied
+func (d *Database) Copy(from string, to string, replace bool, now int64) (bool, bool) {
+	value := d.valueAt(from, now)
+	if value == nil {
+		return false, false
+	}
+	if from == to {
+		return true, true
+	}
+	if !replace && d.valueAt(to, now) != nil {
+		return true, false
+	}
+	expiresAt, expires := d.Expiry(from)
+	d.Set(to, *value)
+	if expires {
+		d.Touch(to, expiresAt)
+	}
+	return true, true
+}
@@ -0,0 +1,76 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRename(t *testing.T) {
+	d := NewDatabase()
+	now := time.Now().UnixNano()
+	later := now + int64(time.Hour)
+
+	d.Set("a", "1")
+	d.Touch("a", later)
+	if found, renamed := d.Rename("a", "b", false, now); !found || !renamed {
+		t.Fatalf("Expected a to be renamed, got %v %v", found, renamed)
+	}
+	if d.Exists("a") || d.Get("b") != "1" {
+		t.Errorf("Expected only b=1, got a=%q b=%q", d.Get("a"), d.Get("b"))
+	}
+	if at, ok := d.Expiry("b"); !ok || at != later {
+		t.Errorf("Expected b to keep a's expiry %d, got %d (%v)", later, at, ok)
+	}
+	if _, ok := d.Expiry("a"); ok {
+		t.Error("Expected a's expiry to be removed with it")
+	}
+	if found, _ := d.Rename("a", "b", true, now); found {
+		t.Error("Expected nothing renamed from a missing key")
+	}
+
+	// the key written is only overwritten with replace, and loses its expiry
+	// if the key read has none
+	d.Set("c", "2")
+	if found, renamed := d.Rename("c", "b", false, now); !found || renamed {
+		t.Errorf("Expected c not to replace b, got %v %v", found, renamed)
+	}
+	if found, renamed := d.Rename("c", "b", true, now); !found || !renamed {
+		t.Errorf("Expected c to replace b, got %v %v", found, renamed)
+	}
+	if _, ok := d.Expiry("b"); ok || d.Get("b") != "2" {
+		t.Errorf("Expected b=2 without an expiry, got %q", d.Get("b"))
+	}
+	if found, renamed := d.Rename("b", "b", false, now); !found || !renamed || d.Get("b") != "2" {
+		t.Errorf("Expected renaming b to itself to leave it alone, got %v %v", found, renamed)
+	}
+
+	// an expired key can't be renamed
+	d.Touch("b", now)
+	if found, _ := d.Rename("b", "d", false, now); found {
+		t.Error("Expected an expired key not to be renamed")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	d := NewDatabase()
+	now := time.Now().UnixNano()
+	later := now + int64(time.Hour)
+
+	d.Set("a", "1")
+	d.Touch("a", later)
+	if found, copied := d.Copy("a", "b", false, now); !found || !copied {
+		t.Fatalf("Expected a to be copied, got %v %v", found, copied)
+	}
+	if d.Get("a") != "1" || d.Get("b") != "1" {
+		t.Errorf("Expected a=1 and b=1, got a=%q b=%q", d.Get("a"), d.Get("b"))
+	}
+	if at, ok := d.Expiry("b"); !ok || at != later {
+		t.Errorf("Expected b to take a's expiry %d, got %d (%v)", later, at, ok)
+	}
+	d.Set("a", "2")
+	if found, copied := d.Copy("a", "b", false, now); !found || copied || d.Get("b") != "1" {
+		t.Errorf("Expected b not to be overwritten without replace, got %v %v", found, copied)
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+// Sets are kept in a keyspace separate from string values, with each set
+// stored as an immutable tree of its members
+import (
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+func (d *Database) getSet(key string) *iradix.Tree {
+	s, ok := d.sets.Get([]byte(key))
+	if !ok {
+		return iradix.New()
+	}
+	return s.(*iradix.Tree)
+}
+
+// SAdd adds members to the set stored at key (creating it if needed), and
+// returns the number of members that were not already present
+func (d *Database) SAdd(key string, members []string) int {
+	txn := d.getSet(key).Txn()
+	added := 0
+	for _, member := range members {
+		if _, updated := txn.Insert([]byte(member), nil); !updated {
+			added++
+		}
+	}
+	if added > 0 {
+		d.sets, _, _ = d.sets.Insert([]byte(key), txn.Commit())
+	}
+	return added
+}
+
+// SRem removes members from the set stored at key, and removes the set when it
+// becomes empty. Returns the number of members removed
+func (d *Database) SRem(key string, members []string) int {
+	txn := d.getSet(key).Txn()
+	removed := 0
+	for _, member := range members {
+		if _, ok := txn.Delete([]byte(member)); ok {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	next := txn.Commit()
+	if next.Len() == 0 {
+		d.sets, _, _ = d.sets.Delete([]byte(key))
+	} else {
+		d.sets, _, _ = d.sets.Insert([]byte(key), next)
+	}
+	return removed
+}
+
+// SIsMember reports whether member is in the set stored at key
+func (d *Database) SIsMember(key string, member string) bool {
+	_, ok := d.getSet(key).Get([]byte(member))
+	return ok
+}
+
+// SMembers returns the members of the set stored at key, in sorted order
+func (d *Database) SMembers(key string) []string {
+	members := []string{}
+	d.getSet(key).Root().Walk(func(k []byte, _ interface{}) bool {
+		members = append(members, string(k))
+		return false
+	})
+	return members
+}
+
+// SCard returns the number of members in the set stored at key
+func (d *Database) SCard(key string) int {
+	return d.getSet(key).Len()
+}
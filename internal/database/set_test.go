@@ -0,0 +1,49 @@
+// +build unit
+
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	d := NewDatabase()
+	if added := d.SAdd("team", []string{"carol", "alice", "bob", "alice"}); added != 3 {
+		t.Errorf("Expected 3 members added, got %d\n", added)
+	}
+	if added := d.SAdd("team", []string{"alice"}); added != 0 {
+		t.Errorf("Expected 0 members added for existing member, got %d\n", added)
+	}
+	if !d.SIsMember("team", "bob") || d.SIsMember("team", "dave") {
+		t.Error("Incorrect membership for bob or dave")
+	}
+	expected := []string{"alice", "bob", "carol"}
+	if members := d.SMembers("team"); !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, members)
+	}
+
+	clone := Clone(d)
+	if removed := d.SRem("team", []string{"bob", "dave"}); removed != 1 {
+		t.Errorf("Expected 1 member removed, got %d\n", removed)
+	}
+	if clone.SCard("team") != 3 || d.SCard("team") != 2 {
+		t.Errorf(
+			"Expected clone to keep 3 members and source to have 2, got %d and %d\n",
+			clone.SCard("team"), d.SCard("team"))
+	}
+
+	snapshot, _ := BuildSnapshot(d)
+	restored, err := InstallSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v\n", err)
+	}
+	if members := restored.SMembers("team"); !reflect.DeepEqual(members, []string{"alice", "carol"}) {
+		t.Errorf("Expected restored set to match, got %v\n", members)
+	}
+
+	d.SRem("team", []string{"alice", "carol"})
+	if d.SCard("team") != 0 {
+		t.Errorf("Expected empty set, got %d members\n", d.SCard("team"))
+	}
+}
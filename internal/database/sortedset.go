@@ -0,0 +1,172 @@
+package database
+
+// Sorted sets are kept in a keyspace separate from string values. Each set is
+// stored as a pair of immutable trees--one from member to score, and one
+// ordered by score then member--so that clones of the database (such as those
+// used for snapshots) are not affected by later writes
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// ErrInvalidScore indicates that a sorted set score is NaN or infinite (which
+// can't be ordered or serialized as JSON, respectively)
+var ErrInvalidScore = errors.New("Sorted set scores must be finite numbers")
+
+// A ScoredMember is a member of a sorted set along with its score
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+type sortedSet struct {
+	scores  *iradix.Tree
+	byScore *iradix.Tree
+}
+
+// encodeScore converts a score to bytes that sort in the same order as the
+// scores do numerically
+func encodeScore(score float64) []byte {
+	if score == 0 {
+		// treat -0 and 0 as the same score
+		score = 0
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, bits)
+	return encoded
+}
+
+// decodeScore reverses encodeScore
+func decodeScore(encoded []byte) float64 {
+	bits := binary.BigEndian.Uint64(encoded)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// scoreKey builds the key for a member in the tree ordered by score--the score
+// is always 8 bytes, so no separator is needed
+func scoreKey(score float64, member string) []byte {
+	return append(encodeScore(score), member...)
+}
+
+// validScore reports whether a score can be stored in a sorted set
+func validScore(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+// ValidateScores returns an error if any score is NaN or infinite
+func ValidateScores(members []ScoredMember) error {
+	for _, m := range members {
+		if !validScore(m.Score) {
+			return ErrInvalidScore
+		}
+	}
+	return nil
+}
+
+func (d *Database) getSortedSet(key string) *sortedSet {
+	s, ok := d.sortedSets.Get([]byte(key))
+	if !ok {
+		return &sortedSet{scores: iradix.New(), byScore: iradix.New()}
+	}
+	return s.(*sortedSet)
+}
+
+// ZAdd adds members to the sorted set stored at key (creating it if needed),
+// or updates the scores of members that are already present. Returns the
+// number of members that were newly added
+func (d *Database) ZAdd(key string, members []ScoredMember) int {
+	s := d.getSortedSet(key)
+	scores := s.scores.Txn()
+	byScore := s.byScore.Txn()
+	added := 0
+	for _, m := range members {
+		if !validScore(m.Score) {
+			continue
+		}
+		if old, ok := scores.Get([]byte(m.Member)); ok {
+			byScore.Delete(scoreKey(old.(float64), m.Member))
+		} else {
+			added++
+		}
+		scores.Insert([]byte(m.Member), m.Score)
+		byScore.Insert(scoreKey(m.Score, m.Member), nil)
+	}
+	d.sortedSets, _, _ = d.sortedSets.Insert(
+		[]byte(key), &sortedSet{scores: scores.Commit(), byScore: byScore.Commit()})
+	return added
+}
+
+// ZRem removes members from the sorted set stored at key, and removes the
+// set when it becomes empty. Returns the number of members removed
+func (d *Database) ZRem(key string, members []string) int {
+	s := d.getSortedSet(key)
+	scores := s.scores.Txn()
+	byScore := s.byScore.Txn()
+	removed := 0
+	for _, member := range members {
+		if old, ok := scores.Delete([]byte(member)); ok {
+			byScore.Delete(scoreKey(old.(float64), member))
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	next := &sortedSet{scores: scores.Commit(), byScore: byScore.Commit()}
+	if next.scores.Len() == 0 {
+		d.sortedSets, _, _ = d.sortedSets.Delete([]byte(key))
+	} else {
+		d.sortedSets, _, _ = d.sortedSets.Insert([]byte(key), next)
+	}
+	return removed
+}
+
+// ZScore returns the score of a member of the sorted set stored at key, or
+// false if it is not a member
+func (d *Database) ZScore(key string, member string) (float64, bool) {
+	score, ok := d.getSortedSet(key).scores.Get([]byte(member))
+	if !ok {
+		return 0, false
+	}
+	return score.(float64), true
+}
+
+// ZCard returns the number of members in the sorted set stored at key
+func (d *Database) ZCard(key string) int {
+	return d.getSortedSet(key).scores.Len()
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key with
+// scores between min and max (inclusive), ordered by score and then by member.
+// If limit is greater than 0, at most limit members are returned
+func (d *Database) ZRangeByScore(
+	key string, min float64, max float64, limit int) []ScoredMember {
+	members := []ScoredMember{}
+	lower := encodeScore(min)
+	d.getSortedSet(key).byScore.Root().Walk(func(k []byte, _ interface{}) bool {
+		if string(k[:8]) < string(lower) {
+			return false
+		}
+		score := decodeScore(k[:8])
+		if score > max {
+			return true
+		}
+		members = append(members, ScoredMember{Member: string(k[8:]), Score: score})
+		return limit > 0 && len(members) >= limit
+	})
+	return members
+}
@@ -0,0 +1,96 @@
+// +build unit
+
+package database
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestScoreEncoding(t *testing.T) {
+	scores := []float64{
+		-math.MaxFloat64, -1e10, -1.5, -1, -math.SmallestNonzeroFloat64, 0,
+		math.SmallestNonzeroFloat64, 0.5, 1, 2, 1e10, math.MaxFloat64}
+	for i, score := range scores {
+		if decoded := decodeScore(encodeScore(score)); decoded != score {
+			t.Errorf("Expected %v to round trip, got %v\n", score, decoded)
+		}
+		if i > 0 && string(encodeScore(scores[i-1])) >= string(encodeScore(score)) {
+			t.Errorf("Expected encoding of %v to sort before %v\n", scores[i-1], score)
+		}
+	}
+	if string(encodeScore(math.Copysign(0, -1))) != string(encodeScore(0)) {
+		t.Error("Expected -0 and 0 to have the same encoding")
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	d := NewDatabase()
+	added := d.ZAdd("board", []ScoredMember{
+		{Member: "carol", Score: 30},
+		{Member: "alice", Score: 10},
+		{Member: "bob", Score: 20},
+		{Member: "dave", Score: 20},
+		{Member: "erin", Score: -5}})
+	if added != 5 {
+		t.Errorf("Expected 5 members added, got %d\n", added)
+	}
+
+	// updating a score moves the member, and does not count as added
+	if added := d.ZAdd("board", []ScoredMember{{Member: "alice", Score: 25}}); added != 0 {
+		t.Errorf("Expected 0 members added on update, got %d\n", added)
+	}
+	if score, ok := d.ZScore("board", "alice"); !ok || score != 25 {
+		t.Errorf("Expected alice=25, got %v (%v)\n", score, ok)
+	}
+
+	all := d.ZRangeByScore("board", math.Inf(-1), math.Inf(1), 0)
+	expected := []ScoredMember{
+		{Member: "erin", Score: -5},
+		{Member: "bob", Score: 20},
+		{Member: "dave", Score: 20},
+		{Member: "alice", Score: 25},
+		{Member: "carol", Score: 30}}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, all)
+	}
+
+	some := d.ZRangeByScore("board", 0, 25, 0)
+	if !reflect.DeepEqual(some, expected[1:4]) {
+		t.Errorf("Expected %v, got %v\n", expected[1:4], some)
+	}
+	limited := d.ZRangeByScore("board", 0, 25, 2)
+	if !reflect.DeepEqual(limited, expected[1:3]) {
+		t.Errorf("Expected %v, got %v\n", expected[1:3], limited)
+	}
+
+	// clones and snapshots are consistent
+	clone := Clone(d)
+	if removed := d.ZRem("board", []string{"bob", "nobody"}); removed != 1 {
+		t.Errorf("Expected 1 member removed, got %d\n", removed)
+	}
+	if clone.ZCard("board") != 5 || d.ZCard("board") != 4 {
+		t.Errorf(
+			"Expected clone to keep 5 members and source to have 4, got %d and %d\n",
+			clone.ZCard("board"), d.ZCard("board"))
+	}
+	snapshot, _ := BuildSnapshot(d)
+	restored, err := InstallSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v\n", err)
+	}
+	restoredAll := restored.ZRangeByScore("board", math.Inf(-1), math.Inf(1), 0)
+	if !reflect.DeepEqual(restoredAll, d.ZRangeByScore("board", math.Inf(-1), math.Inf(1), 0)) {
+		t.Errorf("Expected restored sorted set to match, got %v\n", restoredAll)
+	}
+
+	// removing all members removes the set
+	d.ZRem("board", []string{"alice", "carol", "dave", "erin"})
+	if d.ZCard("board") != 0 {
+		t.Errorf("Expected empty set, got %d members\n", d.ZCard("board"))
+	}
+	if err := ValidateScores([]ScoredMember{{Member: "x", Score: math.NaN()}}); err != ErrInvalidScore {
+		t.Errorf("Expected ErrInvalidScore, got %v\n", err)
+	}
+}
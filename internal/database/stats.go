@@ -0,0 +1,96 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A KeySample describes a key chosen at random from the database
+type KeySample struct {
+	Key string `json:"key"`
+	// Size is the number of bytes in the key and value
+	Size int `json:"size"`
+	// ExpiresAt is the expiry time of the key in Unix nanoseconds, or 0 if the
+	// key does not expire
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// PrefixStats aggregates the keys sharing a prefix
+type PrefixStats struct {
+	Prefix   string `json:"prefix"`
+	Keys     int    `json:"keys"`
+	Bytes    int    `json:"bytes"`
+	Expiring int    `json:"expiring"`
+}
+
+// Sample returns up to n keys chosen uniformly at random, in key order. This
+// visits every key, so it is intended for occasional use by operators
+func (d *Database) Sample(n int, rng *rand.Rand) []KeySample {
+	samples := []KeySample{}
+	if n <= 0 {
+		return samples
+	}
+	now := time.Now().UnixNano()
+	seen := 0
+	cursor := d.Cursor("")
+	for key, value, ok := cursor.Next(); ok; key, value, ok = cursor.Next() {
+		if d.expired(key, now) {
+			continue
+		}
+		sample := KeySample{Key: key, Size: len(key) + len(value)}
+		sample.ExpiresAt, _ = d.Expiry(key)
+		// reservoir sampling: the i-th key replaces a sample with probability n/i
+		seen++
+		if len(samples) < n {
+			samples = append(samples, sample)
+		} else if i := rng.Intn(seen); i < n {
+			samples[i] = sample
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Key < samples[j].Key
+	})
+	return samples
+}
+
+// Stats groups keys by prefix--the part of the key up to and including the
+// first delimiter (keys without the delimiter are grouped under the empty
+// prefix)--and returns the groups ordered from most to fewest bytes
+func (d *Database) Stats(delimiter string) []PrefixStats {
+	groups := map[string]*PrefixStats{}
+	now := time.Now().UnixNano()
+	cursor := d.Cursor("")
+	for key, value, ok := cursor.Next(); ok; key, value, ok = cursor.Next() {
+		if d.expired(key, now) {
+			continue
+		}
+		prefix := ""
+		if i := strings.Index(key, delimiter); delimiter != "" && i >= 0 {
+			prefix = key[:i+len(delimiter)]
+		}
+		group, ok := groups[prefix]
+		if !ok {
+			group = &PrefixStats{Prefix: prefix}
+			groups[prefix] = group
+		}
+		group.Keys++
+		group.Bytes += len(key) + len(value)
+		if _, expires := d.Expiry(key); expires {
+			group.Expiring++
+		}
+	}
+
+	stats := make([]PrefixStats, 0, len(groups))
+	for _, group := range groups {
+		stats = append(stats, *group)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Prefix < stats[j].Prefix
+	})
+	return stats
+}
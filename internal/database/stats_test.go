@@ -0,0 +1,65 @@
+// +build unit
+
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSample(t *testing.T) {
+	d := NewDatabase()
+	for i := 0; i < 100; i++ {
+		d.Set(fmt.Sprintf("key%03d", i), "value")
+	}
+	future := time.Now().Add(time.Minute).UnixNano()
+	d.Touch("key000", future)
+
+	rng := rand.New(rand.NewSource(1))
+	samples := d.Sample(10, rng)
+	if len(samples) != 10 {
+		t.Fatalf("Expected 10 samples, got %d\n", len(samples))
+	}
+	seen := map[string]bool{}
+	for i, s := range samples {
+		if seen[s.Key] {
+			t.Errorf("Duplicate sample %s\n", s.Key)
+		}
+		seen[s.Key] = true
+		if s.Size != len(s.Key)+len("value") {
+			t.Errorf("Expected size %d for %s, got %d\n", len(s.Key)+5, s.Key, s.Size)
+		}
+		if i > 0 && samples[i-1].Key >= s.Key {
+			t.Errorf("Expected samples in key order, got %v\n", samples)
+		}
+	}
+
+	// every key is returned when sampling more than there are
+	all := d.Sample(1000, rng)
+	if len(all) != 100 {
+		t.Errorf("Expected all 100 keys, got %d\n", len(all))
+	}
+	if all[0].ExpiresAt != future {
+		t.Errorf("Expected expiry %d for key000, got %d\n", future, all[0].ExpiresAt)
+	}
+}
+
+func TestStats(t *testing.T) {
+	d := NewDatabase()
+	d.Set("user:1", "alice")
+	d.Set("user:2", "bob")
+	d.Set("session:abc", "a-much-longer-value")
+	d.Set("plain", "x")
+	d.Touch("user:1", time.Now().Add(time.Minute).UnixNano())
+
+	expected := []PrefixStats{
+		{Prefix: "session:", Keys: 1, Bytes: 30},
+		{Prefix: "user:", Keys: 2, Bytes: 20, Expiring: 1},
+		{Prefix: "", Keys: 1, Bytes: 6}}
+	if stats := d.Stats(":"); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected %+v, got %+v\n", expected, stats)
+	}
+}
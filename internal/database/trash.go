@@ -0,0 +1,35 @@
+package database
+
+// A trashed value is moved, when its key is deleted, to a key of its own that
+// expires after a restore window, and can be moved back until then. The key
+// holding the trashed value is chosen by the caller (see node.TrashKey)
+
+// Trash moves the value of key to trashKey, expiring at expiresAt, and deletes
+// key. Keys are treated as expired as of now (Unix nanoseconds). Returns
+// whether key had a value to move
+func (d *Database) Trash(key string, trashKey string, now int64, expiresAt int64) bool {
+	value := d.valueAt(key, now)
+	d.Delete(key)
+	if value == nil {
+		return false
+	}
+	d.Set(trashKey, *value)
+	d.Touch(trashKey, expiresAt)
+	return true
+}
+
+// Restore moves the value in trashKey back to key, unless key has a value as
+// of now (Unix nanoseconds). Returns whether trashKey had a value, and whether
+// it was restored
+func (d *Database) Restore(trashKey string, key string, now int64) (bool, bool) {
+	value := d.valueAt(trashKey, now)
+	if value == nil {
+		return false, false
+	}
+	if d.valueAt(key, now) != nil {
+		return true, false
+	}
+	d.Set(key, *value)
+	d.Delete(trashKey)
+	return true, true
+}
@@ -0,0 +1,51 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	d := NewDatabase()
+	now := time.Now().UnixNano()
+	window := int64(time.Hour)
+
+	d.Set("a", "1")
+	if !d.Trash("a", "trash/a", now, now+window) {
+		t.Fatal("Expected a's value to be trashed")
+	}
+	if d.Exists("a") || d.Get("trash/a") != "1" {
+		t.Errorf("Expected a deleted and its value in the trash, got a=%q trash=%q",
+			d.Get("a"), d.Get("trash/a"))
+	}
+	if at, ok := d.Expiry("trash/a"); !ok || at != now+window {
+		t.Errorf("Expected the trashed value to expire at %d, got %d (%v)", now+window, at, ok)
+	}
+	if d.Trash("missing", "trash/missing", now, now+window) || d.Exists("trash/missing") {
+		t.Error("Expected nothing trashed for a missing key")
+	}
+
+	// a key written again since it was deleted is not overwritten
+	d.Set("a", "2")
+	if found, restored := d.Restore("trash/a", "a", now+1); !found || restored {
+		t.Errorf("Expected a not to be restored over a new value, got %v %v", found, restored)
+	}
+	d.Delete("a")
+	if found, restored := d.Restore("trash/a", "a", now+1); !found || !restored {
+		t.Errorf("Expected a to be restored, got %v %v", found, restored)
+	}
+	if d.Get("a") != "1" || d.Exists("trash/a") {
+		t.Errorf("Expected a=1 and the trash emptied, got a=%q trash=%q", d.Get("a"), d.Get("trash/a"))
+	}
+	if _, ok := d.Expiry("a"); ok {
+		t.Error("Expected the restored key not to expire")
+	}
+
+	// nothing can be restored once the window has passed
+	d.Trash("a", "trash/a", now, now+window)
+	if found, _ := d.Restore("trash/a", "a", now+window); found {
+		t.Error("Expected an expired value not to be found in the trash")
+	}
+}
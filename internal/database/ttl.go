@@ -0,0 +1,70 @@
+package database
+
+// Keys with string values may be given an expiry time. Expiry times are Unix
+// times in nanoseconds chosen by the leader when an entry is proposed, so that
+// every replica records the same expiry. An expired key reads as absent, and is
+// removed when the leader replicates an expire entry for it
+import (
+	"time"
+)
+
+// expired reports whether key has an expiry time at or before now
+func (d *Database) expired(key string, now int64) bool {
+	at, ok := d.getExpiry(key)
+	return ok && at.(int64) <= now
+}
+
+// Exists reports whether key has a value that has not expired
+func (d *Database) Exists(key string) bool {
+	_, ok := d.getValue(key)
+	return ok && !d.expired(key, time.Now().UnixNano())
+}
+
+// Touch sets the expiry time of key, if it has a value. Returns whether the
+// key exists
+func (d *Database) Touch(key string, expiresAt int64) bool {
+	if _, ok := d.getValue(key); !ok {
+		return false
+	}
+	d.setExpiry(key, expiresAt)
+	return true
+}
+
+// Persist removes the expiry time of key, so that it no longer expires.
+// Returns whether the key had an expiry time
+func (d *Database) Persist(key string) bool {
+	return d.clearExpiry(key)
+}
+
+// Expiry returns the expiry time of key, or false if it does not expire
+func (d *Database) Expiry(key string) (int64, bool) {
+	at, ok := d.getExpiry(key)
+	if !ok {
+		return 0, false
+	}
+	return at.(int64), true
+}
+
+// Expire deletes key if its expiry time is at or before now. A key that was
+// touched again after the leader decided to expire it is left in place.
+// Returns whether the key was deleted
+func (d *Database) Expire(key string, now int64) bool {
+	if !d.expired(key, now) {
+		return false
+	}
+	d.Delete(key)
+	return true
+}
+
+// ExpiredKeys returns the sorted list of keys with an expiry time at or before
+// now
+func (d *Database) ExpiredKeys(now int64) []string {
+	keys := []string{}
+	d.expiries.Root().Walk(func(k []byte, at interface{}) bool {
+		if at.(int64) <= now {
+			keys = append(keys, string(k))
+		}
+		return false
+	})
+	return keys
+}
@@ -0,0 +1,72 @@
+// +build unit
+
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiry(t *testing.T) {
+	d := NewDatabase()
+	now := time.Now().UnixNano()
+	past := now - int64(time.Minute)
+	future := now + int64(time.Minute)
+
+	if d.Touch("missing", future) {
+		t.Error("Expected touch of missing key to report it does not exist")
+	}
+
+	d.Set("session", "abc")
+	d.Set("stale", "xyz")
+	d.Touch("session", future)
+	d.Touch("stale", past)
+
+	if d.Get("session") != "abc" || !d.Exists("session") {
+		t.Error("Expected unexpired key to be readable")
+	}
+	if d.Get("stale") != "" || d.Exists("stale") {
+		t.Error("Expected expired key to read as absent")
+	}
+	if keys := d.ExpiredKeys(now); !reflect.DeepEqual(keys, []string{"stale"}) {
+		t.Errorf("Expected [stale] to be expired, got %v\n", keys)
+	}
+
+	// expiry survives snapshots
+	snapshot, _ := BuildSnapshot(d)
+	restored, err := InstallSnapshot(snapshot)
+	if err != nil {
+		t.Fatalf("Error installing snapshot: %v\n", err)
+	}
+	if at, ok := restored.Expiry("session"); !ok || at != future {
+		t.Errorf("Expected restored expiry %d, got %d (%v)\n", future, at, ok)
+	}
+
+	// a key touched after the expiry decision is kept
+	d.Touch("stale", future)
+	if d.Expire("stale", now) {
+		t.Error("Expected touched key not to be expired")
+	}
+	d.Touch("stale", past)
+	if !d.Expire("stale", now) {
+		t.Error("Expected expired key to be deleted")
+	}
+	if _, ok := d.Expiry("stale"); ok {
+		t.Error("Expected expiry to be removed along with key")
+	}
+
+	if !d.Persist("session") {
+		t.Error("Expected persist to report that the key had an expiry")
+	}
+	if _, ok := d.Expiry("session"); ok {
+		t.Error("Expected no expiry after persist")
+	}
+
+	// writing a value clears its expiry
+	d.Touch("session", future)
+	d.Set("session", "def")
+	if _, ok := d.Expiry("session"); ok {
+		t.Error("Expected no expiry after set")
+	}
+}
@@ -0,0 +1,58 @@
+package database
+
+// A transaction checks a list of conditions on the current values of keys, and
+// then applies one of two lists of set and delete operations, depending on
+// whether every condition holds, as a single update
+import (
+	"errors"
+)
+
+// ErrInvalidTxn indicates that a transaction has no operations, or has an
+// operation that is not a set or a delete
+var ErrInvalidTxn = errors.New("Transaction operations must be set or delete, and there must be at least one")
+
+// A Compare is a condition of a transaction: the key has the value Value, or
+// if Value is nil, the key does not exist
+type Compare struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+}
+
+// ValidateTxn returns ErrInvalidTxn unless the operations in then and
+// otherwise are all set or delete operations, and there is at least one
+func ValidateTxn(then []BatchOp, otherwise []BatchOp) error {
+	if len(then)+len(otherwise) == 0 {
+		return ErrInvalidTxn
+	}
+	for _, op := range append(append([]BatchOp{}, then...), otherwise...) {
+		if op.Op != OpSet && op.Op != OpDelete {
+			return ErrInvalidTxn
+		}
+	}
+	return nil
+}
+
+// ApplyTxn applies the operations in then if every condition in compares
+// holds, or those in otherwise if any does not, and returns whether they all
+// held. Keys are treated as expired based on now (Unix nanoseconds), as for
+// ApplyBatch
+func (d *Database) ApplyTxn(
+	compares []Compare,
+	then []BatchOp,
+	otherwise []BatchOp,
+	now int64) bool {
+
+	succeeded := true
+	for _, compare := range compares {
+		if !sameValue(d.valueAt(compare.Key, now), compare.Value) {
+			succeeded = false
+			break
+		}
+	}
+	if succeeded {
+		d.ApplyBatch(then, false, now)
+	} else {
+		d.ApplyBatch(otherwise, false, now)
+	}
+	return succeeded
+}
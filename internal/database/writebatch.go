@@ -0,0 +1,101 @@
+package database
+
+// A write batch applies many changes to keys' values and expiry times as one
+// transaction on each of the trees holding them, rather than building a new
+// version of the tree for every change: nodes copied for one change are
+// modified in place by the changes after it, until the batch is committed.
+// Reads of single keys (Get, Exists, Expiry) see the changes made so far in
+// the batch; reads of many keys (Len, Cursor, and the like) and snapshots do
+// not see them until the batch is committed. The transactions are not safe
+// for concurrent use, so Update applies a batch to a Clone that no one else is
+// reading, then copies the result back into the original.
+
+// BeginBatch opens a write batch (doing nothing if one is already open)
+func (d *Database) BeginBatch() {
+	if d.values != nil {
+		return
+	}
+	d.values = d.underlying.Txn()
+	d.expiring = d.expiries.Txn()
+}
+
+// CommitBatch commits the open write batch, if any
+func (d *Database) CommitBatch() {
+	if d.values == nil {
+		return
+	}
+	d.underlying = d.values.Commit()
+	d.expiries = d.expiring.Commit()
+	d.values, d.expiring = nil, nil
+}
+
+// Update applies fn to a write batch on a clone of the database, and then
+// makes all of its changes visible in the database at once. Reads of the
+// database while fn runs see none of the changes
+func (d *Database) Update(fn func(batch *Database)) {
+	batch := Clone(d)
+	batch.BeginBatch()
+	fn(batch)
+	batch.CommitBatch()
+	*d = *batch
+}
+
+// getValue returns the value stored for key
+func (d *Database) getValue(key string) (interface{}, bool) {
+	if d.values != nil {
+		return d.values.Get([]byte(key))
+	}
+	return d.underlying.Get([]byte(key))
+}
+
+// insertValue stores a value for key, and returns the previous value (nil if
+// there was none)
+func (d *Database) insertValue(key string, value interface{}) interface{} {
+	if d.values != nil {
+		prev, _ := d.values.Insert([]byte(key), value)
+		return prev
+	}
+	var prev interface{}
+	d.underlying, prev, _ = d.underlying.Insert([]byte(key), value)
+	return prev
+}
+
+// deleteValue removes the value for key, and returns it (nil if there was
+// none)
+func (d *Database) deleteValue(key string) interface{} {
+	if d.values != nil {
+		prev, _ := d.values.Delete([]byte(key))
+		return prev
+	}
+	var prev interface{}
+	d.underlying, prev, _ = d.underlying.Delete([]byte(key))
+	return prev
+}
+
+// getExpiry returns the expiry time of key
+func (d *Database) getExpiry(key string) (interface{}, bool) {
+	if d.expiring != nil {
+		return d.expiring.Get([]byte(key))
+	}
+	return d.expiries.Get([]byte(key))
+}
+
+// setExpiry sets the expiry time of key
+func (d *Database) setExpiry(key string, expiresAt int64) {
+	if d.expiring != nil {
+		d.expiring.Insert([]byte(key), expiresAt)
+		return
+	}
+	d.expiries, _, _ = d.expiries.Insert([]byte(key), expiresAt)
+}
+
+// clearExpiry removes the expiry time of key, and returns whether it had one
+func (d *Database) clearExpiry(key string) bool {
+	if d.expiring != nil {
+		_, ok := d.expiring.Delete([]byte(key))
+		return ok
+	}
+	var ok bool
+	d.expiries, _, ok = d.expiries.Delete([]byte(key))
+	return ok
+}
@@ -0,0 +1,55 @@
+// +build unit
+
+package database
+
+import (
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	d := NewDatabase()
+	d.Set("kept", "1")
+	d.Set("gone", "2")
+	original := Clone(d)
+
+	d.BeginBatch()
+	d.Set("new", "3")
+	d.Delete("gone")
+	d.Touch("kept", 10)
+	if d.Get("new") != "3" || d.Exists("gone") {
+		t.Error("Expected reads of single keys to see changes in the batch")
+	}
+	if at, ok := d.Expiry("kept"); !ok || at != 10 {
+		t.Errorf("Expected expiry in the batch to be visible, got %d", at)
+	}
+	if d.Len() != 2 {
+		t.Errorf("Expected bulk reads not to see the batch before commit, got %d keys", d.Len())
+	}
+	d.Persist("kept")
+	d.CommitBatch()
+
+	if d.Len() != 2 || d.Get("new") != "3" || d.Get("gone") != "" {
+		t.Errorf("Expected committed batch to replace gone with new, got %d keys", d.Len())
+	}
+	if _, ok := d.Expiry("kept"); ok {
+		t.Error("Expected expiry cleared in the batch to stay cleared")
+	}
+	if original.Get("gone") != "2" || original.Get("new") != "" {
+		t.Error("Expected a clone taken before the batch to be unchanged")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	d := NewDatabase()
+	d.Set("a", "1")
+	d.Update(func(batch *Database) {
+		batch.Set("b", "2")
+		batch.Delete("a")
+		if d.Get("a") != "1" || d.Get("b") != "" {
+			t.Error("Expected the database not to see the batch before it is committed")
+		}
+	})
+	if d.Get("a") != "" || d.Get("b") != "2" {
+		t.Error("Expected the database to see the committed batch")
+	}
+}
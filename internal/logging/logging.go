@@ -0,0 +1,239 @@
+package logging
+
+// Each subsystem logs through its own Logger, whose level can be set
+// separately (falling back to a default level), so that debugging one area
+// doesn't flood the log with messages from the others. Trace and debug
+// messages, some of which are logged for every append, can also be sampled.
+// Messages are written by zerolog's global logger, and are tagged with the
+// subsystem that logged them.
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Subsystems that have their own log level
+const (
+	// Raft is replication, elections, and membership
+	Raft = "raft"
+	// Storage is snapshots, log compaction, and key expiry
+	Storage = "storage"
+	// API is the client HTTP interface
+	API = "api"
+	// Watch is watches of changes to keys
+	Watch = "watch"
+)
+
+// inherit is the level of a subsystem that uses the default level
+const inherit = math.MinInt32
+
+var (
+	// ErrUnknownSubsystem indicates that a configuration named a subsystem
+	// that does not exist
+	ErrUnknownSubsystem = errors.New("Unknown log subsystem")
+
+	// ErrInvalidLevel indicates that a configuration named a level that does
+	// not exist
+	ErrInvalidLevel = errors.New("Log level must be trace, debug, info, warn, error, fatal, or panic")
+)
+
+// A subsystem holds the current settings of one subsystem
+type subsystem struct {
+	level  int32
+	sample uint32
+	count  uint32
+}
+
+var (
+	// lock serializes changes to the configuration (reads are atomic)
+	lock         sync.Mutex
+	defaultLevel = int32(zerolog.InfoLevel)
+	subsystems   = map[string]*subsystem{
+		Raft:    {level: inherit},
+		Storage: {level: inherit},
+		API:     {level: inherit},
+		Watch:   {level: inherit}}
+)
+
+// SubsystemConfig is the configuration of one subsystem
+type SubsystemConfig struct {
+	// Level of this subsystem (the default level if empty)
+	Level string `json:"level,omitempty"`
+	// Only one in every Sample trace and debug messages is logged (0 or 1 to
+	// log them all)
+	Sample uint32 `json:"sample,omitempty"`
+}
+
+// Config is the logging configuration, as read from a file or sent to the
+// admin log route
+type Config struct {
+	// Level of subsystems that do not have their own
+	Level      string                     `json:"level,omitempty"`
+	Subsystems map[string]SubsystemConfig `json:"subsystems,omitempty"`
+}
+
+// parseLevel returns the level named by s
+func parseLevel(s string) (zerolog.Level, error) {
+	level, err := zerolog.ParseLevel(s)
+	if err != nil || level == zerolog.NoLevel {
+		return zerolog.NoLevel, ErrInvalidLevel
+	}
+	return level, nil
+}
+
+// Configure applies c on top of the current configuration: the default level
+// is changed if c has one, and each subsystem in c is set as given (an empty
+// level makes it use the default). Nothing is changed if c is invalid
+func Configure(c Config) error {
+	var level zerolog.Level
+	var err error
+	if c.Level != "" {
+		if level, err = parseLevel(c.Level); err != nil {
+			return err
+		}
+	}
+	levels := map[string]int32{}
+	for name, s := range c.Subsystems {
+		if _, ok := subsystems[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSubsystem, name)
+		}
+		levels[name] = inherit
+		if s.Level != "" {
+			l, err := parseLevel(s.Level)
+			if err != nil {
+				return err
+			}
+			levels[name] = int32(l)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if c.Level != "" {
+		atomic.StoreInt32(&defaultLevel, int32(level))
+	}
+	for name, s := range c.Subsystems {
+		atomic.StoreInt32(&subsystems[name].level, levels[name])
+		atomic.StoreUint32(&subsystems[name].sample, s.Sample)
+	}
+	return nil
+}
+
+// Current returns the current configuration, with an entry for every
+// subsystem
+func Current() Config {
+	lock.Lock()
+	defer lock.Unlock()
+	c := Config{
+		Level:      zerolog.Level(atomic.LoadInt32(&defaultLevel)).String(),
+		Subsystems: map[string]SubsystemConfig{}}
+	for name, s := range subsystems {
+		config := SubsystemConfig{Sample: atomic.LoadUint32(&s.sample)}
+		if level := atomic.LoadInt32(&s.level); level != inherit {
+			config.Level = zerolog.Level(level).String()
+		}
+		c.Subsystems[name] = config
+	}
+	return c
+}
+
+// LoadFile reads a configuration (see Config) from a JSON file and applies it
+func LoadFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	return Configure(c)
+}
+
+// A Logger logs the messages of one subsystem
+type Logger struct {
+	name string
+	s    *subsystem
+}
+
+// For returns the Logger for a subsystem (one of the subsystem constants)
+func For(name string) *Logger {
+	s, ok := subsystems[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown log subsystem %q", name))
+	}
+	return &Logger{name: name, s: s}
+}
+
+// enabled reports whether a message at level should be logged
+func (l *Logger) enabled(level zerolog.Level) bool {
+	threshold := atomic.LoadInt32(&l.s.level)
+	if threshold == inherit {
+		threshold = atomic.LoadInt32(&defaultLevel)
+	}
+	if int32(level) < threshold {
+		return false
+	}
+	if level <= zerolog.DebugLevel {
+		if sample := atomic.LoadUint32(&l.s.sample); sample > 1 {
+			return atomic.AddUint32(&l.s.count, 1)%sample == 0
+		}
+	}
+	return true
+}
+
+// Trace starts a new message at trace level
+func (l *Logger) Trace() *zerolog.Event {
+	if !l.enabled(zerolog.TraceLevel) {
+		return nil
+	}
+	return log.Trace().Str("subsystem", l.name)
+}
+
+// Debug starts a new message at debug level
+func (l *Logger) Debug() *zerolog.Event {
+	if !l.enabled(zerolog.DebugLevel) {
+		return nil
+	}
+	return log.Debug().Str("subsystem", l.name)
+}
+
+// Info starts a new message at info level
+func (l *Logger) Info() *zerolog.Event {
+	if !l.enabled(zerolog.InfoLevel) {
+		return nil
+	}
+	return log.Info().Str("subsystem", l.name)
+}
+
+// Warn starts a new message at warn level
+func (l *Logger) Warn() *zerolog.Event {
+	if !l.enabled(zerolog.WarnLevel) {
+		return nil
+	}
+	return log.Warn().Str("subsystem", l.name)
+}
+
+// Error starts a new message at error level
+func (l *Logger) Error() *zerolog.Event {
+	if !l.enabled(zerolog.ErrorLevel) {
+		return nil
+	}
+	return log.Error().Str("subsystem", l.name)
+}
+
+// Fatal starts a new message at fatal level, which exits the process once
+// sent
+func (l *Logger) Fatal() *zerolog.Event {
+	if !l.enabled(zerolog.FatalLevel) {
+		return nil
+	}
+	return log.Fatal().Str("subsystem", l.name)
+}
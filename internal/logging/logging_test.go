@@ -0,0 +1,122 @@
+// +build unit
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// capture sends log output to a buffer for the duration of a test, and resets
+// the configuration afterward
+func capture(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, level := log.Logger, zerolog.GlobalLevel()
+	log.Logger = zerolog.New(&buf)
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	t.Cleanup(func() {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+		reset := Config{Level: "info", Subsystems: map[string]SubsystemConfig{}}
+		for name := range subsystems {
+			reset.Subsystems[name] = SubsystemConfig{}
+		}
+		Configure(reset)
+	})
+	return &buf
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	buf := capture(t)
+	err := Configure(Config{
+		Level:      "warn",
+		Subsystems: map[string]SubsystemConfig{Raft: {Level: "debug"}}})
+	if err != nil {
+		t.Fatalf("Error configuring: %v", err)
+	}
+
+	For(Raft).Debug().Msg("raft debug")
+	For(Raft).Trace().Msg("raft trace")
+	For(Storage).Info().Msg("storage info")
+	For(Storage).Warn().Msg("storage warn")
+
+	out := buf.String()
+	if !strings.Contains(out, `"subsystem":"raft","message":"raft debug"`) {
+		t.Errorf("Expected raft debug message, got %s", out)
+	}
+	if strings.Contains(out, "raft trace") || strings.Contains(out, "storage info") {
+		t.Errorf("Expected messages below each subsystem's level to be dropped, got %s", out)
+	}
+	if !strings.Contains(out, "storage warn") {
+		t.Errorf("Expected storage to use the default level, got %s", out)
+	}
+
+	current := Current()
+	if current.Level != "warn" || current.Subsystems[Raft].Level != "debug" || current.Subsystems[Storage].Level != "" {
+		t.Errorf("Unexpected current configuration %+v", current)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	buf := capture(t)
+	Configure(Config{Subsystems: map[string]SubsystemConfig{Raft: {Level: "trace", Sample: 10}}})
+
+	for i := 0; i < 100; i++ {
+		For(Raft).Trace().Msg("append")
+	}
+	For(Raft).Info().Msg("election")
+
+	if n := strings.Count(buf.String(), "append"); n != 10 {
+		t.Errorf("Expected 1 in 10 trace messages, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "election") {
+		t.Error("Expected info messages not to be sampled")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	capture(t)
+	err := Configure(Config{Subsystems: map[string]SubsystemConfig{"disk": {Level: "debug"}}})
+	if !errors.Is(err, ErrUnknownSubsystem) {
+		t.Errorf("Expected ErrUnknownSubsystem, got %v", err)
+	}
+	err = Configure(Config{
+		Level:      "debug",
+		Subsystems: map[string]SubsystemConfig{Raft: {Level: "loud"}}})
+	if err != ErrInvalidLevel {
+		t.Errorf("Expected ErrInvalidLevel, got %v", err)
+	}
+	if Current().Level != "info" {
+		t.Error("Expected an invalid configuration to change nothing")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	capture(t)
+	dir, err := ioutil.TempDir("", "leifdb")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "log.json")
+	config := `{"level": "error", "subsystems": {"watch": {"level": "trace", "sample": 5}}}`
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+
+	if err := LoadFile(filename); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	current := Current()
+	if current.Level != "error" || current.Subsystems[Watch] != (SubsystemConfig{Level: "trace", Sample: 5}) {
+		t.Errorf("Unexpected configuration after load %+v", current)
+	}
+}
@@ -0,0 +1,347 @@
+package metrics
+
+// Metrics are exported in the Prometheus text exposition format, so they can be
+// scraped from the "/metrics" HTTP endpoint. Metrics are registered once (as
+// package-level variables in the package that updates them) and are safe for
+// concurrent use.
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A metric is anything that can write its samples in the text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = map[string]metric{}
+)
+
+// register adds a metric to the registry, panicking on duplicate names (which
+// indicates a programming error)
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[m.name()]; ok {
+		panic("duplicate metric: " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+// WriteText writes all registered metrics, sorted by name
+func WriteText(w io.Writer) {
+	registryLock.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryLock.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w)
+	})
+}
+
+// A Gauge is a value that can go up and down, such as the size of a file
+type Gauge struct {
+	metricName string
+	help       string
+	bits       uint64
+}
+
+// NewGauge creates and registers a Gauge
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	register(g)
+	return g
+}
+
+// Set changes the value of the gauge
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) name() string {
+	return g.metricName
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	fmt.Fprintf(w, "%s %v\n", g.metricName, g.Value())
+}
+
+// A Counter is a value that only increases, such as a number of events
+type Counter struct {
+	metricName string
+	help       string
+	bits       uint64
+}
+
+// NewCounter creates and registers a Counter
+func NewCounter(name string, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+// Add increases the counter by v (which must not be negative)
+func (c *Counter) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&c.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Inc increases the counter by 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+func (c *Counter) name() string {
+	return c.metricName
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", c.metricName, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.metricName)
+	fmt.Fprintf(w, "%s %v\n", c.metricName, c.Value())
+}
+
+// A CounterVec is a set of counters split into series by the value of a
+// single label, such as a count of events per peer
+type CounterVec struct {
+	metricName string
+	help       string
+	label      string
+	lock       sync.Mutex
+	series     map[string]float64
+}
+
+// NewCounterVec creates and registers a CounterVec with the given label
+func NewCounterVec(name string, help string, label string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		label:      label,
+		series:     map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Add increases the counter for a label value by v (which must not be
+// negative)
+func (c *CounterVec) Add(labelValue string, v float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.series[labelValue] += v
+}
+
+// Inc increases the counter for a label value by 1
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Value returns the current value of the counter for a label value
+func (c *CounterVec) Value(labelValue string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.series[labelValue]
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", c.metricName, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.metricName)
+	values := make([]string, 0, len(c.series))
+	for value := range c.series {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %v\n", c.metricName, c.label, value, c.series[value])
+	}
+}
+
+// A GaugeVec is a set of gauges split into series by the value of a single
+// label, such as the size of each kind of file
+type GaugeVec struct {
+	metricName string
+	help       string
+	label      string
+	lock       sync.Mutex
+	series     map[string]float64
+}
+
+// NewGaugeVec creates and registers a GaugeVec with the given label
+func NewGaugeVec(name string, help string, label string) *GaugeVec {
+	g := &GaugeVec{
+		metricName: name,
+		help:       help,
+		label:      label,
+		series:     map[string]float64{}}
+	register(g)
+	return g
+}
+
+// Set changes the value of the gauge for a label value
+func (g *GaugeVec) Set(labelValue string, v float64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.series[labelValue] = v
+}
+
+// Value returns the current value of the gauge for a label value
+func (g *GaugeVec) Value(labelValue string) float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.series[labelValue]
+}
+
+func (g *GaugeVec) name() string {
+	return g.metricName
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	values := make([]string, 0, len(g.series))
+	for value := range g.series {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %v\n", g.metricName, g.label, value, g.series[value])
+	}
+}
+
+// DefaultLatencyBuckets are histogram bucket upper bounds suited to latencies
+// measured in seconds, from half a millisecond to ten seconds
+var DefaultLatencyBuckets = []float64{
+	.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Histogram counts observed values, such as latencies, in buckets. Values
+// are split into series by the value of a single label
+type Histogram struct {
+	metricName string
+	help       string
+	label      string
+	buckets    []float64
+	lock       sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a Histogram with the given label and
+// bucket upper bounds (in increasing order)
+func NewHistogram(name string, help string, label string, buckets []float64) *Histogram {
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		label:      label,
+		buckets:    buckets,
+		series:     map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+// Observe adds a value to the series for a label value
+func (h *Histogram) Observe(labelValue string, v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of values observed for a label value
+func (h *Histogram) Count(labelValue string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if s, ok := h.series[labelValue]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", h.metricName, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.metricName)
+	values := make([]string, 0, len(h.series))
+	for value := range h.series {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		s := h.series[value]
+		label := fmt.Sprintf("%s=%q", h.label, value)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%v\"} %d\n",
+				h.metricName, label, bound, s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.metricName, label, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %v\n", h.metricName, label, s.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.metricName, label, s.count)
+	}
+}
@@ -0,0 +1,121 @@
+// +build unit
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	g := NewGauge("test_gauge_bytes", "A test gauge")
+	c := NewCounter("test_counter_total", "A test counter")
+
+	g.Set(1024)
+	c.Inc()
+	c.Add(2.5)
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	text := buf.String()
+
+	expected := []string{
+		"# HELP test_counter_total A test counter\n",
+		"# TYPE test_counter_total counter\n",
+		"test_counter_total 3.5\n",
+		"# TYPE test_gauge_bytes gauge\n",
+		"test_gauge_bytes 1024\n"}
+	for _, line := range expected {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, text)
+		}
+	}
+	if strings.Index(text, "test_counter_total") > strings.Index(text, "test_gauge_bytes") {
+		t.Errorf("Expected metrics sorted by name, got:\n%s", text)
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	NewGauge("test_duplicate", "first")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected panic registering a duplicate metric name")
+		}
+	}()
+	NewGauge("test_duplicate", "second")
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_events_total", "A test counter vec", "peer")
+
+	c.Inc("b:1")
+	c.Inc("a:1")
+	c.Add("a:1", 2)
+
+	if c.Value("a:1") != 3 || c.Value("c:1") != 0 {
+		t.Errorf("Unexpected values %v, %v", c.Value("a:1"), c.Value("c:1"))
+	}
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	text := buf.String()
+	expected := "# TYPE test_events_total counter\n" +
+		"test_events_total{peer=\"a:1\"} 3\n" +
+		"test_events_total{peer=\"b:1\"} 1\n"
+	if !strings.Contains(text, expected) {
+		t.Errorf("Expected output to contain %q, got:\n%s", expected, text)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("test_file_bytes", "A test gauge vec", "component")
+
+	g.Set("log", 10)
+	g.Set("log", 4)
+	g.Set("snapshots", 7)
+
+	if g.Value("log") != 4 || g.Value("other") != 0 {
+		t.Errorf("Unexpected values %v, %v", g.Value("log"), g.Value("other"))
+	}
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	text := buf.String()
+	expected := "# TYPE test_file_bytes gauge\n" +
+		"test_file_bytes{component=\"log\"} 4\n" +
+		"test_file_bytes{component=\"snapshots\"} 7\n"
+	if !strings.Contains(text, expected) {
+		t.Errorf("Expected output to contain %q, got:\n%s", expected, text)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "A test histogram", "phase", []float64{0.1, 1})
+
+	h.Observe("persist", 0.05)
+	h.Observe("persist", 0.5)
+	h.Observe("persist", 2)
+	h.Observe("apply", 0.01)
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	text := buf.String()
+
+	expected := []string{
+		"# TYPE test_latency_seconds histogram\n",
+		"test_latency_seconds_bucket{phase=\"persist\",le=\"0.1\"} 1\n",
+		"test_latency_seconds_bucket{phase=\"persist\",le=\"1\"} 2\n",
+		"test_latency_seconds_bucket{phase=\"persist\",le=\"+Inf\"} 3\n",
+		"test_latency_seconds_sum{phase=\"persist\"} 2.55\n",
+		"test_latency_seconds_count{phase=\"persist\"} 3\n",
+		"test_latency_seconds_count{phase=\"apply\"} 1\n"}
+	for _, line := range expected {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, text)
+		}
+	}
+	if h.Count("persist") != 3 || h.Count("queue") != 0 {
+		t.Errorf("Unexpected counts: persist %d, queue %d", h.Count("persist"), h.Count("queue"))
+	}
+}
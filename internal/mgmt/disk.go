@@ -0,0 +1,141 @@
+package mgmt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/util"
+)
+
+// Components of a node's data directory. The database itself is kept in
+// memory, so its only footprint on disk is its snapshots (which include those
+// taken as backups)
+const (
+	ComponentLog       = "log"
+	ComponentSnapshots = "snapshots"
+	ComponentOther     = "other"
+)
+
+var (
+	dataDirBytesGauge = metrics.NewGaugeVec(
+		"leifdb_data_dir_bytes",
+		"Size of the files in the data directory, in bytes",
+		"component")
+	volumeFreeBytesGauge = metrics.NewGauge(
+		"leifdb_volume_free_bytes",
+		"Bytes available on the volume holding the data directory")
+	volumeBytesGauge = metrics.NewGauge(
+		"leifdb_volume_bytes",
+		"Total size of the volume holding the data directory, in bytes")
+)
+
+// A DiskFile is one file in the data directory
+type DiskFile struct {
+	// Path relative to the data directory
+	Name      string    `json:"name"`
+	Component string    `json:"component"`
+	Bytes     int64     `json:"bytes"`
+	Modified  time.Time `json:"modified"`
+}
+
+// A DiskComponent totals the files of one component of the data directory
+type DiskComponent struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// DiskUsage describes the files in a node's data directory, and the space
+// left on the volume holding it
+type DiskUsage struct {
+	DataDir    string          `json:"dataDir"`
+	TotalBytes int64           `json:"totalBytes"`
+	Components []DiskComponent `json:"components"`
+	Files      []DiskFile      `json:"files"`
+	// Bytes available, and the total size, of the volume (omitted where free
+	// space can't be measured)
+	VolumeFreeBytes *uint64 `json:"volumeFreeBytes,omitempty"`
+	VolumeBytes     *uint64 `json:"volumeBytes,omitempty"`
+}
+
+// component returns the component of the data directory that a file (named
+// relative to the directory) belongs to
+func component(name string, logFile string) string {
+	switch {
+	case name == logFile:
+		return ComponentLog
+	case strings.HasPrefix(name, prefix), strings.HasPrefix(name, manifestPrefix):
+		return ComponentSnapshots
+	}
+	return ComponentOther
+}
+
+// InspectDataDir lists the files in dataDir by component, where logFile is
+// the path of the raft log
+func InspectDataDir(dataDir string, logFile string) (*DiskUsage, error) {
+	usage := &DiskUsage{DataDir: dataDir, Files: []DiskFile{}}
+	totals := map[string]*DiskComponent{}
+	for _, name := range []string{ComponentLog, ComponentSnapshots, ComponentOther} {
+		totals[name] = &DiskComponent{Name: name}
+	}
+	logName, _ := filepath.Rel(dataDir, logFile)
+
+	err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		file := DiskFile{
+			Name:      name,
+			Component: component(name, logName),
+			Bytes:     info.Size(),
+			Modified:  info.ModTime()}
+		usage.Files = append(usage.Files, file)
+		usage.TotalBytes += file.Bytes
+		totals[file.Component].Files++
+		totals[file.Component].Bytes += file.Bytes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{ComponentLog, ComponentSnapshots, ComponentOther} {
+		usage.Components = append(usage.Components, *totals[name])
+	}
+
+	if free, total, err := util.VolumeSpace(dataDir); err == nil {
+		usage.VolumeFreeBytes, usage.VolumeBytes = &free, &total
+	}
+	return usage, nil
+}
+
+// recordDiskUsage updates the disk usage metrics
+func recordDiskUsage(usage *DiskUsage) {
+	for _, c := range usage.Components {
+		dataDirBytesGauge.Set(c.Name, float64(c.Bytes))
+	}
+	if usage.VolumeFreeBytes != nil {
+		volumeFreeBytesGauge.Set(float64(*usage.VolumeFreeBytes))
+		volumeBytesGauge.Set(float64(*usage.VolumeBytes))
+	}
+}
+
+// DiskUsage describes the files in the node's data directory, and updates the
+// disk usage metrics (which are also updated at each periodic snapshot check)
+func (m *SnapshotManager) DiskUsage() (*DiskUsage, error) {
+	usage, err := InspectDataDir(m.dataDir, m.logFile)
+	if err != nil {
+		return nil, err
+	}
+	recordDiskUsage(usage)
+	return usage, nil
+}
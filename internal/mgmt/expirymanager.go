@@ -0,0 +1,38 @@
+package mgmt
+
+import (
+	"context"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+// StartExpiryManager periodically removes keys whose expiry time has passed,
+// while this node is the leader (followers remove them when the leader's
+// expire entries are applied). It returns a function that stops the manager
+func StartExpiryManager(period time.Duration, n *node.Node) func() {
+	t := time.NewTicker(period)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+			if !n.IsLeader() {
+				continue
+			}
+			// give up waiting for the entry to commit by the next tick
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			if err := n.ExpireKeys(ctx); err != nil {
+				logger.Error().Err(err).Msg("error expiring keys")
+			}
+			cancel()
+		}
+	}()
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,30 @@
+package mgmt
+
+import (
+	"context"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+// StartMembershipPolicy periodically checks whether the leader should promote
+// a learner or demote a flapping voter (see node.ApplyMembershipPolicy). It
+// returns a function that stops the checks
+func StartMembershipPolicy(period time.Duration, n *node.Node) func() {
+	t := time.NewTicker(period)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-t.C:
+				n.ApplyMembershipPolicy(context.Background())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
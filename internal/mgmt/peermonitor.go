@@ -0,0 +1,29 @@
+package mgmt
+
+import (
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+// StartPeerMonitor periodically pings the other members of the cluster, so that
+// their availability and round-trip times are known on every node, whether or
+// not it is the leader. It returns a function that stops the monitor
+func StartPeerMonitor(period time.Duration, n *node.Node) func() {
+	t := time.NewTicker(period)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-t.C:
+				n.PingPeers()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		t.Stop()
+		close(done)
+	}
+}
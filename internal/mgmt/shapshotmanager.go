@@ -4,22 +4,98 @@ package mgmt
 // should not be aware of managers
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
-
 	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/metrics"
 	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/ratelimit"
+)
+
+// logger logs snapshots, log compaction, and key expiry
+var logger = logging.For(logging.Storage)
+
+const (
+	prefix         = "ldbsnapshot"
+	manifestPrefix = "ldbmanifest"
 )
 
-const prefix = "ldbsnapshot"
+var (
+	// ErrSnapshotCorrupt indicates that a snapshot does not match the checksum
+	// recorded for it, or cannot be decoded
+	ErrSnapshotCorrupt = errors.New("Snapshot is corrupt")
+
+	// ErrNoLeader indicates that a reseed was requested while no leader is
+	// known to take a snapshot from
+	ErrNoLeader = errors.New("No leader is known to reseed from")
+
+	// ErrManagerStopped indicates that a snapshot could not be installed
+	// because the snapshot manager has been stopped
+	ErrManagerStopped = errors.New("Snapshot manager is stopped")
+)
+
+var (
+	logBytesGauge = metrics.NewGauge(
+		"leifdb_raft_log_bytes",
+		"Size of the raft log on disk, in bytes (excluding space preallocated for it)")
+	logEntriesGauge = metrics.NewGauge(
+		"leifdb_raft_log_entries",
+		"Number of entries in the in-memory raft log")
+	reclaimedBytesCounter = metrics.NewCounter(
+		"leifdb_raft_log_reclaimed_bytes_total",
+		"Bytes removed from the raft log file by compaction")
+	compactedEntriesCounter = metrics.NewCounter(
+		"leifdb_raft_log_compacted_entries_total",
+		"Log entries discarded by compaction")
+	storageSeconds = metrics.NewHistogram(
+		"leifdb_storage_duration_seconds",
+		"Time taken by each snapshot and log compaction",
+		"operation",
+		metrics.DefaultLatencyBuckets)
+	lastCompactionGauge = metrics.NewGauge(
+		"leifdb_raft_log_last_compaction_timestamp_seconds",
+		"Unix time of the last compaction that discarded log entries")
+	lastSnapshotGauge = metrics.NewGauge(
+		"leifdb_snapshot_last_timestamp_seconds",
+		"Unix time of the last snapshot persisted")
+)
+
+// A snapshotManifest records metadata about the snapshot file with the same
+// index number, so that a restarted node knows which log entries are already
+// reflected in the snapshot
+type snapshotManifest struct {
+	LastApplied int64 `json:"lastApplied"`
+	LastTerm    int64 `json:"lastTerm"`
+	// CRC-32 (IEEE) and SHA-256 (hex) of the snapshot file, and the node's
+	// applied hash as of LastApplied (empty in manifests written by earlier
+	// versions)
+	Checksum    uint32 `json:"checksum,omitempty"`
+	Sha256      string `json:"sha256,omitempty"`
+	AppliedHash uint64 `json:"appliedHash,omitempty"`
+}
+
+// manifestPath returns the path of the manifest file for a snapshot file
+func manifestPath(snapshotPath string) string {
+	dir, filename := filepath.Split(snapshotPath)
+	return filepath.Join(dir, manifestPrefix+strings.TrimPrefix(filename, prefix))
+}
 
 // findExistingSnapshots returns a lexicographically sorted list of snapshot
 // files, along with the next available index number for snapshotting
@@ -28,7 +104,7 @@ func findExistingSnapshots(dataDir string) ([]string, int) {
 	nextIndex := 0
 	files, err := ioutil.ReadDir(dataDir)
 	if err != nil {
-		log.Error().Err(err).Msgf("error listing %s", dataDir)
+		logger.Error().Err(err).Msgf("error listing %s", dataDir)
 	} else {
 		for _, file := range files {
 			if strings.HasPrefix(file.Name(), prefix) {
@@ -37,32 +113,95 @@ func findExistingSnapshots(dataDir string) ([]string, int) {
 		}
 		sort.Strings(snapshotFiles)
 	}
-	log.Info().Int("count", len(snapshotFiles)).Msg("found snapshots")
+	logger.Info().Int("count", len(snapshotFiles)).Msg("found snapshots")
 	if len(snapshotFiles) > 0 {
 		latest := snapshotFiles[len(snapshotFiles)-1]
 		_, filename := filepath.Split(latest)
 		index, err := strconv.Atoi(strings.TrimPrefix(filename, prefix))
 		if err != nil {
-			log.Error().Err(err).Msg("error parsing latest snapshot index")
+			logger.Error().Err(err).Msg("error parsing latest snapshot index")
 		} else {
-			log.Info().Int("index", index).Msg("latest snapshot")
+			logger.Info().Int("index", index).Msg("latest snapshot")
 			nextIndex = index + 1
 		}
 	}
 	return snapshotFiles, nextIndex
 }
 
-// cloneAndSerialize makes a copy of the current commit index and database
-// state, then returns a serialized version of the snapshot and the commit
-// index, or an error
-func cloneAndSerialize(node *node.Node) ([]byte, int64, error) {
+// LatestSnapshotMeta describes the latest snapshot in dataDir, or returns nil
+// if there is none (see node.CheckState)
+func LatestSnapshotMeta(dataDir string) *node.SnapshotMeta {
+	snapshotFiles, _ := findExistingSnapshots(dataDir)
+	if len(snapshotFiles) == 0 {
+		return nil
+	}
+	latest := snapshotFiles[len(snapshotFiles)-1]
+	meta := &node.SnapshotMeta{File: latest}
+	// a manifest that can't be read is reported when the snapshot is loaded
+	if manifest, err := readManifest(latest); err == nil && manifest != nil {
+		meta.HasManifest = true
+		meta.LastApplied, meta.LastTerm = manifest.LastApplied, manifest.LastTerm
+	}
+	return meta
+}
+
+// cloneState makes a copy of the current applied index and database state,
+// and returns the copy along with a manifest describing it (without its
+// checksums, which are known once it is serialized). The copy is cheap, since
+// the database is immutable underneath, so applies are only held up for as
+// long as it takes to make it, not to serialize it
+func cloneState(node *node.Node) (*db.Database, *snapshotManifest) {
 	node.Lock()
-	commitIndex := node.CommitIndex
-	clone := db.Clone(node.Store)
-	node.Unlock()
+	defer node.Unlock()
+	manifest := &snapshotManifest{LastApplied: node.Applied()}
+	manifest.LastTerm, _ = node.LogTerm(manifest.LastApplied)
+	_, manifest.AppliedHash = node.AppliedHash()
+	return db.Clone(node.Store), manifest
+}
 
+// cloneAndSerialize makes a copy of the current applied index and database
+// state, then returns a serialized version of the snapshot and a manifest
+// describing it, or an error
+func cloneAndSerialize(node *node.Node) ([]byte, *snapshotManifest, error) {
+	clone, manifest := cloneState(node)
 	snapshot, err := db.BuildSnapshot(clone)
-	return snapshot, commitIndex, err
+	manifest.Checksum = crc32.ChecksumIEEE(snapshot)
+	manifest.Sha256 = contentHash(snapshot)
+	return snapshot, manifest, err
+}
+
+// writeSnapshot serializes a copy of the database (see cloneState) straight to
+// a file, a batch of keys at a time, no faster than limiter allows, and fills
+// in the checksums of manifest as it goes. The node goes on applying entries
+// while it is written. If it can't be written, the file is removed
+func writeSnapshot(
+	ctx context.Context,
+	clone *db.Database,
+	manifest *snapshotManifest,
+	filename string,
+	limiter *ratelimit.Limiter) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	checksum := crc32.NewIEEE()
+	hash := sha256.New()
+	buffered := bufio.NewWriter(f)
+	w := io.MultiWriter(ratelimit.NewWriter(ctx, buffered, limiter), checksum, hash)
+	err = db.WriteSnapshot(w, clone)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	manifest.Checksum = checksum.Sum32()
+	manifest.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
 }
 
 // persist writes a byte array (the serialized snapshot) to disk
@@ -88,93 +227,601 @@ func persist(data []byte, filename string) error {
 func dropOldSnapshots(snapshotFiles []string, retain int) []string {
 	for len(snapshotFiles) > retain {
 		drop := snapshotFiles[0]
-		log.Info().Str("filename", drop).Msg("removing snapshot")
+		logger.Info().Str("filename", drop).Msg("removing snapshot")
 		err := os.Remove(drop)
 		if err != nil {
-			log.Error().
+			logger.Error().
 				Err(err).
 				Str("filename", drop).
 				Msg("error removing log file")
 		}
+		if err := os.Remove(manifestPath(drop)); err != nil && !os.IsNotExist(err) {
+			logger.Error().
+				Err(err).
+				Str("filename", manifestPath(drop)).
+				Msg("error removing manifest file")
+		}
 		snapshotFiles = snapshotFiles[1:]
 	}
 	return snapshotFiles
 }
 
+// persistManifest writes the manifest for a snapshot to disk
+func persistManifest(manifest *snapshotManifest, filename string) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return persist(data, filename)
+}
+
+// readManifest fetches the manifest for a snapshot, returning nil if the
+// snapshot has no manifest (snapshots taken by earlier versions)
+func readManifest(snapshotPath string) (*snapshotManifest, error) {
+	data, err := ioutil.ReadFile(manifestPath(snapshotPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// parseManifest decodes the contents of a manifest file
+func parseManifest(data []byte) (*snapshotManifest, error) {
+	var manifest snapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
 // loadSnapshot fetches a snapshot as a byte array from the file specified,
-// initializes a database from the snapshot and installs it into the node
+// initializes a database from the snapshot and installs it into the node. If
+// the snapshot has a manifest, the node's applied index is restored as well,
+// so that entries already reflected in the snapshot are not re-applied.
+// Returns ErrSnapshotCorrupt if the snapshot does not match its checksum or
+// cannot be decoded
 func loadSnapshot(n *node.Node, snapshotPath string) error {
 	data, err := ioutil.ReadFile(snapshotPath)
 	if err != nil {
 		return err
 	}
 
-	newStore, err := db.InstallSnapshot(data)
+	manifest, err := readManifest(snapshotPath)
 	if err != nil {
 		return err
 	}
-	n.Store = newStore
+	if manifest != nil && verifyContent(data, manifest) != nil {
+		logger.Error().
+			Str("filename", snapshotPath).
+			Str("expected", manifest.Sha256).
+			Str("actual", contentHash(data)).
+			Msg("snapshot content hash mismatch")
+		return ErrSnapshotCorrupt
+	}
+
+	newStore, err := db.InstallSnapshot(data)
+	if err != nil {
+		logger.Error().Err(err).Str("filename", snapshotPath).Msg("error decoding snapshot")
+		return ErrSnapshotCorrupt
+	}
+
+	if manifest == nil {
+		logger.Warn().Str("filename", snapshotPath).Msg("snapshot has no manifest")
+		n.Store = newStore
+		return nil
+	}
+	logger.Info().
+		Int64("last applied", manifest.LastApplied).
+		Int64("last term", manifest.LastTerm).
+		Msg("restoring snapshot")
+	n.RestoreSnapshot(newStore, manifest.LastApplied, manifest.AppliedHash)
 	return nil
 }
 
+// fileSize returns the size of a file in bytes, or 0 if it does not exist
+func fileSize(filename string) int64 {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// CompactionInfo describes a compaction of the log
+type CompactionInfo struct {
+	Time time.Time `json:"time"`
+	// Index of the first entry kept in the log
+	BaseIndex      int64   `json:"baseIndex"`
+	Entries        int64   `json:"entries"`
+	ReclaimedBytes int64   `json:"reclaimedBytes"`
+	Seconds        float64 `json:"seconds"`
+}
+
+// compactLog discards log entries before the specified index (after a snapshot
+// covering them has been persisted), and records how much space was reclaimed.
+// It returns a description of the compaction, or nil if no entries were
+// discarded
+func compactLog(n *node.Node, index int64) *CompactionInfo {
+	start := time.Now()
+	before := n.LogBytes()
+	dropped, err := n.CompactLog(index)
+	if err != nil {
+		logger.Error().Err(err).Msg("error compacting log")
+		return nil
+	}
+	if dropped == 0 {
+		return nil
+	}
+	after := n.LogBytes()
+	info := &CompactionInfo{
+		Time:      time.Now(),
+		BaseIndex: n.FirstLogIndex(),
+		Entries:   dropped,
+		Seconds:   time.Since(start).Seconds()}
+	if before > after {
+		info.ReclaimedBytes = before - after
+		reclaimedBytesCounter.Add(float64(info.ReclaimedBytes))
+	}
+	compactedEntriesCounter.Add(float64(dropped))
+	logBytesGauge.Set(float64(after))
+	storageSeconds.Observe("compaction", info.Seconds)
+	lastCompactionGauge.Set(float64(info.Time.UnixNano()) / 1e9)
+	logger.Info().
+		Int64("entries", dropped).
+		Int64("reclaimed bytes", before-after).
+		Msg("compacted log")
+	return info
+}
+
+// shouldSnapshot determines whether a snapshot is due, given the size of the
+// log file in bytes and the number of entries applied since the last snapshot.
+// A threshold of 0 disables that trigger
+func shouldSnapshot(
+	size int64,
+	threshold int64,
+	entries int64,
+	entryThreshold int64) bool {
+	if entries <= 0 {
+		// nothing has been applied since the last snapshot
+		return false
+	}
+	return (threshold > 0 && size > threshold) ||
+		(entryThreshold > 0 && entries >= entryThreshold)
+}
+
+// SnapshotInfo describes a snapshot kept on disk
+type SnapshotInfo struct {
+	File        string    `json:"file"`
+	LastApplied int64     `json:"lastApplied"`
+	LastTerm    int64     `json:"lastTerm"`
+	Bytes       int64     `json:"bytes"`
+	Time        time.Time `json:"time"`
+	// CRC-32 (IEEE) and SHA-256 (hex) of the snapshot, and the applied hash of
+	// the node it was taken from as of LastApplied (empty if not known)
+	Checksum    uint32 `json:"checksum,omitempty"`
+	Sha256      string `json:"sha256,omitempty"`
+	AppliedHash uint64 `json:"appliedHash,omitempty"`
+}
+
+// SnapshotStatus reports the snapshots taken by a SnapshotManager
+type SnapshotStatus struct {
+	// Whether a snapshot has been requested and not yet finished
+	InProgress bool `json:"inProgress"`
+	// Number of snapshots kept on disk
+	Count int `json:"count"`
+	// The most recent snapshot, if any
+	Latest *SnapshotInfo `json:"latest,omitempty"`
+	// Error from the most recent attempt to take a snapshot, if it failed
+	LastError string `json:"lastError,omitempty"`
+	// The most recent compaction of the log that discarded entries, if any
+	LastCompaction *CompactionInfo `json:"lastCompaction,omitempty"`
+}
+
+// A SnapshotManager periodically persists snapshots of a node's database, and
+// can be asked to take one immediately (e.g. for a backup)
+type SnapshotManager struct {
+	n       *node.Node
+	dataDir string
+	logFile string
+	trigger chan int64
+	reseeds chan *reseedRequest
+	done    chan struct{}
+	lock    sync.Mutex
+	status  SnapshotStatus
+}
+
+// A reseedRequest carries a snapshot downloaded from the leader to the
+// manager's goroutine, which installs it (see Reseed)
+type reseedRequest struct {
+	snapshot []byte
+	store    *db.Database
+	manifest *snapshotManifest
+	done     chan error
+}
+
+// Stop stops the manager from taking snapshots (one that is already being
+// taken is still finished)
+func (m *SnapshotManager) Stop() {
+	close(m.done)
+}
+
+// Trigger requests a snapshot as soon as possible, regardless of the size of
+// the log. It returns without waiting for the snapshot to be taken (see
+// Status for when it has been)
+func (m *SnapshotManager) Trigger() {
+	m.Compact(-1)
+}
+
+// Compact requests a snapshot as soon as possible, after which the log is
+// compacted keeping only the most recent retainEntries entries covered by the
+// snapshot (or the manager's usual number, if retainEntries is negative), e.g.
+// to shrink the log before planned maintenance. It returns without waiting
+// (see Status for when the compaction is done). If a snapshot is already
+// pending, the request is merged with it, keeping the fewer entries
+func (m *SnapshotManager) Compact(retainEntries int64) {
+	m.lock.Lock()
+	m.status.InProgress = true
+	m.lock.Unlock()
+	for {
+		select {
+		case m.trigger <- retainEntries:
+			return
+		case pending := <-m.trigger:
+			if pending >= 0 && (retainEntries < 0 || pending < retainEntries) {
+				retainEntries = pending
+			}
+		}
+	}
+}
+
+// Status returns the state of the snapshots taken by the manager
+func (m *SnapshotManager) Status() SnapshotStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status := m.status
+	if status.Latest != nil {
+		latest := *status.Latest
+		status.Latest = &latest
+	}
+	return status
+}
+
+// Snapshot serializes the current state of the node's database, without
+// persisting it, and returns it along with a description of it
+func (m *SnapshotManager) Snapshot() ([]byte, *SnapshotInfo, error) {
+	snapshot, manifest, err := cloneAndSerialize(m.n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, &SnapshotInfo{
+		LastApplied: manifest.LastApplied,
+		LastTerm:    manifest.LastTerm,
+		Bytes:       int64(len(snapshot)),
+		Time:        time.Now(),
+		Checksum:    manifest.Checksum,
+		Sha256:      manifest.Sha256,
+		AppliedHash: manifest.AppliedHash}, nil
+}
+
+// Reseed replaces the node's state with a snapshot downloaded from the
+// leader (see node.Reseed), which lifts any quarantine. The snapshot is
+// verified against the checksum sent with it, and persisted in place of the
+// snapshots already on disk
+func (m *SnapshotManager) Reseed(ctx context.Context) error {
+	leader := m.n.RedirectLeader()
+	if leader == "" {
+		return ErrNoLeader
+	}
+	if m.n.IsLeader() {
+		return node.ErrReseedLeader
+	}
+	logger.Info().Str("leader", leader).Msg("downloading snapshot to reseed")
+	snapshot, manifest, err := downloadSnapshot(ctx, "http://"+leader+"/admin/snapshot")
+	if err != nil {
+		return err
+	}
+	store, err := db.InstallSnapshot(snapshot)
+	if err != nil {
+		logger.Error().Err(err).Msg("error decoding snapshot from leader")
+		return ErrSnapshotCorrupt
+	}
+
+	r := &reseedRequest{snapshot: snapshot, store: store, manifest: manifest, done: make(chan error, 1)}
+	select {
+	case m.reseeds <- r:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-r.done
+}
+
+// buildSnapshot takes a snapshot to send to a member being bootstrapped (see
+// node.AddMember)
+func (m *SnapshotManager) buildSnapshot() (*node.Snapshot, error) {
+	snapshot, manifest, err := cloneAndSerialize(m.n)
+	if err != nil {
+		return nil, err
+	}
+	return &node.Snapshot{
+		Data:        snapshot,
+		LastIndex:   manifest.LastApplied,
+		LastTerm:    manifest.LastTerm,
+		AppliedHash: manifest.AppliedHash}, nil
+}
+
+// installSnapshot installs a snapshot sent by the leader to bootstrap this
+// node, persisting it in place of the snapshots already on disk, as for a
+// reseed
+func (m *SnapshotManager) installSnapshot(snapshot *node.Snapshot) error {
+	store, err := db.InstallSnapshot(snapshot.Data)
+	if err != nil {
+		logger.Error().Err(err).Msg("error decoding snapshot from leader")
+		return ErrSnapshotCorrupt
+	}
+	manifest := &snapshotManifest{
+		LastApplied: snapshot.LastIndex,
+		LastTerm:    snapshot.LastTerm,
+		Checksum:    crc32.ChecksumIEEE(snapshot.Data),
+		Sha256:      contentHash(snapshot.Data),
+		AppliedHash: snapshot.AppliedHash}
+	r := &reseedRequest{snapshot: snapshot.Data, store: store, manifest: manifest, done: make(chan error, 1)}
+	select {
+	case m.reseeds <- r:
+	case <-m.done:
+		return ErrManagerStopped
+	}
+	return <-r.done
+}
+
+// downloadSnapshot fetches a snapshot from a node's snapshot route, and
+// returns it along with a manifest built from the response headers
+func downloadSnapshot(ctx context.Context, url string) ([]byte, *snapshotManifest, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Snapshot download from %s failed with status %d", url, resp.StatusCode)
+	}
+	snapshot, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &snapshotManifest{}
+	fields := []struct {
+		header string
+		parse  func(string) error
+	}{
+		{"X-Leifdb-Last-Applied", func(s string) (err error) {
+			manifest.LastApplied, err = strconv.ParseInt(s, 10, 64)
+			return
+		}},
+		{"X-Leifdb-Last-Term", func(s string) (err error) {
+			manifest.LastTerm, err = strconv.ParseInt(s, 10, 64)
+			return
+		}},
+		{"X-Leifdb-Checksum", func(s string) error {
+			checksum, err := strconv.ParseUint(s, 10, 32)
+			manifest.Checksum = uint32(checksum)
+			return err
+		}},
+		{"X-Leifdb-Applied-Hash", func(s string) (err error) {
+			manifest.AppliedHash, err = strconv.ParseUint(s, 10, 64)
+			return
+		}},
+	}
+	// sent by leaders since content hashes were recorded
+	manifest.Sha256 = resp.Header.Get("X-Leifdb-Sha256")
+	for _, field := range fields {
+		if err := field.parse(resp.Header.Get(field.header)); err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s header: %v", field.header, err)
+		}
+	}
+	if manifest.Checksum != crc32.ChecksumIEEE(snapshot) || verifyContent(snapshot, manifest) != nil {
+		logger.Error().
+			Uint32("expected", manifest.Checksum).
+			Uint32("actual", crc32.ChecksumIEEE(snapshot)).
+			Msg("downloaded snapshot checksum mismatch")
+		return nil, nil, ErrSnapshotCorrupt
+	}
+	return snapshot, manifest, nil
+}
+
+// describeSnapshot returns the info for a snapshot file on disk
+func describeSnapshot(snapshotPath string) *SnapshotInfo {
+	info := &SnapshotInfo{File: snapshotPath, LastApplied: -1, LastTerm: -1}
+	if fi, err := os.Stat(snapshotPath); err == nil {
+		info.Bytes = fi.Size()
+		info.Time = fi.ModTime()
+	}
+	if manifest, err := readManifest(snapshotPath); err == nil && manifest != nil {
+		info.LastApplied, info.LastTerm = manifest.LastApplied, manifest.LastTerm
+		info.Checksum, info.AppliedHash = manifest.Checksum, manifest.AppliedHash
+		info.Sha256 = manifest.Sha256
+	}
+	return info
+}
+
+// finish records the outcome of an attempt to take a snapshot (and compact
+// the log)
+func (m *SnapshotManager) finish(latest *SnapshotInfo, compaction *CompactionInfo, count int, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.status.InProgress = false
+	m.status.Count = count
+	if err != nil {
+		m.status.LastError = err.Error()
+		return
+	}
+	m.status.LastError = ""
+	if latest != nil {
+		m.status.Latest = latest
+	}
+	if compaction != nil {
+		m.status.LastCompaction = compaction
+	}
+}
+
+// StartSnapshotManager loads the latest snapshot (if any) into the node, then
+// periodically checks the raft log and takes a new snapshot when the log file
+// exceeds threshold bytes or entryThreshold entries have been applied since
+// the last snapshot, or when one is triggered. After each snapshot, log
+// entries covered by it are discarded, except for the most recent
+// retainEntries entries (kept so that slow followers can still be caught up
+// from the log). If the latest snapshot is corrupt, the node is quarantined
+// rather than started from it
 func StartSnapshotManager(
 	dataDir string,
 	logFile string,
 	threshold int64,
+	entryThreshold int64,
 	period time.Duration,
 	retain int,
-	n *node.Node) {
+	retainEntries int64,
+	n *node.Node) *SnapshotManager {
 	t := time.NewTicker(period)
+	m := &SnapshotManager{
+		n:       n,
+		dataDir: dataDir,
+		logFile: logFile,
+		trigger: make(chan int64, 1),
+		reseeds: make(chan *reseedRequest),
+		done:    make(chan struct{})}
+	n.BuildSnapshot = m.buildSnapshot
+	n.InstallSnapshot = m.installSnapshot
 
 	snapshotFiles, nextIndex := findExistingSnapshots(dataDir)
 	if len(snapshotFiles) > 0 {
-		err := loadSnapshot(n, snapshotFiles[len(snapshotFiles)-1])
-		if err != nil {
-			log.Fatal().Err(err).Msg("error loading snapshot")
+		latest := snapshotFiles[len(snapshotFiles)-1]
+		err := loadSnapshot(n, latest)
+		if err == ErrSnapshotCorrupt {
+			// skip the compacted entries, which can't be applied without the
+			// snapshot, and wait to be reseeded
+			n.RestoreSnapshot(db.NewDatabase(), n.FirstLogIndex()-1, 0)
+			n.Quarantine(fmt.Sprintf("Snapshot %s is corrupt", latest))
+		} else if err != nil {
+			logger.Fatal().Err(err).Msg("error loading snapshot")
 		}
+		m.status.Latest = describeSnapshot(latest)
 	}
+	m.status.Count = len(snapshotFiles)
+	n.Lock()
+	lastSnapshotIndex := n.Applied()
+	n.Unlock()
 
 	go func() {
 		for {
+			triggered := false
+			retainNow := retainEntries
 			select {
 			case <-t.C:
-				fi, err := os.Stat(logFile)
+			case <-m.done:
+				t.Stop()
+				return
+			case retain := <-m.trigger:
+				triggered = true
+				if retain >= 0 {
+					retainNow = retain
+				}
+			case r := <-m.reseeds:
+				filename := fmt.Sprintf("%s%06d", prefix, nextIndex)
+				fullPath := filepath.Join(dataDir, filename)
+				err := installReseed(n, r, fullPath)
+				r.done <- err
+				if err != nil {
+					logger.Error().Err(err).Msg("error reseeding")
+					continue
+				}
+				// the old snapshots are of the state being replaced
+				nextIndex++
+				lastSnapshotIndex = r.manifest.LastApplied
+				snapshotFiles = append(dropOldSnapshots(snapshotFiles, 0), fullPath)
+				m.finish(describeSnapshot(fullPath), nil, len(snapshotFiles), nil)
+				continue
+			}
+
+			// the log's own size, not counting space preallocated for it
+			size := n.LogBytes()
+			lastApplied := n.Applied()
+			logEntries := n.LastLogIndex() - n.FirstLogIndex() + 1
+			logBytesGauge.Set(float64(size))
+			logEntriesGauge.Set(float64(logEntries))
+			if _, err := m.DiskUsage(); err != nil {
+				logger.Warn().Err(err).Msg("error measuring data directory")
+			}
+
+			entries := lastApplied - lastSnapshotIndex
+			logger.Info().
+				Int64("log file size", size).
+				Int64("threshold", threshold).
+				Int64("entries since snapshot", entries).
+				Int64("entry threshold", entryThreshold).
+				Bool("triggered", triggered).
+				Msg("snapshot check")
+
+			if triggered || shouldSnapshot(size, threshold, entries, entryThreshold) {
+				start := time.Now()
+				clone, manifest := cloneState(n)
+				logger.Debug().
+					Int64("last applied", manifest.LastApplied).
+					Msg("doing snapshot")
+
+				filename := fmt.Sprintf("%s%06d", prefix, nextIndex)
+				fullPath := filepath.Join(dataDir, filename)
+				err := writeSnapshot(
+					context.Background(), clone, manifest, fullPath, n.SnapshotWriteLimiter())
 				if err != nil {
-					log.Debug().Msg("log file does not exists -- skipping snapshot")
+					logger.Error().Err(err).Msg("error persisting snapshot")
+					m.finish(nil, nil, len(snapshotFiles), err)
 					continue
 				}
-				size := fi.Size()
-				log.Info().
-					Int64("log file size", size).
-					Int64("threshold", threshold).
-					Msg("snapshot check")
-
-				if size > threshold {
-					snapshot, commitIndex, err := cloneAndSerialize(n)
-					if err != nil {
-						log.Error().Err(err).Msg("error building snapshot")
-						continue
-					}
-					log.Debug().
-						Int64("commit index", commitIndex).
-						Msg("doing snapshot")
-
-					filename := fmt.Sprintf("%s%06d", prefix, nextIndex)
-					fullPath := filepath.Join(dataDir, filename)
-					err = persist(snapshot, fullPath)
-					if err != nil {
-						log.Error().Err(err).Msg("error persisting snapshot")
-						continue
-					}
-
-					nextIndex++
-					snapshotFiles = append(snapshotFiles, fullPath)
-					// todo: trigger node log compaction
+				err = persistManifest(manifest, manifestPath(fullPath))
+				if err != nil {
+					logger.Error().Err(err).Msg("error persisting snapshot manifest")
+					m.finish(nil, nil, len(snapshotFiles), err)
+					continue
 				}
 
+				storageSeconds.Observe("snapshot", time.Since(start).Seconds())
+				lastSnapshotGauge.Set(float64(time.Now().UnixNano()) / 1e9)
+
+				nextIndex++
+				lastSnapshotIndex = manifest.LastApplied
+				snapshotFiles = append(snapshotFiles, fullPath)
+				compaction := compactLog(n, manifest.LastApplied+1-retainNow)
 				snapshotFiles = dropOldSnapshots(snapshotFiles, retain)
-			default:
+				m.finish(describeSnapshot(fullPath), compaction, len(snapshotFiles), nil)
+				continue
 			}
+
+			snapshotFiles = dropOldSnapshots(snapshotFiles, retain)
 		}
 	}()
+	return m
+}
+
+// installReseed persists the snapshot of a reseed request as snapshotPath,
+// then installs it into the node
+func installReseed(n *node.Node, r *reseedRequest, snapshotPath string) error {
+	if err := persist(r.snapshot, snapshotPath); err != nil {
+		return err
+	}
+	if err := persistManifest(r.manifest, manifestPath(snapshotPath)); err != nil {
+		return err
+	}
+	err := n.Reseed(r.store, r.manifest.LastApplied, r.manifest.LastTerm, r.manifest.AppliedHash)
+	if err != nil {
+		os.Remove(snapshotPath)
+		os.Remove(manifestPath(snapshotPath))
+	}
+	return err
 }
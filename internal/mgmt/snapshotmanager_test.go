@@ -3,15 +3,26 @@
 package mgmt
 
 import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	db "github.com/btmorr/leifdb/internal/database"
 	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/internal/ratelimit"
 	"github.com/btmorr/leifdb/internal/util"
 )
 
@@ -77,17 +88,19 @@ func TestCloneAndSerialize(t *testing.T) {
 	n := setupServer(t)
 	n.Store.Set("ice", "cream")
 	n.CommitIndex++
+	n.LastApplied++
 	n.Store.Set("straw", "bale")
 	n.CommitIndex++
+	n.LastApplied++
 
 	var snapshot []byte
-	var index int64
+	var manifest *snapshotManifest
 	var err error
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
-		snapshot, index, err = cloneAndSerialize(n)
+		snapshot, manifest, err = cloneAndSerialize(n)
 		wg.Done()
 	}()
 	// simulate raft write starting during clone
@@ -95,6 +108,7 @@ func TestCloneAndSerialize(t *testing.T) {
 	n.Lock()
 	n.Store.Set("straw", "berry")
 	n.CommitIndex++
+	n.LastApplied++
 	n.Unlock()
 
 	wg.Wait()
@@ -103,8 +117,8 @@ func TestCloneAndSerialize(t *testing.T) {
 		t.Errorf("Error installing snapshot: %v\n", err)
 	}
 
-	if index != 1 {
-		t.Errorf("Next index after first snapshot should be 1, got %d\n", index)
+	if manifest.LastApplied != 1 {
+		t.Errorf("Applied index in first snapshot should be 1, got %d\n", manifest.LastApplied)
 	}
 
 	ice := reconstituted.Get("ice")
@@ -117,3 +131,409 @@ func TestCloneAndSerialize(t *testing.T) {
 		t.Errorf("Expected straw bale but got straw %s\n", straw)
 	}
 }
+
+func TestWriteSnapshot(t *testing.T) {
+	n := setupServer(t)
+	for i := 0; i < 5000; i++ {
+		n.Store.Set(fmt.Sprintf("key%d", i), "before")
+	}
+	n.CommitIndex++
+	n.LastApplied++
+
+	clone, manifest := cloneState(n)
+	// applies carry on while the snapshot is written
+	n.Lock()
+	n.Store.Set("key1", "after")
+	n.CommitIndex++
+	n.LastApplied++
+	n.Unlock()
+
+	snapshotPath := filepath.Join(setupTestDir(t), prefix+"000001")
+	err := writeSnapshot(context.Background(), clone, manifest, snapshotPath, ratelimit.New(1<<20))
+	if err != nil {
+		t.Fatalf("Error writing snapshot: %v\n", err)
+	}
+	data, err := ioutil.ReadFile(snapshotPath)
+	if err != nil {
+		t.Fatalf("Error reading snapshot: %v\n", err)
+	}
+	if err := verifyContent(data, manifest); err != nil || crc32.ChecksumIEEE(data) != manifest.Checksum {
+		t.Errorf("Expected manifest checksums to match the snapshot written\n")
+	}
+	expected, _ := db.BuildSnapshot(clone)
+	if string(data) != string(expected) {
+		t.Errorf("Expected the snapshot written to match BuildSnapshot\n")
+	}
+	if manifest.LastApplied != 0 {
+		t.Errorf("Expected snapshot as of index 0, got %d\n", manifest.LastApplied)
+	}
+	restored, _ := db.InstallSnapshot(data)
+	if v := restored.Get("key1"); v != "before" {
+		t.Errorf("Expected key1=before in snapshot, got %s\n", v)
+	}
+
+	// a snapshot that can't be written in time is removed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limited := ratelimit.New(1024)
+	limited.Take(1024)
+	failedPath := filepath.Join(filepath.Dir(snapshotPath), prefix+"000002")
+	if err := writeSnapshot(ctx, clone, &snapshotManifest{}, failedPath, limited); err == nil {
+		t.Errorf("Expected writing snapshot to fail once cancelled\n")
+	}
+	if _, err := os.Stat(failedPath); !os.IsNotExist(err) {
+		t.Errorf("Expected partial snapshot to be removed\n")
+	}
+}
+
+func TestLoadSnapshotRestoresApplied(t *testing.T) {
+	n := setupServer(t)
+	n.Log = &raft.LogStore{
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_SET, Key: "ice", Value: "cream"},
+			{Term: 1, Action: raft.LogRecord_SET, Key: "straw", Value: "bale"}}}
+
+	source := db.NewDatabase()
+	source.Set("ice", "cream")
+	source.Set("straw", "bale")
+	snapshot, _ := db.BuildSnapshot(source)
+
+	testDir := setupTestDir(t)
+	snapshotPath := filepath.Join(testDir, prefix+"000001")
+	if err := persist(snapshot, snapshotPath); err != nil {
+		t.Fatalf("Error persisting snapshot: %v\n", err)
+	}
+	err := persistManifest(
+		&snapshotManifest{LastApplied: 1, LastTerm: 1}, manifestPath(snapshotPath))
+	if err != nil {
+		t.Fatalf("Error persisting manifest: %v\n", err)
+	}
+
+	if err := loadSnapshot(n, snapshotPath); err != nil {
+		t.Fatalf("Error loading snapshot: %v\n", err)
+	}
+	if n.LastApplied != 1 {
+		t.Errorf("Expected applied index 1 after load, got %d\n", n.LastApplied)
+	}
+	if n.CommitIndex != 1 {
+		t.Errorf("Expected commit index 1 after load, got %d\n", n.CommitIndex)
+	}
+	if v := n.Store.Get("straw"); v != "bale" {
+		t.Errorf("Expected straw bale but got straw %s\n", v)
+	}
+
+	remaining := dropOldSnapshots([]string{snapshotPath}, 0)
+	if len(remaining) != 0 {
+		t.Errorf("Expected no snapshots to remain, got %d\n", len(remaining))
+	}
+	if _, err := os.Stat(manifestPath(snapshotPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected manifest to be removed along with snapshot\n")
+	}
+}
+
+func TestShouldSnapshot(t *testing.T) {
+	testCases := []struct {
+		name           string
+		size           int64
+		threshold      int64
+		entries        int64
+		entryThreshold int64
+		expected       bool
+	}{
+		{"under both thresholds", 100, 1000, 5, 10, false},
+		{"over byte threshold", 2000, 1000, 5, 10, true},
+		{"over entry threshold", 100, 1000, 10, 10, true},
+		{"entry threshold disabled", 100, 1000, 50, 0, false},
+		{"byte threshold disabled", 2000, 0, 5, 10, false},
+		{"nothing applied since snapshot", 2000, 1000, 0, 10, false}}
+
+	for _, tc := range testCases {
+		actual := shouldSnapshot(tc.size, tc.threshold, tc.entries, tc.entryThreshold)
+		if actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v\n", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestCompactLog(t *testing.T) {
+	config := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.State = node.Leader
+	for i := 0; i < 10; i++ {
+		n.Set(context.Background(), fmt.Sprintf("key%d", i), "value")
+	}
+	size := fileSize(config.LogFile)
+	before := reclaimedBytesCounter.Value()
+
+	info := compactLog(n, n.LastApplied+1-3)
+	if info == nil || info.Entries != 7 || info.BaseIndex != 7 {
+		t.Errorf("Expected compaction of 7 entries to be described, got %+v\n", info)
+	}
+	if n.Log.BaseIndex != 7 {
+		t.Errorf("Expected base index 7 after compaction, got %d\n", n.Log.BaseIndex)
+	}
+	if n.LastLogIndex() != 9 {
+		t.Errorf("Expected last log index 9 after compaction, got %d\n", n.LastLogIndex())
+	}
+	reclaimed := reclaimedBytesCounter.Value() - before
+	if reclaimed <= 0 || int64(reclaimed) != size-fileSize(config.LogFile) {
+		t.Errorf("Expected reclaimed bytes to match shrinkage of log, got %v\n", reclaimed)
+	}
+}
+
+func TestTriggerSnapshot(t *testing.T) {
+	config := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.State = node.Leader
+	n.Set(context.Background(), "ice", "cream")
+
+	m := StartSnapshotManager(
+		config.DataDir, config.LogFile, 0, 0, time.Hour, 2, 0, n)
+	if status := m.Status(); status.Count != 0 || status.Latest != nil {
+		t.Fatalf("Expected no snapshots yet, got %+v\n", status)
+	}
+	m.Trigger()
+	if !m.Status().InProgress {
+		t.Error("Expected snapshot to be in progress once triggered")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Status().InProgress && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := m.Status()
+	if status.Count != 1 || status.Latest == nil || status.Latest.LastApplied != 0 {
+		t.Fatalf("Expected one snapshot covering entry 0, got %+v\n", status)
+	}
+	if status.Latest.Bytes != fileSize(status.Latest.File) || status.Latest.Bytes == 0 {
+		t.Errorf("Expected size of snapshot file, got %d\n", status.Latest.Bytes)
+	}
+}
+
+func TestCompactNow(t *testing.T) {
+	config := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.State = node.Leader
+	for i := 0; i < 10; i++ {
+		n.Set(context.Background(), fmt.Sprintf("key%d", i), "value")
+	}
+
+	// the usual retention would keep every entry
+	m := StartSnapshotManager(
+		config.DataDir, config.LogFile, 0, 0, time.Hour, 2, 100, n)
+	m.Compact(2)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Status().InProgress && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := m.Status()
+	if status.LastCompaction == nil || status.LastCompaction.Entries != 8 {
+		t.Fatalf("Expected compaction of 8 entries, got %+v\n", status)
+	}
+	if n.Log.BaseIndex != 8 {
+		t.Errorf("Expected base index 8 after compaction, got %d\n", n.Log.BaseIndex)
+	}
+	if status.LastCompaction.Time.IsZero() || status.LastCompaction.ReclaimedBytes <= 0 {
+		t.Errorf("Expected time and reclaimed bytes of compaction, got %+v\n", status.LastCompaction)
+	}
+}
+
+func TestCorruptSnapshotQuarantines(t *testing.T) {
+	config := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.State = node.Leader
+	n.Set(context.Background(), "ice", "cream")
+
+	snapshot, manifest, err := cloneAndSerialize(n)
+	if err != nil {
+		t.Fatalf("Error building snapshot: %v\n", err)
+	}
+	snapshotPath := filepath.Join(config.DataDir, prefix+"000001")
+	snapshot[len(snapshot)-1] ^= 0xff
+	if err := persist(snapshot, snapshotPath); err != nil {
+		t.Fatalf("Error persisting snapshot: %v\n", err)
+	}
+	if err := persistManifest(manifest, manifestPath(snapshotPath)); err != nil {
+		t.Fatalf("Error persisting manifest: %v\n", err)
+	}
+	if err := loadSnapshot(n, snapshotPath); err != ErrSnapshotCorrupt {
+		t.Errorf("Expected ErrSnapshotCorrupt, got %v\n", err)
+	}
+
+	restarted, _ := node.NewNode(config, db.NewDatabase())
+	StartSnapshotManager(config.DataDir, config.LogFile, 0, 0, time.Hour, 2, 0, restarted)
+	if !restarted.Quarantined() {
+		t.Error("Expected node with a corrupt snapshot to be quarantined")
+	}
+}
+
+func TestVerifyAndRestoreSnapshot(t *testing.T) {
+	config := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.State = node.Leader
+	n.Set(context.Background(), "ice", "cream")
+	n.Set(context.Background(), "straw", "bale")
+
+	snapshot, manifest, err := cloneAndSerialize(n)
+	if err != nil {
+		t.Fatalf("Error building snapshot: %v\n", err)
+	}
+	backupDir, err := ioutil.TempDir("", "leifdb-backup")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v\n", err)
+	}
+	defer os.RemoveAll(backupDir)
+	backup := filepath.Join(backupDir, "backup.ldb")
+	if err := persist(snapshot, backup); err != nil {
+		t.Fatalf("Error persisting snapshot: %v\n", err)
+	}
+	if _, err := VerifySnapshot(backup); err != ErrNoManifest {
+		t.Errorf("Expected ErrNoManifest without a manifest, got %v\n", err)
+	}
+	if err := persistManifest(manifest, backup+BackupManifestSuffix); err != nil {
+		t.Fatalf("Error persisting manifest: %v\n", err)
+	}
+	info, err := VerifySnapshot(backup)
+	if err != nil || info.LastApplied != 1 || info.Sha256 != contentHash(snapshot) {
+		t.Errorf("Expected intact snapshot as of entry 1, got %+v (%v)\n", info, err)
+	}
+
+	corrupt := append([]byte{}, snapshot...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := persist(corrupt, backup); err != nil {
+		t.Fatalf("Error persisting snapshot: %v\n", err)
+	}
+	dataDir, err := ioutil.TempDir("", "leifdb")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v\n", err)
+	}
+	defer os.RemoveAll(dataDir)
+	if _, err := RestoreSnapshot(backup, dataDir); err != ErrSnapshotCorrupt {
+		t.Errorf("Expected ErrSnapshotCorrupt, got %v\n", err)
+	}
+	if files, _ := ioutil.ReadDir(dataDir); len(files) != 0 {
+		t.Errorf("Expected nothing restored from a corrupt snapshot, got %d files\n", len(files))
+	}
+
+	persist(snapshot, backup)
+	if _, err := RestoreSnapshot(backup, config.DataDir); err != ErrDataDirInUse {
+		t.Errorf("Expected ErrDataDirInUse, got %v\n", err)
+	}
+	if _, err := RestoreSnapshot(backup, dataDir); err != nil {
+		t.Fatalf("Error restoring snapshot: %v\n", err)
+	}
+	restoredConfig := node.NewNodeConfig(dataDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	if err := node.ValidateState(restoredConfig, LatestSnapshotMeta(dataDir)); err != nil {
+		t.Errorf("Expected restored state to be consistent, got %v\n", err)
+	}
+	restored, _ := node.NewNode(restoredConfig, db.NewDatabase())
+	StartSnapshotManager(dataDir, restoredConfig.LogFile, 0, 0, time.Hour, 2, 0, restored)
+	if restored.Store.Get("straw") != "bale" || restored.LastApplied != 1 || restored.Quarantined() {
+		t.Errorf("Expected restored node to start from the snapshot, got applied index %d\n", restored.LastApplied)
+	}
+}
+
+func TestReseed(t *testing.T) {
+	leaderConfig := node.NewNodeConfig(
+		setupTestDir(t), "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	leaderNode, _ := node.NewNode(leaderConfig, db.NewDatabase())
+	leaderNode.State = node.Leader
+	leaderNode.Set(context.Background(), "ice", "cream")
+	leaderNode.Set(context.Background(), "straw", "bale")
+	leaderSnapshots := StartSnapshotManager(
+		leaderConfig.DataDir, leaderConfig.LogFile, 0, 0, time.Hour, 2, 0, leaderNode)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot, info, err := leaderSnapshots.Snapshot()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Leifdb-Last-Applied", strconv.FormatInt(info.LastApplied, 10))
+		w.Header().Set("X-Leifdb-Last-Term", strconv.FormatInt(info.LastTerm, 10))
+		w.Header().Set("X-Leifdb-Checksum", strconv.FormatUint(uint64(info.Checksum), 10))
+		w.Header().Set("X-Leifdb-Sha256", info.Sha256)
+		w.Header().Set("X-Leifdb-Applied-Hash", strconv.FormatUint(info.AppliedHash, 10))
+		w.Write(snapshot)
+	}))
+	defer server.Close()
+
+	dataDir, err := ioutil.TempDir("", "leifdb")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v\n", err)
+	}
+	defer os.RemoveAll(dataDir)
+	config := node.NewNodeConfig(dataDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	n, _ := node.NewNode(config, db.NewDatabase())
+	n.CheckForeignNode = checkMock
+	m := StartSnapshotManager(dataDir, config.LogFile, 0, 0, time.Hour, 2, 0, n)
+	if err := m.Reseed(context.Background()); err != ErrNoLeader {
+		t.Errorf("Expected ErrNoLeader with no known leader, got %v\n", err)
+	}
+
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       &raft.Node{Id: "localhost:16990", ClientAddr: strings.TrimPrefix(server.URL, "http://")},
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+	n.Quarantine("test")
+	if err := m.Reseed(context.Background()); err != nil {
+		t.Fatalf("Error reseeding: %v\n", err)
+	}
+	if n.Quarantined() {
+		t.Error("Expected reseed to lift the quarantine")
+	}
+	if v := n.Store.Get("straw"); v != "bale" || n.LastApplied != 1 {
+		t.Errorf("Expected leader's state as of entry 1, got straw %s at %d\n", v, n.LastApplied)
+	}
+	if _, hash := n.AppliedHash(); hash == 0 {
+		t.Error("Expected leader's applied hash after reseed")
+	}
+	status := m.Status()
+	if status.Count != 1 || status.Latest == nil || status.Latest.LastApplied != 1 {
+		t.Errorf("Expected the leader's snapshot to be kept on disk, got %+v\n", status)
+	}
+}
+
+func TestInspectDataDir(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "leifdb")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v\n", err)
+	}
+	defer os.RemoveAll(dataDir)
+	files := map[string]int{
+		"raftlog":             10,
+		"term":                3,
+		prefix + "000001":     20,
+		manifestPrefix + "01": 5}
+	for name, size := range files {
+		if err := ioutil.WriteFile(filepath.Join(dataDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Error writing %s: %v\n", name, err)
+		}
+	}
+
+	usage, err := InspectDataDir(dataDir, filepath.Join(dataDir, "raftlog"))
+	if err != nil {
+		t.Fatalf("Error inspecting data dir: %v\n", err)
+	}
+	if usage.TotalBytes != 38 || len(usage.Files) != 4 {
+		t.Errorf("Expected 4 files totalling 38 bytes, got %+v\n", usage)
+	}
+	expected := []DiskComponent{
+		{Name: ComponentLog, Files: 1, Bytes: 10},
+		{Name: ComponentSnapshots, Files: 2, Bytes: 25},
+		{Name: ComponentOther, Files: 1, Bytes: 3}}
+	if !reflect.DeepEqual(usage.Components, expected) {
+		t.Errorf("Expected components %+v, got %+v\n", expected, usage.Components)
+	}
+	if usage.VolumeFreeBytes == nil || *usage.VolumeBytes < *usage.VolumeFreeBytes {
+		t.Errorf("Expected free space on the volume, got %+v\n", usage)
+	}
+}
@@ -6,129 +6,172 @@ package mgmt
 // Node should still not have any more awareness of StateManager, if for no
 // other reason than to prevent circular dependencies.
 // [todo: add a ref to Node and simplify StateManager logic]
+//
+// Timing is counted in ticks of tickInterval from a clock.Clock, rather than
+// with a timer per state: each tick advances the election timer of a follower,
+// or the append and grace window timers of a leader, and runs a job whose
+// timer has expired. With a clock.Fake, a test drives the manager tick by tick
+// by advancing the clock, and the jobs run before the call returns.
 import (
+	"sync"
 	"time"
 
+	"github.com/btmorr/leifdb/internal/clock"
 	"github.com/btmorr/leifdb/internal/node"
 )
 
-// There is also a virtual role of Candidate when an election is in progress,
-// but for the StateManager this is not different from Follower
-
-type state interface {
-	stop()
-	restart()
-	stateType() node.Role
-}
-
-type leaderState struct {
-	done chan bool
-	job  func()
-}
+// tickInterval is the resolution of the StateManager's timers
+const tickInterval = time.Millisecond
 
-func (l *leaderState) stop() {
-	l.done <- true
-	return
+// ticks returns the number of ticks in d, rounded up (and at least one)
+func ticks(d time.Duration) int {
+	n := int((d + tickInterval - 1) / tickInterval)
+	if n < 1 {
+		return 1
+	}
+	return n
 }
 
-func (l *leaderState) restart() {}
+// There is also a virtual role of Candidate when an election is in progress,
+// but for the StateManager this is not different from Follower
 
-func (l leaderState) stateType() node.Role {
-	return node.Leader
+// StateManager handles the aspects of the Raft protocol that require timing
+type StateManager struct {
+	lock sync.Mutex
+	role node.Role
+	// ticks since the election timer was reset (Follower) or since the last
+	// append job (Leader)
+	elapsed int
+	// ticks until the election timer expires, including any backoff after a
+	// failed election
+	timeout int
+	// ticks left in the grace window after an election (0 if not in one)
+	grace int
+
+	electionTicks   int
+	electionJob     func() bool
+	electionBackoff func() time.Duration
+	graceTicks      int
+	graceEndJob     func()
+	appendTicks     int
+	appendJob       func()
+	stopTicks       func()
+	done            chan struct{}
+	stopOnce        sync.Once
 }
 
-func newLeaderState(job func(), interval time.Duration, grace time.Duration, graceJob func()) *leaderState {
-	l := &leaderState{done: make(chan bool), job: job}
-	l.job()
-	graceCountdown := time.NewTimer(grace)
-	go func() {
-		<-graceCountdown.C
-		graceJob()
-	}()
-	appendTicker := time.NewTicker(interval)
-	go func() {
-		for {
-			select {
-			case <-l.done:
-				return
-			case <-appendTicker.C:
-				l.job()
-			default:
-			}
+// tick advances the timers of the current state by one tick, running the job
+// of any that expires
+func (s *StateManager) tick() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if s.grace > 0 {
+		s.grace--
+		if s.grace == 0 {
+			s.graceEndJob()
 		}
-	}()
-	return l
-}
-
-type followerState struct {
-	timer    *time.Timer
-	timeout  time.Duration
-	election chan bool
-}
-
-func (f *followerState) stop() {
-	if !f.timer.Stop() {
-		select {
-		case <-f.timer.C:
-		default:
+	}
+	s.elapsed++
+	if s.role == node.Leader {
+		if s.elapsed >= s.appendTicks {
+			s.elapsed = 0
+			s.appendJob()
 		}
+		return
+	}
+	if s.elapsed >= s.timeout {
+		s.runElection()
 	}
-	return
 }
 
-func (f *followerState) restart() {
-	f.stop()
-	f.timer.Reset(f.timeout)
-	return
+// runElection restarts the election timer and runs the election job, then
+// changes state according to the result
+func (s *StateManager) runElection() {
+	s.restartTimer()
+	// Note that "Candidate" state is a virtual state--while the `electionJob`
+	// runs, the state of the node corresponds to the Candidate state, but the
+	// behavior is not meaningfully different from during a Follower period
+	// (from the perspective of the StateManager). The `electionJob` function
+	// should perform any side-effects that are unique to the Candidate state.
+	if s.electionJob() {
+		s.becomeLeader()
+		return
+	}
+	var delay time.Duration
+	if s.electionBackoff != nil {
+		delay = s.electionBackoff()
+	}
+	s.becomeFollower(delay)
 }
 
-func (f followerState) stateType() node.Role {
-	return node.Follower
+// becomeLeader changes the state to Leader, running the append job at once
+// and starting the grace window
+func (s *StateManager) becomeLeader() {
+	s.role = node.Leader
+	s.elapsed = 0
+	s.grace = s.graceTicks
+	s.appendJob()
 }
 
-func newFollowerState(signal chan bool, timeout time.Duration) *followerState {
-	t := time.NewTimer(timeout)
-	f := &followerState{timer: t, timeout: timeout, election: signal}
-	go func() {
-		<-t.C
-		f.election <- true
-	}()
-	return f
+// becomeFollower changes the state to Follower, delaying the first election
+// timeout by delay
+func (s *StateManager) becomeFollower(delay time.Duration) {
+	s.role = node.Follower
+	s.elapsed = 0
+	s.timeout = s.electionTicks
+	if delay > 0 {
+		s.timeout += ticks(delay)
+	}
 }
 
-// StateManager handles the aspects of the Raft protocol that require timing
-type StateManager struct {
-	state           state
-	electionFlag    chan bool
-	electionTimeout time.Duration
-	graceWindow     time.Duration
-	graceEndJob     func()
-	appendInterval  time.Duration
-	appendJob       func()
+// restartTimer restarts the countdown on the election timer
+func (s *StateManager) restartTimer() {
+	s.elapsed = 0
+	s.timeout = s.electionTicks
 }
 
-func (s *StateManager) changeState(newState node.Role) {
-	s.state.stop()
-	if newState == node.Leader {
-		s.state = newLeaderState(s.appendJob, s.appendInterval, s.graceWindow, s.graceEndJob)
-	} else {
-		s.state = newFollowerState(s.electionFlag, s.electionTimeout)
-	}
+// Role returns the current state, Leader or Follower
+func (s *StateManager) Role() node.Role {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.role
 }
 
 // ResetTimer restarts the countdown on the election timer if the current state
 // is Follower (does nothing if the current state is Leader)
 func (s *StateManager) ResetTimer() {
-	s.state.restart()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.role != node.Leader {
+		s.restartTimer()
+	}
 }
 
 // BecomeFollower explicitly changes the state to Follower
 func (s *StateManager) BecomeFollower() {
-	s.changeState(node.Follower)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.becomeFollower(0)
+	s.grace = 0
 	s.graceEndJob()
 }
 
+// Stop stops the timers, so the node no longer starts elections or (as leader)
+// sends append requests
+func (s *StateManager) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.stopTicks()
+	})
+}
+
 // NewStateManager creates a StateManager with state initialized to Follower
+// clk is the source of ticks for the timers (clock.Real outside of tests)
 // followFlag is a channel that indicates the node should reset the election
 //    timer, including becoming a Follower if the current state is Leader
 // electionTimeout is the duration a node should wait before starting an
@@ -137,48 +180,42 @@ func (s *StateManager) BecomeFollower() {
 // electionJob is a function that is called when the election timer expires,
 //     which should return a boolean designating whether the node should become
 //     a Leader (on true), or remain a Follower (on false)
+// electionBackoff, if not nil, is called after a failed election for extra
+//     time to wait before the next one (see Node.ElectionBackoff)
 // appendInterval is the period between append requests when a node is a Leader
-//    The ticker ticks on this period, and calls appendJob
+//    The appendJob is called once per appendInterval
 // appendJob is the task that a Leader should perform after each appendInterval
 func NewStateManager(
+	clk clock.Clock,
 	resetFlag chan bool,
 	electionTimeout time.Duration,
 	electionJob func() bool,
+	electionBackoff func() time.Duration,
 	graceWindow time.Duration,
 	graceEndJob func(),
 	appendInterval time.Duration,
 	appendJob func()) *StateManager {
 
-	c := make(chan bool)
 	s := &StateManager{
-		state:           newFollowerState(c, electionTimeout),
-		electionFlag:    c,
-		electionTimeout: electionTimeout,
-		graceWindow:     graceWindow,
+		role:            node.Follower,
+		electionTicks:   ticks(electionTimeout),
+		electionJob:     electionJob,
+		electionBackoff: electionBackoff,
+		graceTicks:      ticks(graceWindow),
 		graceEndJob:     graceEndJob,
-		appendInterval:  appendInterval,
-		appendJob:       appendJob}
+		appendTicks:     ticks(appendInterval),
+		appendJob:       appendJob,
+		done:            make(chan struct{})}
+	s.timeout = s.electionTicks
+	s.stopTicks = clk.Every(tickInterval, s.tick)
 
 	go func() {
 		for {
 			select {
-			case <-s.electionFlag:
-				s.ResetTimer()
-				// Note that "Candidate" state is a virtual state--in between this
-				// `ResetTimer` call and the return of the following `electionJob`, the
-				// state of the node corresponds to the Candidate state, but the
-				// behavior is not meaningfully different from during a Follower
-				// period (from the perspective of the StateManager). The `electionJob`
-				// function should perform any side-effects that are unique to the
-				// Candidate state.
-				if electionJob() {
-					s.changeState(node.Leader)
-				} else {
-					s.changeState(node.Follower)
-				}
 			case <-resetFlag:
 				s.BecomeFollower()
-			default:
+			case <-s.done:
+				return
 			}
 		}
 	}()
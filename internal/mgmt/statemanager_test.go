@@ -1,8 +1,4 @@
-// +build mgmttest
-
-// This test is timing-dependent, and fails if run along with the rest of the
-// test suite. Until we have a better way of testing the timer logic, this test
-// is run separately on CI
+// +build unit
 
 package mgmt
 
@@ -10,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/btmorr/leifdb/internal/clock"
 	"github.com/btmorr/leifdb/internal/node"
 )
 
@@ -22,15 +19,16 @@ func TestManager(t *testing.T) {
 	appendCounter := 0
 	electionShouldSucceed := true
 
-	resetFlag := make(chan bool)
-
+	clk := clock.NewFake(time.Now())
 	mgmt := NewStateManager(
-		resetFlag,
+		clk,
+		make(chan bool),
 		electionTimeout,
 		func() bool { // election job
 			electionCounter++
 			return electionShouldSucceed
 		},
+		nil, // no backoff
 		minimumTimeout,
 		func() {}, // grace window job (not checked here)
 		appendInterval,
@@ -38,119 +36,152 @@ func TestManager(t *testing.T) {
 			appendCounter++
 			return
 		})
+	defer mgmt.Stop()
 
-	if mgmt.state.stateType() != node.Follower {
-		t.Errorf(
-			"Expected state to be Follower but got %s\n", mgmt.state.stateType())
+	if mgmt.Role() != node.Follower {
+		t.Errorf("Expected state to be Follower but got %s\n", mgmt.Role())
+	}
+	clk.Advance(electionTimeout - time.Millisecond)
+	if electionCounter != 0 {
+		t.Errorf("Expected no election before the timeout, got %d\n", electionCounter)
 	}
-	time.Sleep(electionTimeout + time.Millisecond*2)
-	time.Sleep(appendInterval)
+	clk.Advance(time.Millisecond)
 	if electionCounter != 1 {
 		t.Errorf("Expected %d elections, got %d\n", 1, electionCounter)
 	}
-	if mgmt.state.stateType() != node.Leader {
-		t.Errorf(
-			"Expected state to be Leader after election, but got %s\n",
-			mgmt.state.stateType())
+	if mgmt.Role() != node.Leader {
+		t.Errorf("Expected state to be Leader after election, but got %s\n", mgmt.Role())
 	}
-	// Is there a better way to test ticker behavior? I'd prefer not to have
-	// a time.Sleep in a test, but not sure how else to deal with this. Made
-	// the timeouts as short as possible, but any shorter and the job func
-	// throws the timing.
+	// an append is sent on becoming leader, then once per interval
 	n := 5
-	for i := 0; i <= n; i++ {
-		time.Sleep(appendInterval)
-	}
-	if appendCounter < n {
-		t.Errorf("Expected at least %d appends, got %d\n", n, appendCounter)
+	clk.Advance(time.Duration(n) * appendInterval)
+	if appendCounter != n+1 {
+		t.Errorf("Expected %d appends, got %d\n", n+1, appendCounter)
 	}
+
 	electionShouldSucceed = false
-	go func() {
-		resetFlag <- true
-	}()
-	time.Sleep(time.Millisecond * 2)
-	if mgmt.state.stateType() != node.Follower {
-		t.Errorf(
-			"Expected state to be Follower, but got %s\n", mgmt.state.stateType())
-	}
-	time.Sleep(electionTimeout + time.Millisecond*2)
-	time.Sleep(appendInterval)
+	mgmt.BecomeFollower()
+	if mgmt.Role() != node.Follower {
+		t.Errorf("Expected state to be Follower, but got %s\n", mgmt.Role())
+	}
+	clk.Advance(electionTimeout)
 	if electionCounter != 2 {
 		t.Errorf("Expected %d elections, got %d\n", 2, electionCounter)
 	}
-	if mgmt.state.stateType() != node.Follower {
-		t.Errorf(
-			"Expected state to be Follower after failed election, but got %s\n",
-			mgmt.state.stateType())
-	}
-	time.Sleep(electionTimeout / time.Duration(2))
-	go func() {
-		resetFlag <- true
-	}()
-	time.Sleep(electionTimeout / time.Duration(2))
-	go func() {
-		resetFlag <- true
-	}()
-	time.Sleep(electionTimeout / time.Duration(2))
-	go func() {
-		resetFlag <- true
-	}()
+	if mgmt.Role() != node.Follower {
+		t.Errorf("Expected state to be Follower after failed election, but got %s\n", mgmt.Role())
+	}
+	for i := 0; i < 3; i++ {
+		clk.Advance(electionTimeout / 2)
+		mgmt.ResetTimer()
+	}
 	if electionCounter != 2 {
 		t.Errorf("Expected %d elections, got %d\n", 2, electionCounter)
 	}
-	if mgmt.state.stateType() != node.Follower {
-		t.Errorf(
-			"Expected state to still be Follower after resets, but got %s\n",
-			mgmt.state.stateType())
-	}
-	time.Sleep(electionTimeout + time.Millisecond*2)
-	time.Sleep(appendInterval)
+	clk.Advance(electionTimeout)
 	if electionCounter != 3 {
 		t.Errorf("Expected %d elections, got %d\n", 3, electionCounter)
 	}
 }
 
+func TestElectionBackoff(t *testing.T) {
+	electionTimeout := 100 * time.Millisecond
+	backoff := 50 * time.Millisecond
+	elections := 0
+
+	clk := clock.NewFake(time.Now())
+	mgmt := NewStateManager(
+		clk,
+		make(chan bool),
+		electionTimeout,
+		func() bool {
+			elections++
+			return false
+		},
+		func() time.Duration { return backoff },
+		electionTimeout,
+		func() {},
+		10*time.Millisecond,
+		func() {})
+	defer mgmt.Stop()
+
+	clk.Advance(electionTimeout)
+	clk.Advance(electionTimeout + backoff - time.Millisecond)
+	if elections != 1 {
+		t.Errorf("Expected the next election to wait for the backoff, got %d elections", elections)
+	}
+	clk.Advance(time.Millisecond)
+	if elections != 2 {
+		t.Errorf("Expected %d elections, got %d", 2, elections)
+	}
+}
+
 func TestGraceWindow(t *testing.T) {
 	electionTimeout := time.Second / 4
 	minimumTimeout := electionTimeout / 2
 	appendInterval := time.Millisecond * 20
 
-	electionCounter := 0
-	appendCounter := 0
 	electionShouldSucceed := true
 	allowVote := true
 
-	resetFlag := make(chan bool)
-
-	NewStateManager(
-		resetFlag,
+	clk := clock.NewFake(time.Now())
+	mgmt := NewStateManager(
+		clk,
+		make(chan bool),
 		electionTimeout,
 		func() bool { // election job
-			electionCounter++
 			if electionShouldSucceed {
 				allowVote = false
 			}
 			return electionShouldSucceed
 		},
+		nil, // no backoff
 		minimumTimeout,
 		func() {
 			allowVote = true
 		},
 		appendInterval,
-		func() { // append job
-			appendCounter++
-			return
-		})
+		func() {})
+	defer mgmt.Stop()
 
 	if !allowVote {
 		t.Error("AllowVote should be true for follower")
 	}
-	time.Sleep(electionTimeout + appendInterval)
+	clk.Advance(electionTimeout)
 	if allowVote {
 		t.Error("AllowVote should be false immediately after becoming leader")
 	}
-	time.Sleep(minimumTimeout + appendInterval)
+	clk.Advance(minimumTimeout - time.Millisecond)
+	if allowVote {
+		t.Error("AllowVote should be false until the grace window expires")
+	}
+	clk.Advance(time.Millisecond)
 	if !allowVote {
 		t.Error("AllowVote should be true after grace window expires")
 	}
 }
+
+func TestStop(t *testing.T) {
+	elections := 0
+	clk := clock.NewFake(time.Now())
+	mgmt := NewStateManager(
+		clk,
+		make(chan bool),
+		10*time.Millisecond,
+		func() bool {
+			elections++
+			return false
+		},
+		nil,
+		10*time.Millisecond,
+		func() {},
+		time.Millisecond,
+		func() {})
+
+	mgmt.Stop()
+	mgmt.Stop()
+	clk.Advance(time.Second)
+	if elections != 0 {
+		t.Errorf("Expected no elections after Stop, got %d", elections)
+	}
+}
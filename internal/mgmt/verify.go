@@ -0,0 +1,158 @@
+package mgmt
+
+// Each snapshot's manifest records a SHA-256 content hash of it, which is
+// checked whenever the snapshot is read: when a node loads its latest snapshot,
+// when a reseed downloads one from the leader, and before a backup saved by
+// leifctl is restored into a data directory. A corrupt backup is detected
+// before it replaces a node's state, rather than after it has been replicated
+// to the rest of the cluster. Manifests written before the hash was recorded
+// are checked against their CRC-32 instead.
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// BackupManifestSuffix is appended to the name of a snapshot saved outside a
+// data directory (such as by `leifctl snapshot save`) to name its manifest
+const BackupManifestSuffix = ".manifest"
+
+var (
+	// ErrNoManifest indicates that a snapshot has no manifest to verify it
+	// against
+	ErrNoManifest = errors.New("Snapshot has no manifest")
+
+	// ErrDataDirInUse indicates a restore into a data directory that already
+	// holds a node's state
+	ErrDataDirInUse = errors.New("Data directory already holds a node's state")
+)
+
+// contentHash returns the hex-encoded SHA-256 of a snapshot
+func contentHash(snapshot []byte) string {
+	sum := sha256.Sum256(snapshot)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyContent returns ErrSnapshotCorrupt if snapshot does not match the
+// content hash in its manifest (or, if there is none, the checksum)
+func verifyContent(snapshot []byte, manifest *snapshotManifest) error {
+	switch {
+	case manifest.Sha256 != "":
+		if contentHash(snapshot) != manifest.Sha256 {
+			return ErrSnapshotCorrupt
+		}
+	case manifest.Checksum != 0:
+		if crc32.ChecksumIEEE(snapshot) != manifest.Checksum {
+			return ErrSnapshotCorrupt
+		}
+	}
+	return nil
+}
+
+// backupManifest reads the manifest of a snapshot saved outside a data
+// directory, or of one in a data directory if there is none, returning nil if
+// neither exists
+func backupManifest(snapshotPath string) (*snapshotManifest, error) {
+	data, err := ioutil.ReadFile(snapshotPath + BackupManifestSuffix)
+	if os.IsNotExist(err) {
+		return readManifest(snapshotPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// VerifySnapshot checks that a snapshot file matches the content hash in its
+// manifest and can be decoded, and returns a description of it. The manifest
+// is the file named with BackupManifestSuffix, or the one beside the snapshot
+// in a data directory. Returns ErrNoManifest if there is neither, or
+// ErrSnapshotCorrupt
+func VerifySnapshot(snapshotPath string) (*SnapshotInfo, error) {
+	_, manifest, err := verifySnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	info := describeSnapshot(snapshotPath)
+	info.LastApplied, info.LastTerm = manifest.LastApplied, manifest.LastTerm
+	info.Checksum, info.AppliedHash = manifest.Checksum, manifest.AppliedHash
+	info.Sha256 = manifest.Sha256
+	return info, nil
+}
+
+// verifySnapshot reads a snapshot file and its manifest, and checks them (see
+// VerifySnapshot)
+func verifySnapshot(snapshotPath string) ([]byte, *snapshotManifest, error) {
+	manifest, err := backupManifest(snapshotPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest == nil || (manifest.Sha256 == "" && manifest.Checksum == 0) {
+		return nil, nil, ErrNoManifest
+	}
+	data, err := ioutil.ReadFile(snapshotPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyContent(data, manifest); err != nil {
+		return nil, nil, err
+	}
+	if _, err := db.InstallSnapshot(data); err != nil {
+		return nil, nil, ErrSnapshotCorrupt
+	}
+	return data, manifest, nil
+}
+
+// RestoreSnapshot verifies a snapshot file (see VerifySnapshot), then installs
+// it in dataDir, along with a log and term file that start where it ends, so
+// that a node started from dataDir begins with the snapshot's state. Returns
+// ErrDataDirInUse if dataDir already has a log, term file, or snapshot (the
+// node must be stopped, and its state moved aside, first)
+func RestoreSnapshot(snapshotPath string, dataDir string) (*SnapshotInfo, error) {
+	data, manifest, err := verifySnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	config := node.NewNodeConfig(dataDir, "", "", nil)
+	for _, filename := range []string{config.LogFile, config.TermFile} {
+		if _, err := os.Stat(filename); err == nil {
+			return nil, ErrDataDirInUse
+		}
+	}
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if component(file.Name(), "") == ComponentSnapshots {
+			return nil, ErrDataDirInUse
+		}
+	}
+
+	restored := filepath.Join(dataDir, prefix+"000000")
+	if err := persist(data, restored); err != nil {
+		return nil, err
+	}
+	if err := persistManifest(manifest, manifestPath(restored)); err != nil {
+		return nil, err
+	}
+	logStore := &raft.LogStore{
+		Entries:   make([]*raft.LogRecord, 0, 0),
+		BaseIndex: manifest.LastApplied + 1,
+		BaseTerm:  manifest.LastTerm}
+	if err := node.WriteLogs(config.LogFile, logStore); err != nil {
+		return nil, err
+	}
+	if err := node.WriteTerm(config.TermFile, &raft.TermRecord{Term: manifest.LastTerm}); err != nil {
+		return nil, err
+	}
+	return VerifySnapshot(restored)
+}
@@ -0,0 +1,128 @@
+package node
+
+// When many entries are committed together (a follower catching up, or a bulk
+// import), applying each to the database on its own builds a new version of
+// the database's trees per entry, most of which is thrown away by the next.
+// Instead, runs of committed entries that only set, delete, or change the
+// expiry of keys are applied in a single write batch (see Database.Update), of
+// up to ApplyBatchSize entries. Readers see the whole batch at once, and never
+// part of one. Apply hooks are called for each entry, in order, once the batch
+// is committed. Other entries, whose results or hooks depend on
+// the state just before or after them, are applied one at a time.
+
+import (
+	"time"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// DefaultApplyBatchSize is the default limit on entries applied as one batch
+const DefaultApplyBatchSize = 256
+
+// Batches applied, and the entries in them: the ratio is the mean batch size
+var (
+	applyBatches = metrics.NewCounter(
+		"leifdb_apply_batches_total",
+		"Batches of committed entries applied to the database together")
+	applyBatchedEntries = metrics.NewCounter(
+		"leifdb_apply_batched_entries_total",
+		"Committed entries applied to the database as part of a batch")
+)
+
+// batchable reports whether an entry can be applied as part of a batch
+func batchable(entry *raft.LogRecord) bool {
+	switch entry.Action {
+	case raft.LogRecord_SET, raft.LogRecord_DEL, raft.LogRecord_TOUCH, raft.LogRecord_PERSIST:
+		return true
+	}
+	return false
+}
+
+// applyMutation applies an entry that sets, deletes, or changes the expiry of
+// a key to store
+func applyMutation(store *db.Database, entry *raft.LogRecord) {
+	switch entry.Action {
+	case raft.LogRecord_SET:
+		logger.Trace().
+			Str("key", entry.Key).
+			Str("value", entry.Value).
+			Msg("Db set")
+		store.Set(entry.Key, entry.Value)
+		if entry.ExpiresAt > 0 {
+			// the default time to live of the key's namespace
+			store.Touch(entry.Key, entry.ExpiresAt)
+		}
+	case raft.LogRecord_DEL:
+		logger.Trace().
+			Str("key", entry.Key).
+			Msg("Db del")
+		if entry.ExpiresAt > 0 {
+			// keep the value in the trash until then
+			store.Trash(entry.Key, TrashKey(entry.Key), entry.Timestamp, entry.ExpiresAt)
+		} else {
+			store.Delete(entry.Key)
+		}
+	case raft.LogRecord_TOUCH:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int64("expiresAt", entry.ExpiresAt).
+			Msg("Db touch")
+		store.Touch(entry.Key, entry.ExpiresAt)
+	case raft.LogRecord_PERSIST:
+		logger.Trace().
+			Str("key", entry.Key).
+			Msg("Db persist")
+		store.Persist(entry.Key)
+	}
+}
+
+// applyThrough applies the committed entries after LastApplied, up to index,
+// batching runs of entries that can be
+func (n *Node) applyThrough(index int64) {
+	size := int64(n.config.ApplyBatchSize)
+	for n.Applied() < index {
+		first := n.Applied() + 1
+		last := first
+		if size > 1 && !n.IsWitness() && batchable(entryAt(n.Log, first)) {
+			for last < index && last-first+1 < size && batchable(entryAt(n.Log, last+1)) {
+				last++
+			}
+		}
+		if last == first {
+			n.markApplied(first)
+			n.applyEntry(first, entryAt(n.Log, first))
+			continue
+		}
+		n.applyBatch(first, last)
+	}
+}
+
+// applyBatch applies the entries from first to last (all batchable) as one
+// write batch
+func (n *Node) applyBatch(first int64, last int64) {
+	start := time.Now()
+	n.Store.Update(func(batch *db.Database) {
+		for index := first; index <= last; index++ {
+			applyMutation(batch, entryAt(n.Log, index))
+		}
+	})
+	applyBatches.Inc()
+	applyBatchedEntries.Add(float64(last - first + 1))
+	logger.Debug().
+		Int64("first", first).
+		Int64("last", last).
+		Dur("duration", time.Since(start)).
+		Msg("Applied batch of entries")
+
+	for index := first; index <= last; index++ {
+		entry := entryAt(n.Log, index)
+		n.markApplied(index)
+		n.recordAppliedHash(index, entry)
+		for _, hook := range n.applyHooks {
+			hook(index, entry, nil)
+		}
+	}
+	observePhase("apply", start)
+}
@@ -0,0 +1,152 @@
+package node
+
+// Learners added with AddLearner stay learners until promoted with Promote.
+// With NodeConfig.AutoPromoteAfter set, the leader promotes them itself once
+// they have kept up: their match index has stayed within AutoPromoteLag
+// entries of the commit index for AutoPromoteAfter. With AutoDemoteFlaps set,
+// the leader also demotes a voter whose availability changed that many times
+// within AutoDemoteWindow, so that a member on a flaky network stops counting
+// toward the majority: the membership without it is committed, and it stays a
+// learner, still sent every entry, until it is promoted again (by the policy,
+// once it has kept up and stopped flapping). A voter is not demoted if fewer
+// than minVoters voters would be left. Both parts are off by default, and
+// are checked periodically by ApplyMembershipPolicy (see
+// mgmt.StartMembershipPolicy).
+import (
+	"context"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+// EventMemberDemoted is emitted when a flapping voter is demoted to a learner
+const EventMemberDemoted EventType = "member_demoted"
+
+// DefaultAutoDemoteWindow is the default for NodeConfig.AutoDemoteWindow
+const DefaultAutoDemoteWindow = time.Minute
+
+// minVoters is the fewest voters a demotion may leave
+const minVoters = 3
+
+var membershipPolicyChanges = metrics.NewCounterVec(
+	"leifdb_membership_policy_changes_total",
+	"Members promoted or demoted by the leader's membership policy, by action",
+	"action")
+
+// recordFlap records that the peer's availability changed, if flapping voters
+// are demoted
+func (n *Node) recordFlap(peer *ForeignNode) {
+	if n.config.AutoDemoteFlaps <= 0 {
+		return
+	}
+	now := time.Now()
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.flaps = append(recentFlaps(peer.flaps, now.Add(-n.config.AutoDemoteWindow)), now)
+}
+
+// recentFlaps returns the changes of availability in flaps after since
+func recentFlaps(flaps []time.Time, since time.Time) []time.Time {
+	for len(flaps) > 0 && !flaps[0].After(since) {
+		flaps = flaps[1:]
+	}
+	return flaps
+}
+
+// flapping reports whether the peer's availability changed AutoDemoteFlaps
+// times within the window, as of now
+func (n *Node) flapping(peer *ForeignNode, now time.Time) bool {
+	if n.config.AutoDemoteFlaps <= 0 {
+		return false
+	}
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.flaps = recentFlaps(peer.flaps, now.Add(-n.config.AutoDemoteWindow))
+	return len(peer.flaps) >= n.config.AutoDemoteFlaps
+}
+
+// ApplyMembershipPolicy promotes each learner that has kept up for
+// NodeConfig.AutoPromoteAfter, and demotes a flapping voter, while this node
+// is the leader and no membership change is in progress (see above). Changes
+// are made one at a time, and errors are logged, to be tried again at the
+// next check
+func (n *Node) ApplyMembershipPolicy(ctx context.Context) {
+	if n.config.AutoPromoteAfter <= 0 && n.config.AutoDemoteFlaps <= 0 {
+		return
+	}
+	leader, commitIndex := n.IsLeader(), n.Committed()
+	if !leader || n.inJoint() {
+		return
+	}
+	now := time.Now()
+	for addr, peer := range n.peers.snapshot() {
+		if peer.isLearner() {
+			if n.keptUp(peer, commitIndex, now) {
+				n.autoPromote(ctx, addr)
+				return
+			}
+		} else if n.flapping(peer, now) {
+			n.demote(ctx, addr, peer)
+			return
+		}
+	}
+}
+
+// keptUp reports whether the peer is a standing learner whose match index has
+// stayed within AutoPromoteLag of commitIndex for AutoPromoteAfter, and which
+// is not flapping, as of now
+func (n *Node) keptUp(peer *ForeignNode, commitIndex int64, now time.Time) bool {
+	if n.config.AutoPromoteAfter <= 0 {
+		return false
+	}
+	flapping := n.flapping(peer, now)
+	lag := commitIndex - peer.matchIndex()
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if !peer.standing || flapping || lag > n.config.AutoPromoteLag {
+		peer.keptUpSince = time.Time{}
+		return false
+	}
+	if peer.keptUpSince.IsZero() {
+		peer.keptUpSince = now
+	}
+	return now.Sub(peer.keptUpSince) >= n.config.AutoPromoteAfter
+}
+
+// autoPromote promotes the learner at addr, which has kept up
+func (n *Node) autoPromote(ctx context.Context, addr string) {
+	if _, err := n.promote(ctx, addr, n.config.AutoPromoteLag); err != nil {
+		logger.Warn().Err(err).Str("member", addr).Msg("Failed to promote learner that kept up")
+		return
+	}
+	membershipPolicyChanges.Inc("promoted")
+	logger.Info().Str("member", addr).Msg("Promoted learner that kept up")
+}
+
+// demote makes the flapping voter at addr a learner, and commits the
+// membership without it, unless that would leave fewer than minVoters voters
+func (n *Node) demote(ctx context.Context, addr string, peer *ForeignNode) {
+	if len(n.Members())-1 < minVoters {
+		logger.Debug().Str("member", addr).Msg("Not demoting flapping voter: too few voters would be left")
+		return
+	}
+	peer.lock.Lock()
+	peer.learner, peer.standing, peer.keptUpSince = true, true, time.Time{}
+	if peer.bootstrap == nil {
+		peer.bootstrap = &BootstrapStatus{Member: addr, Started: time.Now()}
+	}
+	peer.bootstrap.Phase = BootstrapCaughtUp
+	peer.lock.Unlock()
+	if _, err := n.commitMembers(ctx, ""); err != nil {
+		// the membership without it may yet commit, in which case applying
+		// it removes the member
+		peer.lock.Lock()
+		peer.learner, peer.standing = false, false
+		peer.lock.Unlock()
+		logger.Warn().Err(err).Str("member", addr).Msg("Failed to demote flapping voter")
+		return
+	}
+	membershipPolicyChanges.Inc("demoted")
+	logger.Warn().Str("member", addr).Msg("Demoted flapping voter to learner")
+	n.emit(EventMemberDemoted, addr)
+}
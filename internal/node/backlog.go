@@ -0,0 +1,71 @@
+package node
+
+// A node that falls behind--applying entries more slowly than the leader
+// commits them, or (as leader) taking writes faster than it can commit
+// them--serves reads from increasingly stale data, and every read it serves
+// takes time from the work that would let it catch up. Past a threshold, the
+// node sheds low-priority requests: reads served from its own copy of the
+// data, and scans. Writes and reads that are confirmed with the leader (such
+// as read barriers) are never shed, since they are what the backlog is made
+// of, and what clients that need current data depend on. The backlog is
+// measured as the apply lag--entries committed by the leader (as of its last
+// append request) that this node has not yet applied--and the number of
+// writes waiting behind the one in progress.
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Defaults for the backlog at which low-priority requests are shed
+const (
+	DefaultShedApplyLag     = 1000
+	DefaultShedQueuedWrites = 1000
+)
+
+var (
+	// ErrBacklogged indicates a low-priority request shed because the node
+	// has a backlog of entries to apply or writes to commit
+	ErrBacklogged = errors.New("Node is shedding low-priority requests while it works through a backlog")
+)
+
+// A Backlog reports how far a node is behind in its work
+type Backlog struct {
+	// Entries committed by the leader that this node has not applied
+	ApplyLag int64 `json:"applyLag"`
+	// Writes waiting behind the one in progress
+	QueuedWrites int64 `json:"queuedWrites"`
+	// Whether low-priority requests are being shed
+	Shedding bool `json:"shedding"`
+}
+
+// noteLeaderCommit records the leader's commit index from an append request
+func (n *Node) noteLeaderCommit(commit int64) {
+	atomic.StoreInt64(&n.leaderCommit, commit)
+}
+
+// Backlog reports how far this node is behind, and whether it is shedding
+// low-priority requests because of it
+func (n *Node) Backlog() Backlog {
+	commit := atomic.LoadInt64(&n.leaderCommit)
+	if n.IsLeader() || n.Committed() > commit {
+		commit = n.Committed()
+	}
+	backlog := Backlog{QueuedWrites: atomic.LoadInt64(&n.queuedWrites)}
+	if lag := commit - n.Applied(); lag > 0 {
+		backlog.ApplyLag = lag
+	}
+	limit := n.config.ShedApplyLag
+	backlog.Shedding = limit > 0 && backlog.ApplyLag > limit
+	limit = n.config.ShedQueuedWrites
+	backlog.Shedding = backlog.Shedding || (limit > 0 && backlog.QueuedWrites > limit)
+	return backlog
+}
+
+// ShedLowPriority returns ErrBacklogged if low-priority requests should be
+// shed because of the node's backlog (see Backlog)
+func (n *Node) ShedLowPriority() error {
+	if n.Backlog().Shedding {
+		return ErrBacklogged
+	}
+	return nil
+}
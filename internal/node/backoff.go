@@ -0,0 +1,91 @@
+package node
+
+// When two candidates in a small cluster time out at about the same time, they
+// split the vote, and with similar election timeouts they can keep doing so.
+// After each failed election, a candidate waits a random extra delay before
+// standing again, drawn from a window that doubles with each consecutive
+// failure (binary exponential backoff, as in Ethernet), so that one of them
+// soon gets far enough ahead to win. The window is reset once an election is
+// won or a leader is heard from.
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Defaults for the window of the election backoff (see NodeConfig)
+const (
+	DefaultElectionBackoffBase = 100 * time.Millisecond
+	DefaultElectionBackoffMax  = 2 * time.Second
+)
+
+// electionBackoff tracks consecutive failed elections, and chooses the delay
+// before the next one
+type electionBackoff struct {
+	lock     sync.Mutex
+	rand     *rand.Rand
+	failures uint
+	base     time.Duration
+	max      time.Duration
+}
+
+// newElectionBackoff makes an electionBackoff for the node id, whose window
+// starts at base and doubles up to max. The random source is seeded from id as
+// well as the time, so that nodes started together choose different delays
+func newElectionBackoff(id string, base time.Duration, max time.Duration) *electionBackoff {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	seed := time.Now().UnixNano() ^ int64(h.Sum64())
+	return &electionBackoff{rand: rand.New(rand.NewSource(seed)), base: base, max: max}
+}
+
+// fail records a failed election
+func (b *electionBackoff) fail() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+}
+
+// reset clears the record of failed elections
+func (b *electionBackoff) reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = 0
+}
+
+// window returns the range of the delay before the next election: zero if the
+// last election did not fail, and otherwise base doubled for each consecutive
+// failure after the first, up to max
+func (b *electionBackoff) window() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures == 0 || b.base <= 0 {
+		return 0
+	}
+	w := b.base
+	for i := uint(1); i < b.failures && w < b.max; i++ {
+		w *= 2
+	}
+	if b.max > 0 && w > b.max {
+		w = b.max
+	}
+	return w
+}
+
+// next returns a random delay within the current window
+func (b *electionBackoff) next() time.Duration {
+	w := b.window()
+	if w <= 0 {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return time.Duration(b.rand.Int63n(int64(w)))
+}
+
+// ElectionBackoff returns the extra time to wait before the next election
+// (beyond the election timeout), which is zero unless the last one failed
+func (n *Node) ElectionBackoff() time.Duration {
+	return n.backoff.next()
+}
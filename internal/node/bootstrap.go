@@ -0,0 +1,565 @@
+package node
+
+// A member added to a running cluster with AddMember is bootstrapped by the
+// leader rather than caught up by replaying the log from the start: the leader
+// streams it a snapshot of its database (InstallSnapshot), then sends the
+// entries after the snapshot as usual. Until it has caught up with the
+// leader's commit index, the new member is a learner: it receives entries but
+// does not count toward the majority for commits or elections, so adding a
+// member that has a lot to catch up on never stalls writes. Once caught up, it
+// is promoted to a voter (EventMemberPromoted), and the membership including
+// it is committed through the log (see AddServer). Members added by
+// ChangeMembers are not promoted on their own: once caught up, they wait as
+// learners to be promoted together (see joint.go). Members added by AddLearner
+// are not promoted at all: they stay learners, still sent every entry, until
+// promoted with Promote. Progress is reported by Bootstraps.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/internal/ratelimit"
+	"github.com/btmorr/leifdb/retry"
+)
+
+var (
+	// ErrMemberExists indicates that a member was added that is already a
+	// member of the cluster
+	ErrMemberExists = errors.New("Node is already a member of the cluster")
+
+	// ErrBootstrapping indicates that an append request was not sent to a
+	// member because it is being sent a snapshot
+	ErrBootstrapping = errors.New("Member is being bootstrapped from a snapshot")
+
+	// ErrBootstrapAborted indicates that this node stopped being the leader, or
+	// the member was removed, before the member was promoted
+	ErrBootstrapAborted = errors.New("Bootstrap aborted: no longer the leader or no longer a member")
+
+	// ErrSnapshotRejected indicates that a member did not install a snapshot
+	// sent to it
+	ErrSnapshotRejected = errors.New("Member did not install the snapshot")
+
+	// ErrNotLearner indicates a request to promote a member that is already a
+	// voter
+	ErrNotLearner = errors.New("Member is not a learner")
+
+	// ErrLearnerBehind indicates a request to promote a learner that has not
+	// caught up with the leader
+	ErrLearnerBehind = errors.New("Learner has not caught up with the leader")
+)
+
+// EventMemberPromoted is emitted when a member being bootstrapped has caught
+// up with the leader and starts counting toward the majority
+const EventMemberPromoted EventType = "member_promoted"
+
+// SnapshotChunkSize is the size of the pieces a snapshot is streamed in
+const SnapshotChunkSize = 1 << 20
+
+// snapshotTimeout is the time allowed to send a whole snapshot to a member
+var snapshotTimeout = 5 * time.Minute
+
+// bootstrapPollInterval is how often the leader checks whether a member being
+// caught up can be promoted
+var bootstrapPollInterval = 100 * time.Millisecond
+
+// bootstrapRetry is how sending a snapshot to a new member is retried
+var bootstrapRetry = retry.Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+// A Snapshot is a serialized copy of the database (see db.BuildSnapshot), as
+// of the entry at LastIndex
+type Snapshot struct {
+	Data        []byte
+	LastIndex   int64
+	LastTerm    int64
+	AppliedHash uint64
+}
+
+// A SnapshotBuilder takes a snapshot of the node's database to send to a new
+// member
+type SnapshotBuilder func() (*Snapshot, error)
+
+// A SnapshotInstaller replaces the node's state with a snapshot sent by the
+// leader (e.g. persisting it first, so it survives a restart)
+type SnapshotInstaller func(*Snapshot) error
+
+// Phases of bootstrapping a new member
+const (
+	BootstrapSnapshot   = "snapshot"
+	BootstrapCatchingUp = "catching-up"
+	BootstrapCaughtUp   = "caught-up"
+	BootstrapPromoted   = "promoted"
+	BootstrapFailed     = "failed"
+)
+
+// BootstrapStatus reports the progress of bootstrapping a new member
+type BootstrapStatus struct {
+	Member string `json:"member"`
+	// One of "snapshot" (being sent a snapshot), "catching-up" (being sent the
+	// entries after it), "caught-up" (a learner waiting to be promoted, with
+	// Promote or with the other members of a joint change), "promoted", or
+	// "failed" (the member has been removed)
+	Phase string `json:"phase"`
+	// Last log index covered by the snapshot, its size, and how much of it has
+	// been sent
+	SnapshotIndex int64 `json:"snapshotIndex"`
+	SnapshotBytes int64 `json:"snapshotBytes"`
+	SentBytes     int64 `json:"sentBytes"`
+	// Index of the last entry known to be replicated on the member, and the
+	// leader's commit index it must reach to be promoted
+	MatchIndex  int64     `json:"matchIndex"`
+	CommitIndex int64     `json:"commitIndex"`
+	Error       string    `json:"error,omitempty"`
+	Started     time.Time `json:"started"`
+	Promoted    time.Time `json:"promoted,omitempty"`
+}
+
+// isLearner reports whether the peer is a member being bootstrapped, which
+// does not count toward the majority
+func (f *ForeignNode) isLearner() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.learner
+}
+
+// learnerCandidate reports whether a candidate asking for this node's vote is
+// a learner
+func (n *Node) learnerCandidate(addr string) bool {
+	peer := n.peers.get(addr)
+	return peer != nil && peer.isLearner()
+}
+
+// sendingSnapshot reports whether the peer is being sent a snapshot (to
+// bootstrap it, or to replace entries it needs that have been compacted), so
+// must not be sent append requests
+func (f *ForeignNode) sendingSnapshot() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.installing || f.bootstrap != nil && f.bootstrap.Phase == BootstrapSnapshot
+}
+
+// updateBootstrap changes the peer's bootstrap status, if it has one
+func (f *ForeignNode) updateBootstrap(update func(*BootstrapStatus)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.bootstrap != nil {
+		update(f.bootstrap)
+	}
+}
+
+// bootstrapStatus returns a copy of the peer's bootstrap status (nil if it was
+// not bootstrapped)
+func (f *ForeignNode) bootstrapStatus() *BootstrapStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.bootstrap == nil {
+		return nil
+	}
+	status := *f.bootstrap
+	return &status
+}
+
+// voters returns the number of peers that count toward the majority
+func voters(others map[string]*ForeignNode) int {
+	count := 0
+	for _, peer := range others {
+		if !peer.isLearner() {
+			count++
+		}
+	}
+	return count
+}
+
+// AddMember adds a member to the cluster while this node is the leader, and
+// bootstraps it in the background: the member is sent a snapshot, then the
+// entries after it, and is promoted to a voter once it has caught up (see
+// Bootstraps for progress)
+func (n *Node) AddMember(addr string) (*BootstrapStatus, error) {
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+	return n.addMember(addr, true)
+}
+
+// AddLearner adds a member to the cluster as a learner while this node is the
+// leader, and bootstraps it in the background as AddMember does, but does not
+// promote it once it has caught up: it keeps being sent entries without
+// counting toward the majority for commits or elections until it is promoted
+// with Promote
+func (n *Node) AddLearner(addr string) (*BootstrapStatus, error) {
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+	status, err := n.addMember(addr, false)
+	if err != nil {
+		return nil, err
+	}
+	if peer := n.peers.get(addr); peer != nil {
+		peer.lock.Lock()
+		peer.standing = true
+		peer.lock.Unlock()
+	}
+	return status, nil
+}
+
+// Promote makes the learner at addr a voter while this node is the leader, and
+// returns the new membership once the membership including it is committed (or
+// an error is generated). The learner must have caught up with the leader
+func (n *Node) Promote(ctx context.Context, addr string) ([]string, error) {
+	return n.promote(ctx, addr, 0)
+}
+
+// promote makes the learner at addr a voter (see Promote), if its match index
+// is within maxLag entries of the commit index
+func (n *Node) promote(ctx context.Context, addr string, maxLag int64) ([]string, error) {
+	if !n.IsLeader() {
+		return nil, ErrNotLeaderRecv
+	}
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+	peer := n.peers.get(addr)
+	if peer == nil {
+		return nil, ErrPeerRemoved
+	}
+	if !peer.isLearner() {
+		return nil, ErrNotLearner
+	}
+	if status := peer.bootstrapStatus(); status == nil || status.Phase != BootstrapCaughtUp {
+		return nil, ErrLearnerBehind
+	}
+	n.Lock()
+	behind := peer.matchIndex()+maxLag < n.Committed()
+	n.Unlock()
+	if behind {
+		return nil, ErrLearnerBehind
+	}
+
+	peer.lock.Lock()
+	standing := peer.standing
+	peer.learner, peer.standing = false, false
+	peer.lock.Unlock()
+	members, err := n.commitMembers(ctx, "")
+	if err != nil {
+		// the membership including it may yet commit, in which case applying
+		// it promotes the member again (see syncMembers)
+		peer.lock.Lock()
+		peer.learner, peer.standing = true, standing
+		peer.lock.Unlock()
+		return nil, err
+	}
+	peer.updateBootstrap(func(s *BootstrapStatus) {
+		s.Phase, s.Promoted = BootstrapPromoted, time.Now()
+	})
+	logger.Info().Str("member", addr).Msg("Promoted learner to voter")
+	n.emit(EventMemberPromoted, addr)
+	return members, nil
+}
+
+// addMember adds and bootstraps a member (see AddMember), which is promoted
+// once caught up if promote is set
+func (n *Node) addMember(addr string, promote bool) (*BootstrapStatus, error) {
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return nil, ErrNotLeaderRecv
+	}
+	if addr == n.RaftNode.Id || n.peers.get(addr) != nil {
+		return nil, ErrMemberExists
+	}
+	peer, err := NewForeignNode(addr, peerDialOptions(n.config.ReconnectRetry)...)
+	if err != nil {
+		return nil, err
+	}
+	peer.catchUp = ratelimit.New(n.config.CatchUpBytesPerSecond)
+	peer.learner = true
+	peer.bootstrap = &BootstrapStatus{Member: addr, Phase: BootstrapSnapshot, Started: time.Now()}
+	if !n.peers.add(addr, peer) {
+		peer.Close()
+		return nil, ErrMemberExists
+	}
+	n.Lock()
+	if n.bootstraps == nil {
+		n.bootstraps = make(map[string]*ForeignNode)
+	}
+	n.bootstraps[addr] = peer
+	n.Unlock()
+	logger.Info().Str("member", addr).Msg("Added member, bootstrapping from snapshot")
+	n.emit(EventMemberAdded, addr)
+	status := peer.bootstrapStatus()
+	go n.bootstrap(addr, peer, term, promote)
+	return status, nil
+}
+
+// Bootstraps returns the progress of bootstrapping each member added with
+// AddMember (the latest, for a member added more than once), sorted by member
+func (n *Node) Bootstraps() []BootstrapStatus {
+	n.Lock()
+	peers := make([]*ForeignNode, 0, len(n.bootstraps))
+	for _, peer := range n.bootstraps {
+		peers = append(peers, peer)
+	}
+	n.Unlock()
+	statuses := make([]BootstrapStatus, 0, len(peers))
+	for _, peer := range peers {
+		statuses = append(statuses, *peer.bootstrapStatus())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Member < statuses[j].Member })
+	return statuses
+}
+
+// stillBootstrapping reports whether this node is still the leader in term and
+// peer is still the member at addr
+func (n *Node) stillBootstrapping(addr string, peer *ForeignNode, term int64) bool {
+	state, current := n.CurrentRole()
+	return state == Leader && current == term && n.peers.get(addr) == peer
+}
+
+// bootstrap sends a new member a snapshot, waits for it to catch up with the
+// entries after the snapshot, and promotes it (if promote is set). If the
+// entries it needs are compacted before it catches up, it is sent a new
+// snapshot. If it can't be bootstrapped, it is removed
+func (n *Node) bootstrap(addr string, peer *ForeignNode, term int64, promote bool) {
+	err := n.sendBootstrapSnapshot(addr, peer, term)
+	for err == nil {
+		time.Sleep(bootstrapPollInterval)
+		if !n.stillBootstrapping(addr, peer, term) {
+			err = ErrBootstrapAborted
+			break
+		}
+		matchIndex, commitIndex, baseIndex := peer.matchIndex(), n.Committed(), n.FirstLogIndex()
+		peer.updateBootstrap(func(s *BootstrapStatus) {
+			s.MatchIndex, s.CommitIndex = matchIndex, commitIndex
+		})
+		if matchIndex+1 < baseIndex {
+			logger.Info().Str("member", addr).Msg("Entries needed by member compacted, sending a new snapshot")
+			err = n.sendBootstrapSnapshot(addr, peer, term)
+			continue
+		}
+		if matchIndex >= commitIndex && !promote {
+			peer.updateBootstrap(func(s *BootstrapStatus) {
+				s.Phase = BootstrapCaughtUp
+			})
+			logger.Info().Str("member", addr).Int64("matchIndex", matchIndex).Msg("Member caught up")
+			return
+		}
+		if matchIndex >= commitIndex {
+			peer.lock.Lock()
+			peer.learner = false
+			peer.lock.Unlock()
+			if _, err = n.commitMembers(context.Background(), ""); err != nil {
+				break
+			}
+			peer.updateBootstrap(func(s *BootstrapStatus) {
+				s.Phase, s.Promoted = BootstrapPromoted, time.Now()
+			})
+			logger.Info().Str("member", addr).Int64("matchIndex", matchIndex).Msg("Promoted member to voter")
+			n.emit(EventMemberPromoted, addr)
+			return
+		}
+	}
+	logger.Error().Err(err).Str("member", addr).Msg("Failed to bootstrap member")
+	peer.updateBootstrap(func(s *BootstrapStatus) {
+		s.Phase, s.Error = BootstrapFailed, err.Error()
+	})
+	if n.peers.get(addr) == peer {
+		n.RemoveForeignNode(addr)
+	}
+}
+
+// sendBootstrapSnapshot takes a snapshot and sends it to a new member
+// (retrying as set by bootstrapRetry), then starts replication from the entry
+// after it
+func (n *Node) sendBootstrapSnapshot(addr string, peer *ForeignNode, term int64) error {
+	peer.updateBootstrap(func(s *BootstrapStatus) {
+		s.Phase, s.SentBytes = BootstrapSnapshot, 0
+	})
+	var snapshot *Snapshot
+	err := bootstrapRetry.Do(context.Background(), func(int) error {
+		if !n.stillBootstrapping(addr, peer, term) {
+			return ErrBootstrapAborted
+		}
+		var err error
+		if snapshot, err = n.buildSnapshot(); err != nil {
+			return err
+		}
+		peer.updateBootstrap(func(s *BootstrapStatus) {
+			s.SnapshotIndex, s.SnapshotBytes, s.SentBytes = snapshot.LastIndex, int64(len(snapshot.Data)), 0
+		})
+		return n.sendSnapshot(peer, term, snapshot)
+	})
+	if err != nil {
+		return err
+	}
+	if !n.leaderIn(term) {
+		return ErrBootstrapAborted
+	}
+	peer.maybeAdvanceMatch(snapshot.LastIndex)
+	peer.updateBootstrap(func(s *BootstrapStatus) {
+		s.Phase, s.MatchIndex = BootstrapCatchingUp, snapshot.LastIndex
+	})
+	logger.Info().
+		Str("member", addr).
+		Int64("lastIndex", snapshot.LastIndex).
+		Int("bytes", len(snapshot.Data)).
+		Msg("Sent snapshot to member")
+	return nil
+}
+
+// buildSnapshot takes a snapshot with the node's SnapshotBuilder, or of the
+// in-memory database if it has none
+func (n *Node) buildSnapshot() (*Snapshot, error) {
+	if n.BuildSnapshot != nil {
+		return n.BuildSnapshot()
+	}
+	n.Lock()
+	snapshot := &Snapshot{LastIndex: n.Applied()}
+	snapshot.LastTerm, _ = n.LogTerm(snapshot.LastIndex)
+	_, snapshot.AppliedHash = n.AppliedHash()
+	clone := db.Clone(n.Store)
+	n.Unlock()
+	data, err := db.BuildSnapshot(clone)
+	snapshot.Data = data
+	return snapshot, err
+}
+
+// sendSnapshot streams a snapshot to a peer in chunks of SnapshotChunkSize,
+// subject to the node's limit on the rate snapshots are served
+func (n *Node) sendSnapshot(peer *ForeignNode, term int64, snapshot *Snapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	stream, err := peer.client().InstallSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	size := int64(len(snapshot.Data))
+	for offset := int64(0); offset == 0 || offset < size; {
+		end := offset + SnapshotChunkSize
+		if end > size {
+			end = size
+		}
+		if err := n.snapshotLimiter.Wait(ctx, end-offset); err != nil {
+			return err
+		}
+		chunk := &raft.SnapshotChunk{
+			Term:        term,
+			Leader:      n.RaftNode,
+			LastIndex:   snapshot.LastIndex,
+			LastTerm:    snapshot.LastTerm,
+			AppliedHash: snapshot.AppliedHash,
+			Size:        size,
+			Offset:      offset,
+			Data:        snapshot.Data[offset:end]}
+		if err := stream.Send(chunk); err != nil {
+			if err == io.EOF {
+				// the member stopped reading, and its reply says why
+				break
+			}
+			return err
+		}
+		offset = end
+		peer.updateBootstrap(func(s *BootstrapStatus) { s.SentBytes = offset })
+		if size == 0 {
+			break
+		}
+	}
+	reply, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if reply.Term > term {
+		return ErrBootstrapAborted
+	}
+	if !reply.Success {
+		return ErrSnapshotRejected
+	}
+	return nil
+}
+
+// ReceiveSnapshotChunk checks that a chunk of a snapshot comes from a valid
+// leader, and resets the election timer (so that a long snapshot does not
+// trigger an election)
+func (n *Node) ReceiveSnapshotChunk(chunk *raft.SnapshotChunk) bool {
+	var valid bool
+	n.process(func() {
+		valid = chunk.Leader != nil && n.validateAppend(chunk.Term, chunk.Leader.Id)
+	})
+	return valid
+}
+
+// HandleInstallSnapshot installs a snapshot streamed by the leader (first is
+// its first chunk, and data the whole snapshot), replacing this node's state,
+// unless this node has already applied the entries it covers. The snapshot is
+// installed outside the event loop, between steps that check the leader and
+// that reset the election timer (see loop.go)
+func (n *Node) HandleInstallSnapshot(first *raft.SnapshotChunk, data []byte) *raft.InstallSnapshotReply {
+	var reply *raft.InstallSnapshotReply
+	n.process(func() {
+		reply = n.acceptSnapshot(first)
+	})
+	if reply != nil {
+		return reply
+	}
+	snapshot := &Snapshot{
+		Data:        data,
+		LastIndex:   first.LastIndex,
+		LastTerm:    first.LastTerm,
+		AppliedHash: first.AppliedHash}
+	err := n.installSnapshot(snapshot)
+	n.process(func() {
+		if err != nil {
+			logger.Error().Err(err).Msg("Error installing snapshot from leader")
+			reply = &raft.InstallSnapshotReply{Term: n.Term, Success: false}
+			return
+		}
+		n.resetElectionTimer()
+		reply = &raft.InstallSnapshotReply{Term: n.Term, Success: true}
+	})
+	return reply
+}
+
+// acceptSnapshot checks a snapshot sent by the leader, on the event loop,
+// adopting the leader's term. Returns the reply to send if the snapshot is not
+// to be installed, or nil if it is
+func (n *Node) acceptSnapshot(first *raft.SnapshotChunk) *raft.InstallSnapshotReply {
+	if first.Leader == nil || !n.validateAppend(first.Term, first.Leader.Id) {
+		return &raft.InstallSnapshotReply{Term: n.Term, Success: false}
+	}
+	if first.Term > n.Term {
+		logger.Info().
+			Int64("newTerm", first.Term).
+			Str("leader", first.Leader.Id).
+			Msg("Got more recent snapshot, updating term record")
+		if err := n.SetTerm(first.Term, nil); err != nil {
+			return &raft.InstallSnapshotReply{Term: n.Term, Success: false}
+		}
+	}
+	if n.currentLeader == nil || n.currentLeader.Id != first.Leader.Id {
+		n.currentLeader = first.Leader
+		n.emit(EventLeaderChange, first.Leader.Id)
+	}
+	if n.Applied() >= first.LastIndex {
+		logger.Info().
+			Int64("lastApplied", n.Applied()).
+			Int64("snapshotIndex", first.LastIndex).
+			Msg("Already applied the entries in the snapshot, not installing it")
+		return &raft.InstallSnapshotReply{Term: n.Term, Success: true}
+	}
+	return nil
+}
+
+// installSnapshot installs a snapshot with the node's SnapshotInstaller, or
+// into memory only if it has none
+func (n *Node) installSnapshot(snapshot *Snapshot) error {
+	if n.InstallSnapshot != nil {
+		return n.InstallSnapshot(snapshot)
+	}
+	store, err := db.InstallSnapshot(snapshot.Data)
+	if err != nil {
+		return err
+	}
+	return n.Reseed(store, snapshot.LastIndex, snapshot.LastTerm, snapshot.AppliedHash)
+}
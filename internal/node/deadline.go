@@ -0,0 +1,64 @@
+package node
+
+// A client can bound a write with a deadline, carried by the context it is
+// proposed with (e.g. from the X-Leifdb-Timeout header of an HTTP request),
+// and the deadline is honored all the way through replication, so that a
+// write the client has given up on does not hold up others: a write whose
+// deadline passes while it waits behind others (in the write queue or a
+// proposal batch) is dropped before it is appended to the log, no more append
+// requests are sent on its behalf (those queued for a peer are skipped, and
+// those in flight are cancelled), and a batch stops waiting for its entries to
+// be committed once every write in it has given up. An entry already in the
+// log can't be taken back, so it is still shipped with later rounds, and may
+// yet be committed. A write whose deadline passes fails with
+// context.DeadlineExceeded (in a CommitUncertainError, if its entry was
+// appended), which is distinct from the cluster failing to replicate it in
+// time (ErrCommitTimeout, or ErrAppendFailed).
+import (
+	"context"
+	"errors"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+// Stages a write may have reached when its deadline passed
+const (
+	deadlineQueue  = "queue"
+	deadlineCommit = "commit"
+)
+
+// ErrCommitTimeout indicates that a write was appended to the log, but not
+// known to be committed before the commit timeout (NodeConfig.CommitTimeout)
+// passed
+var ErrCommitTimeout = errors.New("Entry not committed within the commit timeout")
+
+var deadlineExceeded = metrics.NewCounterVec(
+	"leifdb_deadline_exceeded_total",
+	"Writes whose deadline passed before they completed, by the stage they had reached: queue (dropped before being appended to the log) or commit (appended, but not known to be committed)",
+	"stage")
+
+// countDeadline counts a write that failed with err at stage, if err is a
+// passed deadline
+func countDeadline(err error, stage string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		deadlineExceeded.Inc(stage)
+	}
+}
+
+// untilAllDone returns a context derived from ctx that is also done once
+// every one of ctxs is done, and a function to release it, which must be
+// called
+func untilAllDone(ctx context.Context, ctxs []context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancel(ctx)
+	go func() {
+		for _, c := range ctxs {
+			select {
+			case <-c.Done():
+			case <-merged.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return merged, cancel
+}
@@ -0,0 +1,55 @@
+package node
+
+// Demoting the leader makes it step down without choosing a successor (unlike
+// TransferLeadership), so the cluster re-elects a leader as it would after a
+// failure: once the followers' election timers expire, the first to stand
+// with an up-to-date log wins. The demoted node ends its grace period, so that
+// it votes in that election, and holds off standing itself until the holdoff
+// has passed, which should be longer than the followers' election timeout.
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/btmorr/leifdb/retry"
+)
+
+// DefaultDemoteHoldoff is the time a demoted leader waits before standing for
+// election again: twice the default maximum election timeout, so that a
+// follower's election timer expires first
+const DefaultDemoteHoldoff = 2 * time.Second
+
+var (
+	// ErrDemoteNotLeader indicates a request to demote a node that is not the
+	// leader
+	ErrDemoteNotLeader = errors.New("Only the leader can be demoted")
+)
+
+// Demote makes this node step down as leader, once any write in progress has
+// finished, and keeps it from standing for election for holdoff. Returns
+// ErrDemoteNotLeader if the node is not the leader
+func (n *Node) Demote(holdoff time.Duration) error {
+	n.Lock()
+	defer n.Unlock()
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return ErrDemoteNotLeader
+	}
+	// bring the followers' commit indexes up to date, so that whichever of
+	// them wins can serve reads as soon as possible
+	if err := n.SendAppend(context.Background(), retry.Once, term); err != nil {
+		logger.Warn().Err(err).Msg("Error bringing followers up to date for demotion")
+	}
+
+	n.process(func() {
+		logger.Info().
+			Int64("term", n.Term).
+			Dur("holdoff", holdoff).
+			Msg("Demoted, stepping down")
+		n.electionsAfter = time.Now().Add(holdoff)
+		n.resetElectionTimer()
+		n.AllowVote = true
+		n.currentLeader = nil
+	})
+	return nil
+}
@@ -0,0 +1,102 @@
+package node
+
+// An application embedding a node can follow the replicated log directly, to
+// build derived views or external indexes: Entries delivers each committed
+// entry in order, starting with those already in the log (those that have not
+// been compacted into a snapshot), and then each new entry as it is applied.
+// The stream is read from the node's own log, so it works the same on every
+// member, and an application that records the index of the last entry it has
+// handled can resume from the next one after restarting.
+import (
+	"context"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// entryStreamBuffer is the number of entries an Entries channel holds, which
+// is also the most entries read from the log at once
+const entryStreamBuffer = 256
+
+// A CommittedEntry is a log entry that has been committed and applied
+type CommittedEntry struct {
+	EntryInfo
+	// The entry as replicated, for the parts not described by EntryInfo, such
+	// as its value or the operations of a batch. It must not be modified
+	Record *raft.LogRecord
+}
+
+// Entries returns a channel that receives every committed entry from
+// fromIndex on, in order, once it has been applied on this node. It fails with
+// ErrEntriesCompacted if the entry at fromIndex has already been compacted
+// into a snapshot (the application must then start from a snapshot of the
+// database instead), or with ErrWitnessRead on a witness, whose log carries no
+// data. The channel is closed when ctx is done, or if the reader falls so far
+// behind that the next entry is compacted before it is read--calling Entries
+// again from that entry reports the error
+func (n *Node) Entries(ctx context.Context, fromIndex int64) (<-chan CommittedEntry, error) {
+	if n.IsWitness() {
+		return nil, ErrWitnessRead
+	}
+	if fromIndex < 0 {
+		fromIndex = 0
+	}
+	if fromIndex < n.FirstLogIndex() {
+		return nil, ErrEntriesCompacted
+	}
+	c := make(chan CommittedEntry, entryStreamBuffer)
+	go func() {
+		defer close(c)
+		next := fromIndex
+		for {
+			if ok, _ := n.WaitForIndex(ctx, next); !ok {
+				return
+			}
+			batch, ok := n.committedEntries(next, entryStreamBuffer)
+			if !ok || len(batch) == 0 {
+				logger.Warn().
+					Int64("index", next).
+					Msg("Entries stream fell behind compaction")
+				return
+			}
+			for _, entry := range batch {
+				select {
+				case c <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+			next += int64(len(batch))
+		}
+	}()
+	return c, nil
+}
+
+// committedEntries returns up to limit applied entries from the log, starting
+// at index, or false if the entry at index has been compacted
+func (n *Node) committedEntries(index int64, limit int) ([]CommittedEntry, bool) {
+	logStore := n.view().log
+	if index < logStore.BaseIndex {
+		return nil, false
+	}
+	last := n.Applied()
+	if max := index + int64(limit) - 1; last > max {
+		last = max
+	}
+	entries := make([]CommittedEntry, 0, last-index+1)
+	for i := index; i <= last; i++ {
+		record := entryAt(logStore, i)
+		if record == nil {
+			break
+		}
+		entries = append(entries, CommittedEntry{
+			EntryInfo: EntryInfo{
+				Index:     i,
+				Term:      record.Term,
+				Time:      entryTime(record),
+				Action:    record.Action.String(),
+				Key:       record.Key,
+				RequestId: record.RequestId},
+			Record: record})
+	}
+	return entries, true
+}
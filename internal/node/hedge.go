@@ -0,0 +1,137 @@
+package node
+
+// A hedged request is sent again, to the same node, if no reply has arrived
+// after a delay, and the first reply to succeed is used. In networks where
+// latency varies widely, this keeps a single slow or lost message from holding
+// up an election or a read. Duplicates are capped by a budget that earns a
+// fraction of a hedge for each request sent (like gRPC's retry throttling), so
+// hedging adds at most that fraction of extra load.
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+// maxHedgeTokens bounds how many hedges the budget saves up while requests
+// are fast, so that a burst of slow requests cannot all be hedged
+const maxHedgeTokens = 10
+
+// RPCs that may be hedged
+const (
+	hedgeVote      = "vote"
+	hedgeReadIndex = "read_index"
+)
+
+var (
+	hedgedRequests = metrics.NewCounterVec(
+		"leifdb_hedged_requests_total",
+		"Duplicate requests sent because no reply arrived within the hedge delay",
+		"rpc")
+	hedgeWins = metrics.NewCounterVec(
+		"leifdb_hedge_wins_total",
+		"Hedged requests whose duplicate succeeded first",
+		"rpc")
+)
+
+// A hedgeBudget limits hedged requests to a fraction of all requests
+type hedgeBudget struct {
+	sync.Mutex
+	// tokens earned by each request (a hedge costs one)
+	ratio  float64
+	tokens float64
+}
+
+// newHedgeBudget returns a budget allowing hedges for up to percent of
+// requests
+func newHedgeBudget(percent int) *hedgeBudget {
+	return &hedgeBudget{ratio: float64(percent) / 100}
+}
+
+// request records that a request that may be hedged was sent
+func (b *hedgeBudget) request() {
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Min(b.tokens+b.ratio, maxHedgeTokens)
+}
+
+// take spends a token on a hedge, and returns false if there are none left
+func (b *hedgeBudget) take() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type hedgeKey struct{}
+
+// withHedging returns a copy of ctx marking the append requests made with it
+// as hedged requests for rpc
+func withHedging(ctx context.Context, rpc string) context.Context {
+	return context.WithValue(ctx, hedgeKey{}, rpc)
+}
+
+// hedgedRPC returns the RPC named by `withHedging`, or "" if requests made
+// with ctx are not hedged
+func hedgedRPC(ctx context.Context) string {
+	rpc, _ := ctx.Value(hedgeKey{}).(string)
+	return rpc
+}
+
+// hedge calls call, and if it has not returned within the configured hedge
+// delay, calls it again (if the budget allows), returning the first result to
+// succeed, or the last error if both fail. The context passed to call is
+// cancelled once hedge returns, which abandons the slower call. If hedging is
+// disabled, or rpc is "", call is made once
+func (n *Node) hedge(
+	ctx context.Context,
+	rpc string,
+	call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+
+	delay := n.config.HedgeDelay
+	if delay <= 0 || rpc == "" {
+		return call(ctx)
+	}
+	n.hedges.request()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		value  interface{}
+		err    error
+		hedged bool
+	}
+	results := make(chan result, 2)
+	run := func(hedged bool) {
+		value, err := call(ctx)
+		results <- result{value: value, err: err, hedged: hedged}
+	}
+	go run(false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			if n.hedges.take() {
+				hedgedRequests.Inc(rpc)
+				pending++
+				go run(true)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				if r.err == nil && r.hedged {
+					hedgeWins.Inc(rpc)
+				}
+				return r.value, r.err
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+// A follower that falls so far behind that the entries it needs have been
+// compacted into a snapshot (see CompactLog)--say, after being down for a long
+// time--can't be caught up with append requests. The leader sends it a
+// snapshot of the database instead, as it does to bootstrap a new member
+// (InstallSnapshot), and replication resumes from the entry after the
+// snapshot. The follower is not sent append requests while the snapshot is in
+// flight. It keeps its vote, so, like an unavailable member, it does not
+// acknowledge entries until it has caught up.
+import (
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+var followerSnapshots = metrics.NewCounterVec(
+	"leifdb_follower_snapshots_total",
+	"Snapshots sent to the peer because the entries it needed had been compacted",
+	"peer")
+
+// installOnFollower sends a snapshot to the follower at addr in the
+// background, unless one is being sent to it already. Once the follower has
+// installed it, its match index moves to the last entry in the snapshot
+func (n *Node) installOnFollower(addr string, peer *ForeignNode, term int64) {
+	peer.lock.Lock()
+	if peer.installing || peer.bootstrap != nil && peer.bootstrap.Phase == BootstrapSnapshot {
+		peer.lock.Unlock()
+		return
+	}
+	peer.installing = true
+	peer.lock.Unlock()
+
+	go func() {
+		defer func() {
+			peer.lock.Lock()
+			peer.installing = false
+			peer.lock.Unlock()
+		}()
+		snapshot, err := n.buildSnapshot()
+		if err == nil {
+			err = n.sendSnapshot(peer, term, snapshot)
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("peer", addr).Msg("Failed to send snapshot to follower")
+			return
+		}
+		followerSnapshots.Inc(addr)
+
+		if !n.leaderIn(term) || n.peers.get(addr) != peer {
+			return
+		}
+		peer.maybeAdvanceMatch(snapshot.LastIndex)
+		logger.Info().
+			Str("peer", addr).
+			Int64("lastIndex", snapshot.LastIndex).
+			Int("bytes", len(snapshot.Data)).
+			Msg("Sent snapshot to follower")
+	}()
+}
@@ -0,0 +1,98 @@
+package node
+
+// Isolating a peer cuts it off from this node without removing it from the
+// membership, e.g. while it misbehaves because of a bad disk: it is not sent
+// append requests (so while this node is the leader, it falls behind and does
+// not count toward commits), it is not asked for its vote, and its vote
+// requests are refused with the ISOLATED reason. Isolation lasts for the
+// duration given and then lapses on its own, so a peer is not left cut off if
+// the operator forgets it. It is local to this node and not persisted: isolate
+// the peer on each member to keep it out of elections everywhere, and on the
+// leader to stop it being sent entries.
+import (
+	"errors"
+	"time"
+)
+
+// EventPeerIsolated is emitted when a peer is isolated (see Isolate)
+const EventPeerIsolated EventType = "peer_isolated"
+
+var (
+	// ErrIsolateSelf indicates a request for a node to isolate itself
+	ErrIsolateSelf = errors.New("A node can't isolate itself")
+
+	// ErrInvalidIsolation indicates a request to isolate a peer for a duration
+	// that is not positive
+	ErrInvalidIsolation = errors.New("Isolation must be for a positive duration")
+
+	// ErrPeerIsolated indicates that a request was not sent to a peer because
+	// it is isolated
+	ErrPeerIsolated = errors.New("Peer is isolated")
+)
+
+// isolated reports whether the peer is isolated
+func (f *ForeignNode) isolated() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return time.Now().Before(f.isolatedUntil)
+}
+
+// isolation returns the time until which the peer is isolated, or the zero
+// time if it is not
+func (f *ForeignNode) isolation() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !time.Now().Before(f.isolatedUntil) {
+		return time.Time{}
+	}
+	return f.isolatedUntil
+}
+
+// Isolate cuts this node off from the peer at addr for d (replacing any
+// isolation already in place), and returns the time at which the isolation
+// lapses. Returns ErrPeerRemoved if addr is not a peer
+func (n *Node) Isolate(addr string, d time.Duration) (time.Time, error) {
+	if d <= 0 {
+		return time.Time{}, ErrInvalidIsolation
+	}
+	if addr == n.RaftNode.Id {
+		return time.Time{}, ErrIsolateSelf
+	}
+	peer := n.peers.get(addr)
+	if peer == nil {
+		return time.Time{}, ErrPeerRemoved
+	}
+	until := time.Now().Add(d)
+	peer.lock.Lock()
+	peer.isolatedUntil = until
+	peer.lock.Unlock()
+	logger.Warn().Str("peer", addr).Time("until", until).Msg("Isolating peer")
+	n.emit(EventPeerIsolated, addr)
+	time.AfterFunc(d, func() {
+		if n.peers.get(addr) == peer && !peer.isolated() {
+			logger.Info().Str("peer", addr).Msg("Isolation of peer lapsed")
+		}
+	})
+	return until, nil
+}
+
+// Unisolate ends the isolation of the peer at addr, if any. Returns
+// ErrPeerRemoved if addr is not a peer
+func (n *Node) Unisolate(addr string) error {
+	peer := n.peers.get(addr)
+	if peer == nil {
+		return ErrPeerRemoved
+	}
+	peer.lock.Lock()
+	peer.isolatedUntil = time.Time{}
+	peer.lock.Unlock()
+	logger.Info().Str("peer", addr).Msg("Ended isolation of peer")
+	return nil
+}
+
+// isolatedCandidate reports whether a candidate asking for this node's vote is
+// an isolated peer
+func (n *Node) isolatedCandidate(addr string) bool {
+	peer := n.peers.get(addr)
+	return peer != nil && peer.isolated()
+}
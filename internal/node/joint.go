@@ -0,0 +1,340 @@
+package node
+
+// Changing one member at a time (AddServer, RemoveServer) is safe because any
+// majority of the old membership overlaps any majority of the new one. Adding
+// or removing several members at once has no such overlap--a majority of the
+// old members and a majority of the new could each elect a leader or commit
+// entries without the other--so ChangeMembers goes through a joint membership
+// instead, as in section 6 of the Raft paper:
+//
+//   1. Each member being added is bootstrapped as a learner (see AddMember),
+//      but not promoted on its own once it has caught up.
+//   2. The leader appends a MEMBERS entry listing the new members, with the
+//      old members in old_members. Once a node applies it, the cluster is in
+//      the joint membership: every member of either is a peer, and commits
+//      and elections need a majority of the old members and a majority of
+//      the new members.
+//   3. Once the leader applies the joint entry, it appends a MEMBERS entry
+//      listing only the new members, and once that is applied, members that
+//      are not in it are removed.
+//
+// A leader elected during a joint membership completes the change itself.
+// Only one change is in progress at a time: single-member changes are refused
+// until the joint membership is left.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// OldMembersKey is the system key holding the members before a joint
+// membership change in progress (see MembersKey), as a JSON array of raft
+// addresses. It is deleted once the change is complete
+const OldMembersKey = SystemKeyPrefix + "members.old"
+
+var (
+	// ErrChangeInProgress indicates that the membership was changed while a
+	// joint membership change was still in progress
+	ErrChangeInProgress = errors.New("A membership change is already in progress")
+
+	// ErrNoMembers indicates a membership change to no members
+	ErrNoMembers = errors.New("The new membership must have at least one member")
+)
+
+// A configuration is the set of voting members whose majority decides commits
+// and elections, and during a joint membership change, the set of members
+// before the change, whose majority must agree as well
+type configuration struct {
+	members []string
+	old     []string
+}
+
+// joint reports whether c is a joint membership
+func (c configuration) joint() bool {
+	return len(c.old) > 0
+}
+
+// quorum reports whether the members in acked make up a majority of c (of both
+// memberships, if c is joint)
+func (c configuration) quorum(acked map[string]bool) bool {
+	return majorityOf(c.members, acked) && (!c.joint() || majorityOf(c.old, acked))
+}
+
+// majorityOf reports whether more than half of members are in acked
+func majorityOf(members []string, acked map[string]bool) bool {
+	count := 0
+	for _, addr := range members {
+		if acked[addr] {
+			count++
+		}
+	}
+	return count > len(members)/2
+}
+
+// configuration returns the membership that decides commits and elections:
+// the joint membership, during a change, or else this node and its peers that
+// are not learners
+func (n *Node) configuration() configuration {
+	n.jointLock.Lock()
+	joint := n.joint
+	n.jointLock.Unlock()
+	if joint != nil {
+		return *joint
+	}
+	return configuration{members: n.Members()}
+}
+
+// setJoint records the joint membership being changed through (nil once the
+// change is complete)
+func (n *Node) setJoint(joint *configuration) {
+	n.jointLock.Lock()
+	defer n.jointLock.Unlock()
+	n.joint = joint
+}
+
+// inJoint reports whether a joint membership change is in progress
+func (n *Node) inJoint() bool {
+	n.jointLock.Lock()
+	defer n.jointLock.Unlock()
+	return n.joint != nil
+}
+
+// ChangeMembers changes the voting members of the cluster to members, which
+// may add and remove any number of members at once, while this node is the
+// leader. Members being added are bootstrapped first, then the cluster moves
+// through a joint membership to the new one. It returns the new membership
+// once it is committed (or an error is generated). The leader must be one of
+// the new members--transfer leadership first to remove it
+func (n *Node) ChangeMembers(ctx context.Context, members []string) ([]string, error) {
+	target := make([]string, 0, len(members))
+	listed := make(map[string]bool, len(members))
+	for _, addr := range members {
+		if addr != "" && !listed[addr] {
+			listed[addr] = true
+			target = append(target, addr)
+		}
+	}
+	sort.Strings(target)
+	if len(target) == 0 {
+		return nil, ErrNoMembers
+	}
+
+	if !n.IsLeader() {
+		return nil, ErrNotLeaderRecv
+	}
+	if !listed[n.RaftNode.Id] {
+		return nil, ErrRemoveLeader
+	}
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+
+	current := n.Members()
+	added := []string{}
+	for _, addr := range target {
+		peer := n.peers.get(addr)
+		switch {
+		case peer == nil && addr != n.RaftNode.Id:
+			added = append(added, addr)
+		case peer != nil && peer.isLearner():
+			// being bootstrapped by AddMember, which promotes it on its own, or
+			// a learner added by AddLearner, which is promoted with Promote
+			return nil, ErrChangeInProgress
+		}
+	}
+	if len(added) == 0 && equalMembers(current, target) {
+		return current, nil
+	}
+
+	// bootstrap the members being added, and remove them again if any of them
+	// can't be caught up
+	abort := func() {
+		for _, addr := range added {
+			if peer := n.peers.get(addr); peer != nil && peer.isLearner() {
+				n.RemoveForeignNode(addr)
+			}
+		}
+	}
+	for _, addr := range added {
+		if _, err := n.addMember(addr, false); err != nil {
+			abort()
+			return nil, err
+		}
+	}
+	if err := n.awaitCaughtUp(ctx, added); err != nil {
+		abort()
+		return nil, err
+	}
+
+	if err := n.enterJoint(ctx, target, current); err != nil {
+		return nil, err
+	}
+	for _, addr := range added {
+		if peer := n.peers.get(addr); peer != nil {
+			peer.updateBootstrap(func(s *BootstrapStatus) {
+				s.Phase, s.Promoted = BootstrapPromoted, time.Now()
+			})
+			n.emit(EventMemberPromoted, addr)
+		}
+	}
+	if err := n.awaitJointLeft(ctx, target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// equalMembers reports whether two sorted memberships are the same
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// awaitCaughtUp waits until each member at addrs, being bootstrapped without
+// promotion, has caught up
+func (n *Node) awaitCaughtUp(ctx context.Context, addrs []string) error {
+	ticker := time.NewTicker(bootstrapPollInterval)
+	defer ticker.Stop()
+	for {
+		waiting := false
+		for _, addr := range addrs {
+			peer := n.peers.get(addr)
+			if peer == nil {
+				return fmt.Errorf("%w: %s", ErrBootstrapFailed, ErrBootstrapAborted)
+			}
+			status := peer.bootstrapStatus()
+			switch status.Phase {
+			case BootstrapFailed:
+				return fmt.Errorf("%w: %s", ErrBootstrapFailed, status.Error)
+			case BootstrapCaughtUp:
+			default:
+				waiting = true
+			}
+		}
+		if !waiting {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// enterJoint appends the entry starting a change from the members in old to
+// those in members, and returns once it is committed (or an error is
+// generated)
+func (n *Node) enterJoint(ctx context.Context, members []string, old []string) error {
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return ErrNotLeaderRecv
+	}
+	if n.inJoint() {
+		return ErrChangeInProgress
+	}
+	logger.Info().Strs("members", members).Strs("oldMembers", old).Msg("Entering joint membership")
+	record := &raft.LogRecord{
+		Action:     raft.LogRecord_MEMBERS,
+		Members:    members,
+		OldMembers: old,
+	}
+	return n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+}
+
+// leaveJoint appends the entry completing the joint membership change in
+// progress, if this node is still the leader in term. If this node is not one
+// of the new members, it steps down once the entry is committed
+func (n *Node) leaveJoint(term int64) {
+	ctx := context.Background()
+	n.lockWrite(ctx)
+	n.jointLock.Lock()
+	joint := n.joint
+	n.jointLock.Unlock()
+	if !n.leaderIn(term) || joint == nil {
+		n.Unlock()
+		return
+	}
+	logger.Info().Strs("members", joint.members).Msg("Leaving joint membership")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_MEMBERS,
+		Members: joint.members,
+	}
+	err := n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+	n.Unlock()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to leave joint membership")
+		return
+	}
+	for _, addr := range joint.members {
+		if addr == n.RaftNode.Id {
+			return
+		}
+	}
+	logger.Info().Msg("No longer a member, stepping down")
+	n.Demote(DefaultDemoteHoldoff)
+}
+
+// awaitJointLeft waits until the joint membership change to members is
+// complete on this node
+func (n *Node) awaitJointLeft(ctx context.Context, members []string) error {
+	ticker := time.NewTicker(bootstrapPollInterval)
+	defer ticker.Stop()
+	for {
+		if !n.inJoint() && equalMembers(n.Members(), members) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyMembers adopts the membership in a MEMBERS entry: a joint membership,
+// if it lists old members, or else the new membership, completing a joint
+// change in progress. A leader that applies a joint entry goes on to complete
+// the change
+func (n *Node) applyMembers(entry *raft.LogRecord) {
+	if len(entry.OldMembers) == 0 {
+		n.setJoint(nil)
+		n.syncMembers(entry.Members)
+		return
+	}
+	n.setJoint(&configuration{members: entry.Members, old: entry.OldMembers})
+	n.syncMembers(append(append([]string{}, entry.Members...), entry.OldMembers...))
+	if n.State == Leader {
+		go n.leaveJoint(n.Term)
+	}
+}
+
+// restoreJoint restores the joint membership held in OldMembersKey, if the
+// database has one, along with members, the membership held in MembersKey. It
+// returns the members of either
+func (n *Node) restoreJoint(members []string) []string {
+	if !n.Store.Exists(OldMembersKey) {
+		n.setJoint(nil)
+		return members
+	}
+	var old []string
+	if err := json.Unmarshal([]byte(n.Store.Get(OldMembersKey)), &old); err != nil {
+		logger.Error().Err(err).Msg("Invalid joint membership")
+		n.setJoint(nil)
+		return members
+	}
+	n.setJoint(&configuration{members: members, old: old})
+	return append(append([]string{}, members...), old...)
+}
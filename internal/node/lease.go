@@ -0,0 +1,121 @@
+package node
+
+// Reads from a node's store are not linearizable on their own: a leader that
+// has been deposed without knowing it may serve data that a new leader has
+// since overwritten. A read barrier (ReadBarrier) rules that out with a round
+// of append requests, at the cost of a round trip per read. With a leader
+// lease (NodeConfig.LeaderLease), the leader skips the round while a round it
+// started within the lease was acknowledged by a majority, since no other
+// leader can be elected in the meantime: a follower refuses votes until the
+// lease has passed since it last heard from the leader (with the LEADER_LEASE
+// reason, and without adopting the candidate's term), so a candidate can't
+// win the majority that acknowledged the round. The exception is a candidate
+// asked to stand by the leader itself (see TransferLeadership), and the leader
+// stops using its lease before it asks. The lease relies on time passing at
+// about the same rate on every member: it is cut short by a tenth to allow for
+// clocks running at different rates, and not used while a peer's clock is
+// skewed (see skew.go). A leader also doesn't use it until it has committed
+// an entry in its own term, before which its commit index may not cover every
+// entry committed by earlier leaders. Reads fall back to a round of append
+// requests whenever the lease is not held.
+import (
+	"context"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+// Ways a linearizable read confirms leadership
+const (
+	leaseReadLease  = "lease"
+	leaseReadQuorum = "quorum"
+)
+
+// leaseDriftPercent is the share of the leader lease cut off to allow for
+// clocks running at different rates
+const leaseDriftPercent = 10
+
+var leaseReads = metrics.NewCounterVec(
+	"leifdb_lease_reads_total",
+	"Linearizable reads on the leader, by how leadership was confirmed: within the leader lease, or by a round of append requests acknowledged by a quorum",
+	"source")
+
+// leaseHeld reports whether this node holds the leader lease in term: a round
+// of append requests it started within the lease was acknowledged by a
+// majority, and it has committed an entry in term, lease reads are allowed,
+// and no leadership transfer has been started
+func (n *Node) leaseHeld(term int64) bool {
+	lease := n.config.LeaderLease
+	if lease <= 0 || !n.LeaseReadsAllowed() {
+		return false
+	}
+	n.proposalLock.Lock()
+	transferring := n.transferTerm == term
+	n.proposalLock.Unlock()
+	if transferring {
+		return false
+	}
+	if !n.committedInTerm(term) {
+		return false
+	}
+	age := time.Duration(n.leaseAge(term))
+	return age > 0 && age < lease-lease*leaseDriftPercent/100
+}
+
+// revokeLease stops this node using its lease in term, before it asks
+// another member to stand for election
+func (n *Node) revokeLease(term int64) {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	n.transferTerm = term
+}
+
+// restoreLease lets this node use its lease in term again, after a
+// leadership transfer was not accepted
+func (n *Node) restoreLease(term int64) {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	if n.transferTerm == term {
+		n.transferTerm = -1
+	}
+}
+
+// withinLease reports whether this node has heard from its leader within the
+// leader lease, and so must not help elect another
+func (n *Node) withinLease() bool {
+	lease := n.config.LeaderLease
+	return lease > 0 && !n.leaderContact.IsZero() && time.Since(n.leaderContact) < lease
+}
+
+// AwaitLinearizable returns once this node, which must be the leader, can
+// serve reads from its store that reflect every write acknowledged before it
+// was called: within the leader lease, once the store has applied the commit
+// index, and otherwise once the store has applied the read index (see
+// ReadIndex)
+func (n *Node) AwaitLinearizable(ctx context.Context) error {
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return ErrNotLeaderRecv
+	}
+	commitIndex := n.Committed()
+	if n.leaseHeld(term) {
+		leaseReads.Inc(leaseReadLease)
+	} else {
+		leaseReads.Inc(leaseReadQuorum)
+		var err error
+		if commitIndex, err = n.ReadIndex(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := n.WaitForIndex(ctx, commitIndex)
+	return err
+}
+
+// LeaseGet returns the value of key linearizably (see AwaitLinearizable),
+// which is only possible on the leader
+func (n *Node) LeaseGet(ctx context.Context, key string) (string, error) {
+	if err := n.AwaitLinearizable(ctx); err != nil {
+		return "", err
+	}
+	return n.Store.Get(key), nil
+}
@@ -0,0 +1,177 @@
+package node
+
+// Advisory locks let clients agree on who may work on something (a job, a
+// resource) without building a lease out of transactions themselves. Each lock
+// is a system key (under lockPrefix) whose value records its owner, when it
+// was taken, and when its lease runs out (see database.Lock). Locks are taken
+// by LOCK entries and released by UNLOCK entries, which compare the current
+// holder when they are applied--so of two owners racing for a free lock, the
+// one whose entry is applied first gets it, and the other is told who holds
+// it. A lock that is not renewed before its lease runs out is free to be taken
+// again, and is removed by the expiry of keys like any other.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// lockPrefix starts the system key holding each lock
+const lockPrefix = SystemKeyPrefix + "lock/"
+
+var (
+	// ErrInvalidLock indicates a lock request with an empty name or owner, or
+	// a lease that is not positive
+	ErrInvalidLock = errors.New("Lock name and owner must be non-empty, and the lease positive")
+
+	// ErrLockHeld matches (with errors.Is) a LockHeldError
+	ErrLockHeld = errors.New("Lock is held by another owner")
+)
+
+// A Lock is an advisory lock, and who holds it
+type Lock struct {
+	Name string `json:"name"`
+	db.Lock
+}
+
+// A LockHeldError reports that a lock could not be taken because another
+// owner holds it
+type LockHeldError struct {
+	Lock Lock
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%s: %s is held by %s until %s", ErrLockHeld, e.Lock.Name,
+		e.Lock.Owner, time.Unix(0, e.Lock.ExpiresAt).UTC().Format(time.RFC3339Nano))
+}
+
+// Is reports whether target is ErrLockHeld
+func (e *LockHeldError) Is(target error) bool {
+	return target == ErrLockHeld
+}
+
+// lockKey returns the system key holding the lock called name
+func lockKey(name string) string {
+	return lockPrefix + name
+}
+
+// GetLock returns the lock called name, as of the last entry applied by this
+// node, and whether it is held
+func (n *Node) GetLock(name string) (Lock, bool) {
+	lock, ok := n.Store.LockAt(lockKey(name), time.Now().UnixNano())
+	return Lock{Name: name, Lock: lock}, ok
+}
+
+// Locks returns every lock held, as of the last entry applied by this node,
+// sorted by name
+func (n *Node) Locks() []Lock {
+	now := time.Now().UnixNano()
+	locks := []Lock{}
+	cursor := n.Store.PrefixCursor(lockPrefix)
+	for key, _, ok := cursor.Next(); ok; key, _, ok = cursor.Next() {
+		if lock, held := n.Store.LockAt(key, now); held {
+			locks = append(locks, Lock{Name: strings.TrimPrefix(key, lockPrefix), Lock: lock})
+		}
+	}
+	return locks
+}
+
+// AcquireLock tries once to take a lock: it appends an entry giving the lock
+// called name to owner for lease, unless another owner holds it when the entry
+// is applied. If owner already holds it, its lease is renewed. Returns the
+// lock once the entry is applied, or a LockHeldError with the other owner's
+// lock. The outcome is only known once the entry is applied, so AcquireLock
+// waits for that even with WriteConcernLeader
+func (n *Node) AcquireLock(ctx context.Context, name string, owner string, lease time.Duration) (Lock, error) {
+	if name == "" || owner == "" || lease <= 0 {
+		return Lock{}, ErrInvalidLock
+	}
+	logger.Info().Str("lock", name).Str("owner", owner).Dur("lease", lease).Msg("AcquireLock")
+	now := time.Now()
+	value, err := json.Marshal(db.Lock{
+		Owner:      owner,
+		AcquiredAt: now.UnixNano(),
+		LeaseMs:    lease.Milliseconds(),
+		ExpiresAt:  now.Add(lease).UnixNano()})
+	if err != nil {
+		return Lock{}, err
+	}
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_LOCK,
+		Key:    lockKey(name),
+		Value:  string(value),
+	}
+	lock, acquired, err := n.applyLockRecord(ctx, record, now.UnixNano())
+	if err != nil {
+		return Lock{}, err
+	}
+	if !acquired {
+		return Lock{}, &LockHeldError{Lock: Lock{Name: name, Lock: lock}}
+	}
+	return Lock{Name: name, Lock: lock}, nil
+}
+
+// ReleaseLock appends an entry releasing the lock called name, if owner holds
+// it when the entry is applied, and returns whether it did once the entry is
+// applied (waiting for that even with WriteConcernLeader, as for AcquireLock)
+func (n *Node) ReleaseLock(ctx context.Context, name string, owner string) (bool, error) {
+	if name == "" || owner == "" {
+		return false, ErrInvalidLock
+	}
+	logger.Info().Str("lock", name).Str("owner", owner).Msg("ReleaseLock")
+	record := &raft.LogRecord{
+		Action:    raft.LogRecord_UNLOCK,
+		Key:       lockKey(name),
+		Value:     owner,
+		ExpiresAt: time.Now().UnixNano(),
+	}
+	_, released, err := n.applyLockRecord(ctx, record, record.ExpiresAt)
+	return released, err
+}
+
+// applyLockRecord appends a LOCK or UNLOCK entry, and once it is applied,
+// returns the lock as of now (Unix nanoseconds), and whether the entry took
+// effect
+func (n *Node) applyLockRecord(
+	ctx context.Context,
+	record *raft.LogRecord,
+	now int64) (db.Lock, bool, error) {
+
+	proposal := int64(len(record.Key) + len(record.Value))
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return db.Lock{}, false, err
+	}
+	defer n.releaseProposal(proposal)
+
+	if writeConcern(ctx) == WriteConcernLeader {
+		ctx = WithWriteConcern(ctx, WriteConcernMajority)
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	idx := lastIndex(n.Log) + 1
+	if err := n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record); err != nil {
+		return db.Lock{}, false, err
+	}
+	if n.batchIndex != idx {
+		// the leader lost its leadership before applying the entry
+		return db.Lock{}, false, ErrCommitFailed
+	}
+	lock, _ := n.Store.LockAt(record.Key, now)
+	return lock, n.batchApplied[0], nil
+}
+
+// applyLock applies a LOCK entry to store, and returns whether the lock was
+// taken (or renewed)
+func applyLock(store *db.Database, entry *raft.LogRecord) (bool, error) {
+	var lock db.Lock
+	if err := json.Unmarshal([]byte(entry.Value), &lock); err != nil {
+		return false, err
+	}
+	_, acquired := store.TryLock(entry.Key, lock)
+	return acquired, nil
+}
@@ -0,0 +1,197 @@
+package node
+
+// The log is persisted by rewriting the log file whole with each change. By
+// default the write returns as soon as the data is in the operating system's
+// cache. LogSync makes each write wait for the data to be durable--with an
+// fsync after the write, or by opening the file with O_DSYNC, which avoids a
+// separate flush of the file's metadata--at the cost of write latency. On
+// Linux, the file can also be opened with O_DIRECT as well as O_DSYNC, so that
+// writes bypass the operating system's cache rather than filling it with a
+// log that is only read back on restart. Direct writes must be aligned to
+// util.DirectAlignment, so the log is padded (as below) to a multiple of it,
+// and copied into an aligned buffer.
+//
+// Growing the file as the log grows makes the filesystem allocate blocks (and
+// journal the change in size) during the write, which makes the time taken by
+// synchronous writes erratic on a busy disk. With LogPreallocate, the file is
+// allocated ahead of time in steps of that many bytes, and rewritten in place.
+// The space after the log is declared as a padding field (reserved in the
+// LogStore message), which is skipped when the log is read, so only the
+// field's header needs to be written.
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/internal/util"
+	"google.golang.org/protobuf/encoding/protowire"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+// LogSyncMode is how writes to the log file are made durable
+type LogSyncMode string
+
+// Modes of syncing the log file
+const (
+	// LogSyncNone leaves the log to be flushed by the operating system
+	LogSyncNone LogSyncMode = "none"
+	// LogSyncFsync flushes the log file after each write
+	LogSyncFsync LogSyncMode = "fsync"
+	// LogSyncDsync opens the log file with O_DSYNC, so that each write
+	// returns once its data is durable
+	LogSyncDsync LogSyncMode = "dsync"
+	// LogSyncDirect opens the log file with O_DIRECT and O_DSYNC, so that
+	// each write bypasses the operating system's cache and returns once its
+	// data is durable (Linux only, on filesystems that support O_DIRECT)
+	LogSyncDirect LogSyncMode = "direct"
+)
+
+// DefaultLogSync is the default mode of syncing the log file
+const DefaultLogSync = LogSyncNone
+
+// logPaddingField is the number of the field in the LogStore message that
+// fills the preallocated space after the log
+const logPaddingField = 15
+
+// ParseLogSyncMode returns the LogSyncMode named by s
+func ParseLogSyncMode(s string) (LogSyncMode, error) {
+	switch mode := LogSyncMode(s); mode {
+	case LogSyncNone, LogSyncFsync, LogSyncDsync:
+		return mode, nil
+	case LogSyncDirect:
+		if util.DirectFlag == 0 {
+			return "", fmt.Errorf("Log sync mode %q is not supported on this platform", s)
+		}
+		return mode, nil
+	}
+	return "", fmt.Errorf("Invalid log sync mode %q (expected %s, %s, %s, or %s)",
+		s, LogSyncNone, LogSyncFsync, LogSyncDsync, LogSyncDirect)
+}
+
+// writeLogs persists the node's log, as configured by LogSync and
+// LogPreallocate
+func (n *Node) writeLogs(logStore *raft.LogStore) error {
+	size, err := writeLogFile(n.config.LogFile, logStore, n.config.LogSync, n.config.LogPreallocate)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&n.logBytes, size)
+	return nil
+}
+
+// LogBytes returns the size of the log as last written or loaded, which
+// excludes any space preallocated in the log file after it
+func (n *Node) LogBytes() int64 {
+	return atomic.LoadInt64(&n.logBytes)
+}
+
+// writeLogFile writes logStore to filename, syncing it as mode requires. If
+// preallocate is positive, the file is allocated in steps of that many bytes
+// (rounded up to util.DirectAlignment for direct writes) and the log is
+// written in place. It returns the size of the log
+func writeLogFile(filename string, logStore *raft.LogStore, mode LogSyncMode, preallocate int64) (int64, error) {
+	out, err := protov2.Marshal(logStore)
+	if err != nil {
+		return 0, &PersistError{Op: "marshal", File: filename, Err: err}
+	}
+	size := int64(len(out))
+
+	flags := os.O_WRONLY | os.O_CREATE
+	align := int64(1)
+	switch mode {
+	case LogSyncDsync:
+		flags |= util.DsyncFlag
+	case LogSyncDirect:
+		flags |= util.DsyncFlag | util.DirectFlag
+		align = util.DirectAlignment
+		preallocate = roundUp(preallocate, align)
+	}
+	if preallocate <= 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return 0, &PersistError{Op: "open", File: filename, Err: err}
+	}
+	if preallocate > 0 {
+		out, err = padLog(f, out, preallocate, align)
+	} else if align > 1 {
+		out = appendPadding(out, roundUp(size+2, align)-size)
+	}
+	if err == nil && align > 1 {
+		// whole blocks are written, the last ending inside the padding field
+		buf := util.AlignedBuffer(int(roundUp(int64(len(out)), align)))
+		copy(buf, out)
+		out = buf
+	}
+	if err == nil {
+		_, err = f.Write(out)
+	}
+	if err == nil && mode == LogSyncFsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, &PersistError{Op: "write", File: filename, Err: err}
+	}
+	return size, nil
+}
+
+// padLog makes sure that f has room for out followed by an empty padding
+// field, and that its size is a multiple of align, preallocating it in steps
+// of step bytes if not, and returns out followed by the header of a padding
+// field that fills the rest of the file
+func padLog(f *os.File, out []byte, step int64, align int64) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	capacity := fi.Size()
+	if need := int64(len(out)) + 2; capacity < need || capacity%align != 0 {
+		if capacity < need {
+			capacity = roundUp(need, step)
+		}
+		capacity = roundUp(capacity, align)
+		if err := util.Preallocate(f, capacity); err != nil {
+			return nil, err
+		}
+	}
+	return appendPadding(out, capacity-int64(len(out))), nil
+}
+
+// roundUp returns n rounded up to a multiple of step (n itself if step is 0
+// or less)
+func roundUp(n int64, step int64) int64 {
+	if step <= 0 {
+		return n
+	}
+	return (n + step - 1) / step * step
+}
+
+// appendPadding appends to out the headers of padding fields that span room
+// bytes (at least 2) in all
+func appendPadding(out []byte, room int64) []byte {
+	for {
+		for size := 1; size <= protowire.SizeVarint(uint64(room)); size++ {
+			length := room - 1 - int64(size)
+			if length >= 0 && protowire.SizeVarint(uint64(length)) == size {
+				out = protowire.AppendTag(out, logPaddingField, protowire.BytesType)
+				return protowire.AppendVarint(out, uint64(length))
+			}
+		}
+		// no single field spans room exactly, so fill two bytes with an
+		// empty one and try again
+		out = protowire.AppendTag(out, logPaddingField, protowire.BytesType)
+		out = protowire.AppendVarint(out, 0)
+		room -= 2
+	}
+}
+
+// decodeLogs unmarshals the contents of a log file into logStore, skipping
+// any padding
+func decodeLogs(data []byte, logStore *raft.LogStore) error {
+	return protov2.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, logStore)
+}
@@ -0,0 +1,95 @@
+package node
+
+// Messages that change the node's protocol state--requests from other members
+// (votes, appends, snapshots, and timeout-now), the start and outcome of an
+// election, the end of the grace period after one, demotion, and entries
+// proposed on the leader--are processed one at a time by the node's event
+// loop: a single goroutine that owns State, Term, votedFor, currentLeader,
+// AllowVote, and the log, on every node. Only steps write them. Each message
+// is a step sent to the loop over a channel, and the sender waits until it has
+// run. So two append requests (such as a hedged duplicate) or a vote and an
+// append never interleave, and a test drives the node one step at a time by
+// calling the handlers in order, each of which has taken effect when it
+// returns.
+//
+// Steps never take the node's lock, since a write on the leader holds it while
+// it waits for replication, and may send steps (e.g. to step down). Nor do they
+// send further steps, or wait on other members. Work that does, such as
+// collecting votes, installing a snapshot, or replicating entries, runs outside
+// the loop, between the steps that start and finish it, and reads the state it
+// needs with view, which copies it in a step. The node's lock only orders
+// writes on the leader (and other changes, such as compaction) with each
+// other.
+//
+// The commit and applied indexes are also advanced only on the loop (on the
+// leader, by commitRecords once a round of append requests is acknowledged),
+// so that rounds made outside the node's lock (such as for ReadIndex) never
+// apply an entry twice, and are published atomically (see state.go). The
+// replication state of the leader's peers (match and next indexes) is guarded
+// by each peer's own lock, since replies to append requests update it from the
+// goroutines sending them.
+
+import "github.com/btmorr/leifdb/internal/raft"
+
+// A loopStep is a function run on the event loop, and a channel closed once it
+// has run
+type loopStep struct {
+	run  func()
+	done chan struct{}
+}
+
+// loopBacklog is the number of steps that can be waiting for the loop before
+// senders block
+const loopBacklog = 64
+
+// startLoop starts the node's event loop
+func (n *Node) startLoop() {
+	n.steps = make(chan loopStep, loopBacklog)
+	go func() {
+		for step := range n.steps {
+			step.run()
+			close(step.done)
+		}
+	}()
+}
+
+// process runs f on the node's event loop (starting it, the first time), and
+// returns once f has run. It must not be called from a step
+func (n *Node) process(f func()) {
+	n.loopOnce.Do(n.startLoop)
+	step := loopStep{run: f, done: make(chan struct{})}
+	n.steps <- step
+	<-step.done
+}
+
+// A loopView is a copy of the state owned by the event loop, taken in one step
+type loopView struct {
+	state    Role
+	term     int64
+	leader   *raft.Node
+	log      *raft.LogStore
+	caughtUp bool
+}
+
+// view returns a copy of the state owned by the event loop. Like process, it
+// must not be called from a step, which reads the fields directly
+func (n *Node) view() loopView {
+	var v loopView
+	n.process(func() {
+		v = loopView{
+			state:    n.State,
+			term:     n.Term,
+			leader:   n.currentLeader,
+			log:      n.Log,
+			caughtUp: n.caughtUp}
+	})
+	return v
+}
+
+// EndGracePeriod ends the window after this node won an election in which it
+// refuses votes (see AllowVote)
+func (n *Node) EndGracePeriod() {
+	n.process(func() {
+		n.AllowVote = true
+	})
+}
@@ -0,0 +1,182 @@
+package node
+
+// Membership starts out as the members each node is configured with
+// (NodeConfig.NodeIds), and is changed while the cluster runs through the log:
+// the leader appends a MEMBERS entry listing every voting member, and each
+// node (witnesses included) adds the members listed that it does not know and
+// removes the peers that are not listed once it applies the entry. The latest
+// membership is kept in a system key (MembersKey), so it survives compaction
+// and is restored along with a snapshot.
+//
+// AddServer bootstraps a new member as a learner (see AddMember), and commits
+// the membership including it once it has caught up. RemoveServer commits the
+// membership without the member; a removed member is no longer sent entries
+// and its vote requests are refused, so it should be shut down. The leader
+// can't remove itself--transfer leadership first.
+//
+// Learners are not part of the membership in the log, so are only known to
+// the leader bootstrapping them, and are never removed by a MEMBERS entry.
+// Changing several members at once goes through a joint membership (see
+// joint.go).
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// MembersKey is the system key holding the membership last committed through
+// the log, as a JSON array of raft addresses
+const MembersKey = SystemKeyPrefix + "members"
+
+var (
+	// ErrRemoveLeader indicates that the leader was asked to remove itself from
+	// the cluster
+	ErrRemoveLeader = errors.New("The leader can't remove itself; transfer leadership first")
+
+	// ErrBootstrapFailed indicates that a member added with AddServer could not
+	// be caught up, and was removed again
+	ErrBootstrapFailed = errors.New("Member could not be bootstrapped")
+)
+
+// Members returns the raft address of every voting member of the cluster known
+// to this node (itself, and its peers that are not learners), sorted
+func (n *Node) Members() []string {
+	return n.votingMembers("")
+}
+
+// votingMembers returns Members, less exclude
+func (n *Node) votingMembers(exclude string) []string {
+	members := []string{n.RaftNode.Id}
+	for addr, peer := range n.peers.snapshot() {
+		if addr != exclude && !peer.isLearner() {
+			members = append(members, addr)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// AddServer adds a member to the cluster while this node is the leader (see
+// AddMember), and returns the new membership once the member has caught up and
+// the membership including it is committed (or an error is generated). If ctx
+// is done first, the member is still bootstrapped in the background
+func (n *Node) AddServer(ctx context.Context, addr string) ([]string, error) {
+	if _, err := n.AddMember(addr); err != nil {
+		return nil, err
+	}
+	n.Lock()
+	peer := n.bootstraps[addr]
+	n.Unlock()
+	ticker := time.NewTicker(bootstrapPollInterval)
+	defer ticker.Stop()
+	for {
+		status := peer.bootstrapStatus()
+		switch status.Phase {
+		case BootstrapPromoted:
+			return n.Members(), nil
+		case BootstrapFailed:
+			return nil, fmt.Errorf("%w: %s", ErrBootstrapFailed, status.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RemoveServer removes a member from the cluster while this node is the
+// leader, and returns the new membership once it is committed (or an error is
+// generated). A learner, which is not yet part of the membership, is removed
+// at once
+func (n *Node) RemoveServer(ctx context.Context, addr string) ([]string, error) {
+	if !n.IsLeader() {
+		return nil, ErrNotLeaderRecv
+	}
+	if addr == n.RaftNode.Id {
+		return nil, ErrRemoveLeader
+	}
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+	peer := n.peers.get(addr)
+	if peer == nil {
+		return nil, ErrPeerRemoved
+	}
+	if peer.isLearner() {
+		if err := n.RemoveForeignNode(addr); err != nil {
+			return nil, err
+		}
+		return n.Members(), nil
+	}
+	return n.commitMembers(ctx, addr)
+}
+
+// commitMembers appends an entry setting the membership to Members, less
+// exclude, and returns the membership once it is committed (or an error is
+// generated)
+func (n *Node) commitMembers(ctx context.Context, exclude string) ([]string, error) {
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return nil, ErrNotLeaderRecv
+	}
+	if n.inJoint() {
+		return nil, ErrChangeInProgress
+	}
+	members := n.votingMembers(exclude)
+	logger.Info().Strs("members", members).Msg("Committing membership")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_MEMBERS,
+		Members: members,
+	}
+	if err := n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// syncMembers adds each member listed (other than this node) that is not a
+// peer, promotes each learner listed, and removes each peer that is not
+// listed, other than learners
+func (n *Node) syncMembers(members []string) {
+	listed := make(map[string]bool, len(members))
+	for _, addr := range members {
+		listed[addr] = true
+		if addr == n.RaftNode.Id {
+			continue
+		}
+		if peer := n.peers.get(addr); peer != nil {
+			peer.lock.Lock()
+			peer.learner = false
+			peer.lock.Unlock()
+		} else {
+			n.AddForeignNode(addr)
+		}
+	}
+	for addr, peer := range n.peers.snapshot() {
+		if !listed[addr] && !peer.isLearner() {
+			n.RemoveForeignNode(addr)
+		}
+	}
+}
+
+// restoreMembers syncs the peers with the membership held in MembersKey, and
+// the joint membership in OldMembersKey, if the database has them (e.g. after
+// installing a snapshot)
+func (n *Node) restoreMembers() {
+	if !n.Store.Exists(MembersKey) {
+		return
+	}
+	var members []string
+	if err := json.Unmarshal([]byte(n.Store.Get(MembersKey)), &members); err != nil {
+		logger.Error().Err(err).Msg("Invalid membership")
+		return
+	}
+	n.syncMembers(n.restoreJoint(members))
+}
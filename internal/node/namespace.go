@@ -0,0 +1,318 @@
+package node
+
+// A namespace is the set of keys that start with its name followed by ':'
+// (the namespace of "user:42" is "user"). Each namespace can have a policy
+// restricting access to its keys, limiting their number and size, and giving
+// keys written to it a default time to live. Policies are kept in the database
+// itself, as system keys (under SystemKeyPrefix) written by ordinary SET and
+// DEL entries, so a change of policy reaches every member through the log, in
+// order with the writes it governs, and is kept across restarts and changes of
+// leader in the log and snapshots like any other key--rather than in
+// configuration that may differ from node to node. The leader enforces
+// policies on writes when they are proposed, and every node enforces them on
+// the reads it serves. Clients can't write system keys themselves.
+//
+// LeifDB does not authenticate clients, so a namespace's access applies to
+// every client alike.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// SystemKeyPrefix starts the keys reserved for the cluster's own state
+const SystemKeyPrefix = "_leifdb/"
+
+// namespacePolicyPrefix starts the system key holding each namespace's policy
+const namespacePolicyPrefix = SystemKeyPrefix + "namespace/"
+
+// NamespaceAccess is what clients may do with the keys in a namespace
+type NamespaceAccess string
+
+// Kinds of access to a namespace
+const (
+	// AccessReadWrite allows reads and writes (the default)
+	AccessReadWrite NamespaceAccess = "readwrite"
+	// AccessReadOnly allows reads, and rejects writes
+	AccessReadOnly NamespaceAccess = "readonly"
+	// AccessNone rejects reads and writes
+	AccessNone NamespaceAccess = "none"
+)
+
+var (
+	// ErrSystemKey indicates a client write to a key reserved for the
+	// cluster's own state
+	ErrSystemKey = errors.New("Keys starting with " + SystemKeyPrefix + " are reserved")
+
+	// ErrInvalidNamespace indicates a namespace name that is empty or has a
+	// ':' or '/' in it
+	ErrInvalidNamespace = errors.New("Namespace must be non-empty, without ':' or '/'")
+
+	// ErrInvalidPolicy indicates a namespace policy with an unknown access or
+	// a negative limit
+	ErrInvalidPolicy = errors.New("Invalid namespace policy")
+
+	// ErrNamespaceDenied matches (with errors.Is) a NamespaceError for a read
+	// or write that the namespace's access does not allow
+	ErrNamespaceDenied = errors.New("Access to namespace denied")
+
+	// ErrNamespaceQuota matches (with errors.Is) a NamespaceError for a write
+	// that would exceed one of the namespace's limits
+	ErrNamespaceQuota = errors.New("Namespace quota exceeded")
+)
+
+// A NamespacePolicy governs the keys in a namespace. Zero values of the limits
+// mean no limit
+type NamespacePolicy struct {
+	// What clients may do with the keys (AccessReadWrite if empty)
+	Access NamespaceAccess `json:"access,omitempty"`
+	// Number of keys the namespace may hold
+	MaxKeys int64 `json:"maxKeys,omitempty"`
+	// Size of each value, in bytes
+	MaxValueBytes int64 `json:"maxValueBytes,omitempty"`
+	// Time to live of keys set in the namespace, in milliseconds, restarted
+	// by each write (a batch, transaction, or compare-and-set leaves the
+	// expiry of the keys it sets alone)
+	DefaultTTLMs int64 `json:"defaultTtlMs,omitempty"`
+}
+
+// validate returns ErrInvalidPolicy if p has an unknown access or a negative
+// limit
+func (p NamespacePolicy) validate() error {
+	switch p.Access {
+	case "", AccessReadWrite, AccessReadOnly, AccessNone:
+	default:
+		return fmt.Errorf("%w: unknown access %q", ErrInvalidPolicy, p.Access)
+	}
+	if p.MaxKeys < 0 || p.MaxValueBytes < 0 || p.DefaultTTLMs < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// A NamespaceError reports a read or write rejected by a namespace's policy
+type NamespaceError struct {
+	Namespace string
+	// ErrNamespaceDenied or ErrNamespaceQuota
+	Err    error
+	Detail string
+}
+
+func (e *NamespaceError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.Err, e.Namespace, e.Detail)
+}
+
+// Is reports whether target is the kind of error e is
+func (e *NamespaceError) Is(target error) bool {
+	return target == e.Err
+}
+
+// Namespace returns the namespace of key: the part before its first ':', or
+// an empty string if it has none
+func Namespace(key string) string {
+	if i := strings.Index(key, ":"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// IsSystemKey reports whether key is reserved for the cluster's own state
+func IsSystemKey(key string) bool {
+	return strings.HasPrefix(key, SystemKeyPrefix)
+}
+
+// validNamespace returns ErrInvalidNamespace if name can't be a namespace
+func validNamespace(name string) error {
+	if name == "" || strings.ContainsAny(name, ":/") {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+// NamespacePolicy returns the policy of a namespace, as of the last entry
+// applied by this node, and whether it has one
+func (n *Node) NamespacePolicy(name string) (NamespacePolicy, bool) {
+	var policy NamespacePolicy
+	key := namespacePolicyPrefix + name
+	if name == "" || !n.Store.Exists(key) {
+		return policy, false
+	}
+	if err := json.Unmarshal([]byte(n.Store.Get(key)), &policy); err != nil {
+		logger.Error().Err(err).Str("namespace", name).Msg("Invalid namespace policy")
+		return policy, false
+	}
+	return policy, true
+}
+
+// NamespacePolicies returns the policy of every namespace that has one, by
+// name
+func (n *Node) NamespacePolicies() map[string]NamespacePolicy {
+	policies := map[string]NamespacePolicy{}
+	cursor := n.Store.PrefixCursor(namespacePolicyPrefix)
+	for key, _, ok := cursor.Next(); ok; key, _, ok = cursor.Next() {
+		name := strings.TrimPrefix(key, namespacePolicyPrefix)
+		if policy, ok := n.NamespacePolicy(name); ok {
+			policies[name] = policy
+		}
+	}
+	return policies
+}
+
+// NamespaceKeys returns the number of keys in a namespace
+func (n *Node) NamespaceKeys(name string) int {
+	return n.Store.CountPrefix(name + ":")
+}
+
+type systemWriteKey struct{}
+
+// isSystemWrite reports whether ctx is for a write of a system key by the node
+// itself, which namespace policies do not apply to
+func isSystemWrite(ctx context.Context) bool {
+	system, _ := ctx.Value(systemWriteKey{}).(bool)
+	return system
+}
+
+// SetNamespacePolicy appends an entry setting the policy of a namespace, and
+// returns once it is committed (or an error is generated). The policy applies
+// to writes proposed after that, but not to keys already in the namespace
+func (n *Node) SetNamespacePolicy(ctx context.Context, name string, policy NamespacePolicy) error {
+	if err := validNamespace(name); err != nil {
+		return err
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	value, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	logger.Info().Str("namespace", name).Str("policy", string(value)).Msg("SetNamespacePolicy")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_SET,
+		Key:    namespacePolicyPrefix + name,
+		Value:  string(value),
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+}
+
+// DeleteNamespacePolicy appends an entry removing the policy of a namespace,
+// and returns whether it had one (no entry is appended if not) once the entry
+// is committed or an error is generated
+func (n *Node) DeleteNamespacePolicy(ctx context.Context, name string) (bool, error) {
+	if err := validNamespace(name); err != nil {
+		return false, err
+	}
+	logger.Info().Str("namespace", name).Msg("DeleteNamespacePolicy")
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return false, ErrNotLeaderRecv
+	}
+	key := namespacePolicyPrefix + name
+	if !n.Store.Exists(key) {
+		return false, nil
+	}
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_DEL,
+		Key:    key,
+	}
+	return true, n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+}
+
+// CheckRead returns a NamespaceError if the policy of key's namespace does not
+// allow it to be read
+func (n *Node) CheckRead(key string) error {
+	name := Namespace(key)
+	if policy, ok := n.NamespacePolicy(name); ok && policy.Access == AccessNone {
+		return &NamespaceError{Namespace: name, Err: ErrNamespaceDenied, Detail: "reads are not allowed"}
+	}
+	return nil
+}
+
+// checkNamespaces returns ErrSystemKey if record (or any operation of a batch
+// or transaction) writes a system key (or a rename or copy reads one), or a NamespaceError if it is not
+// allowed by the policy of a key's namespace. A set of a key in a namespace
+// with a default time to live is given an expiry time
+func (n *Node) checkNamespaces(ctx context.Context, record *raft.LogRecord) error {
+	if isSystemWrite(ctx) {
+		return nil
+	}
+	ops := []*raft.LogRecord{record}
+	// keys each namespace gains from earlier operations of a batch
+	added := map[string]int64{}
+	switch record.Action {
+	case raft.LogRecord_BATCH, raft.LogRecord_TXN:
+		ops = make([]*raft.LogRecord, 0, len(record.Ops)+len(record.ElseOps))
+		ops = append(ops, record.Ops...)
+		ops = append(ops, record.ElseOps...)
+	case raft.LogRecord_RENAME, raft.LogRecord_COPY:
+		// the key read, as it is now, is set as the key written (and a
+		// rename deletes the key read, so its namespace loses a key)
+		if IsSystemKey(record.Key) {
+			return ErrSystemKey
+		}
+		if err := n.CheckRead(record.Key); err != nil {
+			return err
+		}
+		ops = []*raft.LogRecord{{
+			Action: raft.LogRecord_SET,
+			Key:    record.Value,
+			Value:  n.Store.Get(record.Key)}}
+		if record.Action == raft.LogRecord_RENAME {
+			ops = append(ops, &raft.LogRecord{Action: raft.LogRecord_DEL, Key: record.Key})
+			if n.Store.Exists(record.Key) {
+				added[Namespace(record.Key)]--
+			}
+		}
+	}
+	for _, op := range ops {
+		switch op.Action {
+		case raft.LogRecord_CUSTOM, raft.LogRecord_CREATE_INDEX, raft.LogRecord_DROP_INDEX,
+			raft.LogRecord_EXPIRE, raft.LogRecord_READ_ONLY, raft.LogRecord_CHUNK, raft.LogRecord_MEMBERS:
+			// not a client write to a key
+			continue
+		}
+		if IsSystemKey(op.Key) {
+			return ErrSystemKey
+		}
+		name := Namespace(op.Key)
+		policy, ok := n.NamespacePolicy(name)
+		if !ok {
+			continue
+		}
+		if policy.Access == AccessReadOnly || policy.Access == AccessNone {
+			return &NamespaceError{Namespace: name, Err: ErrNamespaceDenied, Detail: "writes are not allowed"}
+		}
+		switch op.Action {
+		case raft.LogRecord_SET, raft.LogRecord_CAS, raft.LogRecord_SET_CHUNKED, raft.LogRecord_RESTORE:
+		default:
+			continue
+		}
+		if max := policy.MaxValueBytes; max > 0 && int64(len(op.Value)) > max {
+			return &NamespaceError{
+				Namespace: name,
+				Err:       ErrNamespaceQuota,
+				Detail:    fmt.Sprintf("values are limited to %d bytes", max)}
+		}
+		if max := policy.MaxKeys; max > 0 && !n.Store.Exists(op.Key) {
+			if int64(n.NamespaceKeys(name))+added[name] >= max {
+				return &NamespaceError{
+					Namespace: name,
+					Err:       ErrNamespaceQuota,
+					Detail:    fmt.Sprintf("limited to %d keys", max)}
+			}
+			added[name]++
+		}
+		if ttl := policy.DefaultTTLMs; ttl > 0 && op == record && op.Action != raft.LogRecord_CAS {
+			op.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond).UnixNano()
+		}
+	}
+	return nil
+}
@@ -2,21 +2,36 @@ package node
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/metrics"
 	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/internal/ratelimit"
+	"github.com/btmorr/leifdb/retry"
 )
 
+// logger logs replication, elections, and membership
+var logger = logging.For(logging.Raft)
+
 // Role is either Leader or Follower
 type Role string
 
@@ -59,14 +74,292 @@ var (
 	//
 	ErrCommitFailed = errors.New("Failed to commit record")
 
+	// ErrCommitUncertain indicates that a write was added to the leader's log,
+	// but was not known to be committed when the leader stopped waiting for it
+	// (see CommitUncertainError and EntryStatus)
+	ErrCommitUncertain = errors.New("Write may or may not be committed")
+
 	// ErrAppendRangeMet indicates that reverse-iteration has reached the
 	// beginning of the log and still not gotten a response--aborting
 	//
 	// ???
 	ErrAppendRangeMet = errors.New("Append range reached, not trying again")
+
+	// ErrWitnessRead indicates that a client attempted to read from a witness
+	// node, which does not store the database
+	ErrWitnessRead = errors.New("Witness nodes do not serve reads")
+
+	// ErrAppendInFlight indicates that an append request was not sent because
+	// the limit on outstanding append requests (to the peer, or overall) has
+	// been reached--the entries will be included in a later request
+	ErrAppendInFlight = errors.New("Too many append requests in flight")
+
+	// ErrUnknownCommand indicates that a custom entry was proposed or applied
+	// for a command that has no registered handler
+	ErrUnknownCommand = errors.New("No handler registered for command")
+
+	// ErrNotCaughtUp indicates that a client attempted to read from a node
+	// that has not yet caught up with the leader since starting
+	ErrNotCaughtUp = errors.New("Node has not caught up with the leader")
+
+	// ErrEntriesCompacted indicates that a follower needs log entries that the
+	// leader has already compacted into a snapshot
+	ErrEntriesCompacted = errors.New("Requested log entries have been compacted")
+
+	// ErrProposalBudget indicates that a write was rejected because the writes
+	// already waiting to be committed exceed the leader's memory budget--the
+	// write may be retried later
+	ErrProposalBudget = errors.New("Too many pending writes, try again later")
+
+	// ErrDraining indicates that a write was rejected because the node is
+	// being drained for maintenance
+	ErrDraining = errors.New("Node is draining, not accepting writes")
+
+	// ErrNoTransferTarget indicates that leadership could not be transferred
+	// because no follower is up to date and able to stand for election
+	ErrNoTransferTarget = errors.New("No follower is able to take over leadership")
+
+	// ErrTransferNotLeader indicates a request to transfer leadership from a
+	// node that is not the leader
+	ErrTransferNotLeader = errors.New("Only the leader can transfer leadership")
+
+	// ErrInvalidWriteConcern indicates that a client requested a write concern
+	// that is not one of the defined levels
+	ErrInvalidWriteConcern = errors.New("Write concern must be leader, majority, or apply")
+
+	// ErrBatchConflict indicates that an all-or-nothing batch was not applied
+	// because one of its compare-and-swap operations failed
+	ErrBatchConflict = errors.New("Batch not applied, compare-and-swap failed")
+)
+
+// A CommitUncertainError is returned for a write that was added to the
+// leader's log at Index (in term Term), but not known to be committed before
+// the commit deadline passed, the caller gave up, or the leader lost its
+// leadership. The entry may still be committed: EntryStatus (on any node)
+// tells whether it was
+type CommitUncertainError struct {
+	Index int64
+	Term  int64
+	Err   error
+}
+
+func (e *CommitUncertainError) Error() string {
+	return fmt.Sprintf("%s (entry %d in term %d): %v", ErrCommitUncertain, e.Index, e.Term, e.Err)
+}
+
+func (e *CommitUncertainError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCommitUncertain, so that errors.Is matches
+// any CommitUncertainError
+func (e *CommitUncertainError) Is(target error) bool {
+	return target == ErrCommitUncertain
+}
+
+// An EntryStatus is the fate of a log entry, identified by its index and term
+type EntryStatus string
+
+// EntryCommitted: the entry is committed, and will be (or has been) applied
+// EntryPending: the entry is in the log, but not yet committed
+// EntryLost: a different entry was committed at the index, so the entry never
+// will be
+// EntryUnknown: this node can't tell, e.g. because it has not received the
+// entry yet, or the index has been compacted
+const (
+	EntryCommitted EntryStatus = "committed"
+	EntryPending   EntryStatus = "pending"
+	EntryLost      EntryStatus = "lost"
+	EntryUnknown   EntryStatus = "unknown"
+)
+
+// writePhaseSeconds times the phases of a write, to show whether slow writes
+// are waiting on disk or on the network
+var writePhaseSeconds = metrics.NewHistogram(
+	"leifdb_write_phase_seconds",
+	"Time spent in each phase of a write: queue, persist, replicate, commit, apply",
+	"phase",
+	metrics.DefaultLatencyBuckets)
+
+// Per-peer replication metrics, showing which peers a leader's commits wait
+// for. The share of rounds (leifdb_append_rounds_total) in which a peer was
+// part of the quorum shows how often commit latency depends on it
+var (
+	appendRounds = metrics.NewCounter(
+		"leifdb_append_rounds_total",
+		"Rounds of append requests sent by this node as leader")
+	appendQuorum = metrics.NewCounterVec(
+		"leifdb_append_quorum_total",
+		"Append rounds in which the peer's acknowledgement counted toward the quorum",
+		"peer")
+	appendLate = metrics.NewCounterVec(
+		"leifdb_append_late_total",
+		"Append rounds in which the peer acknowledged after a quorum was reached without it",
+		"peer")
+	catchUpBytes = metrics.NewCounterVec(
+		"leifdb_catchup_bytes_total",
+		"Bytes of already-committed entries sent to the peer (counted when a catch-up limit is set)",
+		"peer")
+	catchUpThrottled = metrics.NewCounterVec(
+		"leifdb_catchup_throttled_total",
+		"Append requests to the peer cut short by its catch-up rate limit",
+		"peer")
+	appendLatency = metrics.NewHistogram(
+		"leifdb_append_latency_seconds",
+		"Time for a peer to acknowledge an append request",
+		"peer",
+		metrics.DefaultLatencyBuckets)
+)
+
+// observePhase records the time since start as the duration of a write phase
+func observePhase(phase string, start time.Time) {
+	writePhaseSeconds.Observe(phase, time.Since(start).Seconds())
+}
+
+// Defaults for limits on outstanding append requests (see NodeConfig)
+const (
+	DefaultMaxInflightPerPeer = 4
+	DefaultMaxInflight        = 256
+)
+
+// DefaultMaxProposalBytes is the default memory budget for pending writes (see
+// NodeConfig)
+const DefaultMaxProposalBytes = 64 << 20
+
+// DefaultValueChunkSize is the default size above which values are replicated
+// in chunks (see NodeConfig)
+const DefaultValueChunkSize = 1 << 20
+
+// DefaultHedgeMaxPercent is the default cap on hedged requests, as a
+// percentage of the requests that may be hedged (see NodeConfig)
+const DefaultHedgeMaxPercent = 10
+
+// DefaultCommitTimeout is the default time a write waits to be committed before
+// CommitUncertainError is returned (see NodeConfig)
+const DefaultCommitTimeout = 2 * time.Second
+
+// DefaultVersion is the version a node reports to the other members if none is
+// configured (see NodeConfig)
+const DefaultVersion = "unknown"
+
+// commitRetryDelay is the pause between rounds of append requests while a write
+// waits to be committed
+const commitRetryDelay = 10 * time.Millisecond
+
+// DefaultUnknownNodeBackoff is the default wait before standing for election
+// again when rejected as an unknown node (see NodeConfig)
+const DefaultUnknownNodeBackoff = 30 * time.Second
+
+// WriteConcern is the point at which a write is acknowledged to the client
+type WriteConcern string
+
+// WriteConcernLeader acknowledges a write once it is persisted in the leader's
+// log. It is replicated in the background, and is lost if the leader fails
+// before a majority has it
+// WriteConcernMajority acknowledges a write once it is committed (persisted by
+// a majority of the cluster) and applied on the leader. This is the default
+// WriteConcernApply acknowledges a write once a majority of the cluster has
+// also applied it, so that it is visible in reads from those followers
+const (
+	WriteConcernLeader   WriteConcern = "leader"
+	WriteConcernMajority WriteConcern = "majority"
+	WriteConcernApply    WriteConcern = "apply"
 )
 
+// ParseWriteConcern returns the write concern named by s, or the default
+// (WriteConcernMajority) if s is empty
+func ParseWriteConcern(s string) (WriteConcern, error) {
+	switch WriteConcern(s) {
+	case "":
+		return WriteConcernMajority, nil
+	case WriteConcernLeader, WriteConcernMajority, WriteConcernApply:
+		return WriteConcern(s), nil
+	}
+	return "", ErrInvalidWriteConcern
+}
+
+type writeConcernKey struct{}
+
+// WithWriteConcern returns a copy of ctx carrying a write concern, for use with
+// the write methods of Node (which otherwise use WriteConcernMajority)
+func WithWriteConcern(ctx context.Context, concern WriteConcern) context.Context {
+	return context.WithValue(ctx, writeConcernKey{}, concern)
+}
+
+// writeConcern returns the write concern carried by ctx, or the default
+func writeConcern(ctx context.Context) WriteConcern {
+	if concern, ok := ctx.Value(writeConcernKey{}).(WriteConcern); ok {
+		return concern
+	}
+	return WriteConcernMajority
+}
+
+// A WriteReceipt is where a write was placed in the log: the index and term of
+// its entry (of the last, for a write made of several entries). A client can
+// use it as a causality token--e.g. to wait until a replica has applied
+// through Index before reading from it
+type WriteReceipt struct {
+	Index int64
+	Term  int64
+}
+
+type writeReceiptKey struct{}
+
+// WithWriteReceipt returns a copy of ctx, for use with the write methods of
+// Node, and a receipt that is filled in once a write made with it succeeds
+// (Index is -1 until then, and stays -1 for a write that appends no entry)
+func WithWriteReceipt(ctx context.Context) (context.Context, *WriteReceipt) {
+	receipt := &WriteReceipt{Index: -1, Term: -1}
+	return context.WithValue(ctx, writeReceiptKey{}, receipt), receipt
+}
+
+// recordWrite fills in the receipt carried by ctx, if any
+func recordWrite(ctx context.Context, index, term int64) {
+	if receipt, ok := ctx.Value(writeReceiptKey{}).(*WriteReceipt); ok {
+		receipt.Index = index
+		receipt.Term = term
+	}
+}
+
+// RequestIDMetadataKey is the gRPC metadata key under which append requests
+// carry the request IDs of their entries (and replies echo them)
+const RequestIDMetadataKey = "leifdb-request-ids"
+
+type requestIDKey struct{}
 
+// WithRequestID returns a copy of ctx carrying the ID of a client request, for
+// use with the write methods of Node. The ID is stored in the log entries for
+// the write, and logged as each node appends and applies them, so that the
+// write can be followed across the cluster
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the request ID carried by ctx, or a new one
+func requestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	return NewRequestID()
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDs returns the request IDs of entries, comma-separated
+func requestIDs(entries []*raft.LogRecord) string {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.RequestId != "" {
+			ids = append(ids, entry.RequestId)
+		}
+	}
+	return strings.Join(ids, ",")
+}
 
 // A ForeignNode is another member of the cluster, with connections needed
 // to manage gRPC interaction with that node and track recent availability
@@ -75,13 +368,57 @@ var (
 type ForeignNode struct {
 	Connection *grpc.ClientConn
 	Client     raft.RaftClient
+	// the next entry to send the node, the last entry it is known to have,
+	// and whether it answered the last request, which are updated by the
+	// goroutines sending to it, so guarded by lock
 	NextIndex  int64
 	MatchIndex int64
 	Available  bool
+	// round-trip time of the last successful ping, guarded by lock
+	RTT time.Duration
+	// limits the rate of already-committed entries sent to the node while it
+	// catches up (nil for no limit)
+	catchUp *ratelimit.Limiter
+	// requests waiting for a sender (see submit), the slots limiting how many
+	// are queued or being sent, and a channel closed to stop the senders
+	queue   chan *sendJob
+	slots   chan struct{}
+	stopped chan struct{}
+	// progress and version reported in the peer's last reply (see
+	// peerProgress)
+	progress *peerProgress
+	// guards Connection and Client, which are replaced on reconnect, and
+	// progress
+	lock sync.Mutex
+	// extra options for the connection, kept for reconnecting
+	dialOptions []grpc.DialOption
+	// whether the node is being bootstrapped (see bootstrap.go), so does not
+	// count toward the majority, and the progress of bootstrapping it (nil if
+	// it was not added with AddMember), both guarded by lock
+	learner   bool
+	bootstrap *BootstrapStatus
+	// whether the node is being sent a snapshot because the entries it needs
+	// have been compacted (see install.go), guarded by lock
+	installing bool
+	// until when the node is isolated from this one (see isolate.go), guarded
+	// by lock
+	isolatedUntil time.Time
+	// the estimated offset of the node's clock from this one's, if known (see
+	// skew.go), guarded by lock
+	offset      time.Duration
+	offsetKnown bool
+	// whether the node is a learner that stays one until promoted (added by
+	// AddLearner, or demoted), since when it has kept up with the leader, and
+	// when its availability changed within the flap window (see
+	// autopromote.go), guarded by lock
+	standing    bool
+	keptUpSince time.Time
+	flaps       []time.Time
 }
 
-// NewForeignNode constructs a ForeignNode from an address ("host:port")
-func NewForeignNode(address string) (*ForeignNode, error) {
+// NewForeignNode constructs a ForeignNode from an address ("host:port"), with
+// any extra options for its connection
+func NewForeignNode(address string, opts ...grpc.DialOption) (*ForeignNode, error) {
 
 	// 超时控制
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
@@ -91,9 +428,9 @@ func NewForeignNode(address string) (*ForeignNode, error) {
 	conn, err := grpc.DialContext(
 		ctx,
 		address,
-		grpc.WithInsecure())
+		append([]grpc.DialOption{grpc.WithInsecure()}, opts...)...)
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to connect to %s", address)
+		logger.Error().Err(err).Msgf("Failed to connect to %s", address)
 		return nil, err
 	}
 
@@ -102,14 +439,45 @@ func NewForeignNode(address string) (*ForeignNode, error) {
 
 	//
 	return &ForeignNode{
-		Connection: conn,
-		Client:     client,
-		NextIndex:  0,
-		MatchIndex: -1,
-		Available:  true,
+		Connection:  conn,
+		Client:      client,
+		NextIndex:   0,
+		MatchIndex:  -1,
+		Available:   true,
+		dialOptions: opts,
 	}, err
 }
 
+// trySemaphore takes a slot from a semaphore channel without blocking, and
+// returns false if none is available (a nil channel is unlimited)
+func trySemaphore(sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSemaphore frees a slot taken by `trySemaphore`
+func releaseSemaphore(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// newSemaphore makes a semaphore channel with `size` slots, or nil (meaning
+// unlimited) if size is not positive
+func newSemaphore(size int) chan struct{} {
+	if size <= 0 {
+		return nil
+	}
+	return make(chan struct{}, size)
+}
+
 // Close cleans up the gRPC connection with the foreign node
 // 关闭 grpc 连接
 func (f *ForeignNode) Close() {
@@ -119,14 +487,163 @@ func (f *ForeignNode) Close() {
 // NodeConfig contains configurable properties for a node
 // 节点配置
 type NodeConfig struct {
-	Id         string		// 节点 ID
-	ClientAddr string		// 节点 Addr
-	DataDir    string		// 数据目录
-	TermFile   string		// 临时目录
-	LogFile    string		// 日志文件
-	NodeIds    []string		// 节点列表
+	Id         string   // 节点 ID
+	ClientAddr string   // 节点 Addr
+	DataDir    string   // 数据目录
+	TermFile   string   // 临时目录
+	LogFile    string   // 日志文件
+	NodeIds    []string // 节点列表
+	Witness    bool
+	// Limits on outstanding append requests to each peer, and to all peers
+	// combined (a limit of 0 or less means unlimited)
+	MaxInflightPerPeer int
+	MaxInflight        int
+	// How far behind the leader's commit index (as observed in the first
+	// append after starting) a node may be when it starts serving reads
+	CatchUpLag int64
+	// Values larger than this many bytes are split into chunks of at most this
+	// size, each replicated in its own log entry (0 or less disables chunking)
+	ValueChunkSize int
+	// Limit on the total size of the keys and values of writes waiting to be
+	// committed on the leader (0 or less means unlimited)
+	MaxProposalBytes int64
+	// Vote requests, and the append requests that confirm leadership for
+	// reads, are sent again if no reply arrives within this delay (0 or less
+	// disables hedging). At most HedgeMaxPercent percent of requests are
+	// hedged
+	HedgeDelay      time.Duration
+	HedgeMaxPercent int
+	// Read barriers are answered without a round of append requests of their
+	// own if leadership was confirmed by a round started within this window,
+	// or share a round in flight (0 or less gives each barrier its own round;
+	// see readindex.go). It should not exceed the interval between heartbeats
+	ReadIndexWindow time.Duration
+	// The leader serves linearizable reads without a round of append requests
+	// while a round it started within this lease was acknowledged by a
+	// majority, and followers refuse votes for this long after hearing from
+	// the leader (0 or less disables the lease; see lease.go). It should not
+	// exceed the minimum election timeout
+	LeaderLease time.Duration
+	// Lease reads are disabled while a peer's clock is estimated to be off
+	// from the leader's by more than this (0 or less never disables them; see
+	// skew.go)
+	MaxClockSkew time.Duration
+	// The leader promotes a learner added by AddLearner once its match index
+	// has stayed within AutoPromoteLag entries of the commit index for
+	// AutoPromoteAfter (0 or less leaves it to Promote), and demotes a voter
+	// whose availability changed AutoDemoteFlaps times within
+	// AutoDemoteWindow to a learner (0 or less never demotes; see
+	// autopromote.go)
+	AutoPromoteAfter time.Duration
+	AutoPromoteLag   int64
+	AutoDemoteFlaps  int
+	AutoDemoteWindow time.Duration
+	// Up to this many committed entries that only set, delete, or change the
+	// expiry of keys are applied to the database as one batch (1 or less
+	// applies each entry on its own; see applybatch.go)
+	ApplyBatchSize int
+	// Deleted values are kept in the trash for this long, during which they
+	// can be restored (0 or less deletes them outright; see trash.go)
+	TrashTTL time.Duration
+	// Sets and deletes proposed at about the same time are appended to the
+	// log together, and committed by one round of append requests: up to
+	// ProposalBatchEntries writes and ProposalBatchBytes bytes of keys and
+	// values, waiting up to ProposalBatchDelay for writes to join (entries of
+	// 1 or less disables batching; see proposals.go)
+	ProposalBatchDelay   time.Duration
+	ProposalBatchEntries int
+	ProposalBatchBytes   int64
+	// Whether the leader publishes the membership of the cluster to
+	// TopologyKey, and the file (if any) each node writes it to when applied
+	// (see topology.go)
+	PublishTopology bool
+	TopologyFile    string
+	// Limits in bytes per second on entries that are already committed, sent
+	// to each peer that is catching up, on snapshots served for download, and
+	// on snapshots written to the data directory (0 or less means unlimited)
+	CatchUpBytesPerSecond       int64
+	SnapshotBytesPerSecond      int64
+	SnapshotWriteBytesPerSecond int64
+	// How long a node waits before standing for election again after an
+	// election lost because other members don't recognize it as a member
+	UnknownNodeBackoff time.Duration
+	// Bounds of the window of the random delay added to the election timeout
+	// after a failed election, which starts at ElectionBackoffBase and doubles
+	// with each consecutive failure up to ElectionBackoffMax (a base of 0 or
+	// less disables the backoff)
+	ElectionBackoffBase time.Duration
+	ElectionBackoffMax  time.Duration
+	// How rounds of append requests that miss a majority are retried, and how
+	// long to wait between attempts to reconnect to a peer (see retry.go)
+	AppendRetry    retry.Policy
+	ReconnectRetry retry.Policy
+	// How long a write waits to be committed before CommitUncertainError is
+	// returned (0 or less to give up after one round of append requests)
+	CommitTimeout time.Duration
+	// Identifies the cluster the node belongs to (optional). It is recorded in
+	// the data directory, so that state from another cluster is not mistaken
+	// for this one's (see CheckState)
+	ClusterId string
+	// Version of leifdb the node runs, reported to the other members in its
+	// replies to their requests
+	Version string
+	// How writes to the log file are made durable, and the steps in bytes in
+	// which the log file is preallocated (0 or less disables preallocation)
+	LogSync        LogSyncMode
+	LogPreallocate int64
+	// Low-priority requests are shed while the node has more than
+	// ShedApplyLag entries committed by the leader left to apply, or more than
+	// ShedQueuedWrites writes waiting (0 or less disables either)
+	ShedApplyLag     int64
+	ShedQueuedWrites int64
+}
+
+// A CommandHandler applies the payload of an application-defined (CUSTOM) log
+// entry. Handlers are called in log order on every node as entries are applied,
+// so they must be deterministic. An error is logged, but does not stop later
+// entries from being applied
+type CommandHandler func(data []byte) error
+
+// An ApplyHook is called after each log entry is applied, with the index of the
+// entry, the entry itself, and the result of applying it (an error is only
+// possible for CUSTOM entries, and for BATCH entries that were not applied).
+// Hooks can be used to maintain derived state, such as caches or change feeds,
+// as the database changes
+type ApplyHook func(index int64, entry *raft.LogRecord, result error)
+
+// EventType identifies a change in cluster state reported to event listeners
+type EventType string
+
+// EventLeaderChange is emitted when this node learns of a new leader (or
+// becomes the leader itself)
+// EventMemberAdded and EventMemberRemoved are emitted when the membership of
+// the cluster changes
+// EventPeerAvailable and EventPeerUnavailable are emitted when requests to
+// another member start succeeding or failing
+// EventQuarantined is emitted when this node is quarantined (see Quarantine)
+const (
+	EventLeaderChange    EventType = "leader_change"
+	EventMemberAdded     EventType = "member_added"
+	EventMemberRemoved   EventType = "member_removed"
+	EventPeerAvailable   EventType = "peer_available"
+	EventPeerUnavailable EventType = "peer_unavailable"
+	EventQuarantined     EventType = "quarantined"
+)
+
+// An Event describes a change in cluster state observed by this node. Node is
+// the id of the member the event is about (the new leader, or the member that
+// was added, removed, or changed availability)
+type Event struct {
+	Type EventType `json:"type"`
+	Term int64     `json:"term"`
+	Node string    `json:"node"`
+	Time time.Time `json:"time"`
 }
 
+// An EventListener is called synchronously when an event occurs, so it should
+// not block
+type EventListener func(Event)
+
 // ForeignNodeChecker functions are used to determine if a request comes from
 // a valid participant in a cluster. It should generally check against a
 // configuration file or other canonical record of membership, but can also
@@ -148,34 +665,200 @@ type ForeignNodeChecker func(string, map[string]*ForeignNode) bool
 // 注意 Candidate 是一个虚拟角色，Candidate 与 Follower 收到消息后的行为没有区别，
 // 因此 Candidate 节点将在选举过程中保持 Follower 状态。
 type Node struct {
-	RaftNode         *raft.Node
+	RaftNode *raft.Node
+	// term, vote, and log, and commit and applied indexes (see state.go)
+	PersistentState
+	VolatileState
 	State            Role
-	Term             int64
-	votedFor         *raft.Node
+	currentLeader    *raft.Node
 	Reset            chan bool
-	otherNodes       map[string]*ForeignNode
+	peers            *peerSet
 	CheckForeignNode ForeignNodeChecker
 	AllowVote        bool
-	CommitIndex      int64
-	lastApplied      int64
-	Log              *raft.LogStore
+	// size of the log as last written, read with atomic operations (see
+	// LogBytes)
+	logBytes int64
+	// leader's commit index as of its last append request, and the number of
+	// writes waiting for the lock (both atomic, see Backlog)
+	leaderCommit     int64
+	queuedWrites     int64
+	writeQueue       *writeQueue
 	config           NodeConfig
 	Store            *db.Database
+	inflight         chan struct{}
+	commands         map[string]CommandHandler
+	applyHooks       []ApplyHook
+	listeners        []EventListener
+	caughtUp         bool
+	catchUpTarget    int64
+	hasCatchUpTarget bool
+	proposalBytes    int64
+	draining         bool
+	readOnly         string
+	leaseTerm        int64
+	leaseStart       time.Time
+	freshAsOf        time.Time
+	proposalLock     sync.Mutex
+	batchIndex       int64
+	batchApplied     []bool
+	hedges           *hedgeBudget
+	snapshotLimiter  *ratelimit.Limiter
+	writeLimiter     *ratelimit.Limiter
+	quarantine       string
+	appliedHash      uint64
+	hashIndex        int64
+	hashValid        bool
+	recentHashes     []appliedHashAt
+	expectedHash     appliedHashAt
+	electionsAfter   time.Time
+	backoff          *electionBackoff
+	appliedLock      sync.Mutex
+	appliedNotify    chan struct{}
+	// members added with AddMember, by address, for reporting their progress
+	bootstraps map[string]*ForeignNode
+	// the joint membership during a membership change (see joint.go)
+	joint     *configuration
+	jointLock sync.Mutex
+	// the read barrier round in flight (see readindex.go)
+	readRounds readRounds
+	// 1 while lease reads are disabled because a peer's clock is skewed (see
+	// skew.go), accessed atomically
+	clockSkewed int32
+	// the term in which a leadership transfer was started, in which the
+	// leader lease is not used, and when this node last heard from a leader
+	// (see lease.go)
+	transferTerm  int64
+	leaderContact time.Time
+	// the limits on batches of writes, and the open batches (see proposals.go)
+	proposals proposalBatcher
+	// take and install the snapshots that bootstrap new members (see
+	// bootstrap.go; the in-memory database is used if nil)
+	BuildSnapshot   SnapshotBuilder
+	InstallSnapshot SnapshotInstaller
+	// the event loop that processes protocol messages, started by the first
+	// (see loop.go)
+	steps    chan loopStep
+	loopOnce sync.Once
 	sync.Mutex
 }
 
+// IsWitness reports whether this node is a witness, which votes and counts
+// toward quorum for appends, but only stores log metadata (never values), so
+// it neither becomes a leader nor serves reads
+func (n *Node) IsWitness() bool {
+	return n.config.Witness
+}
+
+// DiskUsage returns the total size in bytes of the files in the node's data
+// directory (its log, term file, and snapshots)
+func (n *Node) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.Walk(n.config.DataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// CaughtUp reports whether the node may serve reads: either it is the leader
+// and has applied an entry of its own term (so every entry committed by earlier
+// leaders), or since starting it has applied entries up to (or within the
+// configured lag of) the leader's commit index as of the first append it
+// received
+func (n *Node) CaughtUp() bool {
+	v := n.view()
+	if v.state == Leader {
+		applied, ok := termAt(v.log, n.Applied())
+		return ok && applied == v.term
+	}
+	return v.caughtUp
+}
+
+// checkCaughtUp records the catch-up target from the first append received
+// from a leader, and marks the node caught up once it has applied far enough
+func (n *Node) checkCaughtUp(leaderCommit int64) {
+	if n.caughtUp {
+		return
+	}
+	if !n.hasCatchUpTarget {
+		n.catchUpTarget = leaderCommit
+		n.hasCatchUpTarget = true
+		logger.Info().
+			Int64("target", leaderCommit).
+			Int64("lastApplied", n.Applied()).
+			Msg("Catching up with leader")
+	}
+	if n.Applied() >= n.catchUpTarget-n.config.CatchUpLag {
+		n.caughtUp = true
+		logger.Info().
+			Int64("lastApplied", n.Applied()).
+			Msg("Caught up with leader, serving reads")
+	}
+}
+
 // Non-volatile state functions
 // `Term`, `votedFor`, and `Log` must persist through application restart, so
 // any request that changes these values must be written to disk before
 // responding to the request.
 
+// ErrPersist matches (with errors.Is) every PersistError
+var ErrPersist = errors.New("Failed to persist node state")
+
+// A PersistError reports that a node's term or log could not be written to
+// disk. The node's state in memory is left as it was before the change, so the
+// caller can decide whether to retry, stop serving, or exit
+type PersistError struct {
+	// What was being done: "marshal", "stat", or "write"
+	Op string
+	// File being written
+	File string
+	Err  error
+}
+
+func (e *PersistError) Error() string {
+	return fmt.Sprintf("%s: %s %s: %v", ErrPersist, e.Op, e.File, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PersistError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrPersist
+func (e *PersistError) Is(target error) bool {
+	return target == ErrPersist
+}
+
 // RedirectLeader provides the leader which we want to redirect requests to if
-// we are not the leader at present
+// we are not the leader at present (empty if the current leader is not known)
 func (n *Node) RedirectLeader() string {
-	if n.votedFor == nil {
+	leader := n.view().leader
+	if leader == nil {
 		return ""
 	}
-	return n.votedFor.ClientAddr
+	return leader.ClientAddr
+}
+
+// IsLeader reports whether this node is the leader
+func (n *Node) IsLeader() bool {
+	return n.view().state == Leader
+}
+
+// leaderIn reports whether this node is the leader in term
+func (n *Node) leaderIn(term int64) bool {
+	state, current := n.CurrentRole()
+	return state == Leader && current == term
+}
+
+// CurrentRole returns the node's role and its current term
+func (n *Node) CurrentRole() (Role, int64) {
+	v := n.view()
+	return v.state, v.term
 }
 
 // WriteTerm persists the node's most recent term and vote
@@ -185,98 +868,201 @@ func WriteTerm(filename string, termRecord *raft.TermRecord) error {
 	// 序列化
 	out, err := proto.Marshal(termRecord)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to marshal term record")
-		return err
+		return &PersistError{Op: "marshal", File: filename, Err: err}
 	}
 
 	// 检查文件是否存在
 	_, err = os.Stat(filepath.Dir(filename))
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed stat")
-		return err
+		return &PersistError{Op: "stat", File: filename, Err: err}
 	}
 
 	// 写入文件
 	if err = ioutil.WriteFile(filename, out, 0644); err != nil {
-		log.Fatal().Err(err).Msg("Failed to write term file")
+		return &PersistError{Op: "write", File: filename, Err: err}
 	}
-	return err
+	return nil
 }
 
 // ReadTerm attempts to unmarshal and return a TermRecord from the specified
 // file, and if unable to do so returns an initialized TermRecord
-//
-//
 func ReadTerm(filename string) *raft.TermRecord {
+	record, err := readTermFile(filename)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to unmarshal term file")
+	}
+	return record
+}
 
+// readTermFile reads the term and vote persisted in filename, returning term 0
+// and no vote if there is no such file. If the file can't be read, the error
+// is returned along with an empty record
+func readTermFile(filename string) (*raft.TermRecord, error) {
 	// 空记录
 	record := &raft.TermRecord{
-		Term: 0,
+		Term:     0,
 		VotedFor: nil,
 	}
 
 	// 检查文件是否存在
-	_, err := os.Stat(filename)
+	if _, err := os.Stat(filename); err != nil {
+		return record, nil
+	}
+	// 读取并反序列化
+	termFile, err := ioutil.ReadFile(filename)
 	if err == nil {
-		// 读取并反序列化
-		termFile, _ := ioutil.ReadFile(filename)
-		if err = proto.Unmarshal(termFile, record); err != nil {
-			log.Warn().Err(err).Msg("Failed to unmarshal term file")
-		}
+		err = proto.Unmarshal(termFile, record)
 	}
-
-	return record
+	if err != nil {
+		return &raft.TermRecord{}, err
+	}
+	return record, nil
 }
 
-// SetTerm records term and vote in non-volatile state
+// SetTerm records term and vote in non-volatile state. If they cannot be
+// written, the node's term and vote are left unchanged and the error (a
+// PersistError) is returned
 func (n *Node) SetTerm(newTerm int64, votedFor *raft.Node) error {
-	// 更新内存变量
-	n.Term = newTerm
-	n.votedFor = votedFor
-
-	// 构造 TermRecord
-	vote := &raft.TermRecord{
-		Term:     newTerm,	// 任期
-		VotedFor: votedFor,	// 投票对象
+	// 落盘，再更新内存变量
+	if err := n.setTerm(newTerm, votedFor); err != nil {
+		logger.Error().Err(err).Int64("term", newTerm).Msg("Failed to persist term")
+		return err
 	}
+	return nil
+}
 
-	// 落盘
-	return WriteTerm(n.config.TermFile, vote)
+// writeTerm persists the node's term and vote to its term file
+func (n *Node) writeTerm(termRecord *raft.TermRecord) error {
+	return WriteTerm(n.config.TermFile, termRecord)
 }
 
 // WriteLogs persists the node's log
 func WriteLogs(filename string, logStore *raft.LogStore) error {
-	// 序列化
-	out, err := proto.Marshal(logStore)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to marshal logs")
-	}
-	// 落盘
-	if err = ioutil.WriteFile(filename, out, 0644); err != nil {
-		log.Fatal().Err(err).Msg("Failed to write log file")
-	}
+	_, err := writeLogFile(filename, logStore, LogSyncNone, 0)
 	return err
 }
 
 // ReadLogs attempts to unmarshal and return a LogStore from the specified
 // file, and if unable to do so returns an empty LogStore
 func ReadLogs(filename string) *raft.LogStore {
+	logStore, _ := loadLogs(filename)
+	return logStore
+}
+
+// loadLogs returns the LogStore in the specified file, or an empty LogStore if
+// the file does not exist. If the file cannot be read or unmarshalled, an
+// empty LogStore is returned along with the error
+func loadLogs(filename string) (*raft.LogStore, error) {
 	// 空数据
 	logStore := &raft.LogStore{
 		Entries: make([]*raft.LogRecord, 0, 0),
 	}
 	// 反序列化并返回
-	_, err := os.Stat(filename)
+	if _, err := os.Stat(filename); err != nil {
+		return logStore, nil
+	}
+	logFile, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = decodeLogs(logFile, logStore)
+	}
 	if err != nil {
-	} else {
-		logFile, _ := ioutil.ReadFile(filename)
-		if err = proto.Unmarshal(logFile, logStore); err != nil {
-			log.Error().
-				Err(err).
-				Msg("Failed to unmarshal log file, creating empty log store")
-		}
+		logger.Error().
+			Err(err).
+			Msg("Failed to unmarshal log file, creating empty log store")
+		return &raft.LogStore{Entries: make([]*raft.LogRecord, 0, 0)}, err
 	}
-	return logStore
+	return logStore, nil
+}
+
+// lastIndex returns the absolute index of the last entry in a log, or
+// `BaseIndex - 1` if there are no entries after the compacted prefix
+func lastIndex(logStore *raft.LogStore) int64 {
+	return logStore.BaseIndex + int64(len(logStore.Entries)) - 1
+}
+
+// entryAt returns the entry at an absolute index in a log, or nil if the index
+// has been compacted or is past the end of the log
+func entryAt(logStore *raft.LogStore, index int64) *raft.LogRecord {
+	i := index - logStore.BaseIndex
+	if i < 0 || i >= int64(len(logStore.Entries)) {
+		return nil
+	}
+	return logStore.Entries[i]
+}
+
+// termAt returns the term of the entry at an absolute index in a log. The term
+// of the last compacted entry is kept, and the term before the start of the log
+// is 0. The second return value is false if the term is not known
+func termAt(logStore *raft.LogStore, index int64) (int64, bool) {
+	if index < 0 {
+		return 0, true
+	}
+	if index == logStore.BaseIndex-1 {
+		return logStore.BaseTerm, true
+	}
+	if entry := entryAt(logStore, index); entry != nil {
+		return entry.Term, true
+	}
+	return 0, false
+}
+
+// LastLogIndex returns the index of the last entry in the node's log
+func (n *Node) LastLogIndex() int64 {
+	return lastIndex(n.view().log)
+}
+
+// FirstLogIndex returns the index of the first entry in the node's log that
+// has not been compacted
+func (n *Node) FirstLogIndex() int64 {
+	return n.view().log.BaseIndex
+}
+
+// LogTerm returns the term of the log entry at the specified index, or false
+// if the node does not know the term of that entry
+func (n *Node) LogTerm(index int64) (int64, bool) {
+	return termAt(n.view().log, index)
+}
+
+// CompactLog discards log entries before the specified index, which must
+// already be applied to the database (and so be reflected in a snapshot). The
+// term of the last discarded entry is kept so that appends following it can
+// still be checked. Returns the number of entries discarded
+func (n *Node) CompactLog(index int64) (int64, error) {
+	n.Lock()
+	defer n.Unlock()
+	var dropped int64
+	var err error
+	n.process(func() {
+		dropped, err = n.compactLog(index)
+	})
+	return dropped, err
+}
+
+// compactLog discards log entries before index, on the event loop
+func (n *Node) compactLog(index int64) (int64, error) {
+	if index > n.Applied()+1 {
+		index = n.Applied() + 1
+	}
+	if index <= n.Log.BaseIndex {
+		return 0, nil
+	}
+	term, _ := termAt(n.Log, index-1)
+	dropped := index - n.Log.BaseIndex
+	// copy the remaining entries so the discarded ones can be freed
+	entries := make([]*raft.LogRecord, int64(len(n.Log.Entries))-dropped)
+	copy(entries, n.Log.Entries[dropped:])
+	record := &raft.LogStore{
+		Entries:   entries,
+		BaseIndex: index,
+		BaseTerm:  term}
+	if err := n.replaceLog(record); err != nil {
+		return 0, err
+	}
+	logger.Info().
+		Int64("baseIndex", index).
+		Int64("dropped", dropped).
+		Msg("Compacted log")
+	return dropped, nil
 }
 
 // resetElectionTimer ensures that the node's state is Follower, and sends a
@@ -292,134 +1078,1203 @@ func (n *Node) resetElectionTimer() {
 }
 
 // setLog records new log contents in non-volatile state, and returns the index
-// of the record in the log, or an error
+// of the record in the log, or an error (a PersistError), in which case the
+// node's log is left unchanged
 func (n *Node) setLog(newLogs []*raft.LogRecord) (int64, error) {
-	record := &raft.LogStore{Entries: newLogs}
-	idx := int64(len(record.Entries) - 1)
-	err := WriteLogs(n.config.LogFile, record)
-	if err == nil {
-		n.Log = record
+	record := &raft.LogStore{
+		Entries:   newLogs,
+		BaseIndex: n.Log.BaseIndex,
+		BaseTerm:  n.Log.BaseTerm}
+	idx := lastIndex(record)
+	if err := n.replaceLog(record); err != nil {
+		logger.Error().Err(err).Int64("index", idx).Msg("Failed to persist log")
+		return idx, err
 	}
-	return idx, err
+	return idx, nil
+}
+
+// appendLocal appends records to the leader's log on the event loop, stamped
+// with its current term, and returns the index of the last of them and the
+// term. It returns ErrNotLeaderRecv if the node is no longer the leader, or a
+// PersistError if the log could not be written (which leaves it unchanged)
+func (n *Node) appendLocal(records ...*raft.LogRecord) (int64, int64, error) {
+	var idx, term int64
+	var err error
+	n.process(func() {
+		if n.State != Leader {
+			err = ErrNotLeaderRecv
+			return
+		}
+		term = n.Term
+		for _, record := range records {
+			record.Term = term
+		}
+		idx, err = n.setLog(append(n.Log.Entries, records...))
+	})
+	return idx, term, err
 }
 
 // applyRecord adds a new record to the log, then sends an append-logs request
 // to other nodes in the cluster. This method does not return until either the
-// log is successfully committed to a majority of nodes, or a majority of
-// nodes fail via explicit rejection or timeout (which should generally result
-// in an election)
+// log is successfully committed to a majority of nodes, or the commit deadline
+// passes, or ctx is done, or the node loses its leadership. In the latter
+// cases, the record is left in place--it is shipped with later append requests
+// and may still be committed--and a CommitUncertainError with its index is
+// returned. The write concern carried by ctx (see WithWriteConcern) may have
+// it return sooner, or wait longer
 //
 // applyRecord 在日志中添加一条新记录，然后向集群中的其他节点发送 append-logs 请求。
 // 直到日志成功提交到大多数节点，或者大多数节点通过显式拒绝或超时（通常应该导致选举）失败，此方法才会返回。
-func (n *Node) applyRecord(record *raft.LogRecord) error {
-	// 非 leader 不许执行 Append Log 。
-	if n.State != Leader {
-		return ErrNotLeaderRecv
+func (n *Node) applyRecord(ctx context.Context, record *raft.LogRecord) error {
+	if err := n.checkProposal(ctx, record); err != nil {
+		return err
 	}
+	stampRecord(ctx, record)
 
 	// 保存日志到本地
-	newEntries := append(n.Log.Entries, record)
-
-	//
-	idx, err := n.setLog(newEntries)
+	persistStart := time.Now()
+	idx, currentTerm, err := n.appendLocal(record)
 	if err != nil {
-		log.Error().Err(err).Msg("applyRecord: Error setting log")
+		logger.Error().Err(err).Msg("applyRecord: Error setting log")
 		return err
 	}
+	observePhase("persist", persistStart)
+	logger.Debug().
+		Str("requestId", record.RequestId).
+		Int64("index", idx).
+		Msg("Appended entry")
+
+	concern := writeConcern(ctx)
+	if concern == WriteConcernLeader {
+		// the entry is shipped with the next round of append requests
+		recordWrite(ctx, idx, record.Term)
+		return nil
+	}
 
+	// Try appending logs to other nodes until the entry is committed or the
+	// commit deadline passes
+	commitStart := time.Now()
+	if err := n.awaitCommit(ctx, idx, currentTerm); err != nil {
+		return err
+	}
+	observePhase("commit", commitStart)
+	logger.Debug().
+		Str("requestId", record.RequestId).
+		Int64("index", idx).
+		Msg("Committed entry")
+
+	if concern == WriteConcernApply {
+		if err := n.confirmApplied(ctx, currentTerm); err != nil {
+			return err
+		}
+	}
+	recordWrite(ctx, idx, record.Term)
 
-	// Try appending logs to other nodes, with 3 retries
-	currentTerm := n.Term
-	err = n.SendAppend(3, currentTerm)
-	if err != nil {
-		log.Error().Err(err).Msg("applyRecord: Error shipping log")
+	// return once entry is applied to state machine or error
+	return nil
+}
+
+// checkProposal returns the error a new record would be rejected with, if any:
+// the node is not the leader or is draining, writes are disabled, the record
+// is not allowed by a namespace's policy, or ctx is done
+func (n *Node) checkProposal(ctx context.Context, record *raft.LogRecord) error {
+	// 非 leader 不许执行 Append Log 。
+	if !n.IsLeader() {
+		return ErrNotLeaderRecv
+	}
+	// the NOOP entry a leader commits to serve reads (see commitNoop) is
+	// still allowed while draining
+	if n.Draining() && !(record.Action == raft.LogRecord_NOOP && isSystemWrite(ctx)) {
+		return ErrDraining
+	}
+	if err := n.checkWritable(record); err != nil {
+		return err
+	}
+	if err := n.checkNamespaces(ctx, record); err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		countDeadline(err, deadlineQueue)
+		return err
+	}
+	return nil
+}
 
-	// verify that n.CommitIndex >= idx
-	if n.CommitIndex < idx {
-		log.Error().Err(ErrCommitFailed).
+// stampRecord records the ID of the client request proposing record, and the
+// time it is appended
+func stampRecord(ctx context.Context, record *raft.LogRecord) {
+	record.RequestId = requestID(ctx)
+	record.Timestamp = time.Now().UnixNano()
+}
+
+// confirmApplied sends another round of append requests once an entry is
+// committed: followers apply entries up to the leader's commit index before
+// replying to an append, so one more round with a majority means a majority
+// has applied the entry (see WriteConcernApply)
+func (n *Node) confirmApplied(ctx context.Context, term int64) error {
+	if err := n.SendAppend(ctx, n.config.AppendRetry, term); err != nil {
+		logger.Error().Err(err).Msg("applyRecord: Error confirming apply")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// awaitCommit sends append requests (retried as set by the AppendRetry policy)
+// until the entry at idx is committed. If it is not committed by the commit
+// deadline (see NodeConfig), or before ctx is done or the node stops being the
+// leader of term, a CommitUncertainError is returned--the entry stays in the
+// log, and may still be committed. It wraps ctx.Err() if ctx is done, and
+// ErrCommitTimeout if the commit deadline passed first
+func (n *Node) awaitCommit(ctx context.Context, idx int64, term int64) error {
+	commitCtx := ctx
+	if timeout := n.config.CommitTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		commitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	uncertain := func(err error) error {
+		logger.Warn().
+			Err(err).
 			Int64("recordIndex", idx).
-			Int64("CommitIndex", n.CommitIndex).
-			Msg("Commit index failed to update after append")
-		return ErrCommitFailed
+			Int64("CommitIndex", n.Committed()).
+			Msg("applyRecord: Stopped waiting for commit")
+		return &CommitUncertainError{Index: idx, Term: term, Err: err}
+	}
+	// stopped returns the error once commitCtx is done
+	stopped := func() error {
+		if err := ctx.Err(); err != nil {
+			countDeadline(err, deadlineCommit)
+			return uncertain(err)
+		}
+		return uncertain(ErrCommitTimeout)
 	}
+	for {
+		err := n.SendAppend(commitCtx, n.config.AppendRetry, term)
+		if n.Committed() >= idx {
+			return nil
+		}
+		if err == nil {
+			// a majority has the entry, but it is not committed (this should
+			// not happen)
+			err = ErrCommitFailed
+		}
+		if commitCtx.Err() != nil {
+			return stopped()
+		}
+		if v := n.view(); v.state != Leader || v.term != term || n.config.CommitTimeout <= 0 {
+			return uncertain(err)
+		}
+		logger.Debug().Err(err).Int64("recordIndex", idx).Msg("applyRecord: Retrying commit")
+		select {
+		case <-commitCtx.Done():
+			return stopped()
+		case <-time.After(commitRetryDelay):
+		}
+	}
+}
 
-	// return once entry is applied to state machine or error
-	return err
+// EntryStatus returns the fate of the log entry at index in term, as far as
+// this node knows. A client that got a CommitUncertainError can use it to
+// find out whether its write was committed
+func (n *Node) EntryStatus(index int64, term int64) EntryStatus {
+	entryTerm, known := termAt(n.view().log, index)
+	switch {
+	case !known || index < 0:
+		return EntryUnknown
+	case entryTerm != term && index <= n.Committed():
+		return EntryLost
+	case entryTerm != term:
+		// this node's entry may itself be replaced
+		return EntryUnknown
+	case index <= n.Committed():
+		return EntryCommitted
+	}
+	return EntryPending
+}
+
+// An EntryInfo describes a log entry, without its value
+type EntryInfo struct {
+	Index     int64     `json:"index"`
+	Term      int64     `json:"term"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Key       string    `json:"key,omitempty"`
+	RequestId string    `json:"requestId,omitempty"`
+}
+
+// entryTime returns the time at which the leader appended entry (the zero time
+// if it was not recorded)
+func entryTime(entry *raft.LogRecord) time.Time {
+	if entry.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, entry.Timestamp)
+}
+
+// EntriesBetween describes the entries in the log (that have not been
+// compacted) appended at or after from and before to, oldest first, up to
+// limit of them (0 or less for no limit). Times are taken from the clocks of
+// the leaders that appended the entries, so a change of leader between
+// clocks that disagree can put entries slightly out of order
+func (n *Node) EntriesBetween(from time.Time, to time.Time, limit int) []EntryInfo {
+	logStore := n.view().log
+	entries := []EntryInfo{}
+	for i, entry := range logStore.Entries {
+		t := entryTime(entry)
+		if t.IsZero() || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		entries = append(entries, EntryInfo{
+			Index:     logStore.BaseIndex + int64(i),
+			Term:      entry.Term,
+			Time:      t,
+			Action:    entry.Action.String(),
+			Key:       entry.Key,
+			RequestId: entry.RequestId})
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+	}
+	return entries
+}
+
+// IndexAt returns the index of the last entry in the log appended at or before
+// t, e.g. to restore the database as of that time, or false if there is none
+// (it has been compacted, or no entry was appended by then)
+func (n *Node) IndexAt(t time.Time) (int64, bool) {
+	logStore := n.view().log
+	for i := len(logStore.Entries) - 1; i >= 0; i-- {
+		et := entryTime(logStore.Entries[i])
+		if !et.IsZero() && !et.After(t) {
+			return logStore.BaseIndex + int64(i), true
+		}
+	}
+	return -1, false
+}
+
+// notifyApplied wakes callers of WaitForIndex after entries have been applied
+func (n *Node) notifyApplied() {
+	n.appliedLock.Lock()
+	defer n.appliedLock.Unlock()
+	close(n.appliedNotify)
+	n.appliedNotify = make(chan struct{})
+}
+
+// WaitForIndex waits until this node has applied the log entry at index, and
+// returns true, or returns false with ctx.Err() if ctx is done first. Whether
+// the entry applied at index is the one a client wrote is told by its term
+// (see EntryStatus)
+func (n *Node) WaitForIndex(ctx context.Context, index int64) (bool, error) {
+	for {
+		n.appliedLock.Lock()
+		notify := n.appliedNotify
+		n.appliedLock.Unlock()
+		if n.Applied() >= index {
+			return true, nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
 }
 
 // Client methods for managing raft state
 
+// lockWrite takes the lock held while a write is appended and committed, after
+// any queued writes of a higher priority (see RequestPriority), recording how
+// long the write waited behind earlier ones
+func (n *Node) lockWrite(ctx context.Context) {
+	start := time.Now()
+	priority := RequestPriority(ctx)
+	atomic.AddInt64(&n.queuedWrites, 1)
+	n.writeQueue.enter(priority)
+	n.Lock()
+	n.writeQueue.leave(priority)
+	atomic.AddInt64(&n.queuedWrites, -1)
+	observePhase("queue", start)
+}
+
 // Set appends a write entry to the log record, and returns once the update is
-// applied to the state machine or an error is generated
-func (n *Node) Set(key string, value string) error {
-	log.Info().Str("key", key).Str("value", value).Msg("Set")
+// applied to the state machine or an error is generated. If ctx is done before
+// then, Set returns a CommitUncertainError wrapping ctx.Err() without waiting
+// any longer, but the update may still be applied
+func (n *Node) Set(ctx context.Context, key string, value string) error {
+	proposal := int64(len(key) + len(value))
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return err
+	}
+	defer n.releaseProposal(proposal)
+
+	if size := n.config.ValueChunkSize; size > 0 && len(value) > size {
+		// the chunks are appended before the entry that sets the key, which
+		// does not carry the value, so the namespace's limits are checked
+		// first
+		probe := &raft.LogRecord{Action: raft.LogRecord_SET, Key: key, Value: value}
+		if err := n.checkNamespaces(ctx, probe); err != nil {
+			return err
+		}
+		return n.setChunked(ctx, key, value, size)
+	}
+	logger.Info().Str("key", key).Str("value", value).Msg("Set")
 
 	// 构造日志
 	record := &raft.LogRecord{
-		Term:   n.Term,
 		Action: raft.LogRecord_SET,
 		Key:    key,
 		Value:  value,
 	}
+
+	// 应用日志
+	return n.propose(ctx, record)
+}
+
+// admitProposal reserves size bytes of the budget for pending writes, or
+// returns ErrProposalBudget if the budget would be exceeded. A write is always
+// admitted when no others are pending, so that writes larger than the whole
+// budget can still be made. Writes with PriorityBatch may only use part of the
+// budget, and writes with PrioritySystem are not limited by it
+func (n *Node) admitProposal(ctx context.Context, size int64) error {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	limit := n.config.MaxProposalBytes
+	switch RequestPriority(ctx) {
+	case PrioritySystem:
+		limit = 0
+	case PriorityBatch:
+		limit /= batchProposalShare
+	}
+	if limit > 0 && n.proposalBytes > 0 && n.proposalBytes+size > limit {
+		logger.Warn().
+			Int64("pending", n.proposalBytes).
+			Int64("size", size).
+			Msg("Write rejected by admission control")
+		return ErrProposalBudget
+	}
+	n.proposalBytes += size
+	return nil
+}
+
+// releaseProposal returns bytes reserved by admitProposal to the budget
+func (n *Node) releaseProposal(size int64) {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	n.proposalBytes -= size
+}
+
+// PendingProposalBytes returns the total size of writes waiting to be committed
+func (n *Node) PendingProposalBytes() int64 {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	return n.proposalBytes
+}
+
+// A PeerStatus reports this node's view of another member of the cluster
+type PeerStatus struct {
+	Id        string `json:"id"`
+	Available bool   `json:"available"`
+	// Whether the peer is being bootstrapped, so does not count toward the
+	// majority (see AddMember)
+	Learner bool `json:"learner,omitempty"`
+	// Index of the last log entry known to be replicated on the peer (only
+	// maintained while this node is the leader)
+	MatchIndex int64 `json:"matchIndex"`
+	// Round-trip time of the last successful ping (zero if none has succeeded)
+	RTT time.Duration `json:"rtt"`
+	// Until when this node has isolated the peer (nil if it has not; see
+	// Isolate)
+	IsolatedUntil *time.Time `json:"isolatedUntil,omitempty"`
+	// Estimated offset of the peer's clock from this node's, from its last
+	// reply to an append request (nil if unknown; only the leader sends
+	// append requests, see skew.go)
+	ClockOffset *time.Duration `json:"clockOffset,omitempty"`
+	// Commit and applied indexes and version reported in the peer's last reply
+	// to a vote or append request (nil and empty until it has replied, or if
+	// it runs a version that does not report them)
+	CommitIndex *int64 `json:"commitIndex,omitempty"`
+	LastApplied *int64 `json:"lastApplied,omitempty"`
+	Version     string `json:"version,omitempty"`
+}
+
+// Peers returns the status of each other member of the cluster, sorted by id
+func (n *Node) Peers() []PeerStatus {
+	others := n.peers.snapshot()
+	peers := make([]PeerStatus, 0, len(others))
+	for id, peer := range others {
+		status := PeerStatus{
+			Id:         id,
+			Available:  peer.available(),
+			Learner:    peer.isLearner(),
+			MatchIndex: peer.matchIndex()}
+		peer.lock.Lock()
+		status.RTT = peer.RTT
+		peer.lock.Unlock()
+		if progress := peer.reportedProgress(); progress != nil {
+			status.CommitIndex, status.LastApplied = &progress.commitIndex, &progress.lastApplied
+			status.Version = progress.version
+		}
+		if until := peer.isolation(); !until.IsZero() {
+			status.IsolatedUntil = &until
+		}
+		if offset, ok := peer.clockOffset(); ok {
+			status.ClockOffset = &offset
+		}
+		peers = append(peers, status)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Id < peers[j].Id })
+	return peers
+}
+
+// Drain prepares the node for maintenance: it stops accepting writes (which
+// fail with ErrDraining), waits for any write in progress to finish, and stops
+// standing for election. If transfer is true and this node is the leader,
+// leadership is then handed over to an up-to-date follower
+func (n *Node) Drain(transfer bool) error {
+	logger.Info().Bool("transfer", transfer).Msg("Draining")
+	n.proposalLock.Lock()
+	n.draining = true
+	n.proposalLock.Unlock()
+
+	// writes hold the lock until they are committed, so once it is acquired
+	// there are none in progress
+	n.Lock()
+	n.Unlock()
+
+	if transfer && n.IsLeader() {
+		return n.TransferLeadership("")
+	}
+	return nil
+}
+
+// Resume reverses Drain, so that the node accepts writes and stands for
+// election again
+func (n *Node) Resume() {
+	logger.Info().Msg("Resuming after drain")
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	n.draining = false
+}
+
+// Draining reports whether the node is draining (see Drain)
+func (n *Node) Draining() bool {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	return n.draining
+}
+
+// A DrainStatus reports the progress of draining a node
+type DrainStatus struct {
+	Draining bool `json:"draining"`
+	// Whether the node is still the leader
+	Leader bool `json:"leader"`
+	// Bytes of writes that have not yet finished
+	PendingWriteBytes int64 `json:"pendingWriteBytes"`
+	// Peers that have not yet acknowledged every entry in the log (only known
+	// while the node is the leader)
+	Unreplicated []string `json:"unreplicated"`
+	// Whether the node is draining and has no writes in progress or
+	// unreplicated entries
+	Drained bool `json:"drained"`
+}
+
+// DrainStatus reports the progress of draining the node
+func (n *Node) DrainStatus() DrainStatus {
+	v := n.view()
+	status := DrainStatus{
+		Draining:          n.Draining(),
+		Leader:            v.state == Leader,
+		PendingWriteBytes: n.PendingProposalBytes(),
+		Unreplicated:      []string{},
+	}
+	if status.Leader {
+		last := lastIndex(v.log)
+		for id, peer := range n.peers.snapshot() {
+			if peer.matchIndex() < last {
+				status.Unreplicated = append(status.Unreplicated, id)
+			}
+		}
+		sort.Strings(status.Unreplicated)
+	}
+	status.Drained = status.Draining &&
+		status.PendingWriteBytes == 0 &&
+		len(status.Unreplicated) == 0
+	return status
+}
+
+// TransferLeadership brings followers up to date, then asks target (or, if
+// target is empty, any follower that has every entry in the log) to start an
+// election immediately, which it will win--this node steps down on receiving
+// its vote request. Returns ErrTransferNotLeader if this node is not the
+// leader, ErrPeerRemoved if target is not a voting member, and
+// ErrNoTransferTarget if the follower asked is behind or does not accept
+func (n *Node) TransferLeadership(target string) error {
 	n.Lock()
 	defer n.Unlock()
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return ErrTransferNotLeader
+	}
+	others := n.peers.snapshot()
+	if target != "" {
+		if peer, ok := others[target]; !ok || peer.isLearner() {
+			return ErrPeerRemoved
+		}
+	}
+	if err := n.SendAppend(context.Background(), n.config.AppendRetry, term); err != nil {
+		logger.Warn().Err(err).Msg("Error bringing followers up to date for transfer")
+	}
 
-	// 应用日志
-	return n.applyRecord(record)
+	last := n.LastLogIndex()
+	targets := []string{}
+	for id, peer := range others {
+		if (target == "" || id == target) && peer.matchIndex() == last && !peer.isLearner() && !peer.isolated() {
+			targets = append(targets, id)
+		}
+	}
+	sort.Strings(targets)
+	// the target may win while this node still believes it is the leader
+	n.revokeLease(term)
+	unanswered := false
+	for _, id := range targets {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		reply, err := others[id].client().TimeoutNow(
+			ctx, &raft.TimeoutNowRequest{Term: term, Leader: n.RaftNode})
+		cancel()
+		if err != nil {
+			logger.Warn().Err(err).Str("target", id).Msg("Error requesting transfer")
+			unanswered = true
+			continue
+		}
+		if reply.Accepted {
+			logger.Info().Str("target", id).Msg("Transferring leadership")
+			return nil
+		}
+	}
+	// a target whose reply was lost may still stand
+	if !unanswered {
+		n.restoreLease(term)
+	}
+	return ErrNoTransferTarget
+}
+
+// HandleTimeoutNow starts an election immediately if the request is from the
+// current leader and this node can stand for election
+func (n *Node) HandleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowReply {
+	var reply *raft.TimeoutNowReply
+	n.process(func() {
+		reply = n.handleTimeoutNow(req)
+	})
+	return reply
+}
+
+// handleTimeoutNow responds to a timeout-now request on the event loop
+func (n *Node) handleTimeoutNow(req *raft.TimeoutNowRequest) *raft.TimeoutNowReply {
+	accepted := req.Term == n.Term &&
+		n.State != Leader &&
+		!n.IsWitness() &&
+		!n.Draining() &&
+		!n.Quarantined() &&
+		n.currentLeader != nil &&
+		n.currentLeader.Id == req.Leader.Id
+	logger.Info().
+		Str("leader", req.Leader.Id).
+		Bool("accepted", accepted).
+		Msg("Leadership transfer requested")
+	if accepted {
+		go n.doElection(true)
+	}
+	return &raft.TimeoutNowReply{Term: n.Term, Accepted: accepted}
+}
+
+// PingPeers sends a ping to every other member of the cluster, updating their
+// availability and round-trip times. Unlike append requests (which only the
+// leader sends), pings keep every node's view of its peers current, even when
+// the cluster is idle
+func (n *Node) PingPeers() {
+	var wg sync.WaitGroup
+	for id, peer := range n.peers.snapshot() {
+		wg.Add(1)
+		go func(id string, peer *ForeignNode) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+			start := time.Now()
+			_, err := peer.client().Ping(ctx, &raft.PingRequest{Node: n.RaftNode})
+			if err != nil {
+				logger.Debug().Err(err).Str("peer", id).Msg("Ping failed")
+				n.setAvailable(id, false)
+				return
+			}
+			rtt := time.Since(start)
+			peer.lock.Lock()
+			peer.RTT = rtt
+			peer.lock.Unlock()
+			n.setAvailable(id, true)
+		}(id, peer)
+	}
+	wg.Wait()
+}
+
+// HandlePing replies to a ping from another member of the cluster
+func (n *Node) HandlePing(req *raft.PingRequest) *raft.PingReply {
+	return &raft.PingReply{Term: n.currentTerm(), Node: n.RaftNode}
+}
+
+// setChunked writes a large value as a series of CHUNK entries followed by a
+// SET_CHUNKED entry that makes the chunks visible as the value of key, so that
+// no single log entry (or append request) carries the whole value
+func (n *Node) setChunked(ctx context.Context, key string, value string, size int) error {
+	logger.Info().
+		Str("key", key).
+		Int("size", len(value)).
+		Msg("Set chunked")
+
+	n.lockWrite(ctx)
+	defer n.Unlock()
+
+	// the index of the first chunk's entry identifies this write, so chunks from
+	// an earlier write to the same key are never mistaken for these
+	writeId := strconv.FormatInt(n.LastLogIndex()+1, 10)
+	chunkKeys := []string{}
+	for i := 0; i*size < len(value); i++ {
+		end := (i + 1) * size
+		if end > len(value) {
+			end = len(value)
+		}
+		chunkKey := db.ChunkKey(key, writeId, i)
+		record := &raft.LogRecord{
+			Action: raft.LogRecord_CHUNK,
+			Key:    chunkKey,
+			Data:   []byte(value[i*size : end]),
+		}
+		if err := n.applyRecord(ctx, record); err != nil {
+			return err
+		}
+		chunkKeys = append(chunkKeys, chunkKey)
+	}
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_SET_CHUNKED,
+		Key:     key,
+		Members: chunkKeys,
+	}
+	return n.applyRecord(ctx, record)
+}
+
+// RegisterCommand adds a handler for application-defined log entries with the
+// given command name. Handlers should be registered on every node in the
+// cluster before entries for that command are proposed
+func (n *Node) RegisterCommand(command string, handler CommandHandler) {
+	n.Lock()
+	defer n.Unlock()
+	n.commands[command] = handler
+}
+
+// AddApplyHook registers a hook to be called after each entry is applied.
+// Hooks are called in the order they were added, synchronously with applying
+// entries, so they should not block
+func (n *Node) AddApplyHook(hook ApplyHook) {
+	n.Lock()
+	defer n.Unlock()
+	n.applyHooks = append(n.applyHooks, hook)
+}
+
+// AddEventListener registers a listener to be called for each cluster event
+// (see EventType)
+func (n *Node) AddEventListener(listener EventListener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners = append(n.listeners, listener)
+}
+
+// emit reports an event to all registered listeners
+func (n *Node) emit(eventType EventType, nodeId string) {
+	event := Event{
+		Type: eventType,
+		Term: n.currentTerm(),
+		Node: nodeId,
+		Time: time.Now()}
+	for _, listener := range n.listeners {
+		listener(event)
+	}
+}
+
+// setAvailable records whether the last request to a peer succeeded, and emits
+// an event if this changes the peer's availability
+func (n *Node) setAvailable(host string, available bool) {
+	peer := n.peers.get(host)
+	if peer == nil || !peer.setAvailable(available) {
+		return
+	}
+	n.recordFlap(peer)
+	if available {
+		n.emit(EventPeerAvailable, host)
+	} else {
+		n.emit(EventPeerUnavailable, host)
+	}
+}
+
+// Propose appends an application-defined entry to the log record, and returns
+// once the entry is applied (via the handler registered for the command) or an
+// error is generated
+func (n *Node) Propose(ctx context.Context, command string, data []byte) error {
+	logger.Info().Str("command", command).Msg("Propose")
+	proposal := int64(len(command) + len(data))
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return err
+	}
+	defer n.releaseProposal(proposal)
+
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_CUSTOM,
+		Command: command,
+		Data:    data,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if _, ok := n.commands[command]; !ok {
+		return ErrUnknownCommand
+	}
+	return n.applyRecord(ctx, record)
 }
 
 // Delete appends a delete entry to the log record, and returns once the update
-// is applied to the state machine or an error is generated
-func (n *Node) Delete(key string) error {
-	log.Info().Str("key", key).Msg("Delete")
+// is applied to the state machine or an error is generated. As with Set, the
+// update may still be applied if ctx is done first
+func (n *Node) Delete(ctx context.Context, key string) error {
+	logger.Info().Str("key", key).Msg("Delete")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_DEL,
+		Key:    key,
+		// the value is moved to the trash, if deleted values are kept
+		ExpiresAt: n.trashExpiry(ctx),
+	}
+	return n.propose(ctx, record)
+}
+
+// Batch appends an entry applying ops in order as a single update, and returns
+// whether each operation was applied once the update is applied to the state
+// machine or an error is generated. If atomic, nothing is applied unless every
+// compare-and-swap succeeds, and ErrBatchConflict is returned if one fails.
+// With WriteConcernLeader, Batch returns before the outcome is known, so the
+// results are nil
+func (n *Node) Batch(ctx context.Context, ops []db.BatchOp, atomic bool) ([]bool, error) {
+	if err := db.ValidateBatch(ops); err != nil {
+		return nil, err
+	}
+	logger.Info().Int("ops", len(ops)).Bool("atomic", atomic).Msg("Batch")
+	proposal := int64(0)
+	record := &raft.LogRecord{
+		Action:    raft.LogRecord_BATCH,
+		Atomic:    atomic,
+		Ops:       make([]*raft.LogRecord, 0, len(ops)),
+		ExpiresAt: time.Now().UnixNano(),
+	}
+	for _, op := range ops {
+		proposal += int64(len(op.Key) + len(op.Value))
+		record.Ops = append(record.Ops, batchRecord(op))
+	}
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+	defer n.releaseProposal(proposal)
+
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	idx := n.LastLogIndex() + 1
+	if err := n.applyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+	if n.batchIndex != idx {
+		// not applied yet (see WriteConcernLeader)
+		return nil, nil
+	}
+	if atomic && !n.batchApplied[0] {
+		return n.batchApplied, ErrBatchConflict
+	}
+	return n.batchApplied, nil
+}
+
+// Txn appends a TXN entry, which applies the set and delete operations in then
+// if every condition in compares holds when the entry is applied, or those in
+// otherwise if any does not, and returns whether the conditions held once it
+// is applied. With WriteConcernLeader, Txn returns before the outcome is
+// known, so the result is nil
+func (n *Node) Txn(
+	ctx context.Context,
+	compares []db.Compare,
+	then []db.BatchOp,
+	otherwise []db.BatchOp) (*bool, error) {
+
+	if err := db.ValidateTxn(then, otherwise); err != nil {
+		return nil, err
+	}
+	logger.Info().
+		Int("compares", len(compares)).
+		Int("then", len(then)).
+		Int("else", len(otherwise)).
+		Msg("Txn")
+	proposal := int64(0)
+	record := &raft.LogRecord{
+		Action:    raft.LogRecord_TXN,
+		Compares:  make([]*raft.LogRecord, 0, len(compares)),
+		Ops:       make([]*raft.LogRecord, 0, len(then)),
+		ElseOps:   make([]*raft.LogRecord, 0, len(otherwise)),
+		ExpiresAt: time.Now().UnixNano(),
+	}
+	for _, compare := range compares {
+		proposal += int64(len(compare.Key))
+		record.Compares = append(record.Compares, batchRecord(db.BatchOp{
+			Op:       db.OpCAS,
+			Key:      compare.Key,
+			Expected: compare.Value}))
+	}
+	for _, op := range then {
+		proposal += int64(len(op.Key) + len(op.Value))
+		record.Ops = append(record.Ops, batchRecord(op))
+	}
+	for _, op := range otherwise {
+		proposal += int64(len(op.Key) + len(op.Value))
+		record.ElseOps = append(record.ElseOps, batchRecord(op))
+	}
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+	defer n.releaseProposal(proposal)
+
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	idx := n.LastLogIndex() + 1
+	if err := n.applyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+	if n.batchIndex != idx {
+		// not applied yet (see WriteConcernLeader)
+		return nil, nil
+	}
+	succeeded := n.batchApplied[0]
+	return &succeeded, nil
+}
+
+// txnCompares converts the conditions in a TXN entry back to compares
+func txnCompares(records []*raft.LogRecord) []db.Compare {
+	compares := make([]db.Compare, 0, len(records))
+	for _, op := range batchOps(records) {
+		compares = append(compares, db.Compare{Key: op.Key, Value: op.Expected})
+	}
+	return compares
+}
+
+// batchRecord converts a batch operation to the record for it in a BATCH entry
+func batchRecord(op db.BatchOp) *raft.LogRecord {
+	record := &raft.LogRecord{Key: op.Key, Value: op.Value}
+	switch op.Op {
+	case db.OpSet:
+		record.Action = raft.LogRecord_SET
+	case db.OpDelete:
+		record.Action = raft.LogRecord_DEL
+	case db.OpCAS:
+		record.Action = raft.LogRecord_CAS
+		if op.Expected != nil {
+			record.Expected = *op.Expected
+			record.ExpectExists = true
+		}
+	}
+	return record
+}
+
+// batchOps converts the records in a BATCH entry back to batch operations
+func batchOps(records []*raft.LogRecord) []db.BatchOp {
+	ops := make([]db.BatchOp, 0, len(records))
+	for _, record := range records {
+		op := db.BatchOp{Key: record.Key, Value: record.Value}
+		switch record.Action {
+		case raft.LogRecord_SET:
+			op.Op = db.OpSet
+		case raft.LogRecord_DEL:
+			op.Op = db.OpDelete
+		case raft.LogRecord_CAS:
+			op.Op = db.OpCAS
+			if record.ExpectExists {
+				expected := record.Expected
+				op.Expected = &expected
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// BatchApplied returns whether each operation of the BATCH entry at index was
+// applied (or for a TXN, LOCK, or UNLOCK entry, a single value for whether its
+// conditions held, for a RESTORE entry, whether the key was in the trash and
+// whether it was restored, and for a RENAME or COPY entry, whether the key
+// read had a value and whether it was written), or nil if it is not the most
+// recently applied batch. It does not lock the node, so it may only be called
+// from an apply hook (which runs on the event loop), to find the outcome of the
+// entry the hook was called for
+func (n *Node) BatchApplied(index int64) []bool {
+	if n.batchIndex != index {
+		return nil
+	}
+	return n.batchApplied
+}
+
+// CreateIndex appends an entry declaring a secondary index on a path within
+// JSON values, and returns once the index is built or an error is generated
+func (n *Node) CreateIndex(ctx context.Context, path string) error {
+	if err := db.ValidatePath(path); err != nil {
+		return err
+	}
+	logger.Info().Str("path", path).Msg("CreateIndex")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_CREATE_INDEX,
+		Key:    path,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// DropIndex appends an entry removing the secondary index on a path, and
+// returns once the index is removed or an error is generated
+func (n *Node) DropIndex(ctx context.Context, path string) error {
+	logger.Info().Str("path", path).Msg("DropIndex")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_DROP_INDEX,
+		Key:    path,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// ZAdd appends an entry adding members (or updating their scores) to the sorted
+// set stored at key, and returns once the update is applied to the state
+// machine or an error is generated
+func (n *Node) ZAdd(ctx context.Context, key string, members []db.ScoredMember) error {
+	if err := db.ValidateScores(members); err != nil {
+		return err
+	}
+	logger.Info().Str("key", key).Int("members", len(members)).Msg("ZAdd")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_ZADD,
+		Key:     key,
+		Members: make([]string, 0, len(members)),
+		Scores:  make([]float64, 0, len(members)),
+	}
+	for _, m := range members {
+		record.Members = append(record.Members, m.Member)
+		record.Scores = append(record.Scores, m.Score)
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// ZRem appends an entry removing members from the sorted set stored at key,
+// and returns once the update is applied to the state machine or an error is
+// generated
+func (n *Node) ZRem(ctx context.Context, key string, members []string) error {
+	logger.Info().Str("key", key).Int("members", len(members)).Msg("ZRem")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_ZREM,
+		Key:     key,
+		Members: members,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// SAdd appends an entry adding members to the set stored at key, and returns
+// once the update is applied to the state machine or an error is generated
+func (n *Node) SAdd(ctx context.Context, key string, members []string) error {
+	logger.Info().Str("key", key).Int("members", len(members)).Msg("SAdd")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_SADD,
+		Key:     key,
+		Members: members,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// SRem appends an entry removing members from the set stored at key, and
+// returns once the update is applied to the state machine or an error is
+// generated
+func (n *Node) SRem(ctx context.Context, key string, members []string) error {
+	logger.Info().Str("key", key).Int("members", len(members)).Msg("SRem")
+	record := &raft.LogRecord{
+		Action:  raft.LogRecord_SREM,
+		Key:     key,
+		Members: members,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// Touch appends an entry setting key to expire after ttl, and returns whether
+// the key exists (no entry is appended for a key that does not exist) once the
+// update is applied to the state machine or an error is generated
+func (n *Node) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	logger.Info().Str("key", key).Dur("ttl", ttl).Msg("Touch")
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return false, ErrNotLeaderRecv
+	}
+	if !n.Store.Exists(key) {
+		return false, nil
+	}
+	record := &raft.LogRecord{
+		Action:    raft.LogRecord_TOUCH,
+		Key:       key,
+		ExpiresAt: time.Now().Add(ttl).UnixNano(),
+	}
+	return true, n.applyRecord(ctx, record)
+}
+
+// Persist appends an entry removing the expiry time of key, and returns
+// whether the key exists (no entry is appended for a key that does not exist)
+// once the update is applied to the state machine or an error is generated
+func (n *Node) Persist(ctx context.Context, key string) (bool, error) {
+	logger.Info().Str("key", key).Msg("Persist")
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return false, ErrNotLeaderRecv
+	}
+	if !n.Store.Exists(key) {
+		return false, nil
+	}
 	record := &raft.LogRecord{
-		Term:   n.Term,
-		Action: raft.LogRecord_DEL,
+		Action: raft.LogRecord_PERSIST,
 		Key:    key,
 	}
-	n.Lock()
-	defer n.Unlock()
-	return n.applyRecord(record)
+	return true, n.applyRecord(ctx, record)
 }
 
-// requestVote sends a request for vote to a single other node (see DoElection)
-func (n *Node) requestVote(host string) (*raft.VoteReply, error) {
-	// 超时控制
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*4)
-	defer cancel()
+// ExpireKeys appends an entry deleting all keys whose expiry time has passed.
+// Each replica only deletes a key if its expiry time is still in the past as
+// of the time recorded in the entry, so a key touched in the meantime is kept
+func (n *Node) ExpireKeys(ctx context.Context) error {
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return ErrNotLeaderRecv
+	}
+	now := time.Now().UnixNano()
+	keys := n.Store.ExpiredKeys(now)
+	if len(keys) == 0 {
+		return nil
+	}
+	logger.Info().Int("keys", len(keys)).Msg("ExpireKeys")
+	record := &raft.LogRecord{
+		Action:    raft.LogRecord_EXPIRE,
+		Members:   keys,
+		ExpiresAt: now,
+	}
+	return n.applyRecord(ctx, record)
+}
 
+// voteRequest builds a request for votes in the node's current term
+func (n *Node) voteRequest() *raft.VoteRequest {
 	//
-	lastLogIndex := int64(len(n.Log.Entries)) - 1
+	lastLogIndex := lastIndex(n.Log)
 
 	//
-	var lastLogTerm int64
-	if lastLogIndex >= 0 {
-		lastLogTerm = n.Log.Entries[lastLogIndex].Term
-	} else {
-		lastLogTerm = 0
-	}
+	lastLogTerm, _ := termAt(n.Log, lastLogIndex)
 
 	// 构造投票请求
-	voteRequest := &raft.VoteRequest{
+	return &raft.VoteRequest{
 		Term:         n.Term,
 		Candidate:    n.RaftNode,
 		LastLogIndex: lastLogIndex,
 		LastLogTerm:  lastLogTerm,
 	}
+}
+
+// requestVote sends a request for vote to a single other node (see DoElection)
+func (n *Node) requestVote(host string) (*raft.VoteReply, error) {
+	return n.sendVoteRequest(host, n.voteRequest())
+}
+
+// sendVoteRequest sends a request for vote built by voteRequest to a single
+// other node
+func (n *Node) sendVoteRequest(host string, voteRequest *raft.VoteRequest) (*raft.VoteReply, error) {
+	peer := n.peers.get(host)
+	if peer == nil {
+		return nil, ErrPeerRemoved
+	}
+
+	// 超时控制
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*4)
+	defer cancel()
 
-	vote, err := n.otherNodes[host].Client.RequestVote(ctx, voteRequest)
+	reply, err := n.hedge(ctx, hedgeVote, func(ctx context.Context) (interface{}, error) {
+		return peer.client().RequestVote(ctx, voteRequest)
+	})
+	vote, _ := reply.(*raft.VoteReply)
 	if err != nil {
-		log.Warn().Err(err).Msgf("Error requesting vote from %s", host)
-		n.otherNodes[host].Available = false
+		logger.Warn().Err(err).Msgf("Error requesting vote from %s", host)
+		n.setAvailable(host, false)
 	} else {
-		n.otherNodes[host].Available = true
+		n.setAvailable(host, true)
+		n.observeProgress(host, peer, vote.CommitIndex, vote.LastApplied, vote.Version)
 	}
 
 	return vote, err
 }
 
+// An election is the state of one election held by this node, from the step
+// that starts it to the step that decides it (see DoElection)
+type election struct {
+	request *raft.VoteRequest
+	voters  []string
+	// the membership whose majority must grant votes (both, if joint)
+	config configuration
+	// 同意节点 (this node included)
+	granted map[string]bool
+	// 看到的最大 term 及对应的 node
+	maxTermSeen       int64
+	maxTermSeenSource *raft.Node
+	// voters that denied their votes because this node is not a member of
+	// their cluster
+	unknown map[string]bool
+}
+
+// count records a voter's reply
+func (e *election) count(voter string, vote *raft.VoteReply) {
+	logger.Trace().Msg("got a vote")
+
+	// 同意
+	if vote.VoteGranted {
+		logger.Trace().Msg("it's a 'yay'")
+		e.granted[voter] = true
+		return
+	}
+	// 拒绝
+	logger.Info().
+		Str("voter", voter).
+		Int64("term", vote.Term).
+		Stringer("reason", vote.DenyReason).
+		Msg("Vote denied")
+	if vote.DenyReason == raft.VoteReply_UNKNOWN_NODE {
+		e.unknown[voter] = true
+	}
+	// 如果该节点返回了更大的 term ，就记录该 term 。
+	if vote.Term > e.maxTermSeen {
+		e.maxTermSeen = vote.Term
+		e.maxTermSeenSource = vote.Node
+	}
+}
+
 // DoElection sends out requests for votes to each other node in the Raft
 // cluster. When a Raft node's role is "candidate", it should send start an
 // election. If it is granted votes from a majority of nodes, its role changes
@@ -428,183 +2283,489 @@ func (n *Node) requestVote(host string) (*raft.VoteReply, error) {
 // "follower". If it does not receive a majority of votes and also does not
 // receive an append-logs from a valid leader, it increments the term and
 // starts another election (repeat until a leader is elected).
+//
+// The election starts and is decided in steps on the event loop (see
+// loop.go). The votes are collected in between, by the calling goroutine
 func (n *Node) DoElection() bool {
-	log.Trace().Msg("Starting Election")
-	n.SetTerm(n.Term+1, n.RaftNode)
-
-	// 总节点数
-	numNodes := len(n.otherNodes) + 1
-	// 满足半数
-	majority := (numNodes / 2) + 1
-
-
-	var success bool
-
-	log.Info().Int64("Term", n.Term).
-		Int("clusterSize", numNodes).
-		Int("needed", majority).
-		Msg("Becoming candidate")
-
-	// 同意节点数
-	numVotes := 1
-	// 看到的最大 term
-	maxTermSeen := n.Term
-	// 看到的最大 term 对应的 nodes
-	maxTermSeenSource := n.votedFor
+	return n.doElection(false)
+}
 
-	var wg sync.WaitGroup
-	wg.Add(len(n.otherNodes))
+// doElection holds an election as for DoElection. If transfer is true, this
+// node was asked to stand by the leader (see TransferLeadership), so voters
+// vote even within the leader lease
+func (n *Node) doElection(transfer bool) bool {
+	var e *election
+	n.process(func() {
+		e = n.startElection()
+		if e != nil {
+			e.request.Transfer = transfer
+		}
+	})
+	if e == nil {
+		return false
+	}
 
-	//
-	for k := range n.otherNodes {
-		// if needed for performance, figure out how to collect the term responses in a thread-safe way
+	type vote struct {
+		voter string
+		reply *raft.VoteReply
+	}
+	votes := make(chan vote, len(e.voters))
+	for _, k := range e.voters {
 		go func(k string) {
-			defer wg.Done()
-
 			// 请求投票
-			vote, err := n.requestVote(k)
+			reply, err := n.sendVoteRequest(k, e.request)
 			if err != nil {
-				return
+				reply = nil
 			}
+			votes <- vote{voter: k, reply: reply}
+		}(k)
+	}
+	for range e.voters {
+		if v := <-votes; v.reply != nil {
+			e.count(v.voter, v.reply)
+		}
+	}
 
-			log.Trace().Msg("got a vote")
+	var success bool
+	n.process(func() {
+		success = n.finishElection(e)
+	})
+	return success
+}
 
-			// 同意
-			if vote.VoteGranted {
-				log.Trace().Msg("it's a 'yay'")
-				numVotes++
-			// 拒绝
-			} else {
-				// 如果该节点返回了更大的 term ，就记录该 term 。
-				if vote.Term > maxTermSeen {
-					maxTermSeen = vote.Term
-					maxTermSeenSource = vote.Node
-				}
-			}
-		}(k)
+// startElection moves to the next term, voting for this node, and returns
+// the election to hold in it, or nil if this node does not stand for election
+func (n *Node) startElection() *election {
+	if n.IsWitness() {
+		logger.Trace().Msg("Witness does not stand for election")
+		return nil
+	}
+	if n.Draining() {
+		logger.Trace().Msg("Draining node does not stand for election")
+		return nil
+	}
+	if n.Quarantined() {
+		logger.Trace().Msg("Quarantined node does not stand for election")
+		return nil
+	}
+	if until := n.electionHoldoff(); !until.IsZero() {
+		logger.Trace().Time("until", until).Msg("Holding off elections")
+		return nil
+	}
+	logger.Trace().Msg("Starting Election")
+	if err := n.SetTerm(n.Term+1, n.RaftNode); err != nil {
+		// without a persisted vote for itself, this node could vote twice in
+		// the new term after a restart
+		return nil
+	}
+	n.currentLeader = nil
+
+	// members being bootstrapped don't vote
+	others := n.peers.snapshot()
+	e := &election{
+		request: n.voteRequest(),
+		// 满足半数 (of both memberships, during a joint change)
+		config:            n.configuration(),
+		granted:           map[string]bool{n.RaftNode.Id: true},
+		maxTermSeen:       n.Term,
+		maxTermSeenSource: n.votedFor,
+		unknown:           map[string]bool{}}
+	for k, peer := range others {
+		if !peer.isLearner() && !peer.isolated() {
+			e.voters = append(e.voters, k)
+		}
 	}
 
-	wg.Wait()
+	logger.Info().Int64("Term", n.Term).
+		Int("clusterSize", voters(others)+1).
+		Bool("joint", e.config.joint()).
+		Msg("Becoming candidate")
+	return e
+}
 
-	voteLog := log.Info().Int("needed", majority).Int("got", numVotes)
+// finishElection decides an election from the votes collected, and returns
+// whether this node won it. An election is lost if this node has moved on to
+// a later term in the meantime (such as on an append from another leader)
+func (n *Node) finishElection(e *election) bool {
+	voteLog := logger.Info().Bool("joint", e.config.joint()).Int("got", len(e.granted))
+
+	if n.Term != e.request.Term {
+		voteLog.Bool("success", false).
+			Int64("term", e.request.Term).
+			Int64("currentTerm", n.Term).
+			Msg("Election overtaken by a later term")
+		return false
+	}
 
 	// 若不满足多数同意
-	if numVotes < majority {
-		voteLog.Bool("success", false).Int64("term", n.Term).Msg("Election failed")
-		success = false
+	if !e.config.quorum(e.granted) {
+		n.backoff.fail()
+		voteLog.Bool("success", false).
+			Int64("term", n.Term).
+			Dur("backoffWindow", n.backoff.window()).
+			Msg("Election failed")
 		// 如果看到更大的 term ，就更新 Term 到磁盘
-		if maxTermSeen > n.Term {
-			log.Info().Int64("max response term", maxTermSeen).
-				Str("other node", maxTermSeenSource.Id).
+		if e.maxTermSeen > n.Term {
+			logger.Info().Int64("max response term", e.maxTermSeen).
+				Str("other node", e.maxTermSeenSource.Id).
 				Msg("Updating term to max seen")
-			n.SetTerm(maxTermSeen, maxTermSeenSource)
+			// the node that reported the term was not voted for--record that
+			// this node has not voted in the new term (if that fails, the
+			// node learns of the term again from the next request in it)
+			n.SetTerm(e.maxTermSeen, nil)
 		}
-	// 若满足多数同意
-	} else {
-		voteLog.Bool("success", true).Int64("term", n.Term).Msg("Election succeeded")
-		// 当前节点仍为 Leader
-		n.State = Leader
-		// 成功
-		success = true
+		// if the votes of members that don't recognize this node would have won
+		// the election, retrying will fail the same way until the cluster's
+		// configuration changes, and only disrupts the other members' terms
+		if len(e.unknown) > 0 && e.config.quorum(union(e.granted, e.unknown)) {
+			n.holdOffElections(n.config.UnknownNodeBackoff)
+		}
+		return false
+	}
 
-		// StateManager grace window job sets this back to true
-		//
-		n.AllowVote = false
+	// 若满足多数同意
+	voteLog.Bool("success", true).Int64("term", n.Term).Msg("Election succeeded")
+	n.backoff.reset()
+	// 当前节点仍为 Leader
+	n.State = Leader
+	n.currentLeader = n.RaftNode
+
+	n.emit(EventLeaderChange, n.RaftNode.Id)
+
+	// StateManager grace window job sets this back to true (see
+	// EndGracePeriod)
+	n.AllowVote = false
+
+	// 更新每个节点的待同步日志序号
+	for _, peer := range n.peers.snapshot() {
+		peer.lock.Lock()
+		peer.MatchIndex, peer.NextIndex = -1, lastIndex(n.Log)+1
+		peer.lock.Unlock()
+	}
+	// complete a joint membership change left in progress by the last leader
+	if n.inJoint() {
+		go n.leaveJoint(n.Term)
+	}
+	// entries left by earlier leaders are only committed along with one of
+	// this term (see commitRecords), so commit one without waiting for a write
+	go n.commitNoop(context.Background(), n.Term)
+	return true
+}
 
-		// 更新每个节点的待同步日志序号
-		for k := range n.otherNodes {
-			n.otherNodes[k].MatchIndex = -1
-			n.otherNodes[k].NextIndex = int64(len(n.Log.Entries))
-		}
+// union returns the members in either a or b
+func union(a, b map[string]bool) map[string]bool {
+	both := make(map[string]bool, len(a)+len(b))
+	for addr := range a {
+		both[addr] = true
+	}
+	for addr := range b {
+		both[addr] = true
 	}
+	return both
+}
 
+// holdOffElections prevents this node from standing for election for d
+func (n *Node) holdOffElections(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	n.electionsAfter = time.Now().Add(d)
+	logger.Warn().
+		Dur("backoff", d).
+		Msg("Rejected as an unknown node by the cluster, holding off elections")
+}
 
-	return success
+// electionHoldoff returns the time until which this node does not stand for
+// election, or the zero time if it may
+func (n *Node) electionHoldoff() time.Time {
+	if time.Now().Before(n.electionsAfter) {
+		return n.electionsAfter
+	}
+	return time.Time{}
 }
 
 // commitRecords iterates backward from last index of log entries, and finds
 // latest index that has been appended to a majority of nodes, and updates
-// the database and node CommitIndex
+// the database and node CommitIndex. It runs on the event loop (see loop.go)
 func (n *Node) commitRecords() {
-	log.Trace().Msg("commitRecords")
+	logger.Trace().Msg("commitRecords")
 
-	// 节点总数
-	numNodes := len(n.otherNodes)
-	// 半数节点
-	majority := (numNodes / 2) + 1
-	log.Trace().Msgf("Need to apply message to %d nodes", majority)
+	// members being bootstrapped don't count
+	others := n.peers.snapshot()
+	// 半数节点 (of both memberships, during a joint change)
+	config := n.configuration()
 
 	//
-	lastIdx := int64(len(n.Log.Entries) - 1)
-	log.Trace().
+	lastIdx := lastIndex(n.Log)
+	logger.Trace().
 		Int64("lastIndex", lastIdx).
-		Int64("CommitIndex", n.CommitIndex).
+		Int64("CommitIndex", n.Committed()).
 		Msgf("Checking for update to commit index")
 
 	//
-	for lastIdx > n.CommitIndex {
-		count := 1
-		for k := range n.otherNodes {
-			if n.otherNodes[k].MatchIndex >= lastIdx {
-				count++
+	for lastIdx > n.Committed() {
+		// only an entry from the leader's own term is committed by counting
+		// replicas, which commits the entries before it too: one from an
+		// earlier term can be on a majority and still be overwritten by
+		// another leader (Raft §5.4.2), and terms only fall going back
+		if term, ok := termAt(n.Log, lastIdx); !ok || term != n.Term {
+			break
+		}
+		acked := map[string]bool{n.RaftNode.Id: true}
+		for addr, peer := range others {
+			if peer.matchIndex() >= lastIdx && !peer.isLearner() {
+				acked[addr] = true
 			}
 		}
-		log.Trace().Msgf("Applied to %d nodes", count)
-		if count >= majority {
-			log.Info().
-				Int64("prevCommitIndex", n.CommitIndex).
+		logger.Trace().Msgf("Applied to %d nodes", len(acked))
+		if config.quorum(acked) {
+			logger.Info().
+				Int64("prevCommitIndex", n.Committed()).
 				Int64("newCommitIndex", lastIdx).
 				Msgf("Updated commit index")
-			n.CommitIndex = lastIdx
+			n.commitThrough(lastIdx)
 			break
 		}
 		lastIdx--
 	}
 	// if any records were committed, apply them to the database
-	log.Trace().
-		Int64("lastApplied", n.lastApplied).
+	logger.Trace().
+		Int64("lastApplied", n.Applied()).
 		Msg("Applying records to database")
-	for n.lastApplied < n.CommitIndex {
-		n.lastApplied++
-		action := n.Log.Entries[n.lastApplied].Action
-		key := n.Log.Entries[n.lastApplied].Key
-		if action == raft.LogRecord_SET {
-			value := n.Log.Entries[n.lastApplied].Value
-			log.Trace().
-				Str("key", key).
-				Str("value", value).
-				Msg("Db set")
-			n.Store.Set(key, value)
-		} else if action == raft.LogRecord_DEL {
-			log.Trace().
-				Str("key", key).
-				Msg("Db del")
-			n.Store.Delete(key)
+	n.applyThrough(n.Committed())
+	n.notifyApplied()
+}
+
+// applyEntry updates the database (or calls the registered command handler)
+// for one committed log entry, then calls each apply hook in order
+func (n *Node) applyEntry(index int64, entry *raft.LogRecord) {
+	// witnesses vote, so follow membership changes, but their logs carry no
+	// other data to apply
+	if entry.Action == raft.LogRecord_MEMBERS {
+		n.applyMembers(entry)
+	}
+	if n.IsWitness() {
+		return
+	}
+	defer observePhase("apply", time.Now())
+	logger.Debug().
+		Str("requestId", entry.RequestId).
+		Int64("index", index).
+		Str("action", entry.Action.String()).
+		Msg("Applying entry")
+	var result error
+	switch entry.Action {
+	case raft.LogRecord_SET, raft.LogRecord_DEL, raft.LogRecord_TOUCH, raft.LogRecord_PERSIST:
+		applyMutation(n.Store, entry)
+	case raft.LogRecord_CREATE_INDEX:
+		logger.Trace().
+			Str("path", entry.Key).
+			Msg("Db create index")
+		result = n.Store.CreateIndex(entry.Key)
+	case raft.LogRecord_DROP_INDEX:
+		logger.Trace().
+			Str("path", entry.Key).
+			Msg("Db drop index")
+		n.Store.DropIndex(entry.Key)
+	case raft.LogRecord_ZADD:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int("members", len(entry.Members)).
+			Msg("Db zadd")
+		members := make([]db.ScoredMember, 0, len(entry.Members))
+		for i, member := range entry.Members {
+			if i < len(entry.Scores) {
+				members = append(members, db.ScoredMember{
+					Member: member,
+					Score:  entry.Scores[i]})
+			}
+		}
+		n.Store.ZAdd(entry.Key, members)
+	case raft.LogRecord_ZREM:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int("members", len(entry.Members)).
+			Msg("Db zrem")
+		n.Store.ZRem(entry.Key, entry.Members)
+	case raft.LogRecord_SADD:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int("members", len(entry.Members)).
+			Msg("Db sadd")
+		n.Store.SAdd(entry.Key, entry.Members)
+	case raft.LogRecord_SREM:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int("members", len(entry.Members)).
+			Msg("Db srem")
+		n.Store.SRem(entry.Key, entry.Members)
+	case raft.LogRecord_EXPIRE:
+		logger.Trace().
+			Int("keys", len(entry.Members)).
+			Msg("Db expire")
+		for _, key := range entry.Members {
+			n.Store.Expire(key, entry.ExpiresAt)
+		}
+	case raft.LogRecord_READ_ONLY:
+		logger.Info().
+			Str("reason", entry.Value).
+			Msg("Cluster read-only mode changed")
+		n.Store.SetReadOnly(entry.Value)
+	case raft.LogRecord_MEMBERS:
+		logger.Info().
+			Strs("members", entry.Members).
+			Strs("oldMembers", entry.OldMembers).
+			Msg("Cluster membership changed")
+		value, _ := json.Marshal(entry.Members)
+		n.Store.Set(MembersKey, string(value))
+		if len(entry.OldMembers) > 0 {
+			old, _ := json.Marshal(entry.OldMembers)
+			n.Store.Set(OldMembersKey, string(old))
+		} else {
+			n.Store.Delete(OldMembersKey)
+		}
+	case raft.LogRecord_CHUNK:
+		logger.Trace().
+			Int("size", len(entry.Data)).
+			Msg("Db put chunk")
+		n.Store.PutChunk(entry.Key, entry.Data)
+	case raft.LogRecord_SET_CHUNKED:
+		logger.Trace().
+			Str("key", entry.Key).
+			Int("chunks", len(entry.Members)).
+			Msg("Db set chunked")
+		n.Store.SetChunked(entry.Key, entry.Members)
+		if entry.ExpiresAt > 0 {
+			n.Store.Touch(entry.Key, entry.ExpiresAt)
 		}
+	case raft.LogRecord_BATCH:
+		logger.Trace().
+			Int("ops", len(entry.Ops)).
+			Bool("atomic", entry.Atomic).
+			Msg("Db batch")
+		applied := n.Store.ApplyBatch(batchOps(entry.Ops), entry.Atomic, entry.ExpiresAt)
+		if entry.Atomic && len(applied) > 0 && !applied[0] {
+			result = ErrBatchConflict
+		}
+		n.batchIndex, n.batchApplied = index, applied
+	case raft.LogRecord_TXN:
+		logger.Trace().
+			Int("compares", len(entry.Compares)).
+			Int("then", len(entry.Ops)).
+			Int("else", len(entry.ElseOps)).
+			Msg("Db txn")
+		succeeded := n.Store.ApplyTxn(
+			txnCompares(entry.Compares),
+			batchOps(entry.Ops),
+			batchOps(entry.ElseOps),
+			entry.ExpiresAt)
+		n.batchIndex, n.batchApplied = index, []bool{succeeded}
+	case raft.LogRecord_LOCK:
+		logger.Trace().
+			Str("key", entry.Key).
+			Str("lock", entry.Value).
+			Msg("Db lock")
+		var acquired bool
+		acquired, result = applyLock(n.Store, entry)
+		n.batchIndex, n.batchApplied = index, []bool{acquired}
+	case raft.LogRecord_UNLOCK:
+		logger.Trace().
+			Str("key", entry.Key).
+			Str("owner", entry.Value).
+			Msg("Db unlock")
+		released := n.Store.Unlock(entry.Key, entry.Value, entry.ExpiresAt)
+		n.batchIndex, n.batchApplied = index, []bool{released}
+	case raft.LogRecord_RESTORE:
+		logger.Trace().
+			Str("key", entry.Key).
+			Msg("Db restore")
+		found, restored := n.Store.Restore(TrashKey(entry.Key), entry.Key, entry.Timestamp)
+		if restored && entry.ExpiresAt > 0 {
+			// the default time to live of the key's namespace
+			n.Store.Touch(entry.Key, entry.ExpiresAt)
+		}
+		n.batchIndex, n.batchApplied = index, []bool{found, restored}
+	case raft.LogRecord_RENAME, raft.LogRecord_COPY:
+		logger.Trace().
+			Str("from", entry.Key).
+			Str("to", entry.Value).
+			Bool("replace", entry.Replace).
+			Msg("Db " + strings.ToLower(entry.Action.String()))
+		var found, moved bool
+		if entry.Action == raft.LogRecord_RENAME {
+			found, moved = n.Store.Rename(entry.Key, entry.Value, entry.Replace, entry.Timestamp)
+		} else {
+			found, moved = n.Store.Copy(entry.Key, entry.Value, entry.Replace, entry.Timestamp)
+		}
+		n.batchIndex, n.batchApplied = index, []bool{found, moved}
+	case raft.LogRecord_CUSTOM:
+		logger.Trace().
+			Str("command", entry.Command).
+			Msg("Custom command")
+		handler, ok := n.commands[entry.Command]
+		if !ok {
+			result = ErrUnknownCommand
+		} else {
+			result = handler(entry.Data)
+		}
+		if result != nil {
+			logger.Error().
+				Err(result).
+				Str("command", entry.Command).
+				Msg("Custom command failed")
+		}
+	}
+	n.recordAppliedHash(index, entry)
+
+	for _, hook := range n.applyHooks {
+		hook(index, entry, result)
 	}
 }
 
 // requestAppend sends append to one other node with new record(s) and updates
-// match index for that node if successful
-func (n *Node) requestAppend(host string, term int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*12)
-	defer cancel()
+// match index for that node if successful. The request is cancelled if ctx is
+// done before it completes
+func (n *Node) requestAppend(ctx context.Context, host string, term int64) error {
+	req, err := n.appendRequest(host, term)
+	if err != nil {
+		return err
+	}
+	return n.sendAppendRequest(ctx, host, term, req)
+}
 
-	prevLogIndex := n.otherNodes[host].MatchIndex
+// appendRequest builds an append request carrying every entry the other node
+// has not acknowledged
+func (n *Node) appendRequest(host string, term int64) (*raft.AppendRequest, error) {
+	peer := n.peers.get(host)
+	if peer == nil {
+		return nil, ErrPeerRemoved
+	}
+	if peer.sendingSnapshot() {
+		return nil, ErrBootstrapping
+	}
+	if peer.isolated() {
+		return nil, ErrPeerIsolated
+	}
+	prevLogIndex := peer.matchIndex()
+	v := n.view()
 	// make a slice of all entries the other node has not seen (right after
 	// election, this will be all records--would it be better to query for
 	// number of entries in other node's log and start there? or is it better
 	// to deal with this via reasonable log-compaction limits? (need to figure
 	// out the relationship between log size and message size and make a
 	// reasonable speculation about desired max message size)
-	idx := int64(len(n.Log.Entries))
-	newEntries := n.Log.Entries[prevLogIndex+1 : idx]
-	var prevLogTerm int64
-	if prevLogIndex >= 0 {
-		prevLogTerm = n.Log.Entries[prevLogIndex].Term
-	} else {
-		prevLogTerm = 0
+	if prevLogIndex+1 < v.log.BaseIndex {
+		// the entries this node needs have been compacted into a snapshot
+		logger.Warn().
+			Str("host", host).
+			Int64("matchIndex", prevLogIndex).
+			Int64("baseIndex", v.log.BaseIndex).
+			Msg("Entries needed by follower have been compacted, sending a snapshot")
+		n.installOnFollower(host, peer, term)
+		return nil, ErrEntriesCompacted
 	}
+	newEntries := n.throttleCatchUp(host, peer.catchUp, prevLogIndex, v.log.Entries[prevLogIndex+1-v.log.BaseIndex:])
+	prevLogTerm, _ := termAt(v.log, prevLogIndex)
 
 	req := &raft.AppendRequest{
 		Term:         term,
@@ -612,103 +2773,323 @@ func (n *Node) requestAppend(host string, term int64) error {
 		PrevLogIndex: prevLogIndex,
 		PrevLogTerm:  prevLogTerm,
 		Entries:      newEntries,
-		LeaderCommit: n.CommitIndex}
+		LeaderCommit: n.Committed()}
+	req.HashIndex, req.AppliedHash = n.AppliedHash()
+	if n.LeaseReadsAllowed() {
+		req.LeaseAge = n.leaseAge(term)
+	}
 
-	if n.State != Leader {
+	if v.state != Leader {
 		// escape hatch in case this node stepped down in between the call to
 		// `SendAppend` and this point
-		log.Trace().Msg("requestAppend not leader, returning")
-		return ErrNotLeaderSend
+		logger.Trace().Msg("requestAppend not leader, returning")
+		return nil, ErrNotLeaderSend
 	}
-	if term != n.Term {
-		log.Trace().
+	if term != v.term {
+		logger.Trace().
 			Int64("req term", term).
-			Int64("node term", n.Term).
-			Str("state", string(n.State)).
+			Int64("node term", v.term).
+			Str("state", string(v.state)).
 			Msg("past escape hatch")
-		return ErrExpiredTerm
+		return nil, ErrExpiredTerm
+	}
+	return req, nil
+}
+
+// throttleCatchUp trims the entries to send to a peer (starting after
+// prevLogIndex) to what the peer's catch-up limit allows. Only entries that are
+// already committed count toward the limit, so a peer that is keeping up with
+// new writes is never slowed down. The first entry is always allowed once a
+// full second's worth of budget has built up, so that entries larger than the
+// limit are still sent. If nothing is allowed, the request carries no entries
+// and serves as a heartbeat
+func (n *Node) throttleCatchUp(
+	host string,
+	limiter *ratelimit.Limiter,
+	prevLogIndex int64,
+	entries []*raft.LogRecord) []*raft.LogRecord {
+
+	if limiter == nil {
+		return entries
+	}
+	available, full := limiter.Available(), limiter.Full()
+	var size int64
+	for i, entry := range entries {
+		if prevLogIndex+1+int64(i) > n.Committed() {
+			break
+		}
+		entrySize := int64(proto.Size(entry))
+		if size+entrySize > available && !(i == 0 && full) {
+			catchUpThrottled.Inc(host)
+			entries = entries[:i]
+			break
+		}
+		size += entrySize
+	}
+	limiter.Take(size)
+	catchUpBytes.Add(host, float64(size))
+	return entries
+}
+
+// SnapshotLimiter returns the limit on the rate at which snapshots are served
+// for download (nil for no limit)
+func (n *Node) SnapshotLimiter() *ratelimit.Limiter {
+	return n.snapshotLimiter
+}
+
+// SnapshotWriteLimiter returns the limit on the rate at which snapshots are
+// written to the data directory (nil for no limit)
+func (n *Node) SnapshotWriteLimiter() *ratelimit.Limiter {
+	return n.writeLimiter
+}
+
+// sendAppendRequest sends an append request built by `appendRequest` to one
+// other node, and updates match index for that node if successful
+func (n *Node) sendAppendRequest(
+	ctx context.Context,
+	host string,
+	term int64,
+	req *raft.AppendRequest) error {
+
+	peer := n.peers.get(host)
+	if peer == nil {
+		return ErrPeerRemoved
+	}
+	rpcCtx, cancel := context.WithTimeout(ctx, time.Millisecond*12)
+	defer cancel()
+
+	prevLogIndex := req.PrevLogIndex
+	newEntries := req.Entries
+	idx := prevLogIndex + int64(len(newEntries)) + 1
+	ids := requestIDs(newEntries)
+	if ids != "" {
+		rpcCtx = metadata.AppendToOutgoingContext(rpcCtx, RequestIDMetadataKey, ids)
+	}
+	type appendReply struct {
+		reply  *raft.AppendReply
+		header metadata.MD
+	}
+	replicateStart := time.Now()
+	result, err := n.hedge(rpcCtx, hedgedRPC(ctx), func(ctx context.Context) (interface{}, error) {
+		var header metadata.MD
+		reply, err := peer.client().AppendLogs(ctx, req, grpc.Header(&header))
+		return appendReply{reply: reply, header: header}, err
+	})
+	reply, header := result.(appendReply).reply, result.(appendReply).header
+	replyReceived := time.Now()
+	if err == nil && len(newEntries) > 0 {
+		observePhase("replicate", replicateStart)
+		logger.Debug().
+			Str("host", host).
+			Strs("requestIds", header.Get(RequestIDMetadataKey)).
+			Bool("success", reply.Success).
+			Msg("Append reply")
 	}
-	reply, err := n.otherNodes[host].Client.AppendLogs(ctx, req)
 	if err == nil {
+		n.observeProgress(host, peer, reply.CommitIndex, reply.LastApplied, reply.Version)
+		n.observeClock(host, peer, replicateStart, replyReceived, reply.Time)
 		if reply.Success {
-			n.otherNodes[host].MatchIndex = idx - 1
-			n.otherNodes[host].NextIndex = idx
-			n.otherNodes[host].Available = true
+			peer.maybeAdvanceMatch(idx - 1)
+			n.setAvailable(host, true)
 			return nil
 		} else {
 			if prevLogIndex > 0 {
-				n.otherNodes[host].MatchIndex--
-				return n.requestAppend(host, term)
+				match := peer.matchIndex() - 1
+				// the entries the other node has committed are the same as
+				// this node's, so rather than stepping back one entry at a
+				// time, skip straight to its commit index (if it reports it),
+				// but not past the start of this node's log
+				if reply.Version != "" {
+					target := reply.CommitIndex
+					if floor := n.FirstLogIndex() - 1; target < floor {
+						target = floor
+					}
+					if target < match {
+						match = target
+					}
+				}
+				peer.setMatchIndex(match)
+				return n.requestAppend(ctx, host, term)
 			}
-			n.otherNodes[host].Available = false
+			n.setAvailable(host, false)
 			return ErrAppendRangeMet
 
-			// todo: would it be viable for AppendReply to include the other
-			// node's log index, so this could fast-forward to the correct
-			// index, rather than recursing possibly down the whole list?
-			// This implementation will blow the stack fast with any kind of
-			// realistic history when you add a fresh node
+			// todo: a node that reports no commit index (an earlier version)
+			// is still stepped back one entry at a time, recursing possibly
+			// down the whole list, which will blow the stack fast with any
+			// kind of realistic history when you add a fresh node
 
 		}
 	}
-	n.otherNodes[host].Available = false
+	if ctx.Err() != nil {
+		// the caller gave up, which says nothing about the other node
+		return ctx.Err()
+	}
+	n.setAvailable(host, false)
 	return err
 }
 
+// sendLimitedAppend calls `requestAppend` for a peer if there is room under
+// both the peer's and the node's limits on outstanding append requests (the
+// peer's limit being that one of its senders is free). If not, the request is
+// skipped--each append carries every entry the peer has not acknowledged, so a
+// later request will catch it up
+func (n *Node) sendLimitedAppend(ctx context.Context, host string, term int64) error {
+	req, err := n.appendRequest(host, term)
+	if err != nil {
+		return err
+	}
+	return n.sendLimitedRequest(ctx, host, term, req)
+}
+
+// sendLimitedRequest sends an append request built by `appendRequest`, subject
+// to the same limits as `sendLimitedAppend`
+func (n *Node) sendLimitedRequest(
+	ctx context.Context,
+	host string,
+	term int64,
+	req *raft.AppendRequest) error {
+
+	peer := n.peers.get(host)
+	if peer == nil {
+		return ErrPeerRemoved
+	}
+	return peer.submit(ctx, func(ctx context.Context) error {
+		if !trySemaphore(n.inflight) {
+			return ErrAppendInFlight
+		}
+		defer releaseSemaphore(n.inflight)
+		return n.sendAppendRequest(ctx, host, term, req)
+	})
+}
+
+// appendResult is the outcome of an append request to one peer
+type appendResult struct {
+	host    string
+	err     error
+	latency time.Duration
+}
+
 // SendAppend sends out append-logs requests to each other node in the cluster,
-// and updates database state on majority success
-func (n *Node) SendAppend(retriesRemaining int, term int64) error {
-	log.Trace().Msgf("SendAppend(r%d)", retriesRemaining)
-	if n.State != Leader {
-		log.Trace().Msg("SendAppend but not leader, returning")
+// and updates database state on majority success. It returns as soon as a
+// majority has the entries, without waiting for slower peers (e.g. in a
+// distant region), whose requests finish in the background. Rounds that miss
+// a majority are retried as set by policy (retry.Once for a single round).
+// Requests in flight are cancelled, and no more retries are made, once ctx is
+// done
+func (n *Node) SendAppend(ctx context.Context, policy retry.Policy, term int64) error {
+	return policy.Do(ctx, func(attempt int) error {
+		logger.Trace().Msgf("SendAppend(attempt %d)", attempt)
+		return n.appendRound(ctx, term)
+	})
+}
+
+// appendRound sends one round of append requests (see SendAppend)
+func (n *Node) appendRound(ctx context.Context, term int64) error {
+	if !n.IsLeader() {
+		logger.Trace().Msg("SendAppend but not leader, returning")
 		return ErrNotLeaderSend
 	}
+	// no round is sent on behalf of a caller that has given up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	numNodes := len(n.otherNodes)
-	majority := (numNodes / 2) + 1
-
-	log.Trace().Msgf("Number needed for append: %d", majority)
+	// members being bootstrapped are sent entries, but don't count toward
+	// the majority (of both memberships, during a joint change)
+	others := n.peers.snapshot()
+	config := n.configuration()
+	appendRounds.Inc()
+	roundStart := time.Now()
+
+	// Send append out to all other nodes with new record(s). Requests are
+	// built before any is sent, so that requests still in flight after this
+	// returns do not read the node's state
+	results := make(chan appendResult, len(others))
+	for k := range others {
+		req, err := n.appendRequest(k, term)
+		if err != nil {
+			results <- appendResult{host: k, err: err}
+			continue
+		}
+		go func(k string, req *raft.AppendRequest) {
+			start := time.Now()
+			err := n.sendLimitedRequest(ctx, k, term, req)
+			results <- appendResult{host: k, err: err, latency: time.Since(start)}
+		}(k, req)
+	}
 
-	var m sync.Mutex
-	numAppended := 1
-	// Send append out to all other nodes with new record(s)
-	var wg sync.WaitGroup
-	for k := range n.otherNodes {
-		// append new entries
-		// update indices
-		wg.Add(1)
-		go func(k string) {
-			defer wg.Done()
-			err := n.requestAppend(k, term)
-			if err != nil {
-				log.Debug().Err(err).Msgf(
-					"Error requesting append from %s for term %d", k, term)
-			} else {
-				m.Lock()
-				numAppended++
-				m.Unlock()
+	acked := map[string]bool{n.RaftNode.Id: true}
+	received := 0
+	for received < len(others) && !config.quorum(acked) {
+		r := <-results
+		received++
+		if r.err != nil {
+			logger.Debug().Err(r.err).Msgf(
+				"Error requesting append from %s for term %d", r.host, term)
+			continue
+		}
+		if !others[r.host].isLearner() {
+			acked[r.host] = true
+		}
+		appendLatency.Observe(r.host, r.latency.Seconds())
+		appendQuorum.Inc(r.host)
+	}
+	if remaining := len(others) - received; remaining > 0 {
+		go func() {
+			for i := 0; i < remaining; i++ {
+				if r := <-results; r.err == nil {
+					appendLatency.Observe(r.host, r.latency.Seconds())
+					appendLate.Inc(r.host)
+				}
 			}
-		}(k)
+		}()
 	}
-	wg.Wait()
 
-	log.Trace().Msgf("Appended to %d nodes", numAppended)
-	if numAppended >= majority {
-		log.Trace().Msg("majority")
+	logger.Trace().Msgf("Appended to %d nodes", len(acked))
+	if config.quorum(acked) {
+		logger.Trace().Msg("majority")
+		n.renewLease(term, roundStart)
 		// update commit index on this node and apply newly committed records
-		// to the database (next automatic append will commit on other nodes)
-		n.commitRecords()
+		// to the database (next automatic append will commit on other nodes),
+		// on the event loop, so that concurrent rounds apply each entry once
+		n.process(n.commitRecords)
 	} else {
-		log.Trace().Msg("minority")
+		logger.Trace().Msg("minority")
 		// did not get a majority
-		if retriesRemaining > 0 {
-			return n.SendAppend(retriesRemaining-1, term)
-		}
 		return ErrAppendFailed
 	}
 	return nil
 }
 
+// ReadBarrier confirms that this node is still the leader by completing a
+// round of append requests with a majority of the cluster (or, with a read
+// index window, by a recent or shared round), and returns the commit index as
+// of the start of the barrier. A client that waits for any node
+// to apply up to this index before reading from it will not observe state
+// older than what it has already seen (monotonic reads)
+func (n *Node) ReadBarrier(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return -1, ErrNotLeaderRecv
+	}
+	commitIndex := n.Committed()
+
+	// see readindex.go for when a round is shared, or not needed
+	if err := n.confirmLeadership(ctx, term); err != nil {
+		return -1, err
+	}
+
+	// leadership may have been lost while the round was in progress
+	if !n.leaderIn(term) {
+		return -1, ErrNotLeaderRecv
+	}
+	return commitIndex, nil
+}
+
 // NewNodeConfig creates a config for a Node
 func NewNodeConfig(dataDir string, addr, clientAddr string, nodeIds []string) NodeConfig {
 	return NodeConfig{
@@ -718,6 +3099,26 @@ func NewNodeConfig(dataDir string, addr, clientAddr string, nodeIds []string) No
 		TermFile:   filepath.Join(dataDir, "term"),
 		LogFile:    filepath.Join(dataDir, "raftlog"),
 		NodeIds:    nodeIds,
+
+		MaxInflightPerPeer: DefaultMaxInflightPerPeer,
+		MaxInflight:        DefaultMaxInflight,
+		ValueChunkSize:     DefaultValueChunkSize,
+		MaxProposalBytes:   DefaultMaxProposalBytes,
+		HedgeMaxPercent:    DefaultHedgeMaxPercent,
+		ApplyBatchSize:     DefaultApplyBatchSize,
+		UnknownNodeBackoff: DefaultUnknownNodeBackoff,
+
+		ElectionBackoffBase: DefaultElectionBackoffBase,
+		ElectionBackoffMax:  DefaultElectionBackoffMax,
+		CommitTimeout:       DefaultCommitTimeout,
+		AppendRetry:         DefaultAppendRetry,
+		ReconnectRetry:      DefaultReconnectRetry,
+		Version:             DefaultVersion,
+		LogSync:             DefaultLogSync,
+		ShedApplyLag:        DefaultShedApplyLag,
+		ShedQueuedWrites:    DefaultShedQueuedWrites,
+		MaxClockSkew:        DefaultMaxClockSkew,
+		AutoDemoteWindow:    DefaultAutoDemoteWindow,
 	}
 }
 
@@ -732,7 +3133,7 @@ func checkForeignNode(addr string, known map[string]*ForeignNode) bool {
 func NewNode(config NodeConfig, store *db.Database) (*Node, error) {
 	// Load persistent Node state
 	termRecord := ReadTerm(config.TermFile)
-	logStore := ReadLogs(config.LogFile)
+	logStore, logErr := loadLogs(config.LogFile)
 
 	// channels used by Node to communicate with StateManager
 	resetChannel := make(chan bool)
@@ -743,7 +3144,7 @@ func NewNode(config NodeConfig, store *db.Database) (*Node, error) {
 		votedForId = termRecord.VotedFor.Id
 	}
 
-	log.Info().
+	logger.Info().
 		Int64("Term", termRecord.Term).
 		Str("Vote", votedForId).
 		Int("nLogs", len(logStore.Entries)).
@@ -754,30 +3155,137 @@ func NewNode(config NodeConfig, store *db.Database) (*Node, error) {
 			Id:         config.Id,
 			ClientAddr: config.ClientAddr,
 		},
+		PersistentState: PersistentState{
+			Term:     termRecord.Term,
+			votedFor: termRecord.VotedFor,
+			Log:      logStore},
+		VolatileState: VolatileState{
+			CommitIndex: -1,
+			LastApplied: -1},
 		State:            Follower,
-		Term:             termRecord.Term,
-		votedFor:         termRecord.VotedFor,
 		Reset:            resetChannel,
-		otherNodes:       make(map[string]*ForeignNode),
+		peers:            newPeerSet(config.MaxInflightPerPeer),
 		CheckForeignNode: checkForeignNode,
 		AllowVote:        true,
-		CommitIndex:      -1,
-		lastApplied:      -1,
-		Log:              logStore,
+		leaderCommit:     -1,
+		batchIndex:       -1,
+		transferTerm:     -1,
+		logBytes:         int64(proto.Size(logStore)),
 		config:           config,
-		Store:            store}
-
+		Store:            store,
+		inflight:         newSemaphore(config.MaxInflight),
+		hedges:           newHedgeBudget(config.HedgeMaxPercent),
+		snapshotLimiter:  ratelimit.New(config.SnapshotBytesPerSecond),
+		writeLimiter:     ratelimit.New(config.SnapshotWriteBytesPerSecond),
+		backoff:          newElectionBackoff(config.Id, config.ElectionBackoffBase, config.ElectionBackoffMax),
+		appliedNotify:    make(chan struct{}),
+		writeQueue:       newWriteQueue(),
+		commands:         make(map[string]CommandHandler)}
+	n.persist = &n
+	n.proposals.settings = ProposalBatching{
+		MaxDelayMicros: config.ProposalBatchDelay.Microseconds(),
+		MaxEntries:     config.ProposalBatchEntries,
+		MaxBytes:       config.ProposalBatchBytes}
+
+	if config.ClusterId != "" {
+		if err := writeClusterId(config.DataDir, config.ClusterId); err != nil {
+			return nil, err
+		}
+	}
 	for _, addr := range config.NodeIds {
 		n.AddForeignNode(addr)
 	}
+	if config.PublishTopology {
+		n.listeners = append(n.listeners, n.publishTopologyOn)
+	}
+	if config.TopologyFile != "" {
+		n.applyHooks = append(n.applyHooks, n.writeTopologyOn)
+	}
+	// the running hash of applied entries can only be computed from the start
+	// of the log--if it has been compacted, the hash is restored along with
+	// the snapshot
+	if logStore.BaseIndex == 0 {
+		n.SetAppliedHash(-1, emptyHash())
+	} else {
+		n.SetAppliedHash(logStore.BaseIndex-1, 0)
+	}
+	if reason := readQuarantine(config.DataDir); reason != "" {
+		n.Quarantine(reason)
+	} else if logErr != nil {
+		n.Quarantine(fmt.Sprintf("Log file could not be loaded: %v", logErr))
+	}
 	return &n, nil
 }
 
-// AddForeignNode updates the list of known other members of the raft cluster
+// RestoreSnapshot installs a database restored from a snapshot, and advances
+// the commit and applied indexes to the last entry covered by the snapshot so
+// that those entries are not applied to the database a second time. hash is
+// the applied hash as of that entry (0 if the snapshot did not record it)
+func (n *Node) RestoreSnapshot(store *db.Database, lastApplied int64, hash uint64) {
+	n.Lock()
+	defer n.Unlock()
+
+	if last := n.LastLogIndex(); lastApplied > last {
+		logger.Warn().
+			Int64("lastApplied", lastApplied).
+			Int64("lastLogIndex", last).
+			Msg("Snapshot is ahead of the log")
+	}
+	n.Store = store
+	n.restoreApplied(lastApplied)
+	n.SetAppliedHash(lastApplied, hash)
+	if n.config.TopologyFile != "" && store.Exists(TopologyKey) {
+		n.writeTopologyFile(store.Get(TopologyKey))
+	}
+	n.restoreMembers()
+	n.notifyApplied()
+}
+
+// AddForeignNode updates the list of known other members of the raft cluster.
+// It is safe to call while the node is running (adding a member that is
+// already known does nothing)
 func (n *Node) AddForeignNode(addr string) {
-	log.Trace().Msgf("AddForeignNode: %s", addr)
-	n.otherNodes[addr], _ = NewForeignNode(addr)
-	log.Info().Msgf("Added %s to known nodes", addr)
+	logger.Trace().Msgf("AddForeignNode: %s", addr)
+	if n.peers.get(addr) != nil {
+		return
+	}
+	peer, err := NewForeignNode(addr, peerDialOptions(n.config.ReconnectRetry)...)
+	if err != nil {
+		return
+	}
+	peer.catchUp = ratelimit.New(n.config.CatchUpBytesPerSecond)
+	if !n.peers.add(addr, peer) {
+		// added concurrently
+		peer.Close()
+		return
+	}
+	logger.Info().Msgf("Added %s to known nodes", addr)
+	n.emit(EventMemberAdded, addr)
+}
+
+// RemoveForeignNode removes a member of the cluster, closing its connection.
+// Requests in flight to it fail, and its replication state is discarded, so a
+// member added again at the same address is caught up from the start of the
+// log as if it were new
+func (n *Node) RemoveForeignNode(addr string) error {
+	if n.peers.remove(addr) == nil {
+		return ErrPeerRemoved
+	}
+	logger.Info().Msgf("Removed %s from known nodes", addr)
+	n.emit(EventMemberRemoved, addr)
+	return nil
+}
+
+// ReconnectForeignNode replaces the connection to a member of the cluster with
+// a new one, e.g. after the host at its address has changed. Requests in
+// flight on the old connection fail
+func (n *Node) ReconnectForeignNode(addr string) error {
+	peer := n.peers.get(addr)
+	if peer == nil {
+		return ErrPeerRemoved
+	}
+	logger.Info().Str("peer", addr).Msg("Reconnecting")
+	return peer.reconnect(addr)
 }
 
 // availability returns the number of nodes believed to be currently available
@@ -786,9 +3294,9 @@ func (n *Node) availability() (int, int) {
 	// initialize to 1 to account for the self
 	available := 1
 	total := 1
-	for _, foreignNode := range n.otherNodes {
+	for _, foreignNode := range n.peers.snapshot() {
 		total++
-		if foreignNode.Available {
+		if foreignNode.available() {
 			available++
 		}
 	}
@@ -802,23 +3310,23 @@ func (n *Node) availability() (int, int) {
 // CandidateLogUpToDate 检查候选人的日志索引是否至少与节点的提交索引一样高（例如：候选人具有所有已知的提交条目）
 func (n *Node) candidateLogUpToDate(cLogIndex int64, cLogTerm int64) bool {
 
-	indexGreater := cLogIndex > n.CommitIndex
+	indexGreater := cLogIndex > n.Committed()
 
-	indexEqual := cLogIndex == n.CommitIndex
+	indexEqual := cLogIndex == n.Committed()
 
-	bothEmpty := cLogIndex == -1 && n.CommitIndex == -1
+	bothEmpty := cLogIndex == -1 && n.Committed() == -1
 
-	indexPresent := cLogIndex < int64(len(n.Log.Entries))
+	logTerm, indexPresent := termAt(n.Log, cLogIndex)
 
-	upToDate := indexGreater || bothEmpty || (indexEqual && cLogTerm == n.Log.Entries[cLogIndex].Term)
+	upToDate := indexGreater || bothEmpty || (indexEqual && indexPresent && cLogTerm == logTerm)
 
 	if !upToDate {
-		failLog := log.Debug().
+		failLog := logger.Debug().
 			Int64("CLogIdx", cLogIndex).
-			Int64("CommitIdx", n.CommitIndex).
+			Int64("CommitIdx", n.Committed()).
 			Int64("CLogTerm", cLogTerm)
 		if indexPresent {
-			failLog.Int64("LogTerm", n.Log.Entries[cLogIndex].Term)
+			failLog.Int64("LogTerm", logTerm)
 		}
 		failLog.Msg("candidate log not up to date")
 	}
@@ -828,18 +3336,51 @@ func (n *Node) candidateLogUpToDate(cLogIndex int64, cLogTerm int64) bool {
 
 // HandleVote responds to vote requests from candidate nodes
 func (n *Node) HandleVote(req *raft.VoteRequest) *raft.VoteReply {
-	log.Info().Msgf("%s proposed term: %d", req.Candidate.Id, req.Term)
+	var reply *raft.VoteReply
+	n.process(func() {
+		reply = n.handleVote(req)
+	})
+	return reply
+}
+
+// handleVote responds to a vote request on the event loop
+func (n *Node) handleVote(req *raft.VoteRequest) *raft.VoteReply {
+	logger.Info().Msgf("%s proposed term: %d", req.Candidate.Id, req.Term)
 	var vote bool
 	var msg string
+	var reason raft.VoteReply_DenyReason
 
 	// 旧的任期，直接拒绝
 	if req.Term < n.Term {
 		vote = false
 		msg = "Past term vote received"
-	// 相同任期，拒绝投票，并检查是否发生任期冲突
-	} else if req.Term == n.Term {
+		reason = raft.VoteReply_STALE_TERM
+		// an isolated candidate's requests are ignored, terms included
+	} else if n.isolatedCandidate(req.Candidate.Id) {
+		vote = false
+		msg = "Candidate is isolated"
+		reason = raft.VoteReply_ISOLATED
+		// a quarantined node does not vote, but still learns of later terms
+	} else if n.Quarantined() {
+		vote = false
+		msg = "Quarantined, not voting"
+		reason = raft.VoteReply_QUARANTINED
+		if req.Term > n.Term {
+			msg = msg + ", advancing term"
+			n.advanceTerm(req.Term)
+		}
+		// 相同任期，拒绝投票，并检查是否发生任期冲突 (unless this node learned of the
+		// term without voting in it, in which case the vote is decided as for a
+		// later term)
+	} else if req.Term == n.Term && n.votedFor != nil && n.votedFor.Id == req.Candidate.Id {
+		// a repeated request (such as a hedged duplicate) from the candidate
+		// this node already voted for in this term
+		vote = true
+		msg = "Repeated vote request, voting yay"
+	} else if req.Term == n.Term && n.votedFor != nil {
 		vote = false
 		msg = "Current term vote received"
+		reason = raft.VoteReply_ALREADY_VOTED
 		// If this node is the leader, and a vote request is received for the
 		// current term, the current term should be increased because the other
 		// node will have voted for itself and therefore not accept appends from
@@ -855,41 +3396,102 @@ func (n *Node) HandleVote(req *raft.VoteRequest) *raft.VoteReply {
 		if n.State == Leader {
 			// 更新 term 信息
 			msg = msg + ", incrementing term"
-			n.SetTerm(n.Term+1, n.RaftNode)
+			if err := n.SetTerm(n.Term+1, n.RaftNode); err != nil {
+				msg = msg + " failed"
+			}
 		}
-	// 检查是否为合法节点
-	} else if !n.CheckForeignNode(req.Candidate.Id, n.otherNodes) {
+		// a candidate can't win while this node has heard from the leader
+		// within the leader lease, unless the leader asked it to stand
+	} else if !req.Transfer && n.withinLease() {
+		vote = false
+		msg = "Heard from leader within lease"
+		reason = raft.VoteReply_LEADER_LEASE
+		// 检查是否为合法节点
+	} else if !n.CheckForeignNode(req.Candidate.Id, n.peers.snapshot()) {
 		vote = false
 		msg = "Unknown foreign node: " + req.Candidate.Id
-	//
+		reason = raft.VoteReply_UNKNOWN_NODE
+		// a learner is not a voting member, so it can't be elected either
+	} else if n.learnerCandidate(req.Candidate.Id) {
+		vote = false
+		msg = "Candidate is a learner: " + req.Candidate.Id
+		reason = raft.VoteReply_UNKNOWN_NODE
+		//
 	} else if !n.candidateLogUpToDate(req.LastLogIndex, req.LastLogTerm) {
 		vote = false
 		msg = "Candidate log not up to date"
-	// 是否在静默期，禁止投票
+		reason = raft.VoteReply_LOG_BEHIND
+		// the candidate can't win, but its term is still the latest known
+		if req.Term > n.Term {
+			msg = msg + ", advancing term"
+			n.advanceTerm(req.Term)
+		}
+		// 是否在静默期，禁止投票
 	} else if !n.AllowVote {
 		vote = false
 		msg = "Leader still in grace period"
-	// 同意投票
+		reason = raft.VoteReply_LEADER_GRACE
+		// A valid candidate with an up-to-date log has started a later term, so
+		// this node's term as leader is over regardless of the grace period--step
+		// down and adopt the new term, but without voting in it
+		if n.State == Leader {
+			msg = msg + ", stepping down"
+			n.advanceTerm(req.Term)
+		}
+		// 同意投票
 	} else {
 		msg = "Voting yay"
-		vote = true
+		if n.State == Leader {
+			msg = "Stepping down, voting yay"
+		}
 		// 重置定时器
 		n.resetElectionTimer()
-		// 记录投票状态
-		n.SetTerm(req.Term, req.Candidate)
+		// 记录投票状态--a vote that is not persisted is not granted, since
+		// this node could vote again in the same term after a restart
+		if err := n.SetTerm(req.Term, req.Candidate); err != nil {
+			vote = false
+			msg = "Failed to persist vote, voting nay"
+			reason = raft.VoteReply_NOT_PERSISTED
+		} else {
+			vote = true
+			// the leader of the new term is not known until it sends an append
+			n.currentLeader = nil
+		}
 	}
 
-	log.Info().
+	logger.Info().
 		Int64("Term", n.Term).
 		Bool("Granted", vote).
+		Stringer("reason", reason).
 		Msg(msg)
 
 	// 返回投票响应
 	return &raft.VoteReply{
-		Term:        n.Term,		// 任期
-		VoteGranted: vote,			// 投票状态
-		Node:        n.RaftNode,	// 节点信息
+		Term:        n.Term,     // 任期
+		VoteGranted: vote,       // 投票状态
+		Node:        n.RaftNode, // 节点信息
+		DenyReason:  reason,
+		CommitIndex: n.Committed(),
+		LastApplied: n.Applied(),
+		Version:     n.config.Version,
+	}
+}
+
+// advanceTerm records a later term that this node has learned of without
+// voting in it (a nil vote, which still allows it to vote in that term), and
+// steps down if this node is the leader
+func (n *Node) advanceTerm(term int64) {
+	logger.Info().
+		Int64("term", n.Term).
+		Int64("newTerm", term).
+		Bool("leader", n.State == Leader).
+		Msg("Advancing term without voting")
+	if n.State == Leader {
+		n.resetElectionTimer()
+		n.AllowVote = true
 	}
+	n.SetTerm(term, nil)
+	n.currentLeader = nil
 }
 
 // validateAppend performs all checks for valid append request
@@ -899,11 +3501,11 @@ func (n *Node) validateAppend(term int64, leaderId string) bool {
 	// reply false if req term < current term
 	if term < n.Term {
 		success = false
-	} else if term == n.Term && leaderId != n.votedFor.Id {
-		log.Error().
+	} else if term == n.Term && n.currentLeader != nil && leaderId != n.currentLeader.Id {
+		logger.Error().
 			Int64("term", n.Term).
 			Str("got", leaderId).
-			Str("expected", n.votedFor.Id).
+			Str("expected", n.currentLeader.Id).
 			Msgf("Append request leader mismatch")
 		success = false
 	}
@@ -920,83 +3522,130 @@ func reconcileLogs(
 	// note: don't memoize length of Entries, it changes multiple times
 	// during this method--safer to recalculate, and memoizing would
 	// only save a maximum of one pass so it's not worth it
+	//
+	// indexes below are relative to the start of logStore.Entries--entries that
+	// have been compacted are committed, so any that the leader sends again are
+	// known to match and are skipped
+	bodyEntries := body.Entries
+	prevLogIndex := body.PrevLogIndex - logStore.BaseIndex
+	if prevLogIndex < -1 {
+		skip := -1 - prevLogIndex
+		if skip > int64(len(bodyEntries)) {
+			skip = int64(len(bodyEntries))
+		}
+		bodyEntries = bodyEntries[skip:]
+		prevLogIndex = -1
+	}
+	// the log is only truncated where an entry conflicts with one sent by the
+	// leader (a different term at the same index)--entries past the end of a
+	// shorter, stale request are kept, as the leader may already count them
+	// as replicated
 	var mismatchIdx int64
 	mismatchIdx = -1
-	if body.PrevLogIndex < int64(len(logStore.Entries)-1) {
-		overlappingEntries := logStore.Entries[body.PrevLogIndex+1:]
+	if prevLogIndex < int64(len(logStore.Entries)-1) {
+		overlappingEntries := logStore.Entries[prevLogIndex+1:]
 		for i, rec := range overlappingEntries {
-			if i >= len(body.Entries) {
-				mismatchIdx = body.PrevLogIndex + int64(i)
+			if i >= len(bodyEntries) {
 				break
 			}
-			if rec.Term != body.Entries[i].Term {
-				mismatchIdx = body.PrevLogIndex + 1 + int64(i)
+			if rec.Term != bodyEntries[i].Term {
+				mismatchIdx = prevLogIndex + 1 + int64(i)
 				break
 			}
 		}
 	}
+	// logStore is left as it is, so that it is still the node's log if the
+	// result cannot be persisted--the capacity of a rewound slice is limited
+	// so that appending copies it rather than overwriting logStore's entries
+	entries := logStore.Entries
 	if mismatchIdx >= 0 {
-		log.Debug().Msgf("Mismatch index: %d - rewinding log", mismatchIdx)
-		logStore.Entries = logStore.Entries[:mismatchIdx]
+		logger.Debug().Msgf("Mismatch index: %d - rewinding log", mismatchIdx+logStore.BaseIndex)
+		entries = entries[:mismatchIdx:mismatchIdx]
 	}
 	// append any entries not already in log
-	offset := int64(len(logStore.Entries)-1) - body.PrevLogIndex
-	newLogs := body.Entries[offset:]
-	log.Info().Msgf("Appending %d entries from %s", len(newLogs), body.Leader.Id)
-	return &raft.LogStore{Entries: append(logStore.Entries, newLogs...)}
+	offset := int64(len(entries)-1) - prevLogIndex
+	if offset > int64(len(bodyEntries)) {
+		offset = int64(len(bodyEntries))
+	}
+	newLogs := bodyEntries[offset:]
+	logger.Info().Msgf("Appending %d entries from %s", len(newLogs), body.Leader.Id)
+	return &raft.LogStore{
+		Entries:   append(entries, newLogs...),
+		BaseIndex: logStore.BaseIndex,
+		BaseTerm:  logStore.BaseTerm}
 }
 
 // applyCommittedLogs updates the database with actions that have not yet been
 // applied, up to the new commit index
 func (n *Node) applyCommittedLogs(commitIdx int64) {
-	log.Debug().
-		Int64("current", n.CommitIndex).
+	logger.Debug().
+		Int64("current", n.Committed()).
 		Int64("leader", commitIdx).
 		Msg("apply commits")
 
-	if commitIdx > n.CommitIndex {
+	if commitIdx > n.Committed() {
 
 		// ensure we don't run over the end of the log
 		//
-		lastIndex := int64(len(n.Log.Entries))
-		if commitIdx > lastIndex {
-			commitIdx = lastIndex
+		lastIdx := lastIndex(n.Log)
+		if commitIdx > lastIdx {
+			commitIdx = lastIdx
 		}
 
 		// apply all entries up to new commit index to store
-		for n.CommitIndex < commitIdx {
-			n.CommitIndex++
-			action := n.Log.Entries[n.CommitIndex].Action
-			key := n.Log.Entries[n.CommitIndex].Key
-			if action == raft.LogRecord_SET {
-				value := n.Log.Entries[n.CommitIndex].Value
-				n.Store.Set(key, value)
-			} else if action == raft.LogRecord_DEL {
-				n.Store.Delete(key)
-			}
-		}
+		n.commitThrough(commitIdx)
+		n.applyThrough(commitIdx)
+		n.notifyApplied()
 
-		log.Info().
-			Int64("commit", n.CommitIndex).
+		logger.Info().
+			Int64("commit", n.Committed()).
 			Msg("Commit updated")
 	}
 }
 
+// stripEntries returns copies of log records with only the metadata that a
+// witness needs to keep (term, action, and time, and the members of a MEMBERS
+// entry), dropping keys and values
+func stripEntries(entries []*raft.LogRecord) []*raft.LogRecord {
+	stripped := make([]*raft.LogRecord, 0, len(entries))
+	for _, entry := range entries {
+		record := &raft.LogRecord{
+			Term:      entry.Term,
+			Action:    entry.Action,
+			Timestamp: entry.Timestamp}
+		if entry.Action == raft.LogRecord_MEMBERS {
+			record.Members = entry.Members
+			record.OldMembers = entry.OldMembers
+		}
+		stripped = append(stripped, record)
+	}
+	return stripped
+}
+
 // checkPrevious returns true if Node.logs contains an entry at the specified
 // index with the specified term, otherwise false
 func (n *Node) checkPrevious(prevIndex int64, prevTerm int64) bool {
 
-	if prevIndex < 0 {
+	// compacted entries are committed, so they match any valid leader's log
+	if prevIndex < 0 || prevIndex < n.Log.BaseIndex-1 {
 		return true
 	}
 
-	inRange := prevIndex < int64(len(n.Log.Entries))
-	matches := n.Log.Entries[prevIndex].Term == prevTerm
-	return inRange && matches
+	term, ok := termAt(n.Log, prevIndex)
+	return ok && term == prevTerm
 }
 
 // HandleAppend responds to append-log messages from leader nodes
 func (n *Node) HandleAppend(req *raft.AppendRequest) *raft.AppendReply {
+	var reply *raft.AppendReply
+	n.process(func() {
+		reply = n.handleAppend(req)
+	})
+	return reply
+}
+
+// handleAppend responds to an append request on the event loop
+func (n *Node) handleAppend(req *raft.AppendRequest) *raft.AppendReply {
 	var success bool
 
 	valid := n.validateAppend(req.Term, req.Leader.Id)
@@ -1009,22 +3658,51 @@ func (n *Node) HandleAppend(req *raft.AppendRequest) *raft.AppendReply {
 		success = false
 	} else {
 		// Valid request, and all required logs present
+		persisted := true
 		if len(req.Entries) > 0 {
-			n.Log = reconcileLogs(n.Log, req)
-			n.setLog(n.Log.Entries)
+			if n.IsWitness() {
+				req.Entries = stripEntries(req.Entries)
+			}
+			if _, err := n.setLog(reconcileLogs(n.Log, req).Entries); err != nil {
+				// entries that are not persisted are not acknowledged, so the
+				// leader sends them again
+				persisted = false
+			} else {
+				logger.Debug().
+					Str("leader", req.Leader.Id).
+					Str("requestIds", requestIDs(req.Entries)).
+					Msg("Appended entries from leader")
+			}
 		}
-		n.applyCommittedLogs(req.LeaderCommit)
-		success = true
+		n.noteLeaderCommit(req.LeaderCommit)
+		if persisted {
+			n.applyCommittedLogs(req.LeaderCommit)
+			n.checkAppliedHash(req.HashIndex, req.AppliedHash)
+			n.checkCaughtUp(req.LeaderCommit)
+			if n.Applied() >= req.LeaderCommit {
+				n.markFresh(req.LeaseAge)
+			}
+		}
+		success = persisted
 	}
 	if valid {
-		// update term if necessary
+		// update term if necessary--this node did not necessarily vote for the
+		// leader, so no vote is recorded for the new term
 		if req.Term > n.Term {
-			log.Info().
+			logger.Info().
 				Int64("newTerm", req.Term).
-				Str("votedFor", req.Leader.Id).
+				Str("leader", req.Leader.Id).
 				Msg("Got more recent append, updating term record")
-			n.SetTerm(req.Term, req.Leader)
+			if err := n.SetTerm(req.Term, nil); err != nil {
+				success = false
+			}
+		}
+		if n.currentLeader == nil || n.currentLeader.Id != req.Leader.Id {
+			n.currentLeader = req.Leader
+			n.emit(EventLeaderChange, req.Leader.Id)
 		}
+		n.leaderContact = time.Now()
+		n.backoff.reset()
 		// reset the election timer on append from a valid leader (even if
 		// not matched)--this duplicates the reset in `validateAppend`, in order to
 		// ensure that the time it takes to do all of the operations in this
@@ -1033,5 +3711,11 @@ func (n *Node) HandleAppend(req *raft.AppendRequest) *raft.AppendReply {
 		n.resetElectionTimer()
 	}
 	// finally
-	return &raft.AppendReply{Term: n.Term, Success: success}
+	return &raft.AppendReply{
+		Term:        n.Term,
+		Success:     success,
+		CommitIndex: n.Committed(),
+		LastApplied: n.Applied(),
+		Version:     n.config.Version,
+		Time:        time.Now().UnixNano()}
 }
@@ -3,6 +3,7 @@
 package node
 
 import (
+	"fmt"
 	"log"
 	"testing"
 
@@ -83,3 +84,33 @@ func BenchmarkFullAppend(b *testing.B) {
 		n.HandleAppend(req)
 	}
 }
+
+// BenchmarkLogWrite compares the ways of syncing the log file, with and
+// without preallocation, by writing a log that grows by one entry each time
+func BenchmarkLogWrite(b *testing.B) {
+	for _, mode := range []LogSyncMode{LogSyncNone, LogSyncFsync, LogSyncDsync, LogSyncDirect} {
+		if _, err := ParseLogSyncMode(string(mode)); err != nil {
+			continue
+		}
+		for _, preallocate := range []int64{0, 1 << 20} {
+			name := fmt.Sprintf("sync=%s/preallocate=%d", mode, preallocate)
+			b.Run(name, func(b *testing.B) {
+				n := setupNodeBench(b)
+				n.config.LogSync = mode
+				n.config.LogPreallocate = preallocate
+				entries := make([]*raft.LogRecord, 0, b.N)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					entries = append(entries, &raft.LogRecord{
+						Term:   1,
+						Action: raft.LogRecord_SET,
+						Key:    "a",
+						Value:  "b"})
+					if err := n.writeLogs(&raft.LogStore{Entries: entries}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -3,16 +3,31 @@
 package node
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
 
 	db "github.com/btmorr/leifdb/internal/database"
 	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/internal/ratelimit"
 	"github.com/btmorr/leifdb/internal/testutil"
 	"github.com/btmorr/leifdb/internal/util"
+	"github.com/btmorr/leifdb/retry"
 )
 
 func init() {
@@ -83,7 +98,7 @@ func TestAvailabilityReport(t *testing.T) {
 	// add another and make an RPC call to one of them, since RPC is not mocked,
 	// it will fail, so one will report unavailable
 	n.AddForeignNode(host2)
-	n.requestAppend(host2, n.Term)
+	n.requestAppend(context.Background(), host2, n.Term)
 	avail, total = n.availability()
 	if avail != 2 {
 		t.Errorf("Availability with 1 other up, 1 other down should be 2, got %d\n", avail)
@@ -173,10 +188,11 @@ func TestPersistence(t *testing.T) {
 }
 
 type VoteTestCase struct {
-	name       string
-	request    *raft.VoteRequest
-	expectTerm int64
-	expectVote bool
+	name         string
+	request      *raft.VoteRequest
+	expectTerm   int64
+	expectVote   bool
+	expectReason raft.VoteReply_DenyReason
 }
 
 func TestVote(t *testing.T) {
@@ -213,8 +229,9 @@ func TestVote(t *testing.T) {
 				Candidate:    testRaftNode,
 				LastLogIndex: 1,
 				LastLogTerm:  2},
-			expectTerm: 2,
-			expectVote: false},
+			expectTerm:   2,
+			expectVote:   false,
+			expectReason: raft.VoteReply_STALE_TERM},
 		{
 			name: "Vote request same term",
 			request: &raft.VoteRequest{
@@ -222,8 +239,9 @@ func TestVote(t *testing.T) {
 				Candidate:    testRaftNode,
 				LastLogIndex: 1,
 				LastLogTerm:  2},
-			expectTerm: 3,
-			expectVote: false},
+			expectTerm:   3,
+			expectVote:   false,
+			expectReason: raft.VoteReply_ALREADY_VOTED},
 		{
 			name: "Vote request log behind",
 			request: &raft.VoteRequest{
@@ -231,8 +249,9 @@ func TestVote(t *testing.T) {
 				Candidate:    testRaftNode,
 				LastLogIndex: 0,
 				LastLogTerm:  1},
-			expectTerm: 3,
-			expectVote: false},
+			expectTerm:   4,
+			expectVote:   false,
+			expectReason: raft.VoteReply_LOG_BEHIND},
 		{
 			name: "Vote request log incorrect (shouldn't happen)",
 			request: &raft.VoteRequest{
@@ -240,8 +259,9 @@ func TestVote(t *testing.T) {
 				Candidate:    testRaftNode,
 				LastLogIndex: 1,
 				LastLogTerm:  1},
-			expectTerm: 3,
-			expectVote: false},
+			expectTerm:   4,
+			expectVote:   false,
+			expectReason: raft.VoteReply_LOG_BEHIND},
 		{
 			name: "Vote request valid, candidate equal",
 			request: &raft.VoteRequest{
@@ -251,6 +271,15 @@ func TestVote(t *testing.T) {
 				LastLogTerm:  2},
 			expectTerm: 4,
 			expectVote: true},
+		{
+			name: "Vote request repeated",
+			request: &raft.VoteRequest{
+				Term:         4,
+				Candidate:    testRaftNode,
+				LastLogIndex: 1,
+				LastLogTerm:  2},
+			expectTerm: 4,
+			expectVote: true},
 		{
 			name: "Vote request valid, candidate ahead",
 			request: &raft.VoteRequest{
@@ -271,10 +300,23 @@ func TestVote(t *testing.T) {
 		if reply.VoteGranted != tc.expectVote {
 			t.Errorf("[%s] Expected vote %t but got %t\n", tc.name, tc.expectVote, reply.VoteGranted)
 		}
+		if reply.DenyReason != tc.expectReason {
+			t.Errorf("[%s] Expected reason %s but got %s\n", tc.name, tc.expectReason, reply.DenyReason)
+		}
 	}
-	// After test cases, node should have voted for `testRaftNode` and redirect to it
+	// After test cases, node has voted for `testRaftNode`, but does not know
+	// whether it won the election, so there is no leader to redirect to
 	redirectNode := n.RedirectLeader()
-	if redirectNode != testRaftNode.ClientAddr {
+	if redirectNode != "" {
+		t.Errorf("Expected no redirect before an append from the leader, but got %s\n", redirectNode)
+	}
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       testRaftNode,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+	if redirectNode = n.RedirectLeader(); redirectNode != testRaftNode.ClientAddr {
 		t.Errorf("Expected redirect to %s, but got %s\n", testRaftNode.ClientAddr, redirectNode)
 	}
 }
@@ -366,7 +408,7 @@ func TestReconcileLogs(t *testing.T) {
 				Entries:      nextTwo},
 			Expected: appendLog},
 		{
-			Name:  "Match but truncate",
+			Name:  "Match, stale and shorter",
 			Store: appendLog,
 			Request: &raft.AppendRequest{
 				Term:         6,
@@ -375,7 +417,7 @@ func TestReconcileLogs(t *testing.T) {
 				PrevLogTerm:  3,
 				LeaderCommit: -1,
 				Entries:      []*raft.LogRecord{nextTwo[0]}},
-			Expected: &raft.LogStore{Entries: appendLog.Entries[:4]}},
+			Expected: appendLog},
 		{
 			Name:  "Mismatch and add",
 			Store: starterLog,
@@ -399,6 +441,40 @@ func TestReconcileLogs(t *testing.T) {
 	}
 }
 
+func TestStaleAppend(t *testing.T) {
+	n := setupNode(t)
+	leader := &raft.Node{Id: "localhost:16991", ClientAddr: "localhost:8181"}
+	entries := make([]*raft.LogRecord, 4)
+	for i := range entries {
+		entries[i] = &raft.LogRecord{Term: 1, Action: raft.LogRecord_SET, Key: fmt.Sprint(i)}
+	}
+	reply := n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1,
+		Entries:      entries})
+	if !reply.Success {
+		t.Fatalf("Expected append of entries 0..3 to succeed")
+	}
+	// a request sent earlier, delivered late, holds a prefix of the same
+	// entries: it matches, and must not remove the entries after it
+	reply = n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1,
+		Entries:      entries[:2]})
+	if !reply.Success {
+		t.Errorf("Expected stale append of entries 0..1 to succeed")
+	}
+	if last := lastIndex(n.Log); last != 3 {
+		t.Errorf("Expected last index 3 after a stale append, got %d", last)
+	}
+}
+
 type CommitTestCase struct {
 	Name     string
 	Store    *raft.LogStore
@@ -543,7 +619,3084 @@ func TestUpdateTermViaAppend(t *testing.T) {
 	if n.Term != newTerm {
 		t.Errorf("Expected term %d but got %d", newTerm, n.Term)
 	}
-	if n.votedFor.Id != otherNode.Id {
-		t.Errorf("Expected voted for %s but got %s", otherNode.Id, n.votedFor.Id)
+	if n.votedFor != nil {
+		t.Errorf("Expected no vote in term learned from append, got %v", n.votedFor)
+	}
+	if n.RedirectLeader() != otherNode.ClientAddr {
+		t.Errorf("Expected redirect to %s but got %s", otherNode.ClientAddr, n.RedirectLeader())
+	}
+}
+
+func TestWitness(t *testing.T) {
+	n := setupNode(t)
+	n.config.Witness = true
+
+	leader := &raft.Node{
+		Id:         "localhost:8181",
+		ClientAddr: "localhost:80",
+	}
+	n.SetTerm(1, leader)
+
+	req := &raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: 0,
+		Entries: []*raft.LogRecord{
+			{
+				Term:   1,
+				Action: raft.LogRecord_SET,
+				Key:    "Harry",
+				Value:  "present"},
+			{
+				Term:   1,
+				Action: raft.LogRecord_DEL,
+				Key:    "Ron"}}}
+	reply := n.HandleAppend(req)
+	if !reply.Success {
+		t.Error("Expected append success")
+	}
+
+	if len(n.Log.Entries) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(n.Log.Entries))
+	}
+	for idx, entry := range n.Log.Entries {
+		if entry.Key != "" || entry.Value != "" {
+			t.Errorf("Witness log entry %d should not contain data: %v", idx, entry)
+		}
+		if entry.Term != 1 {
+			t.Errorf("Expected witness log entry %d to keep term 1, got %d", idx, entry.Term)
+		}
+	}
+	if n.CommitIndex != 0 || n.LastApplied != 0 {
+		t.Errorf(
+			"Expected commit and applied index 0, got %d and %d",
+			n.CommitIndex, n.LastApplied)
+	}
+	if v := n.Store.Get("Harry"); v != "" {
+		t.Errorf("Witness should not store values, got Harry=%s", v)
+	}
+
+	term := n.Term
+	if n.DoElection() {
+		t.Error("Witness should not win an election")
+	}
+	if n.Term != term {
+		t.Errorf("Witness should not start a new term, went from %d to %d", term, n.Term)
+	}
+}
+
+func TestInflightLimit(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	host := "localhost:12345"
+	n.AddForeignNode(host)
+	peer := n.peers.get(host)
+
+	// keep every sender for the peer busy, so the next append must be skipped
+	busy := make(chan struct{})
+	started := make(chan struct{})
+	for i := 0; i < n.config.MaxInflightPerPeer; i++ {
+		go peer.submit(context.Background(), func(context.Context) error {
+			started <- struct{}{}
+			<-busy
+			return nil
+		})
+		<-started
+	}
+
+	err := n.sendLimitedAppend(context.Background(), host, n.Term)
+	if err != ErrAppendInFlight {
+		t.Errorf("Expected %v, got %v", ErrAppendInFlight, err)
+	}
+	if !peer.Available {
+		t.Error("Skipped append should not mark the peer unavailable")
+	}
+
+	// once a sender frees up, the request is sent (and fails, since the peer
+	// does not exist)
+	close(busy)
+	deadline := time.Now().Add(time.Second)
+	for err == ErrAppendInFlight && time.Now().Before(deadline) {
+		err = n.sendLimitedAppend(context.Background(), host, n.Term)
+	}
+	if err == nil || err == ErrAppendInFlight {
+		t.Errorf("Expected an RPC error, got %v", err)
+	}
+	if peer.Available {
+		t.Error("Failed append should mark the peer unavailable")
+	}
+}
+
+func TestCustomCommand(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	var applied []string
+	n.RegisterCommand("record", func(data []byte) error {
+		applied = append(applied, string(data))
+		return nil
+	})
+
+	if err := n.Propose(context.Background(), "unregistered", []byte("x")); err != ErrUnknownCommand {
+		t.Errorf("Expected %v for unregistered command, got %v", ErrUnknownCommand, err)
+	}
+
+	if err := n.Propose(context.Background(), "record", []byte("schema v2")); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	if len(applied) != 1 || applied[0] != "schema v2" {
+		t.Errorf("Expected handler to apply [schema v2], got %v", applied)
+	}
+
+	// followers dispatch custom entries the same way as they are committed,
+	// and skip entries with no handler rather than failing
+	f := setupNode(t)
+	var followerApplied []string
+	f.RegisterCommand("record", func(data []byte) error {
+		followerApplied = append(followerApplied, string(data))
+		return nil
+	})
+	leader := &raft.Node{Id: "localhost:8181", ClientAddr: "localhost:80"}
+	f.SetTerm(1, leader)
+	reply := f.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: 1,
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_CUSTOM, Command: "other", Data: []byte("a")},
+			{Term: 1, Action: raft.LogRecord_CUSTOM, Command: "record", Data: []byte("b")}}})
+	if !reply.Success {
+		t.Error("Expected append success")
+	}
+	if len(followerApplied) != 1 || followerApplied[0] != "b" {
+		t.Errorf("Expected follower handler to apply [b], got %v", followerApplied)
+	}
+	if f.LastApplied != 1 {
+		t.Errorf("Expected applied index 1, got %d", f.LastApplied)
+	}
+}
+
+func TestApplyHooks(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	var calls []string
+	n.AddApplyHook(func(index int64, entry *raft.LogRecord, result error) {
+		calls = append(calls, fmt.Sprintf("first %d %s %v", index, entry.Key, result))
+	})
+	n.AddApplyHook(func(index int64, entry *raft.LogRecord, result error) {
+		calls = append(calls, fmt.Sprintf("second %d %s %v", index, entry.Key, result))
+	})
+	n.RegisterCommand("fail", func(data []byte) error {
+		return errors.New("nope")
+	})
+
+	n.Set(context.Background(), "a", "1")
+	n.Delete(context.Background(), "a")
+	n.Propose(context.Background(), "fail", nil)
+
+	expected := []string{
+		"first 0 a <nil>",
+		"second 0 a <nil>",
+		"first 1 a <nil>",
+		"second 1 a <nil>",
+		"first 2  nope",
+		"second 2  nope"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected hook calls %v, got %v", expected, calls)
+	}
+}
+
+func TestReadBarrier(t *testing.T) {
+	n := setupNode(t)
+
+	if _, err := n.ReadBarrier(context.Background()); err != ErrNotLeaderRecv {
+		t.Errorf("Expected %v from follower, got %v", ErrNotLeaderRecv, err)
+	}
+
+	n.State = Leader
+	n.Set(context.Background(), "a", "1")
+	n.Set(context.Background(), "b", "2")
+
+	index, err := n.ReadBarrier(context.Background())
+	if err != nil {
+		t.Fatalf("ReadBarrier failed: %v", err)
+	}
+	if index != 1 {
+		t.Errorf("Expected barrier at commit index 1, got %d", index)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := n.ReadBarrier(ctx); err != context.Canceled {
+		t.Errorf("Expected %v for cancelled context, got %v", context.Canceled, err)
+	}
+}
+
+func TestReadIndexWindow(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.ReadIndexWindow = time.Hour
+	rounds := readIndexRequests.Value(readIndexRound)
+	cached := readIndexRequests.Value(readIndexCached)
+
+	if _, err := n.ReadBarrier(context.Background()); err != nil {
+		t.Fatalf("ReadBarrier failed: %v", err)
+	}
+	n.Set(context.Background(), "a", "1")
+	index, err := n.ReadBarrier(context.Background())
+	if err != nil {
+		t.Fatalf("ReadBarrier failed: %v", err)
+	}
+	if index != 0 {
+		t.Errorf("Expected barrier at the current commit index 0, got %d", index)
+	}
+	if got := readIndexRequests.Value(readIndexRound) - rounds; got != 1 {
+		t.Errorf("Expected one round for the first barrier, got %v", got)
+	}
+	if got := readIndexRequests.Value(readIndexCached) - cached; got != 1 {
+		t.Errorf("Expected the second barrier to use the confirmed round, got %v", got)
+	}
+
+	// without a window, every barrier has its own round
+	n.config.ReadIndexWindow = 0
+	n.ReadBarrier(context.Background())
+	if got := readIndexRequests.Value(readIndexRound) - rounds; got != 2 {
+		t.Errorf("Expected a round without a window, got %v", got-1)
+	}
+}
+
+func TestProposalBatching(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	if err := n.SetProposalBatching(ProposalBatching{MaxEntries: -1}); err != ErrInvalidBatching {
+		t.Errorf("Expected ErrInvalidBatching for negative limits, got %v", err)
+	}
+	err := n.SetProposalBatching(ProposalBatching{MaxDelayMicros: 1000000, MaxEntries: 4})
+	if err != nil {
+		t.Fatal("Error setting proposal batching:", err)
+	}
+	batches := proposalBatchSize.Count("entries")
+
+	// the batch fills up long before its delay passes
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	receipts := make([]*WriteReceipt, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		ctx, receipt := WithWriteReceipt(context.Background())
+		receipts[i] = receipt
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = n.Set(ctx, fmt.Sprintf("k%d", i), "v")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Error setting k%d: %v", i, err)
+		}
+	}
+	// each write is told the position of its own entry
+	for i, receipt := range receipts {
+		if entry := n.Log.Entries[receipt.Index]; entry.Key != fmt.Sprintf("k%d", i) || receipt.Term != n.Term {
+			t.Errorf("Expected k%d at index %d in term %d, got %q in term %d",
+				i, receipt.Index, receipt.Term, entry.Key, n.Term)
+		}
+	}
+	if got := proposalBatchSize.Count("entries") - batches; got != 1 {
+		t.Errorf("Expected the writes appended as 1 batch, got %d", got)
+	}
+	if len(n.Log.Entries) != 4 {
+		t.Errorf("Expected 4 entries, got %d", len(n.Log.Entries))
+	}
+	for i := 0; i < 4; i++ {
+		if got := n.Store.Get(fmt.Sprintf("k%d", i)); got != "v" {
+			t.Errorf("Expected k%d=v, got %q", i, got)
+		}
+	}
+
+	// a write in a batch fails on its own
+	n.SetProposalBatching(ProposalBatching{MaxDelayMicros: 1000000, MaxEntries: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error)
+	go func() { done <- n.Delete(ctx, "k0") }()
+	if err := n.Delete(context.Background(), "k1"); err != nil {
+		t.Errorf("Error deleting k1: %v", err)
+	}
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected context.Canceled for a cancelled write, got %v", err)
+	}
+	if n.Store.Get("k0") != "v" || n.Store.Get("k1") != "" {
+		t.Errorf("Expected only k1 deleted, got k0=%q k1=%q", n.Store.Get("k0"), n.Store.Get("k1"))
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	n := setupNode(t)
+	n.config.ApplyBatchSize = 2
+	n.Log = &raft.LogStore{
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_SET, Key: "a", Value: "1"},
+			{Term: 1, Action: raft.LogRecord_SET, Key: "b", Value: "2"},
+			{Term: 1, Action: raft.LogRecord_SET, Key: "c", Value: "3"},
+			{Term: 1, Action: raft.LogRecord_CUSTOM, Command: "unknown"},
+			{Term: 1, Action: raft.LogRecord_DEL, Key: "b"},
+			{Term: 1, Action: raft.LogRecord_TOUCH, Key: "c", ExpiresAt: 1}}}
+	var applied []int64
+	n.AddApplyHook(func(index int64, _ *raft.LogRecord, _ error) {
+		applied = append(applied, index)
+	})
+	batches := applyBatches.Value()
+	entries := applyBatchedEntries.Value()
+
+	n.CommitIndex = 5
+	n.applyThrough(n.CommitIndex)
+
+	if n.LastApplied != 5 {
+		t.Errorf("Expected entries applied through 5, got %d", n.LastApplied)
+	}
+	expected := []int64{0, 1, 2, 3, 4, 5}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("Expected hooks called for %v, got %v", expected, applied)
+	}
+	// [0, 1] and [4, 5] are batches, 2 is left over and 3 can't be batched
+	if got := applyBatches.Value() - batches; got != 2 {
+		t.Errorf("Expected 2 batches, got %v", got)
+	}
+	if got := applyBatchedEntries.Value() - entries; got != 4 {
+		t.Errorf("Expected 4 entries applied in batches, got %v", got)
+	}
+	for key, value := range map[string]string{"a": "1", "b": "", "c": ""} {
+		if got := n.Store.Get(key); got != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, got)
+		}
+	}
+}
+
+func TestPublishTopology(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.TopologyFile = filepath.Join(n.config.DataDir, "topology.json")
+	n.AddApplyHook(n.writeTopologyOn)
+	n.AddForeignNode("localhost:12345")
+	// the peer acknowledges, making a majority of the two members
+	n.peers.get("localhost:12345").Client = &toggleAppendClient{ack: 1}
+
+	if err := n.PublishTopology(context.Background()); err != nil {
+		t.Fatalf("PublishTopology failed: %v", err)
+	}
+	expected := Topology{
+		Leader:           "localhost:8080",
+		LeaderClientAddr: "localhost:16990",
+		Term:             n.Term,
+		Members:          []TopologyMember{{Id: "localhost:12345"}, {Id: "localhost:8080"}}}
+	topology, ok := n.Topology()
+	if !ok || !reflect.DeepEqual(topology, expected) {
+		t.Errorf("Expected topology %+v, got %+v", expected, topology)
+	}
+	data, err := ioutil.ReadFile(n.config.TopologyFile)
+	if err != nil {
+		t.Fatalf("Expected topology file: %v", err)
+	}
+	var written Topology
+	if err := json.Unmarshal(data, &written); err != nil || !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected topology file with %+v, got %s", expected, data)
+	}
+
+	// an unchanged topology is not published again
+	entries := len(n.Log.Entries)
+	n.PublishTopology(context.Background())
+	if len(n.Log.Entries) != entries {
+		t.Error("Expected no entry for an unchanged topology")
+	}
+
+	n.State = Follower
+	if err := n.PublishTopology(context.Background()); err != ErrNotLeaderRecv {
+		t.Errorf("Expected ErrNotLeaderRecv from a follower, got %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	n := setupNode(t)
+
+	var events []EventType
+	n.AddEventListener(func(e Event) {
+		events = append(events, e.Type)
+	})
+
+	host := "localhost:12345"
+	n.AddForeignNode(host)
+	// the peer does not exist, so it becomes unavailable (once)
+	n.requestVote(host)
+	n.requestVote(host)
+
+	leader := &raft.Node{Id: "localhost:8181", ClientAddr: "localhost:80"}
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term + 1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+
+	expected := []EventType{EventMemberAdded, EventPeerUnavailable, EventLeaderChange}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	n := setupNode(t)
+	n.config.CatchUpLag = 1
+
+	if n.CaughtUp() {
+		t.Error("Node should not be caught up before hearing from a leader")
+	}
+
+	leader := &raft.Node{Id: "localhost:8181", ClientAddr: "localhost:80"}
+	entries := []*raft.LogRecord{
+		{Term: 1, Action: raft.LogRecord_SET, Key: "a", Value: "1"},
+		{Term: 1, Action: raft.LogRecord_SET, Key: "b", Value: "2"},
+		{Term: 1, Action: raft.LogRecord_SET, Key: "c", Value: "3"}}
+
+	// leader has committed 3 entries, but this node is missing them, so the
+	// first append fails its consistency check
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: 2,
+		PrevLogTerm:  1,
+		LeaderCommit: 2})
+	if n.CaughtUp() {
+		t.Error("Node should not be caught up before applying any entries")
+	}
+
+	// applying 2 of 3 is within the allowed lag of the target seen at startup
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: 1,
+		Entries:      entries})
+	if !n.CaughtUp() {
+		t.Errorf("Node should be caught up at applied index %d", n.LastApplied)
+	}
+}
+
+func TestCompactLog(t *testing.T) {
+	n := setupNode(t)
+	leader := &raft.Node{
+		Id:         "localhost:8181",
+		ClientAddr: "localhost:80",
+	}
+	n.SetTerm(2, leader)
+
+	entries := []*raft.LogRecord{}
+	for i := 0; i < 5; i++ {
+		entries = append(entries, &raft.LogRecord{
+			Term:   int64(1 + i/3),
+			Action: raft.LogRecord_SET,
+			Key:    fmt.Sprintf("key%d", i),
+			Value:  "value"})
+	}
+	n.setLog(entries)
+	n.applyCommittedLogs(3)
+
+	// compaction is limited to entries that have been applied
+	dropped, err := n.CompactLog(10)
+	if err != nil {
+		t.Fatalf("Error compacting log: %v", err)
+	}
+	if dropped != 4 {
+		t.Errorf("Expected 4 entries dropped, got %d", dropped)
+	}
+	if n.Log.BaseIndex != 4 || len(n.Log.Entries) != 1 {
+		t.Errorf(
+			"Expected base index 4 with 1 entry, got %d with %d",
+			n.Log.BaseIndex, len(n.Log.Entries))
+	}
+	if n.LastLogIndex() != 4 {
+		t.Errorf("Expected last log index 4, got %d", n.LastLogIndex())
+	}
+	if term, ok := n.LogTerm(3); !ok || term != 2 {
+		t.Errorf("Expected term 2 for last compacted entry, got %d (%v)", term, ok)
+	}
+	if _, ok := n.LogTerm(1); ok {
+		t.Error("Expected term of compacted entry before base to be unknown")
+	}
+
+	reloaded := ReadLogs(n.config.LogFile)
+	if reloaded.BaseIndex != 4 || reloaded.BaseTerm != 2 || len(reloaded.Entries) != 1 {
+		t.Errorf("Compacted log not persisted correctly: %v", reloaded)
+	}
+
+	// appends continue from the absolute index, and resent compacted entries
+	// are skipped
+	req := &raft.AppendRequest{
+		Term:         2,
+		Leader:       leader,
+		PrevLogIndex: 2,
+		PrevLogTerm:  1,
+		LeaderCommit: 5,
+		Entries: []*raft.LogRecord{
+			entries[3],
+			entries[4],
+			{Term: 2, Action: raft.LogRecord_SET, Key: "key5", Value: "value"}}}
+	reply := n.HandleAppend(req)
+	if !reply.Success {
+		t.Fatal("Expected append success")
+	}
+	if n.LastLogIndex() != 5 || len(n.Log.Entries) != 2 {
+		t.Errorf(
+			"Expected last log index 5 with 2 entries, got %d with %d",
+			n.LastLogIndex(), len(n.Log.Entries))
+	}
+	if n.LastApplied != 5 {
+		t.Errorf("Expected applied index 5, got %d", n.LastApplied)
+	}
+	if v := n.Store.Get("key5"); v != "value" {
+		t.Errorf("Expected key5=value, got %s", v)
+	}
+}
+
+func TestIndexEntries(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	n.Set(context.Background(), "u1", `{"status": "active"}`)
+	if err := n.CreateIndex(context.Background(), "$.status"); err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	n.Set(context.Background(), "u2", `{"status": "active"}`)
+
+	keys, err := n.Store.Find("$.status", `"active"`)
+	if err != nil {
+		t.Fatalf("Error in Find: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"u1", "u2"}) {
+		t.Errorf("Expected [u1 u2], got %v", keys)
+	}
+
+	if err := n.DropIndex(context.Background(), "$.status"); err != nil {
+		t.Fatalf("Error dropping index: %v", err)
+	}
+	if len(n.Store.Indexes()) != 0 {
+		t.Errorf("Expected no indexes, got %v", n.Store.Indexes())
+	}
+	if err := n.CreateIndex(context.Background(), "status"); err != db.ErrInvalidPath {
+		t.Errorf("Expected ErrInvalidPath, got %v", err)
+	}
+}
+
+func TestSortedSetEntries(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	err := n.ZAdd(context.Background(), "board", []db.ScoredMember{
+		{Member: "alice", Score: 10},
+		{Member: "bob", Score: 5}})
+	if err != nil {
+		t.Fatalf("Error in ZAdd: %v", err)
+	}
+	if err := n.ZRem(context.Background(), "board", []string{"alice"}); err != nil {
+		t.Fatalf("Error in ZRem: %v", err)
+	}
+
+	members := n.Store.ZRangeByScore("board", 0, 100, 0)
+	expected := []db.ScoredMember{{Member: "bob", Score: 5}}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected %v, got %v", expected, members)
+	}
+
+	entry := n.Log.Entries[0]
+	if entry.Action != raft.LogRecord_ZADD || len(entry.Members) != 2 || len(entry.Scores) != 2 {
+		t.Errorf("Unexpected log entry for ZAdd: %v", entry)
+	}
+}
+
+func TestSetEntries(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	if err := n.SAdd(context.Background(), "team", []string{"alice", "bob"}); err != nil {
+		t.Fatalf("Error in SAdd: %v", err)
+	}
+	if err := n.SRem(context.Background(), "team", []string{"alice"}); err != nil {
+		t.Fatalf("Error in SRem: %v", err)
+	}
+	if members := n.Store.SMembers("team"); !reflect.DeepEqual(members, []string{"bob"}) {
+		t.Errorf("Expected [bob], got %v", members)
+	}
+}
+
+func TestTouchAndExpire(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	exists, err := n.Touch(context.Background(), "missing", time.Minute)
+	if err != nil || exists {
+		t.Errorf("Expected missing key to not exist, got %v (%v)", exists, err)
+	}
+	if len(n.Log.Entries) != 0 {
+		t.Errorf("Expected no log entry for missing key, got %d", len(n.Log.Entries))
+	}
+
+	n.Set(context.Background(), "session", "abc")
+	n.Set(context.Background(), "stale", "xyz")
+	if exists, err := n.Touch(context.Background(), "session", time.Minute); err != nil || !exists {
+		t.Errorf("Expected key to exist, got %v (%v)", exists, err)
+	}
+	n.Touch(context.Background(), "stale", -time.Second)
+
+	if err := n.ExpireKeys(context.Background()); err != nil {
+		t.Fatalf("Error expiring keys: %v", err)
+	}
+	if _, ok := n.Store.Expiry("stale"); ok || n.Store.Exists("stale") {
+		t.Error("Expected expired key to be removed")
+	}
+	if !n.Store.Exists("session") {
+		t.Error("Expected unexpired key to remain")
+	}
+
+	if exists, err := n.Persist(context.Background(), "session"); err != nil || !exists {
+		t.Errorf("Expected key to exist, got %v (%v)", exists, err)
+	}
+	if _, ok := n.Store.Expiry("session"); ok {
+		t.Error("Expected no expiry after persist")
+	}
+
+	n.State = Follower
+	if _, err := n.Touch(context.Background(), "session", time.Minute); err != ErrNotLeaderRecv {
+		t.Errorf("Expected ErrNotLeaderRecv on follower, got %v", err)
+	}
+}
+
+func TestSetChunked(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.ValueChunkSize = 4
+
+	n.Set(context.Background(), "small", "abcd")
+	if len(n.Log.Entries) != 1 || n.Log.Entries[0].Action != raft.LogRecord_SET {
+		t.Errorf("Expected a single SET entry for a small value, got %v", n.Log.Entries)
+	}
+
+	if err := n.Set(context.Background(), "big", "0123456789"); err != nil {
+		t.Fatalf("Error setting chunked value: %v", err)
+	}
+	if v := n.Store.Get("big"); v != "0123456789" {
+		t.Errorf("Expected reassembled value, got %q", v)
+	}
+	entries := n.Log.Entries[1:]
+	if len(entries) != 4 {
+		t.Fatalf("Expected 3 chunks and a commit entry, got %d entries", len(entries))
+	}
+	for _, entry := range entries[:3] {
+		if entry.Action != raft.LogRecord_CHUNK || len(entry.Data) > 4 {
+			t.Errorf("Unexpected chunk entry: %v", entry)
+		}
+	}
+	if entries[3].Action != raft.LogRecord_SET_CHUNKED || len(entries[3].Members) != 3 {
+		t.Errorf("Unexpected commit entry: %v", entries[3])
+	}
+}
+
+func TestProposalBudget(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.MaxProposalBytes = 10
+
+	// a write is admitted when nothing else is pending, even if over budget
+	if err := n.Set(context.Background(), "key", "a value over budget"); err != nil {
+		t.Errorf("Expected lone write to be admitted, got %v", err)
+	}
+	if pending := n.PendingProposalBytes(); pending != 0 {
+		t.Errorf("Expected budget to be released after write, %d pending", pending)
+	}
+
+	if err := n.admitProposal(context.Background(), 8); err != nil {
+		t.Fatalf("Expected proposal within budget to be admitted, got %v", err)
+	}
+	if err := n.Set(context.Background(), "key", "abc"); err != ErrProposalBudget {
+		t.Errorf("Expected ErrProposalBudget, got %v", err)
+	}
+	n.releaseProposal(8)
+	if err := n.Set(context.Background(), "key", "abc"); err != nil {
+		t.Errorf("Expected write to be admitted after release, got %v", err)
+	}
+}
+
+func TestVoteStepsDownLeader(t *testing.T) {
+	n := setupNode(t)
+	candidate := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+	request := &raft.VoteRequest{
+		Candidate:    candidate,
+		LastLogIndex: -1,
+		LastLogTerm:  0}
+
+	// in the grace period, the leader steps down without voting
+	n.State = Leader
+	n.SetTerm(2, n.RaftNode)
+	n.AllowVote = false
+	request.Term = 3
+	reply := n.HandleVote(request)
+	if reply.VoteGranted || reply.Term != 3 || n.State != Follower {
+		t.Errorf("Expected step down to follower in term 3 without a vote, got %v (%s)",
+			reply, n.State)
+	}
+	// the winner of the new term can then append
+	if !n.validateAppend(3, candidate.Id) {
+		t.Error("Expected append from new leader to be accepted")
+	}
+
+	// after the grace period, the leader steps down and votes
+	n.State = Leader
+	n.SetTerm(4, n.RaftNode)
+	n.AllowVote = true
+	request.Term = 5
+	reply = n.HandleVote(request)
+	if !reply.VoteGranted || reply.Term != 5 || n.State != Follower {
+		t.Errorf("Expected step down to follower in term 5 with a vote, got %v (%s)",
+			reply, n.State)
+	}
+}
+
+func TestAdvanceTermWithoutVote(t *testing.T) {
+	n := setupNode(t)
+	n.SetTerm(2, n.RaftNode)
+	candidate := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+	n.Log = &raft.LogStore{
+		Entries: []*raft.LogRecord{{Term: 2, Action: raft.LogRecord_SET, Key: "a"}}}
+	n.CommitIndex = 0
+
+	// a candidate that is behind can't get a vote, but its term is adopted
+	reply := n.HandleVote(&raft.VoteRequest{
+		Term:         3,
+		Candidate:    candidate,
+		LastLogIndex: -1,
+		LastLogTerm:  0})
+	if reply.VoteGranted || reply.Term != 3 {
+		t.Errorf("Expected term 3 without a vote, got %v", reply)
+	}
+	record := ReadTerm(n.config.TermFile)
+	if record.Term != 3 || record.VotedFor != nil {
+		t.Errorf("Expected persisted term 3 with no vote, got %v", record)
+	}
+
+	// having not voted, the node may still vote in that term
+	reply = n.HandleVote(&raft.VoteRequest{
+		Term:         3,
+		Candidate:    candidate,
+		LastLogIndex: 0,
+		LastLogTerm:  2})
+	if !reply.VoteGranted {
+		t.Errorf("Expected vote in term learned without voting, got %v", reply)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	if err := n.Drain(false); err != nil {
+		t.Fatalf("Error draining: %v", err)
+	}
+	if err := n.Set(context.Background(), "key", "value"); err != ErrDraining {
+		t.Errorf("Expected ErrDraining, got %v", err)
+	}
+	n.State = Follower
+	if n.DoElection() {
+		t.Error("Expected draining node not to stand for election")
+	}
+	n.Resume()
+	n.State = Leader
+	if err := n.Set(context.Background(), "key", "value"); err != nil {
+		t.Errorf("Expected write after resume to succeed, got %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+	n.Set(ctx, "key", "value")
+
+	n.SetReadOnly("")
+	err := n.Set(ctx, "key", "other")
+	var readOnly *ReadOnlyError
+	if !errors.As(err, &readOnly) || readOnly.Scope != ReadOnlyNode ||
+		readOnly.Reason != DefaultReadOnlyReason {
+		t.Errorf("Expected node ReadOnlyError, got %v", err)
+	}
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected error to match ErrReadOnly, got %v", err)
+	}
+	// the cluster can be made read-only while the node is
+	if err := n.SetClusterReadOnly(ctx, "backup check"); err != nil {
+		t.Fatalf("Error making cluster read-only: %v", err)
+	}
+	n.ClearReadOnly()
+	err = n.Delete(ctx, "key")
+	if !errors.As(err, &readOnly) || readOnly.Scope != ReadOnlyCluster ||
+		readOnly.Reason != "backup check" {
+		t.Errorf("Expected cluster ReadOnlyError, got %v", err)
+	}
+	if n.Store.Get("key") != "value" {
+		t.Error("Expected rejected writes to leave the database unchanged")
+	}
+
+	// cluster read-only mode is kept in snapshots
+	data, _ := db.BuildSnapshot(n.Store)
+	restored, err := db.InstallSnapshot(data)
+	if err != nil || restored.ReadOnly() != "backup check" {
+		t.Errorf("Expected read-only mode in snapshot, got %q (%v)", restored.ReadOnly(), err)
+	}
+
+	if err := n.ClearClusterReadOnly(ctx); err != nil {
+		t.Fatalf("Error clearing cluster read-only mode: %v", err)
+	}
+	if status := n.ReadOnly(); status.Node || status.Cluster {
+		t.Errorf("Expected writes to be enabled, got %+v", status)
+	}
+	if err := n.Set(ctx, "key", "other"); err != nil {
+		t.Errorf("Expected write to succeed, got %v", err)
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	n := setupNode(t)
+	config := n.config
+	writeState := func(term int64, log *raft.LogStore) {
+		os.Remove(config.TermFile)
+		if term >= 0 {
+			WriteTerm(config.TermFile, &raft.TermRecord{Term: term})
+		}
+		WriteLogs(config.LogFile, log)
+	}
+	log := func(base int64, terms ...int64) *raft.LogStore {
+		store := &raft.LogStore{BaseIndex: base, BaseTerm: 1}
+		for _, term := range terms {
+			store.Entries = append(store.Entries, &raft.LogRecord{Term: term})
+		}
+		return store
+	}
+	snapshot := &SnapshotMeta{File: "snapshot", HasManifest: true, LastApplied: 1, LastTerm: 2}
+
+	testCases := []struct {
+		name     string
+		term     int64 // -1 for no term file
+		log      *raft.LogStore
+		snapshot *SnapshotMeta
+		problem  string
+	}{
+		{"empty", -1, log(0), nil, ""},
+		{"consistent", 3, log(0, 1, 2, 3), nil, ""},
+		{"consistent with snapshot", 3, log(1, 2, 3), snapshot, ""},
+		{"no manifest", 3, log(1, 2, 3), &SnapshotMeta{File: "snapshot"}, ""},
+		{"missing term file", -1, log(0, 1, 2), nil, "term file"},
+		{"stale term file", 1, log(0, 1, 2), nil, "after term 1"},
+		{"terms out of order", 3, log(0, 2, 1), nil, "before the entry ahead of it"},
+		{"compacted without snapshot", 3, log(2, 3), nil, "no snapshot"},
+		{"snapshot behind log", 3, log(3, 3), snapshot, "only covers entries up to 1"},
+		{"snapshot ahead of log", 3, log(0), snapshot, "the log ends at -1"},
+		{"snapshot term mismatch", 3, log(0, 1, 1, 3), snapshot, "is from term 1"},
+		{"snapshot after term file", 1, log(2), snapshot, "covers term 2"},
+	}
+	for _, tc := range testCases {
+		writeState(tc.term, tc.log)
+		err := ValidateState(config, tc.snapshot)
+		if tc.problem == "" {
+			if err != nil {
+				t.Errorf("%s: expected no problems, got %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInconsistentState) || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("%s: expected problem with %q, got %v", tc.name, tc.problem, err)
+		}
+	}
+
+	writeState(3, log(0, 1))
+	other := config
+	other.ClusterId = "blue"
+	if _, err := NewNode(other, db.NewDatabase()); err != nil {
+		t.Fatalf("Error creating node: %v", err)
+	}
+	if err := ValidateState(other, nil); err != nil {
+		t.Errorf("Expected recorded cluster id to match, got %v", err)
+	}
+	other.ClusterId = "green"
+	if err := ValidateState(other, nil); !errors.Is(err, ErrInconsistentState) {
+		t.Errorf("Expected cluster id mismatch, got %v", err)
+	}
+}
+
+// progressAppendClient rejects appends after its commit index, reporting the
+// commit index and a version, and records the prevLogIndex of each request
+type progressAppendClient struct {
+	raft.RaftClient
+	commitIndex int64
+	prevIndexes []int64
+}
+
+func (c *progressAppendClient) AppendLogs(ctx context.Context, in *raft.AppendRequest, opts ...grpc.CallOption) (*raft.AppendReply, error) {
+	c.prevIndexes = append(c.prevIndexes, in.PrevLogIndex)
+	return &raft.AppendReply{
+		Term:        in.Term,
+		Success:     in.PrevLogIndex <= c.commitIndex,
+		CommitIndex: c.commitIndex,
+		LastApplied: c.commitIndex,
+		Version:     "v2"}, nil
+}
+
+func TestReplyProgress(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.Version = "v1"
+	for i := 0; i < 6; i++ {
+		n.Set(context.Background(), fmt.Sprintf("key%d", i), "value")
+	}
+
+	follower := setupNode(t)
+	follower.config.Version = "v1"
+	reply := follower.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       n.RaftNode,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		Entries:      n.Log.Entries[:3],
+		LeaderCommit: 1})
+	if !reply.Success || reply.CommitIndex != 1 || reply.LastApplied != 1 || reply.Version != "v1" {
+		t.Errorf("Expected reply to report commit index 1 and version, got %+v", reply)
+	}
+
+	client := &progressAppendClient{commitIndex: 1}
+	host := "progress-test:1"
+	n.peers.add(host, &ForeignNode{Client: client, MatchIndex: 5, Available: true})
+	if err := n.requestAppend(context.Background(), host, n.Term); err != nil {
+		t.Fatalf("Error sending append: %v", err)
+	}
+	if !reflect.DeepEqual(client.prevIndexes, []int64{5, 1}) {
+		t.Errorf("Expected retry from the peer's commit index, got requests after %v", client.prevIndexes)
+	}
+	peers := n.Peers()
+	if len(peers) != 1 || peers[0].Version != "v2" ||
+		peers[0].CommitIndex == nil || *peers[0].CommitIndex != 1 || peers[0].MatchIndex != 5 {
+		t.Errorf("Expected peer's reported progress and version, got %+v", peers)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		n.Set(ctx, fmt.Sprintf("key%d", i), "value")
+	}
+
+	entries, err := n.Entries(ctx, 1)
+	if err != nil {
+		t.Fatalf("Error streaming entries: %v", err)
+	}
+	receive := func() CommittedEntry {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				t.Fatal("Expected an entry, but the stream was closed")
+			}
+			return entry
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for an entry")
+		}
+		return CommittedEntry{}
+	}
+	// backfill from the log, then new entries as they are applied
+	for i := int64(1); i < 3; i++ {
+		if entry := receive(); entry.Index != i || entry.Key != fmt.Sprintf("key%d", i) {
+			t.Errorf("Expected entry %d, got %+v", i, entry.EntryInfo)
+		}
+	}
+	n.Delete(ctx, "key0")
+	if entry := receive(); entry.Index != 3 || entry.Action != "DEL" || entry.Record.Key != "key0" {
+		t.Errorf("Expected delete at entry 3, got %+v", entry.EntryInfo)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-entries:
+		if ok {
+			t.Error("Expected no more entries")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the stream to be closed once ctx is done")
+	}
+
+	n.CompactLog(2)
+	if _, err := n.Entries(context.Background(), 1); err != ErrEntriesCompacted {
+		t.Errorf("Expected %v streaming compacted entries, got %v", ErrEntriesCompacted, err)
+	}
+}
+
+func TestStaleness(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	if _, ok := n.Staleness(); ok {
+		t.Error("Expected staleness to be unknown before the leader has a lease")
+	}
+	n.Set(context.Background(), "key", "value")
+	if age, ok := n.Staleness(); !ok || age > time.Second {
+		t.Errorf("Expected the leader's lease to be current, got %v (%t)", age, ok)
+	}
+	if age := n.leaseAge(n.Term); age <= 0 {
+		t.Errorf("Expected append requests to carry the lease age, got %d", age)
+	}
+
+	follower := setupNode(t)
+	if _, ok := follower.Staleness(); ok {
+		t.Error("Expected staleness to be unknown before hearing from a leader")
+	}
+	leaseAge := int64(200 * time.Millisecond)
+	follower.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       n.RaftNode,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		Entries:      n.Log.Entries,
+		LeaderCommit: 0,
+		LeaseAge:     leaseAge})
+	age, ok := follower.Staleness()
+	if !ok || age < time.Duration(leaseAge) || age > time.Second {
+		t.Errorf("Expected follower to be as stale as the leader's lease, got %v (%t)", age, ok)
+	}
+}
+
+func TestHandleTimeoutNow(t *testing.T) {
+	n := setupNode(t)
+	n.config.Witness = true
+	leader := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+
+	other := &raft.Node{Id: "localhost:16998", ClientAddr: "localhost:8088"}
+	if reply := n.HandleTimeoutNow(&raft.TimeoutNowRequest{Term: 1, Leader: other}); reply.Accepted {
+		t.Error("Expected request from a node that is not the leader to be refused")
+	}
+	if reply := n.HandleTimeoutNow(&raft.TimeoutNowRequest{Term: 1, Leader: leader}); reply.Accepted {
+		t.Error("Expected witness to refuse to stand for election")
+	}
+}
+
+func TestWriteCancelled(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := n.Set(ctx, "key", "value"); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if len(n.Log.Entries) != 0 {
+		t.Errorf("Expected no entry for a cancelled write, got %v", n.Log.Entries)
+	}
+
+	// an append cancelled by the caller says nothing about the peer
+	host := "localhost:12345"
+	n.AddForeignNode(host)
+	if err := n.requestAppend(ctx, host, n.Term); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if !n.peers.get(host).Available {
+		t.Error("Cancelled append should not mark the peer unavailable")
+	}
+	n.AddForeignNode("localhost:23456")
+	if err := n.SendAppend(ctx, DefaultAppendRetry, n.Term); err != context.Canceled {
+		t.Errorf("Expected SendAppend to stop retrying with %v, got %v", context.Canceled, err)
+	}
+}
+
+// fakeAppendClient acknowledges append requests once release is closed
+type fakeAppendClient struct {
+	raft.RaftClient
+	release chan struct{}
+}
+
+func (f *fakeAppendClient) AppendLogs(
+	ctx context.Context,
+	in *raft.AppendRequest,
+	opts ...grpc.CallOption) (*raft.AppendReply, error) {
+
+	<-f.release
+	return &raft.AppendReply{Term: in.Term, Success: true}, nil
+}
+
+func TestSendAppendFastestMajority(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.Log = &raft.LogStore{Entries: []*raft.LogRecord{
+		{Term: n.Term, Action: raft.LogRecord_SET, Key: "a", Value: "1"}}}
+
+	fast := make(chan struct{})
+	close(fast)
+	slow := make(chan struct{})
+	for i, release := range []chan struct{}{fast, fast, slow, slow} {
+		host := fmt.Sprintf("quorum-test:%d", i)
+		n.peers.add(host, &ForeignNode{
+			Client:    &fakeAppendClient{release: release},
+			Available: true})
+	}
+
+	// with 4 peers, the leader and the 2 fast peers are a majority
+	if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != nil {
+		t.Fatalf("Error in append: %v", err)
+	}
+	if n.CommitIndex != 0 {
+		t.Errorf("Expected entry committed without the slow peers, commit index %d", n.CommitIndex)
+	}
+	if appendQuorum.Value("quorum-test:0") != 1 || appendQuorum.Value("quorum-test:2") != 0 {
+		t.Errorf("Expected only fast peers counted toward quorum")
+	}
+
+	close(slow)
+	deadline := time.Now().Add(time.Second)
+	for appendLate.Value("quorum-test:2")+appendLate.Value("quorum-test:3") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected slow peers to acknowledge late")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// slowVoteClient never replies to the first vote request it receives, and
+// grants the rest
+type slowVoteClient struct {
+	raft.RaftClient
+	calls int32
+}
+
+func (f *slowVoteClient) RequestVote(
+	ctx context.Context,
+	in *raft.VoteRequest,
+	opts ...grpc.CallOption) (*raft.VoteReply, error) {
+
+	if atomic.AddInt32(&f.calls, 1) == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &raft.VoteReply{Term: in.Term, VoteGranted: true}, nil
+}
+
+func TestHedgedVote(t *testing.T) {
+	n := setupNode(t)
+	host := "hedge-test:1"
+	client := &slowVoteClient{}
+	n.peers.add(host, &ForeignNode{Client: client, Available: true})
+
+	// without hedging, the lost request fails the vote
+	if _, err := n.requestVote(host); err == nil {
+		t.Error("Expected unhedged vote request to time out")
+	}
+
+	n.config.HedgeDelay = time.Millisecond
+	n.hedges = newHedgeBudget(100)
+	atomic.StoreInt32(&client.calls, 0)
+	vote, err := n.requestVote(host)
+	if err != nil || !vote.VoteGranted {
+		t.Fatalf("Expected hedged vote request to be granted, got %v, %v", vote, err)
+	}
+	if hedgeWins.Value(hedgeVote) < 1 {
+		t.Error("Expected the hedged request to be counted")
+	}
+
+	// the budget caps how many requests are hedged
+	atomic.StoreInt32(&client.calls, 0)
+	n.hedges = newHedgeBudget(0)
+	if _, err := n.requestVote(host); err == nil {
+		t.Error("Expected no hedge once the budget is spent")
+	}
+}
+
+func TestCatchUpThrottle(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	value := strings.Repeat("v", 100)
+	n.Log = &raft.LogStore{}
+	for i := 0; i < 4; i++ {
+		n.Log.Entries = append(n.Log.Entries, &raft.LogRecord{
+			Term: n.Term, Action: raft.LogRecord_SET, Key: fmt.Sprint(i), Value: value})
+	}
+	n.CommitIndex = 2
+	size := int64(proto.Size(n.Log.Entries[0]))
+
+	host := "catchup-test:1"
+	n.peers.add(host, &ForeignNode{
+		MatchIndex: -1,
+		Available:  true,
+		catchUp:    ratelimit.New(size * 3 / 2)})
+
+	// the budget allows one committed entry, and then nothing until it refills
+	req, err := n.appendRequest(host, n.Term)
+	if err != nil || len(req.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %v (%v)", req, err)
+	}
+	req, _ = n.appendRequest(host, n.Term)
+	if len(req.Entries) != 0 {
+		t.Errorf("Expected an empty heartbeat while throttled, got %d entries", len(req.Entries))
+	}
+
+	// entries after the commit index are not throttled
+	n.peers.get(host).MatchIndex = 2
+	req, _ = n.appendRequest(host, n.Term)
+	if len(req.Entries) != 1 || req.Entries[0].Key != "3" {
+		t.Errorf("Expected the uncommitted entry, got %v", req.Entries)
+	}
+}
+
+func TestWriteConcern(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	if _, err := ParseWriteConcern("eventually"); err != ErrInvalidWriteConcern {
+		t.Errorf("Expected %v, got %v", ErrInvalidWriteConcern, err)
+	}
+	if concern, _ := ParseWriteConcern(""); concern != WriteConcernMajority {
+		t.Errorf("Expected default of %s, got %s", WriteConcernMajority, concern)
+	}
+
+	// a write acknowledged on the leader is in the log, but not yet committed
+	ctx := WithWriteConcern(context.Background(), WriteConcernLeader)
+	if err := n.Set(ctx, "fast", "value"); err != nil {
+		t.Fatalf("Error in leader-acknowledged write: %v", err)
+	}
+	if len(n.Log.Entries) != 1 || n.CommitIndex != -1 {
+		t.Errorf("Expected uncommitted entry, got %d entries and commit index %d",
+			len(n.Log.Entries), n.CommitIndex)
+	}
+	// ...until the next round of appends
+	n.SendAppend(context.Background(), retry.Once, n.Term)
+	if v := n.Store.Get("fast"); v != "value" {
+		t.Errorf("Expected write to be applied after append, got %q", v)
+	}
+
+	ctx = WithWriteConcern(context.Background(), WriteConcernApply)
+	if err := n.Set(ctx, "safe", "value"); err != nil {
+		t.Fatalf("Error in apply-acknowledged write: %v", err)
+	}
+	if v := n.Store.Get("safe"); v != "value" {
+		t.Errorf("Expected write to be applied, got %q", v)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.Set(context.Background(), "a", "1")
+
+	stale := "0"
+	ops := []db.BatchOp{
+		{Op: db.OpSet, Key: "b", Value: "x"},
+		{Op: db.OpCAS, Key: "a", Expected: &stale, Value: "2"},
+	}
+	applied, err := n.Batch(context.Background(), ops, true)
+	if err != ErrBatchConflict {
+		t.Errorf("Expected %v, got %v", ErrBatchConflict, err)
+	}
+	if len(applied) != 2 || applied[0] || n.Store.Exists("b") {
+		t.Errorf("Expected nothing applied, got %v", applied)
+	}
+
+	applied, err = n.Batch(context.Background(), ops, false)
+	if err != nil {
+		t.Fatalf("Error in best-effort batch: %v", err)
+	}
+	if !reflect.DeepEqual(applied, []bool{true, false}) {
+		t.Errorf("Expected only the set applied, got %v", applied)
+	}
+	if n.Store.Get("b") != "x" || n.Store.Get("a") != "1" {
+		t.Errorf("Unexpected values: a=%q b=%q", n.Store.Get("a"), n.Store.Get("b"))
+	}
+
+	if _, err := n.Batch(context.Background(), nil, true); err != db.ErrInvalidBatch {
+		t.Errorf("Expected %v for empty batch, got %v", db.ErrInvalidBatch, err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+	n.Set(ctx, "a", "1")
+
+	one := "1"
+	compares := []db.Compare{{Key: "a", Value: &one}, {Key: "lock"}}
+	then := []db.BatchOp{{Op: db.OpSet, Key: "lock", Value: "me"}, {Op: db.OpDelete, Key: "a"}}
+	otherwise := []db.BatchOp{{Op: db.OpSet, Key: "failed", Value: "yes"}}
+	succeeded, err := n.Txn(ctx, compares, then, otherwise)
+	if err != nil || succeeded == nil || !*succeeded {
+		t.Fatalf("Expected conditions to hold, got %v (%v)", succeeded, err)
+	}
+	if n.Store.Get("lock") != "me" || n.Store.Exists("a") || n.Store.Exists("failed") {
+		t.Error("Expected only the then operations to be applied")
+	}
+
+	// the lock is now held, so the else operations are applied instead
+	succeeded, err = n.Txn(ctx, compares, then, otherwise)
+	if err != nil || succeeded == nil || *succeeded {
+		t.Fatalf("Expected conditions not to hold, got %v (%v)", succeeded, err)
+	}
+	if n.Store.Get("failed") != "yes" {
+		t.Error("Expected the else operations to be applied")
+	}
+
+	cas := []db.BatchOp{{Op: db.OpCAS, Key: "a", Value: "2"}}
+	if _, err := n.Txn(ctx, nil, cas, nil); err != db.ErrInvalidTxn {
+		t.Errorf("Expected %v for cas in a transaction, got %v", db.ErrInvalidTxn, err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+
+	// without a restore window, deletes are final
+	n.Set(ctx, "a", "1")
+	n.Delete(ctx, "a")
+	if _, ok := n.Trashed("a"); ok {
+		t.Error("Expected nothing in the trash without a restore window")
+	}
+
+	n.config.TrashTTL = time.Hour
+	n.Set(ctx, "a", "1")
+	if err := n.Delete(ctx, "a"); err != nil {
+		t.Fatal("Error deleting a:", err)
+	}
+	trashed, ok := n.Trashed("a")
+	if !ok || trashed.Value != "1" || n.Store.Exists("a") {
+		t.Fatalf("Expected a's value in the trash, got %+v (%v)", trashed, ok)
+	}
+	if trash := n.Trash(); len(trash) != 1 || trash[0].Key != "a" {
+		t.Errorf("Expected the trash to hold a, got %+v", trash)
+	}
+
+	n.Set(ctx, "a", "2")
+	if err := n.RestoreKey(ctx, "a"); err != ErrKeyExists {
+		t.Errorf("Expected ErrKeyExists restoring over a new value, got %v", err)
+	}
+	n.Store.Delete("a")
+	if err := n.RestoreKey(ctx, "a"); err != nil {
+		t.Fatal("Error restoring a:", err)
+	}
+	if got := n.Store.Get("a"); got != "1" {
+		t.Errorf("Expected a=1 once restored, got %q", got)
+	}
+	if err := n.RestoreKey(ctx, "a"); err != ErrNotInTrash {
+		t.Errorf("Expected ErrNotInTrash restoring twice, got %v", err)
+	}
+	if err := n.RestoreKey(ctx, TrashKey("a")); err != ErrSystemKey {
+		t.Errorf("Expected ErrSystemKey restoring a system key, got %v", err)
+	}
+}
+
+func TestRenameAndCopy(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+	n.Set(ctx, "a", "1")
+	n.Touch(ctx, "a", time.Hour)
+	expiresAt, _ := n.Store.Expiry("a")
+	if err := n.Rename(ctx, "a", "b", false); err != nil {
+		t.Fatal("Error renaming a:", err)
+	}
+	if n.Store.Exists("a") || n.Store.Get("b") != "1" {
+		t.Errorf("Expected only b=1, got a=%q b=%q", n.Store.Get("a"), n.Store.Get("b"))
+	}
+	if at, ok := n.Store.Expiry("b"); !ok || at != expiresAt {
+		t.Errorf("Expected b to keep a's expiry, got %d (%v)", at, ok)
+	}
+	if err := n.Rename(ctx, "a", "b", false); err != ErrNoSuchKey {
+		t.Errorf("Expected ErrNoSuchKey renaming a missing key, got %v", err)
+	}
+
+	if err := n.Copy(ctx, "b", "c", false); err != nil {
+		t.Fatal("Error copying b:", err)
+	}
+	n.Set(ctx, "b", "2")
+	if err := n.Copy(ctx, "b", "c", false); err != ErrKeyExists {
+		t.Errorf("Expected ErrKeyExists copying over c, got %v", err)
+	}
+	if err := n.Copy(ctx, "b", "c", true); err != nil || n.Store.Get("c") != "2" {
+		t.Errorf("Expected c replaced with 2, got %q (%v)", n.Store.Get("c"), err)
+	}
+
+	for _, keys := range [][2]string{{"b", SystemKeyPrefix + "x"}, {SystemKeyPrefix + "x", "d"}} {
+		if err := n.Copy(ctx, keys[0], keys[1], false); err != ErrSystemKey {
+			t.Errorf("Expected ErrSystemKey copying %s to %s, got %v", keys[0], keys[1], err)
+		}
+	}
+	if err := n.Rename(ctx, "", "d", false); err != ErrInvalidRename {
+		t.Errorf("Expected ErrInvalidRename without a key to read, got %v", err)
+	}
+}
+
+func TestAdvisoryLocks(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+
+	lock, err := n.AcquireLock(ctx, "report", "worker-1", time.Minute)
+	if err != nil || lock.Name != "report" || lock.Owner != "worker-1" || lock.LeaseMs != 60000 {
+		t.Fatalf("Expected worker-1 to take the lock, got %+v (%v)", lock, err)
+	}
+
+	// even with the leader write concern, the outcome is reported
+	leaderCtx := WithWriteConcern(ctx, WriteConcernLeader)
+	_, err = n.AcquireLock(leaderCtx, "report", "worker-2", time.Minute)
+	var held *LockHeldError
+	if !errors.As(err, &held) || held.Lock.Owner != "worker-1" {
+		t.Fatalf("Expected a LockHeldError naming worker-1, got %v", err)
+	}
+	if !errors.Is(err, ErrLockHeld) {
+		t.Errorf("Expected the error to match %v", ErrLockHeld)
+	}
+
+	renewed, err := n.AcquireLock(ctx, "report", "worker-1", 2*time.Minute)
+	if err != nil || renewed.AcquiredAt != lock.AcquiredAt || renewed.ExpiresAt <= lock.ExpiresAt {
+		t.Errorf("Expected a renewal keeping the acquisition time, got %+v (%v)", renewed, err)
+	}
+	if locks := n.Locks(); len(locks) != 1 || locks[0].Name != "report" {
+		t.Errorf("Expected the report lock to be listed, got %+v", locks)
+	}
+
+	// clients can't write lock keys directly
+	if err := n.Set(ctx, lockKey("report"), "mine"); err != ErrSystemKey {
+		t.Errorf("Expected %v writing a lock key, got %v", ErrSystemKey, err)
+	}
+
+	if released, err := n.ReleaseLock(ctx, "report", "worker-2"); err != nil || released {
+		t.Errorf("Expected worker-2 not to release the lock, got %v (%v)", released, err)
+	}
+	if released, err := n.ReleaseLock(ctx, "report", "worker-1"); err != nil || !released {
+		t.Errorf("Expected worker-1 to release the lock, got %v (%v)", released, err)
+	}
+	if _, ok := n.GetLock("report"); ok {
+		t.Error("Expected the lock to be free")
+	}
+	if _, err := n.AcquireLock(ctx, "report", "worker-2", 0); err != ErrInvalidLock {
+		t.Errorf("Expected %v for a lock without a lease, got %v", ErrInvalidLock, err)
+	}
+}
+
+func TestWritePhaseMetrics(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	before := map[string]uint64{}
+	phases := []string{"queue", "persist", "commit", "apply"}
+	for _, phase := range phases {
+		before[phase] = writePhaseSeconds.Count(phase)
+	}
+	if err := n.Set(context.Background(), "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	for _, phase := range phases {
+		if writePhaseSeconds.Count(phase) != before[phase]+1 {
+			t.Errorf("Expected one %s observation for the write", phase)
+		}
+	}
+}
+
+func TestPingPeers(t *testing.T) {
+	n := setupNode(t)
+	host := "localhost:12345"
+	n.AddForeignNode(host)
+
+	// nothing is listening at the peer's address, so the ping fails
+	n.PingPeers()
+	if n.peers.get(host).Available {
+		t.Error("Expected failed ping to mark the peer unavailable")
+	}
+
+	reply := n.HandlePing(&raft.PingRequest{Node: &raft.Node{Id: host}})
+	if reply.Term != n.Term || reply.Node.Id != n.RaftNode.Id {
+		t.Errorf("Unexpected ping reply: %v", reply)
+	}
+}
+
+// setupNodeDir configures a Node for test like setupNode, but in a directory
+// of its own, for tests that use more than one Node
+func setupNodeDir(t *testing.T) *Node {
+	testDir, err := ioutil.TempDir("", "leifdb")
+	if err != nil {
+		t.Fatalf("Error creating test dir: %v", err)
+	}
+	t.Cleanup(func() {
+		util.RemoveTmpDir(testDir)
+	})
+	config := NewNodeConfig(testDir, "localhost:8080", "localhost:16990", []string{})
+	n, _ := NewNode(config, db.NewDatabase())
+	n.CheckForeignNode = checkForeignNodeMock
+	return n
+}
+
+func TestQuarantineOnDivergence(t *testing.T) {
+	leaderNode := setupNode(t)
+	leaderNode.State = Leader
+	leaderNode.Set(context.Background(), "a", "1")
+	leaderNode.Set(context.Background(), "b", "2")
+	hashIndex, hash := leaderNode.AppliedHash()
+	if hashIndex != 1 || hash == 0 {
+		t.Fatalf("Expected leader's applied hash as of entry 1, got %d at %d", hash, hashIndex)
+	}
+
+	leader := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+	appendFrom := func(n *Node, entries []*raft.LogRecord, commit int64) {
+		n.HandleAppend(&raft.AppendRequest{
+			Term:         1,
+			Leader:       leader,
+			PrevLogIndex: -1,
+			PrevLogTerm:  -1,
+			LeaderCommit: commit,
+			Entries:      entries,
+			HashIndex:    hashIndex,
+			AppliedHash:  hash})
+	}
+
+	matching := setupNodeDir(t)
+	appendFrom(matching, leaderNode.Log.Entries, 1)
+	if matching.Quarantined() {
+		t.Errorf("Expected follower with the same entries not to be quarantined: %s",
+			matching.QuarantineReason())
+	}
+
+	// a leader that has applied nothing sends the hash of the empty store
+	fresh := setupNodeDir(t)
+	fresh.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1,
+		HashIndex:    -1,
+		AppliedHash:  emptyHash()})
+	if fresh.Quarantined() {
+		t.Errorf("Expected no check before any entry is applied: %s", fresh.QuarantineReason())
+	}
+
+	// the leader's hash arrives before the follower applies the entry it
+	// covers, and is checked once it does
+	divergent := setupNodeDir(t)
+	entries := []*raft.LogRecord{
+		proto.Clone(leaderNode.Log.Entries[0]).(*raft.LogRecord),
+		proto.Clone(leaderNode.Log.Entries[1]).(*raft.LogRecord)}
+	entries[1].Value = "corrupt"
+	appendFrom(divergent, entries, 0)
+	if divergent.Quarantined() {
+		t.Fatal("Expected no check before the entry is applied")
+	}
+	appendFrom(divergent, entries, 1)
+	if !divergent.Quarantined() {
+		t.Fatal("Expected follower with a different entry to be quarantined")
+	}
+
+	reply := divergent.HandleVote(&raft.VoteRequest{
+		Term:         2,
+		Candidate:    leader,
+		LastLogIndex: 1,
+		LastLogTerm:  1})
+	if reply.VoteGranted || divergent.Term != 2 {
+		t.Errorf("Expected quarantined node to refuse its vote but advance its term, got %v", reply)
+	}
+	if divergent.DoElection() {
+		t.Error("Expected quarantined node not to stand for election")
+	}
+	restarted, _ := NewNode(divergent.config, db.NewDatabase())
+	if !restarted.Quarantined() {
+		t.Error("Expected quarantine to persist through a restart")
+	}
+
+	store := db.NewDatabase()
+	store.Set("a", "1")
+	store.Set("b", "2")
+	if err := divergent.Reseed(store, hashIndex, 1, hash); err != nil {
+		t.Fatalf("Error reseeding: %v", err)
+	}
+	if divergent.Quarantined() || divergent.Store.Get("b") != "2" {
+		t.Error("Expected reseed to replace the database and lift the quarantine")
+	}
+	if divergent.Log.BaseIndex != 2 || divergent.LastApplied != 1 || divergent.CaughtUp() {
+		t.Errorf("Expected empty log after entry 1, not caught up, got base %d applied %d",
+			divergent.Log.BaseIndex, divergent.LastApplied)
+	}
+	if _, h := divergent.AppliedHash(); h != hash {
+		t.Errorf("Expected applied hash %d after reseed, got %d", hash, h)
+	}
+	if restarted, _ := NewNode(divergent.config, db.NewDatabase()); restarted.Quarantined() {
+		t.Error("Expected reseed to remove the persisted quarantine")
+	}
+	if err := leaderNode.Reseed(db.NewDatabase(), 1, 1, hash); err != ErrReseedLeader {
+		t.Errorf("Expected ErrReseedLeader, got %v", err)
+	}
+}
+
+func TestQuarantineOnCorruptLog(t *testing.T) {
+	n := setupNode(t)
+	if err := ioutil.WriteFile(n.config.LogFile, []byte("not a log"), 0644); err != nil {
+		t.Fatalf("Error writing log file: %v", err)
+	}
+	restarted, _ := NewNode(n.config, db.NewDatabase())
+	if !restarted.Quarantined() {
+		t.Error("Expected node with a corrupt log to be quarantined")
+	}
+}
+
+func TestPersistFailure(t *testing.T) {
+	n := setupNode(t)
+	n.SetTerm(2, n.RaftNode)
+	leader := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+	n.Log = &raft.LogStore{
+		Entries: []*raft.LogRecord{{Term: 2, Action: raft.LogRecord_SET, Key: "a"}}}
+
+	// files in a directory that does not exist can't be written
+	missing := n.config.DataDir + "/missing"
+	n.config.TermFile = missing + "/term"
+	n.config.LogFile = missing + "/raftlog"
+
+	err := n.SetTerm(3, nil)
+	var persistErr *PersistError
+	if !errors.Is(err, ErrPersist) || !errors.As(err, &persistErr) || persistErr.Op != "stat" {
+		t.Errorf("Expected PersistError from stat, got %v", err)
+	}
+	if n.Term != 2 || n.votedFor == nil || n.votedFor.Id != n.RaftNode.Id {
+		t.Errorf("Expected term and vote to be unchanged, got %d (%v)", n.Term, n.votedFor)
+	}
+
+	// a vote that can't be persisted is not granted
+	reply := n.HandleVote(&raft.VoteRequest{
+		Term:         3,
+		Candidate:    leader,
+		LastLogIndex: 0,
+		LastLogTerm:  2})
+	if reply.VoteGranted || reply.Term != 2 {
+		t.Errorf("Expected no vote in term 2, got %v", reply)
+	}
+
+	// entries that can't be persisted are not acknowledged, or added to the log
+	appendReply := n.HandleAppend(&raft.AppendRequest{
+		Term:         2,
+		Leader:       leader,
+		PrevLogIndex: 0,
+		PrevLogTerm:  2,
+		Entries:      []*raft.LogRecord{{Term: 2, Action: raft.LogRecord_SET, Key: "b"}},
+		LeaderCommit: 1})
+	if appendReply.Success {
+		t.Error("Expected append to fail")
+	}
+	if n.LastLogIndex() != 0 || n.CommitIndex > 0 {
+		t.Errorf("Expected log to be unchanged, got last index %d, commit %d",
+			n.LastLogIndex(), n.CommitIndex)
+	}
+
+	if n.DoElection() {
+		t.Error("Expected election to fail without a persisted term")
+	}
+	if n.Term != 2 {
+		t.Errorf("Expected term to be unchanged, got %d", n.Term)
+	}
+}
+
+func TestPeerSet(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	host := "peer-test:1"
+	peer := &ForeignNode{
+		Client:     &fakeAppendClient{release: make(chan struct{})},
+		MatchIndex: -1,
+		Available:  true}
+	if !n.peers.add(host, peer) {
+		t.Fatal("Expected peer to be added")
+	}
+	if n.peers.add(host, &ForeignNode{}) || n.peers.get(host) != peer {
+		t.Error("Expected adding a known peer to leave it unchanged")
+	}
+
+	// an append blocked on the peer is abandoned when the peer is removed
+	result := make(chan error, 1)
+	go func() {
+		result <- n.sendLimitedAppend(context.Background(), host, n.Term)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if removed := n.peers.remove(host); removed != peer {
+		t.Errorf("Expected removed peer to be returned, got %v", removed)
+	}
+	select {
+	case err := <-result:
+		if err != ErrPeerRemoved && err != context.DeadlineExceeded {
+			t.Errorf("Expected append to be abandoned, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected append to return once the peer was removed")
+	}
+
+	if err := n.sendLimitedAppend(context.Background(), host, n.Term); err != ErrPeerRemoved {
+		t.Errorf("Expected %v for a removed peer, got %v", ErrPeerRemoved, err)
+	}
+	if _, err := n.requestVote(host); err != ErrPeerRemoved {
+		t.Errorf("Expected %v for a removed peer, got %v", ErrPeerRemoved, err)
+	}
+}
+
+func TestMaybeAdvanceMatch(t *testing.T) {
+	peer := &ForeignNode{MatchIndex: -1}
+	// replies to requests sent in parallel arrive out of order: the reply to
+	// the shorter request, arriving last, leaves the match index where it is
+	peer.maybeAdvanceMatch(3)
+	peer.maybeAdvanceMatch(1)
+	if peer.MatchIndex != 3 || peer.NextIndex != 4 {
+		t.Errorf("Expected match 3 and next 4, got %d and %d", peer.MatchIndex, peer.NextIndex)
+	}
+	// a rejection still rewinds it
+	peer.setMatchIndex(1)
+	if peer.MatchIndex != 1 || peer.NextIndex != 2 {
+		t.Errorf("Expected match 1 and next 2 after rewinding, got %d and %d", peer.MatchIndex, peer.NextIndex)
+	}
+}
+
+func TestRemoveForeignNode(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	var removed []string
+	n.AddEventListener(func(e Event) {
+		if e.Type == EventMemberRemoved {
+			removed = append(removed, e.Node)
+		}
+	})
+	released := make(chan struct{})
+	close(released)
+	newPeer := func() *ForeignNode {
+		return &ForeignNode{
+			Client:     &fakeAppendClient{release: released},
+			MatchIndex: -1,
+			Available:  true}
+	}
+	host := "remove-test:0"
+	n.peers.add(host, newPeer())
+
+	// membership changes while appends are being sent
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			n.SendAppend(context.Background(), retry.Once, n.Term)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		churn := fmt.Sprintf("remove-test:%d", i%3+1)
+		n.peers.add(churn, newPeer())
+		if err := n.RemoveForeignNode(churn); err != nil {
+			t.Errorf("Error removing %s: %v", churn, err)
+		}
+	}
+	<-done
+
+	old := n.peers.get(host)
+	if err := n.RemoveForeignNode(host); err != nil {
+		t.Fatalf("Error removing %s: %v", host, err)
+	}
+	if err := n.RemoveForeignNode(host); err != ErrPeerRemoved {
+		t.Errorf("Expected %v removing an unknown peer, got %v", ErrPeerRemoved, err)
+	}
+	if len(removed) != 51 || removed[50] != host {
+		t.Errorf("Expected an event for each removal, got %d", len(removed))
+	}
+
+	// a peer added again at the same address does not inherit the removed
+	// peer's replication state
+	n.peers.add(host, newPeer())
+	if n.peers.get(host) == old {
+		t.Errorf("Expected re-added peer to start from scratch")
+	}
+	if _, total := n.availability(); total != 2 {
+		t.Errorf("Expected 1 peer, got %d", total-1)
+	}
+}
+
+// unknownVoteClient denies every vote request, as from a member that does not
+// recognize the candidate
+type unknownVoteClient struct {
+	raft.RaftClient
+	calls int32
+}
+
+func (f *unknownVoteClient) RequestVote(
+	ctx context.Context,
+	in *raft.VoteRequest,
+	opts ...grpc.CallOption) (*raft.VoteReply, error) {
+
+	atomic.AddInt32(&f.calls, 1)
+	return &raft.VoteReply{
+		Term:       in.Term,
+		DenyReason: raft.VoteReply_UNKNOWN_NODE}, nil
+}
+
+func TestUnknownNodeBackoff(t *testing.T) {
+	n := setupNode(t)
+	client := &unknownVoteClient{}
+	n.peers.add("unknown-test:1", &ForeignNode{Client: client, Available: true})
+	n.peers.add("unknown-test:2", &ForeignNode{Client: client, Available: true})
+
+	if n.DoElection() {
+		t.Fatal("Expected election to fail")
+	}
+	term := n.Term
+
+	// the next election is not started until the backoff has passed
+	if n.DoElection() {
+		t.Error("Expected election to be held off")
+	}
+	if n.Term != term || atomic.LoadInt32(&client.calls) != 2 {
+		t.Errorf("Expected no new election, term %d, %d vote requests", n.Term, client.calls)
+	}
+
+	n.electionsAfter = time.Time{}
+	n.DoElection()
+	if n.Term != term+1 {
+		t.Errorf("Expected an election once the backoff has passed, term %d", n.Term)
+	}
+}
+
+func TestElectionBackoff(t *testing.T) {
+	n := setupNode(t)
+	n.backoff = newElectionBackoff(n.RaftNode.Id, 10*time.Millisecond, 50*time.Millisecond)
+	if d := n.ElectionBackoff(); d != 0 {
+		t.Errorf("Expected no backoff before a failed election, got %s", d)
+	}
+
+	// the window doubles with each failed election, up to the maximum
+	client := &unknownVoteClient{}
+	n.peers.add("backoff-test:1", &ForeignNode{Client: client, Available: true})
+	n.config.UnknownNodeBackoff = 0
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range expected {
+		if n.DoElection() {
+			t.Fatal("Expected election to fail")
+		}
+		if got := n.backoff.window(); got != w*time.Millisecond {
+			t.Errorf("Expected window %dms after %d failures, got %s", w, i+1, got)
+		}
+		if d := n.ElectionBackoff(); d < 0 || d >= w*time.Millisecond {
+			t.Errorf("Expected backoff within %dms, got %s", w, d)
+		}
+	}
+
+	// hearing from a leader resets the backoff
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       &raft.Node{Id: "backoff-test:1"},
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+	if w := n.backoff.window(); w != 0 {
+		t.Errorf("Expected backoff reset by an append from the leader, got %s", w)
+	}
+}
+
+// toggleAppendClient rejects append requests until ack is set
+type toggleAppendClient struct {
+	raft.RaftClient
+	ack int32
+}
+
+func (f *toggleAppendClient) AppendLogs(
+	ctx context.Context,
+	in *raft.AppendRequest,
+	opts ...grpc.CallOption) (*raft.AppendReply, error) {
+
+	if atomic.LoadInt32(&f.ack) == 0 {
+		return nil, errors.New("unavailable")
+	}
+	return &raft.AppendReply{Term: in.Term, Success: true}, nil
+}
+
+func TestCommitQuorum(t *testing.T) {
+	// with an even number of members, half of them are not a majority
+	for _, size := range []int{2, 4} {
+		t.Run(fmt.Sprintf("%d members", size), func(t *testing.T) {
+			n := setupNode(t)
+			n.State = Leader
+			n.Log = &raft.LogStore{Entries: []*raft.LogRecord{
+				{Term: n.Term, Action: raft.LogRecord_SET, Key: "a", Value: "1"}}}
+			clients := []*toggleAppendClient{}
+			for i := 1; i < size; i++ {
+				client := &toggleAppendClient{}
+				clients = append(clients, client)
+				n.peers.add(fmt.Sprintf("commit-quorum-test:%d", i), &ForeignNode{
+					Client:     client,
+					MatchIndex: -1,
+					Available:  true})
+			}
+
+			// acknowledged by half of the members (the leader, and any peers
+			// before the last needed for a majority)
+			for _, client := range clients[:size/2-1] {
+				atomic.StoreInt32(&client.ack, 1)
+			}
+			if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != ErrAppendFailed {
+				t.Errorf("Expected append by half of %d members to fail, got %v", size, err)
+			}
+			if n.Committed() != -1 {
+				t.Errorf("Expected entry not committed by half of %d members, commit index %d",
+					size, n.Committed())
+			}
+
+			atomic.StoreInt32(&clients[size/2-1].ack, 1)
+			if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != nil {
+				t.Errorf("Error in append by a majority of %d members: %v", size, err)
+			}
+			if n.Committed() != 0 {
+				t.Errorf("Expected entry committed by a majority of %d members, commit index %d",
+					size, n.Committed())
+			}
+		})
+	}
+}
+
+func TestCommitEarlierTerm(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.Term = 3
+	// an entry left by the leader of term 2 is replicated to every member
+	// by the leader of term 3, but it is not committed until an entry of term 3
+	// is replicated too (Figure 8 of the Raft paper)
+	n.Log = &raft.LogStore{Entries: []*raft.LogRecord{
+		{Term: 2, Action: raft.LogRecord_SET, Key: "a", Value: "1"}}}
+	for i := 1; i < 3; i++ {
+		n.peers.add(fmt.Sprintf("earlier-term-test:%d", i), &ForeignNode{
+			Client:     &toggleAppendClient{ack: 1},
+			MatchIndex: -1,
+			Available:  true})
+	}
+	if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != nil {
+		t.Fatalf("Error in append: %v", err)
+	}
+	if n.Committed() != -1 {
+		t.Errorf("Expected entry of an earlier term not to be committed, commit index %d", n.Committed())
+	}
+
+	n.Log.Entries = append(n.Log.Entries,
+		&raft.LogRecord{Term: n.Term, Action: raft.LogRecord_SET, Key: "b", Value: "2"})
+	if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != nil {
+		t.Fatalf("Error in append: %v", err)
+	}
+	if n.Committed() != 1 {
+		t.Errorf("Expected both entries committed with an entry of the current term, commit index %d",
+			n.Committed())
+	}
+}
+
+func TestCommitUncertain(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.CommitTimeout = 50 * time.Millisecond
+	client := &toggleAppendClient{}
+	for i := 1; i <= 2; i++ {
+		n.peers.add(fmt.Sprintf("uncertain-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+
+	start := time.Now()
+	err := n.Set(context.Background(), "key", "value")
+	var uncertain *CommitUncertainError
+	if !errors.As(err, &uncertain) || !errors.Is(err, ErrCommitUncertain) {
+		t.Fatalf("Expected %v, got %v", ErrCommitUncertain, err)
+	}
+	if !errors.Is(err, ErrCommitTimeout) {
+		t.Errorf("Expected the commit deadline to have passed, got %v", uncertain.Err)
+	}
+	if elapsed := time.Since(start); elapsed < n.config.CommitTimeout {
+		t.Errorf("Expected write to wait for the commit deadline, returned after %s", elapsed)
+	}
+	if uncertain.Index != 0 || uncertain.Term != n.Term {
+		t.Errorf("Expected entry 0 in term %d, got %d in %d", n.Term, uncertain.Index, uncertain.Term)
+	}
+	if status := n.EntryStatus(uncertain.Index, uncertain.Term); status != EntryPending {
+		t.Errorf("Expected entry to be pending, got %s", status)
+	}
+
+	// the entry is committed by a later round of appends
+	atomic.StoreInt32(&client.ack, 1)
+	if err := n.SendAppend(context.Background(), retry.Once, n.Term); err != nil {
+		t.Fatalf("Error in append: %v", err)
+	}
+	if status := n.EntryStatus(uncertain.Index, uncertain.Term); status != EntryCommitted {
+		t.Errorf("Expected entry to be committed, got %s", status)
+	}
+	if status := n.EntryStatus(uncertain.Index, uncertain.Term-1); status != EntryLost {
+		t.Errorf("Expected an entry from another term to be lost, got %s", status)
+	}
+	if status := n.EntryStatus(uncertain.Index+1, uncertain.Term); status != EntryUnknown {
+		t.Errorf("Expected an entry past the end of the log to be unknown, got %s", status)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.CommitTimeout = 5 * time.Second
+	client := &toggleAppendClient{}
+	for i := 1; i <= 2; i++ {
+		n.peers.add(fmt.Sprintf("deadline-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+
+	// a write whose deadline has passed is not appended
+	queued, committing := deadlineExceeded.Value(deadlineQueue), deadlineExceeded.Value(deadlineCommit)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err := n.Set(ctx, "key", "value")
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCommitUncertain) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if n.LastLogIndex() != -1 {
+		t.Errorf("Expected no entry to be appended, got last index %d", n.LastLogIndex())
+	}
+	if deadlineExceeded.Value(deadlineQueue) != queued+1 {
+		t.Errorf("Expected the write to be counted as past its deadline in the queue")
+	}
+
+	// one whose deadline passes while it is being replicated stops waiting
+	// then, rather than at the commit timeout, and is told apart from it
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = n.Set(ctx, "key", "value")
+	var uncertain *CommitUncertainError
+	if !errors.As(err, &uncertain) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected an uncertain commit past its deadline, got %v", err)
+	}
+	if errors.Is(err, ErrCommitTimeout) {
+		t.Errorf("Expected the deadline to be told apart from the commit timeout")
+	}
+	if elapsed := time.Since(start); elapsed >= n.config.CommitTimeout {
+		t.Errorf("Expected write to stop waiting at its deadline, returned after %s", elapsed)
+	}
+	if deadlineExceeded.Value(deadlineCommit) != committing+1 {
+		t.Errorf("Expected the write to be counted as past its deadline while committing")
+	}
+}
+
+func TestWaitForIndex(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if applied, err := n.WaitForIndex(ctx, 0); applied || err != context.DeadlineExceeded {
+		t.Errorf("Expected wait for an unwritten index to time out, got %t, %v", applied, err)
+	}
+
+	result := make(chan bool, 1)
+	go func() {
+		applied, _ := n.WaitForIndex(context.Background(), 0)
+		result <- applied
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if err := n.Set(context.Background(), "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	select {
+	case applied := <-result:
+		if !applied {
+			t.Error("Expected index to be applied")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected wait to return once the index was applied")
+	}
+}
+
+func TestLogPreallocation(t *testing.T) {
+	for room := int64(2); room < 70000; room++ {
+		if padding := appendPadding(nil, room); int64(len(padding)) > room {
+			t.Fatalf("Padding for %d bytes has %d bytes of headers", room, len(padding))
+		}
+	}
+
+	modes := []LogSyncMode{LogSyncNone, LogSyncFsync, LogSyncDsync}
+	if util.DirectFlag != 0 {
+		modes = append(modes, LogSyncDirect)
+	}
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) { testLogPreallocation(t, mode) })
+	}
+}
+
+func TestDirectLogWrite(t *testing.T) {
+	if _, err := ParseLogSyncMode(string(LogSyncDirect)); err != nil {
+		t.Skip(err)
+	}
+	filename := filepath.Join(t.TempDir(), "raftlog")
+	entries := []*raft.LogRecord{}
+	for i := 0; i < 3; i++ {
+		entries = append(entries, &raft.LogRecord{
+			Term:   1,
+			Action: raft.LogRecord_SET,
+			Key:    fmt.Sprintf("key%d", i),
+			Value:  strings.Repeat("v", 3000)})
+		size, err := writeLogFile(filename, &raft.LogStore{Entries: entries}, LogSyncDirect, 0)
+		if err != nil {
+			t.Fatalf("Error writing log: %v", err)
+		}
+		// the file is padded to a whole number of blocks after the log
+		fi, _ := os.Stat(filename)
+		if fi.Size()%util.DirectAlignment != 0 || fi.Size() < size {
+			t.Errorf("Expected a log of %d bytes padded to a multiple of %d, got a %d-byte file",
+				size, util.DirectAlignment, fi.Size())
+		}
+		logStore, err := loadLogs(filename)
+		if err != nil || len(logStore.Entries) != i+1 || logStore.Entries[i].Key != entries[i].Key {
+			t.Errorf("Expected to load the log written, got %+v (%v)", logStore, err)
+		}
+	}
+}
+
+func testLogPreallocation(t *testing.T, mode LogSyncMode) {
+	n := setupNode(t)
+	n.config.LogSync = mode
+	n.config.LogPreallocate = 4096
+	n.State = Leader
+	for i := 0; i < 5; i++ {
+		n.Set(context.Background(), fmt.Sprintf("key%d", i), strings.Repeat("v", 1000))
+	}
+	fi, err := os.Stat(n.config.LogFile)
+	if err != nil {
+		t.Fatalf("Error reading log file: %v", err)
+	}
+	if fi.Size() != 8192 || n.LogBytes() >= 8192 || n.LogBytes() < 5000 {
+		t.Errorf("%s: expected a log of about 5000 bytes in an 8192-byte file, got %d in %d",
+			mode, n.LogBytes(), fi.Size())
+	}
+
+	// rewriting a shorter log leaves the file allocated
+	if _, err := n.CompactLog(4); err != nil {
+		t.Fatalf("Error compacting log: %v", err)
+	}
+	logStore, err := loadLogs(n.config.LogFile)
+	if err != nil {
+		t.Fatalf("%s: error loading log: %v", mode, err)
+	}
+	if len(logStore.Entries) != 1 || logStore.BaseIndex != 4 || logStore.Entries[0].Key != "key4" {
+		t.Errorf("%s: expected the entry after the compacted ones, got %+v", mode, logStore)
+	}
+	if fi, _ := os.Stat(n.config.LogFile); fi.Size() != 8192 {
+		t.Errorf("%s: expected the log file to stay allocated, got %d bytes", mode, fi.Size())
+	}
+}
+
+func TestNamespacePolicies(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	ctx := context.Background()
+	n.Set(ctx, "user:1", "alice")
+
+	policy := NamespacePolicy{MaxKeys: 2, MaxValueBytes: 5, DefaultTTLMs: 60000}
+	if err := n.SetNamespacePolicy(ctx, "user", policy); err != nil {
+		t.Fatal("Error setting namespace policy:", err)
+	}
+	if err := n.Set(ctx, "user:2", "bob"); err != nil {
+		t.Fatal("Error writing within the namespace's limits:", err)
+	}
+	if at, ok := n.Store.Expiry("user:2"); !ok || time.Until(time.Unix(0, at)) < 50*time.Second {
+		t.Errorf("Expected the default time to live to be applied, got %v", ok)
+	}
+	if _, ok := n.Store.Expiry("user:1"); ok {
+		t.Error("Expected keys written before the policy to keep no expiry")
+	}
+	if err := n.Set(ctx, "user:3", "carol"); !errors.Is(err, ErrNamespaceQuota) {
+		t.Errorf("Expected key limit to be enforced, got %v", err)
+	}
+	if err := n.Set(ctx, "user:1", "alison"); !errors.Is(err, ErrNamespaceQuota) {
+		t.Errorf("Expected value size limit to be enforced, got %v", err)
+	}
+	if err := n.Set(ctx, "order:1", "a long value"); err != nil {
+		t.Errorf("Expected other namespaces to be unaffected, got %v", err)
+	}
+	if err := n.Set(ctx, namespacePolicyPrefix+"user", "{}"); err != ErrSystemKey {
+		t.Errorf("Expected client write of a system key to fail, got %v", err)
+	}
+
+	// policies are kept in snapshots, as keys
+	data, _ := db.BuildSnapshot(n.Store)
+	restored, err := db.InstallSnapshot(data)
+	if err != nil || !restored.Exists(namespacePolicyPrefix+"user") {
+		t.Errorf("Expected namespace policy in snapshot (%v)", err)
+	}
+
+	n.SetNamespacePolicy(ctx, "user", NamespacePolicy{Access: AccessReadOnly})
+	var nsErr *NamespaceError
+	if err := n.Delete(ctx, "user:1"); !errors.As(err, &nsErr) || nsErr.Namespace != "user" ||
+		!errors.Is(err, ErrNamespaceDenied) {
+		t.Errorf("Expected write to read-only namespace to be denied, got %v", err)
+	}
+	if err := n.CheckRead("user:1"); err != nil {
+		t.Errorf("Expected read of read-only namespace to be allowed, got %v", err)
+	}
+	n.SetNamespacePolicy(ctx, "user", NamespacePolicy{Access: AccessNone})
+	if err := n.CheckRead("user:1"); !errors.Is(err, ErrNamespaceDenied) {
+		t.Errorf("Expected read to be denied, got %v", err)
+	}
+	if policies := n.NamespacePolicies(); len(policies) != 1 || policies["user"].Access != AccessNone {
+		t.Errorf("Expected the user namespace's policy, got %v", policies)
+	}
+
+	if err := n.SetNamespacePolicy(ctx, "a:b", policy); err != ErrInvalidNamespace {
+		t.Errorf("Expected invalid namespace to be rejected, got %v", err)
+	}
+	if err := n.SetNamespacePolicy(ctx, "user", NamespacePolicy{Access: "some"}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Expected invalid policy to be rejected, got %v", err)
+	}
+
+	if existed, err := n.DeleteNamespacePolicy(ctx, "user"); !existed || err != nil {
+		t.Errorf("Expected policy to be deleted, got %t, %v", existed, err)
+	}
+	if err := n.Set(ctx, "user:3", "carol"); err != nil {
+		t.Errorf("Expected write after deleting the policy to succeed, got %v", err)
+	}
+	if existed, _ := n.DeleteNamespacePolicy(ctx, "user"); existed {
+		t.Error("Expected no policy to delete")
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	n := setupNode(t)
+	n.config.ShedApplyLag = 10
+	n.config.ShedQueuedWrites = 2
+
+	if b := n.Backlog(); b.Shedding || b.ApplyLag != 0 || n.ShedLowPriority() != nil {
+		t.Errorf("Expected no backlog on a new node, got %+v", b)
+	}
+
+	// a follower measures its lag against the leader's commit index
+	n.noteLeaderCommit(n.LastApplied + 10)
+	if b := n.Backlog(); b.Shedding || b.ApplyLag != 10 {
+		t.Errorf("Expected a lag of 10 within the limit, got %+v", b)
+	}
+	n.noteLeaderCommit(n.LastApplied + 11)
+	if err := n.ShedLowPriority(); err != ErrBacklogged {
+		t.Errorf("Expected shedding past the apply lag limit, got %v", err)
+	}
+	n.noteLeaderCommit(n.LastApplied)
+
+	atomic.StoreInt64(&n.queuedWrites, 3)
+	if b := n.Backlog(); !b.Shedding || b.QueuedWrites != 3 {
+		t.Errorf("Expected shedding past the queued write limit, got %+v", b)
+	}
+	n.config.ShedQueuedWrites = 0
+	if b := n.Backlog(); b.Shedding {
+		t.Errorf("Expected no shedding with the queued write limit disabled, got %+v", b)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	n := setupNode(t)
+	n.config.MaxProposalBytes = 100
+	ctx := context.Background()
+	batch := WithPriority(ctx, PriorityBatch)
+
+	if p, err := ParsePriority(""); err != nil || p != PriorityNormal {
+		t.Errorf("Expected normal priority by default, got %q %v", p, err)
+	}
+	if _, err := ParsePriority("urgent"); err != ErrInvalidPriority {
+		t.Errorf("Expected ErrInvalidPriority, got %v", err)
+	}
+	if p := RequestPriority(context.WithValue(batch, systemWriteKey{}, true)); p != PrioritySystem {
+		t.Errorf("Expected system writes to have system priority, got %q", p)
+	}
+
+	// batch writes may use half of the budget, leaving the rest to others
+	if err := n.admitProposal(batch, 40); err != nil {
+		t.Fatalf("Expected batch proposal within its share to be admitted, got %v", err)
+	}
+	if err := n.admitProposal(batch, 20); err != ErrProposalBudget {
+		t.Errorf("Expected batch proposal over its share to be rejected, got %v", err)
+	}
+	if err := n.admitProposal(ctx, 50); err != nil {
+		t.Errorf("Expected normal proposal within budget to be admitted, got %v", err)
+	}
+	if err := n.admitProposal(WithPriority(ctx, PrioritySystem), 1000); err != nil {
+		t.Errorf("Expected system proposal not to be limited, got %v", err)
+	}
+
+	// a batch write waits while a normal write is queued
+	q := newWriteQueue()
+	q.enter(PriorityNormal)
+	entered := make(chan struct{})
+	go func() {
+		q.enter(PriorityBatch)
+		close(entered)
+	}()
+	select {
+	case <-entered:
+		t.Error("Expected batch write to wait behind a normal write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.enter(PrioritySystem)
+	q.leave(PrioritySystem)
+	q.leave(PriorityNormal)
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Error("Expected batch write to proceed once the normal write left")
+	}
+}
+
+// overtakingVoteClient grants votes, but first has the candidate receive an
+// append from another leader in a later term
+type overtakingVoteClient struct {
+	raft.RaftClient
+	candidate *Node
+}
+
+func (f *overtakingVoteClient) RequestVote(
+	ctx context.Context,
+	in *raft.VoteRequest,
+	opts ...grpc.CallOption) (*raft.VoteReply, error) {
+
+	f.candidate.HandleAppend(&raft.AppendRequest{
+		Term:         in.Term + 1,
+		Leader:       &raft.Node{Id: "other-leader"},
+		PrevLogIndex: -1,
+		LeaderCommit: -1})
+	return &raft.VoteReply{Term: in.Term, VoteGranted: true}, nil
+}
+
+func TestEventLoop(t *testing.T) {
+	n := setupNode(t)
+
+	// vote requests are processed one at a time, so only one candidate gets
+	// this node's vote in a term, however many ask at once
+	term := n.Term + 1
+	var granted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := n.HandleVote(&raft.VoteRequest{
+				Term:         term,
+				Candidate:    &raft.Node{Id: fmt.Sprintf("candidate:%d", i)},
+				LastLogIndex: -1,
+				LastLogTerm:  0})
+			if reply.VoteGranted {
+				atomic.AddInt32(&granted, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if granted != 1 || n.Term != term {
+		t.Errorf("Expected one vote granted in term %d, got %d in term %d", term, granted, n.Term)
+	}
+
+	// an election overtaken by a later term while the votes are collected is
+	// lost, even with a majority
+	n.peers.add("overtaking:1", &ForeignNode{Client: &overtakingVoteClient{candidate: n}, Available: true})
+	if n.DoElection() {
+		t.Error("Expected an election overtaken by a later term to be lost")
+	}
+	if n.State != Follower || n.Term != term+2 || n.currentLeader.Id != "other-leader" {
+		t.Errorf("Expected to follow the other leader in term %d, got %s in term %d", term+2, n.State, n.Term)
+	}
+}
+
+func TestApplyMembers(t *testing.T) {
+	n := setupNode(t)
+	n.AddForeignNode("a:16990")
+	n.AddForeignNode("b:16990")
+	learner := &ForeignNode{Client: &fakeAppendClient{}, MatchIndex: -1, learner: true}
+	n.peers.add("l:16990", learner)
+
+	leader := &raft.Node{Id: "b:16990", ClientAddr: "b:8080"}
+	members := []string{"b:16990", "c:16990", n.RaftNode.Id}
+	reply := n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		Entries:      []*raft.LogRecord{{Term: 1, Action: raft.LogRecord_MEMBERS, Members: members}},
+		LeaderCommit: 0})
+	if !reply.Success {
+		t.Fatal("Expected the membership entry to be appended")
+	}
+
+	// peers not listed are removed, other than the learner, which is not part
+	// of the membership yet
+	ids := []string{}
+	for _, peer := range n.Peers() {
+		ids = append(ids, peer.Id)
+	}
+	expected := []string{"b:16990", "c:16990", "l:16990"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected peers %v, got %v", expected, ids)
+	}
+	if members := n.Members(); len(members) != 3 || members[2] != n.RaftNode.Id {
+		t.Errorf("Expected the learner not to be a voting member, got %v", members)
+	}
+
+	// the membership is restored along with a snapshot
+	restored := setupNode(t)
+	restored.RestoreSnapshot(db.Clone(n.Store), 0, 0)
+	if members := restored.Members(); len(members) != 3 || members[0] != "b:16990" || members[1] != "c:16990" {
+		t.Errorf("Expected the membership to be restored from the snapshot, got %v", members)
+	}
+
+	// witnesses keep the members of a membership entry
+	stripped := stripEntries([]*raft.LogRecord{{Action: raft.LogRecord_MEMBERS, Members: members, Key: "k"}})
+	if len(stripped[0].Members) != 3 {
+		t.Errorf("Expected a witness to keep the members, got %v", stripped[0])
+	}
+}
+
+func TestJointMembers(t *testing.T) {
+	// commits and elections in a joint membership need a majority of each
+	joint := configuration{
+		members: []string{"a:16990", "d:16990", "e:16990"},
+		old:     []string{"a:16990", "b:16990", "c:16990"}}
+	acked := func(addrs ...string) map[string]bool {
+		set := map[string]bool{}
+		for _, addr := range addrs {
+			set[addr] = true
+		}
+		return set
+	}
+	if joint.quorum(acked("a:16990", "d:16990", "e:16990")) {
+		t.Error("Expected no quorum without a majority of the old members")
+	}
+	if joint.quorum(acked("a:16990", "b:16990", "c:16990")) {
+		t.Error("Expected no quorum without a majority of the new members")
+	}
+	if !joint.quorum(acked("a:16990", "b:16990", "d:16990")) {
+		t.Error("Expected a quorum with a majority of each")
+	}
+
+	// a follower adopts the joint membership, then the new one
+	n := setupNode(t)
+	n.AddForeignNode("a:16990")
+	n.AddForeignNode("b:16990")
+	old := []string{"a:16990", "b:16990", n.RaftNode.Id}
+	members := []string{"a:16990", "c:16990", "d:16990", n.RaftNode.Id}
+	leader := &raft.Node{Id: "a:16990", ClientAddr: "a:8080"}
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_MEMBERS, Members: members, OldMembers: old}},
+		LeaderCommit: 0})
+	if !n.inJoint() || len(n.Peers()) != 4 || !n.Store.Exists(OldMembersKey) {
+		t.Errorf("Expected the members of both memberships as peers, got %v", n.Members())
+	}
+	if _, err := n.RemoveServer(context.Background(), "b:16990"); err != ErrNotLeaderRecv {
+		t.Errorf("Expected %v removing a member on a follower, got %v", ErrNotLeaderRecv, err)
+	}
+
+	// the joint membership is restored along with a snapshot
+	restored := setupNode(t)
+	restored.RestoreSnapshot(db.Clone(n.Store), 0, 0)
+	if !restored.inJoint() || len(restored.Members()) != 5 {
+		t.Errorf("Expected the joint membership to be restored, got %v", restored.Members())
+	}
+
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       leader,
+		PrevLogIndex: 0,
+		PrevLogTerm:  1,
+		Entries:      []*raft.LogRecord{{Term: 1, Action: raft.LogRecord_MEMBERS, Members: members}},
+		LeaderCommit: 1})
+	if n.inJoint() || n.Store.Exists(OldMembersKey) || strings.Join(n.Members(), ",") != strings.Join(members, ",") {
+		t.Errorf("Expected the new membership once the change is complete, got %v", n.Members())
+	}
+}
+
+func TestChangeMembers(t *testing.T) {
+	n := setupNode(t)
+	ctx := context.Background()
+	if _, err := n.ChangeMembers(ctx, []string{n.RaftNode.Id}); err != ErrNotLeaderRecv {
+		t.Errorf("Expected %v on a follower, got %v", ErrNotLeaderRecv, err)
+	}
+	n.State = Leader
+	released := make(chan struct{})
+	close(released)
+	for _, addr := range []string{"a:16990", "b:16990", "c:16990"} {
+		n.peers.add(addr, &ForeignNode{
+			Client:     &fakeAppendClient{release: released},
+			MatchIndex: -1,
+			Available:  true})
+	}
+	if _, err := n.ChangeMembers(ctx, nil); err != ErrNoMembers {
+		t.Errorf("Expected %v for no members, got %v", ErrNoMembers, err)
+	}
+	if _, err := n.ChangeMembers(ctx, []string{"a:16990", "b:16990"}); err != ErrRemoveLeader {
+		t.Errorf("Expected %v removing the leader, got %v", ErrRemoveLeader, err)
+	}
+
+	// two of the three peers are removed at once, through a joint membership
+	members, err := n.ChangeMembers(ctx, []string{n.RaftNode.Id, "c:16990", n.RaftNode.Id})
+	if err != nil {
+		t.Fatal("Error changing members:", err)
+	}
+	expected := []string{"c:16990", n.RaftNode.Id}
+	if strings.Join(members, ",") != strings.Join(expected, ",") ||
+		strings.Join(n.Members(), ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected members %v, got %v (peers %v)", expected, members, n.Members())
+	}
+	entries := n.Log.Entries
+	if len(entries) != 2 || len(entries[0].OldMembers) != 4 || len(entries[1].OldMembers) != 0 {
+		t.Errorf("Expected a joint entry, then the new membership, got %v", entries)
+	}
+	if n.inJoint() || n.CommitIndex != 1 {
+		t.Errorf("Expected the change to be complete, commit index %d", n.CommitIndex)
+	}
+
+	// one change at a time
+	n.setJoint(&configuration{members: expected, old: entries[0].OldMembers})
+	if _, err := n.RemoveServer(ctx, "c:16990"); err != ErrChangeInProgress {
+		t.Errorf("Expected %v during a change, got %v", ErrChangeInProgress, err)
+	}
+	if _, err := n.AddMember("d:16990"); err != ErrChangeInProgress {
+		t.Errorf("Expected %v during a change, got %v", ErrChangeInProgress, err)
+	}
+}
+
+// recordingPersister keeps the persistent state written by a node in memory,
+// along with the node's state in memory at the time of each write, and fails
+// writes while fail is set
+type recordingPersister struct {
+	n        *Node
+	fail     bool
+	term     *raft.TermRecord
+	log      *raft.LogStore
+	termSeen int64
+	logSeen  *raft.LogStore
+}
+
+func (p *recordingPersister) writeTerm(term *raft.TermRecord) error {
+	if p.fail {
+		return &PersistError{Op: "write", File: "term", Err: errors.New("failed")}
+	}
+	p.term, p.termSeen = term, p.n.Term
+	return nil
+}
+
+func (p *recordingPersister) writeLogs(log *raft.LogStore) error {
+	if p.fail {
+		return &PersistError{Op: "write", File: "raftlog", Err: errors.New("failed")}
+	}
+	p.log, p.logSeen = log, p.n.Log
+	return nil
+}
+
+// check returns an error if the node's persistent state is not what was last
+// written, or its applied index is past its commit index
+func (p *recordingPersister) check() error {
+	n := p.n
+	if p.term != nil && (p.term.Term != n.Term || p.term.VotedFor.GetId() != n.votedFor.GetId()) {
+		return fmt.Errorf("term %d (vote %q) is not the persisted term %d (vote %q)",
+			n.Term, n.votedFor.GetId(), p.term.Term, p.term.VotedFor.GetId())
+	}
+	if p.log != nil && p.log != n.Log {
+		return fmt.Errorf("log to %d is not the persisted log to %d", lastIndex(n.Log), lastIndex(p.log))
+	}
+	if n.LastApplied > n.CommitIndex {
+		return fmt.Errorf("applied index %d is past commit index %d", n.LastApplied, n.CommitIndex)
+	}
+	return nil
+}
+
+func TestStateTransitions(t *testing.T) {
+	n := setupNode(t)
+	p := &recordingPersister{n: n}
+	n.persist = p
+	candidate := &raft.Node{Id: "localhost:16999", ClientAddr: "localhost:8089"}
+
+	// a vote is persisted before it takes effect, and before the reply
+	reply := n.HandleVote(&raft.VoteRequest{Term: 1, Candidate: candidate, LastLogIndex: -1})
+	if !reply.VoteGranted {
+		t.Fatalf("Expected vote to be granted, got %v", reply)
+	}
+	if p.term == nil || p.term.Term != 1 || p.term.VotedFor.GetId() != candidate.Id || p.termSeen != 0 {
+		t.Errorf("Expected the vote to be persisted while still in term 0, got %v in term %d", p.term, p.termSeen)
+	}
+	if err := p.check(); err != nil {
+		t.Error(err)
+	}
+
+	// so are entries, before they are acknowledged
+	appendReply := n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       candidate,
+		PrevLogIndex: -1,
+		Entries: []*raft.LogRecord{
+			{Term: 1, Action: raft.LogRecord_SET, Key: "a", Value: "1"},
+			{Term: 1, Action: raft.LogRecord_SET, Key: "b", Value: "2"}},
+		LeaderCommit: 0})
+	if !appendReply.Success {
+		t.Fatal("Expected append to succeed")
+	}
+	if lastIndex(p.log) != 1 || lastIndex(p.logSeen) != -1 {
+		t.Errorf("Expected the entries to be persisted before the log changed, got %v", p.log)
+	}
+	if n.CommitIndex != 0 || n.LastApplied != 0 {
+		t.Errorf("Expected entry 0 to be committed and applied, got %d and %d", n.CommitIndex, n.LastApplied)
+	}
+	if err := p.check(); err != nil {
+		t.Error(err)
+	}
+
+	// state that can't be persisted doesn't change, and isn't acknowledged
+	p.fail = true
+	reply = n.HandleVote(&raft.VoteRequest{Term: 2, Candidate: candidate, LastLogIndex: 1, LastLogTerm: 1})
+	if reply.VoteGranted || n.Term != 1 {
+		t.Errorf("Expected no vote without a persisted term, got %v in term %d", reply, n.Term)
+	}
+	appendReply = n.HandleAppend(&raft.AppendRequest{
+		Term:         1,
+		Leader:       candidate,
+		PrevLogIndex: 1,
+		PrevLogTerm:  1,
+		Entries:      []*raft.LogRecord{{Term: 1, Action: raft.LogRecord_SET, Key: "c", Value: "3"}},
+		LeaderCommit: 1})
+	if appendReply.Success || n.LastLogIndex() != 1 {
+		t.Errorf("Expected the append to be refused, got %v with last index %d", appendReply, n.LastLogIndex())
+	}
+	if err := p.check(); err != nil {
+		t.Error(err)
+	}
+
+	// the commit index never moves back
+	if n.commitThrough(-1) || n.CommitIndex != 0 {
+		t.Errorf("Expected commit index to stay at 0, got %d", n.CommitIndex)
+	}
+}
+
+func TestIsolate(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	client := &unknownVoteClient{}
+	host := "isolate-test:1"
+	n.peers.add(host, &ForeignNode{Client: client, Available: true, MatchIndex: -1})
+
+	if _, err := n.Isolate(host, 0); err != ErrInvalidIsolation {
+		t.Errorf("Expected ErrInvalidIsolation, got %v", err)
+	}
+	if _, err := n.Isolate(n.RaftNode.Id, time.Minute); err != ErrIsolateSelf {
+		t.Errorf("Expected ErrIsolateSelf, got %v", err)
+	}
+	if _, err := n.Isolate("isolate-test:2", time.Minute); err != ErrPeerRemoved {
+		t.Errorf("Expected ErrPeerRemoved, got %v", err)
+	}
+
+	until, err := n.Isolate(host, time.Minute)
+	if err != nil {
+		t.Fatalf("Error isolating peer: %v", err)
+	}
+	if peers := n.Peers(); peers[0].IsolatedUntil == nil || !peers[0].IsolatedUntil.Equal(until) {
+		t.Errorf("Expected the peer to be reported isolated until %v, got %v", until, peers[0])
+	}
+
+	// the isolated peer is not sent entries...
+	if _, err := n.appendRequest(host, n.Term); err != ErrPeerIsolated {
+		t.Errorf("Expected ErrPeerIsolated, got %v", err)
+	}
+	// ...its vote requests are refused without adopting its term...
+	n.State = Follower
+	term := n.Term
+	reply := n.HandleVote(&raft.VoteRequest{
+		Term:         term + 1,
+		Candidate:    &raft.Node{Id: host},
+		LastLogIndex: -1,
+		LastLogTerm:  0})
+	if reply.VoteGranted || reply.DenyReason != raft.VoteReply_ISOLATED || n.Term != term {
+		t.Errorf("Expected the vote to be refused as ISOLATED in term %d, got %v (term %d)",
+			term, reply, n.Term)
+	}
+	// ...and it is not asked for its vote
+	n.DoElection()
+	if calls := atomic.LoadInt32(&client.calls); calls != 0 {
+		t.Errorf("Expected no vote requests to the isolated peer, got %d", calls)
+	}
+
+	if err := n.Unisolate(host); err != nil {
+		t.Fatalf("Error ending isolation: %v", err)
+	}
+	if n.Peers()[0].IsolatedUntil != nil {
+		t.Error("Expected the peer not to be isolated")
+	}
+	if err := n.Unisolate("isolate-test:2"); err != ErrPeerRemoved {
+		t.Errorf("Expected ErrPeerRemoved, got %v", err)
+	}
+
+	// isolation lapses on its own
+	n.State = Leader
+	if _, err := n.Isolate(host, 10*time.Millisecond); err != nil {
+		t.Fatalf("Error isolating peer: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := n.appendRequest(host, n.Term); err != nil {
+		t.Errorf("Expected the isolation to have lapsed, got %v", err)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.ReadIndexWindow = time.Hour
+	n.config.MaxClockSkew = 500 * time.Millisecond
+	host := "skew-test:1"
+	n.peers.add(host, &ForeignNode{Client: &toggleAppendClient{ack: 1}, Available: true, MatchIndex: -1})
+	peer := n.peers.get(host)
+	n.Set(context.Background(), "key", "value")
+	var events []EventType
+	n.AddEventListener(func(e Event) {
+		events = append(events, e.Type)
+	})
+
+	// a reply without a time leaves the offset unknown
+	sent := time.Now()
+	received := sent.Add(2 * time.Millisecond)
+	n.observeClock(host, peer, sent, received, 0)
+	if n.Peers()[0].ClockOffset != nil {
+		t.Error("Expected no clock offset from a reply without a time")
+	}
+
+	// the peer's time is compared with the middle of the round trip
+	n.observeClock(host, peer, sent, received, sent.Add(101*time.Millisecond).UnixNano())
+	if offset := n.Peers()[0].ClockOffset; offset == nil || *offset != 100*time.Millisecond {
+		t.Errorf("Expected an offset of 100ms, got %v", offset)
+	}
+	if !n.LeaseReadsAllowed() {
+		t.Error("Expected lease reads within the bound")
+	}
+
+	// beyond the bound, lease reads are disabled
+	rounds := readIndexRequests.Value(readIndexRound)
+	n.observeClock(host, peer, sent, received, sent.Add(-time.Second).UnixNano())
+	if n.LeaseReadsAllowed() || leaseReadsDisabled.Value() != 1 {
+		t.Fatal("Expected lease reads to be disabled beyond the bound")
+	}
+	if !reflect.DeepEqual(events, []EventType{EventClockSkew}) {
+		t.Errorf("Expected a clock skew event, got %v", events)
+	}
+	if _, err := n.ReadBarrier(context.Background()); err != nil {
+		t.Fatalf("ReadBarrier failed: %v", err)
+	}
+	if got := readIndexRequests.Value(readIndexRound) - rounds; got != 1 {
+		t.Errorf("Expected the barrier to take a round of its own, got %v", got)
+	}
+	if _, ok := n.Staleness(); ok {
+		t.Error("Expected the leader's staleness to be unknown")
+	}
+	if req, err := n.appendRequest(host, n.Term); err != nil || req.LeaseAge != 0 {
+		t.Errorf("Expected append requests without the lease age, got %v (%v)", req, err)
+	}
+
+	// lease reads resume once the peer is back within the bound
+	n.observeClock(host, peer, sent, received, sent.UnixNano())
+	if !n.LeaseReadsAllowed() || leaseReadsDisabled.Value() != 0 {
+		t.Error("Expected lease reads to resume within the bound")
+	}
+	if req, _ := n.appendRequest(host, n.Term); req.LeaseAge == 0 {
+		t.Error("Expected append requests to carry the lease age again")
+	}
+
+	// with no bound, skew never disables them
+	n.config.MaxClockSkew = 0
+	n.observeClock(host, peer, sent, received, sent.Add(time.Hour).UnixNano())
+	if !n.LeaseReadsAllowed() {
+		t.Error("Expected lease reads without a bound")
+	}
+}
+
+func TestLeaderLease(t *testing.T) {
+	// a lease restored after a transfer must be usable in any term, including
+	// the first
+	for _, term := range []int64{0, 1} {
+		t.Run(fmt.Sprintf("term %d", term), func(t *testing.T) { testLeaderLease(t, term) })
+	}
+}
+
+func testLeaderLease(t *testing.T, term int64) {
+	n := setupNode(t)
+	n.State = Leader
+	n.Term = term
+	n.config.LeaderLease = 200 * time.Millisecond
+	client := &toggleAppendClient{ack: 1}
+	for i := 1; i <= 2; i++ {
+		n.peers.add(fmt.Sprintf("lease-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+	ctx := context.Background()
+
+	// until the leader has committed an entry in its term, reads take a round
+	quorum, lease := leaseReads.Value(leaseReadQuorum), leaseReads.Value(leaseReadLease)
+	if err := n.AwaitLinearizable(ctx); err != nil {
+		t.Fatalf("Error confirming leadership: %v", err)
+	}
+	if leaseReads.Value(leaseReadQuorum) != quorum+1 {
+		t.Error("Expected a read before committing in the term to take a round")
+	}
+
+	if err := n.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	value, err := n.LeaseGet(ctx, "key")
+	if err != nil || value != "value" {
+		t.Errorf("Expected to read the write, got %q (%v)", value, err)
+	}
+	if leaseReads.Value(leaseReadLease) != lease+1 {
+		t.Error("Expected the read to be served within the lease")
+	}
+
+	// the lease is not used during a leadership transfer
+	n.revokeLease(n.Term)
+	if n.leaseHeld(n.Term) {
+		t.Error("Expected the lease to be revoked")
+	}
+	n.restoreLease(n.Term)
+	if !n.leaseHeld(n.Term) {
+		t.Error("Expected the lease to be restored")
+	}
+
+	// once the lease lapses, a read falls back to a round, which fails
+	// without a majority
+	atomic.StoreInt32(&client.ack, 0)
+	time.Sleep(n.config.LeaderLease)
+	if _, err := n.LeaseGet(ctx, "key"); err == nil {
+		t.Error("Expected a read after the lease lapsed to need a majority")
+	}
+}
+
+func TestLeaseVote(t *testing.T) {
+	n := setupNode(t)
+	n.config.LeaderLease = time.Minute
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       &raft.Node{Id: "lease-vote-test:0", ClientAddr: "localhost:8089"},
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+
+	// a candidate is refused while this node has heard from the leader within
+	// the lease, without adopting its term...
+	term := n.Term
+	request := &raft.VoteRequest{
+		Term:         term + 1,
+		Candidate:    &raft.Node{Id: "lease-vote-test:1"},
+		LastLogIndex: -1,
+		LastLogTerm:  0}
+	reply := n.HandleVote(request)
+	if reply.VoteGranted || reply.DenyReason != raft.VoteReply_LEADER_LEASE || n.Term != term {
+		t.Errorf("Expected the vote to be refused as LEADER_LEASE in term %d, got %v (term %d)",
+			term, reply, n.Term)
+	}
+
+	// ...unless the leader asked it to stand
+	request.Transfer = true
+	if reply := n.HandleVote(request); !reply.VoteGranted {
+		t.Errorf("Expected a vote for a transfer candidate, got %v", reply)
+	}
+}
+
+func TestLinearizableGet(t *testing.T) {
+	n := setupNode(t)
+	if _, err := n.LinearizableGet("key"); err != ErrNotLeaderRecv {
+		t.Errorf("Expected %v on a follower, got %v", ErrNotLeaderRecv, err)
+	}
+
+	n.State = Leader
+	n.Term = 1
+	client := &toggleAppendClient{ack: 1}
+	for i := 1; i <= 2; i++ {
+		n.peers.add(fmt.Sprintf("read-index-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+
+	// a leader that has not committed an entry in its term commits a NOOP
+	// entry first
+	if value, err := n.LinearizableGet("key"); err != nil || value != "" {
+		t.Errorf("Expected an empty value, got %q (%v)", value, err)
+	}
+	if last := n.LastLogIndex(); last != 0 || n.Log.Entries[0].Action != raft.LogRecord_NOOP {
+		t.Fatalf("Expected a NOOP entry, got last index %d", last)
+	}
+	if n.CommitIndex != 0 || n.LastApplied != 0 {
+		t.Errorf("Expected the NOOP entry to be committed and applied, got %d/%d",
+			n.CommitIndex, n.LastApplied)
+	}
+
+	// once it has, reads only confirm leadership
+	if err := n.Set(context.Background(), "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+	if value, err := n.LinearizableGet("key"); err != nil || value != "value" {
+		t.Errorf("Expected to read the write, got %q (%v)", value, err)
+	}
+	if last := n.LastLogIndex(); last != 1 {
+		t.Errorf("Expected no more NOOP entries, got last index %d", last)
+	}
+
+	// without a majority, leadership can't be confirmed
+	atomic.StoreInt32(&client.ack, 0)
+	if _, err := n.LinearizableGet("key"); err == nil {
+		t.Error("Expected a read without a majority to fail")
+	}
+
+	// a draining leader still commits a NOOP entry to serve reads in a new
+	// term, though it takes no writes
+	atomic.StoreInt32(&client.ack, 1)
+	if err := n.Drain(false); err != nil {
+		t.Fatalf("Error draining: %v", err)
+	}
+	n.Term = 2
+	if value, err := n.LinearizableGet("key"); err != nil || value != "value" {
+		t.Errorf("Expected to read on a draining leader, got %q (%v)", value, err)
+	}
+	if last := n.LastLogIndex(); last != 2 || n.Log.Entries[2].Action != raft.LogRecord_NOOP {
+		t.Errorf("Expected a NOOP entry in the new term, got last index %d", last)
+	}
+	if err := n.Set(context.Background(), "key", "other"); err != ErrDraining {
+		t.Errorf("Expected ErrDraining for a write, got %v", err)
+	}
+	n.Lock()
+	err := n.applyRecord(context.Background(), &raft.LogRecord{Action: raft.LogRecord_NOOP})
+	n.Unlock()
+	if err != ErrDraining {
+		t.Errorf("Expected ErrDraining for a NOOP entry not written by the node, got %v", err)
+	}
+}
+
+func TestAutoPromote(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.AutoPromoteAfter = 50 * time.Millisecond
+	client := &toggleAppendClient{ack: 1}
+	for i := 1; i <= 2; i++ {
+		n.peers.add(fmt.Sprintf("promote-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+	host := "promote-test:3"
+	lagging := &toggleAppendClient{}
+	learner := &ForeignNode{
+		Client:     lagging,
+		MatchIndex: -1,
+		Available:  true,
+		learner:    true,
+		standing:   true,
+		bootstrap:  &BootstrapStatus{Member: host, Phase: BootstrapCaughtUp}}
+	n.peers.add(host, learner)
+	var promoted []string
+	n.AddEventListener(func(e Event) {
+		if e.Type == EventMemberPromoted {
+			promoted = append(promoted, e.Node)
+		}
+	})
+	ctx := context.Background()
+	if err := n.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Error in write: %v", err)
+	}
+
+	// a learner that is behind is not promoted, however long it has been a
+	// learner
+	n.ApplyMembershipPolicy(ctx)
+	time.Sleep(n.config.AutoPromoteAfter)
+	n.ApplyMembershipPolicy(ctx)
+	if !learner.isLearner() {
+		t.Fatal("Expected a learner that is behind not to be promoted")
+	}
+
+	// once caught up, it is promoted after keeping up for the window
+	atomic.StoreInt32(&lagging.ack, 1)
+	n.Lock()
+	learner.MatchIndex = n.CommitIndex
+	n.Unlock()
+	n.ApplyMembershipPolicy(ctx)
+	if !learner.isLearner() {
+		t.Error("Expected the learner not to be promoted before keeping up for the window")
+	}
+	time.Sleep(n.config.AutoPromoteAfter)
+	n.ApplyMembershipPolicy(ctx)
+	if learner.isLearner() || len(promoted) != 1 || promoted[0] != host {
+		t.Errorf("Expected the learner to be promoted, got events for %v", promoted)
+	}
+	if members := n.Members(); len(members) != 4 {
+		t.Errorf("Expected the promoted learner to be a member, got %v", members)
+	}
+}
+
+func TestAutoDemote(t *testing.T) {
+	n := setupNode(t)
+	n.State = Leader
+	n.config.AutoDemoteFlaps = 3
+	client := &toggleAppendClient{ack: 1}
+	for i := 1; i <= 3; i++ {
+		n.peers.add(fmt.Sprintf("demote-test:%d", i), &ForeignNode{
+			Client:     client,
+			MatchIndex: -1,
+			Available:  true})
+	}
+	var demoted []string
+	n.AddEventListener(func(e Event) {
+		if e.Type == EventMemberDemoted {
+			demoted = append(demoted, e.Node)
+		}
+	})
+	flap := func(host string, times int) {
+		for i := 0; i < times; i++ {
+			n.setAvailable(host, i%2 == 1)
+		}
+	}
+	ctx := context.Background()
+
+	// a voter is demoted once its availability changes often enough within
+	// the window
+	flaky := "demote-test:1"
+	flap(flaky, 2)
+	n.ApplyMembershipPolicy(ctx)
+	if n.peers.get(flaky).isLearner() {
+		t.Fatal("Expected a voter that changed availability twice not to be demoted")
+	}
+	flap(flaky, 1)
+	n.ApplyMembershipPolicy(ctx)
+	if !n.peers.get(flaky).isLearner() || len(demoted) != 1 || demoted[0] != flaky {
+		t.Fatalf("Expected the flapping voter to be demoted, got events for %v", demoted)
+	}
+	if members := n.Members(); len(members) != 3 {
+		t.Errorf("Expected the demoted voter to be left out of the members, got %v", members)
+	}
+
+	// a demotion does not leave fewer than three voters
+	flap("demote-test:2", 3)
+	n.ApplyMembershipPolicy(ctx)
+	if n.peers.get("demote-test:2").isLearner() || len(demoted) != 1 {
+		t.Errorf("Expected no demotion leaving two voters, got events for %v", demoted)
 	}
 }
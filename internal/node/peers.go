@@ -0,0 +1,285 @@
+package node
+
+// The other members of the cluster are held in a peerSet, which is
+// copy-on-write: each change to membership replaces the map of members, so the
+// election and replication loops can take a snapshot of the members on every
+// pass without copying or locking, and look a member up by address (getting nil
+// once it has been removed) rather than indexing a shared map. Each member has
+// its own send queue, drained by a fixed number of goroutines (the per-peer
+// limit on append requests in flight), which are stopped, along with the
+// connection, when the member is removed.
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+var (
+	// ErrPeerRemoved indicates that a request was for a member that has been
+	// removed from the cluster (or was never added)
+	ErrPeerRemoved = errors.New("Peer is not a member of the cluster")
+)
+
+// A sendJob is a request queued to be sent to a peer by one of its senders
+type sendJob struct {
+	ctx  context.Context
+	send func(ctx context.Context) error
+	done chan error
+}
+
+// start starts the goroutines that send the requests queued for the peer--one
+// for each request allowed in flight at once, or none if workers is not
+// positive, in which case each request is sent from its own goroutine
+func (f *ForeignNode) start(workers int) {
+	f.stopped = make(chan struct{})
+	if workers <= 0 {
+		return
+	}
+	// a job takes a slot before it is queued, so the queue never blocks, and
+	// frees it once sent
+	f.slots = newSemaphore(workers)
+	f.queue = make(chan *sendJob, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-f.queue:
+					// a request whose caller has given up while it was
+					// queued is not sent
+					if err := job.ctx.Err(); err != nil {
+						job.done <- err
+					} else {
+						job.done <- job.send(job.ctx)
+					}
+					releaseSemaphore(f.slots)
+				case <-f.stopped:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// submit hands a request to one of the peer's senders and waits for it to be
+// sent. If every sender is busy, the request is dropped and ErrAppendInFlight
+// is returned--each append carries every entry the peer has not acknowledged,
+// so a later one will catch it up
+func (f *ForeignNode) submit(ctx context.Context, send func(ctx context.Context) error) error {
+	job := &sendJob{ctx: ctx, send: send, done: make(chan error, 1)}
+	if f.queue == nil {
+		go func() {
+			job.done <- send(ctx)
+		}()
+	} else {
+		if !trySemaphore(f.slots) {
+			return ErrAppendInFlight
+		}
+		f.queue <- job
+	}
+	select {
+	case err := <-job.done:
+		return err
+	case <-ctx.Done():
+		// a queued request is skipped, and one being sent is cancelled
+		return ctx.Err()
+	case <-f.stopped:
+		// the send is abandoned along with the connection
+		return ErrPeerRemoved
+	}
+}
+
+// client returns the gRPC client for the peer's current connection
+func (f *ForeignNode) client() raft.RaftClient {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.Client
+}
+
+// matchIndex returns the index of the last entry the peer is known to have
+func (f *ForeignNode) matchIndex() int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.MatchIndex
+}
+
+// setMatchIndex records the index of the last entry the peer is known to
+// have, so entries after it are sent next. It is used to rewind the peer after
+// it rejects an append; progress is recorded with maybeAdvanceMatch
+func (f *ForeignNode) setMatchIndex(index int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.MatchIndex = index
+	f.NextIndex = index + 1
+}
+
+// maybeAdvanceMatch records that the peer has every entry up to index, unless
+// it is already known to have a later one: replies to requests sent in
+// parallel can arrive out of order, and the reply to a shorter request must not
+// move the match index back
+func (f *ForeignNode) maybeAdvanceMatch(index int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if index > f.MatchIndex {
+		f.MatchIndex = index
+		f.NextIndex = index + 1
+	}
+}
+
+// available reports whether the peer answered the last request sent to it
+func (f *ForeignNode) available() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.Available
+}
+
+// setAvailable records whether the peer answered the last request sent to
+// it, and reports whether that changed
+func (f *ForeignNode) setAvailable(available bool) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	changed := f.Available != available
+	f.Available = available
+	return changed
+}
+
+// reconnect replaces the connection to the peer with a new one to address,
+// e.g. after its address has moved to another host
+func (f *ForeignNode) reconnect(address string) error {
+	replacement, err := NewForeignNode(address, f.dialOptions...)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	old := f.Connection
+	f.Connection, f.Client = replacement.Connection, replacement.Client
+	f.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// stop stops the peer's senders (each once it has finished the request it is
+// sending, if any) and closes its connection. Callers waiting on requests get
+// ErrPeerRemoved
+func (f *ForeignNode) stop() {
+	close(f.stopped)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.Connection != nil {
+		f.Connection.Close()
+	}
+}
+
+// peerProgress is the progress and version a peer reported in its last reply
+type peerProgress struct {
+	commitIndex int64
+	lastApplied int64
+	version     string
+}
+
+// reportedProgress returns the progress the peer last reported, or nil if it
+// has not reported any
+func (f *ForeignNode) reportedProgress() *peerProgress {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.progress == nil {
+		return nil
+	}
+	progress := *f.progress
+	return &progress
+}
+
+// observeProgress records the progress and version reported by the peer at
+// host in a reply. Replies from versions that don't report them (with an empty
+// version) are ignored. A peer that runs a different version from this node is
+// logged when first seen, to make mixed versions visible during upgrades
+func (n *Node) observeProgress(host string, peer *ForeignNode, commitIndex int64, lastApplied int64, version string) {
+	if version == "" {
+		return
+	}
+	peer.lock.Lock()
+	changed := peer.progress == nil || peer.progress.version != version
+	peer.progress = &peerProgress{commitIndex: commitIndex, lastApplied: lastApplied, version: version}
+	peer.lock.Unlock()
+	if changed && version != n.config.Version {
+		logger.Warn().
+			Str("peer", host).
+			Str("version", version).
+			Str("localVersion", n.config.Version).
+			Msg("Peer runs a different version")
+	}
+}
+
+// A peerSet is the set of other members of the cluster, keyed by address. The
+// map held in members is never modified--changes store a new one
+type peerSet struct {
+	lock    sync.Mutex // serializes changes
+	members atomic.Value
+	workers int
+}
+
+// newPeerSet makes an empty peerSet, whose members each have workers senders
+func newPeerSet(workers int) *peerSet {
+	p := &peerSet{workers: workers}
+	p.members.Store(make(map[string]*ForeignNode))
+	return p
+}
+
+// get returns the member at addr, or nil if there is none
+func (p *peerSet) get(addr string) *ForeignNode {
+	return p.snapshot()[addr]
+}
+
+// snapshot returns the current set of members, which is not affected by later
+// changes to the set. It must not be modified
+func (p *peerSet) snapshot() map[string]*ForeignNode {
+	return p.members.Load().(map[string]*ForeignNode)
+}
+
+// update stores a copy of the set of members, changed by fn. p.lock must be
+// held
+func (p *peerSet) update(fn func(map[string]*ForeignNode)) {
+	current := p.snapshot()
+	next := make(map[string]*ForeignNode, len(current)+1)
+	for addr, peer := range current {
+		next[addr] = peer
+	}
+	fn(next)
+	p.members.Store(next)
+}
+
+// add starts peer's senders and adds it as the member at addr. It returns
+// false, leaving the set unchanged, if there is already a member at addr
+func (p *peerSet) add(addr string, peer *ForeignNode) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.snapshot()[addr]; ok {
+		return false
+	}
+	peer.start(p.workers)
+	p.update(func(members map[string]*ForeignNode) {
+		members[addr] = peer
+	})
+	return true
+}
+
+// remove removes the member at addr, stopping its senders and closing its
+// connection, and returns it (nil if there was none)
+func (p *peerSet) remove(addr string) *ForeignNode {
+	p.lock.Lock()
+	peer, ok := p.snapshot()[addr]
+	if ok {
+		p.update(func(members map[string]*ForeignNode) {
+			delete(members, addr)
+		})
+	}
+	p.lock.Unlock()
+	if ok {
+		peer.stop()
+	}
+	return peer
+}
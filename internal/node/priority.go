@@ -0,0 +1,126 @@
+package node
+
+// Clients can mark each request with a priority, so that bulk work such as an
+// import can share a cluster with interactive traffic without starving it.
+// Priority affects the two places where writes wait for each other: the budget
+// for pending writes (see admitProposal), where batch writes may only use part
+// of the budget, so that normal writes always have room; and the queue for the
+// write lock, where a write waits while writes of a higher priority are
+// queued, so that a steady stream of batch writes can't keep a normal write
+// waiting behind it. Within a priority, writes are served in the order the
+// lock grants them, as before. System priority is for operators and the node's
+// own maintenance, and is neither limited by the budget nor shed under load.
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Priority is how urgently a request should be served relative to others
+type Priority string
+
+// Request priorities, from highest to lowest
+const (
+	// PrioritySystem is for administrative writes, such as changes of policy
+	PrioritySystem Priority = "system"
+	// PriorityNormal is for interactive traffic (the default)
+	PriorityNormal Priority = "normal"
+	// PriorityBatch is for bulk work, such as imports
+	PriorityBatch Priority = "batch"
+)
+
+// batchProposalShare is the divisor of the pending write budget that batch
+// writes may use
+const batchProposalShare = 2
+
+var (
+	// ErrInvalidPriority indicates that a client requested an unknown priority
+	ErrInvalidPriority = errors.New("Priority must be system, normal, or batch")
+)
+
+// ParsePriority returns the priority named by s, or the default
+// (PriorityNormal) if s is empty
+func ParsePriority(s string) (Priority, error) {
+	switch Priority(s) {
+	case "":
+		return PriorityNormal, nil
+	case PrioritySystem, PriorityNormal, PriorityBatch:
+		return Priority(s), nil
+	}
+	return "", ErrInvalidPriority
+}
+
+// rank orders priorities from highest (0) to lowest
+func (p Priority) rank() int {
+	switch p {
+	case PrioritySystem:
+		return 0
+	case PriorityBatch:
+		return 2
+	}
+	return 1
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying a request priority, for use with
+// the write methods of Node (which otherwise use PriorityNormal)
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// RequestPriority returns the priority carried by ctx, or the default. Writes
+// of system keys by the node itself have PrioritySystem
+func RequestPriority(ctx context.Context) Priority {
+	if isSystemWrite(ctx) {
+		return PrioritySystem
+	}
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// A writeQueue holds writes back from the write lock while writes of a higher
+// priority are waiting for it
+type writeQueue struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	waiting [3]int
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// enter queues a write of the given priority, and returns once no write of a
+// higher priority is queued. The write stays queued until leave is called
+func (q *writeQueue) enter(priority Priority) {
+	rank := priority.rank()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.waiting[rank]++
+	for q.ahead(rank) {
+		q.cond.Wait()
+	}
+}
+
+// ahead reports whether writes of a higher priority than rank are queued
+func (q *writeQueue) ahead(rank int) bool {
+	for r := 0; r < rank; r++ {
+		if q.waiting[r] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// leave removes a write queued by enter, once it holds the write lock
+func (q *writeQueue) leave(priority Priority) {
+	q.lock.Lock()
+	q.waiting[priority.rank()]--
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,283 @@
+package node
+
+// Each write is appended to the log on its own and committed by a round of
+// append requests of its own, so many concurrent writes cost a round each.
+// With proposal batching (NodeConfig.ProposalBatchEntries above 1), sets and
+// deletes proposed at about the same time are appended together and committed
+// by one round (group commit). A write joins the open batch of its priority,
+// or opens one and waits up to the batch's delay for others to join--less if
+// the batch fills up, reaching its limit on entries or bytes. The write that
+// opened the batch then appends every entry in it, checking each as a write
+// on its own would be checked, and waits for them to be committed; each write
+// returns as it would have if appended alone. Batching trades that delay for
+// fewer, larger rounds: it raises throughput under many concurrent writes,
+// and only adds latency to a write that no others join. The limits can be
+// changed while the node runs (see SetProposalBatching).
+//
+// Other writes (batches, transactions, locks, and so on), whose outcome is
+// read back from the database once applied, are appended one at a time.
+// Namespace limits on the number of keys are checked against the database as
+// it was before the batch, so may be overshot by the writes of one batch.
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// ErrInvalidBatching indicates proposal batching limits that are negative
+var ErrInvalidBatching = errors.New("Proposal batching limits must not be negative")
+
+// Sizes of the batches of writes appended together, in entries and bytes
+var proposalBatchSize = metrics.NewHistogram(
+	"leifdb_proposal_batch_size",
+	"Writes appended to the log together as one batch, in entries and in bytes of keys and values",
+	"unit",
+	[]float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 1024, 4096, 16384, 65536, 262144, 1 << 20, 4 << 20})
+
+// ProposalBatching is how writes proposed at about the same time are batched
+type ProposalBatching struct {
+	// Longest the first write of a batch waits for others to join, in
+	// microseconds
+	MaxDelayMicros int64 `json:"maxDelayMicros"`
+	// Most writes in a batch (1 or less disables batching)
+	MaxEntries int `json:"maxEntries"`
+	// Most bytes of keys and values in a batch (0 means unlimited). A write
+	// larger than this still makes a batch of its own
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// validate returns ErrInvalidBatching if any limit of b is negative
+func (b ProposalBatching) validate() error {
+	if b.MaxDelayMicros < 0 || b.MaxEntries < 0 || b.MaxBytes < 0 {
+		return ErrInvalidBatching
+	}
+	return nil
+}
+
+// A proposal is a write waiting in a batch
+type proposal struct {
+	ctx    context.Context
+	record *raft.LogRecord
+	// index of the record's entry, and the error it got
+	index int64
+	err   error
+}
+
+// A proposalBatch is a set of writes appended together
+type proposalBatch struct {
+	proposals []*proposal
+	bytes     int64
+	// closed when the batch is full, when it is appended (or no entry in it
+	// could be), and when its entries are committed (or fail to be)
+	full     chan struct{}
+	appended chan struct{}
+	done     chan struct{}
+	term     int64
+}
+
+// proposalBatcher holds the limits on batches, and the open batch of each
+// priority
+type proposalBatcher struct {
+	lock     sync.Mutex
+	settings ProposalBatching
+	open     map[Priority]*proposalBatch
+}
+
+// ProposalBatching returns the current limits on batches of writes
+func (n *Node) ProposalBatching() ProposalBatching {
+	n.proposals.lock.Lock()
+	defer n.proposals.lock.Unlock()
+	return n.proposals.settings
+}
+
+// SetProposalBatching changes the limits on batches of writes, for batches
+// opened from now on. The change lasts until the node restarts
+func (n *Node) SetProposalBatching(settings ProposalBatching) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	logger.Info().
+		Int64("maxDelayMicros", settings.MaxDelayMicros).
+		Int("maxEntries", settings.MaxEntries).
+		Int64("maxBytes", settings.MaxBytes).
+		Msg("Proposal batching changed")
+	n.proposals.lock.Lock()
+	defer n.proposals.lock.Unlock()
+	n.proposals.settings = settings
+	return nil
+}
+
+// propose appends record to the log, as part of a batch if batching is
+// enabled, and returns as applyRecord does
+func (n *Node) propose(ctx context.Context, record *raft.LogRecord) error {
+	priority := RequestPriority(ctx)
+	size := int64(len(record.Key) + len(record.Value))
+	p := &proposal{ctx: ctx, record: record}
+
+	n.proposals.lock.Lock()
+	settings := n.proposals.settings
+	if settings.MaxEntries <= 1 {
+		n.proposals.lock.Unlock()
+		n.lockWrite(ctx)
+		defer n.Unlock()
+		return n.applyRecord(ctx, record)
+	}
+	if n.proposals.open == nil {
+		n.proposals.open = map[Priority]*proposalBatch{}
+	}
+	b, joined := n.proposals.open[priority]
+	if !joined {
+		b = &proposalBatch{
+			full:     make(chan struct{}),
+			appended: make(chan struct{}),
+			done:     make(chan struct{})}
+		n.proposals.open[priority] = b
+	}
+	b.proposals = append(b.proposals, p)
+	b.bytes += size
+	if len(b.proposals) >= settings.MaxEntries ||
+		(settings.MaxBytes > 0 && b.bytes >= settings.MaxBytes) {
+		// no more writes join a full batch
+		delete(n.proposals.open, priority)
+		close(b.full)
+	}
+	n.proposals.lock.Unlock()
+
+	if !joined {
+		// the batch is appended by the write that opened it, but not
+		// cancelled with its context
+		timer := time.NewTimer(time.Duration(settings.MaxDelayMicros) * time.Microsecond)
+		select {
+		case <-b.full:
+		case <-timer.C:
+			n.proposals.lock.Lock()
+			if n.proposals.open[priority] == b {
+				delete(n.proposals.open, priority)
+			}
+			n.proposals.lock.Unlock()
+		}
+		timer.Stop()
+		go n.appendBatch(WithPriority(context.Background(), priority), b)
+	}
+
+	select {
+	case <-b.done:
+		if p.err == nil {
+			recordWrite(ctx, p.index, b.term)
+		}
+		return p.err
+	case <-ctx.Done():
+	}
+	// the entry may already be in the log, or be about to be, so wait until
+	// it is appended (or is not, having been checked after ctx was done)
+	<-b.appended
+	if p.err != nil || p.index == 0 {
+		return p.err
+	}
+	if writeConcern(ctx) == WriteConcernLeader {
+		recordWrite(ctx, p.index, b.term)
+		return nil
+	}
+	countDeadline(ctx.Err(), deadlineCommit)
+	return &CommitUncertainError{Index: p.index, Term: b.term, Err: ctx.Err()}
+}
+
+// appendBatch appends the writes in b that pass the checks made of every
+// write, and waits for them to be committed (and applied by a majority, if
+// any of them asks for that). ctx carries the priority of the batch
+func (n *Node) appendBatch(ctx context.Context, b *proposalBatch) {
+	defer close(b.done)
+	n.lockWrite(ctx)
+	defer n.Unlock()
+
+	records := []*raft.LogRecord{}
+	appended := []*proposal{}
+	bytes := int64(0)
+	for _, p := range b.proposals {
+		if p.err = n.checkProposal(p.ctx, p.record); p.err != nil {
+			continue
+		}
+		stampRecord(p.ctx, p.record)
+		records = append(records, p.record)
+		appended = append(appended, p)
+		bytes += int64(len(p.record.Key) + len(p.record.Value))
+	}
+	if len(appended) == 0 {
+		close(b.appended)
+		return
+	}
+	proposalBatchSize.Observe("entries", float64(len(appended)))
+	proposalBatchSize.Observe("bytes", float64(bytes))
+
+	persistStart := time.Now()
+	idx, term, err := n.appendLocal(records...)
+	if err != nil {
+		logger.Error().Err(err).Msg("appendBatch: Error setting log")
+		for _, p := range appended {
+			p.err, p.index = err, 0
+		}
+		close(b.appended)
+		return
+	}
+	b.term = term
+	for i, p := range appended {
+		p.index = idx - int64(len(appended)-1-i)
+	}
+	observePhase("persist", persistStart)
+	logger.Debug().
+		Str("requestIds", requestIDs(records)).
+		Int64("index", idx).
+		Int("entries", len(appended)).
+		Msg("Appended batch of entries")
+	close(b.appended)
+
+	waiting, confirm := []*proposal{}, false
+	for _, p := range appended {
+		switch writeConcern(p.ctx) {
+		case WriteConcernLeader:
+			// the entry is shipped with the next round of append requests
+		case WriteConcernApply:
+			confirm = true
+			fallthrough
+		default:
+			waiting = append(waiting, p)
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+	// the batch stops waiting once every write waiting on it has given up
+	ctxs := make([]context.Context, 0, len(waiting))
+	for _, p := range waiting {
+		ctxs = append(ctxs, p.ctx)
+	}
+	ctx, cancel := untilAllDone(ctx, ctxs)
+	defer cancel()
+	commitStart := time.Now()
+	if err := n.awaitCommit(ctx, idx, b.term); err != nil {
+		for _, p := range waiting {
+			// each write is reported as uncertain at its own index
+			var uncertain *CommitUncertainError
+			if errors.As(err, &uncertain) {
+				p.err = &CommitUncertainError{Index: p.index, Term: b.term, Err: uncertain.Err}
+			} else {
+				p.err = err
+			}
+		}
+		return
+	}
+	observePhase("commit", commitStart)
+	logger.Debug().Int64("index", idx).Msg("Committed batch of entries")
+	if confirm {
+		err := n.confirmApplied(ctx, b.term)
+		for _, p := range waiting {
+			if writeConcern(p.ctx) == WriteConcernApply {
+				p.err = err
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package node
+
+// A node whose state can no longer be trusted--because a file failed to load,
+// or because the database it has built by applying the log does not match the
+// leader's--is put in quarantine. It keeps its place in the cluster and keeps
+// receiving entries from the leader, but it does not vote or stand for
+// election, and does not serve reads, until it is reseeded from a snapshot of
+// the leader's database.
+//
+// Divergence is detected by comparing applied hashes: each node keeps a
+// running hash of every entry it has applied, which the leader sends with its
+// append requests. A hash of 0 means "not known" (e.g. from a node that was
+// restored from a snapshot taken before hashes were recorded), and is never
+// compared.
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+const (
+	// quarantineFile records the reason a node is quarantined, so that it is
+	// still quarantined after restarting
+	quarantineFile = "quarantine"
+	// recentHashCount is the number of recent applied hashes kept to compare
+	// with the leader's
+	recentHashCount = 1024
+)
+
+var (
+	// ErrQuarantined indicates that a client attempted to read from a node
+	// whose state is suspected to be corrupt or divergent (see Quarantine)
+	ErrQuarantined = errors.New("Node is quarantined until it is reseeded")
+
+	// ErrReseedLeader indicates that a reseed was requested on the leader,
+	// which has no other node to copy its state from
+	ErrReseedLeader = errors.New("The leader cannot be reseeded")
+)
+
+var quarantinedGauge = metrics.NewGauge(
+	"leifdb_quarantined",
+	"1 if this node is quarantined, otherwise 0")
+
+// appliedHashAt is the applied hash after the entry at index was applied
+type appliedHashAt struct {
+	index int64
+	hash  uint64
+}
+
+// emptyHash is the applied hash of a node that has applied no entries
+func emptyHash() uint64 {
+	return fnv.New64a().Sum64()
+}
+
+// nextHash returns the applied hash after entry is applied to a node with the
+// applied hash prev
+func nextHash(prev uint64, entry *raft.LogRecord) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], prev)
+	h.Write(buf[:])
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to marshal entry for applied hash")
+	}
+	h.Write(data)
+	// 0 is reserved for "not known"
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// AppliedHash returns the index of the last entry reflected in the node's
+// applied hash, and the hash (0 if it is not known)
+func (n *Node) AppliedHash() (int64, uint64) {
+	if !n.hashValid {
+		return n.hashIndex, 0
+	}
+	return n.hashIndex, n.appliedHash
+}
+
+// SetAppliedHash sets the applied hash as of the entry at index, e.g. after
+// restoring a snapshot. A hash of 0 marks it as not known, which disables
+// divergence checks until the node is reseeded
+func (n *Node) SetAppliedHash(index int64, hash uint64) {
+	n.hashIndex = index
+	n.appliedHash = hash
+	n.hashValid = hash != 0
+	n.recentHashes = make([]appliedHashAt, recentHashCount)
+	n.expectedHash = appliedHashAt{index: -1}
+}
+
+// recordAppliedHash updates the applied hash after the entry at index has been
+// applied, and compares it with the leader's hash for that entry, if the leader
+// sent one before this node got to it
+func (n *Node) recordAppliedHash(index int64, entry *raft.LogRecord) {
+	if !n.hashValid {
+		return
+	}
+	n.appliedHash = nextHash(n.appliedHash, entry)
+	n.hashIndex = index
+	n.recentHashes[index%recentHashCount] = appliedHashAt{index: index, hash: n.appliedHash}
+	if n.expectedHash.index == index {
+		n.compareHash(index, n.appliedHash, n.expectedHash.hash)
+	}
+}
+
+// checkAppliedHash compares the applied hash sent by the leader, as of the
+// entry at index, with this node's. If this node has not applied that entry
+// yet, the leader's hash is kept until it does. There is nothing to compare
+// before the leader has applied an entry
+func (n *Node) checkAppliedHash(index int64, hash uint64) {
+	if index < 0 || hash == 0 || !n.hashValid || n.IsWitness() || n.Quarantined() {
+		return
+	}
+	if index > n.hashIndex {
+		// keep the first hash this node is waiting for, so that a steady
+		// stream of later ones does not keep replacing it
+		if n.expectedHash.index <= n.hashIndex {
+			n.expectedHash = appliedHashAt{index: index, hash: hash}
+		}
+		return
+	}
+	recent := n.recentHashes[index%recentHashCount]
+	if recent.index == index && recent.hash != 0 {
+		n.compareHash(index, recent.hash, hash)
+	}
+}
+
+// compareHash quarantines the node if its applied hash as of the entry at
+// index differs from the leader's
+func (n *Node) compareHash(index int64, local uint64, leader uint64) {
+	if local == leader {
+		return
+	}
+	logger.Error().
+		Int64("index", index).
+		Uint64("local", local).
+		Uint64("leader", leader).
+		Msg("Applied hash does not match the leader's")
+	reason := fmt.Sprintf("Applied state at entry %d does not match the leader's", index)
+	if n.recordQuarantine(reason) {
+		n.stepDownQuarantined()
+		n.emit(EventQuarantined, n.RaftNode.Id)
+	}
+}
+
+// Quarantine stops the node from voting, standing for election, and serving
+// reads, because its state is suspected to be corrupt or divergent. It remains
+// a member of the cluster and keeps replicating the log. The quarantine is
+// persisted, so that it survives a restart, and is lifted by Reseed
+func (n *Node) Quarantine(reason string) {
+	if n.recordQuarantine(reason) {
+		n.process(n.stepDownQuarantined)
+		n.emit(EventQuarantined, n.RaftNode.Id)
+	}
+}
+
+// recordQuarantine records and persists the reason for a quarantine, and
+// reports whether the node was not already quarantined
+func (n *Node) recordQuarantine(reason string) bool {
+	n.proposalLock.Lock()
+	already := n.quarantine != ""
+	n.quarantine = reason
+	n.proposalLock.Unlock()
+	if already {
+		return false
+	}
+
+	logger.Error().Str("reason", reason).Msg("Quarantining node")
+	quarantinedGauge.Set(1)
+	filename := filepath.Join(n.config.DataDir, quarantineFile)
+	if err := ioutil.WriteFile(filename, []byte(reason+"\n"), 0644); err != nil {
+		logger.Error().Err(err).Msg("Failed to persist quarantine")
+	}
+	return true
+}
+
+// stepDownQuarantined steps down if the node is the leader, on the event loop
+func (n *Node) stepDownQuarantined() {
+	if n.State == Leader {
+		n.resetElectionTimer()
+		n.AllowVote = true
+		n.currentLeader = nil
+	}
+}
+
+// Quarantined reports whether the node is quarantined (see Quarantine)
+func (n *Node) Quarantined() bool {
+	return n.QuarantineReason() != ""
+}
+
+// QuarantineReason returns why the node is quarantined, or an empty string if
+// it is not
+func (n *Node) QuarantineReason() string {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	return n.quarantine
+}
+
+// liftQuarantine clears the quarantine, including the persisted record of it
+func (n *Node) liftQuarantine() {
+	n.proposalLock.Lock()
+	n.quarantine = ""
+	n.proposalLock.Unlock()
+	quarantinedGauge.Set(0)
+	err := os.Remove(filepath.Join(n.config.DataDir, quarantineFile))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error().Err(err).Msg("Failed to remove quarantine record")
+	}
+}
+
+// readQuarantine returns the reason recorded for a quarantine in dataDir, or
+// an empty string if there is none
+func readQuarantine(dataDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, quarantineFile))
+	if err != nil {
+		return ""
+	}
+	reason := strings.TrimSpace(string(data))
+	if reason == "" {
+		reason = "Quarantined before restart"
+	}
+	return reason
+}
+
+// Reseed replaces the node's state with a snapshot of the leader's database,
+// covering entries up to lastApplied (whose term is lastTerm), and with the
+// applied hash hash. The log is emptied, so the leader sends every entry after
+// the snapshot again, and the node serves reads once it has caught up. Any
+// quarantine is lifted
+func (n *Node) Reseed(store *db.Database, lastApplied int64, lastTerm int64, hash uint64) error {
+	n.Lock()
+	defer n.Unlock()
+	var err error
+	n.process(func() {
+		err = n.reseed(store, lastApplied, lastTerm, hash)
+	})
+	return err
+}
+
+// reseed replaces the node's state with a snapshot, on the event loop
+func (n *Node) reseed(store *db.Database, lastApplied int64, lastTerm int64, hash uint64) error {
+	if n.State == Leader {
+		return ErrReseedLeader
+	}
+
+	logStore := &raft.LogStore{
+		Entries:   make([]*raft.LogRecord, 0, 0),
+		BaseIndex: lastApplied + 1,
+		BaseTerm:  lastTerm}
+	if err := n.replaceLog(logStore); err != nil {
+		return err
+	}
+	logger.Info().
+		Int64("lastApplied", lastApplied).
+		Int64("lastTerm", lastTerm).
+		Int("keys", store.Len()).
+		Msg("Reseeded from snapshot")
+	n.Store = store
+	n.reset(lastApplied)
+	n.SetAppliedHash(lastApplied, hash)
+	n.restoreMembers()
+	n.notifyApplied()
+	n.caughtUp = false
+	n.hasCatchUpTarget = false
+	n.liftQuarantine()
+	return nil
+}
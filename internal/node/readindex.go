@@ -0,0 +1,171 @@
+package node
+
+// Each read barrier (ReadBarrier) confirms leadership with a round of append
+// requests, so a burst of linearizable reads costs a round per read. With a
+// read index window (NodeConfig.ReadIndexWindow), a barrier is answered
+// without a round of its own if leadership was confirmed by a round started
+// within the window--any round acknowledged by a majority, including
+// heartbeats--or joins a round already in flight that started within it. No
+// other leader can have been elected since that round started unless an
+// election completed within the window, which is bounded by the heartbeat
+// interval and so much shorter than the election timeout. The index returned
+// is still the commit index as of the barrier, so it covers every write
+// acknowledged before the barrier was requested.
+//
+// LinearizableGet serves reads by the ReadIndex protocol from section 6.4 of
+// the Raft thesis: the leader takes its commit index as the read index,
+// confirms its leadership with a read barrier, and reads once it has applied
+// the read index. A new leader's commit index may not yet cover entries
+// committed by earlier leaders, which it can only commit along with an entry
+// of its own term, so a leader that has not committed one yet first commits a
+// NOOP entry--even while draining, since the entry changes no data.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/btmorr/leifdb/retry"
+)
+
+// Ways a read barrier confirms leadership
+const (
+	readIndexRound  = "round"
+	readIndexShared = "shared"
+	readIndexCached = "cached"
+)
+
+var readIndexRequests = metrics.NewCounterVec(
+	"leifdb_read_index_total",
+	"Read barriers, by how leadership was confirmed: a round of their own, a round shared with other barriers, or a round confirmed within the read index window",
+	"source")
+
+// A readRound is a round of append requests confirming leadership, which
+// barriers arriving while it is in flight can wait for instead of sending
+// their own
+type readRound struct {
+	term  int64
+	start time.Time
+	done  chan struct{}
+	err   error
+}
+
+// readRounds holds the round in flight, if any
+type readRounds struct {
+	lock    sync.Mutex
+	pending *readRound
+}
+
+// confirmLeadership completes a round of append requests in term, or returns
+// ctx.Err() if ctx is done first
+func (n *Node) confirmLeadership(ctx context.Context, term int64) error {
+	window := n.config.ReadIndexWindow
+	if window <= 0 {
+		readIndexRequests.Inc(readIndexRound)
+		done := make(chan error, 1)
+		go func() {
+			done <- n.SendAppend(withHedging(ctx, hedgeReadIndex), retry.Once, term)
+		}()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-done:
+			return err
+		}
+	}
+
+	if age := n.leaseAge(term); age > 0 && time.Duration(age) < window && n.LeaseReadsAllowed() {
+		readIndexRequests.Inc(readIndexCached)
+		return nil
+	}
+	now := time.Now()
+	n.readRounds.lock.Lock()
+	r := n.readRounds.pending
+	if r == nil || r.term != term || now.Sub(r.start) >= window {
+		readIndexRequests.Inc(readIndexRound)
+		r = &readRound{term: term, start: now, done: make(chan struct{})}
+		n.readRounds.pending = r
+		// the round is shared, so it is not cancelled with the context of
+		// the barrier that started it
+		go func() {
+			r.err = n.SendAppend(withHedging(context.Background(), hedgeReadIndex), retry.Once, term)
+			n.readRounds.lock.Lock()
+			if n.readRounds.pending == r {
+				n.readRounds.pending = nil
+			}
+			n.readRounds.lock.Unlock()
+			close(r.done)
+		}()
+	} else {
+		readIndexRequests.Inc(readIndexShared)
+	}
+	n.readRounds.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.done:
+		return r.err
+	}
+}
+
+// committedInTerm reports whether this node has committed an entry in term
+func (n *Node) committedInTerm(term int64) bool {
+	committed, ok := n.LogTerm(n.Committed())
+	return ok && committed == term
+}
+
+// commitNoop commits a NOOP entry in term, unless an entry of term has been
+// committed in the meantime
+func (n *Node) commitNoop(ctx context.Context, term int64) error {
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.leaderIn(term) {
+		return ErrNotLeaderRecv
+	}
+	if n.committedInTerm(term) {
+		return nil
+	}
+	logger.Info().Int64("term", term).Msg("Committing an entry in the term")
+	record := &raft.LogRecord{Term: term, Action: raft.LogRecord_NOOP}
+	return n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+}
+
+// ReadIndex returns the index this node, which must be the leader, must apply
+// before serving a linearizable read: its commit index, once it has committed
+// an entry in its term (committing a NOOP entry first, if needed) and
+// confirmed its leadership with a read barrier
+func (n *Node) ReadIndex(ctx context.Context) (int64, error) {
+	state, term := n.CurrentRole()
+	if state != Leader {
+		return -1, ErrNotLeaderRecv
+	}
+	if !n.committedInTerm(term) {
+		if err := n.commitNoop(ctx, term); err != nil {
+			return -1, err
+		}
+	}
+	return n.ReadBarrier(ctx)
+}
+
+// LinearizableGet returns the value of key by the ReadIndex protocol, which is
+// only possible on the leader, so that it reflects every write acknowledged
+// before the call. It gives up after the commit timeout (see NodeConfig)
+func (n *Node) LinearizableGet(key string) (string, error) {
+	ctx := context.Background()
+	if timeout := n.config.CommitTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	readIndex, err := n.ReadIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+	if _, err := n.WaitForIndex(ctx, readIndex); err != nil {
+		return "", err
+	}
+	return n.Store.Get(key), nil
+}
@@ -0,0 +1,138 @@
+package node
+
+// Writes can be disabled for maintenance, such as a migration or verifying a
+// backup, while reads continue to be served. Read-only mode is either set on
+// a single node, which is local to it and lasts until it is cleared or the
+// node restarts, or on the whole cluster, by appending a READ_ONLY entry to
+// the log--so that every member (including any later leader) applies it, and
+// it is kept in snapshots. Rejected writes get a ReadOnlyError. Expiry of keys
+// is not a client write, and carries on in read-only mode.
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// ReadOnlyScope is what read-only mode was set on: a node or the cluster
+type ReadOnlyScope string
+
+// Scopes of read-only mode
+const (
+	ReadOnlyNode    ReadOnlyScope = "node"
+	ReadOnlyCluster ReadOnlyScope = "cluster"
+)
+
+// DefaultReadOnlyReason is the reason given for read-only mode when none is
+const DefaultReadOnlyReason = "maintenance"
+
+// ErrReadOnly matches (with errors.Is) every ReadOnlyError
+var ErrReadOnly = errors.New("Writes are disabled")
+
+// A ReadOnlyError reports that a write was rejected because the node or the
+// cluster is in read-only mode
+type ReadOnlyError struct {
+	Scope ReadOnlyScope
+	// Reason given when read-only mode was set
+	Reason string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s: %s is read-only (%s)", ErrReadOnly, e.Scope, e.Reason)
+}
+
+// Is reports whether target is ErrReadOnly
+func (e *ReadOnlyError) Is(target error) bool {
+	return target == ErrReadOnly
+}
+
+// A ReadOnlyStatus reports whether writes are disabled on a node, and why
+type ReadOnlyStatus struct {
+	// Whether writes are disabled on this node alone
+	Node       bool   `json:"node"`
+	NodeReason string `json:"nodeReason,omitempty"`
+	// Whether writes are disabled on the whole cluster (as of the last entry
+	// applied by this node)
+	Cluster       bool   `json:"cluster"`
+	ClusterReason string `json:"clusterReason,omitempty"`
+}
+
+// SetReadOnly disables writes on this node (which fail with a ReadOnlyError)
+// for the reason given, until ClearReadOnly is called or the node restarts
+func (n *Node) SetReadOnly(reason string) {
+	if reason == "" {
+		reason = DefaultReadOnlyReason
+	}
+	logger.Info().Str("reason", reason).Msg("Node is read-only")
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	n.readOnly = reason
+}
+
+// ClearReadOnly reverses SetReadOnly
+func (n *Node) ClearReadOnly() {
+	logger.Info().Msg("Node accepts writes again")
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	n.readOnly = ""
+}
+
+// SetClusterReadOnly appends an entry disabling writes on every member of the
+// cluster, for the reason given, and returns once it is committed (or an error
+// is generated). Writes stay disabled, across restarts and changes of leader,
+// until ClearClusterReadOnly
+func (n *Node) SetClusterReadOnly(ctx context.Context, reason string) error {
+	if reason == "" {
+		reason = DefaultReadOnlyReason
+	}
+	return n.setClusterReadOnly(ctx, reason)
+}
+
+// ClearClusterReadOnly appends an entry enabling writes on the cluster again,
+// and returns once it is committed (or an error is generated)
+func (n *Node) ClearClusterReadOnly(ctx context.Context) error {
+	return n.setClusterReadOnly(ctx, "")
+}
+
+func (n *Node) setClusterReadOnly(ctx context.Context, reason string) error {
+	logger.Info().Str("reason", reason).Msg("SetClusterReadOnly")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_READ_ONLY,
+		Value:  reason,
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	return n.applyRecord(ctx, record)
+}
+
+// ReadOnly reports whether writes are disabled on this node or the cluster
+func (n *Node) ReadOnly() ReadOnlyStatus {
+	n.proposalLock.Lock()
+	nodeReason := n.readOnly
+	n.proposalLock.Unlock()
+	clusterReason := n.Store.ReadOnly()
+	return ReadOnlyStatus{
+		Node:          nodeReason != "",
+		NodeReason:    nodeReason,
+		Cluster:       clusterReason != "",
+		ClusterReason: clusterReason}
+}
+
+// checkWritable returns a ReadOnlyError if record may not be written because
+// the node or the cluster is read-only
+func (n *Node) checkWritable(record *raft.LogRecord) error {
+	switch record.Action {
+	case raft.LogRecord_READ_ONLY, raft.LogRecord_EXPIRE, raft.LogRecord_MEMBERS,
+		raft.LogRecord_NOOP:
+		return nil
+	}
+	status := n.ReadOnly()
+	if status.Cluster {
+		return &ReadOnlyError{Scope: ReadOnlyCluster, Reason: status.ClusterReason}
+	}
+	if status.Node {
+		return &ReadOnlyError{Scope: ReadOnlyNode, Reason: status.NodeReason}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package node
+
+// A client can't rename or copy a key safely by reading it and writing
+// another: a write to the key between the two is lost (or, for a rename,
+// resurrected). RENAME and COPY entries do both in the state machine instead,
+// as of the time the leader appended the entry. The key written takes the
+// value and the expiry time of the key read, and is only overwritten if the
+// entry says to replace it (see database.Rename).
+import (
+	"context"
+	"errors"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+var (
+	// ErrNoSuchKey indicates a rename or copy of a key that has no value
+	ErrNoSuchKey = errors.New("Key has no value")
+
+	// ErrInvalidRename indicates a rename or copy without a key to read or a
+	// key to write
+	ErrInvalidRename = errors.New("Keys to rename or copy from and to must be non-empty")
+)
+
+// Rename appends an entry moving the value of from (and its expiry time) to
+// to, and returns once it is applied: ErrNoSuchKey if from had no value, or
+// ErrKeyExists if to had one and replace is false. The outcome is only known
+// once the entry is applied, so Rename waits for that even with
+// WriteConcernLeader
+func (n *Node) Rename(ctx context.Context, from string, to string, replace bool) error {
+	logger.Info().Str("from", from).Str("to", to).Bool("replace", replace).Msg("Rename")
+	return n.moveKey(ctx, raft.LogRecord_RENAME, from, to, replace)
+}
+
+// Copy appends an entry copying the value of from (and its expiry time) to
+// to, and returns as Rename does
+func (n *Node) Copy(ctx context.Context, from string, to string, replace bool) error {
+	logger.Info().Str("from", from).Str("to", to).Bool("replace", replace).Msg("Copy")
+	return n.moveKey(ctx, raft.LogRecord_COPY, from, to, replace)
+}
+
+// moveKey appends a RENAME or COPY entry, and returns its outcome once it is
+// applied
+func (n *Node) moveKey(
+	ctx context.Context,
+	action raft.LogRecord_Action,
+	from string,
+	to string,
+	replace bool) error {
+
+	if from == "" || to == "" {
+		return ErrInvalidRename
+	}
+	record := &raft.LogRecord{
+		Action:  action,
+		Key:     from,
+		Value:   to,
+		Replace: replace,
+	}
+	applied, err := n.applyForOutcome(ctx, record)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !applied[0]:
+		return ErrNoSuchKey
+	case !applied[1]:
+		return ErrKeyExists
+	}
+	return nil
+}
+
+// applyForOutcome appends an entry whose outcome is only known once it is
+// applied, and returns the outcome (see BatchApplied). It waits for the entry
+// to be applied even with WriteConcernLeader
+func (n *Node) applyForOutcome(ctx context.Context, record *raft.LogRecord) ([]bool, error) {
+	proposal := int64(len(record.Key) + len(record.Value))
+	if err := n.admitProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+	defer n.releaseProposal(proposal)
+
+	if writeConcern(ctx) == WriteConcernLeader {
+		ctx = WithWriteConcern(ctx, WriteConcernMajority)
+	}
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	idx := n.LastLogIndex() + 1
+	if err := n.applyRecord(ctx, record); err != nil {
+		return nil, err
+	}
+	if n.batchIndex != idx {
+		// the leader lost its leadership before applying the entry
+		return nil, ErrCommitFailed
+	}
+	return n.batchApplied, nil
+}
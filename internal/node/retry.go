@@ -0,0 +1,62 @@
+package node
+
+// Retry policies used by the node (see the retry package, and NodeConfig).
+// Rounds of append requests that miss a majority are retried immediately by
+// default, since a missed round usually means one slow or restarting peer.
+// Connections to peers are re-established by gRPC, which waits between
+// attempts as set by the reconnect policy (its MaxAttempts is not used: a
+// member is reconnected to for as long as it is in the cluster)
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+
+	"github.com/btmorr/leifdb/retry"
+)
+
+var (
+	// DefaultAppendRetry makes up to 3 more rounds of append requests when a
+	// round misses a majority
+	DefaultAppendRetry = retry.Policy{MaxAttempts: 4, Retryable: appendRetryable}
+
+	// DefaultReconnectRetry matches gRPC's default connection backoff
+	DefaultReconnectRetry = retry.Policy{
+		BaseDelay:  backoff.DefaultConfig.BaseDelay,
+		MaxDelay:   backoff.DefaultConfig.MaxDelay,
+		Multiplier: backoff.DefaultConfig.Multiplier,
+		Jitter:     backoff.DefaultConfig.Jitter,
+	}
+)
+
+// minConnectTimeout is the time allowed for each attempt to connect to a peer
+// (gRPC's default)
+const minConnectTimeout = 20 * time.Second
+
+// appendRetryable reports whether a round of append requests that failed with
+// err is worth retrying: a round that missed a majority may reach one next
+// time, but a node that is no longer the leader can't send appends
+func appendRetryable(err error) bool {
+	return err == ErrAppendFailed
+}
+
+// peerDialOptions returns the options for connections to peers, which are
+// re-established with the delays of policy
+func peerDialOptions(policy retry.Policy) []grpc.DialOption {
+	config := backoff.DefaultConfig
+	if policy.BaseDelay > 0 {
+		config.BaseDelay = policy.BaseDelay
+	}
+	if policy.MaxDelay > 0 {
+		config.MaxDelay = policy.MaxDelay
+	}
+	if policy.Multiplier > 0 {
+		config.Multiplier = policy.Multiplier
+	}
+	config.Jitter = policy.Jitter
+	return []grpc.DialOption{grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           config,
+		MinConnectTimeout: minConnectTimeout,
+	})}
+}
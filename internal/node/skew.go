@@ -0,0 +1,102 @@
+package node
+
+// Lease reads--read barriers answered within the read index window (see
+// readindex.go), linearizable reads within the leader lease (see lease.go),
+// and bounded-staleness reads (see staleness.go)--trust that time passes at
+// about the same rate on every member, and key expiry and lock leases that the
+// members agree on what time it is. Raft does not check either, so the leader
+// estimates how far each peer's clock is from its own, from the time the peer
+// reports in each reply to an append request (heartbeats included): the peer's
+// time should fall halfway between the request being sent and the reply
+// arriving, give or take half the round trip. A clock that far off is
+// misbehaving (e.g. it has stopped syncing, or jumped), so while any peer is
+// off by more than NodeConfig.MaxClockSkew, the leader stops taking lease
+// reads: read barriers and linearizable reads take a round of their own, and
+// append requests no longer carry the lease age, so followers stop serving
+// bounded-staleness reads once their data is older than the bound. Lease reads
+// resume once every peer is back within the bound.
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/metrics"
+)
+
+// DefaultMaxClockSkew is the default for NodeConfig.MaxClockSkew
+const DefaultMaxClockSkew = 500 * time.Millisecond
+
+// EventClockSkew is emitted when a peer's clock is found to be off from the
+// leader's by more than the configured bound, and lease reads are disabled
+const EventClockSkew EventType = "clock_skew"
+
+var (
+	peerClockOffset = metrics.NewGaugeVec(
+		"leifdb_peer_clock_offset_seconds",
+		"Estimated offset of each peer's clock from the leader's (positive if the peer's is ahead), from its replies to append requests",
+		"peer")
+
+	leaseReadsDisabled = metrics.NewGauge(
+		"leifdb_lease_reads_disabled",
+		"1 while lease reads are disabled because a peer's clock is off by more than the configured bound, otherwise 0")
+)
+
+// clockOffset returns the estimated offset of the peer's clock from this
+// node's, and false if there is no estimate
+func (f *ForeignNode) clockOffset() (time.Duration, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.offset, f.offsetKnown
+}
+
+// observeClock records the offset of the clock of the peer at host, from
+// peerTime, the time (in unix nanoseconds) it reported in its reply to a
+// request sent at sent and answered at received. Replies from versions that
+// don't report the time (0) are ignored
+func (n *Node) observeClock(host string, peer *ForeignNode, sent time.Time, received time.Time, peerTime int64) {
+	if peerTime == 0 {
+		return
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	offset := time.Unix(0, peerTime).Sub(midpoint)
+	peer.lock.Lock()
+	peer.offset, peer.offsetKnown = offset, true
+	peer.lock.Unlock()
+	peerClockOffset.Set(host, offset.Seconds())
+	n.checkClockSkew()
+}
+
+// checkClockSkew disables lease reads if any peer's clock is off by more
+// than NodeConfig.MaxClockSkew, and enables them again once none is
+func (n *Node) checkClockSkew() {
+	bound := n.config.MaxClockSkew
+	skewed, worst := "", time.Duration(0)
+	for addr, peer := range n.peers.snapshot() {
+		offset, ok := peer.clockOffset()
+		if offset < 0 {
+			offset = -offset
+		}
+		if ok && bound > 0 && offset > bound && offset > worst {
+			skewed, worst = addr, offset
+		}
+	}
+	if skewed != "" {
+		if atomic.CompareAndSwapInt32(&n.clockSkewed, 0, 1) {
+			leaseReadsDisabled.Set(1)
+			logger.Warn().
+				Str("peer", skewed).
+				Dur("offset", worst).
+				Dur("bound", bound).
+				Msg("Peer clock skewed beyond bound, disabling lease reads")
+			n.emit(EventClockSkew, skewed)
+		}
+	} else if atomic.CompareAndSwapInt32(&n.clockSkewed, 1, 0) {
+		leaseReadsDisabled.Set(0)
+		logger.Info().Msg("Peer clocks back within bound, enabling lease reads")
+	}
+}
+
+// LeaseReadsAllowed reports whether this node takes lease reads, which it
+// does unless a peer's clock is off by more than NodeConfig.MaxClockSkew
+func (n *Node) LeaseReadsAllowed() bool {
+	return atomic.LoadInt32(&n.clockSkewed) == 0
+}
@@ -0,0 +1,82 @@
+package node
+
+// Readers that can tolerate slightly stale data (such as caches) can read
+// from any node whose data is known to be current as of a recent enough time,
+// spreading load away from the leader. The leader's data is current as of the
+// start of its last round of append requests acknowledged by a majority (its
+// lease)--no other leader can have committed anything since. The leader sends
+// the age of its lease with each append request, so a follower that has
+// applied everything the leader had committed is current as of the time the
+// lease was taken. The follower's estimate is low by the time the request
+// spent in flight, which is small next to the bounds readers are expected to
+// use.
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTooStale indicates that a read bounded in staleness was made to a
+	// node whose data may be older than the bound
+	ErrTooStale = errors.New("Node's data may be staler than the read allows")
+)
+
+// renewLease records that a round of append requests started at start was
+// acknowledged by a majority in term
+func (n *Node) renewLease(term int64, start time.Time) {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	if term != n.leaseTerm || start.After(n.leaseStart) {
+		n.leaseTerm, n.leaseStart = term, start
+	}
+}
+
+// leaseAge returns the age in nanoseconds of the lease in term, or 0 if there
+// is none
+func (n *Node) leaseAge(term int64) int64 {
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	if n.leaseTerm != term || n.leaseStart.IsZero() {
+		return 0
+	}
+	if age := int64(time.Since(n.leaseStart)); age > 0 {
+		return age
+	}
+	return 1
+}
+
+// markFresh records that this node has applied everything committed by a
+// leader whose lease was leaseAge nanoseconds old (0 if unknown)
+func (n *Node) markFresh(leaseAge int64) {
+	if leaseAge <= 0 {
+		return
+	}
+	asOf := time.Now().Add(-time.Duration(leaseAge))
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	if asOf.After(n.freshAsOf) {
+		n.freshAsOf = asOf
+	}
+}
+
+// Staleness returns how old this node's data may be: the time since the data
+// was last known to be current. It returns false if that is not known, e.g.
+// before a follower has heard from a leader with a lease, or on a leader that
+// has disabled lease reads (see skew.go)
+func (n *Node) Staleness() (time.Duration, bool) {
+	// read before taking the lock, which steps on the event loop also take
+	state, term := n.CurrentRole()
+	n.proposalLock.Lock()
+	defer n.proposalLock.Unlock()
+	asOf := n.freshAsOf
+	if state == Leader {
+		if n.leaseTerm != term || n.leaseStart.IsZero() || !n.LeaseReadsAllowed() {
+			return 0, false
+		}
+		asOf = n.leaseStart
+	}
+	if asOf.IsZero() {
+		return 0, false
+	}
+	return time.Since(asOf), true
+}
@@ -0,0 +1,113 @@
+package node
+
+// A node's state is in two parts, as in the Raft paper. PersistentState--the
+// current term, the vote cast in it, and the log--must survive a restart, so
+// each change to it is written to disk before it takes effect: the node only
+// replies to a request (granting a vote, or acknowledging entries) with state
+// it can't lose in a crash, and if the write fails, the state is unchanged and
+// the request is refused. VolatileState--the commit and applied indexes--is
+// rebuilt after a restart, and only moves forward, except when a snapshot
+// replaces the database. The leader's volatile state, the next and match
+// index of each peer, is kept with the peer (see ForeignNode).
+//
+// State changes go through the transition methods below rather than by
+// setting the fields, so that these rules hold everywhere. They are made on
+// the node's event loop (see loop.go). The commit and applied indexes, and the
+// term, are read by goroutines other than the loop (such as callers of
+// WaitForIndex, or listeners for events), so they are written atomically, and
+// read with Committed, Applied, and currentTerm.
+import (
+	"sync/atomic"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// A persister writes a node's persistent state to disk. A Node persists to the
+// files set in its NodeConfig
+type persister interface {
+	writeTerm(*raft.TermRecord) error
+	writeLogs(*raft.LogStore) error
+}
+
+// PersistentState is the state a node keeps across restarts
+type PersistentState struct {
+	Term     int64
+	votedFor *raft.Node
+	Log      *raft.LogStore
+	// where changes are written before they take effect
+	persist persister
+}
+
+// setTerm persists term and vote, then adopts them. If they can't be written,
+// the state is left unchanged and the error (a PersistError) is returned
+func (p *PersistentState) setTerm(term int64, vote *raft.Node) error {
+	if err := p.persist.writeTerm(&raft.TermRecord{Term: term, VotedFor: vote}); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&p.Term, term)
+	p.votedFor = vote
+	return nil
+}
+
+// currentTerm returns the current term, from any goroutine
+func (p *PersistentState) currentTerm() int64 {
+	return atomic.LoadInt64(&p.Term)
+}
+
+// replaceLog persists log, then adopts it. If it can't be written, the state is
+// left unchanged and the error (a PersistError) is returned
+func (p *PersistentState) replaceLog(log *raft.LogStore) error {
+	if err := p.persist.writeLogs(log); err != nil {
+		return err
+	}
+	p.Log = log
+	return nil
+}
+
+// VolatileState is the state a node rebuilds after a restart
+type VolatileState struct {
+	// index of the last entry known to be committed
+	CommitIndex int64
+	// index of the last entry applied to the database (at most CommitIndex)
+	LastApplied int64
+}
+
+// Committed returns the index of the last entry known to be committed
+func (v *VolatileState) Committed() int64 {
+	return atomic.LoadInt64(&v.CommitIndex)
+}
+
+// Applied returns the index of the last entry applied to the database
+func (v *VolatileState) Applied() int64 {
+	return atomic.LoadInt64(&v.LastApplied)
+}
+
+// commitThrough advances the commit index to index, and reports whether it
+// moved (it never moves back)
+func (v *VolatileState) commitThrough(index int64) bool {
+	if index <= v.Committed() {
+		return false
+	}
+	atomic.StoreInt64(&v.CommitIndex, index)
+	return true
+}
+
+// markApplied records that the entry at index is applied. Entries are applied
+// in order, and only once committed (see applyThrough)
+func (v *VolatileState) markApplied(index int64) {
+	atomic.StoreInt64(&v.LastApplied, index)
+}
+
+// restoreApplied records that a snapshot covering every entry through index
+// has replaced the database. Those entries are committed
+func (v *VolatileState) restoreApplied(index int64) {
+	v.markApplied(index)
+	v.commitThrough(index)
+}
+
+// reset records that a snapshot covering every entry through index has
+// replaced the database and the log, so the node starts over from there
+func (v *VolatileState) reset(index int64) {
+	atomic.StoreInt64(&v.CommitIndex, index)
+	atomic.StoreInt64(&v.LastApplied, index)
+}
@@ -0,0 +1,158 @@
+package node
+
+// With NodeConfig.PublishTopology set, the leader publishes the membership of
+// the cluster and its own identity to a system key (TopologyKey) whenever it
+// is elected or the membership changes, so sidecars and load balancers can
+// find the leader and members from any node (GET /topology), or from a file:
+// with NodeConfig.TopologyFile set, each node writes the topology to a local
+// JSON file whenever it applies a new version of the key (witnesses apply no
+// entries, so do not write it). The
+// topology is only as current as the last entry applied by the node reading
+// it, and only lists the members known to the leader that published it.
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// TopologyKey is the system key holding the topology published by the leader
+const TopologyKey = SystemKeyPrefix + "topology"
+
+// Topology is the membership of the cluster, as published by its leader
+type Topology struct {
+	// Raft address of the leader
+	Leader string `json:"leader"`
+	// Client (HTTP) address of the leader
+	LeaderClientAddr string `json:"leaderClientAddr"`
+	// Term in which the leader published the topology
+	Term int64 `json:"term"`
+	// Every member, including the leader, sorted by id
+	Members []TopologyMember `json:"members"`
+}
+
+// TopologyMember is a member of the cluster in a Topology
+type TopologyMember struct {
+	// Raft address of the member
+	Id string `json:"id"`
+	// Whether the member is being bootstrapped (see AddMember)
+	Learner bool `json:"learner,omitempty"`
+}
+
+// Topology returns the topology last published by a leader, as of the last
+// entry applied by this node, and whether one has been published
+func (n *Node) Topology() (Topology, bool) {
+	var topology Topology
+	if !n.Store.Exists(TopologyKey) {
+		return topology, false
+	}
+	if err := json.Unmarshal([]byte(n.Store.Get(TopologyKey)), &topology); err != nil {
+		logger.Error().Err(err).Msg("Invalid topology")
+		return topology, false
+	}
+	return topology, true
+}
+
+// currentTopology returns the topology of the cluster as this node (the
+// leader) knows it
+func (n *Node) currentTopology() Topology {
+	members := []TopologyMember{{Id: n.RaftNode.Id}}
+	for _, peer := range n.Peers() {
+		members = append(members, TopologyMember{Id: peer.Id, Learner: peer.Learner})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Id < members[j].Id })
+	return Topology{
+		Leader:           n.RaftNode.Id,
+		LeaderClientAddr: n.RaftNode.ClientAddr,
+		Term:             n.currentTerm(),
+		Members:          members}
+}
+
+// PublishTopology appends an entry setting TopologyKey to the current
+// topology, if it has changed, and returns once it is committed (or an error
+// is generated)
+func (n *Node) PublishTopology(ctx context.Context) error {
+	n.lockWrite(ctx)
+	defer n.Unlock()
+	if !n.IsLeader() {
+		return ErrNotLeaderRecv
+	}
+	value, err := json.Marshal(n.currentTopology())
+	if err != nil {
+		return err
+	}
+	if n.Store.Get(TopologyKey) == string(value) {
+		return nil
+	}
+	logger.Info().Str("topology", string(value)).Msg("PublishTopology")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_SET,
+		Key:    TopologyKey,
+		Value:  string(value),
+	}
+	return n.applyRecord(context.WithValue(ctx, systemWriteKey{}, true), record)
+}
+
+// publishTopologyOn is an EventListener that publishes the topology when this
+// node is elected leader, or the membership changes while it is the leader
+func (n *Node) publishTopologyOn(e Event) {
+	switch e.Type {
+	case EventLeaderChange:
+		if e.Node != n.RaftNode.Id {
+			return
+		}
+	case EventMemberAdded, EventMemberRemoved, EventMemberPromoted, EventMemberDemoted:
+	default:
+		return
+	}
+	// listeners may be called on the event loop
+	go func() {
+		err := n.PublishTopology(context.Background())
+		if err != nil && err != ErrNotLeaderRecv {
+			logger.Warn().Err(err).Msg("Failed to publish topology")
+		}
+	}()
+}
+
+// writeTopologyOn is an ApplyHook that writes the topology file when an entry
+// setting TopologyKey is applied
+func (n *Node) writeTopologyOn(_ int64, entry *raft.LogRecord, _ error) {
+	if entry.Action == raft.LogRecord_SET && entry.Key == TopologyKey {
+		n.writeTopologyFile(entry.Value)
+	}
+}
+
+// writeTopologyFile replaces the topology file with value (as indented JSON),
+// through a temporary file so readers never see part of it
+func (n *Node) writeTopologyFile(value string) {
+	filename := n.config.TopologyFile
+	var topology Topology
+	if err := json.Unmarshal([]byte(value), &topology); err != nil {
+		logger.Error().Err(err).Msg("Invalid topology")
+		return
+	}
+	data, _ := json.MarshalIndent(topology, "", "  ")
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".topology-")
+	if err == nil {
+		_, err = tmp.Write(append(data, '\n'))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filename)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("file", filename).Msg("Failed to write topology file")
+	}
+}
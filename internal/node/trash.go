@@ -0,0 +1,102 @@
+package node
+
+// With a restore window set (NodeConfig.TrashTTL), deleting a key moves its
+// value to the trash--a system key (under trashPrefix) that expires once the
+// window has passed--rather than dropping it, so that a key deleted by mistake
+// can be restored. The leader records when the trashed value expires in the
+// DEL entry, so every replica keeps it for the same time, and a DEL entry
+// without an expiry deletes the value outright (as every DEL did before). A
+// RESTORE entry moves the value back, unless the key has been written again
+// since. Both treat keys as expired as of the time the leader appended the
+// entry. Only deletes of single keys are trashed: the deletes of batches and
+// transactions, and keys that expire, are not.
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/raft"
+)
+
+// trashPrefix starts the system key holding the trashed value of each key
+const trashPrefix = SystemKeyPrefix + "trash/"
+
+var (
+	// ErrNotInTrash indicates a key with no value in the trash to restore
+	ErrNotInTrash = errors.New("Key has no value in the trash")
+
+	// ErrKeyExists indicates a key that can't be restored because it has been
+	// written since it was deleted
+	ErrKeyExists = errors.New("Key has a value, so it can't be restored from the trash")
+)
+
+// A TrashedValue is the value of a deleted key, kept in the trash
+type TrashedValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// When the value is removed from the trash for good, in Unix nanoseconds
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// TrashKey returns the system key holding the trashed value of key
+func TrashKey(key string) string {
+	return trashPrefix + key
+}
+
+// Trashed returns the value of key in the trash, as of the last entry applied
+// by this node, and whether there is one
+func (n *Node) Trashed(key string) (TrashedValue, bool) {
+	trashKey := TrashKey(key)
+	if !n.Store.Exists(trashKey) {
+		return TrashedValue{}, false
+	}
+	expiresAt, _ := n.Store.Expiry(trashKey)
+	return TrashedValue{Key: key, Value: n.Store.Get(trashKey), ExpiresAt: expiresAt}, true
+}
+
+// Trash returns every value in the trash, as of the last entry applied by this
+// node, sorted by key
+func (n *Node) Trash() []TrashedValue {
+	values := []TrashedValue{}
+	cursor := n.Store.PrefixCursor(trashPrefix)
+	for trashKey, _, ok := cursor.Next(); ok; trashKey, _, ok = cursor.Next() {
+		if value, found := n.Trashed(strings.TrimPrefix(trashKey, trashPrefix)); found {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// trashExpiry returns when a value deleted now by a client is removed from the
+// trash, or 0 if deleted values are not trashed
+func (n *Node) trashExpiry(ctx context.Context) int64 {
+	if n.config.TrashTTL <= 0 || isSystemWrite(ctx) {
+		return 0
+	}
+	return time.Now().Add(n.config.TrashTTL).UnixNano()
+}
+
+// RestoreKey appends an entry moving the value of key back out of the trash,
+// and returns once it is applied: ErrNotInTrash if there was no value to
+// restore, or ErrKeyExists if the key had been written since it was deleted.
+// The outcome is only known once the entry is applied, so RestoreKey waits for
+// that even with WriteConcernLeader
+func (n *Node) RestoreKey(ctx context.Context, key string) error {
+	logger.Info().Str("key", key).Msg("RestoreKey")
+	record := &raft.LogRecord{
+		Action: raft.LogRecord_RESTORE,
+		Key:    key,
+	}
+	applied, err := n.applyForOutcome(ctx, record)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !applied[0]:
+		return ErrNotInTrash
+	case !applied[1]:
+		return ErrKeyExists
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package node
+
+// Before a node starts, the state persisted in its data directory is checked
+// for consistency: the term file, the terms of the entries in the log, the
+// latest snapshot, and the cluster the directory belongs to. A node started
+// from inconsistent state--say, with a term file that was lost or restored
+// from an older backup, so that the node could vote a second time in a term
+// it has already voted in--can elect a second leader and lose committed
+// writes, so the server refuses to start unless told to recover anyway.
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// clusterIdFile records the id of the cluster a data directory belongs to
+const clusterIdFile = "cluster_id"
+
+// ErrInconsistentState matches (with errors.Is) every StateError
+var ErrInconsistentState = errors.New("Persisted state is inconsistent")
+
+// A StateError reports the inconsistencies found in a node's persisted state
+type StateError struct {
+	DataDir  string
+	Problems []string
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("%s in %s: %s",
+		ErrInconsistentState, e.DataDir, strings.Join(e.Problems, "; "))
+}
+
+// Is reports whether target is ErrInconsistentState
+func (e *StateError) Is(target error) bool {
+	return target == ErrInconsistentState
+}
+
+// SnapshotMeta describes the latest snapshot in a node's data directory, for
+// CheckState
+type SnapshotMeta struct {
+	File string
+	// Whether the snapshot has a manifest, without which LastApplied and
+	// LastTerm are not known
+	HasManifest bool
+	LastApplied int64
+	LastTerm    int64
+}
+
+// CheckState returns the inconsistencies between the term file, log, latest
+// snapshot (nil if there is none), and cluster id in the data directory of
+// config, each described along with what can be done about it. A log that
+// can't be loaded is not reported, as the node is quarantined instead
+func CheckState(config NodeConfig, snapshot *SnapshotMeta) []string {
+	problems := []string{}
+	_, statErr := os.Stat(config.TermFile)
+	hasTermFile := statErr == nil
+	termRecord, err := readTermFile(config.TermFile)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf(
+			"term file %s can't be read (%v); restore it from a backup", config.TermFile, err))
+	}
+	term := termRecord.Term
+
+	if logStore, err := loadLogs(config.LogFile); err == nil {
+		last := lastIndex(logStore)
+		hasState := last >= 0 || snapshot != nil
+		if hasState && !hasTermFile {
+			problems = append(problems, fmt.Sprintf(
+				"term file %s is missing, but the log or a snapshot is not empty, so votes cast before it was lost are forgotten; restore it from a backup",
+				config.TermFile))
+		}
+		prev := logStore.BaseTerm
+		for i, entry := range logStore.Entries {
+			if entry.Term < prev {
+				problems = append(problems, fmt.Sprintf(
+					"log entry %d is from term %d, before the entry ahead of it (term %d); reseed the node",
+					logStore.BaseIndex+int64(i), entry.Term, prev))
+				break
+			}
+			prev = entry.Term
+		}
+		if lastTerm, _ := termAt(logStore, last); hasTermFile && lastTerm > term {
+			problems = append(problems, fmt.Sprintf(
+				"last log entry is from term %d, after term %d in the term file, which is probably out of date; restore it from a backup",
+				lastTerm, term))
+		}
+
+		if snapshot == nil && logStore.BaseIndex > 0 {
+			problems = append(problems, fmt.Sprintf(
+				"log starts after entry %d, but there is no snapshot of the entries before it; restore the snapshot or reseed the node",
+				logStore.BaseIndex-1))
+		}
+		if snapshot != nil && snapshot.HasManifest {
+			if hasTermFile && snapshot.LastTerm > term {
+				problems = append(problems, fmt.Sprintf(
+					"snapshot %s covers term %d, after term %d in the term file; restore the term file from a backup",
+					snapshot.File, snapshot.LastTerm, term))
+			}
+			if snapshot.LastApplied < logStore.BaseIndex-1 {
+				problems = append(problems, fmt.Sprintf(
+					"log starts after entry %d, but snapshot %s only covers entries up to %d; restore a later snapshot or reseed the node",
+					logStore.BaseIndex-1, snapshot.File, snapshot.LastApplied))
+			} else if snapshot.LastApplied > last {
+				problems = append(problems, fmt.Sprintf(
+					"snapshot %s covers entries up to %d, but the log ends at %d; restore the log from a backup or reseed the node",
+					snapshot.File, snapshot.LastApplied, last))
+			} else if logTerm, ok := termAt(logStore, snapshot.LastApplied); ok && logTerm != snapshot.LastTerm {
+				problems = append(problems, fmt.Sprintf(
+					"snapshot %s ends with an entry from term %d, but entry %d in the log is from term %d; reseed the node",
+					snapshot.File, snapshot.LastTerm, snapshot.LastApplied, logTerm))
+			}
+		}
+	}
+
+	if config.ClusterId != "" {
+		if id := readClusterId(config.DataDir); id != "" && id != config.ClusterId {
+			problems = append(problems, fmt.Sprintf(
+				"data directory belongs to cluster %q, not %q; check the data directory and cluster id",
+				id, config.ClusterId))
+		}
+	}
+	return problems
+}
+
+// ValidateState returns a StateError if CheckState finds any inconsistencies
+func ValidateState(config NodeConfig, snapshot *SnapshotMeta) error {
+	if problems := CheckState(config, snapshot); len(problems) > 0 {
+		return &StateError{DataDir: config.DataDir, Problems: problems}
+	}
+	return nil
+}
+
+// readClusterId returns the cluster id recorded in dataDir, or an empty string
+// if there is none
+func readClusterId(dataDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, clusterIdFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeClusterId records id as the cluster that dataDir belongs to
+func writeClusterId(dataDir string, id string) error {
+	if readClusterId(dataDir) == id {
+		return nil
+	}
+	filename := filepath.Join(dataDir, clusterIdFile)
+	if err := ioutil.WriteFile(filename, []byte(id+"\n"), 0644); err != nil {
+		return &PersistError{Op: "write", File: filename, Err: err}
+	}
+	return nil
+}
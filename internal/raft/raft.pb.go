@@ -25,22 +25,163 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+// why the vote was not granted (NONE if it was)
+type VoteReply_DenyReason int32
+
+const (
+	VoteReply_NONE          VoteReply_DenyReason = 0
+	VoteReply_STALE_TERM    VoteReply_DenyReason = 1 // the candidate's term is behind the voter's
+	VoteReply_LOG_BEHIND    VoteReply_DenyReason = 2 // the candidate's log is missing committed entries
+	VoteReply_LEADER_GRACE  VoteReply_DenyReason = 3 // the voter's leader was elected too recently
+	VoteReply_UNKNOWN_NODE  VoteReply_DenyReason = 4 // the candidate is not a member of the voter's cluster
+	VoteReply_ALREADY_VOTED VoteReply_DenyReason = 5 // the voter voted for another candidate in the term
+	VoteReply_QUARANTINED   VoteReply_DenyReason = 6 // the voter is quarantined (see Node.Quarantine)
+	VoteReply_NOT_PERSISTED VoteReply_DenyReason = 7 // the voter failed to persist its vote
+	VoteReply_ISOLATED      VoteReply_DenyReason = 8 // the voter has isolated the candidate (see Node.Isolate)
+	VoteReply_LEADER_LEASE  VoteReply_DenyReason = 9 // the voter has heard from its leader within the leader lease
+)
+
+// Enum value maps for VoteReply_DenyReason.
+var (
+	VoteReply_DenyReason_name = map[int32]string{
+		0: "NONE",
+		1: "STALE_TERM",
+		2: "LOG_BEHIND",
+		3: "LEADER_GRACE",
+		4: "UNKNOWN_NODE",
+		5: "ALREADY_VOTED",
+		6: "QUARANTINED",
+		7: "NOT_PERSISTED",
+		8: "ISOLATED",
+		9: "LEADER_LEASE",
+	}
+	VoteReply_DenyReason_value = map[string]int32{
+		"NONE":          0,
+		"STALE_TERM":    1,
+		"LOG_BEHIND":    2,
+		"LEADER_GRACE":  3,
+		"UNKNOWN_NODE":  4,
+		"ALREADY_VOTED": 5,
+		"QUARANTINED":   6,
+		"NOT_PERSISTED": 7,
+		"ISOLATED":      8,
+		"LEADER_LEASE":  9,
+	}
+)
+
+func (x VoteReply_DenyReason) Enum() *VoteReply_DenyReason {
+	p := new(VoteReply_DenyReason)
+	*p = x
+	return p
+}
+
+func (x VoteReply_DenyReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (VoteReply_DenyReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_raft_proto_enumTypes[0].Descriptor()
+}
+
+func (VoteReply_DenyReason) Type() protoreflect.EnumType {
+	return &file_raft_proto_enumTypes[0]
+}
+
+func (x VoteReply_DenyReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use VoteReply_DenyReason.Descriptor instead.
+func (VoteReply_DenyReason) EnumDescriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{2, 0}
+}
+
+// 行为
 type LogRecord_Action int32
 
 const (
-	LogRecord_SET LogRecord_Action = 0
-	LogRecord_DEL LogRecord_Action = 1
+	LogRecord_SET          LogRecord_Action = 0
+	LogRecord_DEL          LogRecord_Action = 1
+	LogRecord_CUSTOM       LogRecord_Action = 2
+	LogRecord_CREATE_INDEX LogRecord_Action = 3
+	LogRecord_DROP_INDEX   LogRecord_Action = 4
+	LogRecord_ZADD         LogRecord_Action = 5
+	LogRecord_ZREM         LogRecord_Action = 6
+	LogRecord_SADD         LogRecord_Action = 7
+	LogRecord_SREM         LogRecord_Action = 8
+	LogRecord_TOUCH        LogRecord_Action = 9
+	LogRecord_PERSIST      LogRecord_Action = 10
+	LogRecord_EXPIRE       LogRecord_Action = 11
+	LogRecord_CHUNK        LogRecord_Action = 12
+	LogRecord_SET_CHUNKED  LogRecord_Action = 13
+	LogRecord_BATCH        LogRecord_Action = 14
+	LogRecord_CAS          LogRecord_Action = 15
+	LogRecord_TXN          LogRecord_Action = 16
+	LogRecord_READ_ONLY    LogRecord_Action = 17 // value is the reason writes are disabled, or empty to enable them
+	LogRecord_LOCK         LogRecord_Action = 18 // value is the lock (see database.Lock) to take or renew
+	LogRecord_UNLOCK       LogRecord_Action = 19 // value is the owner releasing the lock
+	LogRecord_RESTORE      LogRecord_Action = 20 // moves the value of key back out of the trash
+	LogRecord_RENAME       LogRecord_Action = 21 // moves the value of key to the key in value
+	LogRecord_COPY         LogRecord_Action = 22 // copies the value of key to the key in value
+	LogRecord_MEMBERS      LogRecord_Action = 23 // members is the raft address of every voting member (and old_members, of every member before a joint change)
+	LogRecord_NOOP         LogRecord_Action = 24 // changes nothing, appended by a leader to commit an entry in its term
 )
 
 // Enum value maps for LogRecord_Action.
 var (
 	LogRecord_Action_name = map[int32]string{
-		0: "SET",
-		1: "DEL",
+		0:  "SET",
+		1:  "DEL",
+		2:  "CUSTOM",
+		3:  "CREATE_INDEX",
+		4:  "DROP_INDEX",
+		5:  "ZADD",
+		6:  "ZREM",
+		7:  "SADD",
+		8:  "SREM",
+		9:  "TOUCH",
+		10: "PERSIST",
+		11: "EXPIRE",
+		12: "CHUNK",
+		13: "SET_CHUNKED",
+		14: "BATCH",
+		15: "CAS",
+		16: "TXN",
+		17: "READ_ONLY",
+		18: "LOCK",
+		19: "UNLOCK",
+		20: "RESTORE",
+		21: "RENAME",
+		22: "COPY",
+		23: "MEMBERS",
+		24: "NOOP",
 	}
 	LogRecord_Action_value = map[string]int32{
-		"SET": 0,
-		"DEL": 1,
+		"SET":          0,
+		"DEL":          1,
+		"CUSTOM":       2,
+		"CREATE_INDEX": 3,
+		"DROP_INDEX":   4,
+		"ZADD":         5,
+		"ZREM":         6,
+		"SADD":         7,
+		"SREM":         8,
+		"TOUCH":        9,
+		"PERSIST":      10,
+		"EXPIRE":       11,
+		"CHUNK":        12,
+		"SET_CHUNKED":  13,
+		"BATCH":        14,
+		"CAS":          15,
+		"TXN":          16,
+		"READ_ONLY":    17,
+		"LOCK":         18,
+		"UNLOCK":       19,
+		"RESTORE":      20,
+		"RENAME":       21,
+		"COPY":         22,
+		"MEMBERS":      23,
+		"NOOP":         24,
 	}
 )
 
@@ -55,11 +196,11 @@ func (x LogRecord_Action) String() string {
 }
 
 func (LogRecord_Action) Descriptor() protoreflect.EnumDescriptor {
-	return file_raft_proto_enumTypes[0].Descriptor()
+	return file_raft_proto_enumTypes[1].Descriptor()
 }
 
 func (LogRecord_Action) Type() protoreflect.EnumType {
-	return &file_raft_proto_enumTypes[0]
+	return &file_raft_proto_enumTypes[1]
 }
 
 func (x LogRecord_Action) Number() protoreflect.EnumNumber {
@@ -68,16 +209,17 @@ func (x LogRecord_Action) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use LogRecord_Action.Descriptor instead.
 func (LogRecord_Action) EnumDescriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{5, 0}
+	return file_raft_proto_rawDescGZIP(), []int{11, 0}
 }
 
+// 节点
 type Node struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id         string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	ClientAddr string `protobuf:"bytes,2,opt,name=clientAddr,proto3" json:"clientAddr,omitempty"`
+	Id         string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                 // 节点 ID
+	ClientAddr string `protobuf:"bytes,2,opt,name=clientAddr,proto3" json:"clientAddr,omitempty"` // 节点 Addr
 }
 
 func (x *Node) Reset() {
@@ -126,15 +268,19 @@ func (x *Node) GetClientAddr() string {
 	return ""
 }
 
+// 投票请求
 type VoteRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term         int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	Candidate    *Node `protobuf:"bytes,2,opt,name=candidate,proto3" json:"candidate,omitempty"`
-	LastLogIndex int64 `protobuf:"varint,3,opt,name=lastLogIndex,proto3" json:"lastLogIndex,omitempty"`
-	LastLogTerm  int64 `protobuf:"varint,4,opt,name=lastLogTerm,proto3" json:"lastLogTerm,omitempty"`
+	Term         int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`                 // 任期
+	Candidate    *Node `protobuf:"bytes,2,opt,name=candidate,proto3" json:"candidate,omitempty"`        // 候选节点
+	LastLogIndex int64 `protobuf:"varint,3,opt,name=lastLogIndex,proto3" json:"lastLogIndex,omitempty"` // 日志序号
+	LastLogTerm  int64 `protobuf:"varint,4,opt,name=lastLogTerm,proto3" json:"lastLogTerm,omitempty"`   // 日志期号
+	// the candidate was asked to stand by the leader (see TimeoutNow), so
+	// voters vote even if they have heard from the leader within its lease
+	Transfer bool `protobuf:"varint,5,opt,name=transfer,proto3" json:"transfer,omitempty"`
 }
 
 func (x *VoteRequest) Reset() {
@@ -197,14 +343,27 @@ func (x *VoteRequest) GetLastLogTerm() int64 {
 	return 0
 }
 
+func (x *VoteRequest) GetTransfer() bool {
+	if x != nil {
+		return x.Transfer
+	}
+	return false
+}
+
+// 投票响应
 type VoteReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term        int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	VoteGranted bool  `protobuf:"varint,2,opt,name=voteGranted,proto3" json:"voteGranted,omitempty"`
-	Node        *Node `protobuf:"bytes,3,opt,name=node,proto3" json:"node,omitempty"`
+	Term        int64                `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	VoteGranted bool                 `protobuf:"varint,2,opt,name=voteGranted,proto3" json:"voteGranted,omitempty"`
+	Node        *Node                `protobuf:"bytes,3,opt,name=node,proto3" json:"node,omitempty"`
+	DenyReason  VoteReply_DenyReason `protobuf:"varint,4,opt,name=denyReason,proto3,enum=raft.VoteReply_DenyReason" json:"denyReason,omitempty"`
+	// the voter's progress and version (see AppendReply)
+	CommitIndex int64  `protobuf:"varint,5,opt,name=commitIndex,proto3" json:"commitIndex,omitempty"`
+	LastApplied int64  `protobuf:"varint,6,opt,name=lastApplied,proto3" json:"lastApplied,omitempty"`
+	Version     string `protobuf:"bytes,7,opt,name=version,proto3" json:"version,omitempty"`
 }
 
 func (x *VoteReply) Reset() {
@@ -260,6 +419,35 @@ func (x *VoteReply) GetNode() *Node {
 	return nil
 }
 
+func (x *VoteReply) GetDenyReason() VoteReply_DenyReason {
+	if x != nil {
+		return x.DenyReason
+	}
+	return VoteReply_NONE
+}
+
+func (x *VoteReply) GetCommitIndex() int64 {
+	if x != nil {
+		return x.CommitIndex
+	}
+	return 0
+}
+
+func (x *VoteReply) GetLastApplied() int64 {
+	if x != nil {
+		return x.LastApplied
+	}
+	return 0
+}
+
+func (x *VoteReply) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+// 追加请求
 type AppendRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -271,6 +459,14 @@ type AppendRequest struct {
 	PrevLogTerm  int64        `protobuf:"varint,4,opt,name=prevLogTerm,proto3" json:"prevLogTerm,omitempty"`
 	LeaderCommit int64        `protobuf:"varint,5,opt,name=leaderCommit,proto3" json:"leaderCommit,omitempty"`
 	Entries      []*LogRecord `protobuf:"bytes,6,rep,name=entries,proto3" json:"entries,omitempty"`
+	// the leader's applied hash (see `Node.AppliedHash`) as of entry
+	// hashIndex, for followers to check against their own (0 if unknown)
+	HashIndex   int64  `protobuf:"varint,7,opt,name=hashIndex,proto3" json:"hashIndex,omitempty"`
+	AppliedHash uint64 `protobuf:"varint,8,opt,name=appliedHash,proto3" json:"appliedHash,omitempty"`
+	// nanoseconds since the start of the leader's last round of append
+	// requests acknowledged by a majority in this term, or 0 if unknown (see
+	// Node.Staleness)
+	LeaseAge int64 `protobuf:"varint,9,opt,name=leaseAge,proto3" json:"leaseAge,omitempty"`
 }
 
 func (x *AppendRequest) Reset() {
@@ -347,6 +543,28 @@ func (x *AppendRequest) GetEntries() []*LogRecord {
 	return nil
 }
 
+func (x *AppendRequest) GetHashIndex() int64 {
+	if x != nil {
+		return x.HashIndex
+	}
+	return 0
+}
+
+func (x *AppendRequest) GetAppliedHash() uint64 {
+	if x != nil {
+		return x.AppliedHash
+	}
+	return 0
+}
+
+func (x *AppendRequest) GetLeaseAge() int64 {
+	if x != nil {
+		return x.LeaseAge
+	}
+	return 0
+}
+
+// 追加响应
 type AppendReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -354,6 +572,16 @@ type AppendReply struct {
 
 	Term    int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
 	Success bool  `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	// the follower's commit and applied indexes, and the version of leifdb it
+	// runs. version is never empty, so an empty version identifies a reply
+	// from an earlier version, which does not report its progress
+	CommitIndex int64  `protobuf:"varint,3,opt,name=commitIndex,proto3" json:"commitIndex,omitempty"`
+	LastApplied int64  `protobuf:"varint,4,opt,name=lastApplied,proto3" json:"lastApplied,omitempty"`
+	Version     string `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	// the follower's wall-clock time (unix nanoseconds) when it handled the
+	// request, for the leader to estimate the skew between their clocks (0
+	// from an earlier version)
+	Time int64 `protobuf:"varint,6,opt,name=time,proto3" json:"time,omitempty"`
 }
 
 func (x *AppendReply) Reset() {
@@ -402,19 +630,46 @@ func (x *AppendReply) GetSuccess() bool {
 	return false
 }
 
-type LogRecord struct {
+func (x *AppendReply) GetCommitIndex() int64 {
+	if x != nil {
+		return x.CommitIndex
+	}
+	return 0
+}
+
+func (x *AppendReply) GetLastApplied() int64 {
+	if x != nil {
+		return x.LastApplied
+	}
+	return 0
+}
+
+func (x *AppendReply) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AppendReply) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+// request from the leader for a follower to take over leadership
+type TimeoutNowRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term   int64            `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	Action LogRecord_Action `protobuf:"varint,2,opt,name=action,proto3,enum=raft.LogRecord_Action" json:"action,omitempty"`
-	Key    string           `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
-	Value  string           `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	Term   int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Leader *Node `protobuf:"bytes,2,opt,name=leader,proto3" json:"leader,omitempty"`
 }
 
-func (x *LogRecord) Reset() {
-	*x = LogRecord{}
+func (x *TimeoutNowRequest) Reset() {
+	*x = TimeoutNowRequest{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_raft_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -422,13 +677,13 @@ func (x *LogRecord) Reset() {
 	}
 }
 
-func (x *LogRecord) String() string {
+func (x *TimeoutNowRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LogRecord) ProtoMessage() {}
+func (*TimeoutNowRequest) ProtoMessage() {}
 
-func (x *LogRecord) ProtoReflect() protoreflect.Message {
+func (x *TimeoutNowRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_raft_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -440,64 +695,106 @@ func (x *LogRecord) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LogRecord.ProtoReflect.Descriptor instead.
-func (*LogRecord) Descriptor() ([]byte, []int) {
+// Deprecated: Use TimeoutNowRequest.ProtoReflect.Descriptor instead.
+func (*TimeoutNowRequest) Descriptor() ([]byte, []int) {
 	return file_raft_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *LogRecord) GetTerm() int64 {
+func (x *TimeoutNowRequest) GetTerm() int64 {
 	if x != nil {
 		return x.Term
 	}
 	return 0
 }
 
-func (x *LogRecord) GetAction() LogRecord_Action {
+func (x *TimeoutNowRequest) GetLeader() *Node {
 	if x != nil {
-		return x.Action
+		return x.Leader
 	}
-	return LogRecord_SET
+	return nil
 }
 
-func (x *LogRecord) GetKey() string {
+type TimeoutNowReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	// false if the follower can't stand for election (e.g. it is a witness)
+	Accepted bool `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+}
+
+func (x *TimeoutNowReply) Reset() {
+	*x = TimeoutNowReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TimeoutNowReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeoutNowReply) ProtoMessage() {}
+
+func (x *TimeoutNowReply) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeoutNowReply.ProtoReflect.Descriptor instead.
+func (*TimeoutNowReply) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TimeoutNowReply) GetTerm() int64 {
 	if x != nil {
-		return x.Key
+		return x.Term
 	}
-	return ""
+	return 0
 }
 
-func (x *LogRecord) GetValue() string {
+func (x *TimeoutNowReply) GetAccepted() bool {
 	if x != nil {
-		return x.Value
+		return x.Accepted
 	}
-	return ""
+	return false
 }
 
-type LogStore struct {
+type PingRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Entries []*LogRecord `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	Node *Node `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
 }
 
-func (x *LogStore) Reset() {
-	*x = LogStore{}
+func (x *PingRequest) Reset() {
+	*x = PingRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_raft_proto_msgTypes[6]
+		mi := &file_raft_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *LogStore) String() string {
+func (x *PingRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LogStore) ProtoMessage() {}
+func (*PingRequest) ProtoMessage() {}
 
-func (x *LogStore) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[6]
+func (x *PingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -508,44 +805,44 @@ func (x *LogStore) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LogStore.ProtoReflect.Descriptor instead.
-func (*LogStore) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{6}
+// Deprecated: Use PingRequest.ProtoReflect.Descriptor instead.
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *LogStore) GetEntries() []*LogRecord {
+func (x *PingRequest) GetNode() *Node {
 	if x != nil {
-		return x.Entries
+		return x.Node
 	}
 	return nil
 }
 
-type TermRecord struct {
+type PingReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term     int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	VotedFor *Node `protobuf:"bytes,2,opt,name=votedFor,proto3" json:"votedFor,omitempty"`
+	Term int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Node *Node `protobuf:"bytes,2,opt,name=node,proto3" json:"node,omitempty"`
 }
 
-func (x *TermRecord) Reset() {
-	*x = TermRecord{}
+func (x *PingReply) Reset() {
+	*x = PingReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_raft_proto_msgTypes[7]
+		mi := &file_raft_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *TermRecord) String() string {
+func (x *PingReply) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*TermRecord) ProtoMessage() {}
+func (*PingReply) ProtoMessage() {}
 
-func (x *TermRecord) ProtoReflect() protoreflect.Message {
-	mi := &file_raft_proto_msgTypes[7]
+func (x *PingReply) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -556,168 +853,1127 @@ func (x *TermRecord) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use TermRecord.ProtoReflect.Descriptor instead.
-func (*TermRecord) Descriptor() ([]byte, []int) {
-	return file_raft_proto_rawDescGZIP(), []int{7}
+// Deprecated: Use PingReply.ProtoReflect.Descriptor instead.
+func (*PingReply) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *TermRecord) GetTerm() int64 {
+func (x *PingReply) GetTerm() int64 {
 	if x != nil {
 		return x.Term
 	}
 	return 0
 }
 
-func (x *TermRecord) GetVotedFor() *Node {
+func (x *PingReply) GetNode() *Node {
 	if x != nil {
-		return x.VotedFor
+		return x.Node
 	}
 	return nil
 }
 
-var File_raft_proto protoreflect.FileDescriptor
+// a piece of a snapshot sent by the leader. Every chunk carries the leader's
+// term and id, and the position of the snapshot in the log
+type SnapshotChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-var file_raft_proto_rawDesc = []byte{
-	0x0a, 0x0a, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x72, 0x61,
-	0x66, 0x74, 0x22, 0x36, 0x0a, 0x04, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x6c,
-	0x69, 0x65, 0x6e, 0x74, 0x41, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a,
-	0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x41, 0x64, 0x64, 0x72, 0x22, 0x91, 0x01, 0x0a, 0x0b, 0x56,
-	0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65,
-	0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x28,
-	0x0a, 0x09, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x09, 0x63,
-	0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x6c, 0x61, 0x73, 0x74,
-	0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c,
-	0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b,
-	0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x22, 0x61,
-	0x0a, 0x09, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74,
-	0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12,
-	0x20, 0x0a, 0x0b, 0x76, 0x6f, 0x74, 0x65, 0x47, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x76, 0x6f, 0x74, 0x65, 0x47, 0x72, 0x61, 0x6e, 0x74, 0x65,
-	0x64, 0x12, 0x1e, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
-	0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x6e, 0x6f, 0x64,
-	0x65, 0x22, 0xdc, 0x01, 0x0a, 0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x22, 0x0a, 0x06, 0x6c, 0x65, 0x61, 0x64, 0x65,
-	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e,
-	0x6f, 0x64, 0x65, 0x52, 0x06, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x22, 0x0a, 0x0c, 0x70,
-	0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12,
-	0x20, 0x0a, 0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x04,
-	0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72,
-	0x6d, 0x12, 0x22, 0x0a, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69,
-	0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43,
-	0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x12, 0x29, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73,
-	0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f,
-	0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73,
-	0x22, 0x3b, 0x0a, 0x0b, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12,
-	0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74,
-	0x65, 0x72, 0x6d, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x93, 0x01,
-	0x0a, 0x09, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74,
-	0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12,
-	0x2e, 0x0a, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32,
-	0x16, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64,
-	0x2e, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12,
-	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
-	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x1a, 0x0a, 0x06, 0x41, 0x63, 0x74, 0x69, 0x6f,
-	0x6e, 0x12, 0x07, 0x0a, 0x03, 0x53, 0x45, 0x54, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x44, 0x45,
-	0x4c, 0x10, 0x01, 0x22, 0x35, 0x0a, 0x08, 0x4c, 0x6f, 0x67, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x12,
-	0x29, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
-	0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72,
-	0x64, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x22, 0x48, 0x0a, 0x0a, 0x54, 0x65,
-	0x72, 0x6d, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x26, 0x0a, 0x08,
-	0x76, 0x6f, 0x74, 0x65, 0x64, 0x46, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a,
-	0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x08, 0x76, 0x6f, 0x74, 0x65,
-	0x64, 0x46, 0x6f, 0x72, 0x32, 0x73, 0x0a, 0x04, 0x52, 0x61, 0x66, 0x74, 0x12, 0x33, 0x0a, 0x0b,
-	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x12, 0x11, 0x2e, 0x72, 0x61,
-	0x66, 0x74, 0x2e, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f,
-	0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
-	0x00, 0x12, 0x36, 0x0a, 0x0a, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x4c, 0x6f, 0x67, 0x73, 0x12,
-	0x13, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x41, 0x70, 0x70, 0x65,
-	0x6e, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x28, 0x5a, 0x26, 0x67, 0x69, 0x74,
-	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x74, 0x6d, 0x6f, 0x72, 0x72, 0x2f, 0x6c,
-	0x65, 0x69, 0x66, 0x64, 0x62, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x72,
-	0x61, 0x66, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	Term   int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	Leader *Node `protobuf:"bytes,2,opt,name=leader,proto3" json:"leader,omitempty"`
+	// index and term of the last entry covered by the snapshot, and the
+	// leader's applied hash as of that entry
+	LastIndex   int64  `protobuf:"varint,3,opt,name=lastIndex,proto3" json:"lastIndex,omitempty"`
+	LastTerm    int64  `protobuf:"varint,4,opt,name=lastTerm,proto3" json:"lastTerm,omitempty"`
+	AppliedHash uint64 `protobuf:"varint,5,opt,name=appliedHash,proto3" json:"appliedHash,omitempty"`
+	// total size of the snapshot, and the offset of this chunk's data in it
+	Size   int64  `protobuf:"varint,6,opt,name=size,proto3" json:"size,omitempty"`
+	Offset int64  `protobuf:"varint,7,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data   []byte `protobuf:"bytes,8,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *SnapshotChunk) Reset() {
+	*x = SnapshotChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-var (
-	file_raft_proto_rawDescOnce sync.Once
-	file_raft_proto_rawDescData = file_raft_proto_rawDesc
-)
+func (x *SnapshotChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
 
-func file_raft_proto_rawDescGZIP() []byte {
-	file_raft_proto_rawDescOnce.Do(func() {
-		file_raft_proto_rawDescData = protoimpl.X.CompressGZIP(file_raft_proto_rawDescData)
-	})
-	return file_raft_proto_rawDescData
+func (*SnapshotChunk) ProtoMessage() {}
+
+func (x *SnapshotChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var file_raft_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
-var file_raft_proto_goTypes = []interface{}{
-	(LogRecord_Action)(0), // 0: raft.LogRecord.Action
-	(*Node)(nil),          // 1: raft.Node
-	(*VoteRequest)(nil),   // 2: raft.VoteRequest
-	(*VoteReply)(nil),     // 3: raft.VoteReply
-	(*AppendRequest)(nil), // 4: raft.AppendRequest
-	(*AppendReply)(nil),   // 5: raft.AppendReply
-	(*LogRecord)(nil),     // 6: raft.LogRecord
-	(*LogStore)(nil),      // 7: raft.LogStore
-	(*TermRecord)(nil),    // 8: raft.TermRecord
+// Deprecated: Use SnapshotChunk.ProtoReflect.Descriptor instead.
+func (*SnapshotChunk) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{9}
 }
-var file_raft_proto_depIdxs = []int32{
-	1, // 0: raft.VoteRequest.candidate:type_name -> raft.Node
-	1, // 1: raft.VoteReply.node:type_name -> raft.Node
-	1, // 2: raft.AppendRequest.leader:type_name -> raft.Node
-	6, // 3: raft.AppendRequest.entries:type_name -> raft.LogRecord
-	0, // 4: raft.LogRecord.action:type_name -> raft.LogRecord.Action
-	6, // 5: raft.LogStore.entries:type_name -> raft.LogRecord
-	1, // 6: raft.TermRecord.votedFor:type_name -> raft.Node
-	2, // 7: raft.Raft.RequestVote:input_type -> raft.VoteRequest
-	4, // 8: raft.Raft.AppendLogs:input_type -> raft.AppendRequest
-	3, // 9: raft.Raft.RequestVote:output_type -> raft.VoteReply
-	5, // 10: raft.Raft.AppendLogs:output_type -> raft.AppendReply
-	9, // [9:11] is the sub-list for method output_type
-	7, // [7:9] is the sub-list for method input_type
-	7, // [7:7] is the sub-list for extension type_name
-	7, // [7:7] is the sub-list for extension extendee
-	0, // [0:7] is the sub-list for field type_name
+
+func (x *SnapshotChunk) GetTerm() int64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
 }
 
-func init() { file_raft_proto_init() }
-func file_raft_proto_init() {
-	if File_raft_proto != nil {
-		return
+func (x *SnapshotChunk) GetLeader() *Node {
+	if x != nil {
+		return x.Leader
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_raft_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Node); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_raft_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*VoteRequest); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
+	return nil
+}
+
+func (x *SnapshotChunk) GetLastIndex() int64 {
+	if x != nil {
+		return x.LastIndex
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetLastTerm() int64 {
+	if x != nil {
+		return x.LastTerm
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetAppliedHash() uint64 {
+	if x != nil {
+		return x.AppliedHash
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type InstallSnapshotReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	// whether the snapshot was installed (or the member already had every
+	// entry it covers)
+	Success bool `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+}
+
+func (x *InstallSnapshotReply) Reset() {
+	*x = InstallSnapshotReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InstallSnapshotReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstallSnapshotReply) ProtoMessage() {}
+
+func (x *InstallSnapshotReply) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstallSnapshotReply.ProtoReflect.Descriptor instead.
+func (*InstallSnapshotReply) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *InstallSnapshotReply) GetTerm() int64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *InstallSnapshotReply) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+// 日志记录
+type LogRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// 任期
+	Term int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	// 行为
+	Action LogRecord_Action `protobuf:"varint,2,opt,name=action,proto3,enum=raft.LogRecord_Action" json:"action,omitempty"`
+	// 键
+	Key string `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	// 值
+	Value string `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	// name of the registered handler for a CUSTOM entry
+	Command string `protobuf:"bytes,5,opt,name=command,proto3" json:"command,omitempty"`
+	// opaque payload for a CUSTOM entry, or the contents of a CHUNK
+	Data []byte `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	// members of a collection (a set or sorted set) being added or removed, or
+	// the chunk keys making up the value for SET_CHUNKED
+	Members []string `protobuf:"bytes,7,rep,name=members,proto3" json:"members,omitempty"`
+	// scores for each of the members being added to a sorted set
+	Scores []float64 `protobuf:"fixed64,8,rep,packed,name=scores,proto3" json:"scores,omitempty"`
+	// expiry time for TOUCH (or for SET, SET_CHUNKED, and RESTORE, from the
+	// default time to live of the key's namespace, or for DEL, of the value
+	// moved to the trash), or the time as of which keys (in members) are expired for EXPIRE
+	// or treated as expired by the operations of a BATCH or by UNLOCK, in Unix
+	// nanoseconds
+	ExpiresAt int64 `protobuf:"varint,9,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// SET, DEL, and CAS operations making up a BATCH, or the SET and DEL
+	// operations applied by a TXN if its conditions hold, applied in order
+	Ops []*LogRecord `protobuf:"bytes,10,rep,name=ops,proto3" json:"ops,omitempty"`
+	// whether a BATCH is applied all-or-nothing
+	Atomic bool `protobuf:"varint,11,opt,name=atomic,proto3" json:"atomic,omitempty"`
+	// value the key must have for a CAS to be applied (if expect_exists is
+	// false, the key must not exist instead)
+	Expected     string `protobuf:"bytes,12,opt,name=expected,proto3" json:"expected,omitempty"`
+	ExpectExists bool   `protobuf:"varint,13,opt,name=expect_exists,json=expectExists,proto3" json:"expect_exists,omitempty"`
+	// ID of the client request that proposed the entry, for following a write
+	// through the logs of each node
+	RequestId string `protobuf:"bytes,14,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// conditions of a TXN, each holding if the key has the value expected (or
+	// if expect_exists is false, the key does not exist)
+	Compares []*LogRecord `protobuf:"bytes,15,rep,name=compares,proto3" json:"compares,omitempty"`
+	// operations applied by a TXN if any of its conditions does not hold
+	ElseOps []*LogRecord `protobuf:"bytes,16,rep,name=else_ops,json=elseOps,proto3" json:"else_ops,omitempty"`
+	// wall-clock time at which the leader appended the entry, in Unix
+	// nanoseconds (0 for entries appended before timestamps were recorded)
+	Timestamp int64 `protobuf:"varint,17,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// whether a RENAME or COPY overwrites the key it writes, if it has a value
+	Replace bool `protobuf:"varint,18,opt,name=replace,proto3" json:"replace,omitempty"`
+	// for a MEMBERS entry starting a joint membership change, the voting
+	// members before the change (empty for the entry that completes it)
+	OldMembers []string `protobuf:"bytes,19,rep,name=old_members,json=oldMembers,proto3" json:"old_members,omitempty"`
+}
+
+func (x *LogRecord) Reset() {
+	*x = LogRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LogRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogRecord) ProtoMessage() {}
+
+func (x *LogRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogRecord.ProtoReflect.Descriptor instead.
+func (*LogRecord) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *LogRecord) GetTerm() int64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *LogRecord) GetAction() LogRecord_Action {
+	if x != nil {
+		return x.Action
+	}
+	return LogRecord_SET
+}
+
+func (x *LogRecord) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *LogRecord) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *LogRecord) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *LogRecord) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *LogRecord) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+func (x *LogRecord) GetScores() []float64 {
+	if x != nil {
+		return x.Scores
+	}
+	return nil
+}
+
+func (x *LogRecord) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *LogRecord) GetOps() []*LogRecord {
+	if x != nil {
+		return x.Ops
+	}
+	return nil
+}
+
+func (x *LogRecord) GetAtomic() bool {
+	if x != nil {
+		return x.Atomic
+	}
+	return false
+}
+
+func (x *LogRecord) GetExpected() string {
+	if x != nil {
+		return x.Expected
+	}
+	return ""
+}
+
+func (x *LogRecord) GetExpectExists() bool {
+	if x != nil {
+		return x.ExpectExists
+	}
+	return false
+}
+
+func (x *LogRecord) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *LogRecord) GetCompares() []*LogRecord {
+	if x != nil {
+		return x.Compares
+	}
+	return nil
+}
+
+func (x *LogRecord) GetElseOps() []*LogRecord {
+	if x != nil {
+		return x.ElseOps
+	}
+	return nil
+}
+
+func (x *LogRecord) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *LogRecord) GetReplace() bool {
+	if x != nil {
+		return x.Replace
+	}
+	return false
+}
+
+func (x *LogRecord) GetOldMembers() []string {
+	if x != nil {
+		return x.OldMembers
+	}
+	return nil
+}
+
+// 日志记录集合
+type LogStore struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Entries []*LogRecord `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	// index of the first entry in entries (earlier entries have been compacted
+	// into a snapshot)
+	BaseIndex int64 `protobuf:"varint,2,opt,name=base_index,json=baseIndex,proto3" json:"base_index,omitempty"`
+	// term of the entry at base_index - 1
+	BaseTerm int64 `protobuf:"varint,3,opt,name=base_term,json=baseTerm,proto3" json:"base_term,omitempty"`
+}
+
+func (x *LogStore) Reset() {
+	*x = LogStore{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LogStore) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogStore) ProtoMessage() {}
+
+func (x *LogStore) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogStore.ProtoReflect.Descriptor instead.
+func (*LogStore) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *LogStore) GetEntries() []*LogRecord {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *LogStore) GetBaseIndex() int64 {
+	if x != nil {
+		return x.BaseIndex
+	}
+	return 0
+}
+
+func (x *LogStore) GetBaseTerm() int64 {
+	if x != nil {
+		return x.BaseTerm
+	}
+	return 0
+}
+
+// 任期记录
+type TermRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term     int64 `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	VotedFor *Node `protobuf:"bytes,2,opt,name=votedFor,proto3" json:"votedFor,omitempty"`
+}
+
+func (x *TermRecord) Reset() {
+	*x = TermRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TermRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TermRecord) ProtoMessage() {}
+
+func (x *TermRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TermRecord.ProtoReflect.Descriptor instead.
+func (*TermRecord) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *TermRecord) GetTerm() int64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *TermRecord) GetVotedFor() *Node {
+	if x != nil {
+		return x.VotedFor
+	}
+	return nil
+}
+
+// a key in an export in the protobuf format, where each record is preceded by
+// its length as a varint
+type ExportRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// expiry time of the key in Unix nanoseconds (0 if it does not expire)
+	ExpiresAt int64 `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+}
+
+func (x *ExportRecord) Reset() {
+	*x = ExportRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExportRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportRecord) ProtoMessage() {}
+
+func (x *ExportRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportRecord.ProtoReflect.Descriptor instead.
+func (*ExportRecord) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ExportRecord) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ExportRecord) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *ExportRecord) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type AddServerRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Address string `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+}
+
+func (x *AddServerRequest) Reset() {
+	*x = AddServerRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddServerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddServerRequest) ProtoMessage() {}
+
+func (x *AddServerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddServerRequest.ProtoReflect.Descriptor instead.
+func (*AddServerRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *AddServerRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type RemoveServerRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Address string `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+}
+
+func (x *RemoveServerRequest) Reset() {
+	*x = RemoveServerRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RemoveServerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveServerRequest) ProtoMessage() {}
+
+func (x *RemoveServerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveServerRequest.ProtoReflect.Descriptor instead.
+func (*RemoveServerRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *RemoveServerRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+// a request to change the voting members of the cluster to members
+type ChangeMembersRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Members []string `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
+}
+
+func (x *ChangeMembersRequest) Reset() {
+	*x = ChangeMembersRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ChangeMembersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeMembersRequest) ProtoMessage() {}
+
+func (x *ChangeMembersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeMembersRequest.ProtoReflect.Descriptor instead.
+func (*ChangeMembersRequest) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ChangeMembersRequest) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type MembershipReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// raft address of every voting member once the change is committed
+	Members []string `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
+}
+
+func (x *MembershipReply) Reset() {
+	*x = MembershipReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_raft_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MembershipReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MembershipReply) ProtoMessage() {}
+
+func (x *MembershipReply) ProtoReflect() protoreflect.Message {
+	mi := &file_raft_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MembershipReply.ProtoReflect.Descriptor instead.
+func (*MembershipReply) Descriptor() ([]byte, []int) {
+	return file_raft_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *MembershipReply) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+var File_raft_proto protoreflect.FileDescriptor
+
+var file_raft_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x72, 0x61,
+	0x66, 0x74, 0x22, 0x36, 0x0a, 0x04, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x41, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a,
+	0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x41, 0x64, 0x64, 0x72, 0x22, 0xad, 0x01, 0x0a, 0x0b, 0x56,
+	0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65,
+	0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x28,
+	0x0a, 0x09, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x09, 0x63,
+	0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x6c, 0x61, 0x73, 0x74,
+	0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c,
+	0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b,
+	0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x1a,
+	0x0a, 0x08, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x08, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x22, 0xaf, 0x03, 0x0a, 0x09, 0x56,
+	0x6f, 0x74, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x20, 0x0a, 0x0b,
+	0x76, 0x6f, 0x74, 0x65, 0x47, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x76, 0x6f, 0x74, 0x65, 0x47, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x12, 0x1e,
+	0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72,
+	0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x3a,
+	0x0a, 0x0a, 0x64, 0x65, 0x6e, 0x79, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x1a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65,
+	0x70, 0x6c, 0x79, 0x2e, 0x44, 0x65, 0x6e, 0x79, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x52, 0x0a,
+	0x64, 0x65, 0x6e, 0x79, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x6f,
+	0x6d, 0x6d, 0x69, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0b, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b,
+	0x6c, 0x61, 0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0xb1, 0x01, 0x0a, 0x0a, 0x44, 0x65, 0x6e,
+	0x79, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f, 0x4e, 0x45, 0x10,
+	0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x53, 0x54, 0x41, 0x4c, 0x45, 0x5f, 0x54, 0x45, 0x52, 0x4d, 0x10,
+	0x01, 0x12, 0x0e, 0x0a, 0x0a, 0x4c, 0x4f, 0x47, 0x5f, 0x42, 0x45, 0x48, 0x49, 0x4e, 0x44, 0x10,
+	0x02, 0x12, 0x10, 0x0a, 0x0c, 0x4c, 0x45, 0x41, 0x44, 0x45, 0x52, 0x5f, 0x47, 0x52, 0x41, 0x43,
+	0x45, 0x10, 0x03, 0x12, 0x10, 0x0a, 0x0c, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x5f, 0x4e,
+	0x4f, 0x44, 0x45, 0x10, 0x04, 0x12, 0x11, 0x0a, 0x0d, 0x41, 0x4c, 0x52, 0x45, 0x41, 0x44, 0x59,
+	0x5f, 0x56, 0x4f, 0x54, 0x45, 0x44, 0x10, 0x05, 0x12, 0x0f, 0x0a, 0x0b, 0x51, 0x55, 0x41, 0x52,
+	0x41, 0x4e, 0x54, 0x49, 0x4e, 0x45, 0x44, 0x10, 0x06, 0x12, 0x11, 0x0a, 0x0d, 0x4e, 0x4f, 0x54,
+	0x5f, 0x50, 0x45, 0x52, 0x53, 0x49, 0x53, 0x54, 0x45, 0x44, 0x10, 0x07, 0x12, 0x0c, 0x0a, 0x08,
+	0x49, 0x53, 0x4f, 0x4c, 0x41, 0x54, 0x45, 0x44, 0x10, 0x08, 0x12, 0x10, 0x0a, 0x0c, 0x4c, 0x45,
+	0x41, 0x44, 0x45, 0x52, 0x5f, 0x4c, 0x45, 0x41, 0x53, 0x45, 0x10, 0x09, 0x22, 0xb8, 0x02, 0x0a,
+	0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12,
+	0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65,
+	0x72, 0x6d, 0x12, 0x22, 0x0a, 0x06, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x06,
+	0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x22, 0x0a, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f,
+	0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x70, 0x72,
+	0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b, 0x70, 0x72,
+	0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x22, 0x0a, 0x0c,
+	0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74,
+	0x12, 0x29, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x68,
+	0x61, 0x73, 0x68, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x68, 0x61, 0x73, 0x68, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b, 0x61, 0x70, 0x70,
+	0x6c, 0x69, 0x65, 0x64, 0x48, 0x61, 0x73, 0x68, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b,
+	0x61, 0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x48, 0x61, 0x73, 0x68, 0x12, 0x1a, 0x0a, 0x08, 0x6c,
+	0x65, 0x61, 0x73, 0x65, 0x41, 0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x6c,
+	0x65, 0x61, 0x73, 0x65, 0x41, 0x67, 0x65, 0x22, 0xad, 0x01, 0x0a, 0x0b, 0x41, 0x70, 0x70, 0x65,
+	0x6e, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x18, 0x0a, 0x07, 0x73,
+	0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75,
+	0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x49,
+	0x6e, 0x64, 0x65, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x63, 0x6f, 0x6d, 0x6d,
+	0x69, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x41,
+	0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x6c, 0x61,
+	0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x4b, 0x0a, 0x11, 0x54, 0x69, 0x6d, 0x65, 0x6f,
+	0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04,
+	0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d,
+	0x12, 0x22, 0x0a, 0x06, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x06, 0x6c, 0x65,
+	0x61, 0x64, 0x65, 0x72, 0x22, 0x41, 0x0a, 0x0f, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4e,
+	0x6f, 0x77, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x1a, 0x0a, 0x08, 0x61,
+	0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x61,
+	0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x22, 0x2d, 0x0a, 0x0b, 0x50, 0x69, 0x6e, 0x67, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65,
+	0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x22, 0x3f, 0x0a, 0x09, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65,
+	0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x1e, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64,
+	0x65, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x22, 0xe3, 0x01, 0x0a, 0x0d, 0x53, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72,
+	0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x22, 0x0a,
+	0x06, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e,
+	0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x06, 0x6c, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x12, 0x1c, 0x0a, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12,
+	0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x20, 0x0a, 0x0b, 0x61,
+	0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x48, 0x61, 0x73, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x0b, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x65, 0x64, 0x48, 0x61, 0x73, 0x68, 0x12, 0x12, 0x0a,
+	0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x73, 0x69, 0x7a,
+	0x65, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74,
+	0x61, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x44, 0x0a,
+	0x14, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74,
+	0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63,
+	0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63,
+	0x65, 0x73, 0x73, 0x22, 0xf2, 0x06, 0x0a, 0x09, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72,
+	0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x2e, 0x0a, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x16, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f, 0x67,
+	0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x61,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x6d,
+	0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65,
+	0x6d, 0x62, 0x65, 0x72, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18,
+	0x08, 0x20, 0x03, 0x28, 0x01, 0x52, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x1d, 0x0a,
+	0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x5f, 0x61, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x41, 0x74, 0x12, 0x21, 0x0a, 0x03,
+	0x6f, 0x70, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74,
+	0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x03, 0x6f, 0x70, 0x73, 0x12,
+	0x16, 0x0a, 0x06, 0x61, 0x74, 0x6f, 0x6d, 0x69, 0x63, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x06, 0x61, 0x74, 0x6f, 0x6d, 0x69, 0x63, 0x12, 0x1a, 0x0a, 0x08, 0x65, 0x78, 0x70, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x65, 0x78, 0x70, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x78, 0x70, 0x65, 0x63, 0x74, 0x5f, 0x65, 0x78,
+	0x69, 0x73, 0x74, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x65, 0x78, 0x70, 0x65,
+	0x63, 0x74, 0x45, 0x78, 0x69, 0x73, 0x74, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x64, 0x12, 0x2b, 0x0a, 0x08, 0x63, 0x6f, 0x6d, 0x70, 0x61,
+	0x72, 0x65, 0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74,
+	0x2e, 0x4c, 0x6f, 0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x08, 0x63, 0x6f, 0x6d, 0x70,
+	0x61, 0x72, 0x65, 0x73, 0x12, 0x2a, 0x0a, 0x08, 0x65, 0x6c, 0x73, 0x65, 0x5f, 0x6f, 0x70, 0x73,
+	0x18, 0x10, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f,
+	0x67, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x07, 0x65, 0x6c, 0x73, 0x65, 0x4f, 0x70, 0x73,
+	0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x11, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x18,
+	0x0a, 0x07, 0x72, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x18, 0x12, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x07, 0x72, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x6f, 0x6c, 0x64, 0x5f,
+	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x18, 0x13, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x6f,
+	0x6c, 0x64, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x22, 0xac, 0x02, 0x0a, 0x06, 0x41, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x07, 0x0a, 0x03, 0x53, 0x45, 0x54, 0x10, 0x00, 0x12, 0x07, 0x0a,
+	0x03, 0x44, 0x45, 0x4c, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x55, 0x53, 0x54, 0x4f, 0x4d,
+	0x10, 0x02, 0x12, 0x10, 0x0a, 0x0c, 0x43, 0x52, 0x45, 0x41, 0x54, 0x45, 0x5f, 0x49, 0x4e, 0x44,
+	0x45, 0x58, 0x10, 0x03, 0x12, 0x0e, 0x0a, 0x0a, 0x44, 0x52, 0x4f, 0x50, 0x5f, 0x49, 0x4e, 0x44,
+	0x45, 0x58, 0x10, 0x04, 0x12, 0x08, 0x0a, 0x04, 0x5a, 0x41, 0x44, 0x44, 0x10, 0x05, 0x12, 0x08,
+	0x0a, 0x04, 0x5a, 0x52, 0x45, 0x4d, 0x10, 0x06, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x41, 0x44, 0x44,
+	0x10, 0x07, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x52, 0x45, 0x4d, 0x10, 0x08, 0x12, 0x09, 0x0a, 0x05,
+	0x54, 0x4f, 0x55, 0x43, 0x48, 0x10, 0x09, 0x12, 0x0b, 0x0a, 0x07, 0x50, 0x45, 0x52, 0x53, 0x49,
+	0x53, 0x54, 0x10, 0x0a, 0x12, 0x0a, 0x0a, 0x06, 0x45, 0x58, 0x50, 0x49, 0x52, 0x45, 0x10, 0x0b,
+	0x12, 0x09, 0x0a, 0x05, 0x43, 0x48, 0x55, 0x4e, 0x4b, 0x10, 0x0c, 0x12, 0x0f, 0x0a, 0x0b, 0x53,
+	0x45, 0x54, 0x5f, 0x43, 0x48, 0x55, 0x4e, 0x4b, 0x45, 0x44, 0x10, 0x0d, 0x12, 0x09, 0x0a, 0x05,
+	0x42, 0x41, 0x54, 0x43, 0x48, 0x10, 0x0e, 0x12, 0x07, 0x0a, 0x03, 0x43, 0x41, 0x53, 0x10, 0x0f,
+	0x12, 0x07, 0x0a, 0x03, 0x54, 0x58, 0x4e, 0x10, 0x10, 0x12, 0x0d, 0x0a, 0x09, 0x52, 0x45, 0x41,
+	0x44, 0x5f, 0x4f, 0x4e, 0x4c, 0x59, 0x10, 0x11, 0x12, 0x08, 0x0a, 0x04, 0x4c, 0x4f, 0x43, 0x4b,
+	0x10, 0x12, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x4e, 0x4c, 0x4f, 0x43, 0x4b, 0x10, 0x13, 0x12, 0x0b,
+	0x0a, 0x07, 0x52, 0x45, 0x53, 0x54, 0x4f, 0x52, 0x45, 0x10, 0x14, 0x12, 0x0a, 0x0a, 0x06, 0x52,
+	0x45, 0x4e, 0x41, 0x4d, 0x45, 0x10, 0x15, 0x12, 0x08, 0x0a, 0x04, 0x43, 0x4f, 0x50, 0x59, 0x10,
+	0x16, 0x12, 0x0b, 0x0a, 0x07, 0x4d, 0x45, 0x4d, 0x42, 0x45, 0x52, 0x53, 0x10, 0x17, 0x12, 0x08,
+	0x0a, 0x04, 0x4e, 0x4f, 0x4f, 0x50, 0x10, 0x18, 0x22, 0x77, 0x0a, 0x08, 0x4c, 0x6f, 0x67, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x12, 0x29, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4c, 0x6f, 0x67,
+	0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12,
+	0x1d, 0x0a, 0x0a, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x09, 0x62, 0x61, 0x73, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1b,
+	0x0a, 0x09, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x08, 0x62, 0x61, 0x73, 0x65, 0x54, 0x65, 0x72, 0x6d, 0x4a, 0x04, 0x08, 0x0f, 0x10,
+	0x10, 0x22, 0x48, 0x0a, 0x0a, 0x54, 0x65, 0x72, 0x6d, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12,
+	0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74,
+	0x65, 0x72, 0x6d, 0x12, 0x26, 0x0a, 0x08, 0x76, 0x6f, 0x74, 0x65, 0x64, 0x46, 0x6f, 0x72, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4e, 0x6f, 0x64,
+	0x65, 0x52, 0x08, 0x76, 0x6f, 0x74, 0x65, 0x64, 0x46, 0x6f, 0x72, 0x22, 0x55, 0x0a, 0x0c, 0x45,
+	0x78, 0x70, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x5f, 0x61,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73,
+	0x41, 0x74, 0x22, 0x2c, 0x0a, 0x10, 0x41, 0x64, 0x64, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73,
+	0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x22, 0x2f, 0x0a, 0x13, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65,
+	0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73,
+	0x73, 0x22, 0x30, 0x0a, 0x14, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x4d, 0x65, 0x6d, 0x62, 0x65,
+	0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x6d, 0x62,
+	0x65, 0x72, 0x73, 0x22, 0x2b, 0x0a, 0x0f, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69,
+	0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x32, 0xf1, 0x03, 0x0a, 0x04, 0x52, 0x61, 0x66, 0x74, 0x12, 0x33, 0x0a, 0x0b, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x12, 0x11, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e,
+	0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x72, 0x61,
+	0x66, 0x74, 0x2e, 0x56, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x36,
+	0x0a, 0x0a, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x4c, 0x6f, 0x67, 0x73, 0x12, 0x13, 0x2e, 0x72,
+	0x61, 0x66, 0x74, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x11, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0a, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x4e, 0x6f, 0x77, 0x12, 0x17, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e,
+	0x72, 0x61, 0x66, 0x74, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4e, 0x6f, 0x77, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x2c, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x12, 0x11,
+	0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x0f, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x0f, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x53,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x13, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x53,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x1a, 0x1a, 0x2e, 0x72,
+	0x61, 0x66, 0x74, 0x2e, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73,
+	0x68, 0x6f, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x28, 0x01, 0x12, 0x3c, 0x0a, 0x09,
+	0x41, 0x64, 0x64, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x16, 0x2e, 0x72, 0x61, 0x66, 0x74,
+	0x2e, 0x41, 0x64, 0x64, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x15, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x68, 0x69, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0c, 0x52, 0x65,
+	0x6d, 0x6f, 0x76, 0x65, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x19, 0x2e, 0x72, 0x61, 0x66,
+	0x74, 0x2e, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x4d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x44,
+	0x0a, 0x0d, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x12,
+	0x1a, 0x2e, 0x72, 0x61, 0x66, 0x74, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x4d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x72, 0x61,
+	0x66, 0x74, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x22, 0x00, 0x42, 0x28, 0x5a, 0x26, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x62, 0x74, 0x6d, 0x6f, 0x72, 0x72, 0x2f, 0x6c, 0x65, 0x69, 0x66, 0x64, 0x62,
+	0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x72, 0x61, 0x66, 0x74, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_raft_proto_rawDescOnce sync.Once
+	file_raft_proto_rawDescData = file_raft_proto_rawDesc
+)
+
+func file_raft_proto_rawDescGZIP() []byte {
+	file_raft_proto_rawDescOnce.Do(func() {
+		file_raft_proto_rawDescData = protoimpl.X.CompressGZIP(file_raft_proto_rawDescData)
+	})
+	return file_raft_proto_rawDescData
+}
+
+var file_raft_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_raft_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
+var file_raft_proto_goTypes = []interface{}{
+	(VoteReply_DenyReason)(0),    // 0: raft.VoteReply.DenyReason
+	(LogRecord_Action)(0),        // 1: raft.LogRecord.Action
+	(*Node)(nil),                 // 2: raft.Node
+	(*VoteRequest)(nil),          // 3: raft.VoteRequest
+	(*VoteReply)(nil),            // 4: raft.VoteReply
+	(*AppendRequest)(nil),        // 5: raft.AppendRequest
+	(*AppendReply)(nil),          // 6: raft.AppendReply
+	(*TimeoutNowRequest)(nil),    // 7: raft.TimeoutNowRequest
+	(*TimeoutNowReply)(nil),      // 8: raft.TimeoutNowReply
+	(*PingRequest)(nil),          // 9: raft.PingRequest
+	(*PingReply)(nil),            // 10: raft.PingReply
+	(*SnapshotChunk)(nil),        // 11: raft.SnapshotChunk
+	(*InstallSnapshotReply)(nil), // 12: raft.InstallSnapshotReply
+	(*LogRecord)(nil),            // 13: raft.LogRecord
+	(*LogStore)(nil),             // 14: raft.LogStore
+	(*TermRecord)(nil),           // 15: raft.TermRecord
+	(*ExportRecord)(nil),         // 16: raft.ExportRecord
+	(*AddServerRequest)(nil),     // 17: raft.AddServerRequest
+	(*RemoveServerRequest)(nil),  // 18: raft.RemoveServerRequest
+	(*ChangeMembersRequest)(nil), // 19: raft.ChangeMembersRequest
+	(*MembershipReply)(nil),      // 20: raft.MembershipReply
+}
+var file_raft_proto_depIdxs = []int32{
+	2,  // 0: raft.VoteRequest.candidate:type_name -> raft.Node
+	2,  // 1: raft.VoteReply.node:type_name -> raft.Node
+	0,  // 2: raft.VoteReply.denyReason:type_name -> raft.VoteReply.DenyReason
+	2,  // 3: raft.AppendRequest.leader:type_name -> raft.Node
+	13, // 4: raft.AppendRequest.entries:type_name -> raft.LogRecord
+	2,  // 5: raft.TimeoutNowRequest.leader:type_name -> raft.Node
+	2,  // 6: raft.PingRequest.node:type_name -> raft.Node
+	2,  // 7: raft.PingReply.node:type_name -> raft.Node
+	2,  // 8: raft.SnapshotChunk.leader:type_name -> raft.Node
+	1,  // 9: raft.LogRecord.action:type_name -> raft.LogRecord.Action
+	13, // 10: raft.LogRecord.ops:type_name -> raft.LogRecord
+	13, // 11: raft.LogRecord.compares:type_name -> raft.LogRecord
+	13, // 12: raft.LogRecord.else_ops:type_name -> raft.LogRecord
+	13, // 13: raft.LogStore.entries:type_name -> raft.LogRecord
+	2,  // 14: raft.TermRecord.votedFor:type_name -> raft.Node
+	3,  // 15: raft.Raft.RequestVote:input_type -> raft.VoteRequest
+	5,  // 16: raft.Raft.AppendLogs:input_type -> raft.AppendRequest
+	7,  // 17: raft.Raft.TimeoutNow:input_type -> raft.TimeoutNowRequest
+	9,  // 18: raft.Raft.Ping:input_type -> raft.PingRequest
+	11, // 19: raft.Raft.InstallSnapshot:input_type -> raft.SnapshotChunk
+	17, // 20: raft.Raft.AddServer:input_type -> raft.AddServerRequest
+	18, // 21: raft.Raft.RemoveServer:input_type -> raft.RemoveServerRequest
+	19, // 22: raft.Raft.ChangeMembers:input_type -> raft.ChangeMembersRequest
+	4,  // 23: raft.Raft.RequestVote:output_type -> raft.VoteReply
+	6,  // 24: raft.Raft.AppendLogs:output_type -> raft.AppendReply
+	8,  // 25: raft.Raft.TimeoutNow:output_type -> raft.TimeoutNowReply
+	10, // 26: raft.Raft.Ping:output_type -> raft.PingReply
+	12, // 27: raft.Raft.InstallSnapshot:output_type -> raft.InstallSnapshotReply
+	20, // 28: raft.Raft.AddServer:output_type -> raft.MembershipReply
+	20, // 29: raft.Raft.RemoveServer:output_type -> raft.MembershipReply
+	20, // 30: raft.Raft.ChangeMembers:output_type -> raft.MembershipReply
+	23, // [23:31] is the sub-list for method output_type
+	15, // [15:23] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
+}
+
+func init() { file_raft_proto_init() }
+func file_raft_proto_init() {
+	if File_raft_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_raft_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Node); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VoteRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
 			}
 		}
 		file_raft_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
@@ -757,7 +2013,7 @@ func file_raft_proto_init() {
 			}
 		}
 		file_raft_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LogRecord); i {
+			switch v := v.(*TimeoutNowRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -769,7 +2025,7 @@ func file_raft_proto_init() {
 			}
 		}
 		file_raft_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*LogStore); i {
+			switch v := v.(*TimeoutNowReply); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -781,6 +2037,78 @@ func file_raft_proto_init() {
 			}
 		}
 		file_raft_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PingRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PingReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SnapshotChunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InstallSnapshotReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LogRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LogStore); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*TermRecord); i {
 			case 0:
 				return &v.state
@@ -792,14 +2120,74 @@ func file_raft_proto_init() {
 				return nil
 			}
 		}
+		file_raft_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExportRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddServerRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RemoveServerRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ChangeMembersRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_raft_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MembershipReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_raft_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   8,
+			NumEnums:      2,
+			NumMessages:   19,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
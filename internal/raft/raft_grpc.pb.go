@@ -19,6 +19,12 @@ const _ = grpc.SupportPackageIsVersion6
 type RaftClient interface {
 	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteReply, error)
 	AppendLogs(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendReply, error)
+	TimeoutNow(ctx context.Context, in *TimeoutNowRequest, opts ...grpc.CallOption) (*TimeoutNowReply, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error)
+	InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (Raft_InstallSnapshotClient, error)
+	AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*MembershipReply, error)
+	RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*MembershipReply, error)
+	ChangeMembers(ctx context.Context, in *ChangeMembersRequest, opts ...grpc.CallOption) (*MembershipReply, error)
 }
 
 type raftClient struct {
@@ -47,12 +53,97 @@ func (c *raftClient) AppendLogs(ctx context.Context, in *AppendRequest, opts ...
 	return out, nil
 }
 
+func (c *raftClient) TimeoutNow(ctx context.Context, in *TimeoutNowRequest, opts ...grpc.CallOption) (*TimeoutNowReply, error) {
+	out := new(TimeoutNowReply)
+	err := c.cc.Invoke(ctx, "/raft.Raft/TimeoutNow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error) {
+	out := new(PingReply)
+	err := c.cc.Invoke(ctx, "/raft.Raft/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) InstallSnapshot(ctx context.Context, opts ...grpc.CallOption) (Raft_InstallSnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Raft_serviceDesc.Streams[0], "/raft.Raft/InstallSnapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &raftInstallSnapshotClient{stream}
+	return x, nil
+}
+
+type Raft_InstallSnapshotClient interface {
+	Send(*SnapshotChunk) error
+	CloseAndRecv() (*InstallSnapshotReply, error)
+	grpc.ClientStream
+}
+
+type raftInstallSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *raftInstallSnapshotClient) Send(m *SnapshotChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *raftInstallSnapshotClient) CloseAndRecv() (*InstallSnapshotReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(InstallSnapshotReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *raftClient) AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*MembershipReply, error) {
+	out := new(MembershipReply)
+	err := c.cc.Invoke(ctx, "/raft.Raft/AddServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*MembershipReply, error) {
+	out := new(MembershipReply)
+	err := c.cc.Invoke(ctx, "/raft.Raft/RemoveServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) ChangeMembers(ctx context.Context, in *ChangeMembersRequest, opts ...grpc.CallOption) (*MembershipReply, error) {
+	out := new(MembershipReply)
+	err := c.cc.Invoke(ctx, "/raft.Raft/ChangeMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RaftServer is the server API for Raft service.
 // All implementations must embed UnimplementedRaftServer
 // for forward compatibility
 type RaftServer interface {
 	RequestVote(context.Context, *VoteRequest) (*VoteReply, error)
 	AppendLogs(context.Context, *AppendRequest) (*AppendReply, error)
+	TimeoutNow(context.Context, *TimeoutNowRequest) (*TimeoutNowReply, error)
+	Ping(context.Context, *PingRequest) (*PingReply, error)
+	InstallSnapshot(Raft_InstallSnapshotServer) error
+	AddServer(context.Context, *AddServerRequest) (*MembershipReply, error)
+	RemoveServer(context.Context, *RemoveServerRequest) (*MembershipReply, error)
+	ChangeMembers(context.Context, *ChangeMembersRequest) (*MembershipReply, error)
 	mustEmbedUnimplementedRaftServer()
 }
 
@@ -66,6 +157,24 @@ func (*UnimplementedRaftServer) RequestVote(context.Context, *VoteRequest) (*Vot
 func (*UnimplementedRaftServer) AppendLogs(context.Context, *AppendRequest) (*AppendReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AppendLogs not implemented")
 }
+func (*UnimplementedRaftServer) TimeoutNow(context.Context, *TimeoutNowRequest) (*TimeoutNowReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TimeoutNow not implemented")
+}
+func (*UnimplementedRaftServer) Ping(context.Context, *PingRequest) (*PingReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (*UnimplementedRaftServer) InstallSnapshot(Raft_InstallSnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method InstallSnapshot not implemented")
+}
+func (*UnimplementedRaftServer) AddServer(context.Context, *AddServerRequest) (*MembershipReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddServer not implemented")
+}
+func (*UnimplementedRaftServer) RemoveServer(context.Context, *RemoveServerRequest) (*MembershipReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveServer not implemented")
+}
+func (*UnimplementedRaftServer) ChangeMembers(context.Context, *ChangeMembersRequest) (*MembershipReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeMembers not implemented")
+}
 func (*UnimplementedRaftServer) mustEmbedUnimplementedRaftServer() {}
 
 func RegisterRaftServer(s *grpc.Server, srv RaftServer) {
@@ -108,6 +217,122 @@ func _Raft_AppendLogs_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Raft_TimeoutNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TimeoutNowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).TimeoutNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/raft.Raft/TimeoutNow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).TimeoutNow(ctx, req.(*TimeoutNowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/raft.Raft/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_AddServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).AddServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/raft.Raft/AddServer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).AddServer(ctx, req.(*AddServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_RemoveServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).RemoveServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/raft.Raft/RemoveServer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).RemoveServer(ctx, req.(*RemoveServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_ChangeMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).ChangeMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/raft.Raft/ChangeMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).ChangeMembers(ctx, req.(*ChangeMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Raft_InstallSnapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RaftServer).InstallSnapshot(&raftInstallSnapshotServer{stream})
+}
+
+type Raft_InstallSnapshotServer interface {
+	SendAndClose(*InstallSnapshotReply) error
+	Recv() (*SnapshotChunk, error)
+	grpc.ServerStream
+}
+
+type raftInstallSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *raftInstallSnapshotServer) SendAndClose(m *InstallSnapshotReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *raftInstallSnapshotServer) Recv() (*SnapshotChunk, error) {
+	m := new(SnapshotChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Raft_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "raft.Raft",
 	HandlerType: (*RaftServer)(nil),
@@ -120,7 +345,33 @@ var _Raft_serviceDesc = grpc.ServiceDesc{
 			MethodName: "AppendLogs",
 			Handler:    _Raft_AppendLogs_Handler,
 		},
+		{
+			MethodName: "TimeoutNow",
+			Handler:    _Raft_TimeoutNow_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Raft_Ping_Handler,
+		},
+		{
+			MethodName: "AddServer",
+			Handler:    _Raft_AddServer_Handler,
+		},
+		{
+			MethodName: "RemoveServer",
+			Handler:    _Raft_RemoveServer_Handler,
+		},
+		{
+			MethodName: "ChangeMembers",
+			Handler:    _Raft_ChangeMembers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "InstallSnapshot",
+			Handler:       _Raft_InstallSnapshot_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "raft.proto",
 }
@@ -0,0 +1,64 @@
+package raftserver
+
+// The Raft RPC server also serves the standard gRPC health checking protocol
+// (grpc.health.v1.Health), so that load balancers and Kubernetes gRPC probes
+// can check a node without a custom endpoint. Statuses are reported for:
+//
+//   - "raft.Raft": serving whenever the RPC server is running, since a node
+//     takes part in replication even while catching up
+//   - "leifdb.Client": serving only while the node can serve client reads (it
+//     is not a witness, not quarantined, has caught up with the leader since
+//     starting, and is not draining). Clients are served over HTTP, so this is
+//     reported here on behalf of the HTTP interface
+//   - "" (the whole server): the same as "leifdb.Client", so a probe that does
+//     not name a service checks readiness
+
+import (
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Services reported by the health server
+const (
+	RaftService   = "raft.Raft"
+	ClientService = "leifdb.Client"
+)
+
+// healthCheckInterval is how often the status of the node is checked
+const healthCheckInterval = time.Second
+
+// servingStatus returns whether the node can serve clients
+func servingStatus(n *node.Node) healthpb.HealthCheckResponse_ServingStatus {
+	if n.IsWitness() || n.Quarantined() || !n.CaughtUp() || n.Draining() {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// updateHealth sets the statuses reported by h from the state of the node
+func updateHealth(h *health.Server, n *node.Node) {
+	h.SetServingStatus(RaftService, healthpb.HealthCheckResponse_SERVING)
+	status := servingStatus(n)
+	h.SetServingStatus(ClientService, status)
+	h.SetServingStatus("", status)
+}
+
+// monitorHealth keeps the statuses reported by h up to date, checking the node
+// every interval, until done is closed, then reports every service as not
+// serving
+func monitorHealth(h *health.Server, n *node.Node, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			updateHealth(h, n)
+		case <-done:
+			h.Shutdown()
+			return
+		}
+	}
+}
@@ -2,14 +2,25 @@ package raftserver
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
+	"time"
 
+	"github.com/btmorr/leifdb/internal/logging"
 	"github.com/btmorr/leifdb/internal/node"
 	"github.com/btmorr/leifdb/internal/raft"
-	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// logger logs the raft RPC server
+var logger = logging.For(logging.Raft)
+
 type server struct {
 	raft.UnimplementedRaftServer
 	Node *node.Node
@@ -17,24 +28,158 @@ type server struct {
 
 // RequestVote handles RPC vote requests from other nodes
 func (s *server) RequestVote(ctx context.Context, v *raft.VoteRequest) (*raft.VoteReply, error) {
-	log.Debug().Msgf("Received vote request: %v", v)
+	logger.Debug().Msgf("Received vote request: %v", v)
 	return s.Node.HandleVote(v), nil
 }
 
-// AppendLogs handles RPC log-append requests from other nodes
+// AppendLogs handles RPC log-append requests from other nodes. The request IDs
+// of the entries, if any, are echoed in the reply header, so that the leader can
+// match replies to writes
 func (s *server) AppendLogs(ctx context.Context, a *raft.AppendRequest) (*raft.AppendReply, error) {
-	log.Debug().Msgf("Received append request: %v", a)
+	logger.Debug().Msgf("Received append request: %v", a)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(node.RequestIDMetadataKey); len(ids) > 0 {
+			grpc.SetHeader(ctx, metadata.Pairs(node.RequestIDMetadataKey, ids[0]))
+		}
+	}
 	return s.Node.HandleAppend(a), nil
 }
 
-// StartRaftServer constructs and starts a gRPC server for Raft protocol routes
+// TimeoutNow handles RPC requests from the leader to start an election, which
+// are sent to transfer leadership
+func (s *server) TimeoutNow(ctx context.Context, t *raft.TimeoutNowRequest) (*raft.TimeoutNowReply, error) {
+	logger.Debug().Msgf("Received timeout-now request: %v", t)
+	return s.Node.HandleTimeoutNow(t), nil
+}
+
+// Ping handles RPC reachability checks from other nodes
+func (s *server) Ping(ctx context.Context, p *raft.PingRequest) (*raft.PingReply, error) {
+	logger.Trace().Msgf("Received ping: %v", p)
+	return s.Node.HandlePing(p), nil
+}
+
+// InstallSnapshot handles a snapshot streamed by the leader to bootstrap this
+// node. The chunks are collected, and the snapshot installed once the stream
+// ends. A stream from a node that is not a valid leader is refused
+func (s *server) InstallSnapshot(stream raft.Raft_InstallSnapshotServer) error {
+	var first *raft.SnapshotChunk
+	var data []byte
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !s.Node.ReceiveSnapshotChunk(chunk) {
+			_, term := s.Node.CurrentRole()
+			return stream.SendAndClose(&raft.InstallSnapshotReply{Term: term})
+		}
+		if first == nil {
+			logger.Info().
+				Int64("term", chunk.Term).
+				Int64("lastIndex", chunk.LastIndex).
+				Int64("size", chunk.Size).
+				Msg("Receiving snapshot")
+			first, data = chunk, make([]byte, 0, chunk.Size)
+		}
+		if chunk.Offset != int64(len(data)) {
+			return status.Errorf(codes.InvalidArgument,
+				"snapshot chunk at offset %d, expected %d", chunk.Offset, len(data))
+		}
+		data = append(data, chunk.Data...)
+	}
+	if first == nil || int64(len(data)) != first.Size {
+		return status.Error(codes.InvalidArgument, "incomplete snapshot")
+	}
+	return stream.SendAndClose(s.Node.HandleInstallSnapshot(first, data))
+}
+
+// AddServer handles requests to add a member to the cluster, which must be
+// sent to the leader. It returns once the member has caught up and the new
+// membership is committed
+func (s *server) AddServer(ctx context.Context, r *raft.AddServerRequest) (*raft.MembershipReply, error) {
+	logger.Info().Str("address", r.Address).Msg("Received add-server request")
+	if r.Address == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing address")
+	}
+	members, err := s.Node.AddServer(ctx, r.Address)
+	if err != nil {
+		return nil, membershipError(err)
+	}
+	return &raft.MembershipReply{Members: members}, nil
+}
+
+// RemoveServer handles requests to remove a member from the cluster, which
+// must be sent to the leader. It returns once the new membership is committed
+func (s *server) RemoveServer(ctx context.Context, r *raft.RemoveServerRequest) (*raft.MembershipReply, error) {
+	logger.Info().Str("address", r.Address).Msg("Received remove-server request")
+	if r.Address == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing address")
+	}
+	members, err := s.Node.RemoveServer(ctx, r.Address)
+	if err != nil {
+		return nil, membershipError(err)
+	}
+	return &raft.MembershipReply{Members: members}, nil
+}
+
+// ChangeMembers handles requests to change the members of the cluster to any
+// new set, which must be sent to the leader. It returns once the new
+// membership is committed
+func (s *server) ChangeMembers(ctx context.Context, r *raft.ChangeMembersRequest) (*raft.MembershipReply, error) {
+	logger.Info().Strs("members", r.Members).Msg("Received change-members request")
+	members, err := s.Node.ChangeMembers(ctx, r.Members)
+	if err != nil {
+		return nil, membershipError(err)
+	}
+	return &raft.MembershipReply{Members: members}, nil
+}
+
+// membershipError converts an error changing the membership to a gRPC status
+func membershipError(err error) error {
+	switch {
+	case errors.Is(err, node.ErrNotLeaderRecv), errors.Is(err, node.ErrRemoveLeader),
+		errors.Is(err, node.ErrChangeInProgress):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, node.ErrNoMembers):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, node.ErrMemberExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, node.ErrPeerRemoved):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, node.ErrBootstrapFailed):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// StartRaftServer constructs and starts a gRPC server for Raft protocol routes,
+// and the gRPC health checking service (see health.go)
 // Note: `port` must be in the form ":12345"
 func StartRaftServer(lis net.Listener, n *node.Node) *grpc.Server {
+	return startRaftServer(lis, n, healthCheckInterval)
+}
+
+// startRaftServer starts a Raft server that checks the health of the node
+// every healthInterval
+func startRaftServer(lis net.Listener, n *node.Node, healthInterval time.Duration) *grpc.Server {
 	s := grpc.NewServer()
 	raft.RegisterRaftServer(s, &server{Node: n})
+	h := health.NewServer()
+	updateHealth(h, n)
+	healthpb.RegisterHealthServer(s, h)
+	done := make(chan struct{})
+	go monitorHealth(h, n, healthInterval, done)
 	go func() {
+		defer close(done)
 		if err := s.Serve(lis); err != nil {
-			log.Fatal().Err(err).Msg("gRPC failed to serve")
+			logger.Fatal().Err(err).Msg("gRPC failed to serve")
 		}
 	}()
 	return s
@@ -13,13 +13,17 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
 	db "github.com/btmorr/leifdb/internal/database"
 	"github.com/btmorr/leifdb/internal/node"
 	"github.com/btmorr/leifdb/internal/raft"
 	"github.com/btmorr/leifdb/internal/testutil"
-	"github.com/btmorr/leifdb/internal/util"
+	"github.com/btmorr/leifdb/retry"
 )
 
 func init() {
@@ -34,22 +38,20 @@ func checkMock(addr string, known map[string]*node.ForeignNode) bool {
 }
 
 // setupServer configures a Database and a Node, mocks cluster membership check,
-// and creates a test directory that is cleaned up after each test
+// and creates a test directory of its own that is cleaned up after each test
 func setupServer(t *testing.T) *node.Node {
 	addr := "localhost:16990"
 	clientAddr := "localhost:8080"
 
-	testDir, err := util.CreateTmpDir(".tmp-leifdb")
-	if err != nil {
-		log.Fatalln("Error creating test dir:", err)
-	}
-	t.Cleanup(func() {
-		util.RemoveTmpDir(testDir)
-	})
+	testDir := t.TempDir()
 
 	store := db.NewDatabase()
 
 	config := node.NewNodeConfig(testDir, addr, clientAddr, make([]string, 0, 0))
+	// the log is written in place: truncating and rewriting it can take
+	// longer than a leader waits for an append on some filesystems (ext4
+	// flushes a truncated file when it is closed)
+	config.LogPreallocate = 1 << 16
 	n, _ := node.NewNode(config, store)
 	n.CheckForeignNode = checkMock
 	return n
@@ -72,10 +74,7 @@ func TestAppend(t *testing.T) {
 	addr := "localhost:16990"
 	clientAddr := "localhost:8080"
 
-	testDir, _ := util.CreateTmpDir(".tmp-leifdb")
-	t.Cleanup(func() {
-		util.RemoveTmpDir(testDir)
-	})
+	testDir := t.TempDir()
 
 	config := node.NewNodeConfig(testDir, addr, clientAddr, make([]string, 0, 0))
 
@@ -141,17 +140,6 @@ func TestAppend(t *testing.T) {
 			expectedSuccess: false,
 			expectedStore:   starterLog,
 			expectedDb:      make(map[string]string)},
-		{
-			name:            "Invalid leader",
-			sendTerm:        termRecord.Term,
-			sendId:          invalidLeader,
-			sendPrevIdx:     0,
-			sendPrevTerm:    0,
-			sendCommit:      2,
-			sendRecords:     make([]*raft.LogRecord, 0, 0),
-			expectedSuccess: false,
-			expectedStore:   starterLog,
-			expectedDb:      make(map[string]string)},
 		{
 			name:            "Empty valid request",
 			sendTerm:        termRecord.Term,
@@ -163,6 +151,19 @@ func TestAppend(t *testing.T) {
 			expectedSuccess: true,
 			expectedStore:   starterLog,
 			expectedDb:      make(map[string]string)},
+		{
+			// once an append from the leader of the term has been accepted, an
+			// append from any other node in the same term is rejected
+			name:            "Invalid leader",
+			sendTerm:        termRecord.Term,
+			sendId:          invalidLeader,
+			sendPrevIdx:     0,
+			sendPrevTerm:    0,
+			sendCommit:      2,
+			sendRecords:     make([]*raft.LogRecord, 0, 0),
+			expectedSuccess: false,
+			expectedStore:   starterLog,
+			expectedDb:      make(map[string]string)},
 		{
 			name:            "New record",
 			sendTerm:        termRecord.Term,
@@ -262,12 +263,14 @@ func TestVote(t *testing.T) {
 	n.State = node.Leader
 	n.DoElection()
 	// mock behavior of StateManager
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		for {
 			select {
 			case <-n.Reset:
-				n.State = node.Follower
-			default:
+			case <-done:
+				return
 			}
 		}
 	}()
@@ -285,11 +288,13 @@ func TestVote(t *testing.T) {
 			expectNodeState: node.Leader},
 		{
 			name: "Vote request valid",
+			// the leader may have appended the NOOP of its term by now, so
+			// the candidate's log must be at least as up to date
 			request: &raft.VoteRequest{
 				Term:         3,
 				Candidate:    testRaftNode,
-				LastLogIndex: -1,
-				LastLogTerm:  0},
+				LastLogIndex: 0,
+				LastLogTerm:  2},
 			expectTerm:      3,
 			expectVote:      true,
 			expectNodeState: node.Follower}}
@@ -328,11 +333,11 @@ func TestVote(t *testing.T) {
 				reply.Node)
 		}
 		// Ensure node logs are as expected
-		if n.State != tc.expectNodeState {
+		if state, _ := n.CurrentRole(); state != tc.expectNodeState {
 			t.Errorf("[%s] Expected node to be a %v but it is a %v",
 				tc.name,
 				tc.expectNodeState,
-				n.State)
+				state)
 		}
 	}
 }
@@ -367,4 +372,431 @@ func TestServe(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Request failed: %v", err)
 	}
+	ping, err := client.Ping(ctx, &raft.PingRequest{
+		Node: &raft.Node{Id: "localhost:12345"}})
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if ping.Node.Id != n.RaftNode.Id {
+		t.Errorf("Expected ping reply from %s, got %v", n.RaftNode.Id, ping.Node)
+	}
+	// request IDs sent with an append are echoed in the reply header
+	var header metadata.MD
+	appendCtx := metadata.AppendToOutgoingContext(ctx, node.RequestIDMetadataKey, "write-1")
+	_, err = client.AppendLogs(appendCtx, &raft.AppendRequest{
+		Term:         1,
+		Leader:       &raft.Node{Id: "localhost:12345", ClientAddr: "localhost:8081"},
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1,
+		Entries:      []*raft.LogRecord{{Term: 1, Key: "a", RequestId: "write-1"}},
+	}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if ids := header.Get(node.RequestIDMetadataKey); len(ids) != 1 || ids[0] != "write-1" {
+		t.Errorf("Expected request ID in reply header, got %v", ids)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	n := setupServer(t)
+	lis := bufconn.Listen(1024 * 1024)
+	s := startRaftServer(lis, n, 10*time.Millisecond)
+	defer s.Stop()
+
+	bufDialer := func(c context.Context, s string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Health check of %q failed: %v", service, err)
+		}
+		return resp.Status
+	}
+
+	// a follower that has not caught up takes part in Raft, but can't serve
+	// clients
+	if status := check(RaftService); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected Raft service to be serving, got %v", status)
+	}
+	for _, service := range []string{"", ClientService} {
+		if status := check(service); status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("Expected %q not to be serving before catching up, got %v", service, status)
+		}
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "other"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for an unknown service, got %v", err)
+	}
+
+	n.State = node.Leader
+	deadline := time.Now().Add(2 * time.Second)
+	for check("") != healthpb.HealthCheckResponse_SERVING {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected node to be serving once leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := check(ClientService); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected client service to be serving, got %v", status)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	follower := setupServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	s := StartRaftServer(lis, follower)
+	defer s.Stop()
+
+	testDir := t.TempDir()
+	config := node.NewNodeConfig(testDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	leader, _ := node.NewNode(config, db.NewDatabase())
+	leader.CheckForeignNode = checkMock
+	leader.State = node.Leader
+	leader.Set(context.Background(), "a", "1")
+	leader.Set(context.Background(), "b", "2")
+
+	if _, err := follower.AddMember("localhost:16992"); err != node.ErrNotLeaderRecv {
+		t.Errorf("Expected %v adding a member on a follower, got %v", node.ErrNotLeaderRecv, err)
+	}
+	addr := lis.Addr().String()
+	if _, err := leader.AddMember(addr); err != nil {
+		t.Fatal("Error adding member:", err)
+	}
+	if _, err := leader.AddMember(addr); err != node.ErrMemberExists {
+		t.Errorf("Expected %v adding a member twice, got %v", node.ErrMemberExists, err)
+	}
+
+	var status node.BootstrapStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status = leader.Bootstraps()[0]
+		if status.Phase == node.BootstrapPromoted || status.Phase == node.BootstrapFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Phase != node.BootstrapPromoted {
+		t.Fatalf("Expected member to be promoted, got %+v", status)
+	}
+	if status.SnapshotIndex != 1 || status.SentBytes != status.SnapshotBytes {
+		t.Errorf("Expected the whole snapshot as of entry 1 to be sent, got %+v", status)
+	}
+	if follower.Store.Get("b") != "2" || follower.Applied() != 1 || follower.Log.BaseIndex != 2 {
+		t.Errorf("Expected follower to install the snapshot, got last applied %d", follower.Applied())
+	}
+	if peers := leader.Peers(); peers[0].Learner {
+		t.Error("Expected promoted member not to be a learner")
+	}
+
+	// the promoted member is sent the entries after the snapshot: the
+	// membership including it, committed on promotion, then later writes
+	if err := leader.Set(context.Background(), "c", "3"); err != nil {
+		t.Fatal("Error writing after bootstrap:", err)
+	}
+	// no heartbeats are running to resend an append that times out while the
+	// connection to the member is set up, so appends are sent until one gets
+	// through
+	for i := 0; i < 100 && follower.LastLogIndex() < 3; i++ {
+		leader.SendAppend(context.Background(), retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+	entries := follower.Log.Entries
+	if len(entries) != 2 || entries[0].Action != raft.LogRecord_MEMBERS || entries[1].Key != "c" {
+		t.Errorf("Expected follower to have the entries after the snapshot, got %v", entries)
+	}
+	// the member reports its time in its replies, which gives its clock
+	// offset once a reply has been handled
+	var offset *time.Duration
+	deadline = time.Now().Add(time.Second)
+	for offset == nil && time.Now().Before(deadline) {
+		offset = leader.Peers()[0].ClockOffset
+		time.Sleep(10 * time.Millisecond)
+	}
+	if offset == nil || *offset > time.Second || *offset < -time.Second {
+		t.Errorf("Expected the member's clock offset to be estimated, got %v", offset)
+	}
+}
+
+func TestLearner(t *testing.T) {
+	learner := setupServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	s := StartRaftServer(lis, learner)
+	defer s.Stop()
+
+	testDir := t.TempDir()
+	config := node.NewNodeConfig(testDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	leader, _ := node.NewNode(config, db.NewDatabase())
+	leader.CheckForeignNode = checkMock
+	leader.State = node.Leader
+	leader.Set(context.Background(), "a", "1")
+
+	addr := lis.Addr().String()
+	if _, err := leader.AddLearner(addr); err != nil {
+		t.Fatal("Error adding learner:", err)
+	}
+	ctx := context.Background()
+	if _, err := leader.Promote(ctx, "localhost:16992"); err != node.ErrPeerRemoved {
+		t.Errorf("Expected %v promoting a non-member, got %v", node.ErrPeerRemoved, err)
+	}
+
+	var status node.BootstrapStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status = leader.Bootstraps()[0]
+		if status.Phase == node.BootstrapCaughtUp || status.Phase == node.BootstrapFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Phase != node.BootstrapCaughtUp {
+		t.Fatalf("Expected learner to catch up, got %+v", status)
+	}
+
+	// the learner stays a learner: it is sent entries, but is not a voting
+	// member and can't be elected
+	time.Sleep(50 * time.Millisecond)
+	if peers := leader.Peers(); !peers[0].Learner {
+		t.Error("Expected caught-up member to still be a learner")
+	}
+	if members := leader.Members(); len(members) != 1 {
+		t.Errorf("Expected the learner not to be a member, got %v", members)
+	}
+	if err := leader.Set(ctx, "b", "2"); err != nil {
+		t.Fatal("Error writing with a learner:", err)
+	}
+	for i := 0; i < 100 && leader.Peers()[0].MatchIndex < leader.LastLogIndex(); i++ {
+		leader.SendAppend(ctx, retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if learner.LastLogIndex() != 1 {
+		t.Errorf("Expected learner to be sent entries, got last index %d", learner.LastLogIndex())
+	}
+	reply := leader.HandleVote(&raft.VoteRequest{
+		Term:         leader.Term + 1,
+		Candidate:    &raft.Node{Id: addr},
+		LastLogIndex: learner.LastLogIndex(),
+		LastLogTerm:  leader.Term})
+	if reply.VoteGranted || reply.DenyReason != raft.VoteReply_UNKNOWN_NODE {
+		t.Errorf("Expected a learner's vote request to be refused, got %v", reply)
+	}
+
+	members, err := leader.Promote(ctx, addr)
+	if err != nil {
+		t.Fatal("Error promoting learner:", err)
+	}
+	if len(members) != 2 || leader.Peers()[0].Learner {
+		t.Errorf("Expected learner to be promoted, got members %v", members)
+	}
+	if status := leader.Bootstraps()[0]; status.Phase != node.BootstrapPromoted {
+		t.Errorf("Expected promoted phase, got %+v", status)
+	}
+	if _, err := leader.Promote(ctx, addr); err != node.ErrNotLearner {
+		t.Errorf("Expected %v promoting a voter, got %v", node.ErrNotLearner, err)
+	}
+	if _, err := learner.Promote(ctx, addr); err != node.ErrNotLeaderRecv {
+		t.Errorf("Expected %v promoting on a follower, got %v", node.ErrNotLeaderRecv, err)
+	}
+}
+
+func TestMembershipChange(t *testing.T) {
+	follower := setupServer(t)
+	followerLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	fs := StartRaftServer(followerLis, follower)
+	defer fs.Stop()
+
+	testDir := t.TempDir()
+	config := node.NewNodeConfig(testDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	leader, _ := node.NewNode(config, db.NewDatabase())
+	leader.CheckForeignNode = checkMock
+	leader.State = node.Leader
+	leader.Set(context.Background(), "a", "1")
+	leaderLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	ls := StartRaftServer(leaderLis, leader)
+	defer ls.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dial := func(lis net.Listener) raft.RaftClient {
+		conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			t.Fatal("Failed to dial:", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return raft.NewRaftClient(conn)
+	}
+	leaderClient, followerClient := dial(leaderLis), dial(followerLis)
+	member := followerLis.Addr().String()
+
+	_, err = followerClient.AddServer(ctx, &raft.AddServerRequest{Address: "localhost:16992"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected %v adding a member on a follower, got %v", codes.FailedPrecondition, err)
+	}
+
+	reply, err := leaderClient.AddServer(ctx, &raft.AddServerRequest{Address: member})
+	if err != nil {
+		t.Fatal("Error adding member:", err)
+	}
+	expected := []string{member, "localhost:16991"}
+	if len(reply.Members) != 2 || reply.Members[0] != expected[0] || reply.Members[1] != expected[1] {
+		t.Errorf("Expected members %v, got %v", expected, reply.Members)
+	}
+	if _, err := leaderClient.AddServer(ctx, &raft.AddServerRequest{Address: member}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected %v adding a member twice, got %v", codes.AlreadyExists, err)
+	}
+
+	// the follower learns the membership from the log once it applies it
+	for i := 0; i < 100 && !follower.Store.Exists(node.MembersKey); i++ {
+		leader.SendAppend(context.Background(), retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if follower.Store.Get(node.MembersKey) != `["`+member+`","localhost:16991"]` {
+		t.Errorf("Expected follower to apply the membership, got %q", follower.Store.Get(node.MembersKey))
+	}
+	known := false
+	for _, peer := range follower.Peers() {
+		known = known || peer.Id == "localhost:16991"
+	}
+	if !known {
+		t.Error("Expected follower to add the leader as a peer")
+	}
+
+	for _, c := range []struct {
+		address string
+		code    codes.Code
+	}{
+		{"", codes.InvalidArgument},
+		{"localhost:16991", codes.FailedPrecondition},
+		{"localhost:16999", codes.NotFound},
+	} {
+		_, err := leaderClient.RemoveServer(ctx, &raft.RemoveServerRequest{Address: c.address})
+		if status.Code(err) != c.code {
+			t.Errorf("Expected %v removing %q, got %v", c.code, c.address, err)
+		}
+	}
+
+	reply, err = leaderClient.RemoveServer(ctx, &raft.RemoveServerRequest{Address: member})
+	if err != nil {
+		t.Fatal("Error removing member:", err)
+	}
+	if len(reply.Members) != 1 || reply.Members[0] != "localhost:16991" {
+		t.Errorf("Expected only the leader to be left, got %v", reply.Members)
+	}
+	if peers := leader.Peers(); len(peers) != 0 {
+		t.Errorf("Expected the leader to have no peers, got %v", peers)
+	}
+	if leader.Store.Get(node.MembersKey) != `["localhost:16991"]` {
+		t.Errorf("Expected the leader to apply the membership, got %q", leader.Store.Get(node.MembersKey))
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	follower := setupServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	s := StartRaftServer(lis, follower)
+	defer s.Stop()
+
+	testDir := t.TempDir()
+	config := node.NewNodeConfig(testDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	leader, _ := node.NewNode(config, db.NewDatabase())
+	leader.CheckForeignNode = checkMock
+	leader.State = node.Leader
+	addr := lis.Addr().String()
+	leader.AddForeignNode(addr)
+	if err := leader.Set(context.Background(), "a", "1"); err != nil {
+		t.Fatal("Error writing:", err)
+	}
+	// (no heartbeats are running, so appends are sent until one gets through)
+	for i := 0; i < 100 && leader.Peers()[0].MatchIndex < leader.LastLogIndex(); i++ {
+		leader.SendAppend(context.Background(), retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := follower.TransferLeadership(""); err != node.ErrTransferNotLeader {
+		t.Errorf("Expected %v transferring from a follower, got %v", node.ErrTransferNotLeader, err)
+	}
+	if err := leader.TransferLeadership("localhost:16999"); err != node.ErrPeerRemoved {
+		t.Errorf("Expected %v transferring to a non-member, got %v", node.ErrPeerRemoved, err)
+	}
+	term := leader.Term
+	if err := leader.TransferLeadership(addr); err != nil {
+		t.Fatal("Error transferring leadership:", err)
+	}
+	// the target stands for election as soon as it accepts
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && follower.Term == term {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if follower.Term <= term {
+		t.Errorf("Expected target to start an election after term %d, got term %d", term, follower.Term)
+	}
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	follower := setupServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	s := StartRaftServer(lis, follower)
+	defer s.Stop()
+
+	testDir := t.TempDir()
+	config := node.NewNodeConfig(testDir, "localhost:16991", "localhost:8081", make([]string, 0, 0))
+	leader, _ := node.NewNode(config, db.NewDatabase())
+	leader.CheckForeignNode = checkMock
+	leader.State = node.Leader
+	leader.Set(context.Background(), "a", "1")
+	leader.Set(context.Background(), "b", "2")
+	leader.Set(context.Background(), "c", "3")
+	if _, err := leader.CompactLog(2); err != nil {
+		t.Fatal("Error compacting log:", err)
+	}
+
+	// the follower needs entries that have been compacted, so it is sent a
+	// snapshot rather than append requests
+	leader.AddForeignNode(lis.Addr().String())
+	for i := 0; i < 100 && follower.Applied() < 2; i++ {
+		leader.SendAppend(context.Background(), retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if follower.Store.Get("c") != "3" || follower.LastApplied != 2 || follower.Log.BaseIndex != 3 {
+		t.Fatalf("Expected follower to install a snapshot as of entry 2, got last applied %d",
+			follower.LastApplied)
+	}
+
+	// and then the entries after it
+	if err := leader.Set(context.Background(), "d", "4"); err != nil {
+		t.Fatal("Error writing after snapshot:", err)
+	}
+	for i := 0; i < 100 && follower.LastLogIndex() < 3; i++ {
+		leader.SendAppend(context.Background(), retry.Once, leader.Term)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entries := follower.Log.Entries; len(entries) != 1 || entries[0].Key != "d" {
+		t.Errorf("Expected follower to have the entry after the snapshot, got %v", entries)
+	}
 }
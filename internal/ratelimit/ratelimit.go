@@ -0,0 +1,148 @@
+package ratelimit
+
+// Limits on the rate at which bytes are sent, such as for snapshot transfers
+// and catch-up replication, so that bulk transfers leave room for foreground
+// traffic. A Limiter is a token bucket holding up to one second's worth of
+// bytes. A nil *Limiter places no limit, so callers need not check whether a
+// limit is configured.
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most a Writer writes at once, so that a large write is
+// spread out rather than sent in a burst after a long wait
+const chunkSize = 32 * 1024
+
+// A Limiter limits a rate of bytes per second
+type Limiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// New returns a Limiter allowing bytesPerSecond, or nil (no limit) if
+// bytesPerSecond is not positive. The bucket starts full
+func New(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+		now:    time.Now}
+}
+
+// refill adds the tokens earned since the last refill (must be called with
+// the lock held)
+func (l *Limiter) refill() {
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// Available returns the number of bytes that may be sent now without
+// exceeding the limit (-1 if there is no limit)
+func (l *Limiter) Available() int64 {
+	if l == nil {
+		return -1
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill()
+	if l.tokens < 0 {
+		return 0
+	}
+	return int64(l.tokens)
+}
+
+// Full reports whether a whole second's worth of bytes is available, in which
+// case anything may be sent--even more than a second's worth, so that
+// transfers larger than the limit still make progress
+func (l *Limiter) Full() bool {
+	return l == nil || l.Available() >= int64(l.rate)
+}
+
+// Take records that n bytes were sent. This may take more than is
+// available, in which case later sends wait for the debt to be repaid
+func (l *Limiter) Take(n int64) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill()
+	l.tokens -= float64(n)
+}
+
+// Wait blocks until n bytes (or a second's worth, if n is more) may be sent,
+// and then takes them, or returns ctx.Err() if ctx is done first
+func (l *Limiter) Wait(ctx context.Context, n int64) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.lock.Lock()
+		l.refill()
+		need := float64(n)
+		if need > l.rate {
+			need = l.rate
+		}
+		if l.tokens >= need {
+			l.tokens -= float64(n)
+			l.lock.Unlock()
+			return nil
+		}
+		delay := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.lock.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// A Writer writes to an underlying writer no faster than a Limiter allows
+type Writer struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *Limiter
+}
+
+// NewWriter returns a Writer that writes to w at the rate allowed by limiter,
+// and stops with ctx.Err() if ctx is done while waiting
+func NewWriter(ctx context.Context, w io.Writer, limiter *Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, limiter: limiter}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := w.limiter.Wait(w.ctx, int64(len(chunk))); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
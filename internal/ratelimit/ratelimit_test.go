@@ -0,0 +1,68 @@
+// +build unit
+
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	if New(0) != nil {
+		t.Error("Expected no limiter for a rate of 0")
+	}
+	var unlimited *Limiter
+	if unlimited.Available() != -1 || !unlimited.Full() {
+		t.Error("Expected a nil limiter to place no limit")
+	}
+
+	now := time.Unix(0, 0)
+	l := New(1000)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	if !l.Full() || l.Available() != 1000 {
+		t.Errorf("Expected a full bucket, got %d", l.Available())
+	}
+	l.Take(1500)
+	if l.Available() != 0 || l.Full() {
+		t.Errorf("Expected nothing available while in debt, got %d", l.Available())
+	}
+	now = now.Add(time.Second)
+	if l.Available() != 500 {
+		t.Errorf("Expected 500 bytes after a second, got %d", l.Available())
+	}
+	now = now.Add(time.Hour)
+	if l.Available() != 1000 {
+		t.Errorf("Expected bucket to hold at most a second's worth, got %d", l.Available())
+	}
+}
+
+func TestWriter(t *testing.T) {
+	l := New(100 * 1024)
+	l.Take(100 * 1024)
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, l)
+
+	start := time.Now()
+	data := bytes.Repeat([]byte("x"), 20*1024)
+	if n, err := w.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Expected %d bytes written, got %d, %v", len(data), n, err)
+	}
+	// 20 KiB at 100 KiB per second, starting from an empty bucket
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected write to be throttled, took %v", elapsed)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Expected data to be written unchanged")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.Take(100 * 1024)
+	if _, err := NewWriter(ctx, &buf, l).Write(data); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+// A Server runs a node along with everything it needs to take part in a
+// cluster: the timers that drive elections and appends, the snapshot, expiry,
+// and peer managers, and the Raft RPC server. The client HTTP interface is not
+// part of it--the leifdb binary serves that on top of a Server, while an
+// application embedding leifdb uses the node directly (see package leifdb)
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/btmorr/leifdb/internal/clock"
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/mgmt"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raftserver"
+	"github.com/btmorr/leifdb/internal/webhook"
+	"github.com/btmorr/leifdb/retry"
+)
+
+var logger = logging.For(logging.Raft)
+
+// Defaults for the timing of a Server
+const (
+	// DefaultMinElectionTimeout and DefaultMaxElectionTimeout bound the
+	// election timeout, which is chosen at random between them
+	DefaultMinElectionTimeout = 500 * time.Millisecond
+	DefaultMaxElectionTimeout = 1000 * time.Millisecond
+	// DefaultAppendInterval is the period between append requests from the
+	// leader
+	DefaultAppendInterval = 14 * time.Millisecond
+	// DefaultSnapshotPeriod is the period between checks of whether to take
+	// a snapshot
+	DefaultSnapshotPeriod = time.Minute
+	// DefaultExpiryPeriod is the period between removals of expired keys
+	DefaultExpiryPeriod = time.Second
+	// DefaultPingPeriod is the period between pings of the other members
+	DefaultPingPeriod = time.Second
+)
+
+var (
+	// ErrInvalidTimeouts indicates that the append interval is not shorter
+	// than the minimum election timeout, so followers would start elections
+	// while the leader is healthy
+	ErrInvalidTimeouts = errors.New("appendInterval must be shorter than minimum election window")
+)
+
+// Config is the configuration of a Server. Zero durations (and a nil Clock) are
+// replaced by the defaults
+type Config struct {
+	Node node.NodeConfig
+
+	// Bounds of the election timeout, which is chosen at random between them
+	MinElectionTimeout time.Duration
+	MaxElectionTimeout time.Duration
+	AppendInterval     time.Duration
+	// Source of time for the election and append timers (the system clock if
+	// nil)
+	Clock clock.Clock
+
+	// Snapshots are taken when the log file exceeds SnapshotThreshold bytes or
+	// SnapshotEntries entries have been applied since the last one (see
+	// mgmt.StartSnapshotManager)
+	SnapshotThreshold int64
+	SnapshotEntries   int64
+	SnapshotPeriod    time.Duration
+	RetainSnapshots   int
+	RetainLogEntries  int64
+
+	ExpiryPeriod time.Duration
+	PingPeriod   time.Duration
+
+	// URLs to send cluster events to (see webhook.Notifier)
+	WebhookURLs []string
+
+	// Listener for Raft RPCs from the other members. If nil, the RPC server is
+	// not started, which is only useful for a cluster of one node
+	RaftListener net.Listener
+
+	// Start even if the state in the data directory is inconsistent (see
+	// node.CheckState), only logging the problems found
+	ForceRecover bool
+}
+
+// applyDefaults replaces zero durations with the defaults
+func (c *Config) applyDefaults() {
+	if c.MinElectionTimeout == 0 {
+		c.MinElectionTimeout = DefaultMinElectionTimeout
+	}
+	if c.MaxElectionTimeout == 0 {
+		c.MaxElectionTimeout = DefaultMaxElectionTimeout
+	}
+	if c.AppendInterval == 0 {
+		c.AppendInterval = DefaultAppendInterval
+	}
+	if c.Clock == nil {
+		c.Clock = clock.Real
+	}
+	if c.SnapshotPeriod == 0 {
+		c.SnapshotPeriod = DefaultSnapshotPeriod
+	}
+	if c.ExpiryPeriod == 0 {
+		c.ExpiryPeriod = DefaultExpiryPeriod
+	}
+	if c.PingPeriod == 0 {
+		c.PingPeriod = DefaultPingPeriod
+	}
+}
+
+// A Server is a running node and the managers that drive it
+type Server struct {
+	Node      *node.Node
+	Snapshots *mgmt.SnapshotManager
+	state     *mgmt.StateManager
+	rpc       *grpc.Server
+	stops     []func()
+}
+
+// Start loads the node's state from its data directory (including the latest
+// snapshot) and starts it. If the state is inconsistent, a node.StateError is
+// returned, unless config.ForceRecover is set
+func Start(config Config) (*Server, error) {
+	config.applyDefaults()
+	if config.MinElectionTimeout < config.AppendInterval {
+		// in practice, append interval should be 10x-100x shorter
+		return nil, ErrInvalidTimeouts
+	}
+	if config.Node.ReadIndexWindow > config.AppendInterval {
+		// leadership is only known to hold for about a heartbeat interval
+		logger.Warn().
+			Dur("window", config.Node.ReadIndexWindow).
+			Dur("appendInterval", config.AppendInterval).
+			Msg("Read index window limited to the append interval")
+		config.Node.ReadIndexWindow = config.AppendInterval
+	}
+	if config.Node.LeaderLease > config.MinElectionTimeout {
+		// followers stand for election once the election timeout passes,
+		// whether or not the lease has
+		logger.Warn().
+			Dur("lease", config.Node.LeaderLease).
+			Dur("minElectionTimeout", config.MinElectionTimeout).
+			Msg("Leader lease limited to the minimum election timeout")
+		config.Node.LeaderLease = config.MinElectionTimeout
+	}
+
+	snapshot := mgmt.LatestSnapshotMeta(config.Node.DataDir)
+	if err := node.ValidateState(config.Node, snapshot); err != nil {
+		if !config.ForceRecover {
+			return nil, err
+		}
+		logger.Warn().Err(err).Msg("Starting with inconsistent state (force recover)")
+	}
+
+	n, err := node.NewNode(config.Node, db.NewDatabase())
+	if err != nil {
+		return nil, err
+	}
+	if len(config.WebhookURLs) > 0 {
+		notifier := webhook.NewNotifier(config.Node.Id, config.WebhookURLs, 2*time.Second)
+		n.AddEventListener(notifier.Notify)
+	}
+
+	// Select random election timeout (in the configured interval)--seeded
+	// here, since an embedding application may not seed the global source
+	electionTimeout := config.MinElectionTimeout
+	if spread := config.MaxElectionTimeout - config.MinElectionTimeout; spread > 0 {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		electionTimeout += time.Duration(r.Int63n(int64(spread)))
+	}
+	logger.Info().Msgf("Election timeout: %s", electionTimeout.String())
+
+	s := &Server{Node: n}
+	s.Snapshots = mgmt.StartSnapshotManager(
+		config.Node.DataDir,
+		config.Node.LogFile,
+		config.SnapshotThreshold,
+		config.SnapshotEntries,
+		config.SnapshotPeriod,
+		config.RetainSnapshots,
+		config.RetainLogEntries,
+		n)
+
+	s.state = mgmt.NewStateManager(
+		config.Clock,              // Source of the timers' ticks
+		n.Reset,                   // Node -> StateManager: reset election timer
+		electionTimeout,           // Time to wait for election when Follower
+		n.DoElection,              // Call when election timer expires
+		n.ElectionBackoff,         // Extra wait before the next election after one fails
+		config.MinElectionTimeout, // After successful election, window to bar elections
+		n.EndGracePeriod,          // Call when the grace window ends
+		config.AppendInterval,     // Period for doing append job when Leader
+		func() {
+			if state, term := n.CurrentRole(); state == node.Leader {
+				n.SendAppend(context.Background(), retry.Once, term)
+			}
+		}) // Call when append ticker cycles
+
+	s.stops = append(s.stops,
+		mgmt.StartExpiryManager(config.ExpiryPeriod, n),
+		mgmt.StartPeerMonitor(config.PingPeriod, n),
+		mgmt.StartMembershipPolicy(config.PingPeriod, n))
+
+	if config.RaftListener != nil {
+		s.rpc = raftserver.StartRaftServer(config.RaftListener, n)
+	}
+	return s, nil
+}
+
+// Stop stops the node taking part in the cluster: its timers and managers are
+// stopped, and the Raft RPC server is closed. State on disk is left as it is,
+// so a Server can be started again from the same data directory
+func (s *Server) Stop() {
+	if s.rpc != nil {
+		s.rpc.Stop()
+	}
+	s.state.Stop()
+	s.Snapshots.Stop()
+	for _, stop := range s.stops {
+		stop()
+	}
+}
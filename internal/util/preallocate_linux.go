@@ -0,0 +1,26 @@
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// DsyncFlag opens a file so that each write returns once its data is durable,
+// without waiting for metadata (such as the modification time) to be flushed
+const DsyncFlag = syscall.O_DSYNC
+
+// DirectFlag opens a file so that writes bypass the operating system's cache.
+// The buffers written, their lengths, and the offsets they are written at
+// must be multiples of DirectAlignment
+const DirectFlag = syscall.O_DIRECT
+
+// Preallocate reserves disk blocks for f up to size bytes, extending it if it
+// is shorter. Where the filesystem can't allocate blocks ahead of time, the
+// file is extended without them
+func Preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return f.Truncate(size)
+	}
+	return err
+}
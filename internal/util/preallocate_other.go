@@ -0,0 +1,27 @@
+// +build !linux
+
+package util
+
+import (
+	"os"
+)
+
+// DsyncFlag opens a file so that each write returns once it is durable (data
+// and metadata alike, where the platform has no data-only option)
+const DsyncFlag = os.O_SYNC
+
+// DirectFlag is 0 where writes can't bypass the operating system's cache
+const DirectFlag = 0
+
+// Preallocate extends f to size bytes if it is shorter. Blocks are not
+// reserved ahead of time on this platform
+func Preallocate(f *os.File, size int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= size {
+		return nil
+	}
+	return f.Truncate(size)
+}
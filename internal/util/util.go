@@ -4,8 +4,13 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"unsafe"
 )
 
+// DirectAlignment is the alignment required of writes to a file opened with
+// DirectFlag (the logical block size of most disks and filesystems)
+const DirectAlignment = 4096
+
 // EnsureDirectory creates the directory if it does not exist (fail if path
 // exists and is not a directory)
 func EnsureDirectory(path string) error {
@@ -39,3 +44,14 @@ func CreateTmpDir(dir string) (string, error) {
 func RemoveTmpDir(dir string) error {
 	return os.RemoveAll(dir)
 }
+
+// AlignedBuffer returns a buffer of size bytes that starts at a multiple of
+// DirectAlignment in memory
+func AlignedBuffer(size int) []byte {
+	buf := make([]byte, size+DirectAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % DirectAlignment); rem != 0 {
+		offset = DirectAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package util
+
+import (
+	"syscall"
+)
+
+// VolumeSpace returns the bytes available to unprivileged users, and the total
+// size in bytes, of the filesystem containing path
+func VolumeSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"errors"
+)
+
+// ErrVolumeSpaceUnsupported indicates that free space can't be measured on
+// this platform
+var ErrVolumeSpaceUnsupported = errors.New("Free space is not reported on this platform")
+
+// VolumeSpace is not supported on Windows, and always returns
+// ErrVolumeSpaceUnsupported
+func VolumeSpace(path string) (uint64, uint64, error) {
+	return 0, 0, ErrVolumeSpaceUnsupported
+}
@@ -0,0 +1,95 @@
+package webhook
+
+// The webhook notifier is aware of node events, but node should not be aware
+// of the notifier (register it with `Node.AddEventListener`)
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+// logger logs webhook deliveries
+var logger = logging.For(logging.API)
+
+// queueSize is the number of events that can wait to be sent before new
+// events are dropped
+const queueSize = 256
+
+// A Payload is the JSON body posted to each webhook URL. Source is the id of
+// the node reporting the event
+type Payload struct {
+	Source string `json:"source"`
+	node.Event
+}
+
+// A Notifier posts cluster events to a list of webhook URLs. Events are sent in
+// the background, in the order they occurred, so that reporting an event never
+// blocks the node
+type Notifier struct {
+	source string
+	urls   []string
+	client *http.Client
+	queue  chan node.Event
+}
+
+// NewNotifier creates a Notifier that reports events observed by the node with
+// id `source` to each of the URLs, and starts sending in the background
+func NewNotifier(source string, urls []string, timeout time.Duration) *Notifier {
+	w := &Notifier{
+		source: source,
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan node.Event, queueSize)}
+	go w.run()
+	return w
+}
+
+// Notify queues an event to be sent (it can be passed directly to
+// `Node.AddEventListener`). If the queue is full the event is dropped
+func (w *Notifier) Notify(event node.Event) {
+	select {
+	case w.queue <- event:
+	default:
+		logger.Warn().
+			Str("type", string(event.Type)).
+			Msg("webhook queue full, dropping event")
+	}
+}
+
+// Close stops the notifier after sending any events already queued
+func (w *Notifier) Close() {
+	close(w.queue)
+}
+
+func (w *Notifier) run() {
+	for event := range w.queue {
+		body, err := json.Marshal(Payload{Source: w.source, Event: event})
+		if err != nil {
+			logger.Error().Err(err).Msg("error marshalling webhook payload")
+			continue
+		}
+		for _, url := range w.urls {
+			w.post(url, body)
+		}
+	}
+}
+
+// post sends one payload to one URL, logging (but otherwise ignoring) failures
+func (w *Notifier) post(url string, body []byte) {
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warn().Err(err).Str("url", url).Msg("webhook request failed")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Warn().
+			Int("status", resp.StatusCode).
+			Str("url", url).
+			Msg("webhook rejected")
+	}
+}
@@ -0,0 +1,51 @@
+// +build unit
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+)
+
+func TestNotifier(t *testing.T) {
+	received := make(chan Payload, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		var payload Payload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			t.Errorf("Error decoding payload %s: %v", string(raw), err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	w := NewNotifier("localhost:16990", []string{server.URL}, time.Second)
+	defer w.Close()
+
+	w.Notify(node.Event{Type: node.EventLeaderChange, Term: 3, Node: "localhost:16991"})
+	w.Notify(node.Event{Type: node.EventPeerUnavailable, Term: 3, Node: "localhost:16992"})
+
+	expected := []node.EventType{node.EventLeaderChange, node.EventPeerUnavailable}
+	for _, eventType := range expected {
+		select {
+		case payload := <-received:
+			if payload.Type != eventType {
+				t.Errorf("Expected %s event, got %s", eventType, payload.Type)
+			}
+			if payload.Source != "localhost:16990" {
+				t.Errorf("Expected source localhost:16990, got %s", payload.Source)
+			}
+			if payload.Term != 3 {
+				t.Errorf("Expected term 3, got %d", payload.Term)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s event", eventType)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Package leifdb runs a LeifDB node inside another application, which reads
+// and writes the database through a DB rather than over HTTP. A DB with no
+// peers is a cluster of one, and opens no listeners at all. With peers, it
+// listens for Raft RPCs from them on its RaftAddr (the other members may be
+// embedded or standalone servers)
+package leifdb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/server"
+	"github.com/btmorr/leifdb/internal/util"
+)
+
+// Defaults for Config fields that are left unset
+const (
+	// DefaultRaftAddr identifies a node with no peers
+	DefaultRaftAddr = "localhost:16990"
+	// DefaultSnapshotThreshold is the size of the log file (in bytes) at which
+	// a snapshot is taken
+	DefaultSnapshotThreshold = 1 << 30
+	// DefaultRetainSnapshots is the number of snapshots kept on disk
+	DefaultRetainSnapshots = 1
+	// DefaultRetainLogEntries is the number of entries already covered by a
+	// snapshot that are kept in the log
+	DefaultRetainLogEntries = 1000
+	// DefaultEventBuffer is the number of events an Events channel holds
+	DefaultEventBuffer = 64
+)
+
+var (
+	// ErrClosed indicates that a DB was used after it was closed
+	ErrClosed = errors.New("DB is closed")
+
+	// ErrNotLeader indicates a write to a member that is not the leader (see
+	// DB.Leader for the member to write to instead)
+	ErrNotLeader = node.ErrNotLeaderRecv
+
+	// ErrNotCaughtUp indicates a read from a member that has not caught up
+	// with the leader since starting
+	ErrNotCaughtUp = node.ErrNotCaughtUp
+
+	// ErrWitnessRead indicates a read from a witness, which stores no data
+	ErrWitnessRead = node.ErrWitnessRead
+
+	// ErrQuarantined indicates a read from a member whose state is suspected
+	// to be corrupt or divergent
+	ErrQuarantined = node.ErrQuarantined
+
+	// ErrCommitUncertain indicates a write that was not known to be committed
+	// when this member stopped waiting for it (see CommitUncertainError)
+	ErrCommitUncertain = node.ErrCommitUncertain
+
+	// ErrReadOnly indicates a write rejected because the cluster (or this
+	// member) is in read-only mode (see ReadOnlyError)
+	ErrReadOnly = node.ErrReadOnly
+
+	// ErrInconsistentState indicates that New found the state in DataDir to be
+	// inconsistent, and did not start (see Config.ForceRecover)
+	ErrInconsistentState = node.ErrInconsistentState
+
+	// ErrEntriesCompacted indicates that Entries was asked for entries that
+	// have been compacted into a snapshot
+	ErrEntriesCompacted = node.ErrEntriesCompacted
+)
+
+// A ReadOnlyError is returned by a write rejected in read-only mode, with the
+// reason given when it was set
+type ReadOnlyError = node.ReadOnlyError
+
+// A CommitUncertainError is returned by a write that may still be committed,
+// with the index and term of its log entry
+type CommitUncertainError = node.CommitUncertainError
+
+// A CommittedEntry is an entry of the replicated log, delivered by Entries
+type CommittedEntry = node.CommittedEntry
+
+// LogSyncMode is how writes to the log file are made durable
+type LogSyncMode = node.LogSyncMode
+
+// Modes of syncing the log file (see node.LogSyncMode)
+const (
+	LogSyncNone   = node.LogSyncNone
+	LogSyncFsync  = node.LogSyncFsync
+	LogSyncDsync  = node.LogSyncDsync
+	LogSyncDirect = node.LogSyncDirect
+)
+
+// An Event describes a change in cluster state observed by a DB
+type Event = node.Event
+
+// EventType identifies the kind of an Event
+type EventType = node.EventType
+
+// Kinds of Event (see node.EventType)
+const (
+	EventLeaderChange    = node.EventLeaderChange
+	EventMemberAdded     = node.EventMemberAdded
+	EventMemberRemoved   = node.EventMemberRemoved
+	EventMemberPromoted  = node.EventMemberPromoted
+	EventMemberDemoted   = node.EventMemberDemoted
+	EventPeerAvailable   = node.EventPeerAvailable
+	EventPeerUnavailable = node.EventPeerUnavailable
+	EventQuarantined     = node.EventQuarantined
+)
+
+// Config holds the settings for a DB
+type Config struct {
+	// Directory for the node's log, term, and snapshots (created if it does
+	// not exist)
+	DataDir string
+	// Address ("host:port") at which the other members reach this node. It
+	// identifies the node in the cluster, and is only listened on if there are
+	// Peers
+	RaftAddr string
+	// Address reported as this member's by Leader on the other members, so
+	// that the application can forward writes to it (optional)
+	ClientAddr string
+	// Raft addresses of the other members of the cluster (none for a cluster
+	// of one)
+	Peers []string
+	// Whether this member is a witness, which votes but stores no data
+	Witness bool
+	// Identifies the cluster (optional). It is recorded in DataDir, and New
+	// refuses to start from a DataDir recorded as belonging to another cluster
+	ClusterId string
+	// Start even if the state in DataDir is inconsistent (New otherwise
+	// returns an error matching ErrInconsistentState)
+	ForceRecover bool
+
+	// A snapshot is taken when the log file exceeds SnapshotThreshold bytes,
+	// or SnapshotEntries entries have been applied since the last one (0 for
+	// no limit on entries)
+	SnapshotThreshold int64
+	SnapshotEntries   int64
+	RetainSnapshots   int
+	RetainLogEntries  int64
+
+	// How writes to the log file are made durable (node.DefaultLogSync if
+	// unset), and the steps in bytes in which the log file is preallocated (0
+	// for none)
+	LogSync        LogSyncMode
+	LogPreallocate int64
+
+	// Number of events each Events channel holds before further events are
+	// dropped
+	EventBuffer int
+}
+
+// A DB is a running member of a LeifDB cluster
+type DB struct {
+	s *server.Server
+
+	lock        sync.Mutex
+	closed      bool
+	events      []chan Event
+	eventBuffer int
+	// closed when the DB is closed, to stop Entries streams
+	done chan struct{}
+}
+
+// New starts a member of a cluster, with state loaded from cfg.DataDir. A new
+// cluster of one becomes its own leader after an election timeout (see Ready)
+func New(cfg Config) (*DB, error) {
+	if cfg.RaftAddr == "" {
+		cfg.RaftAddr = DefaultRaftAddr
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if cfg.RetainSnapshots == 0 {
+		cfg.RetainSnapshots = DefaultRetainSnapshots
+	}
+	if cfg.RetainLogEntries == 0 {
+		cfg.RetainLogEntries = DefaultRetainLogEntries
+	}
+	if cfg.EventBuffer == 0 {
+		cfg.EventBuffer = DefaultEventBuffer
+	}
+	if err := util.EnsureDirectory(cfg.DataDir); err != nil {
+		return nil, err
+	}
+
+	config := node.NewNodeConfig(cfg.DataDir, cfg.RaftAddr, cfg.ClientAddr, cfg.Peers)
+	config.Witness = cfg.Witness
+	config.ClusterId = cfg.ClusterId
+	if cfg.LogSync != "" {
+		config.LogSync = cfg.LogSync
+	}
+	config.LogPreallocate = cfg.LogPreallocate
+	serverConfig := server.Config{
+		Node:              config,
+		SnapshotThreshold: cfg.SnapshotThreshold,
+		SnapshotEntries:   cfg.SnapshotEntries,
+		RetainSnapshots:   cfg.RetainSnapshots,
+		RetainLogEntries:  cfg.RetainLogEntries,
+		ForceRecover:      cfg.ForceRecover}
+	if len(cfg.Peers) > 0 {
+		lis, err := net.Listen("tcp", cfg.RaftAddr)
+		if err != nil {
+			return nil, err
+		}
+		serverConfig.RaftListener = lis
+	}
+	s, err := server.Start(serverConfig)
+	if err != nil {
+		if serverConfig.RaftListener != nil {
+			serverConfig.RaftListener.Close()
+		}
+		return nil, err
+	}
+
+	d := &DB{s: s, eventBuffer: cfg.EventBuffer, done: make(chan struct{})}
+	s.Node.AddEventListener(d.publish)
+	return d, nil
+}
+
+// Ready waits until a leader is known and this member can serve reads (or
+// ctx is done)
+func (d *DB) Ready(ctx context.Context) error {
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		if err := d.check(); err != nil {
+			return err
+		}
+		n := d.s.Node
+		if (n.IsLeader() || n.RedirectLeader() != "") && d.readable() == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// IsLeader reports whether this member is currently the leader, which accepts
+// writes
+func (d *DB) IsLeader() bool {
+	return d.s.Node.IsLeader()
+}
+
+// Leader returns the ClientAddr of the current leader, or an empty string if
+// the leader is not known (or is this member)
+func (d *DB) Leader() string {
+	if d.IsLeader() {
+		return ""
+	}
+	return d.s.Node.RedirectLeader()
+}
+
+// readable returns the reason this member can't serve reads, if any
+func (d *DB) readable() error {
+	n := d.s.Node
+	switch {
+	case n.IsWitness():
+		return ErrWitnessRead
+	case n.Quarantined():
+		return ErrQuarantined
+	case !n.CaughtUp():
+		return ErrNotCaughtUp
+	}
+	return nil
+}
+
+// Get returns the value of key, and whether it exists. Reads are served from
+// this member's copy of the database, which may lag behind the leader
+func (d *DB) Get(key string) (string, bool, error) {
+	if err := d.check(); err != nil {
+		return "", false, err
+	}
+	if err := d.readable(); err != nil {
+		return "", false, err
+	}
+	store := d.s.Node.Store
+	if !store.Exists(key) {
+		return "", false, nil
+	}
+	return store.Get(key), true, nil
+}
+
+// Set sets key to value. It returns once the write is committed, or with an
+// error if this member is not the leader
+func (d *DB) Set(ctx context.Context, key string, value string) error {
+	if err := d.check(); err != nil {
+		return err
+	}
+	return d.s.Node.Set(ctx, key, value)
+}
+
+// Delete removes key. It returns once the delete is committed, or with an
+// error if this member is not the leader
+func (d *DB) Delete(ctx context.Context, key string) error {
+	if err := d.check(); err != nil {
+		return err
+	}
+	return d.s.Node.Delete(ctx, key)
+}
+
+// Entries returns a channel that receives every committed entry of the log
+// from fromIndex on, in order, as this member applies it, to build derived
+// views or external indexes. Entries still in the log are delivered first, so
+// an application that records the index of the last entry it handled can
+// resume from the next one. If that entry has been compacted into a snapshot,
+// ErrEntriesCompacted is returned. The channel is closed when ctx is done, the
+// DB is closed, or the reader falls so far behind that the next entry is
+// compacted before it is read
+func (d *DB) Entries(ctx context.Context, fromIndex int64) (<-chan CommittedEntry, error) {
+	if err := d.check(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	entries, err := d.s.Node.Entries(ctx, fromIndex)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return entries, nil
+}
+
+// Events returns a channel that receives the cluster events observed by this
+// member from now on. Events are dropped while the channel is full, and it is
+// closed when the DB is closed
+func (d *DB) Events() <-chan Event {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c := make(chan Event, d.eventBuffer)
+	if d.closed {
+		close(c)
+		return c
+	}
+	d.events = append(d.events, c)
+	return c
+}
+
+// publish sends an event to each Events channel that has room for it
+func (d *DB) publish(event Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, c := range d.events {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+// check returns ErrClosed if the DB has been closed
+func (d *DB) check() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close stops this member, leaving its state on disk, so that a new DB can be
+// started from the same DataDir. Events channels are closed
+func (d *DB) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.done)
+	for _, c := range d.events {
+		close(c)
+	}
+	d.events = nil
+	d.lock.Unlock()
+
+	d.s.Stop()
+	return nil
+}
@@ -0,0 +1,106 @@
+// +build unit
+
+package leifdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+}
+
+func open(t *testing.T, dataDir string) *DB {
+	d, err := New(Config{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("Error starting DB: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestEmbedded(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "leifdb-embed")
+	if err != nil {
+		t.Fatalf("Error creating data dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	d := open(t, dataDir)
+	events := d.Events()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Ready(ctx); err != nil {
+		t.Fatalf("Expected a cluster of one to elect itself, got %v", err)
+	}
+	if !d.IsLeader() {
+		t.Error("Expected DB to be the leader")
+	}
+	select {
+	case event := <-events:
+		if event.Type != EventLeaderChange {
+			t.Errorf("Expected leader change event, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected leader change event")
+	}
+
+	if err := d.Set(ctx, "a", "1"); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	d.Set(ctx, "b", "2")
+	d.Delete(ctx, "b")
+	if value, ok, err := d.Get("a"); value != "1" || !ok || err != nil {
+		t.Errorf("Expected a=1, got %q %v %v", value, ok, err)
+	}
+	if _, ok, err := d.Get("b"); ok || err != nil {
+		t.Errorf("Expected b to be deleted, got %v %v", ok, err)
+	}
+
+	d.Close()
+	if _, _, err := d.Get("a"); err != ErrClosed {
+		t.Errorf("Expected %v after close, got %v", ErrClosed, err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Expected events channel to be closed")
+	}
+
+	// the data is still there when started again
+	reopened := open(t, dataDir)
+	if err := reopened.Ready(ctx); err != nil {
+		t.Fatalf("Expected reopened DB to elect itself, got %v", err)
+	}
+	if value, _, _ := reopened.Get("a"); value != "1" {
+		t.Errorf("Expected a=1 after reopening, got %q", value)
+	}
+}
+
+func TestClusterIdMismatch(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "leifdb-embed")
+	if err != nil {
+		t.Fatalf("Error creating data dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	d, err := New(Config{DataDir: dataDir, ClusterId: "blue"})
+	if err != nil {
+		t.Fatalf("Error starting DB: %v", err)
+	}
+	d.Close()
+
+	if _, err := New(Config{DataDir: dataDir, ClusterId: "green"}); !errors.Is(err, ErrInconsistentState) {
+		t.Fatalf("Expected DB from another cluster's data dir not to start, got %v", err)
+	}
+	d, err = New(Config{DataDir: dataDir, ClusterId: "green", ForceRecover: true})
+	if err != nil {
+		t.Fatalf("Expected DB to start with ForceRecover, got %v", err)
+	}
+	d.Close()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin routes returning a list (members, locks, the trash, and so on) take
+// the same query parameters, so that a client polling a large cluster fetches
+// a page at a time, and only the fields it uses:
+//
+//	limit   most items to return (0 or absent for all of them)
+//	after   return the items after this one, by the key the list is sorted on
+//	        (the `next` of the previous page)
+//	fields  comma-separated JSON field names to keep in each item (absent for
+//	        all of them)
+//
+// A response holding a page that is not the last one has the key of its last
+// item in `next`.
+
+// listQuery holds the pagination and field selection of a list request
+type listQuery struct {
+	limit  int
+	after  string
+	fields []string
+}
+
+// parseListQuery reads the list parameters of a request, or responds with an
+// error and returns false if they are invalid
+func parseListQuery(c *gin.Context) (listQuery, bool) {
+	q := listQuery{after: c.Query("after")}
+	if param := c.Query("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 0 {
+			invalidRequest(c, fmt.Errorf("Invalid limit %q", param))
+			return q, false
+		}
+		q.limit = limit
+	}
+	if param := c.Query("fields"); param != "" {
+		for _, field := range strings.Split(param, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				q.fields = append(q.fields, field)
+			}
+		}
+	}
+	return q, true
+}
+
+// page returns the range [start, end) of the n items of a list (sorted by the
+// key of each, as given by key) making up the page asked for, and the key to
+// continue after if items remain past the page
+func (q listQuery) page(n int, key func(i int) string) (int, int, string) {
+	start := 0
+	if q.after != "" {
+		start = sort.Search(n, func(i int) bool { return key(i) > q.after })
+	}
+	end := n
+	if q.limit > 0 && start+q.limit < n {
+		end = start + q.limit
+	}
+	next := ""
+	if end < n && end > start {
+		next = key(end - 1)
+	}
+	return start, end, next
+}
+
+// respondList sends response, whose list of items is the JSON field named
+// list, keeping only the fields asked for in each item
+func respondList(c *gin.Context, q listQuery, list string, response interface{}) {
+	if len(q.fields) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	selected, err := selectFields(response, list, q.fields)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, selected)
+}
+
+// selectFields returns response as a JSON object, with only the given fields
+// kept in each item of its list
+func selectFields(response interface{}, list string, fields []string) (map[string]interface{}, error) {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(object[list], &items); err != nil {
+		return nil, err
+	}
+	kept := make([]map[string]json.RawMessage, len(items))
+	for i, item := range items {
+		kept[i] = map[string]json.RawMessage{}
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				kept[i][field] = value
+			}
+		}
+	}
+	selected := make(map[string]interface{}, len(object))
+	for name, value := range object {
+		selected[name] = value
+	}
+	selected[list] = kept
+	return selected, nil
+}
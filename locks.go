@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrNoLock indicates a request for, or to release, a lock that is not
+	// held (or not held by the owner releasing it)
+	ErrNoLock = errors.New("Lock is not held")
+)
+
+// LockRequest is a request body template for taking or renewing a lock
+type LockRequest struct {
+	// Who is taking the lock (any string the clients sharing it agree on)
+	Owner string `json:"owner"`
+	// Length of the lease, in milliseconds
+	LeaseMs int64 `json:"leaseMs"`
+}
+
+// LocksResponse lists the locks held
+type LocksResponse struct {
+	Locks []node.Lock `json:"locks"`
+	// Name of the last lock listed, to pass as `after` for the next page
+	// (absent on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// Handler for reading a lock
+// @Summary Return the owner, acquisition time, and lease of a lock
+// @Description The lock is as of the last entry applied by this node.
+// @ID lock-read
+// @Accept */*
+// @Produce application/json
+// @Param name path string true "Lock name"
+// @Success 200 {object} node.Lock
+// @Failure 404 {object} ErrorResponse "Lock is not held"
+// @Router /lock/{name} [get]
+func (ctl *Controller) handleGetLock(c *gin.Context) {
+	lock, ok := ctl.Node.GetLock(c.Param("name"))
+	if !ok {
+		respondError(c, ErrNoLock)
+		return
+	}
+	c.JSON(http.StatusOK, lock)
+}
+
+// Handler for taking a lock
+// @Summary Take a lock if it is free, or renew it if the owner already holds it
+// @Description Tries once, without waiting: if another owner holds the lock,
+// @Description the response is a 409 naming the holder. Whether the lock is
+// @Description free is decided when the entry is applied, so of two owners
+// @Description racing for it, only one gets it. A lock that is not renewed
+// @Description before its lease runs out is free to be taken again.
+// @ID lock-acquire
+// @Accept application/json
+// @Produce application/json
+// @Param name path string true "Lock name"
+// @Param body body LockRequest true "Owner and lease"
+// @Success 200 {object} node.Lock
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 409 {object} ErrorResponse "The lock is held by another owner (see holder)"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
+// @Router /lock/{name} [put]
+func (ctl *Controller) handleAcquireLock(c *gin.Context) {
+	var body LockRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	lock, err := ctl.Node.AcquireLock(
+		ctx, c.Param("name"), body.Owner, time.Duration(body.LeaseMs)*time.Millisecond)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lock)
+}
+
+// Handler for releasing a lock
+// @Summary Release a lock held by an owner
+// @ID lock-release
+// @Accept */*
+// @Produce application/json
+// @Param name path string true "Lock name"
+// @Param owner query string true "Owner releasing the lock"
+// @Success 200 {object} DeleteResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "Lock is not held by the owner"
+// @Router /lock/{name} [delete]
+func (ctl *Controller) handleReleaseLock(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	released, err := ctl.Node.ReleaseLock(ctx, c.Param("name"), c.Query("owner"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !released {
+		respondError(c, ErrNoLock)
+		return
+	}
+	c.JSON(http.StatusOK, DeleteResponse{Status: "Ok"})
+}
+
+// Handler for listing locks
+// @Summary Return every lock held, with its owner, acquisition time, and lease
+// @Description Locks are as of the last entry applied by this node, sorted by
+// @Description name.
+// @ID admin-locks
+// @Accept */*
+// @Produce application/json
+// @Param limit query int false "Most locks to return (default all)"
+// @Param after query string false "Return the locks after this name (next of the previous page)"
+// @Param fields query string false "Comma-separated fields to return of each lock (default all)"
+// @Success 200 {object} LocksResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/locks [get]
+func (ctl *Controller) handleLocks(c *gin.Context) {
+	q, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	locks := ctl.Node.Locks()
+	start, end, next := q.page(len(locks), func(i int) string { return locks[i].Name })
+	respondList(c, q, "locks", LocksResponse{Locks: locks[start:end], Next: next})
+}
@@ -1,19 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/btmorr/leifdb/internal/configuration"
-	"github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/metrics"
 	"github.com/btmorr/leifdb/internal/mgmt"
 	"github.com/btmorr/leifdb/internal/node"
-	"github.com/btmorr/leifdb/internal/raftserver"
+	"github.com/btmorr/leifdb/internal/server"
 	"github.com/gin-gonic/gin"
 	cors "github.com/rs/cors/wrapper/gin"
 	"github.com/rs/zerolog"
@@ -24,8 +27,10 @@ import (
 	_ "github.com/btmorr/leifdb/docs" // Generated by Swag CLI
 )
 
+// logger logs the client HTTP interface
+var logger = logging.For(logging.API)
+
 var (
-	ErrInvalidTimeouts = errors.New("appendInterval must be shorter than minimum election window")
 	// LeifDBVersion is a flag the indicates the version of the current build
 	LeifDBVersion = "Version not defined"
 )
@@ -38,12 +43,168 @@ var (
 
 // Controller wraps routes for HTTP interface
 type Controller struct {
-	Node *node.Node
+	Node      *node.Node
+	events    *eventLog
+	changes   *changeFeed
+	hotKeys   *hotKeys
+	snapshots *mgmt.SnapshotManager
 }
 
 // NewController returns a Controller
-func NewController(n *node.Node) *Controller {
-	return &Controller{Node: n}
+func NewController(n *node.Node, snapshots *mgmt.SnapshotManager) *Controller {
+	events := newEventLog(recentEvents)
+	n.AddEventListener(events.add)
+	changes := newChangeFeed(recentChanges, n.Applied())
+	n.AddApplyHook(changes.hook(n))
+	hotKeys := newHotKeys()
+	n.AddApplyHook(hotKeys.hook())
+	return &Controller{
+		Node:      n,
+		events:    events,
+		changes:   changes,
+		hotKeys:   hotKeys,
+		snapshots: snapshots}
+}
+
+var (
+	requestsCounter = metrics.NewCounter(
+		"leifdb_http_requests_total",
+		"HTTP requests served")
+	errorsCounter = metrics.NewCounter(
+		"leifdb_http_errors_total",
+		"HTTP requests that failed with a server error (5xx)")
+)
+
+// countRequests is middleware that counts requests, and those that fail with a
+// server error
+func countRequests(c *gin.Context) {
+	c.Next()
+	requestsCounter.Inc()
+	if c.Writer.Status() >= http.StatusInternalServerError {
+		errorsCounter.Inc()
+	}
+}
+
+// maxRequestIDLength is the longest request ID accepted from a client (longer
+// ones are replaced with a generated ID)
+const maxRequestIDLength = 64
+
+// tagRequest is middleware that gives each request an ID--the one in the
+// client's X-Request-Id header, or a generated one--which is returned in the
+// response and recorded in the log entries for writes
+func tagRequest(c *gin.Context) {
+	id := c.GetHeader("X-Request-Id")
+	if id == "" || len(id) > maxRequestIDLength {
+		id = node.NewRequestID()
+	}
+	c.Header("X-Request-Id", id)
+	c.Request = c.Request.WithContext(node.WithRequestID(c.Request.Context(), id))
+	c.Next()
+}
+
+// prioritize is middleware that gives each request the priority named in the
+// client's X-Leifdb-Priority header (normal if there is none), which orders
+// its writes against others (see node.Priority)
+func prioritize(c *gin.Context) {
+	priority, err := node.ParsePriority(c.GetHeader("X-Leifdb-Priority"))
+	if err != nil {
+		invalidRequest(c, err)
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(node.WithPriority(c.Request.Context(), priority))
+	c.Next()
+}
+
+// limitTime is middleware that bounds each request by the deadline in the
+// client's X-Leifdb-Timeout header (a duration, e.g. "1.5s"), if it has one.
+// The deadline carries through to the write queue and replication, so a write
+// the client has given up on stops taking its turn (see node/deadline.go)
+func limitTime(c *gin.Context) {
+	header := c.GetHeader("X-Leifdb-Timeout")
+	if header == "" {
+		c.Next()
+		return
+	}
+	timeout, err := time.ParseDuration(header)
+	if err != nil || timeout <= 0 {
+		invalidRequest(c, fmt.Errorf("Invalid X-Leifdb-Timeout %q", header))
+		c.Abort()
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// leaderOrRedirect returns true if this node is the leader. Otherwise, it
+// responds with a redirect to the current presumptive leader (or an error, if
+// no leader is known) and returns false
+func (ctl *Controller) leaderOrRedirect(c *gin.Context) bool {
+	if ctl.Node.IsLeader() {
+		return true
+	}
+	if ctl.Node.RedirectLeader() == "" {
+		respondError(c, node.ErrNotLeaderRecv)
+		return false
+	}
+	redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
+	return false
+}
+
+// readableOrRedirect returns true if this node can serve reads. Witness nodes
+// redirect reads to the current presumptive leader, and a node that has not
+// caught up with the leader since starting responds with an error
+func (ctl *Controller) readableOrRedirect(c *gin.Context) bool {
+	if ctl.Node.IsWitness() {
+		if ctl.Node.RedirectLeader() == "" {
+			respondError(c, node.ErrWitnessRead)
+			return false
+		}
+		redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
+		return false
+	}
+	if ctl.Node.Quarantined() {
+		respondError(c, node.ErrQuarantined)
+		return false
+	}
+	if !ctl.Node.CaughtUp() {
+		respondError(c, node.ErrNotCaughtUp)
+		return false
+	}
+	return true
+}
+
+// writeContext returns the context for a write, carrying the write concern
+// requested with the "concern" query parameter. If the parameter is invalid, it
+// responds with an error and returns false
+func (ctl *Controller) writeContext(c *gin.Context) (context.Context, bool) {
+	concern, err := node.ParseWriteConcern(c.Query("concern"))
+	if err != nil {
+		invalidRequest(c, err)
+		return nil, false
+	}
+	ctx, receipt := node.WithWriteReceipt(node.WithWriteConcern(c.Request.Context(), concern))
+	c.Writer = &receiptWriter{ResponseWriter: c.Writer, receipt: receipt}
+	return ctx, true
+}
+
+// receiptWriter adds the log position of a successful write (see
+// node.WriteReceipt) to the response, in the X-Leifdb-Index and X-Leifdb-Term
+// headers. With concern=leader, the entry is not yet committed when the
+// response is sent
+type receiptWriter struct {
+	gin.ResponseWriter
+	receipt *node.WriteReceipt
+}
+
+func (w *receiptWriter) WriteHeader(code int) {
+	if w.receipt.Index >= 0 {
+		w.Header().Set("X-Leifdb-Index", strconv.FormatInt(w.receipt.Index, 10))
+		w.Header().Set("X-Leifdb-Term", strconv.FormatInt(w.receipt.Term, 10))
+	}
+	w.ResponseWriter.WriteHeader(code)
 }
 
 // HealthResponse is a response body template for the health route [note: this
@@ -72,6 +233,10 @@ func (ctl *Controller) handleHealth(c *gin.Context) {
 // endpoint takes a GET request, so there is no corresponding Request type]
 type ReadResponse struct {
 	Value string `json:"value"`
+	// Size in bytes of the whole value, for reads of a byte range
+	Size *int `json:"size,omitempty"`
+	// How old the data read may be, for reads with max_staleness
+	AgeMillis *float64 `json:"ageMillis,omitempty"`
 }
 
 // Handler for database reads
@@ -79,14 +244,161 @@ type ReadResponse struct {
 // @ID db-read
 // @Accept */*
 // @Produce application/json
+// @Description With max_staleness (such as 500ms), the read is only served if
+// @Description this node's data is known to be current as of that long ago
+// @Description (see Node.Staleness), and the response includes its age.
+// @Description Otherwise, it is redirected to the leader. With offset or
+// @Description length, only that range of bytes of the value is returned
+// @Description (empty past the end of the value), along with the size of the
+// @Description whole value. With linearizable, the read is served by the
+// @Description leader once it has confirmed its leadership, within its lease
+// @Description (see Node.AwaitLinearizable) or by a round of append requests,
+// @Description and reflects every write acknowledged before it.
 // @Param key path string true "Key"
+// @Param max_staleness query string false "Bound on the age of the data read, such as 500ms"
+// @Param linearizable query bool false "Serve the read on the leader, reflecting every acknowledged write (default false)"
+// @Param offset query int false "Byte of the value to start reading at (default 0)"
+// @Param length query int false "Most bytes of the value to read (default the rest of it)"
 // @Success 200 {object} ReadResponse
+// @Header 200 {integer} X-Leifdb-Index "Index of the last log entry applied before the read"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness, or too stale)"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Error message"
 // @Router /db/{key} [get]
 func (ctl *Controller) handleRead(c *gin.Context) {
 	key := c.Param("key")
-	value := ctl.Node.Store.Get(key)
+	var maxStaleness time.Duration
+	if param := c.Query("max_staleness"); param != "" {
+		var err error
+		if maxStaleness, err = time.ParseDuration(param); err != nil || maxStaleness <= 0 {
+			invalidRequest(c, fmt.Errorf("Invalid max_staleness %q", param))
+			return
+		}
+	}
+	offset, length, ranged, err := parseRange(c)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	linearizable, err := strconv.ParseBool(c.DefaultQuery("linearizable", "false"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if linearizable {
+		if !ctl.leaderOrRedirect(c) {
+			return
+		}
+		if err := ctl.Node.AwaitLinearizable(c.Request.Context()); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	// Witness nodes do not store the database, so redirect the read to the
+	// current presumptive leader
+	if ctl.Node.IsWitness() {
+		if ctl.Node.RedirectLeader() == "" {
+			respondError(c, node.ErrWitnessRead)
+			return
+		}
+
+		redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
+		return
+	}
+
+	// Until a node has caught up with the leader after starting, its data may
+	// be arbitrarily stale
+	if !ctl.Node.CaughtUp() {
+		respondError(c, node.ErrNotCaughtUp)
+		return
+	}
+
+	if !ctl.readAllowed(c, key) {
+		return
+	}
+
+	// the value read reflects at least every entry through this index
+	c.Header("X-Leifdb-Index", strconv.FormatInt(ctl.Node.Applied(), 10))
+	response := ReadResponse{}
+	if maxStaleness > 0 {
+		age, ok := ctl.Node.Staleness()
+		if !ok || age > maxStaleness {
+			if !ctl.Node.IsLeader() && ctl.Node.RedirectLeader() != "" {
+				redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
+				return
+			}
+			respondError(c, node.ErrTooStale)
+			return
+		}
+		ageMillis := age.Seconds() * 1000
+		response.AgeMillis = &ageMillis
+	}
+	if ranged {
+		value, size, _ := ctl.Node.Store.GetRange(key, offset, length)
+		response.Value = value
+		response.Size = &size
+	} else {
+		response.Value = ctl.Node.Store.Get(key)
+	}
+	ctl.hotKeys.read(key)
+
+	c.JSON(http.StatusOK, response)
+}
 
-	c.JSON(http.StatusOK, ReadResponse{Value: value})
+// parseRange reads the byte range of a read from the "offset" and "length"
+// query parameters, and whether either was given. Without a length, the range
+// runs to the end of the value (length -1)
+func parseRange(c *gin.Context) (int, int, bool, error) {
+	offsetParam, hasOffset := c.GetQuery("offset")
+	lengthParam, hasLength := c.GetQuery("length")
+	offset, length := 0, -1
+	if hasOffset {
+		var err error
+		if offset, err = strconv.Atoi(offsetParam); err != nil || offset < 0 {
+			return 0, 0, false, fmt.Errorf("Invalid offset %q", offsetParam)
+		}
+	}
+	if hasLength {
+		var err error
+		if length, err = strconv.Atoi(lengthParam); err != nil || length < 0 {
+			return 0, 0, false, fmt.Errorf("Invalid length %q", lengthParam)
+		}
+	}
+	return offset, length, hasOffset || hasLength, nil
+}
+
+// Handler for value size queries
+// @Summary Return the size of a value, without the value
+// @Description Responds with the headers of a read: 404 if the key has no
+// @Description value, or the size in bytes of the value in X-Leifdb-Value-Size.
+// @ID db-head
+// @Accept */*
+// @Param key path string true "Key"
+// @Success 200 "Key has a value"
+// @Header 200 {integer} X-Leifdb-Value-Size "Size of the value in bytes"
+// @Header 200 {integer} X-Leifdb-Index "Index of the last log entry applied before the read"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 404 {object} ErrorResponse "Key has no value"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /db/{key} [head]
+func (ctl *Controller) handleHead(c *gin.Context) {
+	key := c.Param("key")
+	if !ctl.readableOrRedirect(c) || !ctl.readAllowed(c, key) {
+		return
+	}
+
+	c.Header("X-Leifdb-Index", strconv.FormatInt(ctl.Node.Applied(), 10))
+	size, ok := ctl.Node.Store.Size(key)
+	ctl.hotKeys.read(key)
+	if !ok {
+		respondError(c, node.ErrNoSuchKey)
+		return
+	}
+	c.Header("X-Leifdb-Value-Size", strconv.Itoa(size))
+	c.Status(http.StatusOK)
 }
 
 // WriteRequest is a request body template for the write route
@@ -106,35 +418,44 @@ type WriteResponse struct {
 // @Produce application/json
 // @Param key path string true "Key"
 // @Param body body WriteRequest true "Value"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
 // @Success 200 {object} WriteResponse
-// @Failure 307 {string} string "Temporary Redirect"
+// @Header 200 {integer} X-Leifdb-Index "Index of the log entry for the write"
+// @Header 200 {integer} X-Leifdb-Term "Term of the log entry for the write"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
 // @Header 307 {string} Location "Redirect address of the current leader"
-// @Failure 400 {string} string "Error message"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
 // @Router /db/{key} [put]
 func (ctl *Controller) handleWrite(c *gin.Context) {
 	key := c.Param("key")
 	var body WriteRequest
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+		invalidRequest(c, err)
 		return
 	}
 
 	// Short circuit, if we are not the leader right now, we return
 	// a redirect to the current presumptive leader
-	if ctl.Node.State != node.Leader {
+	if !ctl.Node.IsLeader() {
 		// We could be in a state where we don't have a leader elected yet to
 		// redirect to, at this point this server can't do much
 		if ctl.Node.RedirectLeader() == "" {
-			c.String(http.StatusInternalServerError, node.ErrNotLeaderRecv.Error())
+			respondError(c, node.ErrNotLeaderRecv)
 			return
 		}
 
-		c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("http://%s/db/%s", ctl.Node.RedirectLeader(), key))
+		redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
 		return
 	}
 
-	if err := ctl.Node.Set(key, body.Value); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.Set(ctx, key, body.Value); err != nil {
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, WriteResponse{Status: "Ok"})
@@ -151,9 +472,13 @@ type DeleteResponse struct {
 // @Accept */*
 // @Produce application/json
 // @Param key path string true "Key"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
 // @Success 200 {object} DeleteResponse
-// @Failure 307 {string} string "Temporary Redirect"
+// @Header 200 {integer} X-Leifdb-Index "Index of the log entry for the write"
+// @Header 200 {integer} X-Leifdb-Term "Term of the log entry for the write"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
 // @Header 307 {string} Location "Redirect address of current leader"
+// @Failure 400 {object} ErrorResponse "Invalid write concern"
 // @Router /db/{key} [delete]
 func (ctl *Controller) handleDelete(c *gin.Context) {
 	// todo: add redirect if not leader, use "Location:" header
@@ -161,41 +486,240 @@ func (ctl *Controller) handleDelete(c *gin.Context) {
 
 	// Short circuit, if we are not the leader right now, we return
 	// a redirect to the current presumptive leader
-	if ctl.Node.State != node.Leader {
+	if !ctl.Node.IsLeader() {
 		// We could be in a state where we don't have a leader elected yet to
 		// redirect to, at this point this server can't do much
 		if ctl.Node.RedirectLeader() == "" {
-			c.String(http.StatusInternalServerError, node.ErrNotLeaderRecv.Error())
+			respondError(c, node.ErrNotLeaderRecv)
 			return
 		}
 
-		c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("http://%s/db/%s", ctl.Node.RedirectLeader(), key))
+		redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
 		return
 	}
 
-	if err := ctl.Node.Delete(key); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.Delete(ctx, key); err != nil {
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, DeleteResponse{Status: "Ok"})
 }
 
+// BarrierResponse is a response body template for the read barrier route
+type BarrierResponse struct {
+	CommitIndex int64 `json:"commitIndex"`
+}
+
+// Handler for read barriers--confirms leadership and returns the commit index,
+// which a client can use to ensure that a later read (from any node that has
+// applied at least that index) is not older than data it has already seen
+// @Summary Return the leader's commit index after confirming leadership
+// @ID read-barrier
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} BarrierResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 500 {object} ErrorResponse "Error message"
+// @Router /barrier [get]
+func (ctl *Controller) handleBarrier(c *gin.Context) {
+	if !ctl.Node.IsLeader() {
+		if ctl.Node.RedirectLeader() == "" {
+			respondError(c, node.ErrNotLeaderRecv)
+			return
+		}
+
+		redirectToLeader(c, ctl.Node.RedirectLeader(), "/barrier")
+		return
+	}
+
+	commitIndex, err := ctl.Node.ReadBarrier(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, BarrierResponse{CommitIndex: commitIndex})
+}
+
+// EntryResponse is a response body template for the entry status route
+type EntryResponse struct {
+	Index int64 `json:"index"`
+	Term  int64 `json:"term"`
+	// committed, pending, lost, or unknown (see node.EntryStatus)
+	Status string `json:"status"`
+	// Whether this node has applied the entry at the index (which is only the
+	// entry in question if it is committed)
+	Applied bool `json:"applied"`
+}
+
+// Handler for entry status--tells a client whose write timed out with an
+// uncertain outcome (see ErrorResponse) whether the write was committed
+// @Summary Return whether the log entry at an index in a term was committed
+// @Description Optionally waits for the node to apply the index first. The
+// @Description leader has the most up-to-date view: on a follower, an entry
+// @Description that is committed may still be reported as pending
+// @ID entry-status
+// @Accept */*
+// @Produce application/json
+// @Param index path int true "Log index"
+// @Param term query int true "Term of the entry"
+// @Param wait query string false "How long to wait for the index to be applied, e.g. 5s (default 0)"
+// @Success 200 {object} EntryResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /entries/{index} [get]
+func (ctl *Controller) handleEntry(c *gin.Context) {
+	index, err := strconv.ParseInt(c.Param("index"), 10, 64)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	term, err := strconv.ParseInt(c.Query("term"), 10, 64)
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	var wait time.Duration
+	if s := c.Query("wait"); s != "" {
+		if wait, err = time.ParseDuration(s); err != nil {
+			invalidRequest(c, err)
+			return
+		}
+		if wait > maxWatchWait {
+			wait = maxWatchWait
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+	defer cancel()
+	applied, _ := ctl.Node.WaitForIndex(ctx, index)
+	c.JSON(http.StatusOK, EntryResponse{
+		Index:   index,
+		Term:    term,
+		Status:  string(ctl.Node.EntryStatus(index, term)),
+		Applied: applied})
+}
+
 // buildRouter hooks endpoints for Node/Database ops
-func buildRouter(n *node.Node) *gin.Engine {
+func buildRouter(n *node.Node, snapshots *mgmt.SnapshotManager) *gin.Engine {
 	// Distilled structure of how this is hooking the database:
 	// https://play.golang.org/p/c_wk9rQdJx8
-	ctl := NewController(n)
+	ctl := NewController(n, snapshots)
 
 	router := gin.Default()
 	router.Use(cors.AllowAll())
+	router.Use(countRequests)
+	router.Use(tagRequest)
+	router.Use(prioritize)
+	router.Use(limitTime)
 
 	router.GET("/health", ctl.handleHealth)
+	router.GET("/barrier", ctl.handleBarrier)
+	router.GET("/topology", ctl.handleTopology)
+	router.GET("/entries/:index", ctl.handleEntry)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	dbRouter := router.Group("/db")
 	{
-		dbRouter.GET("/:key", ctl.handleRead)
+		dbRouter.GET("/:key", ctl.shed(shedRead), ctl.handleRead)
+		dbRouter.HEAD("/:key", ctl.shed(shedRead), ctl.handleHead)
 		dbRouter.PUT("/:key", ctl.handleWrite)
 		dbRouter.DELETE("/:key", ctl.handleDelete)
+		dbRouter.POST("/_batch", ctl.handleBatch)
+		dbRouter.POST("/_txn", ctl.handleTxn)
+		dbRouter.POST("/_rename", ctl.handleRename)
+		dbRouter.POST("/_copy", ctl.handleCopy)
+	}
+
+	zsetRouter := router.Group("/zset")
+	{
+		zsetRouter.GET("/:key", ctl.shed(shedRead), ctl.handleZRange)
+		zsetRouter.PUT("/:key", ctl.handleZAdd)
+		zsetRouter.DELETE("/:key", ctl.handleZRem)
+	}
+
+	ttlRouter := router.Group("/ttl")
+	{
+		ttlRouter.GET("/:key", ctl.shed(shedRead), ctl.handleTTL)
+		ttlRouter.PUT("/:key", ctl.handleTouch)
+		ttlRouter.DELETE("/:key", ctl.handlePersist)
+	}
+
+	setRouter := router.Group("/set")
+	{
+		setRouter.GET("/:key", ctl.shed(shedRead), ctl.handleSMembers)
+		setRouter.GET("/:key/:member", ctl.shed(shedRead), ctl.handleSIsMember)
+		setRouter.PUT("/:key", ctl.handleSAdd)
+		setRouter.DELETE("/:key", ctl.handleSRem)
+	}
+
+	lockRouter := router.Group("/lock")
+	{
+		lockRouter.GET("/:name", ctl.handleGetLock)
+		lockRouter.PUT("/:name", ctl.handleAcquireLock)
+		lockRouter.DELETE("/:name", ctl.handleReleaseLock)
+	}
+
+	trashRouter := router.Group("/trash")
+	{
+		trashRouter.GET("/:key", ctl.handleGetTrashed)
+		trashRouter.POST("/:key/restore", ctl.handleRestore)
+	}
+
+	indexRouter := router.Group("/index")
+	{
+		indexRouter.GET("", ctl.handleListIndexes)
+		indexRouter.PUT("", ctl.handleCreateIndex)
+		indexRouter.DELETE("", ctl.handleDropIndex)
+	}
+	router.GET("/find", ctl.shed(shedScan), ctl.handleFind)
+	router.GET("/watch", ctl.handleWatch)
+	router.GET("/export", ctl.shed(shedScan), ctl.handleExport)
+
+	adminRouter := router.Group("/admin")
+	{
+		adminRouter.GET("/ui", ctl.handleDashboard)
+		adminRouter.GET("/status", ctl.handleStatus)
+		adminRouter.GET("/hotkeys", ctl.handleHotKeys)
+		adminRouter.GET("/locks", ctl.handleLocks)
+		adminRouter.GET("/trash", ctl.handleTrash)
+		adminRouter.GET("/namespaces", ctl.handleNamespaces)
+		adminRouter.GET("/namespaces/:name", ctl.handleNamespace)
+		adminRouter.PUT("/namespaces/:name", ctl.handleSetNamespace)
+		adminRouter.DELETE("/namespaces/:name", ctl.handleDeleteNamespace)
+		adminRouter.GET("/events", ctl.handleEvents)
+		adminRouter.GET("/entries", ctl.handleEntries)
+		adminRouter.GET("/disk", ctl.handleDisk)
+		adminRouter.GET("/log", ctl.handleLogConfig)
+		adminRouter.PUT("/log", ctl.handleSetLogConfig)
+		adminRouter.GET("/batching", ctl.handleBatching)
+		adminRouter.PUT("/batching", ctl.handleSetBatching)
+		adminRouter.GET("/keys/sample", ctl.shed(shedScan), ctl.handleSample)
+		adminRouter.GET("/keys/stats", ctl.shed(shedScan), ctl.handleStats)
+		adminRouter.GET("/snapshot", ctl.handleSnapshot)
+		adminRouter.POST("/snapshot", ctl.handleSnapshotTrigger)
+		adminRouter.GET("/snapshot/status", ctl.handleSnapshotStatus)
+		adminRouter.POST("/compact", ctl.handleCompact)
+		adminRouter.POST("/reseed", ctl.handleReseed)
+		adminRouter.GET("/drain", ctl.handleDrainStatus)
+		adminRouter.POST("/drain", ctl.handleDrain)
+		adminRouter.DELETE("/drain", ctl.handleResume)
+		adminRouter.POST("/demote", ctl.handleDemote)
+		adminRouter.POST("/transfer", ctl.handleTransfer)
+		adminRouter.GET("/members", ctl.handleMembers)
+		adminRouter.POST("/members", ctl.handleAddMember)
+		adminRouter.PUT("/members", ctl.handleChangeMembers)
+		adminRouter.DELETE("/members/:address", ctl.handleRemoveMember)
+		adminRouter.POST("/members/:address/promote", ctl.handlePromoteMember)
+		adminRouter.PUT("/members/:address/isolation", ctl.handleIsolate)
+		adminRouter.DELETE("/members/:address/isolation", ctl.handleUnisolate)
+		adminRouter.GET("/members/bootstrap", ctl.handleBootstraps)
+		adminRouter.GET("/readonly", ctl.handleReadOnlyStatus)
+		adminRouter.PUT("/readonly", ctl.handleReadOnly)
+		adminRouter.DELETE("/readonly", ctl.handleReadWrite)
 	}
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	router.StaticFile("/", "./docs/swagger.json")
@@ -209,71 +733,85 @@ func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{
 		Out:        os.Stdout,
 		TimeFormat: time.RFC3339})
+	// levels are applied per subsystem by the logging package
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
 	logLevel := configuration.GetLogLevel()
-	zerolog.SetGlobalLevel(logLevel)
+	logging.Configure(logging.Config{Level: logLevel.String()})
+	if filename := configuration.GetLogConfigFile(); filename != "" {
+		if err := logging.LoadFile(filename); err != nil {
+			log.Fatal().Err(err).Str("file", filename).Msg("Error loading log configuration")
+		}
+	}
 }
 
 func main() {
+	forceRecover := flag.Bool("force-recover", false,
+		"Start even if the state in the data directory is inconsistent")
+	flag.Parse()
 
 	cfg := configuration.BuildServerConfig()
+	cfg.ForceRecover = cfg.ForceRecover || *forceRecover
 	fmt.Printf("Configuration:\n%+v\n\n", *cfg)
 
-	store := database.NewDatabase()
 	config := node.NewNodeConfig(cfg.DataDir, cfg.RaftAddr, cfg.ClientAddr, cfg.NodeIds)
-	n, err := node.NewNode(config, store)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize node")
-	}
-
-	// todo: make these configurable
-	upperBound := 1000
-	lowerBound := upperBound / 2
-	snapshotPeriod := time.Minute
-
-	// Select random election timeout (in interval specified above), and set
-	// static interval for sending append requests
-	ms := (rand.Int() % lowerBound) + (upperBound - lowerBound)
-	electionTimeout := time.Duration(ms) * time.Millisecond
-	minimumTimeout := time.Duration(lowerBound) * time.Millisecond
-	appendInterval := time.Duration(14) * time.Millisecond
-	log.Info().Msgf("Election timeout: %s", electionTimeout.String())
-	if minimumTimeout < appendInterval {
-		// in practice, append interval should be 10x-100x shorter
-		panic(ErrInvalidTimeouts)
-	}
-
-	// Reference to StateManager is not kept--all coordination is done via
-	// either channels or callback hooks
-	mgmt.NewStateManager(
-		n.Reset,         // Node -> StateManager: reset election timer
-		electionTimeout, // Time to wait for election when Follower
-		n.DoElection,    // Call when election timer expires
-		minimumTimeout,  // After successful election, window to bar elections
-		func() {
-			n.AllowVote = true
-		},
-		appendInterval, // Period for doing append job when Leader
-		func() {
-			if n.State == node.Leader {
-				n.SendAppend(0, n.Term)
-			}
-		}) // Call when append ticker cycles
-
-	mgmt.StartSnapshotManager(
-		config.DataDir,
-		config.LogFile,
-		cfg.SnapshotThreshold,
-		snapshotPeriod,
-		cfg.RetainNSnapshots,
-		n)
+	config.Witness = cfg.Witness
+	config.MaxInflightPerPeer = cfg.MaxInflightPeer
+	config.MaxInflight = cfg.MaxInflight
+	config.CatchUpLag = cfg.CatchUpLag
+	config.ValueChunkSize = cfg.ValueChunkSize
+	config.MaxProposalBytes = cfg.MaxProposalBytes
+	config.HedgeDelay = cfg.HedgeDelay
+	config.HedgeMaxPercent = cfg.HedgeMaxPercent
+	config.ReadIndexWindow = cfg.ReadIndexWindow
+	config.LeaderLease = cfg.LeaderLease
+	config.MaxClockSkew = cfg.MaxClockSkew
+	config.AutoPromoteAfter = cfg.AutoPromoteAfter
+	config.AutoPromoteLag = cfg.AutoPromoteLag
+	config.AutoDemoteFlaps = cfg.AutoDemoteFlaps
+	config.AutoDemoteWindow = cfg.AutoDemoteWindow
+	config.ApplyBatchSize = cfg.ApplyBatchSize
+	config.TrashTTL = cfg.TrashTTL
+	config.ProposalBatchDelay = cfg.BatchDelay
+	config.ProposalBatchEntries = cfg.BatchEntries
+	config.ProposalBatchBytes = cfg.BatchBytes
+	config.PublishTopology = cfg.PublishTopology
+	config.TopologyFile = cfg.TopologyFile
+	config.CatchUpBytesPerSecond = cfg.CatchUpRate
+	config.SnapshotBytesPerSecond = cfg.SnapshotRate
+	config.SnapshotWriteBytesPerSecond = cfg.SnapshotWriteRate
+	config.ClusterId = cfg.ClusterId
+	config.LogSync = cfg.LogSync
+	config.LogPreallocate = cfg.LogPreallocate
+	config.ShedApplyLag = cfg.ShedApplyLag
+	config.ShedQueuedWrites = cfg.ShedQueuedWrites
+	config.AppendRetry = cfg.AppendRetry
+	config.ReconnectRetry = cfg.ReconnectRetry
+	config.Version = LeifDBVersion
 
 	raftPortString := fmt.Sprintf(":%s", cfg.RaftPort)
 	clientPortString := fmt.Sprintf(":%s", cfg.ClientPort)
 	lis, err := net.Listen("tcp", raftPortString)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Cluster interface failed to bind")
+		logger.Fatal().Err(err).Msg("Cluster interface failed to bind")
+	}
+
+	// todo: make the timing configurable
+	s, err := server.Start(server.Config{
+		Node:              config,
+		SnapshotThreshold: cfg.SnapshotThreshold,
+		SnapshotEntries:   cfg.SnapshotEntries,
+		RetainSnapshots:   cfg.RetainNSnapshots,
+		RetainLogEntries:  cfg.RetainLogEntries,
+		WebhookURLs:       cfg.WebhookURLs,
+		RaftListener:      lis,
+		ForceRecover:      cfg.ForceRecover})
+	if errors.Is(err, node.ErrInconsistentState) {
+		logger.Fatal().Err(err).Msg(
+			"Refusing to start with inconsistent state (fix the problems listed, or start with --force-recover to start anyway)")
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize node")
 	}
-	raftserver.StartRaftServer(lis, n)
-	router := buildRouter(n)
+	router := buildRouter(s.Node, s.Snapshots)
 	router.Run(clientPortString)
 }
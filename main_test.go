@@ -4,19 +4,28 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/btmorr/leifdb/internal/mgmt"
 	"github.com/btmorr/leifdb/internal/node"
 	"github.com/btmorr/leifdb/internal/raft"
 	"github.com/btmorr/leifdb/internal/util"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
 // setupServer configures a Database, Node, and router for test, and creates
@@ -37,11 +46,23 @@ func setupServer(t *testing.T) (*gin.Engine, *node.Node) {
 
 	config := node.NewNodeConfig(testDir, addr, clientAddr, make([]string, 0, 0))
 	n, _ := node.NewNode(config, store)
-	router := buildRouter(n)
+	router := buildRouter(n, nil)
 	n.State = node.Leader
 	return router, n
 }
 
+// followLeader makes n a follower of leader, as if it had received an append
+// from leader for a later term
+func followLeader(n *node.Node, leader *raft.Node) {
+	n.State = node.Follower
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term + 1,
+		Leader:       leader,
+		PrevLogIndex: -1,
+		PrevLogTerm:  -1,
+		LeaderCommit: -1})
+}
+
 func TestHealthRoute(t *testing.T) {
 	router, _ := setupServer(t)
 
@@ -90,12 +111,106 @@ func TestReadAfterWrite(t *testing.T) {
 	}
 }
 
+func TestRangeRead(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "blob", "0123456789")
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var data ReadResponse
+	json.Unmarshal(do("GET", "/db/blob?offset=3&length=4").Body.Bytes(), &data)
+	if data.Value != "3456" || data.Size == nil || *data.Size != 10 {
+		t.Errorf("Expected bytes 3456 of 10, got %+v", data)
+	}
+	data = ReadResponse{}
+	json.Unmarshal(do("GET", "/db/blob?offset=12").Body.Bytes(), &data)
+	if data.Value != "" || data.Size == nil || *data.Size != 10 {
+		t.Errorf("Expected nothing past the end of the value, got %+v", data)
+	}
+	data = ReadResponse{}
+	json.Unmarshal(do("GET", "/db/blob").Body.Bytes(), &data)
+	if data.Value != "0123456789" || data.Size != nil {
+		t.Errorf("Expected the whole value without a size, got %+v", data)
+	}
+	if w := do("GET", "/db/blob?offset=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative offset, got %d", w.Code)
+	}
+
+	w := do("HEAD", "/db/blob")
+	if w.Code != http.StatusOK || w.Header().Get("X-Leifdb-Value-Size") != "10" {
+		t.Errorf("Expected a size of 10, got %d %q", w.Code, w.Header().Get("X-Leifdb-Value-Size"))
+	}
+	if w := do("HEAD", "/db/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing key, got %d", w.Code)
+	}
+}
+
+func TestWriteConcernParam(t *testing.T) {
+	router, _ := setupServer(t)
+
+	body := strings.NewReader(`{"value": "testy"}`)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/db/stuff?concern=apply", body)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in PUT with write concern:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/db/stuff?concern=eventually", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid write concern, got %d\n", w.Code)
+	}
+}
+
+func TestLogPositionHeaders(t *testing.T) {
+	router, n := setupServer(t)
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/db/a", `{"value": "1"}`)
+	w := do("PUT", "/db/b", `{"value": "2"}`)
+	index, term := w.Header().Get("X-Leifdb-Index"), w.Header().Get("X-Leifdb-Term")
+	if index != "1" || term != fmt.Sprint(n.Term) {
+		t.Errorf("Expected the write at index 1 in term %d, got %q in %q", n.Term, index, term)
+	}
+	w = do("DELETE", "/db/a", "")
+	if index := w.Header().Get("X-Leifdb-Index"); index != "2" {
+		t.Errorf("Expected the delete at index 2, got %q", index)
+	}
+
+	// a write that fails has no position
+	n.SetReadOnly("test")
+	w = do("PUT", "/db/b", `{"value": "3"}`)
+	if w.Code == http.StatusOK || w.Header().Get("X-Leifdb-Index") != "" {
+		t.Errorf("Expected no index for a rejected write, got %d %q", w.Code, w.Header().Get("X-Leifdb-Index"))
+	}
+
+	w = do("GET", "/db/b", "")
+	if index := w.Header().Get("X-Leifdb-Index"); index != fmt.Sprint(n.LastApplied) {
+		t.Errorf("Expected the read at index %d, got %q", n.LastApplied, index)
+	}
+	w = do("HEAD", "/db/b", "")
+	if index := w.Header().Get("X-Leifdb-Index"); index != fmt.Sprint(n.LastApplied) {
+		t.Errorf("Expected the size query at index %d, got %q", n.LastApplied, index)
+	}
+}
+
 func TestWriteRedirect(t *testing.T) {
 	router, n := setupServer(t)
 
 	// Change our node so we become a follower
 	n.State = node.Follower
-	n.SetTerm(n.Term+1, &raft.Node{
+	followLeader(n, &raft.Node{
 		Id:         "localhost:16991",
 		ClientAddr: "localhost:8081",
 	})
@@ -118,6 +233,14 @@ func TestWriteRedirect(t *testing.T) {
 	if location != expected {
 		t.Errorf("Expected to be redirected to %s but got %s\n", expected, location)
 	}
+
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("Error parsing redirect body:", err)
+	}
+	if resp.Code != ErrorNotLeader || resp.Leader != "localhost:8081" {
+		t.Errorf("Expected NotLeader error naming the leader but got %+v\n", resp)
+	}
 }
 
 func TestDelete(t *testing.T) {
@@ -186,7 +309,7 @@ func TestDeleteRedirect(t *testing.T) {
 
 	// Change our node so we become a follower
 	n.State = node.Follower
-	n.SetTerm(n.Term+1, &raft.Node{
+	followLeader(n, &raft.Node{
 		Id:         "localhost:16991",
 		ClientAddr: "localhost:8081",
 	})
@@ -205,3 +328,1069 @@ func TestDeleteRedirect(t *testing.T) {
 		t.Errorf("Expected to be redirected to %s but got %s\n", expected, location)
 	}
 }
+
+func TestWitnessReadRedirect(t *testing.T) {
+	testDir, err := util.CreateTmpDir(".tmp-leifdb-witness")
+	if err != nil {
+		log.Fatalln("Error creating test dir:", err)
+	}
+	t.Cleanup(func() {
+		util.RemoveTmpDir(testDir)
+	})
+
+	config := node.NewNodeConfig(
+		testDir, "localhost:16990", "localhost:8080", make([]string, 0, 0))
+	config.Witness = true
+	n, _ := node.NewNode(config, db.NewDatabase())
+	followLeader(n, &raft.Node{
+		Id:         "localhost:16991",
+		ClientAddr: "localhost:8081",
+	})
+	router := buildRouter(n, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/db/stuff", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected a temporary (307) redirect but got: %d\n", w.Code)
+	}
+
+	location := w.Header().Get("Location")
+	expected := "http://localhost:8081/db/stuff"
+	if location != expected {
+		t.Errorf("Expected to be redirected to %s but got %s\n", expected, location)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "stuff", "testy")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/barrier", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in GET:", w.Code)
+	}
+
+	raw, _ := ioutil.ReadAll(w.Body)
+	var data BarrierResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Error(err.Error())
+	}
+	if data.CommitIndex != 0 {
+		t.Errorf("Expected commit index 0, got %d", data.CommitIndex)
+	}
+}
+
+func TestTopologyRoute(t *testing.T) {
+	router, n := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/topology", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before a topology is published, got %d", w.Code)
+	}
+
+	if err := n.PublishTopology(context.Background()); err != nil {
+		t.Fatalf("PublishTopology failed: %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status in GET:", w.Code)
+	}
+	var data node.Topology
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err.Error())
+	}
+	if data.Leader != "localhost:16990" || data.LeaderClientAddr != "localhost:8080" || len(data.Members) != 1 {
+		t.Errorf("Expected this node as the only member and leader, got %+v", data)
+	}
+}
+
+func TestEntryStatus(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "stuff", "testy")
+
+	testCases := []struct {
+		path    string
+		status  string
+		applied bool
+	}{
+		{fmt.Sprintf("/entries/0?term=%d", n.Term), "committed", true},
+		{fmt.Sprintf("/entries/0?term=%d", n.Term+1), "lost", true},
+		{fmt.Sprintf("/entries/1?term=%d&wait=10ms", n.Term), "unknown", false},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: non-200 status: %d", tc.path, w.Code)
+		}
+		var data EntryResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err.Error())
+		}
+		if data.Status != tc.status || data.Applied != tc.applied {
+			t.Errorf("%s: expected %s (applied %t), got %+v", tc.path, tc.status, tc.applied, data)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/entries/0", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a term, got %d", w.Code)
+	}
+}
+
+func TestMaxStalenessRead(t *testing.T) {
+	router, n := setupServer(t)
+	read := func(query string) (*httptest.ResponseRecorder, ReadResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/db/stuff"+query, nil)
+		router.ServeHTTP(w, req)
+		var data ReadResponse
+		json.Unmarshal(w.Body.Bytes(), &data)
+		return w, data
+	}
+
+	if w, _ := read("?max_staleness=soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request (400) for invalid max_staleness but got: %d\n", w.Code)
+	}
+	// the leader has no lease until a round of appends is acknowledged
+	if w, _ := read("?max_staleness=500ms"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected service unavailable (503) but got: %d\n", w.Code)
+	}
+	n.Set(context.Background(), "stuff", "things")
+	w, data := read("?max_staleness=500ms")
+	if w.Code != http.StatusOK || data.Value != "things" || data.AgeMillis == nil || *data.AgeMillis > 500 {
+		t.Errorf("Expected read with its age but got %d %+v\n", w.Code, data)
+	}
+	if _, data := read(""); data.AgeMillis != nil {
+		t.Errorf("Expected no age without max_staleness but got %+v\n", data)
+	}
+
+	// a follower that has not heard the leader's lease redirects to it
+	leader := &raft.Node{Id: "localhost:16991", ClientAddr: "localhost:8081"}
+	lastTerm := n.Term
+	followLeader(n, leader)
+	w, _ = read("?max_staleness=500ms")
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusTemporaryRedirect || location != "http://localhost:8081/db/stuff?max_staleness=500ms" {
+		t.Errorf("Expected redirect to leader but got %d to %q\n", w.Code, location)
+	}
+
+	n.HandleAppend(&raft.AppendRequest{
+		Term:         n.Term,
+		Leader:       leader,
+		PrevLogIndex: n.CommitIndex,
+		PrevLogTerm:  lastTerm,
+		LeaderCommit: n.CommitIndex,
+		LeaseAge:     int64(100 * time.Millisecond)})
+	w, data = read("?max_staleness=500ms")
+	if w.Code != http.StatusOK || data.AgeMillis == nil || *data.AgeMillis < 100 {
+		t.Errorf("Expected follower to serve the read but got %d %+v\n", w.Code, data)
+	}
+	if w, _ := read("?max_staleness=50ms"); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected redirect for a tighter bound but got: %d\n", w.Code)
+	}
+}
+
+func TestReadBeforeCatchUp(t *testing.T) {
+	router, n := setupServer(t)
+	n.State = node.Follower
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/db/stuff", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected service unavailable (503) but got: %d\n", w.Code)
+	}
+
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("Error parsing error body:", err)
+	}
+	if resp.Code != ErrorUnavailable || !resp.Retryable {
+		t.Errorf("Expected retryable Unavailable error but got %+v\n", resp)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
+
+func TestQuarantinedRead(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "stuff", "things")
+	n.Quarantine("test")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/db/stuff", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected service unavailable (503) but got: %d\n", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/status", nil)
+	router.ServeHTTP(w, req)
+	var status StatusResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal("Error parsing status body:", err)
+	}
+	if !status.Quarantined || status.QuarantineReason != "test" {
+		t.Errorf("Expected status to report the quarantine but got %+v\n", status)
+	}
+}
+
+func TestClassifyErrors(t *testing.T) {
+	testCases := []struct {
+		err       error
+		status    int
+		code      ErrorCode
+		retryable bool
+	}{
+		{node.ErrNotLeaderRecv, http.StatusServiceUnavailable, ErrorNotLeader, true},
+		{node.ErrProposalBudget, http.StatusServiceUnavailable, ErrorQuotaExceeded, true},
+		{node.ErrDraining, http.StatusServiceUnavailable, ErrorUnavailable, true},
+		{node.ErrQuarantined, http.StatusServiceUnavailable, ErrorUnavailable, true},
+		{node.ErrTooStale, http.StatusServiceUnavailable, ErrorUnavailable, true},
+		{node.ErrReseedLeader, http.StatusConflict, ErrorConflict, false},
+		{node.ErrNoTransferTarget, http.StatusConflict, ErrorConflict, false},
+		{node.ErrInvalidIsolation, http.StatusBadRequest, ErrorInvalidRequest, false},
+		{node.ErrLearnerBehind, http.StatusConflict, ErrorConflict, false},
+		{&node.ReadOnlyError{Scope: node.ReadOnlyCluster, Reason: "migration"},
+			http.StatusServiceUnavailable, ErrorReadOnly, false},
+		{node.ErrCommitFailed, http.StatusGatewayTimeout, ErrorTimeout, true},
+		{&node.CommitUncertainError{Index: 7, Term: 2, Err: node.ErrCommitTimeout},
+			http.StatusGatewayTimeout, ErrorTimeout, true},
+		{&node.CommitUncertainError{Index: 7, Term: 2, Err: context.DeadlineExceeded},
+			http.StatusGatewayTimeout, ErrorDeadline, true},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, ErrorDeadline, true},
+		{db.ErrInvalidQuery, http.StatusBadRequest, ErrorInvalidRequest, false},
+		{db.ErrNoIndex, http.StatusNotFound, ErrorNotFound, false},
+		{ErrWatchCompacted, http.StatusGone, ErrorCompacted, false},
+		{fmt.Errorf("something else"), http.StatusInternalServerError, ErrorInternal, false},
+	}
+	for _, tc := range testCases {
+		status, resp := classify(tc.err)
+		if status != tc.status || resp.Code != tc.code || resp.Retryable != tc.retryable {
+			t.Errorf("%v: expected %d %s (retryable %t) but got %d %+v\n",
+				tc.err, tc.status, tc.code, tc.retryable, status, resp)
+		}
+		if resp.Message != tc.err.Error() {
+			t.Errorf("Expected message %q but got %q\n", tc.err.Error(), resp.Message)
+		}
+	}
+
+	// an uncertain write identifies its entry
+	_, resp := classify(&node.CommitUncertainError{Index: 7, Term: 2, Err: context.Canceled})
+	if resp.Index == nil || *resp.Index != 7 || resp.Term == nil || *resp.Term != 2 {
+		t.Errorf("Expected entry 7 in term 2 in response, got %+v\n", resp)
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	router, _ := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 metrics status:", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected text/plain content, got %s\n", ct)
+	}
+}
+
+func TestFind(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "u1", `{"status": "active"}`)
+	n.Set(context.Background(), "u2", `{"status": "inactive"}`)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/find?where="+url.QueryEscape(`$.status == "active"`), nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before index is created, got %d\n", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/index?path=$.status", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status creating index:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/find?where="+url.QueryEscape(`$.status == "active"`), nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in find:", w.Code)
+	}
+	raw, _ := ioutil.ReadAll(w.Body)
+	var data FindResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Error(err.Error())
+	}
+	if len(data.Keys) != 1 || data.Keys[0] != "u1" {
+		t.Errorf("Incorrect response: %+v", data)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/find?where=status", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid query, got %d\n", w.Code)
+	}
+}
+
+func TestIndexRedirect(t *testing.T) {
+	router, n := setupServer(t)
+	n.State = node.Follower
+	followLeader(n, &raft.Node{
+		Id:         "localhost:16991",
+		ClientAddr: "localhost:8081",
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/index?path=$.status", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected a temporary (307) redirect but got: %d\n", w.Code)
+	}
+	location := w.Header().Get("Location")
+	expected := "http://localhost:8081/index?path=$.status"
+	if location != expected {
+		t.Errorf("Expected to be redirected to %s but got %s\n", expected, location)
+	}
+}
+
+func TestSortedSetRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+
+	body := `{"members": [{"member": "alice", "score": 10}, {"member": "bob", "score": 5}]}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/zset/board", bytes.NewReader([]byte(body)))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in PUT:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/zset/board?min=6&max=+inf", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in GET:", w.Code)
+	}
+	raw, _ := ioutil.ReadAll(w.Body)
+	var data ZRangeResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Error(err.Error())
+	}
+	if len(data.Members) != 1 || data.Members[0].Member != "alice" {
+		t.Errorf("Incorrect response: %+v", data)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/zset/board?member=alice&member=bob", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in DELETE:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/zset/board?min=low", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid bound, got %d\n", w.Code)
+	}
+}
+
+func TestSetRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"PUT", "/set/team", bytes.NewReader([]byte(`{"members": ["alice", "bob"]}`)))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in PUT:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/set/team?member=bob", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in DELETE:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/set/team", nil)
+	router.ServeHTTP(w, req)
+	raw, _ := ioutil.ReadAll(w.Body)
+	var members SMembersResponse
+	if err := json.Unmarshal(raw, &members); err != nil {
+		t.Error(err.Error())
+	}
+	if len(members.Members) != 1 || members.Members[0] != "alice" {
+		t.Errorf("Incorrect response: %+v", members)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/set/team/alice", nil)
+	router.ServeHTTP(w, req)
+	raw, _ = ioutil.ReadAll(w.Body)
+	var check SIsMemberResponse
+	if err := json.Unmarshal(raw, &check); err != nil {
+		t.Error(err.Error())
+	}
+	if !check.IsMember {
+		t.Errorf("Expected alice to be a member: %+v", check)
+	}
+}
+
+func TestTTLRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "session", "abc")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/ttl/session?ttl=1m", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in PUT:", w.Code)
+	}
+	raw, _ := ioutil.ReadAll(w.Body)
+	var touched ExistsResponse
+	if err := json.Unmarshal(raw, &touched); err != nil {
+		t.Error(err.Error())
+	}
+	if !touched.Exists {
+		t.Errorf("Expected key to exist: %+v", touched)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/ttl/session", nil)
+	router.ServeHTTP(w, req)
+	raw, _ = ioutil.ReadAll(w.Body)
+	var ttl TTLResponse
+	if err := json.Unmarshal(raw, &ttl); err != nil {
+		t.Error(err.Error())
+	}
+	if !ttl.Exists || !ttl.Expires || ttl.TTLMs <= 0 || ttl.TTLMs > 60000 {
+		t.Errorf("Incorrect response: %+v", ttl)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/ttl/session", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Non-200 status in DELETE:", w.Code)
+	}
+	if _, ok := n.Store.Expiry("session"); ok {
+		t.Error("Expected no expiry after persist")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/ttl/session?ttl=soon", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid ttl, got %d\n", w.Code)
+	}
+}
+
+func TestBatchRoute(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "a", "1")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/db/_batch", strings.NewReader(`{"operations": [
+		{"op": "set", "key": "b", "value": "x"},
+		{"op": "cas", "key": "a", "expected": "1", "value": "2"},
+		{"op": "delete", "key": "c"}]}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status in batch:", w.Code)
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(resp.Applied) != 3 || n.Store.Get("a") != "2" || n.Store.Get("b") != "x" {
+		t.Errorf("Unexpected result of batch: %+v", resp)
+	}
+
+	// a stale compare-and-swap fails the whole batch
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/db/_batch", strings.NewReader(`{"operations": [
+		{"op": "set", "key": "b", "value": "y"},
+		{"op": "cas", "key": "a", "expected": "1", "value": "3"}]}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for failed compare-and-swap, got %d\n", w.Code)
+	}
+	if n.Store.Get("b") != "x" {
+		t.Error("Expected no operations applied from a conflicting batch")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/db/_batch", strings.NewReader(
+		`{"operations": [{"op": "incr", "key": "a"}]}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown operation, got %d\n", w.Code)
+	}
+}
+
+func TestTxnRoute(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "a", "1")
+
+	txn := func(body string) TxnResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/db/_txn", strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatal("Non-200 status in transaction:", w.Code)
+		}
+		var resp TxnResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err.Error())
+		}
+		return resp
+	}
+	body := `{
+		"if": [{"key": "a", "value": "1"}],
+		"then": [{"op": "set", "key": "a", "value": "2"}],
+		"else": [{"op": "delete", "key": "a"}]}`
+	if resp := txn(body); resp.Succeeded == nil || !*resp.Succeeded || n.Store.Get("a") != "2" {
+		t.Errorf("Expected then operations applied, got %+v", resp)
+	}
+	if resp := txn(body); resp.Succeeded == nil || *resp.Succeeded || n.Store.Exists("a") {
+		t.Errorf("Expected else operations applied, got %+v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/db/_txn", strings.NewReader(`{"if": [{"key": "a"}]}`))
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for transaction without operations, got %d\n", w.Code)
+	}
+}
+
+func TestLockRoutes(t *testing.T) {
+	router, _ := setupServer(t)
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PUT", "/lock/nightly", `{"owner": "host-a", "leaseMs": 30000}`)
+	var lock node.Lock
+	json.Unmarshal(w.Body.Bytes(), &lock)
+	if w.Code != http.StatusOK || lock.Name != "nightly" || lock.Owner != "host-a" || lock.LeaseMs != 30000 {
+		t.Fatalf("Expected host-a to take the lock, got %d %+v", w.Code, lock)
+	}
+
+	w = do("PUT", "/lock/nightly", `{"owner": "host-b", "leaseMs": 30000}`)
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if w.Code != http.StatusConflict || errResp.Code != ErrorConflict ||
+		errResp.Holder == nil || errResp.Holder.Owner != "host-a" {
+		t.Errorf("Expected a conflict naming host-a, got %d %+v", w.Code, errResp)
+	}
+	if w := do("PUT", "/lock/nightly", `{"owner": "host-b"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a lock without a lease, got %d", w.Code)
+	}
+
+	if w := do("GET", "/lock/nightly", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the lock to be readable, got %d", w.Code)
+	}
+	var list LocksResponse
+	json.Unmarshal(do("GET", "/admin/locks", "").Body.Bytes(), &list)
+	if len(list.Locks) != 1 || list.Locks[0].Name != "nightly" {
+		t.Errorf("Expected one lock listed, got %+v", list)
+	}
+
+	if w := do("DELETE", "/lock/nightly?owner=host-b", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 releasing a lock held by another owner, got %d", w.Code)
+	}
+	if w := do("DELETE", "/lock/nightly?owner=host-a", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected host-a to release the lock, got %d", w.Code)
+	}
+	if w := do("GET", "/lock/nightly", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a free lock, got %d", w.Code)
+	}
+}
+
+func TestTrashRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/trash/stuff/restore"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 restoring a key not in the trash, got %d", w.Code)
+	}
+	if w := do("GET", "/trash/stuff"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 reading a key not in the trash, got %d", w.Code)
+	}
+
+	n.Store.Set(node.TrashKey("stuff"), "testy")
+	n.Store.Touch(node.TrashKey("stuff"), time.Now().Add(time.Hour).UnixNano())
+	var trashed node.TrashedValue
+	json.Unmarshal(do("GET", "/trash/stuff").Body.Bytes(), &trashed)
+	if trashed.Key != "stuff" || trashed.Value != "testy" || trashed.ExpiresAt == 0 {
+		t.Errorf("Expected the trashed value of stuff, got %+v", trashed)
+	}
+	var list TrashResponse
+	json.Unmarshal(do("GET", "/admin/trash").Body.Bytes(), &list)
+	if len(list.Values) != 1 || list.Values[0].Key != "stuff" {
+		t.Errorf("Expected one value in the trash, got %+v", list)
+	}
+
+	if w := do("POST", "/trash/stuff/restore"); w.Code != http.StatusOK {
+		t.Fatalf("Expected stuff to be restored, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := n.Store.Get("stuff"); got != "testy" {
+		t.Errorf("Expected stuff=testy, got %q", got)
+	}
+}
+
+func TestRenameRoutes(t *testing.T) {
+	router, n := setupServer(t)
+	do := func(path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	n.Set(context.Background(), "a", "1")
+	if w := do("/db/_rename", `{"from": "a", "to": "b"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected a to be renamed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("/db/_copy", `{"from": "b", "to": "c"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected b to be copied, got %d: %s", w.Code, w.Body.String())
+	}
+	if n.Store.Exists("a") || n.Store.Get("b") != "1" || n.Store.Get("c") != "1" {
+		t.Errorf("Expected b=1 and c=1 only, got a=%q b=%q c=%q",
+			n.Store.Get("a"), n.Store.Get("b"), n.Store.Get("c"))
+	}
+
+	if w := do("/db/_copy", `{"from": "b", "to": "c"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 copying over a key without replace, got %d", w.Code)
+	}
+	if w := do("/db/_rename", `{"from": "a", "to": "c", "replace": true}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 renaming a missing key, got %d", w.Code)
+	}
+	if w := do("/db/_rename", `{"from": "b"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a key to write, got %d", w.Code)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router, n := setupServer(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/db/stuff", strings.NewReader(`{"value": "testy"}`))
+	req.Header.Set("X-Request-Id", "write-1")
+	router.ServeHTTP(w, req)
+	if id := w.Header().Get("X-Request-Id"); id != "write-1" {
+		t.Errorf("Expected request ID to be echoed, got %q", id)
+	}
+	entries := n.Log.Entries
+	if len(entries) != 1 || entries[0].RequestId != "write-1" {
+		t.Errorf("Expected log entry tagged with request ID, got %v", entries)
+	}
+
+	// a request without an ID is given one
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/health", nil)
+	router.ServeHTTP(w, req)
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Error("Expected a generated request ID")
+	}
+}
+
+func TestWatchRoute(t *testing.T) {
+	router, n := setupServer(t)
+	ctx := context.Background()
+	n.Set(ctx, "a/1", "x")
+	n.CreateIndex(ctx, "$.status")
+	n.Batch(ctx, []db.BatchOp{
+		{Op: db.OpSet, Key: "a/2", Value: "y"},
+		{Op: db.OpCAS, Key: "b", Value: "z"},
+		{Op: db.OpCAS, Key: "a/1", Value: "z"}}, false)
+	n.Delete(ctx, "a/1")
+
+	watch := func(query string) WatchResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/watch?"+query, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Non-200 status in watch %q: %d", query, w.Code)
+		}
+		var resp WatchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err.Error())
+		}
+		return resp
+	}
+
+	// only applied operations of the batch are changes, and the index entry
+	// makes none
+	resp := watch("prefix=a/")
+	expected := []Change{
+		{Revision: 0, Type: ChangePut, Key: "a/1", Value: "x"},
+		{Revision: 2, Type: ChangePut, Key: "a/2", Value: "y"},
+		{Revision: 3, Type: ChangeDelete, Key: "a/1"}}
+	// each change carries the time of its entry
+	for i := range resp.Changes {
+		if resp.Changes[i].Time == nil || resp.Changes[i].Time.IsZero() {
+			t.Errorf("Expected a time for change %+v", resp.Changes[i])
+		}
+		resp.Changes[i].Time = nil
+	}
+	if fmt.Sprint(resp.Changes) != fmt.Sprint(expected) || resp.Revision != 3 {
+		t.Errorf("Expected changes %v, got %+v", expected, resp)
+	}
+	if resp = watch("after=2"); len(resp.Changes) != 1 || resp.Changes[0].Revision != 3 {
+		t.Errorf("Expected only the change after revision 2, got %+v", resp)
+	}
+
+	// with no changes yet, the watch waits for one...
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		n.Set(ctx, "a/3", "w")
+	}()
+	if resp = watch("after=3&wait=5s"); len(resp.Changes) != 1 || resp.Changes[0].Key != "a/3" {
+		t.Errorf("Expected to wait for the next change, got %+v", resp)
+	}
+	// ...until it times out
+	if resp = watch("after=4&wait=10ms"); len(resp.Changes) != 0 || resp.Revision != 4 {
+		t.Errorf("Expected no changes, got %+v", resp)
+	}
+}
+
+func TestChangeFeedCompaction(t *testing.T) {
+	feed := newChangeFeed(2, -1)
+	feed.add(0, []Change{{Revision: 0, Key: "a"}, {Revision: 0, Key: "b"}})
+	feed.add(1, []Change{{Revision: 1, Key: "c"}})
+
+	// the oldest revision is discarded entirely
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := feed.wait(ctx, "", -1); err != ErrWatchCompacted {
+		t.Errorf("Expected %v, got %v", ErrWatchCompacted, err)
+	}
+	changes, revision, err := feed.wait(ctx, "", 0)
+	if err != nil || len(changes) != 1 || changes[0].Key != "c" || revision != 1 {
+		t.Errorf("Expected only the latest change, got %v %d %v", changes, revision, err)
+	}
+}
+
+func TestSnapshotRoutes(t *testing.T) {
+	_, n := setupServer(t)
+	n.Set(context.Background(), "ice", "cream")
+	testDir, _ := util.CreateTmpDir(".tmp-leifdb")
+	t.Cleanup(func() {
+		util.RemoveTmpDir(testDir)
+	})
+	snapshots := mgmt.StartSnapshotManager(
+		testDir, filepath.Join(testDir, "raftlog"), 0, 0, time.Hour, 2, 0, n)
+	router := buildRouter(n, snapshots)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/snapshot", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status downloading snapshot:", w.Code)
+	}
+	if applied := w.Header().Get("X-Leifdb-Last-Applied"); applied != "0" {
+		t.Errorf("Expected snapshot as of entry 0, got %q", applied)
+	}
+	store, err := db.InstallSnapshot(w.Body.Bytes())
+	if err != nil || store.Get("ice") != "cream" {
+		t.Errorf("Expected snapshot of the database, got error %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/snapshot", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 triggering snapshot, got %d", w.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var status mgmt.SnapshotStatus
+	for time.Now().Before(deadline) {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/admin/snapshot/status", nil)
+		router.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &status)
+		if !status.InProgress {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Count != 1 || status.Latest == nil {
+		t.Errorf("Expected triggered snapshot to be reported, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/compact?retain=-1", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 compacting with negative retain, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/compact?retain=0", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 requesting compaction, got %d", w.Code)
+	}
+}
+
+func TestExportRoute(t *testing.T) {
+	router, n := setupServer(t)
+	ctx := context.Background()
+	n.Set(ctx, "app/b", "2")
+	n.Set(ctx, "app/a", "1")
+	n.Set(ctx, "other", "3")
+	n.Touch(ctx, "app/b", time.Hour)
+	n.Set(ctx, "app/gone", "4")
+	n.Touch(ctx, "app/gone", -time.Second)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/export?prefix=app/", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Non-200 status in export:", w.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 unexpired keys with the prefix, got:\n%s", w.Body.String())
+	}
+	var a, b ExportRecord
+	json.Unmarshal([]byte(lines[0]), &a)
+	json.Unmarshal([]byte(lines[1]), &b)
+	if a.Key != "app/a" || a.Value != "1" || a.ExpiresAt != 0 {
+		t.Errorf("Unexpected first record: %+v", a)
+	}
+	if b.Key != "app/b" || b.Value != "2" || b.ExpiresAt == 0 {
+		t.Errorf("Unexpected second record: %+v", b)
+	}
+}
+
+func TestShedding(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "k", "v")
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// entries committed but not applied, past the default limit
+	applied := n.LastApplied
+	n.CommitIndex = applied + node.DefaultShedApplyLag + 1
+	for _, path := range []string{"/db/k", "/ttl/k", "/find?prefix=k", "/export", "/admin/keys/stats"} {
+		w := do("GET", path)
+		var errResp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != http.StatusServiceUnavailable || !errResp.Retryable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected %s to be shed with a retryable 503, got %d %+v", path, w.Code, errResp)
+		}
+	}
+	w := do("GET", "/admin/status")
+	var status StatusResponse
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if !status.Backlog.Shedding || status.Backlog.ApplyLag != node.DefaultShedApplyLag+1 {
+		t.Errorf("Expected status to report the backlog, got %+v", status.Backlog)
+	}
+
+	n.CommitIndex = applied
+	if w := do("GET", "/db/k"); w.Code != http.StatusOK {
+		t.Errorf("Expected reads to be served once the backlog clears, got %d", w.Code)
+	}
+}
+
+func TestPriorityHeader(t *testing.T) {
+	router, _ := setupServer(t)
+	put := func(priority string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/db/k", strings.NewReader(`{"value":"v"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Leifdb-Priority", priority)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := put("batch"); w.Code != http.StatusOK {
+		t.Errorf("Expected write with batch priority to succeed, got %d", w.Code)
+	}
+	if w := put("urgent"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown priority, got %d", w.Code)
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	router, n := setupServer(t)
+	put := func(timeout string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/db/k", strings.NewReader(`{"value":"v"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Leifdb-Timeout", timeout)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := put("5s"); w.Code != http.StatusOK {
+		t.Errorf("Expected write within its deadline to succeed, got %d", w.Code)
+	}
+	if w := put("soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid timeout, got %d", w.Code)
+	}
+	last := n.LastLogIndex()
+	w := put("1ns")
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusGatewayTimeout || resp.Code != ErrorDeadline {
+		t.Errorf("Expected 504 %s for a write past its deadline, got %d %+v", ErrorDeadline, w.Code, resp)
+	}
+	if n.LastLogIndex() != last {
+		t.Errorf("Expected a write past its deadline not to be appended")
+	}
+}
+
+func TestLinearizableRead(t *testing.T) {
+	router, n := setupServer(t)
+	n.Set(context.Background(), "k", "v")
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/db/k?"+query, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := get("linearizable=true")
+	var resp ReadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Value != "v" {
+		t.Errorf("Expected a linearizable read of the write, got %d %+v", w.Code, resp)
+	}
+	if w := get("linearizable=maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid linearizable flag, got %d", w.Code)
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	router, n := setupServer(t)
+	ctx := context.Background()
+	n.Set(ctx, "user:1", `{"name": "alice", "status": "active"}`)
+	n.Set(ctx, "user:2", `{"name": "bob", "status": "done"}`)
+	n.Set(ctx, "user:admin", `{"name": "carol", "status": "active"}`)
+	export := func(query string) (int, []string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export?"+query, nil)
+		router.ServeHTTP(w, req)
+		keys := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var record ExportRecord
+			if json.Unmarshal([]byte(line), &record) == nil {
+				keys = append(keys, record.Key)
+			}
+		}
+		return w.Code, keys
+	}
+
+	where := url.QueryEscape(`$.status == "active"`)
+	testCases := []struct {
+		query string
+		keys  []string
+	}{
+		{"match=" + url.QueryEscape(`^user:\d+$`), []string{"user:1", "user:2"}},
+		{"contains=bob", []string{"user:2"}},
+		{"where=" + where, []string{"user:1", "user:admin"}},
+		{"where=" + where + "&match=" + url.QueryEscape(`\d`), []string{"user:1"}}}
+	for _, tc := range testCases {
+		code, keys := export(tc.query)
+		if code != http.StatusOK || strings.Join(keys, ",") != strings.Join(tc.keys, ",") {
+			t.Errorf("[%s] Expected %v, got %d %v", tc.query, tc.keys, code, keys)
+		}
+	}
+	if code, _ := export("match=" + url.QueryEscape("user:(")); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid key pattern, got %d", code)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	router, n := setupServer(t)
+	ctx := context.Background()
+	n.Set(ctx, "a", "1")
+	n.Set(ctx, "b", "two,\n\"lines\"")
+	n.Touch(ctx, "b", time.Hour)
+	export := func(query string, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/export"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := export("?format=csv", "")
+	if w.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("Expected CSV content type, got %q", w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %v (%v)", rows, err)
+	}
+	if strings.Join(rows[0], ",") != "key,value,expiresAt" ||
+		strings.Join(rows[1], ",") != "a,1," ||
+		rows[2][1] != "two,\n\"lines\"" || rows[2][2] == "" {
+		t.Errorf("Unexpected rows: %q", rows)
+	}
+
+	w = export("", "application/x-protobuf")
+	body := w.Body.Bytes()
+	keys := []string{}
+	for len(body) > 0 {
+		length, size := protowire.ConsumeVarint(body)
+		if size < 0 || uint64(len(body)-size) < length {
+			t.Fatal("Invalid length-delimited record")
+		}
+		var record raft.ExportRecord
+		if err := proto.Unmarshal(body[size:size+int(length)], &record); err != nil {
+			t.Fatal("Error decoding record:", err)
+		}
+		keys = append(keys, record.Key)
+		body = body[size+int(length):]
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("Expected protobuf records for a and b, got %v", keys)
+	}
+
+	if w := export("", "*/*"); w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON by default, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := export("?format=xml", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", w.Code)
+	}
+}
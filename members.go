@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// AddMemberRequest is a request body template for the add-member route
+type AddMemberRequest struct {
+	// Raft address ("host:port") of the new member
+	Address string `json:"address"`
+	// Whether to leave the member a learner once it has caught up, until it
+	// is promoted (see /admin/members/{address}/promote)
+	Learner bool `json:"learner"`
+}
+
+// Handler for adding a member to the cluster
+// @Summary Add a member to the cluster and bootstrap it from a snapshot
+// @Description Adds the node at the address as a learner, which is sent
+// @Description entries but does not count toward the majority. The leader
+// @Description sends it a snapshot of the database, then the entries after the
+// @Description snapshot, and promotes it to a voter once it has caught up (see
+// @Description /admin/members/bootstrap). The membership including it is then
+// @Description committed through the log, so every member learns of it. With
+// @Description learner set, the member is left a learner once it has caught
+// @Description up, until it is promoted. Returns as soon as the member is
+// @Description added.
+// @ID admin-add-member
+// @Accept application/json
+// @Produce application/json
+// @Param member body AddMemberRequest true "New member"
+// @Success 202 {object} node.BootstrapStatus
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 409 {object} ErrorResponse "Already a member"
+// @Router /admin/members [post]
+func (ctl *Controller) handleAddMember(c *gin.Context) {
+	var body AddMemberRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if body.Address == "" {
+		invalidRequest(c, errors.New("Missing address"))
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+	add := ctl.Node.AddMember
+	if body.Learner {
+		add = ctl.Node.AddLearner
+	}
+	status, err := add(body.Address)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, status)
+}
+
+// RemoveMemberResponse is a response body template for the remove-member route
+type RemoveMemberResponse struct {
+	// Raft address of every voting member, once the member is removed
+	Members []string `json:"members"`
+}
+
+// Handler for removing a member from the cluster
+// @Summary Remove a member from the cluster
+// @Description Commits the membership without the member through the log,
+// @Description and returns once it is committed. Every member then stops
+// @Description sending requests to it and refuses its vote requests, so it
+// @Description should be shut down. A member still being bootstrapped is
+// @Description removed at once. The leader can't remove itself: transfer
+// @Description leadership first.
+// @ID admin-remove-member
+// @Accept */*
+// @Produce application/json
+// @Param address path string true "Raft address of the member"
+// @Success 200 {object} RemoveMemberResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 404 {object} ErrorResponse "Not a member"
+// @Failure 409 {object} ErrorResponse "The member is the leader"
+// @Router /admin/members/{address} [delete]
+func (ctl *Controller) handleRemoveMember(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+	members, err := ctl.Node.RemoveServer(c.Request.Context(), c.Param("address"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, RemoveMemberResponse{Members: members})
+}
+
+// PromoteMemberResponse is a response body template for the promote-member
+// route
+type PromoteMemberResponse struct {
+	// Raft address of every voting member, once the learner is promoted
+	Members []string `json:"members"`
+}
+
+// Handler for promoting a learner
+// @Summary Promote a learner to a voting member
+// @Description Commits the membership including the learner (added with
+// @Description learner set, see POST /admin/members) through the log, and
+// @Description returns once it is committed. The learner must have caught up
+// @Description with the leader (phase caught-up in /admin/members/bootstrap).
+// @ID admin-promote-member
+// @Accept */*
+// @Produce application/json
+// @Param address path string true "Raft address of the learner"
+// @Success 200 {object} PromoteMemberResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 404 {object} ErrorResponse "Not a member"
+// @Failure 409 {object} ErrorResponse "Not a learner, or not caught up"
+// @Router /admin/members/{address}/promote [post]
+func (ctl *Controller) handlePromoteMember(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+	members, err := ctl.Node.Promote(c.Request.Context(), c.Param("address"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, PromoteMemberResponse{Members: members})
+}
+
+// ChangeMembersRequest is a request body template for the change-members route
+type ChangeMembersRequest struct {
+	// Raft address of every voting member after the change
+	Members []string `json:"members"`
+}
+
+// ChangeMembersResponse is a response body template for the change-members
+// route
+type ChangeMembersResponse struct {
+	// Raft address of every voting member, once the change is committed
+	Members []string `json:"members"`
+}
+
+// Handler for changing several members at once
+// @Summary Change the members of the cluster to a new set
+// @Description Adds and removes any number of members at once, safely: the
+// @Description members being added are bootstrapped (see POST /admin/members),
+// @Description then a joint membership of the old and new members is committed
+// @Description through the log, in which commits and elections need a majority
+// @Description of each, and then the new membership. Returns once the new
+// @Description membership is committed. The leader must be one of the new
+// @Description members, and only one change can be in progress at a time.
+// @ID admin-change-members
+// @Accept application/json
+// @Produce application/json
+// @Param members body ChangeMembersRequest true "New members"
+// @Success 200 {object} ChangeMembersResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 409 {object} ErrorResponse "The leader is not a new member, or a change is in progress"
+// @Router /admin/members [put]
+func (ctl *Controller) handleChangeMembers(c *gin.Context) {
+	var body ChangeMembersRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+	members, err := ctl.Node.ChangeMembers(c.Request.Context(), body.Members)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ChangeMembersResponse{Members: members})
+}
+
+// BootstrapsResponse is a response body template for the bootstrap progress
+// route
+type BootstrapsResponse struct {
+	Members []node.BootstrapStatus `json:"members"`
+	// Address of the last member listed, to pass as `after` for the next
+	// page (absent on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// Handler for bootstrap progress
+// @Summary Return the progress of bootstrapping members added to the cluster
+// @Description Reports each member added with POST or PUT /admin/members while
+// @Description this node was the leader: the phase it is in (snapshot,
+// @Description catching-up, caught-up, promoted, or failed), how much of the
+// @Description snapshot has been sent, and how far it has caught up. Members
+// @Description are sorted by address.
+// @ID admin-bootstraps
+// @Accept */*
+// @Produce application/json
+// @Param limit query int false "Most members to return (default all)"
+// @Param after query string false "Return the members after this address (next of the previous page)"
+// @Param fields query string false "Comma-separated fields to return of each member (default all)"
+// @Success 200 {object} BootstrapsResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/members/bootstrap [get]
+func (ctl *Controller) handleBootstraps(c *gin.Context) {
+	q, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	members := ctl.Node.Bootstraps()
+	start, end, next := q.page(len(members), func(i int) string { return members[i].Member })
+	respondList(c, q, "members", BootstrapsResponse{Members: members[start:end], Next: next})
+}
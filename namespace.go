@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrNoNamespacePolicy indicates a request for the policy of a namespace
+	// that has none
+	ErrNoNamespacePolicy = errors.New("Namespace has no policy")
+)
+
+// NamespaceResponse describes a namespace's policy, and the number of keys in
+// the namespace on this node
+type NamespaceResponse struct {
+	Name   string               `json:"name"`
+	Policy node.NamespacePolicy `json:"policy"`
+	Keys   int                  `json:"keys"`
+}
+
+// NamespacesResponse lists the namespaces that have a policy, by name
+type NamespacesResponse struct {
+	Namespaces []NamespaceResponse `json:"namespaces"`
+	// Name of the last namespace listed, to pass as `after` for the next
+	// page (absent on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// readAllowed returns true if the policy of key's namespace allows it to be
+// read, and responds with an error if not
+func (ctl *Controller) readAllowed(c *gin.Context, key string) bool {
+	if err := ctl.Node.CheckRead(key); err != nil {
+		respondError(c, err)
+		return false
+	}
+	return true
+}
+
+// namespaceResponse describes the namespace called name
+func (ctl *Controller) namespaceResponse(name string, policy node.NamespacePolicy) NamespaceResponse {
+	return NamespaceResponse{Name: name, Policy: policy, Keys: ctl.Node.NamespaceKeys(name)}
+}
+
+// Handler for listing namespace policies
+// @Summary Return the namespaces that have a policy, with their policies
+// @Description Policies are as of the last entry applied by this node, sorted
+// @Description by namespace.
+// @ID admin-namespaces
+// @Accept */*
+// @Produce application/json
+// @Param limit query int false "Most namespaces to return (default all)"
+// @Param after query string false "Return the namespaces after this name (next of the previous page)"
+// @Param fields query string false "Comma-separated fields to return of each namespace (default all)"
+// @Success 200 {object} NamespacesResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/namespaces [get]
+func (ctl *Controller) handleNamespaces(c *gin.Context) {
+	q, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	policies := ctl.Node.NamespacePolicies()
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	start, end, next := q.page(len(names), func(i int) string { return names[i] })
+	// the number of keys is only counted for the namespaces on the page
+	response := NamespacesResponse{Namespaces: []NamespaceResponse{}, Next: next}
+	for _, name := range names[start:end] {
+		response.Namespaces = append(response.Namespaces, ctl.namespaceResponse(name, policies[name]))
+	}
+	respondList(c, q, "namespaces", response)
+}
+
+// Handler for reading a namespace policy
+// @Summary Return the policy of a namespace, and its number of keys
+// @ID admin-namespace
+// @Accept */*
+// @Produce application/json
+// @Param name path string true "Namespace (the part of its keys before the first ':')"
+// @Success 200 {object} NamespaceResponse
+// @Failure 404 {object} ErrorResponse "Namespace has no policy"
+// @Router /admin/namespaces/{name} [get]
+func (ctl *Controller) handleNamespace(c *gin.Context) {
+	name := c.Param("name")
+	policy, ok := ctl.Node.NamespacePolicy(name)
+	if !ok {
+		respondError(c, ErrNoNamespacePolicy)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.namespaceResponse(name, policy))
+}
+
+// Handler for setting a namespace policy
+// @Summary Set the access, limits, and default time to live of a namespace
+// @Description The policy is committed to the log, so it applies on every
+// @Description member, and survives restarts and changes of leader. It applies
+// @Description to writes made after it is set, and is not applied to keys
+// @Description already in the namespace.
+// @ID admin-namespace-set
+// @Accept application/json
+// @Produce application/json
+// @Param name path string true "Namespace (the part of its keys before the first ':')"
+// @Param policy body node.NamespacePolicy true "Policy"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} NamespaceResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/namespaces/{name} [put]
+func (ctl *Controller) handleSetNamespace(c *gin.Context) {
+	var policy node.NamespacePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	if err := ctl.Node.SetNamespacePolicy(ctx, name, policy); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.namespaceResponse(name, policy))
+}
+
+// Handler for removing a namespace policy
+// @Summary Remove the policy of a namespace, lifting its restrictions
+// @ID admin-namespace-delete
+// @Accept */*
+// @Produce application/json
+// @Param name path string true "Namespace (the part of its keys before the first ':')"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} DeleteResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "Namespace has no policy"
+// @Router /admin/namespaces/{name} [delete]
+func (ctl *Controller) handleDeleteNamespace(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	existed, err := ctl.Node.DeleteNamespacePolicy(ctx, c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !existed {
+		respondError(c, ErrNoNamespacePolicy)
+		return
+	}
+	c.JSON(http.StatusOK, DeleteResponse{Status: "Ok"})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// MoveRequest is a request body template for renaming or copying a key
+type MoveRequest struct {
+	// Key to read
+	From string `json:"from"`
+	// Key to write
+	To string `json:"to"`
+	// Overwrite the key written if it has a value
+	Replace bool `json:"replace"`
+}
+
+// Handler for renaming keys
+// @Summary Move the value of a key, and its expiry time, to another key
+// @Description Reading the key and writing the other are one step, so no
+// @Description write can come between them. The key written takes the expiry
+// @Description time of the key read (or none). The outcome is only known once
+// @Description the entry is applied, so the response waits for that even with
+// @Description the leader write concern.
+// @ID db-rename
+// @Accept application/json
+// @Produce application/json
+// @Param body body MoveRequest true "Keys"
+// @Param concern query string false "Write concern: majority (default) or apply"
+// @Success 200 {object} WriteResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "The key to read has no value"
+// @Failure 409 {object} ErrorResponse "The key to write has a value, and replace is false"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
+// @Router /db/_rename [post]
+func (ctl *Controller) handleRename(c *gin.Context) {
+	ctl.handleMove(c, ctl.Node.Rename)
+}
+
+// Handler for copying keys
+// @Summary Copy the value of a key, and its expiry time, to another key
+// @Description Reading the key and writing the other are one step, so no
+// @Description write can come between them. The key written takes the expiry
+// @Description time of the key read (or none). The outcome is only known once
+// @Description the entry is applied, so the response waits for that even with
+// @Description the leader write concern.
+// @ID db-copy
+// @Accept application/json
+// @Produce application/json
+// @Param body body MoveRequest true "Keys"
+// @Param concern query string false "Write concern: majority (default) or apply"
+// @Success 200 {object} WriteResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "The key to read has no value"
+// @Failure 409 {object} ErrorResponse "The key to write has a value, and replace is false"
+// @Failure 503 {object} ErrorResponse "Too many pending writes, or draining"
+// @Header 503 {string} Retry-After "Seconds to wait before retrying"
+// @Router /db/_copy [post]
+func (ctl *Controller) handleCopy(c *gin.Context) {
+	ctl.handleMove(c, ctl.Node.Copy)
+}
+
+// handleMove serves a rename or copy request with move (Node.Rename or
+// Node.Copy)
+func (ctl *Controller) handleMove(
+	c *gin.Context,
+	move func(ctx context.Context, from string, to string, replace bool) error) {
+
+	var body MoveRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if body.From == "" || body.To == "" {
+		respondError(c, node.ErrInvalidRename)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := move(ctx, body.From, body.To, body.Replace); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, WriteResponse{Status: "Ok"})
+}
@@ -0,0 +1,146 @@
+// Package retry defines retry policies: how many times an operation is
+// attempted, how long to wait between attempts, and which errors are worth
+// another attempt. The same Policy is used by the server (for rounds of
+// append requests and reconnecting to peers), by the Go client, and by
+// leifctl, so that each is configured the same way.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMultiplier is the factor by which the delay grows after each attempt,
+// if a Policy does not set one
+const DefaultMultiplier = 2
+
+// ErrInvalidPolicy indicates a policy that can't be parsed (see Parse)
+var ErrInvalidPolicy = errors.New("Invalid retry policy")
+
+// A Policy decides whether and when a failed operation is attempted again. The
+// zero Policy makes a single attempt
+type Policy struct {
+	// Total number of attempts, including the first (less than 1 means one)
+	MaxAttempts int
+	// Wait before the first retry, which grows by Multiplier for each one
+	// after, up to MaxDelay (0 means no limit)
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	// Fraction by which each wait is randomly lengthened or shortened (0 to
+	// 1), so that clients that failed together do not retry together
+	Jitter float64
+	// Retryable reports whether an error is worth another attempt (all errors
+	// are, if nil)
+	Retryable func(error) bool
+}
+
+// Once makes a single attempt
+var Once = Policy{MaxAttempts: 1}
+
+// Attempts returns the total number of attempts allowed
+func (p Policy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// ShouldRetry reports whether err is worth another attempt
+func (p Policy) ShouldRetry(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Delay returns the wait before retry number n (1 for the first retry)
+func (p Policy) Delay(n int) time.Duration {
+	if p.BaseDelay <= 0 || n < 1 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = DefaultMultiplier
+	}
+	d := float64(p.BaseDelay) * math.Pow(multiplier, float64(n-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// Do calls op (with the number of the attempt, starting from 1) until it
+// succeeds, fails with an error that is not retryable, or has been attempted
+// MaxAttempts times, and returns its last error. If ctx is done after a failed
+// attempt, ctx's error is returned instead
+func (p Policy) Do(ctx context.Context, op func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if attempt >= p.Attempts() || !p.ShouldRetry(err) {
+			return err
+		}
+		if d := p.Delay(attempt); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// Parse returns base with the settings in s replaced: a comma-separated list
+// of attempts=<n>, base=<duration>, max=<duration>, multiplier=<x>, and
+// jitter=<fraction>, e.g. "attempts=5,base=100ms,max=2s,jitter=0.2". The
+// Retryable classification is kept from base
+func Parse(s string, base Policy) (Policy, error) {
+	p := base
+	for _, setting := range strings.Split(s, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return base, fmt.Errorf("%w: expected name=value, got %q", ErrInvalidPolicy, setting)
+		}
+		name, value := parts[0], parts[1]
+		var err error
+		switch name {
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+		case "base":
+			p.BaseDelay, err = time.ParseDuration(value)
+		case "max":
+			p.MaxDelay, err = time.ParseDuration(value)
+		case "multiplier":
+			p.Multiplier, err = strconv.ParseFloat(value, 64)
+		case "jitter":
+			p.Jitter, err = strconv.ParseFloat(value, 64)
+			if err == nil && (p.Jitter < 0 || p.Jitter > 1) {
+				err = errors.New("must be from 0 to 1")
+			}
+		default:
+			return base, fmt.Errorf("%w: unknown setting %q", ErrInvalidPolicy, name)
+		}
+		if err != nil {
+			return base, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, name, err)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,109 @@
+// +build unit
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	cases := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+	}
+	for _, tc := range cases {
+		if d := p.Delay(tc.retry); d != tc.expected {
+			t.Errorf("Expected delay %s before retry %d, got %s", tc.expected, tc.retry, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("Expected jittered delay within 50%% of 100ms, got %s", d)
+		}
+	}
+	if d := (Policy{}).Delay(3); d != 0 {
+		t.Errorf("Expected no delay without a base delay, got %s", d)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errBusy := errors.New("busy")
+	errFatal := errors.New("fatal")
+	p := Policy{MaxAttempts: 3, Retryable: func(err error) bool { return err == errBusy }}
+
+	attempts := 0
+	err := p.Do(context.Background(), func(int) error {
+		attempts++
+		return errBusy
+	})
+	if err != errBusy || attempts != 3 {
+		t.Errorf("Expected 3 attempts ending in errBusy, got %d and %v", attempts, err)
+	}
+
+	attempts = 0
+	err = p.Do(context.Background(), func(int) error {
+		attempts++
+		return errFatal
+	})
+	if err != errFatal || attempts != 1 {
+		t.Errorf("Expected no retry of an error that is not retryable, got %d attempts", attempts)
+	}
+
+	err = p.Do(context.Background(), func(attempt int) error {
+		if attempt < 2 {
+			return errBusy
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected success on the second attempt, got %v", err)
+	}
+
+	attempts = 0
+	if err := (Policy{}).Do(context.Background(), func(int) error { attempts++; return errBusy }); err != errBusy || attempts != 1 {
+		t.Errorf("Expected the zero Policy to make one attempt, got %d", attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+	err = slow.Do(ctx, func(int) error {
+		go cancel()
+		return errBusy
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected waiting to stop when the context is cancelled, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	retryable := func(error) bool { return true }
+	base := Policy{MaxAttempts: 2, Jitter: 0.1, Retryable: retryable}
+	p, err := Parse("attempts=5, base=100ms,max=2s,multiplier=1.5", base)
+	if err != nil {
+		t.Fatal("Error parsing policy:", err)
+	}
+	if p.MaxAttempts != 5 || p.BaseDelay != 100*time.Millisecond || p.MaxDelay != 2*time.Second ||
+		p.Multiplier != 1.5 || p.Jitter != 0.1 || p.Retryable == nil {
+		t.Errorf("Unexpected policy: %+v", p)
+	}
+	if p, err := Parse("", base); err != nil || p.MaxAttempts != 2 {
+		t.Errorf("Expected an empty string to keep the base policy, got %+v, %v", p, err)
+	}
+	for _, s := range []string{"attempts", "attempts=x", "jitter=2", "delay=1s"} {
+		if _, err := Parse(s, base); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("Expected ErrInvalidPolicy for %q, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SAddRequest is a request body template for adding to a set
+type SAddRequest struct {
+	Members []string `json:"members"`
+}
+
+// SetResponse is a response body template for set updates
+type SetResponse struct {
+	Status string `json:"status"`
+}
+
+// SMembersResponse is a response body template for listing set members
+type SMembersResponse struct {
+	Members []string `json:"members"`
+}
+
+// SIsMemberResponse is a response body template for set membership checks
+type SIsMemberResponse struct {
+	IsMember bool `json:"isMember"`
+}
+
+// Handler for listing set members
+// @Summary Return the members of a set
+// @ID set-members
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Success 200 {object} SMembersResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /set/{key} [get]
+func (ctl *Controller) handleSMembers(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) || !ctl.readAllowed(c, c.Param("key")) {
+		return
+	}
+	ctl.hotKeys.read(c.Param("key"))
+	c.JSON(http.StatusOK, SMembersResponse{
+		Members: ctl.Node.Store.SMembers(c.Param("key"))})
+}
+
+// Handler for set membership checks
+// @Summary Return whether a value is a member of a set
+// @ID set-is-member
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param member path string true "Member"
+// @Success 200 {object} SIsMemberResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /set/{key}/{member} [get]
+func (ctl *Controller) handleSIsMember(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) || !ctl.readAllowed(c, c.Param("key")) {
+		return
+	}
+	ctl.hotKeys.read(c.Param("key"))
+	c.JSON(http.StatusOK, SIsMemberResponse{
+		IsMember: ctl.Node.Store.SIsMember(c.Param("key"), c.Param("member"))})
+}
+
+// Handler for adding to sets
+// @Summary Add members to a set
+// @ID set-add
+// @Accept application/json
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param body body SAddRequest true "Members"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} SetResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /set/{key} [put]
+func (ctl *Controller) handleSAdd(c *gin.Context) {
+	var body SAddRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.SAdd(ctx, c.Param("key"), body.Members); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SetResponse{Status: "Ok"})
+}
+
+// Handler for removing from sets
+// @Summary Remove members from a set
+// @ID set-remove
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param member query []string true "Members to remove" collectionFormat(multi)
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} SetResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Invalid write concern"
+// @Router /set/{key} [delete]
+func (ctl *Controller) handleSRem(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.SRem(ctx, c.Param("key"), c.QueryArray("member")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SetResponse{Status: "Ok"})
+}
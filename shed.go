@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/btmorr/leifdb/internal/metrics"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// Classes of low-priority requests, which are shed while the node has a
+// backlog (see node.Backlog)
+const (
+	// shedRead is a read served from this node's copy of the data
+	shedRead = "read"
+	// shedScan is a request that visits many keys
+	shedScan = "scan"
+)
+
+var shedCounter = metrics.NewCounterVec(
+	"leifdb_shed_requests_total",
+	"Low-priority requests rejected because the node had a backlog, by class",
+	"class")
+
+// shed returns middleware that rejects requests of a low-priority class with a
+// retryable 503 response (with a Retry-After header) while the node has a
+// backlog, leaving its capacity to writes and reads confirmed with the leader.
+// Requests with system priority are never shed
+func (ctl *Controller) shed(class string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if node.RequestPriority(c.Request.Context()) == node.PrioritySystem {
+			c.Next()
+			return
+		}
+		if err := ctl.Node.ShedLowPriority(); err != nil {
+			shedCounter.Inc(class)
+			respondError(c, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
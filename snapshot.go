@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/btmorr/leifdb/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for downloading a snapshot
+// @Summary Return a snapshot of the database as of the last applied entry
+// @Description The snapshot is in the same format as the snapshot files in the
+// @Description data directory. It is built for the request, not read from disk,
+// @Description and is sent no faster than the node's snapshot transfer limit.
+// @ID admin-snapshot
+// @Produce application/octet-stream
+// @Success 200 {string} string "Snapshot"
+// @Header 200 {integer} X-Leifdb-Last-Applied "Index of the last log entry reflected in the snapshot"
+// @Header 200 {integer} X-Leifdb-Last-Term "Term of that entry"
+// @Header 200 {integer} X-Leifdb-Checksum "CRC-32 (IEEE) of the snapshot"
+// @Header 200 {string} X-Leifdb-Sha256 "SHA-256 of the snapshot, hex encoded"
+// @Header 200 {integer} X-Leifdb-Applied-Hash "Applied hash of this node as of that entry (0 if not known)"
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 500 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /admin/snapshot [get]
+func (ctl *Controller) handleSnapshot(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+	snapshot, info, err := ctl.snapshots.Snapshot()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("X-Leifdb-Last-Applied", strconv.FormatInt(info.LastApplied, 10))
+	c.Header("X-Leifdb-Last-Term", strconv.FormatInt(info.LastTerm, 10))
+	c.Header("X-Leifdb-Checksum", strconv.FormatUint(uint64(info.Checksum), 10))
+	c.Header("X-Leifdb-Sha256", info.Sha256)
+	c.Header("X-Leifdb-Applied-Hash", strconv.FormatUint(info.AppliedHash, 10))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", strconv.Itoa(len(snapshot)))
+	c.Status(http.StatusOK)
+	w := ratelimit.NewWriter(c.Request.Context(), c.Writer, ctl.Node.SnapshotLimiter())
+	if _, err := io.Copy(w, bytes.NewReader(snapshot)); err != nil {
+		logger.Warn().Err(err).Msg("Snapshot download interrupted")
+	}
+}
+
+// Handler for snapshot status
+// @Summary Return the state of the snapshots this node keeps on disk
+// @ID admin-snapshot-status
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} mgmt.SnapshotStatus
+// @Router /admin/snapshot/status [get]
+func (ctl *Controller) handleSnapshotStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, ctl.snapshots.Status())
+}
+
+// Handler for triggering a snapshot
+// @Summary Take a snapshot to disk now, rather than waiting for the log to grow
+// @Description Returns once the snapshot has been requested. Poll
+// @Description /admin/snapshot/status to find when it has been taken.
+// @ID admin-snapshot-trigger
+// @Accept */*
+// @Produce application/json
+// @Success 202 {object} mgmt.SnapshotStatus
+// @Router /admin/snapshot [post]
+func (ctl *Controller) handleSnapshotTrigger(c *gin.Context) {
+	ctl.snapshots.Trigger()
+	c.JSON(http.StatusAccepted, ctl.snapshots.Status())
+}
+
+// Handler for compacting the log
+// @Summary Take a snapshot and compact the log now, e.g. before maintenance
+// @Description Takes a snapshot regardless of the size of the log, then
+// @Description discards the log entries it covers, keeping the most recent
+// @Description retain of them (by default, the number configured for automatic
+// @Description compaction). Returns once the compaction has been requested.
+// @Description Poll /admin/snapshot/status for lastCompaction to find when it
+// @Description is done.
+// @ID admin-compact
+// @Accept */*
+// @Produce application/json
+// @Param retain query int false "Number of entries covered by the snapshot to keep in the log"
+// @Success 202 {object} mgmt.SnapshotStatus
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/compact [post]
+func (ctl *Controller) handleCompact(c *gin.Context) {
+	retain := int64(-1)
+	if param := c.Query("retain"); param != "" {
+		parsed, err := strconv.ParseInt(param, 10, 64)
+		if err == nil && parsed < 0 {
+			err = errors.New("retain must not be negative")
+		}
+		if err != nil {
+			invalidRequest(c, err)
+			return
+		}
+		retain = parsed
+	}
+	ctl.snapshots.Compact(retain)
+	c.JSON(http.StatusAccepted, ctl.snapshots.Status())
+}
+
+// Handler for reseeding a node
+// @Summary Replace this node's state with a snapshot of the leader's database
+// @Description Downloads a snapshot from the leader, verifies its checksum,
+// @Description and installs it in place of the local database, log, and
+// @Description snapshots. The leader then sends every entry after the snapshot,
+// @Description and the node serves reads once it has caught up. Lifts a
+// @Description quarantine. Returns once the snapshot is installed.
+// @ID admin-reseed
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} mgmt.SnapshotStatus
+// @Failure 409 {object} ErrorResponse "This node is the leader"
+// @Failure 500 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "No leader is known"
+// @Router /admin/reseed [post]
+func (ctl *Controller) handleReseed(c *gin.Context) {
+	if err := ctl.snapshots.Reseed(c.Request.Context()); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ctl.snapshots.Status())
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrNoTopology indicates a request for the topology before a leader has
+	// published one
+	ErrNoTopology = errors.New("No topology has been published")
+)
+
+// Handler for the cluster topology
+// @Summary Return the membership of the cluster and its leader
+// @Description Returns the topology last published by a leader (with
+// @Description LEIFDB_PUBLISH_TOPOLOGY set) to the _leifdb/topology system key,
+// @Description as of the last entry applied by this node: the leader's Raft and
+// @Description client addresses, its term, and every member it knew of.
+// @ID http-topology
+// @Accept */*
+// @Produce application/json
+// @Success 200 {object} node.Topology
+// @Failure 404 {object} ErrorResponse "No topology has been published"
+// @Router /topology [get]
+func (ctl *Controller) handleTopology(c *gin.Context) {
+	topology, ok := ctl.Node.Topology()
+	if !ok {
+		respondError(c, ErrNoTopology)
+		return
+	}
+	c.JSON(http.StatusOK, topology)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/gin-gonic/gin"
+)
+
+// TrashResponse lists the values in the trash
+type TrashResponse struct {
+	Values []node.TrashedValue `json:"values"`
+	// Key of the last value listed, to pass as `after` for the next page
+	// (absent on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// Handler for reading a value in the trash
+// @Summary Return the value of a deleted key kept in the trash, and when it expires
+// @Description Deleted values are only kept if the node is configured with a
+// @Description restore window (LEIFDB_TRASH_TTL). The value is as of the last
+// @Description entry applied by this node.
+// @ID trash-read
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Success 200 {object} node.TrashedValue
+// @Failure 404 {object} ErrorResponse "Key has no value in the trash"
+// @Router /trash/{key} [get]
+func (ctl *Controller) handleGetTrashed(c *gin.Context) {
+	value, ok := ctl.Node.Trashed(c.Param("key"))
+	if !ok {
+		respondError(c, node.ErrNotInTrash)
+		return
+	}
+	c.JSON(http.StatusOK, value)
+}
+
+// Handler for restoring a deleted key
+// @Summary Restore the value of a deleted key from the trash
+// @Description Fails if the key has been written since it was deleted. The
+// @Description outcome is only known once the entry is applied, so the
+// @Description response waits for that even with the leader write concern.
+// @ID trash-restore
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param concern query string false "Write concern: majority (default) or apply"
+// @Success 200 {object} WriteResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 404 {object} ErrorResponse "Key has no value in the trash"
+// @Failure 409 {object} ErrorResponse "Key has been written since it was deleted"
+// @Router /trash/{key}/restore [post]
+func (ctl *Controller) handleRestore(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.RestoreKey(ctx, c.Param("key")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, WriteResponse{Status: "Ok"})
+}
+
+// Handler for listing the trash
+// @Summary Return every value in the trash, with its key and when it expires
+// @Description Values are as of the last entry applied by this node, sorted by
+// @Description key.
+// @ID admin-trash
+// @Accept */*
+// @Produce application/json
+// @Param limit query int false "Most values to return (default all)"
+// @Param after query string false "Return the values after this key (next of the previous page)"
+// @Param fields query string false "Comma-separated fields to return of each value (default all)"
+// @Success 200 {object} TrashResponse
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /admin/trash [get]
+func (ctl *Controller) handleTrash(c *gin.Context) {
+	q, ok := parseListQuery(c)
+	if !ok {
+		return
+	}
+	values := ctl.Node.Trash()
+	start, end, next := q.page(len(values), func(i int) string { return values[i].Key })
+	respondList(c, q, "values", TrashResponse{Values: values[start:end], Next: next})
+}
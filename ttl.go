@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TTLResponse is a response body template for the TTL read route
+type TTLResponse struct {
+	Exists  bool  `json:"exists"`
+	Expires bool  `json:"expires"`
+	TTLMs   int64 `json:"ttlMs"`
+}
+
+// ExistsResponse is a response body template for the touch and persist
+// routes, reporting whether the key existed
+type ExistsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+// Handler for reading the remaining time to live of a key
+// @Summary Return the remaining time before a key expires
+// @ID ttl-read
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Success 200 {object} TTLResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /ttl/{key} [get]
+func (ctl *Controller) handleTTL(c *gin.Context) {
+	if !ctl.readableOrRedirect(c) {
+		return
+	}
+
+	key := c.Param("key")
+	if !ctl.readAllowed(c, key) {
+		return
+	}
+	ctl.hotKeys.read(key)
+	var response TTLResponse
+	response.Exists = ctl.Node.Store.Exists(key)
+	if at, ok := ctl.Node.Store.Expiry(key); ok && response.Exists {
+		response.Expires = true
+		response.TTLMs = time.Until(time.Unix(0, at)).Milliseconds()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handler for setting the time to live of a key
+// @Summary Set a key to expire after a duration, without changing its value
+// @ID ttl-touch
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param ttl query string true "Time to live, such as 30s or 15m"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} ExistsResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /ttl/{key} [put]
+func (ctl *Controller) handleTouch(c *gin.Context) {
+	ttl, err := time.ParseDuration(c.Query("ttl"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	exists, err := ctl.Node.Touch(ctx, c.Param("key"), ttl)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ExistsResponse{Exists: exists})
+}
+
+// Handler for removing the time to live of a key
+// @Summary Remove the expiry time of a key, so that it no longer expires
+// @ID ttl-persist
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} ExistsResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Invalid write concern"
+// @Router /ttl/{key} [delete]
+func (ctl *Controller) handlePersist(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	exists, err := ctl.Node.Persist(ctx, c.Param("key"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ExistsResponse{Exists: exists})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btmorr/leifdb/internal/logging"
+	"github.com/btmorr/leifdb/internal/node"
+	"github.com/btmorr/leifdb/internal/raft"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// recentChanges is the number of database changes kept for watches
+	recentChanges = 10000
+	// defaultWatchWait and maxWatchWait bound how long a watch request waits
+	// for a change before returning an empty response
+	defaultWatchWait = 30 * time.Second
+	maxWatchWait     = 5 * time.Minute
+)
+
+// watchLogger logs watches
+var watchLogger = logging.For(logging.Watch)
+
+var (
+	// ErrWatchCompacted indicates that a watch asked for changes that are no
+	// longer kept by the node, so some may have been missed
+	ErrWatchCompacted = errors.New("Changes since the requested revision are no longer available")
+)
+
+// Change types
+const (
+	ChangePut    = "put"
+	ChangeDelete = "delete"
+)
+
+// A Change is a write to a key in the database. Revision is the index of the
+// log entry that made the change, which is the same on every member of the
+// cluster, so it can be used to resume watching on any member. Time is when
+// the leader appended the entry (if it was recorded)
+type Change struct {
+	Revision int64      `json:"revision"`
+	Type     string     `json:"type"`
+	Key      string     `json:"key"`
+	Value    string     `json:"value,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+}
+
+// A changeFeed keeps the most recent changes to the database, and wakes up
+// watches waiting for new ones
+type changeFeed struct {
+	sync.Mutex
+	changes []Change
+	// changes after revision since are all kept
+	since int64
+	// revision of the last entry applied
+	last   int64
+	size   int
+	notify chan struct{}
+}
+
+// newChangeFeed returns a feed for the changes made by entries after index
+// since (the last entry applied when the feed is created)
+func newChangeFeed(size int, since int64) *changeFeed {
+	return &changeFeed{
+		since:  since,
+		last:   since,
+		size:   size,
+		notify: make(chan struct{})}
+}
+
+// hook returns an apply hook that records the changes made by each entry
+func (f *changeFeed) hook(n *node.Node) node.ApplyHook {
+	return func(index int64, entry *raft.LogRecord, result error) {
+		changes := []Change{}
+		switch entry.Action {
+		case raft.LogRecord_SET:
+			changes = append(changes, Change{Type: ChangePut, Key: entry.Key, Value: entry.Value})
+		case raft.LogRecord_SET_CHUNKED:
+			changes = append(changes, Change{Type: ChangePut, Key: entry.Key, Value: n.Store.Get(entry.Key)})
+		case raft.LogRecord_DEL:
+			changes = append(changes, Change{Type: ChangeDelete, Key: entry.Key})
+		case raft.LogRecord_EXPIRE:
+			for _, key := range entry.Members {
+				if !n.Store.Exists(key) {
+					changes = append(changes, Change{Type: ChangeDelete, Key: key})
+				}
+			}
+		case raft.LogRecord_BATCH:
+			applied := n.BatchApplied(index)
+			for i, op := range entry.Ops {
+				if i < len(applied) && applied[i] {
+					changes = append(changes, opChange(op))
+				}
+			}
+		case raft.LogRecord_TXN:
+			ops := entry.ElseOps
+			if applied := n.BatchApplied(index); len(applied) > 0 && applied[0] {
+				ops = entry.Ops
+			}
+			for _, op := range ops {
+				changes = append(changes, opChange(op))
+			}
+		case raft.LogRecord_LOCK:
+			if applied := n.BatchApplied(index); len(applied) > 0 && applied[0] {
+				changes = append(changes, Change{Type: ChangePut, Key: entry.Key, Value: n.Store.Get(entry.Key)})
+			}
+		case raft.LogRecord_UNLOCK:
+			if applied := n.BatchApplied(index); len(applied) > 0 && applied[0] {
+				changes = append(changes, Change{Type: ChangeDelete, Key: entry.Key})
+			}
+		case raft.LogRecord_RESTORE:
+			if applied := n.BatchApplied(index); len(applied) > 1 && applied[1] {
+				changes = append(changes, Change{Type: ChangePut, Key: entry.Key, Value: n.Store.Get(entry.Key)})
+			}
+		case raft.LogRecord_RENAME, raft.LogRecord_COPY:
+			applied := n.BatchApplied(index)
+			if len(applied) < 2 || !applied[1] || entry.Key == entry.Value {
+				break
+			}
+			if entry.Action == raft.LogRecord_RENAME {
+				changes = append(changes, Change{Type: ChangeDelete, Key: entry.Key})
+			}
+			changes = append(changes, Change{Type: ChangePut, Key: entry.Value, Value: n.Store.Get(entry.Value)})
+		}
+		var at *time.Time
+		if entry.Timestamp != 0 {
+			t := time.Unix(0, entry.Timestamp)
+			at = &t
+		}
+		for i := range changes {
+			changes[i].Revision = index
+			changes[i].Time = at
+		}
+		f.add(index, changes)
+	}
+}
+
+// opChange returns the change made by an applied operation of a BATCH or TXN
+func opChange(op *raft.LogRecord) Change {
+	if op.Action == raft.LogRecord_DEL {
+		return Change{Type: ChangeDelete, Key: op.Key}
+	}
+	return Change{Type: ChangePut, Key: op.Key, Value: op.Value}
+}
+
+// add records the changes made by the entry at index, discarding the oldest
+// changes if more than size are kept
+func (f *changeFeed) add(index int64, changes []Change) {
+	f.Lock()
+	defer f.Unlock()
+	f.last = index
+	f.changes = append(f.changes, changes...)
+	for len(f.changes) > f.size {
+		// discard every change from the oldest revision together, so that
+		// none is returned without the others made by the same entry
+		f.since = f.changes[0].Revision
+		i := 0
+		for i < len(f.changes) && f.changes[i].Revision == f.since {
+			i++
+		}
+		f.changes = f.changes[i:]
+	}
+	if len(changes) > 0 {
+		close(f.notify)
+		f.notify = make(chan struct{})
+	}
+}
+
+// wait returns the changes to keys starting with prefix after revision after,
+// waiting for one to be made if there are none yet, along with the revision up
+// to which changes have been returned. If ctx is done first, no changes are
+// returned
+func (f *changeFeed) wait(ctx context.Context, prefix string, after int64) ([]Change, int64, error) {
+	for {
+		f.Lock()
+		if after < f.since {
+			f.Unlock()
+			return nil, 0, ErrWatchCompacted
+		}
+		changes := []Change{}
+		for _, change := range f.changes {
+			if change.Revision > after && strings.HasPrefix(change.Key, prefix) {
+				changes = append(changes, change)
+			}
+		}
+		last, notify := f.last, f.notify
+		f.Unlock()
+		if len(changes) > 0 {
+			return changes, last, nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return changes, last, nil
+		}
+	}
+}
+
+// WatchResponse is a response body template for the watch route
+type WatchResponse struct {
+	Changes []Change `json:"changes"`
+	// Changes up to this revision have been returned, so the next request
+	// should be for changes after it
+	Revision int64 `json:"revision"`
+}
+
+// Handler for watching changes to keys
+// @Summary Wait for changes to keys, starting after a revision
+// @Description Returns changes to keys with the prefix made after revision
+// @Description `after`, or if there are none yet, waits for one. Changes to
+// @Description sets, sorted sets, and TTLs are not included.
+// @ID db-watch
+// @Produce application/json
+// @Param prefix query string false "Only return changes to keys with this prefix"
+// @Param after query int false "Only return changes made after this revision (default -1, all that are kept)"
+// @Param wait query string false "How long to wait for a change, e.g. 10s (default 30s)"
+// @Success 200 {object} WatchResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 410 {object} ErrorResponse "Changes after the revision are no longer kept"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /watch [get]
+func (ctl *Controller) handleWatch(c *gin.Context) {
+	after := int64(-1)
+	if s := c.Query("after"); s != "" {
+		var err error
+		if after, err = strconv.ParseInt(s, 10, 64); err != nil {
+			invalidRequest(c, err)
+			return
+		}
+	}
+	wait := defaultWatchWait
+	if s := c.Query("wait"); s != "" {
+		var err error
+		if wait, err = time.ParseDuration(s); err != nil {
+			invalidRequest(c, err)
+			return
+		}
+		if wait > maxWatchWait {
+			wait = maxWatchWait
+		}
+	}
+
+	if ctl.Node.IsWitness() {
+		if ctl.Node.RedirectLeader() == "" {
+			respondError(c, node.ErrWitnessRead)
+			return
+		}
+		redirectToLeader(c, ctl.Node.RedirectLeader(), c.Request.URL.RequestURI())
+		return
+	}
+	if ctl.Node.Quarantined() {
+		respondError(c, node.ErrQuarantined)
+		return
+	}
+	if !ctl.Node.CaughtUp() {
+		respondError(c, node.ErrNotCaughtUp)
+		return
+	}
+
+	watchLogger.Debug().
+		Str("prefix", c.Query("prefix")).
+		Int64("after", after).
+		Dur("wait", wait).
+		Msg("Watch started")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+	defer cancel()
+	changes, revision, err := ctl.changes.wait(ctx, c.Query("prefix"), after)
+	if err != nil {
+		watchLogger.Debug().Err(err).Int64("after", after).Msg("Watch failed")
+		respondError(c, err)
+		return
+	}
+	watchLogger.Debug().
+		Int("changes", len(changes)).
+		Int64("revision", revision).
+		Msg("Watch returned")
+	c.JSON(http.StatusOK, WatchResponse{Changes: changes, Revision: revision})
+}
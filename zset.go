@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	db "github.com/btmorr/leifdb/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// ZAddRequest is a request body template for adding to a sorted set
+type ZAddRequest struct {
+	Members []db.ScoredMember `json:"members"`
+}
+
+// ZSetResponse is a response body template for sorted set updates
+type ZSetResponse struct {
+	Status string `json:"status"`
+}
+
+// ZRangeResponse is a response body template for sorted set range queries
+type ZRangeResponse struct {
+	Members []db.ScoredMember `json:"members"`
+}
+
+// parseScore parses a score bound from a query parameter, which may be "-inf"
+// or "+inf", using the default if the parameter is not present. Surrounding
+// spaces are ignored, since an unescaped "+" in a query decodes to a space
+func parseScore(c *gin.Context, name string, def float64) (float64, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return def, nil
+	}
+	return strconv.ParseFloat(strings.TrimSpace(value), 64)
+}
+
+// Handler for sorted set range queries
+// @Summary Return members of a sorted set with scores in a range
+// @ID zset-range
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param min query string false "Minimum score, inclusive (default -inf)"
+// @Param max query string false "Maximum score, inclusive (default +inf)"
+// @Param limit query int false "Maximum number of members to return"
+// @Success 200 {object} ZRangeResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader (when this node is a witness)"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Failure 503 {object} ErrorResponse "Error message"
+// @Router /zset/{key} [get]
+func (ctl *Controller) handleZRange(c *gin.Context) {
+	min, err := parseScore(c, "min", math.Inf(-1))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	max, err := parseScore(c, "max", math.Inf(1))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.readableOrRedirect(c) || !ctl.readAllowed(c, c.Param("key")) {
+		return
+	}
+
+	members := ctl.Node.Store.ZRangeByScore(c.Param("key"), min, max, limit)
+	ctl.hotKeys.read(c.Param("key"))
+	c.JSON(http.StatusOK, ZRangeResponse{Members: members})
+}
+
+// Handler for adding to sorted sets
+// @Summary Add members to a sorted set, or update their scores
+// @ID zset-add
+// @Accept application/json
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param body body ZAddRequest true "Members and scores"
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} ZSetResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Error message"
+// @Router /zset/{key} [put]
+func (ctl *Controller) handleZAdd(c *gin.Context) {
+	var body ZAddRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if err := db.ValidateScores(body.Members); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.ZAdd(ctx, c.Param("key"), body.Members); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ZSetResponse{Status: "Ok"})
+}
+
+// Handler for removing from sorted sets
+// @Summary Remove members from a sorted set
+// @ID zset-remove
+// @Accept */*
+// @Produce application/json
+// @Param key path string true "Key"
+// @Param member query []string true "Members to remove" collectionFormat(multi)
+// @Param concern query string false "Write concern: leader, majority (default), or apply"
+// @Success 200 {object} ZSetResponse
+// @Failure 307 {object} ErrorResponse "Temporary Redirect"
+// @Header 307 {string} Location "Redirect address of the current leader"
+// @Failure 400 {object} ErrorResponse "Invalid write concern"
+// @Router /zset/{key} [delete]
+func (ctl *Controller) handleZRem(c *gin.Context) {
+	if !ctl.leaderOrRedirect(c) {
+		return
+	}
+
+	ctx, ok := ctl.writeContext(c)
+	if !ok {
+		return
+	}
+	if err := ctl.Node.ZRem(ctx, c.Param("key"), c.QueryArray("member")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ZSetResponse{Status: "Ok"})
+}